@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/haricheung/agentic-shell/internal/tasklog"
+)
+
+// logABRun writes one finished run tagged tag to reg, with the given rework and token use.
+func logABRun(reg *tasklog.Registry, taskID, tag, status string, corrections, replans, tokens int) {
+	tl := reg.Open(taskID, "summarise the release notes", tag)
+	tl.LLMCall("executor", "sys", "user", "response", tokens, 0, 10, 0)
+	for i := range corrections {
+		tl.Correction("s1", "missing the version", "read the changelog", i+1)
+	}
+	for i := range replans {
+		tl.Replan("criteria unmet", i+1)
+	}
+	reg.Close(taskID, status)
+}
+
+func TestAttributeABRuns_MetricsGoToTheTaggedVariant(t *testing.T) {
+	// Attributes each run's status, tokens, corrections, and replans to the variant whose tag it carries
+	dir := filepath.Join(t.TempDir(), "tasks")
+	reg := tasklog.NewRegistry(dir)
+	// B is logged first so the attribution cannot depend on log order.
+	logABRun(reg, "notes_b", "ab-1234-b", "accepted", 0, 0, 300)
+	logABRun(reg, "notes_a", "ab-1234-a", "abandoned", 2, 1, 150)
+	logABRun(reg, "unrelated", "research", "accepted", 5, 5, 999)
+
+	variants := []abVariant{{Name: "A", Tag: "ab-1234-a"}, {Name: "B", Tag: "ab-1234-b"}}
+	if err := attributeABRuns(dir, variants); err != nil {
+		t.Fatalf("attributeABRuns: %v", err)
+	}
+	a, b := variants[0].Run, variants[1].Run
+	if a == nil || b == nil {
+		t.Fatalf("expected both variants attributed, got A=%v B=%v", a, b)
+	}
+	if a.TaskID != "notes_a" || a.Status != "abandoned" || a.Corrections != 2 || a.Replans != 1 || a.TotalTokens != 150 {
+		t.Errorf("variant A = %+v", *a)
+	}
+	if b.TaskID != "notes_b" || b.Status != "accepted" || b.Corrections != 0 || b.Replans != 0 || b.TotalTokens != 300 {
+		t.Errorf("variant B = %+v", *b)
+	}
+}
+
+func TestAttributeABRuns_MissingRunStaysNil(t *testing.T) {
+	// Leaves Run nil for a variant with no tagged run
+	dir := filepath.Join(t.TempDir(), "tasks")
+	reg := tasklog.NewRegistry(dir)
+	logABRun(reg, "notes_a", "ab-1234-a", "accepted", 0, 0, 100)
+	variants := []abVariant{{Name: "A", Tag: "ab-1234-a"}, {Name: "B", Tag: "ab-1234-b"}}
+	if err := attributeABRuns(dir, variants); err != nil {
+		t.Fatalf("attributeABRuns: %v", err)
+	}
+	if variants[0].Run == nil || variants[1].Run != nil {
+		t.Errorf("expected only A attributed, got A=%v B=%v", variants[0].Run, variants[1].Run)
+	}
+}
+
+func TestABWinner(t *testing.T) {
+	// Prefers the accepted run, then fewer corrections + replans, then fewer total tokens
+	v := func(name, status string, corrections, replans, tokens int) abVariant {
+		return abVariant{Name: name, Run: &tasklog.TaskSummary{Status: status, Corrections: corrections, Replans: replans, TotalTokens: tokens}}
+	}
+	cases := []struct {
+		name string
+		a, b abVariant
+		want string
+	}{
+		{"accepted beats abandoned despite cost", v("A", "abandoned", 0, 0, 10), v("B", "accepted", 3, 1, 9000), "B"},
+		{"less rework wins", v("A", "accepted", 1, 0, 900), v("B", "accepted", 1, 1, 100), "A"},
+		{"fewer tokens break a rework tie", v("A", "accepted", 1, 0, 900), v("B", "accepted", 0, 1, 400), "B"},
+		{"all equal is a tie", v("A", "accepted", 0, 0, 500), v("B", "accepted", 0, 0, 500), "tie"},
+		{"missing run has no winner", v("A", "accepted", 0, 0, 500), abVariant{Name: "B"}, ""},
+	}
+	for _, c := range cases {
+		if got := abWinner(c.a, c.b); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestValidateABFlags(t *testing.T) {
+	// Returns an error naming the accepted roles, for a bad prompt file, or without a task
+	dir := t.TempDir()
+	a, b, blank := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"), filepath.Join(dir, "blank.txt")
+	os.WriteFile(a, []byte("prompt A"), 0o644)
+	os.WriteFile(b, []byte("prompt B"), 0o644)
+	os.WriteFile(blank, []byte("\n"), 0o644)
+
+	if err := validateABFlags("executor", a, b, "count Go files"); err != nil {
+		t.Errorf("valid invocation: %v", err)
+	}
+	if err := validateABFlags("auditor", a, b, "count Go files"); err == nil || !strings.Contains(err.Error(), "executor") {
+		t.Errorf("expected an error listing the roles, got %v", err)
+	}
+	for name, paths := range map[string][2]string{
+		"missing -b":   {a, ""},
+		"unreadable":   {a, filepath.Join(dir, "nope.txt")},
+		"blank prompt": {blank, b},
+	} {
+		if err := validateABFlags("executor", paths[0], paths[1], "count Go files"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if err := validateABFlags("executor", a, b, ""); err == nil {
+		t.Error("expected an error without a task")
+	}
+}
+
+func TestPrintABReport_ShowsEachVariantsMetrics(t *testing.T) {
+	// The report puts each variant's metrics in its own column and names the winner
+	cmp := abComparison{Role: "executor", Task: "summarise the release notes", Variants: [2]abVariant{
+		{Name: "A", PromptFile: "/p/a.txt", Tag: "ab-1-a", Run: &tasklog.TaskSummary{Status: "abandoned", Corrections: 4, Replans: 2, TotalTokens: 1200}},
+		{Name: "B", PromptFile: "/p/b.txt", Tag: "ab-1-b", Run: &tasklog.TaskSummary{Status: "accepted", Corrections: 1, TotalTokens: 800}},
+	}}
+	var buf bytes.Buffer
+	printABReport(&buf, cmp)
+	out := buf.String()
+	for _, want := range []string{"a.txt", "b.txt", "abandoned", "accepted", "Result: B is better", "ab-1-a", "ab-1-b"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report missing %q:\n%s", want, out)
+		}
+	}
+	found := false
+	for _, line := range strings.Split(out, "\n") {
+		if f := strings.Fields(line); len(f) == 3 && f[0] == "corrections" {
+			found = true
+			if f[1] != "4" || f[2] != "1" {
+				t.Errorf("corrections row = %q, want A=4 B=1", line)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("report has no corrections row:\n%s", out)
+	}
+}
+
+func TestPrintABReport_MissingRun(t *testing.T) {
+	// Shows "—" for a variant with no logged run, and each variant's tag for /find
+	cmp := abComparison{Role: "planner", Task: "plan a trip", Variants: [2]abVariant{
+		{Name: "A", PromptFile: "/p/a.txt", Tag: "ab-2-a", Run: &tasklog.TaskSummary{Status: "accepted"}},
+		{Name: "B", PromptFile: "/p/b.txt", Tag: "ab-2-b"},
+	}}
+	var buf bytes.Buffer
+	printABReport(&buf, cmp)
+	out := buf.String()
+	if !strings.Contains(out, "—") || !strings.Contains(out, "no run logged under tag ab-2-b") {
+		t.Errorf("expected B's missing run to be reported:\n%s", out)
+	}
+	if strings.Contains(out, "Result:") {
+		t.Errorf("expected no winner without both runs:\n%s", out)
+	}
+}
+
+func TestCopyMemory_CopiesTheStore(t *testing.T) {
+	// Copies every file of src into the returned directory
+	src := filepath.Join(t.TempDir(), "memory.leveldb")
+	if err := os.MkdirAll(src, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "000001.log"), []byte("entries"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := copyMemory(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(dst))
+	if got, err := os.ReadFile(filepath.Join(dst, "000001.log")); err != nil || string(got) != "entries" {
+		t.Errorf("copied log = %q, %v; want the source contents", got, err)
+	}
+	if dst == src {
+		t.Error("copyMemory returned the source path")
+	}
+}
+
+func TestCopyMemory_MissingSourceGivesFreshPath(t *testing.T) {
+	// Returns a not-yet-existing path under a new temporary directory when src does not exist
+	dst, err := copyMemory(filepath.Join(t.TempDir(), "absent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(dst))
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("expected %s not to exist yet, stat err = %v", dst, err)
+	}
+	if _, err := os.Stat(filepath.Dir(dst)); err != nil {
+		t.Errorf("expected the temporary parent to exist: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/tasklog"
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+// cancelRig is a canceller wired to a real bus and task log, with the channels
+// a test inspects: what outputFn delivered and what the bus carried.
+type cancelRig struct {
+	c       *taskCanceller
+	b       *bus.Bus
+	dir     string
+	results chan types.FinalResult
+	onBus   <-chan types.Message
+}
+
+func newCancelRig(t *testing.T) *cancelRig {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "tasks")
+	r := &cancelRig{
+		b:       bus.New(),
+		dir:     dir,
+		results: make(chan types.FinalResult, 4),
+	}
+	r.onBus = r.b.Subscribe(types.MsgFinalResult)
+	r.c = newTaskCanceller(r.b, tasklog.NewRegistry(dir), func(fr types.FinalResult) { r.results <- fr })
+	r.c.logReg.Open("t1", "count Go files")
+	return r
+}
+
+// expectCancelled asserts one cancelled FinalResult for t1 with reason reached
+// outputFn and the bus, and the log closed as cancelled.
+func (r *cancelRig) expectCancelled(t *testing.T, reason string) {
+	t.Helper()
+	var fr types.FinalResult
+	select {
+	case fr = <-r.results:
+	case <-time.After(time.Second):
+		t.Fatalf("no FinalResult delivered (want reason %q)", reason)
+	}
+	if fr.TaskID != "t1" || fr.Directive != "cancelled" || fr.CancelReason != reason {
+		t.Errorf("FinalResult = %+v, want cancelled t1 with reason %q", fr, reason)
+	}
+	select {
+	case msg := <-r.onBus:
+		if got := msg.Payload.(types.FinalResult); got.CancelReason != reason || msg.From != types.RoleUser {
+			t.Errorf("bus FinalResult = %+v from %s", got, msg.From)
+		}
+	default:
+		t.Error("FinalResult was not published on the bus")
+	}
+	runs, err := tasklog.Query(r.dir, tasklog.QueryFilter{Status: "cancelled"})
+	if err != nil || len(runs) != 1 || runs[0].TaskID != "t1" {
+		t.Errorf("cancelled runs = %+v, %v", runs, err)
+	}
+}
+
+func TestTaskCanceller_UserAndSignalYieldCancelledResult(t *testing.T) {
+	// Ctrl+C and SIGTERM each deliver one cancelled FinalResult naming their reason
+	for _, reason := range []string{types.CancelUser, types.CancelSignal} {
+		t.Run(reason, func(t *testing.T) {
+			r := newCancelRig(t)
+			r.c.begin("t1", nil)
+			if !r.c.cancel(reason) {
+				t.Fatal("cancel should report a running task")
+			}
+			r.expectCancelled(t, reason)
+		})
+	}
+}
+
+func TestTaskCanceller_CancelledResultCarriesAssumptions(t *testing.T) {
+	// Publishes and delivers one FinalResult with the task's assumptions
+	r := newCancelRig(t)
+	r.c.begin("t1", []string{"project means the current working directory"})
+	r.c.cancel(types.CancelUser)
+	select {
+	case fr := <-r.results:
+		if len(fr.Assumptions) != 1 || fr.Assumptions[0] != "project means the current working directory" {
+			t.Errorf("Assumptions = %v, want R1's assumption", fr.Assumptions)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no FinalResult delivered")
+	}
+}
+
+func TestTaskCanceller_WallTimeWatchdog(t *testing.T) {
+	// A task running past the wall-time limit is cancelled with reason "walltime"
+	r := newCancelRig(t)
+	r.c.wallTime = 30 * time.Millisecond
+	ctx := t.Context()
+	go r.c.watch(ctx, r.b.NewTap(), 5*time.Millisecond)
+	r.c.begin("t1", nil)
+	r.expectCancelled(t, types.CancelWallTime)
+}
+
+func TestTaskCanceller_IdleWatchdog(t *testing.T) {
+	// A task whose bus stays quiet past the idle limit is cancelled with reason "idle"
+	r := newCancelRig(t)
+	r.c.idleTimeout = 30 * time.Millisecond
+	ctx := t.Context()
+	go r.c.watch(ctx, r.b.NewTap(), 5*time.Millisecond)
+	r.c.begin("t1", nil)
+	r.expectCancelled(t, types.CancelIdle)
+}
+
+func TestTaskCanceller_ExpiredChecksWallTimeThenIdle(t *testing.T) {
+	// expired reports walltime before idle, and nothing without a running task
+	c := &taskCanceller{wallTime: time.Minute, idleTimeout: 10 * time.Second}
+	start := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	if got := c.expired(start.Add(time.Hour)); got != "" {
+		t.Errorf("no running task: got %q", got)
+	}
+	c.taskID, c.started, c.lastActivity = "t1", start, start
+	if got := c.expired(start.Add(5 * time.Second)); got != "" {
+		t.Errorf("within limits: got %q", got)
+	}
+	if got := c.expired(start.Add(10 * time.Second)); got != types.CancelIdle {
+		t.Errorf("quiet for the idle limit: got %q", got)
+	}
+	c.lastActivity = start.Add(55 * time.Second)
+	if got := c.expired(start.Add(58 * time.Second)); got != "" {
+		t.Errorf("recent activity resets the idle clock: got %q", got)
+	}
+	if got := c.expired(start.Add(time.Minute)); got != types.CancelWallTime {
+		t.Errorf("past the wall time: got %q", got)
+	}
+}
+
+func TestTaskCanceller_NoRunningTaskPublishesNothing(t *testing.T) {
+	// cancel is a no-op without a running task, after end, and on a second call
+	r := newCancelRig(t)
+	if r.c.cancel(types.CancelUser) {
+		t.Error("cancel with no task should return false")
+	}
+	r.c.begin("t1", nil)
+	r.c.end("t1")
+	if r.c.cancel(types.CancelUser) {
+		t.Error("cancel after end should return false")
+	}
+	r.c.begin("t1", nil)
+	r.c.cancel(types.CancelSignal)
+	<-r.results
+	if r.c.cancel(types.CancelSignal) {
+		t.Error("second cancel should return false")
+	}
+	select {
+	case fr := <-r.results:
+		t.Errorf("unexpected second FinalResult %+v", fr)
+	default:
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/haricheung/agentic-shell/internal/roles/planner"
+	"github.com/haricheung/agentic-shell/internal/ui"
+)
+
+// gateWithAnswer returns a costGate whose prompt answers ans and records the questions.
+func gateWithAnswer(threshold int, ans string) (*costGate, *bytes.Buffer, *[]string) {
+	var asked []string
+	c := &toolConfirmer{}
+	c.setAsk(func(_ context.Context, q string) (string, error) {
+		asked = append(asked, q)
+		return ans, nil
+	})
+	out := &bytes.Buffer{}
+	return &costGate{threshold: threshold, confirmer: c, out: out}, out, &asked
+}
+
+func TestCostGate_BelowThresholdShowsEstimateWithoutAsking(t *testing.T) {
+	// An estimate under the threshold is printed and the plan proceeds unasked
+	g, out, asked := gateWithAnswer(10000, "n")
+	if !g.preview(t.Context(), "t1", planner.CostEstimate{Subtasks: 2, Tokens: 8000, Historical: true}) {
+		t.Fatal("expected the plan to proceed")
+	}
+	if len(*asked) != 0 || !strings.Contains(out.String(), "~8.0k tokens") {
+		t.Errorf("asked %v, printed %q", *asked, out.String())
+	}
+}
+
+func TestCostGate_AtThresholdAsks(t *testing.T) {
+	// At or over the threshold the user is asked; only yes proceeds
+	for ans, want := range map[string]bool{"y": true, "no": false, "": false} {
+		g, _, asked := gateWithAnswer(8000, ans)
+		got := g.preview(t.Context(), "t1", planner.CostEstimate{Subtasks: 2, Tokens: 8000, Historical: true})
+		if got != want || len(*asked) != 1 || !strings.Contains((*asked)[0], "may cost ~8.0k tokens") {
+			t.Errorf("answer %q: proceed = %v, asked %v", ans, got, *asked)
+		}
+	}
+}
+
+func TestCostGate_NoColorPrintsNoEscapeSequences(t *testing.T) {
+	// With NO_COLOR set the printed estimate contains no escape sequences
+	prev := ui.Active()
+	ui.SetTheme(ui.ThemeFor(func(k string) string {
+		return map[string]string{"TERM": "xterm-256color", "NO_COLOR": "1"}[k]
+	}, true))
+	t.Cleanup(func() { ui.SetTheme(prev) })
+
+	g, out, _ := gateWithAnswer(0, "")
+	g.preview(t.Context(), "t1", planner.CostEstimate{Subtasks: 2, Tokens: 8000, Historical: true})
+	if strings.Contains(out.String(), "\033") || !strings.Contains(out.String(), "Plan estimate:") {
+		t.Errorf("printed %q, want the estimate without escape sequences", out.String())
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/haricheung/agentic-shell/internal/tasklog"
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+// criteriaFixture is a replanned task: s1 belonged to the first plan, s2 and s3
+// to the final one, whose manifest is returned.
+func criteriaFixture(status string) (types.DispatchManifest, []tasklog.Event) {
+	yes, no := true, false
+	m := types.DispatchManifest{
+		TaskID:       "t1",
+		SubTaskIDs:   []string{"s2", "s3"},
+		TaskCriteria: []string{"the report lists every region"},
+	}
+	events := []tasklog.Event{
+		{Kind: tasklog.KindTaskBegin, TaskID: "t1"},
+		{Kind: tasklog.KindSubtaskBegin, SubtaskID: "s1", Intent: "old plan", Criteria: []string{"stale criterion"}},
+		{Kind: tasklog.KindCriterionVerdict, SubtaskID: "s1", Criterion: "stale criterion", Met: &no},
+		{Kind: tasklog.KindSubtaskBegin, SubtaskID: "s2", Intent: "fetch the sales data", Criteria: []string{"file exists", "has 12 rows"}},
+		{Kind: tasklog.KindCriterionVerdict, SubtaskID: "s2", Criterion: "file exists", Met: &yes, Attempt: 1},
+		{Kind: tasklog.KindCriterionVerdict, SubtaskID: "s2", Criterion: "has 12 rows", Met: &no, Evidence: "only 11 rows", Attempt: 1},
+		{Kind: tasklog.KindCriterionVerdict, SubtaskID: "s2", Criterion: "has 12 rows", Met: &yes, Evidence: "12 rows", Attempt: 2},
+		{Kind: tasklog.KindSubtaskBegin, SubtaskID: "s3", Intent: "summarise by region", Criteria: []string{"names each region", "under 200 words"}},
+		{Kind: tasklog.KindCriterionVerdict, SubtaskID: "s3", Criterion: "names each region", Met: &no, Evidence: "EMEA missing"},
+		{Kind: tasklog.KindCriterionVerdict, SubtaskID: "s3", Criterion: "cites the source", Met: &yes},
+	}
+	if status != "" {
+		events = append(events, tasklog.Event{Kind: tasklog.KindTaskEnd, Status: status})
+	}
+	return m, events
+}
+
+func TestBuildCriteriaReport_FinalPlanWithLastVerdicts(t *testing.T) {
+	// Lists the manifest's subtasks in order with the verdict of each criterion's last attempt,
+	// leaves out earlier plans, appends unnamed criteria, and leaves Met nil without a verdict
+	rep := buildCriteriaReport(criteriaFixture("accepted"))
+	if rep.Status != "accepted" || len(rep.Subtasks) != 2 || rep.Subtasks[0].ID != "s2" || rep.Subtasks[1].ID != "s3" {
+		t.Fatalf("got %+v", rep)
+	}
+	s2 := rep.Subtasks[0].Criteria
+	if len(s2) != 2 || !*s2[1].Met || s2[1].Evidence != "12 rows" {
+		t.Errorf("s2 criteria = %+v, want the retry's passing verdict", s2)
+	}
+	s3 := rep.Subtasks[1].Criteria
+	if len(s3) != 3 || s3[1].Met != nil || s3[2].Criterion != "cites the source" {
+		t.Errorf("s3 criteria = %+v, want an unjudged criterion and an appended one", s3)
+	}
+}
+
+func TestBuildCriteriaReport_ReplanReusingSubtaskID(t *testing.T) {
+	// Reads each subtask from the latest planning round that began it, so a replan
+	// reusing a subtask ID shows the new plan's criteria and verdicts
+	yes, no := true, false
+	m := types.DispatchManifest{TaskID: "t1", SubTaskIDs: []string{"st1"}}
+	events := []tasklog.Event{
+		{Kind: tasklog.KindSubtaskBegin, SubtaskID: "st1", Intent: "search mail", Criteria: []string{"report found in mail"}},
+		{Kind: tasklog.KindCriterionVerdict, SubtaskID: "st1", Criterion: "report found in mail", Met: &no},
+		{Kind: tasklog.KindPlanDirective, Directive: "change_path"},
+		{Kind: tasklog.KindSubtaskBegin, SubtaskID: "st1", Intent: "search Documents", Criteria: []string{"report found in Documents"}},
+		{Kind: tasklog.KindCriterionVerdict, SubtaskID: "st1", Criterion: "report found in Documents", Met: &yes},
+	}
+	rep := buildCriteriaReport(m, events)
+	if len(rep.Subtasks) != 1 {
+		t.Fatalf("got %+v", rep)
+	}
+	st := rep.Subtasks[0]
+	if st.Intent != "search Documents" || len(st.Criteria) != 1 || st.Criteria[0].Criterion != "report found in Documents" || !*st.Criteria[0].Met {
+		t.Errorf("st1 = %+v, want only the replanned round's criterion, passed", st)
+	}
+}
+
+func TestPrintCriteria_ListsEachCriterionWithVerdict(t *testing.T) {
+	// Marks each subtask criterion pass, fail, or no verdict, and the task criteria pass only when accepted
+	for status, taskVerdict := range map[string]string{"accepted": "pass", "abandoned": "unconfirmed", "": "unconfirmed"} {
+		var out bytes.Buffer
+		printCriteria(&out, buildCriteriaReport(criteriaFixture(status)))
+		lines := strings.Split(out.String(), "\n")
+		for criterion, verdict := range map[string]string{
+			"the report lists every region": taskVerdict,
+			"file exists":                   "pass",
+			"has 12 rows":                   "pass",
+			"names each region":             "fail",
+			"under 200 words":               "no verdict",
+			"cites the source":              "pass",
+		} {
+			i := slices.IndexFunc(lines, func(l string) bool { return strings.HasSuffix(l, "  "+criterion) })
+			if i < 0 || !strings.Contains(lines[i], verdict) {
+				t.Errorf("status %q: no %q line for %q in:\n%s", status, verdict, criterion, out.String())
+			}
+		}
+		if strings.Contains(out.String(), "stale criterion") {
+			t.Errorf("status %q: a criterion of an earlier plan was listed", status)
+		}
+	}
+}
+
+func TestLatestManifest_ForTask(t *testing.T) {
+	// Returns the latest manifest only for the task it belongs to
+	ch := make(chan types.Message, 2)
+	ch <- types.Message{Payload: types.DispatchManifest{TaskID: "t1", TaskCriteria: []string{"first"}}}
+	ch <- types.Message{Payload: types.DispatchManifest{TaskID: "t1", TaskCriteria: []string{"replanned"}}}
+	close(ch)
+	var l latestManifest
+	l.watch(ch)
+	if m, ok := l.forTask("t1"); !ok || m.TaskCriteria[0] != "replanned" {
+		t.Errorf("forTask(t1) = %+v, %v; want the replanned manifest", m, ok)
+	}
+	if _, ok := l.forTask("t2"); ok {
+		t.Error("expected no manifest for another task")
+	}
+}
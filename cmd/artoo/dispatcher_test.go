@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/roles/executor"
+	"github.com/haricheung/agentic-shell/internal/tasklog"
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+// idleExecutor stands in for R3 and returns without producing a result.
+type idleExecutor struct{}
+
+func (idleExecutor) RunSubTask(context.Context, types.SubTask, <-chan types.CorrectionSignal, *tasklog.TaskLog) {
+}
+
+// matchingValidator stands in for R4a and matches every subtask at once.
+type matchingValidator struct{}
+
+func (matchingValidator) Run(_ context.Context, st types.SubTask, _ <-chan types.ExecutionResult, _ chan<- types.CorrectionSignal, _ *tasklog.TaskLog) types.SubTaskOutcome {
+	return types.SubTaskOutcome{SubTaskID: st.SubTaskID, ParentTaskID: st.ParentTaskID, Status: "matched", Output: "done " + st.SubTaskID}
+}
+
+func TestSubtaskDispatcher_PublishesProgress(t *testing.T) {
+	// Publishes one TaskProgress per completed subtask, counting against the manifest
+	// and naming the sequence group the subtask belonged to
+	b := bus.New()
+	progressCh := b.Subscribe(types.MsgTaskProgress)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go runSubtaskDispatcher(ctx, b, idleExecutor{}, matchingValidator{}, tasklog.NewRegistry(t.TempDir()), nil)
+	time.Sleep(20 * time.Millisecond) // let the dispatcher register its subscriptions
+
+	subTasks := []types.SubTask{
+		{SubTaskID: "a", ParentTaskID: "t1", Sequence: 1},
+		{SubTaskID: "b", ParentTaskID: "t1", Sequence: 1},
+		{SubTaskID: "c", ParentTaskID: "t1", Sequence: 2},
+	}
+	b.Publish(types.Message{Type: types.MsgDispatchManifest, From: types.RolePlanner, To: types.RoleMetaVal,
+		Payload: types.DispatchManifest{TaskID: "t1", SubTaskIDs: []string{"a", "b", "c"}}})
+	for _, st := range subTasks {
+		b.Publish(types.Message{Type: types.MsgSubTask, From: types.RolePlanner, To: types.RoleExecutor, Payload: st})
+	}
+
+	want := []types.TaskProgress{
+		{TaskID: "t1", CompletedSubtasks: 1, TotalSubtasks: 3, CurrentSequence: 1},
+		{TaskID: "t1", CompletedSubtasks: 2, TotalSubtasks: 3, CurrentSequence: 1},
+		{TaskID: "t1", CompletedSubtasks: 3, TotalSubtasks: 3, CurrentSequence: 2},
+	}
+	for i, w := range want {
+		select {
+		case msg := <-progressCh:
+			raw, _ := json.Marshal(msg.Payload)
+			var got types.TaskProgress
+			if err := json.Unmarshal(raw, &got); err != nil {
+				t.Fatalf("unmarshal TaskProgress: %v", err)
+			}
+			if got != w {
+				t.Errorf("progress %d = %+v, want %+v", i+1, got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for progress %d", i+1)
+		}
+	}
+}
+
+// recordingValidator matches every subtask at once and records the order R4a was started in.
+type recordingValidator struct {
+	mu    sync.Mutex
+	order []string
+}
+
+func (v *recordingValidator) Run(_ context.Context, st types.SubTask, _ <-chan types.ExecutionResult, _ chan<- types.CorrectionSignal, _ *tasklog.TaskLog) types.SubTaskOutcome {
+	v.mu.Lock()
+	v.order = append(v.order, st.SubTaskID)
+	v.mu.Unlock()
+	return types.SubTaskOutcome{SubTaskID: st.SubTaskID, ParentTaskID: st.ParentTaskID, Status: "matched", Output: "done " + st.SubTaskID}
+}
+
+func (v *recordingValidator) started() []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]string(nil), v.order...)
+}
+
+func TestSubtaskDispatcher_PriorityOrdersGroupUnderLimit(t *testing.T) {
+	// Higher Priority comes first
+	// Equal priorities keep their original (plan) order
+	t.Setenv("ARTOO_MAX_PARALLEL_SUBTASKS", "1")
+	b := bus.New()
+	progressCh := b.Subscribe(types.MsgTaskProgress)
+	av := &recordingValidator{}
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go runSubtaskDispatcher(ctx, b, idleExecutor{}, av, tasklog.NewRegistry(t.TempDir()), nil)
+	time.Sleep(20 * time.Millisecond) // let the dispatcher register its subscriptions
+
+	subTasks := []types.SubTask{
+		{SubTaskID: "low", ParentTaskID: "t1", Sequence: 1},
+		{SubTaskID: "high", ParentTaskID: "t1", Sequence: 1, Priority: 5},
+		{SubTaskID: "mid-a", ParentTaskID: "t1", Sequence: 1, Priority: 2},
+		{SubTaskID: "mid-b", ParentTaskID: "t1", Sequence: 1, Priority: 2},
+		{SubTaskID: "next", ParentTaskID: "t1", Sequence: 2, Priority: 9},
+	}
+	ids := make([]string, len(subTasks))
+	for i, st := range subTasks {
+		ids[i] = st.SubTaskID
+	}
+	b.Publish(types.Message{Type: types.MsgDispatchManifest, From: types.RolePlanner, To: types.RoleMetaVal,
+		Payload: types.DispatchManifest{TaskID: "t1", SubTaskIDs: ids}})
+	for _, st := range subTasks {
+		b.Publish(types.Message{Type: types.MsgSubTask, From: types.RolePlanner, To: types.RoleExecutor, Payload: st})
+	}
+
+	for i := range subTasks {
+		select {
+		case <-progressCh:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for progress %d", i+1)
+		}
+	}
+	want := []string{"high", "mid-a", "mid-b", "low", "next"}
+	if got := av.started(); !slices.Equal(got, want) {
+		t.Errorf("start order = %v, want %v", got, want)
+	}
+}
+
+// blockingExecutor stands in for R3: it reports its start, then waits for its
+// context to end and reports that too.
+type blockingExecutor struct{ started, stopped chan string }
+
+func (e blockingExecutor) RunSubTask(ctx context.Context, st types.SubTask, _ <-chan types.CorrectionSignal, _ *tasklog.TaskLog) {
+	e.started <- st.SubTaskID
+	<-ctx.Done()
+	e.stopped <- st.SubTaskID
+}
+
+// waitingValidator stands in for R4a and never matches: it returns when its context ends.
+type waitingValidator struct{}
+
+func (waitingValidator) Run(ctx context.Context, st types.SubTask, _ <-chan types.ExecutionResult, _ chan<- types.CorrectionSignal, _ *tasklog.TaskLog) types.SubTaskOutcome {
+	<-ctx.Done()
+	return types.SubTaskOutcome{SubTaskID: st.SubTaskID, ParentTaskID: st.ParentTaskID, Status: "failed"}
+}
+
+func TestSubtaskDispatcher_CancelledFinalResultStopsTask(t *testing.T) {
+	// A "cancelled" FinalResult stops the task's executor/agentval goroutines
+	b := bus.New()
+	exec := blockingExecutor{started: make(chan string, 1), stopped: make(chan string, 1)}
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go runSubtaskDispatcher(ctx, b, exec, waitingValidator{}, tasklog.NewRegistry(t.TempDir()), nil)
+	time.Sleep(20 * time.Millisecond) // let the dispatcher register its subscriptions
+
+	b.Publish(types.Message{Type: types.MsgDispatchManifest, From: types.RolePlanner, To: types.RoleMetaVal,
+		Payload: types.DispatchManifest{TaskID: "t1", SubTaskIDs: []string{"a"}}})
+	b.Publish(types.Message{Type: types.MsgSubTask, From: types.RolePlanner, To: types.RoleExecutor,
+		Payload: types.SubTask{SubTaskID: "a", ParentTaskID: "t1", Sequence: 1}})
+	select {
+	case <-exec.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("subtask never started")
+	}
+
+	b.Publish(types.Message{Type: types.MsgFinalResult, From: types.RoleUser, To: types.RoleUser,
+		Payload: types.FinalResult{TaskID: "t1", Directive: "cancelled", CancelReason: types.CancelUser}})
+	select {
+	case <-exec.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled FinalResult did not stop the running subtask")
+	}
+}
+
+func TestByPriority(t *testing.T) {
+	// Higher Priority comes first; equal priorities keep plan order; the input is not modified
+	in := []types.SubTask{{SubTaskID: "a"}, {SubTaskID: "b", Priority: 1}, {SubTaskID: "c"}, {SubTaskID: "d", Priority: 1}}
+	got := byPriority(in)
+	var order []string
+	for _, st := range got {
+		order = append(order, st.SubTaskID)
+	}
+	if want := []string{"b", "d", "a", "c"}; !slices.Equal(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+	if in[0].SubTaskID != "a" || in[1].SubTaskID != "b" {
+		t.Errorf("input was reordered: %+v", in)
+	}
+}
+
+func TestMaxParallelSubtasksFromEnv(t *testing.T) {
+	// Returns 0 (unlimited) when the variable is unset, not a number, or not positive
+	// Returns the parsed value otherwise
+	for _, tc := range []struct {
+		val  string
+		want int
+	}{{"", 0}, {"abc", 0}, {"-2", 0}, {"0", 0}, {"3", 3}, {" 4 ", 4}} {
+		t.Setenv("ARTOO_MAX_PARALLEL_SUBTASKS", tc.val)
+		if got := maxParallelSubtasksFromEnv(); got != tc.want {
+			t.Errorf("ARTOO_MAX_PARALLEL_SUBTASKS=%q: got %d, want %d", tc.val, got, tc.want)
+		}
+	}
+}
+
+func TestToolBreakerThresholdFromEnv(t *testing.T) {
+	// Returns executor.DefaultBreakerThreshold when unset or not a number; 0 for 0 or a
+	// negative value; the parsed value otherwise
+	for _, tc := range []struct {
+		val  string
+		want int
+	}{{"", executor.DefaultBreakerThreshold}, {"abc", executor.DefaultBreakerThreshold}, {"-2", 0}, {"0", 0}, {" 5 ", 5}} {
+		t.Setenv("ARTOO_TOOL_BREAKER", tc.val)
+		if got := toolBreakerThresholdFromEnv(); got != tc.want {
+			t.Errorf("ARTOO_TOOL_BREAKER=%q: got %d, want %d", tc.val, got, tc.want)
+		}
+	}
+}
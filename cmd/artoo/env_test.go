@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/haricheung/agentic-shell/internal/llm"
+	"github.com/haricheung/agentic-shell/internal/roles/agentval"
+	"github.com/haricheung/agentic-shell/internal/tools"
+)
+
+// namedTool is a no-op tools.Tool for registry-driven report tests.
+type namedTool string
+
+func (n namedTool) Name() string        { return string(n) }
+func (n namedTool) Description() string { return "test tool" }
+func (n namedTool) Schema() string      { return `{"action":"tool","tool":"` + string(n) + `"}` }
+func (n namedTool) Run(context.Context, json.RawMessage) (string, error) {
+	return "", nil
+}
+
+func TestBuildEnvReport_ReflectsConfigurationAndTools(t *testing.T) {
+	// Lists every registered tool in registry order with its availability and reason
+	t.Setenv("ARTOO_WORKSPACE", "/tmp/artoo-ws")
+	t.Setenv("OPENAI_BASE_URL", "https://llm.example/v1/")
+	t.Setenv("OPENAI_API_KEY", "k")
+	t.Setenv("OPENAI_MODEL", "shared-model")
+	t.Setenv("BRAIN_MODEL", "brain-model")
+
+	reg := tools.NewRegistry()
+	for _, name := range []string{"glob", "mdfind", "search"} {
+		if err := reg.Register(namedTool(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	available := func(tool string) (bool, string) {
+		if tool == "mdfind" {
+			return false, "mdfind: executable file not found in $PATH"
+		}
+		return true, ""
+	}
+	rep := buildEnvReport(envSources{
+		tiers:         []*llm.Client{llm.NewTier("BRAIN"), llm.NewTier("TOOL")},
+		registry:      reg,
+		available:     available,
+		memoryPath:    "/data/memory.leveldb",
+		dataDir:       "/data",
+		verdictPolicy: agentval.PolicyStrict,
+	})
+
+	cwd, _ := os.Getwd()
+	if rep.OS != runtime.GOOS || rep.Arch != runtime.GOARCH || rep.CWD != cwd {
+		t.Errorf("expected runtime platform and cwd, got %s/%s %s", rep.OS, rep.Arch, rep.CWD)
+	}
+	if rep.Workspace != "/tmp/artoo-ws" || rep.DataDir != "/data" || rep.Memory != "/data/memory.leveldb" {
+		t.Errorf("expected configured paths, got workspace=%s data=%s memory=%s", rep.Workspace, rep.DataDir, rep.Memory)
+	}
+	want := []tierStatus{
+		{Name: "BRAIN", Model: "brain-model", BaseURL: "https://llm.example/v1"},
+		{Name: "TOOL", Model: "shared-model", BaseURL: "https://llm.example/v1"},
+	}
+	if len(rep.Tiers) != len(want) {
+		t.Fatalf("expected %d tiers, got %+v", len(want), rep.Tiers)
+	}
+	for i := range want {
+		if rep.Tiers[i] != want[i] {
+			t.Errorf("tier %d: expected %+v, got %+v", i, want[i], rep.Tiers[i])
+		}
+	}
+	wantTools := []toolStatus{
+		{Name: "glob", Available: true},
+		{Name: "mdfind", Available: false, Reason: "mdfind: executable file not found in $PATH"},
+		{Name: "search", Available: true},
+	}
+	if len(rep.Tools) != len(wantTools) {
+		t.Fatalf("expected %d tools, got %+v", len(wantTools), rep.Tools)
+	}
+	for i := range wantTools {
+		if rep.Tools[i] != wantTools[i] {
+			t.Errorf("tool %d: expected %+v, got %+v", i, wantTools[i], rep.Tools[i])
+		}
+	}
+	if rep.MaxReplans != 3 || rep.TimeBudget != 5*time.Minute || rep.VerdictPolicy != agentval.PolicyStrict {
+		t.Errorf("expected GGS budget 3/5m and strict policy, got %d/%s %s", rep.MaxReplans, rep.TimeBudget, rep.VerdictPolicy)
+	}
+}
+
+func TestBuildEnvReport_MisconfiguredTierReportsProblem(t *testing.T) {
+	// Lists each tier's label, model, and base URL, with its Validate error as Problem
+	t.Setenv("OPENAI_BASE_URL", "")
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("OPENAI_MODEL", "")
+	t.Setenv("TOOL_BASE_URL", "")
+	t.Setenv("TOOL_API_KEY", "")
+	t.Setenv("TOOL_MODEL", "")
+
+	rep := buildEnvReport(envSources{tiers: []*llm.Client{llm.NewTier("TOOL")}})
+	if len(rep.Tiers) != 1 || rep.Tiers[0].Problem == "" {
+		t.Fatalf("expected a problem for the unconfigured tier, got %+v", rep.Tiers)
+	}
+	if rep.Memory != "" || len(rep.Tools) != 0 {
+		t.Errorf("expected no memory and no tools, got memory=%q tools=%+v", rep.Memory, rep.Tools)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFindQuery_SplitsWordsAndFilters(t *testing.T) {
+	// Plain words form the intent; status:, since:Nd and until: set the other fields
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local)
+	f, err := parseFindQuery(" weather  status:abandoned since:7d until:2026-10-15 beijing", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Intent != "weather beijing" || f.Status != "abandoned" {
+		t.Errorf("intent/status = %q/%q", f.Intent, f.Status)
+	}
+	if !f.Since.Equal(now.AddDate(0, 0, -7)) {
+		t.Errorf("since = %v", f.Since)
+	}
+	if want := time.Date(2026, 10, 16, 0, 0, 0, 0, time.Local); !f.Until.Equal(want) {
+		t.Errorf("until = %v, want %v (end of the named day)", f.Until, want)
+	}
+}
+
+func TestParseFindQuery_RejectsBadTokens(t *testing.T) {
+	// Unknown status values and unparseable dates are errors
+	for _, q := range []string{"status:done", "since:yesterday", "since:xd", "until:10/15", "tag:", "tag:a,b"} {
+		if _, err := parseFindQuery(q, time.Now()); err == nil {
+			t.Errorf("parseFindQuery(%q) should fail", q)
+		}
+	}
+}
+
+func TestParseFindQuery_Tag(t *testing.T) {
+	// tag: sets the tag filter, normalised like --tag
+	f, err := parseFindQuery("go tag:Research", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Intent != "go" || f.Tag != "research" {
+		t.Errorf("intent/tag = %q/%q", f.Intent, f.Tag)
+	}
+}
+
+func TestFilterHistory_ByTag(t *testing.T) {
+	// Keeps only turns whose Tags contain tag; returns history unchanged for an empty tag
+	history := []sessionEntry{
+		{Input: "search Go news", Summary: "Go 1.25 is out", Tags: []string{"research"}},
+		{Input: "hi", Summary: "Hello!"},
+		{Input: "find large videos", Summary: "3 files", Tags: []string{"files", "media"}},
+		{Input: "compare Rust and Go", Summary: "...", Tags: []string{"work", "research"}},
+	}
+	got := filterHistory(history, "research")
+	if len(got) != 2 || got[0].Input != "search Go news" || got[1].Input != "compare Rust and Go" {
+		t.Errorf("filterHistory(research) = %+v", got)
+	}
+	if got := filterHistory(history, "mail"); len(got) != 0 {
+		t.Errorf("expected no turns tagged mail, got %+v", got)
+	}
+	if got := filterHistory(history, ""); len(got) != len(history) {
+		t.Errorf("empty tag should keep all %d turns, got %d", len(history), len(got))
+	}
+}
+
+func TestParseHistoryTag(t *testing.T) {
+	// Returns "" for no argument, the normalised tag for --tag <tag>, and an error otherwise
+	if tag, err := parseHistoryTag(""); err != nil || tag != "" {
+		t.Errorf("empty: %q, %v", tag, err)
+	}
+	if tag, err := parseHistoryTag(" --tag Research"); err != nil || tag != "research" {
+		t.Errorf("--tag Research: %q, %v", tag, err)
+	}
+	for _, bad := range []string{"research", "--tag", "--tag a b", "--tag ,"} {
+		if _, err := parseHistoryTag(bad); err == nil {
+			t.Errorf("parseHistoryTag(%q) should fail", bad)
+		}
+	}
+}
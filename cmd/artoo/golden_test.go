@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/llm"
+	"github.com/haricheung/agentic-shell/internal/roles/agentval"
+	"github.com/haricheung/agentic-shell/internal/roles/executor"
+	"github.com/haricheung/agentic-shell/internal/roles/ggs"
+	"github.com/haricheung/agentic-shell/internal/roles/metaval"
+	"github.com/haricheung/agentic-shell/internal/roles/perceiver"
+	"github.com/haricheung/agentic-shell/internal/roles/planner"
+	"github.com/haricheung/agentic-shell/internal/tasklog"
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+// Golden tasks are end-to-end acceptance tests: a user input is driven through
+// the real R1 → R2 → dispatcher → R3/R4a → R4b → R7 wiring, with every LLM call
+// answered from a fixed per-role script and every tool call run for real inside
+// a temp directory. They guard the role wiring and dispatcher sequencing that
+// the per-role unit tests cannot see.
+//
+// "$DIR" in an input, script entry, or assertion is replaced with the task's
+// temp directory before use.
+
+// goldenTask is one acceptance case.
+type goldenTask struct {
+	name  string
+	input string
+	setup func(t *testing.T, dir string) // optional: populate the temp directory
+
+	// script maps a role tag ("R1", "R2", "R3", "R4a", "R4b") to the responses
+	// that role's LLM calls receive, in call order. The last entry repeats once
+	// the script runs out, so replan rounds can reuse the same answer.
+	script map[string][]string
+
+	wantDirective string              // FinalResult.Directive
+	wantOutput    []string            // substrings of the rendered FinalResult output
+	wantPrompts   map[string][]string // role → substrings that must appear in some user prompt to that role
+	wantNoCalls   []string            // roles that must never be called
+	minReplans    int                 // lower bound on FinalResult.Replans
+
+	// noClarify runs R1 as --no-clarify does: it may not ask, so it must record
+	// what it assumed. wantAssumptions are substrings of FinalResult.Assumptions.
+	noClarify       bool
+	wantAssumptions []string
+
+	// panicRole ("R3" or "R4a") swaps that role's subtask goroutine for one that
+	// panics, to check the dispatcher recovers instead of hanging the task.
+	panicRole string
+}
+
+// ── scriptedLLM ──────────────────────────────────────────────────────────────
+
+// scriptedRoleRe extracts the role tag from a system prompt ("You are R4a — ...").
+var scriptedRoleRe = regexp.MustCompile(`^You are (R\d[ab]?) —`)
+
+// scriptedLLM is an OpenAI-compatible server that answers each role from a script.
+type scriptedLLM struct {
+	mu         sync.Mutex
+	script     map[string][]string
+	calls      map[string]int
+	prompts    map[string][]string // role → user prompts received, in call order
+	unexpected []string            // calls that matched no scripted role
+}
+
+func newScriptedLLM(t *testing.T, script map[string][]string) *scriptedLLM {
+	t.Helper()
+	s := &scriptedLLM{script: script, calls: map[string]int{}, prompts: map[string][]string{}}
+	srv := httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(srv.Close)
+	t.Setenv("OPENAI_BASE_URL", srv.URL)
+	t.Setenv("OPENAI_API_KEY", "golden")
+	t.Setenv("OPENAI_MODEL", "golden")
+	return s
+}
+
+func (s *scriptedLLM) serve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Messages) < 2 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	system, user := req.Messages[0].Content, req.Messages[1].Content
+
+	s.mu.Lock()
+	var role string
+	if m := scriptedRoleRe.FindStringSubmatch(system); m != nil {
+		role = m[1]
+	}
+	responses := s.script[role]
+	if len(responses) == 0 {
+		s.unexpected = append(s.unexpected, fmt.Sprintf("%q: %s", role, firstN(system, 60)))
+		s.mu.Unlock()
+		http.Error(w, "no script for role "+role, http.StatusInternalServerError)
+		return
+	}
+	i := s.calls[role]
+	if i >= len(responses) {
+		i = len(responses) - 1
+	}
+	s.calls[role]++
+	s.prompts[role] = append(s.prompts[role], user)
+	content := responses[i]
+	s.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"choices": []map[string]any{{"message": map[string]string{"content": content}}},
+		"usage":   map[string]int{"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15},
+	})
+}
+
+// ── fault injection ──────────────────────────────────────────────────────────
+
+// panickingExecutor stands in for R3 and panics as soon as a subtask starts.
+type panickingExecutor struct{}
+
+func (panickingExecutor) RunSubTask(context.Context, types.SubTask, <-chan types.CorrectionSignal, *tasklog.TaskLog) {
+	panic("injected executor fault")
+}
+
+// panickingValidator stands in for R4a and panics as soon as a subtask starts.
+type panickingValidator struct{}
+
+func (panickingValidator) Run(context.Context, types.SubTask, <-chan types.ExecutionResult, chan<- types.CorrectionSignal, *tasklog.TaskLog) types.SubTaskOutcome {
+	panic("injected agentval fault")
+}
+
+// ── harness ──────────────────────────────────────────────────────────────────
+
+// runGolden wires the full pipeline against a scripted LLM, submits gt.input,
+// and returns the FinalResult published to the user together with the script.
+func runGolden(t *testing.T, gt goldenTask, dir string) (types.FinalResult, *scriptedLLM) {
+	t.Helper()
+	expand := func(s string) string { return strings.ReplaceAll(s, "$DIR", dir) }
+	if gt.setup != nil {
+		gt.setup(t, dir)
+	}
+	script := make(map[string][]string, len(gt.script))
+	for role, responses := range gt.script {
+		for _, r := range responses {
+			script[role] = append(script[role], expand(r))
+		}
+	}
+	sl := newScriptedLLM(t, script)
+	client := llm.New()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	t.Cleanup(cancel)
+
+	b := bus.New()
+	finalCh := b.Subscribe(types.MsgFinalResult)
+	logReg := tasklog.NewRegistry(filepath.Join(dir, ".cache", "tasks"))
+
+	// Memory is disabled (nil) so runs are hermetic and repeatable.
+	go planner.New(b, client, logReg, nil, nil).Run(ctx)
+	go metaval.New(b, client, nil, logReg).Run(ctx)
+	go ggs.New(b, nil, nil, logReg).Run(ctx)
+	var exec subtaskExecutor = executor.New(b, client, nil)
+	var av subtaskValidator = agentval.New(b, client, agentval.PolicyDefault)
+	switch gt.panicRole {
+	case "R3":
+		exec = panickingExecutor{}
+	case "R4a":
+		av = panickingValidator{}
+	}
+	go runSubtaskDispatcher(ctx, b, exec, av, logReg, nil)
+	time.Sleep(20 * time.Millisecond) // let role goroutines register their subscriptions
+
+	clarify := func(string) (string, error) { return "", nil }
+	if gt.noClarify {
+		clarify = perceiver.NoClarify
+	}
+	p := perceiver.New(b, client, clarify, nil)
+	pr, err := p.Process(ctx, expand(gt.input), "")
+	if err != nil {
+		t.Fatalf("perceiver: %v", err)
+	}
+	if pr.TaskID == "" {
+		t.Fatalf("expected the input to enter the pipeline, got direct response %q", pr.DirectResponse)
+	}
+
+	select {
+	case msg := <-finalCh:
+		raw, _ := json.Marshal(msg.Payload)
+		var fr types.FinalResult
+		if err := json.Unmarshal(raw, &fr); err != nil {
+			t.Fatalf("unmarshal FinalResult: %v", err)
+		}
+		return fr, sl
+	case <-ctx.Done():
+		sl.mu.Lock()
+		defer sl.mu.Unlock()
+		t.Fatalf("timed out waiting for FinalResult (calls so far: %v, unexpected: %v)", sl.calls, sl.unexpected)
+	}
+	return types.FinalResult{}, nil
+}
+
+// checkGolden applies gt's assertions to the FinalResult and the recorded calls.
+func checkGolden(t *testing.T, gt goldenTask, fr types.FinalResult, sl *scriptedLLM, dir string) {
+	t.Helper()
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	expand := func(s string) string { return strings.ReplaceAll(s, "$DIR", dir) }
+
+	if len(sl.unexpected) > 0 {
+		t.Errorf("unscripted LLM calls: %v", sl.unexpected)
+	}
+	if fr.Directive != gt.wantDirective {
+		t.Errorf("directive = %q, want %q (summary: %s)", fr.Directive, gt.wantDirective, fr.Summary)
+	}
+	if fr.Replans < gt.minReplans {
+		t.Errorf("replans = %d, want >= %d", fr.Replans, gt.minReplans)
+	}
+	out, _ := json.Marshal(fr.Output)
+	for _, want := range gt.wantOutput {
+		if !strings.Contains(string(out), expand(want)) {
+			t.Errorf("output %s does not contain %q", out, expand(want))
+		}
+	}
+	for role, wants := range gt.wantPrompts {
+		all := strings.Join(sl.prompts[role], "\n====\n")
+		for _, want := range wants {
+			if !strings.Contains(all, expand(want)) {
+				t.Errorf("no %s prompt contains %q; prompts:\n%s", role, expand(want), all)
+			}
+		}
+	}
+	assumed := strings.Join(fr.Assumptions, "\n")
+	for _, want := range gt.wantAssumptions {
+		if !strings.Contains(assumed, expand(want)) {
+			t.Errorf("assumptions %q do not contain %q", fr.Assumptions, expand(want))
+		}
+	}
+	for _, role := range gt.wantNoCalls {
+		if n := sl.calls[role]; n > 0 {
+			t.Errorf("expected no %s calls, got %d", role, n)
+		}
+	}
+}
+
+// ── golden tasks ─────────────────────────────────────────────────────────────
+
+var goldenTasks = []goldenTask{
+	{
+		// Single subtask, matched on the first attempt, accepted by R4b.
+		name:  "find_file_single_subtask_accept",
+		input: "find report.txt somewhere under $DIR",
+		setup: func(t *testing.T, dir string) {
+			writeGoldenFile(t, filepath.Join(dir, "notes", "report.txt"), "quarterly numbers")
+		},
+		script: map[string][]string{
+			"R1": {`{"task_id":"find_report","intent":"locate report.txt under $DIR","constraints":{"scope":null,"deadline":null},"raw_input":"find report.txt"}`},
+			"R2": {`{"task_criteria":["merged output contains an absolute path ending in report.txt"],"subtasks":[{"intent":"find report.txt under $DIR","success_criteria":["output contains an absolute path ending in report.txt"],"context":"search root: $DIR","deadline":null,"sequence":1}]}`},
+			"R3": {
+				`{"action":"tool","tool":"glob","pattern":"report.txt","root":"$DIR"}`,
+				`{"action":"result","status":"completed","output":"$DIR/notes/report.txt","uncertainty":null,"tool_calls":["glob: report.txt → $DIR/notes/report.txt"]}`,
+			},
+			"R4a": {`{"verdict":"matched","score":1.0,"criteria_results":[{"criterion":"output contains an absolute path ending in report.txt","met":true,"evidence":"glob returned $DIR/notes/report.txt"}],"unmet_criteria":[]}`},
+			"R4b": {`{"verdict":"accept","summary":"Found report.txt.","merged_output":"$DIR/notes/report.txt"}`},
+		},
+		wantDirective: "accept",
+		wantOutput:    []string{"$DIR/notes/report.txt"},
+		// The glob really ran: its match is fed back to R3 as a tool result.
+		wantPrompts: map[string][]string{"R3": {"Tool results so far", "$DIR/notes/report.txt"}},
+	},
+	{
+		// Two sequence groups: sequence 2 only starts after sequence 1 completes,
+		// and receives sequence 1's output in its context.
+		name:  "run_command_two_sequence_dependency",
+		input: "read the version from $DIR/VERSION and tell me how many characters it has",
+		setup: func(t *testing.T, dir string) {
+			writeGoldenFile(t, filepath.Join(dir, "VERSION"), "v1.2.3")
+		},
+		script: map[string][]string{
+			"R1": {`{"task_id":"version_length","intent":"count the characters of the version in $DIR/VERSION","constraints":{"scope":null,"deadline":null},"raw_input":"version length"}`},
+			"R2": {`{"task_criteria":["merged output states the version and its character count"],"subtasks":[` +
+				`{"intent":"read the version string from $DIR/VERSION","success_criteria":["output contains the version string"],"context":"","deadline":null,"sequence":1},` +
+				`{"intent":"count the characters of the version string","success_criteria":["output contains a numeric character count"],"context":"","deadline":null,"sequence":2}]}`},
+			"R3": {
+				`{"action":"tool","tool":"shell","command":"cat $DIR/VERSION"}`,
+				`{"action":"result","status":"completed","output":"v1.2.3","uncertainty":null,"tool_calls":["shell: cat VERSION → v1.2.3"]}`,
+				`{"action":"tool","tool":"shell","command":"echo length=$(printf %s v1.2.3 | wc -c | tr -d ' ')"}`,
+				`{"action":"result","status":"completed","output":"6 characters","uncertainty":null,"tool_calls":["shell: wc -c → 6"]}`,
+			},
+			"R4a": {
+				`{"verdict":"matched","score":1.0,"criteria_results":[{"criterion":"output contains the version string","met":true,"evidence":"cat printed v1.2.3"}],"unmet_criteria":[]}`,
+				`{"verdict":"matched","score":1.0,"criteria_results":[{"criterion":"output contains a numeric character count","met":true,"evidence":"wc -c printed 6"}],"unmet_criteria":[]}`,
+			},
+			"R4b": {`{"verdict":"accept","summary":"The version v1.2.3 has 6 characters.","merged_output":"v1.2.3 — 6 characters"}`},
+		},
+		wantDirective: "accept",
+		wantOutput:    []string{"v1.2.3", "6 characters"},
+		wantPrompts: map[string][]string{"R3": {
+			"stdout: v1.2.3",           // sequence 1 really ran cat
+			"Outputs from prior steps", // dispatcher injected sequence 1 output…
+			"count the characters",     // …into the sequence 2 subtask
+			"stdout: length=6",         // sequence 2 really ran wc
+		}},
+	},
+	{
+		// Every round fails; R4b's hard gate replans until R7 abandons the task.
+		// R4b's LLM is never consulted because no subtask ever matches.
+		name:  "abandon_after_replans",
+		input: "print the contents of $DIR/missing.txt",
+		script: map[string][]string{
+			"R1": {`{"task_id":"print_missing","intent":"print the contents of $DIR/missing.txt","constraints":{"scope":null,"deadline":null},"raw_input":"print missing.txt"}`},
+			"R2": {`{"task_criteria":["merged output contains the file contents"],"subtasks":[{"intent":"cat $DIR/missing.txt","success_criteria":["output contains the file contents"],"context":"","deadline":null,"sequence":1}]}`},
+			"R3": {
+				`{"action":"tool","tool":"shell","command":"cat $DIR/missing.txt"}`,
+				`{"action":"result","status":"failed","output":"cat: No such file or directory","uncertainty":null,"tool_calls":["shell: cat missing.txt → No such file or directory"]}`,
+			},
+			"R4a": {`{"verdict":"failed","score":0.0,"criteria_results":[{"criterion":"output contains the file contents","met":false,"failure_class":"environmental","evidence":"No such file or directory"}],"unmet_criteria":["output contains the file contents"],"failure_reason":"file not found"}`},
+		},
+		wantDirective: "abandon",
+		minReplans:    1,
+		wantPrompts:   map[string][]string{"R3": {"No such file or directory"}},
+		wantNoCalls:   []string{"R4b"},
+	},
+	{
+		// A Chinese request: R1 detects the language and R2, R3, and R4b are all
+		// told to answer in Chinese.
+		name:  "chinese_input_language_guidance",
+		input: "查找 $DIR 下的 report.txt",
+		setup: func(t *testing.T, dir string) {
+			writeGoldenFile(t, filepath.Join(dir, "report.txt"), "quarterly numbers")
+		},
+		script: map[string][]string{
+			"R1": {`{"task_id":"find_report_zh","intent":"locate report.txt under $DIR","constraints":{"scope":null,"deadline":null},"raw_input":"查找 report.txt"}`},
+			"R2": {`{"task_criteria":["merged output contains an absolute path ending in report.txt"],"subtasks":[{"intent":"find report.txt under $DIR","success_criteria":["output contains an absolute path ending in report.txt"],"context":"","deadline":null,"sequence":1}]}`},
+			"R3": {
+				`{"action":"tool","tool":"glob","pattern":"report.txt","root":"$DIR"}`,
+				`{"action":"result","status":"completed","output":"找到文件：$DIR/report.txt","uncertainty":null,"tool_calls":["glob: report.txt → $DIR/report.txt"]}`,
+			},
+			"R4a": {`{"verdict":"matched","score":1.0,"criteria_results":[{"criterion":"output contains an absolute path ending in report.txt","met":true,"evidence":"glob returned $DIR/report.txt"}],"unmet_criteria":[]}`},
+			"R4b": {`{"verdict":"accept","summary":"已找到 report.txt。","merged_output":"$DIR/report.txt"}`},
+		},
+		wantDirective: "accept",
+		wantOutput:    []string{"$DIR/report.txt"},
+		wantPrompts: map[string][]string{
+			"R2":  {`"language": "zh"`, "the user wrote in Chinese (zh)"},
+			"R3":  {`"language": "zh"`, "the user wrote in Chinese (zh)"},
+			"R4b": {"the user wrote in Chinese (zh)"},
+		},
+	},
+	{
+		// An ambiguous request under --no-clarify: R1 wants to ask which file is
+		// meant, is told nobody can answer, and records the interpretation it
+		// chose — which reaches the user in the FinalResult.
+		name:  "no_clarify_assumption_reaches_result",
+		input: "how long is the file in $DIR",
+		setup: func(t *testing.T, dir string) {
+			writeGoldenFile(t, filepath.Join(dir, "notes.md"), "twelve chars")
+		},
+		noClarify: true,
+		script: map[string][]string{
+			"R1": {
+				`{"needs_clarification":true,"question":"Which file in $DIR do you mean?"}`,
+				`{"task_id":"file_length","intent":"count the characters of $DIR/notes.md","constraints":{"scope":null,"deadline":null},"raw_input":"how long is the file","assumptions":["assumed 'the file' means the most recently modified .md in $DIR"]}`,
+			},
+			"R2": {`{"task_criteria":["merged output states a character count"],"subtasks":[{"intent":"count the characters of $DIR/notes.md","success_criteria":["output contains a numeric character count"],"context":"","deadline":null,"sequence":1}]}`},
+			"R3": {
+				`{"action":"tool","tool":"shell","command":"wc -c < $DIR/notes.md"}`,
+				`{"action":"result","status":"completed","output":"12 characters","uncertainty":null,"tool_calls":["shell: wc -c → 12"]}`,
+			},
+			"R4a": {`{"verdict":"matched","score":1.0,"criteria_results":[{"criterion":"output contains a numeric character count","met":true,"evidence":"wc -c printed 12"}],"unmet_criteria":[]}`},
+			"R4b": {`{"verdict":"accept","summary":"notes.md has 12 characters.","merged_output":"12 characters"}`},
+		},
+		wantDirective:   "accept",
+		wantOutput:      []string{"12 characters"},
+		wantPrompts:     map[string][]string{"R1": {"clarification is disabled"}},
+		wantAssumptions: []string{"assumed 'the file' means the most recently modified .md in $DIR"},
+	},
+	{
+		// R4a panics on every subtask: the dispatcher reports each one failed to
+		// R4b, so the task replans and abandons instead of hanging.
+		name:  "agentval_panic_abandons",
+		input: "print the contents of $DIR/VERSION",
+		setup: func(t *testing.T, dir string) {
+			writeGoldenFile(t, filepath.Join(dir, "VERSION"), "v1.2.3")
+		},
+		script: map[string][]string{
+			"R1": {`{"task_id":"print_version","intent":"print the contents of $DIR/VERSION","constraints":{"scope":null,"deadline":null},"raw_input":"print VERSION"}`},
+			"R2": {`{"task_criteria":["merged output contains the file contents"],"subtasks":[{"intent":"cat $DIR/VERSION","success_criteria":["output contains the file contents"],"context":"","deadline":null,"sequence":1}]}`},
+			"R3": {
+				`{"action":"tool","tool":"shell","command":"cat $DIR/VERSION"}`,
+				`{"action":"result","status":"completed","output":"v1.2.3","uncertainty":null,"tool_calls":["shell: cat VERSION → v1.2.3"]}`,
+			},
+		},
+		panicRole:     "R4a",
+		wantDirective: "abandon",
+		minReplans:    1,
+		wantNoCalls:   []string{"R4a", "R4b"},
+	},
+	{
+		// R3 panics on every subtask, with a second sequence group queued behind
+		// the first: the group still completes, sequence 2 is dispatched, and the
+		// task abandons instead of hanging.
+		name:  "executor_panic_abandons",
+		input: "read $DIR/VERSION and count its characters",
+		script: map[string][]string{
+			"R1": {`{"task_id":"version_length","intent":"count the characters of the version in $DIR/VERSION","constraints":{"scope":null,"deadline":null},"raw_input":"version length"}`},
+			"R2": {`{"task_criteria":["merged output states the version and its character count"],"subtasks":[` +
+				`{"intent":"read the version string from $DIR/VERSION","success_criteria":["output contains the version string"],"context":"","deadline":null,"sequence":1},` +
+				`{"intent":"count the characters of the version string","success_criteria":["output contains a numeric character count"],"context":"","deadline":null,"sequence":2}]}`},
+		},
+		panicRole:     "R3",
+		wantDirective: "abandon",
+		minReplans:    1,
+		wantNoCalls:   []string{"R3", "R4a", "R4b"},
+	},
+}
+
+func writeGoldenFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ── TestGolden ───────────────────────────────────────────────────────────────
+
+func TestGolden(t *testing.T) {
+	for _, gt := range goldenTasks {
+		t.Run(gt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fr, sl := runGolden(t, gt, dir)
+			checkGolden(t, gt, fr, sl, dir)
+		})
+	}
+}
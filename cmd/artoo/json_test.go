@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+func TestPrintResultJSON_OneCompactLine(t *testing.T) {
+	// Writes exactly one line: the marshalled FinalResult followed by a newline;
+	// includes loss, directive, and (when set) grad_l and replans under their JSON tags
+	var out bytes.Buffer
+	fr := types.FinalResult{
+		TaskID:    "t1",
+		Summary:   "done",
+		Output:    map[string]any{"rows": 12},
+		Loss:      types.LossBreakdown{D: 0.2, P: 0.1, Omega: 0.3, L: 0.42},
+		GradL:     -0.1,
+		Replans:   2,
+		Directive: "accept",
+	}
+	if err := printResultJSON(&out, fr); err != nil {
+		t.Fatal(err)
+	}
+	line, rest, _ := strings.Cut(out.String(), "\n")
+	if rest != "" || !strings.HasSuffix(out.String(), "\n") {
+		t.Fatalf("got %q, want one newline-terminated line", out.String())
+	}
+	var got struct {
+		Loss      struct{ L float64 } `json:"loss"`
+		GradL     float64             `json:"grad_l"`
+		Replans   int                 `json:"replans"`
+		Directive string              `json:"directive"`
+	}
+	if err := json.Unmarshal([]byte(line), &got); err != nil {
+		t.Fatalf("not JSON: %v in %q", err, line)
+	}
+	if got.Loss.L != 0.42 || got.GradL != -0.1 || got.Replans != 2 || got.Directive != "accept" {
+		t.Errorf("decoded %+v from %s", got, line)
+	}
+}
+
+func TestRunTask_JSONPrintsOnlyTheResultLine(t *testing.T) {
+	// outputJSON prints the whole FinalResult as one JSON line to stdout with no ANSI escapes
+	fr := types.FinalResult{
+		TaskID:    "find_report",
+		Summary:   "Found report.txt.",
+		Output:    "/tmp/work/report.txt",
+		Loss:      types.LossBreakdown{L: 0.05},
+		Directive: "accept",
+	}
+	got := runTaskStdout(t, outputJSON, fr)
+	if strings.Count(got, "\n") != 1 || strings.Contains(got, "\x1b") {
+		t.Fatalf("stdout = %q, want one plain JSON line", got)
+	}
+	var back types.FinalResult
+	if err := json.Unmarshal([]byte(got), &back); err != nil {
+		t.Fatalf("not JSON: %v", err)
+	}
+	if back.TaskID != "find_report" || back.Output != "/tmp/work/report.txt" || back.Loss.L != 0.05 {
+		t.Errorf("decoded %+v", back)
+	}
+}
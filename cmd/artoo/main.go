@@ -1,14 +1,24 @@
 package main
 
 import (
+	"archive/zip"
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -38,6 +48,124 @@ func main() {
 	// Load env
 	_ = godotenv.Load(".env")
 
+	// Command-line flags. Each flag defaults to its ARTOO_* env var so the same
+	// setting can live in .env; anything left after the flags is one-shot input.
+	verdictPolicyFlag := flag.String("verdict-policy", os.Getenv("ARTOO_VERDICT_POLICY"),
+		"R4a acceptance bar: strict | default | lenient")
+	themeFlag := flag.String("theme", os.Getenv("ARTOO_THEME"),
+		"terminal theme: scifi | plain (default: scifi on a capable TTY, plain for pipes and TERM=dumb; NO_COLOR drops colours)")
+	noClarifyDefault, _ := strconv.ParseBool(os.Getenv("ARTOO_NO_CLARIFY"))
+	noClarifyFlag := flag.Bool("no-clarify", noClarifyDefault,
+		"never ask clarifying questions; R1 proceeds with its best interpretation and records the assumption")
+	confirmFlag := flag.String("confirm", os.Getenv("ARTOO_CONFIRM_TOOLS"),
+		"comma-separated tools that ask before every call, e.g. shell,write_file,applescript")
+	noNetworkDefault, _ := strconv.ParseBool(os.Getenv("ARTOO_NO_NETWORK"))
+	noNetworkFlag := flag.Bool("no-network", noNetworkDefault,
+		"run every shell command without network access (Linux: unshare; macOS: sandbox-exec); exits if unsupported here")
+	replayFlag := flag.String("replay-llm", os.Getenv("ARTOO_REPLAY_LLM"),
+		"answer LLM calls from a task log (file or directory) instead of the backend, for offline debugging")
+	replayLiveDefault, _ := strconv.ParseBool(os.Getenv("ARTOO_REPLAY_LIVE"))
+	replayLiveFlag := flag.Bool("replay-live", replayLiveDefault,
+		"with --replay-llm, send prompts that match no recorded call to the backend instead of failing")
+	confirmCostDefault, _ := strconv.Atoi(os.Getenv("ARTOO_CONFIRM_COST"))
+	confirmCostFlag := flag.Int("confirm-cost", confirmCostDefault,
+		"ask before dispatching a plan whose estimated cost reaches this many tokens (0: never ask)")
+	checkFlag := flag.Bool("check", false,
+		"ping each LLM tier, report reachability, latency, and model, then exit (1 if any tier is unreachable)")
+	quietFlag := flag.Bool("quiet", false,
+		"one-shot only: print just the result's output — no UI, colors, decision log, or cost lines; questions go to stderr")
+	jsonFlag := flag.Bool("json", false,
+		"one-shot only: print the whole FinalResult (loss, gradient, replans, directive) as one compact JSON line — no UI or colors; questions go to stderr")
+	graphFlag := flag.String("graph", "",
+		"print the task log of this task ID as a Mermaid flowchart, then exit")
+	tagFlag := flag.String("tag", os.Getenv("ARTOO_TAGS"),
+		"comma-separated tags for every task this session, e.g. research,work (filter with /history --tag or /find tag:)")
+	abRoleFlag := flag.String("ab-role", "",
+		"A/B-test a role's system prompt: run the task once with --prompt-a and once with --prompt-b, then compare ("+strings.Join(abRoles, ", ")+")")
+	promptAFlag := flag.String("prompt-a", "", "with --ab-role, the file holding variant A's system prompt")
+	promptBFlag := flag.String("prompt-b", "", "with --ab-role, the file holding variant B's system prompt")
+	exportSessionFlag := flag.String("export-session", "",
+		"one-shot only: after the task, bundle its log, memory, effective config, and bus trace into this .zip for bug reports")
+	importSessionFlag := flag.String("import-session", "",
+		"load an --export-session bundle's memory and task log into the data dir, then exit (replay it with --replay-llm)")
+	flag.Parse()
+
+	// Theme first — every later message, including flag errors, renders through it.
+	th := ui.DefaultTheme()
+	if *themeFlag != "" {
+		t, err := ui.ThemeByName(*themeFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(2)
+		}
+		th = t
+	}
+	// --quiet and --json are for pipes and scripts: plain text, one answer, nothing else.
+	mode := outputRich
+	switch {
+	case *jsonFlag:
+		mode = outputJSON
+	case *quietFlag:
+		mode = outputQuiet
+	}
+	if mode != outputRich {
+		if len(flag.Args()) == 0 || flag.Arg(0) == "" {
+			fmt.Fprintf(os.Stderr, "error: --%s needs a task argument (it does not start the REPL)\n", mode)
+			os.Exit(2)
+		}
+		th, _ = ui.ThemeByName("plain")
+	}
+	ui.SetTheme(th)
+	if *exportSessionFlag != "" && (len(flag.Args()) == 0 || flag.Arg(0) == "") {
+		fmt.Fprintln(os.Stderr, "error: --export-session needs a task argument (it bundles one one-shot run)")
+		os.Exit(2)
+	}
+
+	verdictPolicy, err := agentval.ParseVerdictPolicy(*verdictPolicyFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%serror: %v%s\n", th.Red, err, th.Reset)
+		os.Exit(2)
+	}
+	// fetch_url (readable text of a web page) is offered only when ARTOO_FETCH_URL=true;
+	// registered before --confirm is parsed so it can be named there.
+	if on, _ := strconv.ParseBool(os.Getenv("ARTOO_FETCH_URL")); on {
+		if err := executor.RegisterFetchURL(tools.Default); err != nil {
+			fmt.Fprintf(os.Stderr, "%serror: %v%s\n", th.Red, err, th.Reset)
+			os.Exit(2)
+		}
+	}
+	confirmTools, err := executor.ParseConfirmTools(*confirmFlag, tools.Default)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%serror: %v%s\n", th.Red, err, th.Reset)
+		os.Exit(2)
+	}
+	// --no-network never silently degrades: refuse to start rather than run shell
+	// commands with the network the user asked to keep them away from.
+	if *noNetworkFlag {
+		if ok, reason := tools.NoNetworkSupported(); !ok {
+			fmt.Fprintf(os.Stderr, "%serror: --no-network: %s%s\n", th.Red, reason, th.Reset)
+			os.Exit(2)
+		}
+	}
+	// Per-tool concurrency caps shared by all parallel subtasks, e.g.
+	// ARTOO_TOOL_CONCURRENCY=applescript=1,shell=4 (applescript defaults to 1).
+	concurrency, err := tools.ParseLimits(os.Getenv("ARTOO_TOOL_CONCURRENCY"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%serror: %v%s\n", th.Red, err, th.Reset)
+		os.Exit(2)
+	}
+	toolLimits := tools.NewDefaultLimits(concurrency)
+	// Search and fetch results are reused from disk for ARTOO_WEB_CACHE_TTL
+	// (default 1h; "off" disables) so repeated web calls skip the rate limits.
+	webCacheTTL, err := tools.ParseWebCacheTTL(os.Getenv("ARTOO_WEB_CACHE_TTL"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%serror: %v%s\n", th.Red, err, th.Reset)
+		os.Exit(2)
+	}
+	if webCacheTTL > 0 {
+		tools.DefaultWebCache = tools.NewWebCache(tools.DefaultWebCacheDir(), webCacheTTL)
+	}
+
 	// Resolve data dir — ARTOO_DATA_DIR overrides the default ~/.artoo/
 	homeDir, _ := os.UserHomeDir()
 	cacheDir := os.Getenv("ARTOO_DATA_DIR")
@@ -48,6 +176,35 @@ func main() {
 	// Ensure data directory exists before opening any files.
 	_ = os.MkdirAll(cacheDir, 0755)
 
+	// --graph: render a logged task and exit — needs only the data dir.
+	if *graphFlag != "" {
+		out, err := tasklog.RenderMermaid(filepath.Join(cacheDir, "tasks"), *graphFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%serror: %v%s\n", th.Red, err, th.Reset)
+			os.Exit(1)
+		}
+		fmt.Print(out)
+		return
+	}
+
+	// --ab-role: each variant runs in its own one-shot child process (LevelDB is
+	// single-writer, so this process never opens memory), then the two logged
+	// runs are compared.
+	if *abRoleFlag != "" {
+		task := strings.TrimSpace(strings.Join(flag.Args(), " "))
+		if err := validateABFlags(*abRoleFlag, *promptAFlag, *promptBFlag, task); err != nil {
+			fmt.Fprintf(os.Stderr, "%serror: %v%s\n", th.Red, err, th.Reset)
+			os.Exit(2)
+		}
+		var extra []string
+		if *verdictPolicyFlag != "" {
+			extra = append(extra, "--verdict-policy", *verdictPolicyFlag)
+		}
+		cmp := runAB(context.Background(), *abRoleFlag, [2]string{*promptAFlag, *promptBFlag}, task, *tagFlag, extra, filepath.Join(cacheDir, "tasks"), memoryPath(cacheDir))
+		printABReport(os.Stdout, cmp)
+		return
+	}
+
 	// Ensure the agent workspace exists so write_file never fails on a missing dir.
 	// Generated files (scripts, reports, data) are redirected here automatically.
 	if err := tools.EnsureWorkspace(); err != nil {
@@ -69,60 +226,211 @@ func main() {
 
 	// Build the bus — foundational, everything depends on it
 	b := bus.New()
+	// Closing it on the way out ends every subscriber's, tap's, and priority
+	// subscriber's receive loop; roles drain on ctx first, so it runs last.
+	defer b.Close()
 
 	// LLM clients — each tier reads {TIER}_{API_KEY,BASE_URL,MODEL},
 	// falling back to the shared OPENAI_* vars for any unset tier variable.
 	brainClient := llm.NewTier("BRAIN") // R2 Planner only — needs reasoning/thinking
 	toolClient := llm.NewTier("TOOL")   // R1 Perceiver, R3 Executor, R4a AgentVal, R4b MetaVal
 
-	if err := toolClient.Validate(); err != nil {
-		fmt.Fprintf(os.Stderr, "\033[31merror: %v\033[0m\n", err)
-		fmt.Fprintf(os.Stderr, "\033[2mCopy .env.example to .env and fill in your API credentials.\033[0m\n")
+	// --check: health-check the tiers before anything else can fail on them.
+	if *checkFlag {
+		rep := checkTiers(context.Background(), []*llm.Client{brainClient, toolClient}, tierPingTimeout)
+		printTierStatus(rep)
+		if !rep.allReachable() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// --replay-llm: both tiers share one Replay so the log's interleaved calls are
+	// each served once. Without --replay-live the backend is never contacted, so
+	// credentials are not required.
+	var replay *llm.Replay
+	if *replayFlag != "" {
+		r, err := loadReplay(*replayFlag, *replayLiveFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%serror: --replay-llm: %v%s\n", th.Red, err, th.Reset)
+			os.Exit(2)
+		}
+		replay = r
+		brainClient.SetReplay(replay)
+		toolClient.SetReplay(replay)
+		_, remaining, _ := replay.Stats()
+		fmt.Fprintf(os.Stderr, "%sreplaying %d recorded LLM calls from %s%s\n", th.Dim, remaining, *replayFlag, th.Reset)
+	}
+
+	if err := toolClient.Validate(); err != nil && (replay == nil || replay.Live()) {
+		fmt.Fprintf(os.Stderr, "%serror: %v%s\n", th.Red, err, th.Reset)
+		fmt.Fprintf(os.Stderr, "%sCopy .env.example to .env and fill in your API credentials.%s\n", th.Dim, th.Reset)
 		os.Exit(1)
 	}
-	if err := brainClient.Validate(); err != nil {
-		fmt.Fprintf(os.Stderr, "\033[33mwarning: %v (will fall back to TOOL tier)\033[0m\n", err)
+	if err := brainClient.Validate(); err != nil && (replay == nil || replay.Live()) {
+		fmt.Fprintf(os.Stderr, "%swarning: %v (will fall back to TOOL tier)%s\n", th.Yellow, err, th.Reset)
 	}
 
 	// Infrastructure roles
 	// LevelDB memory store with toolClient for Dreamer upward consolidation (v0.9).
-	mem := memory.New(b, filepath.Join(cacheDir, "memory.leveldb"), toolClient)
+	// ARTOO_QUANTIZATION overrides (f, σ, k) rows of the GGS quantization matrix as JSON,
+	// e.g. {"refine":{"f":0.1,"sigma":0.5,"k":0.2}} — for experimenting with memory dynamics.
+	var quantOverrides map[string]memory.Quantization
+	if raw := os.Getenv("ARTOO_QUANTIZATION"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &quantOverrides); err != nil {
+			fmt.Fprintf(os.Stderr, "%serror: ARTOO_QUANTIZATION: %v%s\n", th.Red, err, th.Reset)
+			os.Exit(2)
+		}
+	}
+	// ARTOO_MEMORY_DECAY picks the forgetting curve: exponential (default) or power_law.
+	decay, err := memory.ParseDecay(os.Getenv("ARTOO_MEMORY_DECAY"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%serror: ARTOO_MEMORY_DECAY: %v%s\n", th.Red, err, th.Reset)
+		os.Exit(2)
+	}
+	mem, err := memory.NewWithQuantization(b, memoryPath(cacheDir), toolClient, quantOverrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%serror: ARTOO_QUANTIZATION: %v%s\n", th.Red, err, th.Reset)
+		os.Exit(2)
+	}
+	mem.SetDecay(decay)
+	// ARTOO_DREAMER_INTERVAL / ARTOO_DREAMER_SETTLE / ARTOO_DREAMER_ADAPTIVE tune when
+	// the Dreamer consolidates memory (see memory.DreamerSchedule).
+	dream, err := memory.ParseDreamerSchedule(os.Getenv("ARTOO_DREAMER_INTERVAL"), os.Getenv("ARTOO_DREAMER_SETTLE"), os.Getenv("ARTOO_DREAMER_ADAPTIVE"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%serror: %v%s\n", th.Red, err, th.Reset)
+		os.Exit(2)
+	}
+	mem.SetDreamerSchedule(dream)
+	// --import-session: rebuild an exported run's memory and task log here, then exit.
+	if *importSessionFlag != "" {
+		man, added, err := importSession(*importSessionFlag, mem, filepath.Join(cacheDir, "tasks"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%serror: --import-session: %v%s\n", th.Red, err, th.Reset)
+			os.Exit(1)
+		}
+		logPath := filepath.Join(cacheDir, "tasks", man.TaskID+".jsonl")
+		fmt.Printf("imported %s: %d megrams, task log %s\n", man.TaskID, added, logPath)
+		fmt.Printf("%sreplay it with: artoo --replay-llm %s <task>%s\n", th.Dim, logPath, th.Reset)
+		return
+	}
+	// R6 audits every message on the bus, its own reports included.
 	aud := auditor.New(b, b.NewTap(),
 		filepath.Join(cacheDir, "audit.jsonl"),
 		filepath.Join(cacheDir, "audit_stats.json"),
 		5*time.Minute)
 
-	// Sci-fi terminal UI — reads its own independent tap of every bus message
-	disp := ui.New(b.NewTap())
+	// Sci-fi terminal UI — reads its own independent tap of the task pipeline's
+	// messages; audit traffic never opens a pipeline box. --quiet and --json run without it.
+	var disp *ui.Display
+	if mode == outputRich {
+		disp = ui.New(b.NewFilteredTap(types.TaskMessageTypes...))
+	}
 
 	// Final result channel — delivers output to the REPL/one-shot handler
 	resultCh := make(chan types.FinalResult, 4)
 
-	outputFn := func(taskID, summary string, output any) {
-		resultCh <- types.FinalResult{TaskID: taskID, Summary: summary, Output: output}
+	outputFn := func(fr types.FinalResult) {
+		resultCh <- fr
 	}
 
 	// Per-task structured log registry — one JSONL file per task under tasks/
 	logReg := tasklog.NewRegistry(filepath.Join(cacheDir, "tasks"))
 
-	// Logical roles
-	plan := planner.New(b, brainClient, logReg, mem, outputFn)
+	// --confirm: the listed tools ask before every call; the REPL or one-shot
+	// runner installs the prompt, since each owns stdin.
+	confirmer := &toolConfirmer{}
+
+	// Logical roles. R2 shows each plan's estimated cost before dispatch and,
+	// at or above --confirm-cost tokens, asks through the same prompt as --confirm.
+	costs := &costGate{threshold: *confirmCostFlag, confirmer: confirmer, out: os.Stdout}
+	if mode != outputRich {
+		costs.out = io.Discard // the question itself still reaches stderr through the confirmer
+	}
+	plan := planner.NewWithCostPreview(b, brainClient, logReg, mem, outputFn, costs.preview)
 	mv := metaval.New(b, toolClient, outputFn, logReg)
-	gs := ggs.New(b, outputFn, mem, logReg) // R7 — Goal Gradient Solver; sole writer to R5
-	exec := executor.New(b, toolClient)
-	av := agentval.New(b, toolClient)
+	// R7 — Goal Gradient Solver; sole writer to R5. ARTOO_GGS_CHECKPOINTS=true persists
+	// per-task controller state so a restart mid-task keeps the loss gradient.
+	gs := ggs.New(b, outputFn, mem, logReg)
+	if on, _ := strconv.ParseBool(os.Getenv("ARTOO_GGS_CHECKPOINTS")); on {
+		gs = ggs.NewWithCheckpoints(b, outputFn, mem, logReg, filepath.Join(cacheDir, "ggs"))
+	}
+	// GGS_ALPHA, GGS_DELTA, GGS_ABANDON_OMEGA, ... override the loss weights and thresholds.
+	hp, err := ggs.LoadHyperparams()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%serror: %v%s\n", th.Red, err, th.Reset)
+		os.Exit(2)
+	}
+	gs.SetHyperparams(hp)
+	// GGS_LOGICAL_KEYWORDS / GGS_ENV_KEYWORDS extend the failure-text keyword lists behind P.
+	gs.SetFailureKeywords(ggs.LoadFailureKeywords())
+	// GGS_DECISION_TABLE names a JSON rules file that replaces the built-in directive cascade.
+	if path := os.Getenv("GGS_DECISION_TABLE"); path != "" {
+		table, err := ggs.LoadDecisionTable(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%serror: GGS_DECISION_TABLE: %v%s\n", th.Red, err, th.Reset)
+			os.Exit(2)
+		}
+		gs.SetDecisionTable(table)
+	}
+	// The confirmer also approves every message send, --confirm or not.
+	r3 := executor.NewWithConfirm(b, toolClient, mem, tools.Default, confirmTools, confirmer.confirm)
+	r3.SetNoNetwork(*noNetworkFlag)
+	r3.SetToolLimits(toolLimits)
+	// Per-call ceilings, e.g. ARTOO_TOOL_TIMEOUTS=shell=2m,applescript=10s (0 removes one).
+	toolTimeouts, err := executor.ParseToolTimeouts(os.Getenv("ARTOO_TOOL_TIMEOUTS"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%serror: %v%s\n", th.Red, err, th.Reset)
+		os.Exit(2)
+	}
+	for tool, d := range toolTimeouts {
+		r3.ToolTimeouts[tool] = d
+	}
+	// A tool that fails ARTOO_TOOL_BREAKER times in a row is refused for the rest of
+	// the task; the dispatcher resets the count when the task completes.
+	breaker := executor.NewToolBreaker(toolBreakerThresholdFromEnv())
+	r3.SetToolBreaker(breaker)
+	// R4a recalls prior verdicts on similarly worded criteria as scoring hints.
+	av := agentval.NewWithMemory(b, toolClient, verdictPolicy, mem)
+
+	// What /env reports on.
+	env := envSources{
+		tiers:         []*llm.Client{brainClient, toolClient},
+		registry:      tools.Default,
+		available:     tools.Available,
+		memoryPath:    memoryPath(cacheDir),
+		dataDir:       cacheDir,
+		verdictPolicy: verdictPolicy,
+	}
+
+	// Cancellation — Ctrl+C, SIGTERM, and the ARTOO_TASK_WALLTIME / ARTOO_TASK_IDLE_TIMEOUT
+	// watchdogs end the running task with a "cancelled" FinalResult naming the reason.
+	// Its "cancelled" FinalResult is what tells the dispatcher to stop the task's
+	// executor/agentval goroutines.
+	canceller := newTaskCanceller(b, logReg, outputFn)
 
 	// Context — cancelled on SIGTERM or when the current mode finishes.
+	// SIGTERM first cancels the running task so its result and log record the signal.
 	ctx, cancel := context.WithCancel(context.Background())
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGTERM) // Ctrl+C (SIGINT) handled per-mode below
 	go func() {
 		select {
 		case <-sigCh:
+			canceller.cancel(types.CancelSignal)
 			cancel()
 		case <-ctx.Done():
 		}
 	}()
+	if canceller.wallTime > 0 || canceller.idleTimeout > 0 {
+		// Only task messages count as activity: a periodic audit report is not progress.
+		go canceller.watch(ctx, b.NewFilteredTap(types.TaskMessageTypes...), time.Second)
+	}
+	// --export-session records the bus from the start so the bundle has the full trace.
+	var busRec *busRecorder
+	if *exportSessionFlag != "" {
+		busRec = recordBus(ctx, b.NewFilteredTap(types.TaskMessageTypes...))
+	}
 
 	// Audit report channel — delivers R6 reports to the REPL printer.
 	auditReportCh := make(chan types.AuditReport, 4)
@@ -140,25 +448,27 @@ func main() {
 		}
 	}()
 
+	// The latest plan's DispatchManifest — its task criteria are what /criteria shows.
+	manifests := &latestManifest{}
+	go manifests.watch(b.Subscribe(types.MsgDispatchManifest))
+
 	// Start persistent goroutines
 	go mem.Run(ctx)
 	go aud.Run(ctx)
 	go plan.Run(ctx)
 	go mv.Run(ctx)
 	go gs.Run(ctx)
-	go disp.Run(ctx)
-
-	// Task abort channel: REPL sends a taskID here when Ctrl+C is pressed mid-task.
-	// The dispatcher cancels all executor/agentval goroutines for that task.
-	abortTaskCh := make(chan string, 4)
+	if disp != nil {
+		go disp.Run(ctx)
+	}
 
 	// Subtask dispatcher: subscribes to SubTask messages and spawns paired executor/agentval goroutines
-	go runSubtaskDispatcher(ctx, b, exec, av, abortTaskCh, logReg)
+	go runSubtaskDispatcher(ctx, b, r3, av, logReg, breaker)
 
 	// REPL or one-shot
-	if len(os.Args) > 1 && os.Args[1] != "" {
+	if args := flag.Args(); len(args) > 0 && args[0] != "" {
 		// Meta commands intercepted before the pipeline so they work in one-shot mode too.
-		input := strings.Join(os.Args[1:], " ")
+		input := strings.Join(args, " ")
 		switch strings.TrimSpace(input) {
 		case "/memory":
 			printMemorySummary(mem.Summary())
@@ -168,22 +478,48 @@ func main() {
 			printMemorySummaryVerbose(mem.SummaryVerbose())
 			cancel()
 			return
+		case "/env", "/whoami":
+			printEnvReport(buildEnvReport(env))
+			cancel()
+			return
+		case "/tier-status":
+			printTierStatus(checkTiers(ctx, env.tiers, tierPingTimeout))
+			cancel()
+			return
 		case "/audit":
 			// Audit report requires the auditor goroutine to be running — use REPL path.
 			// Fall through to one-shot below (auditor is already started above).
 		}
 
-		// One-shot mode: Ctrl+C cancels the whole task and exits.
+		// One-shot mode: Ctrl+C cancels the task (reporting it as cancelled) and exits;
+		// before R1 has produced a task there is nothing to report, so just stop.
 		intrCh := make(chan os.Signal, 1)
 		signal.Notify(intrCh, os.Interrupt)
 		go func() {
 			select {
 			case <-intrCh:
-				cancel()
+				if !canceller.cancel(types.CancelUser) {
+					cancel()
+				}
 			case <-ctx.Done():
 			}
 		}()
-		if err := runTask(ctx, b, toolClient, input, resultCh, logReg, mem); err != nil {
+		taskID, err := runTask(ctx, b, toolClient, input, resultCh, logReg, mem, *noClarifyFlag, confirmer, canceller, mode, perceiver.ParseTags(*tagFlag))
+		// Export even a cancelled run — those are the ones worth a bug report.
+		if *exportSessionFlag != "" {
+			if taskID == "" {
+				fmt.Fprintf(os.Stderr, "%s--export-session: nothing to export (answered directly, no task ran)%s\n", th.Yellow, th.Reset)
+			} else {
+				cfg := sessionConfig{Args: os.Args[1:], Env: buildEnvReport(env), Settings: sessionSettings(os.Environ())}
+				man, xerr := exportSession(*exportSessionFlag, taskID, logReg, mem, cfg, busRec.messages())
+				if xerr != nil {
+					fmt.Fprintf(os.Stderr, "%serror: --export-session: %v%s\n", th.Red, xerr, th.Reset)
+				} else {
+					fmt.Fprintf(os.Stderr, "%sexported %s to %s (%d megrams, %d bus messages)%s\n", th.Dim, taskID, *exportSessionFlag, man.Megrams, man.BusMessages, th.Reset)
+				}
+			}
+		}
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			cancel()
 			os.Exit(1)
@@ -195,8 +531,248 @@ func main() {
 		time.Sleep(200 * time.Millisecond)
 	} else {
 		// REPL mode
-		runREPL(ctx, b, toolClient, resultCh, auditReportCh, cancel, cacheDir, disp, abortTaskCh, logReg, mem)
+		runREPL(ctx, b, toolClient, resultCh, auditReportCh, cancel, cacheDir, disp, canceller, logReg, mem, env, *noClarifyFlag, confirmer, perceiver.ParseTags(*tagFlag), gs.Trajectory, manifests)
+	}
+}
+
+// abRoles are the roles --ab-role accepts: each builds its LLM client with
+// llm.ForRole, which applies the role's system prompt override.
+var abRoles = []string{"perceiver", "planner", "executor", "agentval", "metaval"}
+
+// abVariant is one arm of a prompt A/B run.
+type abVariant struct {
+	Name       string               // "A" or "B"
+	PromptFile string               // absolute path of the variant's system prompt
+	Tag        string               // unique task tag its run is logged under
+	Output     string               // the child's --quiet output
+	Err        error                // the child process failed (its run may still be logged)
+	Run        *tasklog.TaskSummary // the logged run; nil when none carries Tag
+}
+
+// abComparison is the result of --ab-role: one task run under two prompt variants.
+type abComparison struct {
+	Role, Task string
+	Variants   [2]abVariant
+}
+
+// validateABFlags checks an --ab-role invocation before anything runs.
+//
+// Expectations:
+//   - Returns an error naming the accepted roles when role is not one of abRoles
+//   - Returns an error when either prompt file is unset, unreadable, or blank
+//   - Returns an error when task is empty
+//   - Returns nil for a valid invocation
+func validateABFlags(role, promptA, promptB, task string) error {
+	if !slices.Contains(abRoles, role) {
+		return fmt.Errorf("--ab-role %q: want one of %s", role, strings.Join(abRoles, ", "))
+	}
+	for _, f := range []struct{ flag, path string }{{"--prompt-a", promptA}, {"--prompt-b", promptB}} {
+		if f.path == "" {
+			return fmt.Errorf("--ab-role needs %s", f.flag)
+		}
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.flag, err)
+		}
+		if strings.TrimSpace(string(data)) == "" {
+			return fmt.Errorf("%s: %s is empty", f.flag, f.path)
+		}
+	}
+	if task == "" {
+		return fmt.Errorf("--ab-role needs a task argument")
+	}
+	return nil
+}
+
+// runAB runs task once per prompt variant, one after the other, each as a
+// --quiet --no-clarify child of this binary with the variant's prompt in the
+// role's llm.SystemPromptFile variable, plus extra flags. Each child is tagged
+// with tags and a tag unique to its variant, and gets its own copy of the
+// memory store at memPath (ARTOO_MEMORY_PATH), so both variants start from the
+// same memory and neither sees what the other wrote.
+func runAB(ctx context.Context, role string, promptFiles [2]string, task, tags string, extra []string, tasksDir, memPath string) abComparison {
+	cmp := abComparison{Role: role, Task: task}
+	self, err := os.Executable()
+	if err != nil {
+		self = os.Args[0]
+	}
+	runID := uuid.New().String()[:8]
+	for i, name := range []string{"A", "B"} {
+		v := abVariant{Name: name, PromptFile: promptFiles[i], Tag: "ab-" + runID + "-" + strings.ToLower(name)}
+		if abs, err := filepath.Abs(v.PromptFile); err == nil {
+			v.PromptFile = abs
+		}
+		args := append(slices.Clone(extra), "--quiet", "--no-clarify", "--tag", strings.Trim(tags+","+v.Tag, ","), task)
+		fmt.Fprintf(os.Stderr, "running variant %s (%s)...\n", name, v.PromptFile)
+		variantMem, err := copyMemory(memPath)
+		if err != nil {
+			v.Err = fmt.Errorf("copying memory for variant %s: %w", name, err)
+			cmp.Variants[i] = v
+			continue
+		}
+		cmd := exec.CommandContext(ctx, self, args...)
+		cmd.Env = append(os.Environ(), llm.SystemPromptFile(role)+"="+v.PromptFile, "ARTOO_MEMORY_PATH="+variantMem)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		os.RemoveAll(filepath.Dir(variantMem))
+		v.Output, v.Err = strings.TrimSpace(string(out)), err
+		cmp.Variants[i] = v
+	}
+	if err := attributeABRuns(tasksDir, cmp.Variants[:]); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: reading task logs: %v\n", err)
+	}
+	return cmp
+}
+
+// memoryPath is the LevelDB memory directory: ARTOO_MEMORY_PATH when set, else
+// memory.leveldb under dataDir.
+func memoryPath(dataDir string) string {
+	if p := os.Getenv("ARTOO_MEMORY_PATH"); p != "" {
+		return p
+	}
+	return filepath.Join(dataDir, "memory.leveldb")
+}
+
+// copyMemory copies the memory store at src into a new temporary directory and
+// returns the copy's path; the caller removes its parent when done. A missing
+// src yields a path where a fresh, empty store will be created.
+//
+// Expectations:
+//   - Copies every file of src into the returned directory
+//   - Returns a not-yet-existing path under a new temporary directory when src does not exist
+func copyMemory(src string) (string, error) {
+	parent, err := os.MkdirTemp("", "artoo-ab-memory-")
+	if err != nil {
+		return "", err
+	}
+	dst := filepath.Join(parent, "memory.leveldb")
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return dst, nil
+	}
+	if err := os.CopyFS(dst, os.DirFS(src)); err != nil {
+		os.RemoveAll(parent)
+		return "", err
+	}
+	return dst, nil
+}
+
+// attributeABRuns sets each variant's Run to the newest logged run carrying its Tag.
+//
+// Expectations:
+//   - Attributes each run's status, tokens, corrections, and replans to the variant whose tag it carries
+//   - Leaves Run nil for a variant with no tagged run
+//   - Returns the error from reading dir
+func attributeABRuns(dir string, variants []abVariant) error {
+	for i := range variants {
+		runs, err := tasklog.Query(dir, tasklog.QueryFilter{Tag: variants[i].Tag})
+		if err != nil {
+			return err
+		}
+		if len(runs) > 0 {
+			variants[i].Run = &runs[0]
+		}
+	}
+	return nil
+}
+
+// abWinner names the better variant: an accepted run beats one that was not
+// accepted; then fewer corrections plus replans; then fewer tokens.
+//
+// Expectations:
+//   - Returns "" (no winner) when either variant has no logged run
+//   - Prefers the accepted run when exactly one was accepted
+//   - Then prefers fewer corrections + replans, then fewer total tokens
+//   - Returns "tie" when status, rework, and tokens are all equal
+func abWinner(a, b abVariant) string {
+	if a.Run == nil || b.Run == nil {
+		return ""
+	}
+	ra, rb := a.Run, b.Run
+	accA, accB := ra.Status == "accepted", rb.Status == "accepted"
+	switch {
+	case accA != accB:
+		return pick(accA, a.Name, b.Name)
+	case ra.Corrections+ra.Replans != rb.Corrections+rb.Replans:
+		return pick(ra.Corrections+ra.Replans < rb.Corrections+rb.Replans, a.Name, b.Name)
+	case ra.TotalTokens != rb.TotalTokens:
+		return pick(ra.TotalTokens < rb.TotalTokens, a.Name, b.Name)
+	}
+	return "tie"
+}
+
+// pick returns a when cond holds, else b.
+func pick(cond bool, a, b string) string {
+	if cond {
+		return a
+	}
+	return b
+}
+
+// printABReport writes the side-by-side comparison of an --ab-role run.
+//
+// Expectations:
+//   - The report puts each variant's metrics in its own column and names the winner
+//   - Shows the theme's dash for a variant with no logged run, and each variant's tag for /find
+func printABReport(w io.Writer, cmp abComparison) {
+	t := ui.Active()
+	bold, cyan, dim, red, reset := t.Bold, t.Cyan, t.Dim, t.Red, t.Reset
+	a, b := cmp.Variants[0], cmp.Variants[1]
+	fmt.Fprintf(w, "\n%s%s%sPrompt A/B: %s%s  %s%s%s\n\n", bold, cyan, t.Prefix("robot"), cmp.Role, reset, dim, cmp.Task, reset)
+	fmt.Fprintf(w, "  %-12s %-24s %-24s\n", "", "A", "B")
+	fmt.Fprintf(w, "  %-12s %-24s %-24s\n", "prompt", firstN(filepath.Base(a.PromptFile), 21), firstN(filepath.Base(b.PromptFile), 21))
+	row := func(label string, f func(s tasklog.TaskSummary) string) {
+		cell := func(v abVariant) string {
+			if v.Run == nil {
+				return t.Icon("dash")
+			}
+			return f(*v.Run)
+		}
+		fmt.Fprintf(w, "  %-12s %-24s %-24s\n", label, cell(a), cell(b))
+	}
+	row("status", func(s tasklog.TaskSummary) string {
+		if s.Status == "" {
+			return "unfinished"
+		}
+		return s.Status
+	})
+	row("corrections", func(s tasklog.TaskSummary) string { return strconv.Itoa(s.Corrections) })
+	row("replans", func(s tasklog.TaskSummary) string { return strconv.Itoa(s.Replans) })
+	row("subtasks", func(s tasklog.TaskSummary) string { return strconv.Itoa(s.SubtaskCount) })
+	row("tool calls", func(s tasklog.TaskSummary) string { return strconv.Itoa(s.ToolCallCount) })
+	row("tokens", func(s tasklog.TaskSummary) string { return strconv.Itoa(s.TotalTokens) })
+	row("time", func(s tasklog.TaskSummary) string {
+		return (time.Duration(s.ElapsedMs) * time.Millisecond).Round(100 * time.Millisecond).String()
+	})
+	fmt.Fprintln(w)
+	for _, v := range cmp.Variants {
+		if v.Err != nil {
+			fmt.Fprintf(w, "  %s%s: %v%s\n", red, v.Name, v.Err, reset)
+		}
+		if v.Run == nil {
+			fmt.Fprintf(w, "  %s%s: no run logged under tag %s%s\n", red, v.Name, v.Tag, reset)
+		}
+		if v.Output != "" {
+			fmt.Fprintf(w, "  %s%s output:%s %s\n", dim, v.Name, reset, firstN(strings.ReplaceAll(v.Output, "\n", " "), 200))
+		}
+	}
+	switch winner := abWinner(a, b); winner {
+	case "":
+	case "tie":
+		fmt.Fprintf(w, "\n  %sResult: tie%s\n", bold, reset)
+	default:
+		fmt.Fprintf(w, "\n  %sResult: %s is better%s  %s(accepted first, then fewer corrections + replans, then fewer tokens)%s\n", bold, winner, reset, dim, reset)
 	}
+	fmt.Fprintf(w, "  %sruns are tagged %s / %s %s see /find tag:<tag>%s\n\n", dim, a.Tag, b.Tag, t.Icon("dash"), reset)
+}
+
+// subtaskExecutor is the R3 surface the dispatcher drives (*executor.Executor).
+type subtaskExecutor interface {
+	RunSubTask(ctx context.Context, subTask types.SubTask, correctionCh <-chan types.CorrectionSignal, tlog *tasklog.TaskLog)
+}
+
+// subtaskValidator is the R4a surface the dispatcher drives (*agentval.AgentValidator).
+type subtaskValidator interface {
+	Run(ctx context.Context, subTask types.SubTask, resultCh <-chan types.ExecutionResult, correctionCh chan<- types.CorrectionSignal, tlog *tasklog.TaskLog) types.SubTaskOutcome
 }
 
 // runSubtaskDispatcher subscribes to DispatchManifest, SubTask, and ExecutionResult
@@ -205,10 +781,37 @@ func main() {
 // started once the current group fully completes. Outputs from each completed group are
 // appended to the context of the next group so later subtasks can see earlier results
 // (e.g. a "locate file" subtask feeds its path to an "extract audio" subtask).
-func runSubtaskDispatcher(ctx context.Context, b *bus.Bus, exec *executor.Executor, av *agentval.AgentValidator, abortTaskCh <-chan string, logReg *tasklog.Registry) {
-	manifestCh := b.Subscribe(types.MsgDispatchManifest)
-	subTaskCh := b.Subscribe(types.MsgSubTask)
-	execResultCh := b.Subscribe(types.MsgExecutionResult)
+//
+// ARTOO_MAX_PARALLEL_SUBTASKS caps how many subtasks of one task run at once. When a
+// group is larger than the cap, its subtasks start in Priority order (highest first,
+// ties in plan order) and the rest wait for a free slot.
+//
+// A panic in a subtask's executor or agentval goroutine is recovered and turned into
+// a failed SubTaskOutcome for R4b, so the group still completes and the task can
+// replan or abandon instead of hanging on a completion signal that never arrives.
+//
+// The dispatcher reads the bus through one priority subscriber, so a FinalResult
+// overtakes the SubTask and ExecutionResult messages already queued: a "cancelled"
+// one stops the task's executor/agentval goroutines before anything behind it runs.
+func runSubtaskDispatcher(ctx context.Context, b *bus.Bus, r3 subtaskExecutor, av subtaskValidator, logReg *tasklog.Registry, breaker *executor.ToolBreaker) {
+	busQ := b.SubscribePriority(types.MsgDispatchManifest, types.MsgSubTask, types.MsgExecutionResult, types.MsgFinalResult)
+	// Unbuffered, so messages wait in busQ, where priority orders them, rather than here.
+	busCh := make(chan types.Message)
+	go func() {
+		defer close(busCh)
+		for {
+			msg, err := busQ.Recv(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case busCh <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	maxParallel := maxParallelSubtasksFromEnv()
 
 	type subtaskState struct {
 		resultCh     chan types.ExecutionResult
@@ -222,7 +825,9 @@ func runSubtaskDispatcher(ctx context.Context, b *bus.Bus, exec *executor.Execut
 		expected    int                     // total subtasks from manifest (-1 = not yet received)
 		bySeq       map[int][]types.SubTask // sequence number -> subtasks
 		inFlight    int                     // subtasks currently executing
+		pending     []types.SubTask         // current group's subtasks waiting for a free slot
 		currentSeq  int                     // sequence group now running (0 = not started)
+		completed   int                     // subtasks finished so far
 		prevOutputs []string                // outputs collected from completed sequence groups
 	}
 
@@ -248,34 +853,72 @@ func runSubtaskDispatcher(ctx context.Context, b *bus.Bus, exec *executor.Execut
 		slog.Debug("[DISPATCHER] spawning executor+agentval", "subtask", st.SubTaskID, "seq", st.Sequence)
 		subTask := st
 		tl := logReg.Get(subTask.ParentTaskID)
-		go exec.RunSubTask(td.ctx, subTask, correctionC, tl)
+		// stCtx scopes this subtask's pair: an executor panic cancels it so the
+		// agentval goroutine stops waiting for a result that will never come.
+		stCtx, stCancel := context.WithCancel(td.ctx)
+		execPanic := make(chan string, 1)
 		go func() {
-			outcome := av.Run(td.ctx, subTask, resultC, correctionC, tl)
-			mu.Lock()
-			delete(states, subTask.SubTaskID)
-			mu.Unlock()
-			completionCh <- completionSignal{parentTaskID: subTask.ParentTaskID, output: outcome.Output}
+			defer func() {
+				if r := recover(); r != nil {
+					slog.Error("[DISPATCHER] executor panic", "subtask", subTask.SubTaskID, "panic", r, "stack", string(debug.Stack()))
+					execPanic <- fmt.Sprintf("executor panic: %v", r)
+					stCancel()
+				}
+			}()
+			r3.RunSubTask(stCtx, subTask, correctionC, tl)
+		}()
+		go func() {
+			var outcome types.SubTaskOutcome
+			defer func() {
+				if r := recover(); r != nil {
+					slog.Error("[DISPATCHER] agentval panic", "subtask", subTask.SubTaskID, "panic", r, "stack", string(debug.Stack()))
+					tl.SubtaskEnd(subTask.SubTaskID, "failed")
+					outcome = publishFailedOutcome(b, subTask, fmt.Sprintf("agentval panic: %v", r))
+				}
+				stCancel()
+				mu.Lock()
+				delete(states, subTask.SubTaskID)
+				mu.Unlock()
+				completionCh <- completionSignal{parentTaskID: subTask.ParentTaskID, output: outcome.Output}
+			}()
+			outcome = av.Run(stCtx, subTask, resultC, correctionC, tl)
+			select {
+			case reason := <-execPanic:
+				// R4a returned on the cancelled context without publishing; report for it.
+				outcome = publishFailedOutcome(b, subTask, reason)
+			default:
+			}
 		}()
 		td.inFlight++
 	}
 
-	// dispatchSeq launches all subtasks for a given sequence number,
-	// enriching their Context with outputs from previous sequences.
+	// fillSlots starts pending subtasks of the current group while the parallel cap allows.
+	fillSlots := func(td *taskDispatch) {
+		for len(td.pending) > 0 && (maxParallel <= 0 || td.inFlight < maxParallel) {
+			st := td.pending[0]
+			td.pending = td.pending[1:]
+			spawnSubtask(td, st)
+		}
+	}
+
+	// dispatchSeq launches the subtasks for a given sequence number in priority
+	// order, enriching their Context with outputs from previous sequences.
 	dispatchSeq := func(td *taskDispatch, seq int) {
-		subtasks := td.bySeq[seq]
+		subtasks := byPriority(td.bySeq[seq])
 		td.currentSeq = seq
 		prevCtx := ""
 		if len(td.prevOutputs) > 0 {
 			prevCtx = "\n\nOutputs from prior steps (use these directly — do not re-run discovery):\n" +
 				strings.Join(td.prevOutputs, "\n---\n")
 		}
-		slog.Debug("[DISPATCHER] dispatching sequence", "seq", seq, "count", len(subtasks))
-		for _, st := range subtasks {
+		slog.Debug("[DISPATCHER] dispatching sequence", "seq", seq, "count", len(subtasks), "max_parallel", maxParallel)
+		for i := range subtasks {
 			if prevCtx != "" {
-				st.Context = st.Context + prevCtx
+				subtasks[i].Context = subtasks[i].Context + prevCtx
 			}
-			spawnSubtask(td, st)
 		}
+		td.pending = subtasks
+		fillSlots(td)
 	}
 
 	// minSeqAbove returns the smallest sequence number strictly above floor, or -1.
@@ -311,53 +954,76 @@ func runSubtaskDispatcher(ctx context.Context, b *bus.Bus, exec *executor.Execut
 		case <-ctx.Done():
 			return
 
-		case taskID, ok := <-abortTaskCh:
+		case msg, ok := <-busCh:
 			if !ok {
 				return
 			}
-			if td, found := dispatches[taskID]; found {
-				slog.Info("[DISPATCHER] aborting task", "task", taskID)
-				td.cancel()
-				delete(dispatches, taskID)
-			}
-
-		case msg, ok := <-manifestCh:
-			if !ok {
-				return
-			}
-			raw, _ := json.Marshal(msg.Payload)
-			var manifest types.DispatchManifest
-			if err := json.Unmarshal(raw, &manifest); err != nil {
-				slog.Error("[DISPATCHER] bad DispatchManifest payload", "error", err)
-				continue
-			}
-			td, exists := dispatches[manifest.TaskID]
-			if !exists {
-				tCtx, tCancel := context.WithCancel(ctx)
-				td = &taskDispatch{ctx: tCtx, cancel: tCancel, bySeq: make(map[int][]types.SubTask)}
-				dispatches[manifest.TaskID] = td
-			}
-			td.expected = len(manifest.SubTaskIDs)
-			slog.Debug("[DISPATCHER] manifest received", "task", manifest.TaskID, "expecting", td.expected)
-			tryStart(td)
-
-		case msg, ok := <-subTaskCh:
-			if !ok {
-				return
-			}
-			st, err := toSubTask(msg.Payload)
-			if err != nil {
-				slog.Error("[DISPATCHER] bad SubTask payload", "error", err)
-				continue
-			}
-			td, exists := dispatches[st.ParentTaskID]
-			if !exists {
-				tCtx, tCancel := context.WithCancel(ctx)
-				td = &taskDispatch{ctx: tCtx, cancel: tCancel, bySeq: make(map[int][]types.SubTask)}
-				dispatches[st.ParentTaskID] = td
+			switch msg.Type {
+			case types.MsgFinalResult:
+				raw, _ := json.Marshal(msg.Payload)
+				var fr types.FinalResult
+				if err := json.Unmarshal(raw, &fr); err != nil {
+					continue
+				}
+				if td, found := dispatches[fr.TaskID]; found && fr.Directive == "cancelled" {
+					slog.Info("[DISPATCHER] aborting task", "task", fr.TaskID)
+					td.cancel()
+					delete(dispatches, fr.TaskID)
+				}
+				// The task is over: its tools start with closed circuits next time.
+				breaker.Reset(fr.TaskID)
+
+			case types.MsgDispatchManifest:
+				raw, _ := json.Marshal(msg.Payload)
+				var manifest types.DispatchManifest
+				if err := json.Unmarshal(raw, &manifest); err != nil {
+					slog.Error("[DISPATCHER] bad DispatchManifest payload", "error", err)
+					continue
+				}
+				td, exists := dispatches[manifest.TaskID]
+				if !exists {
+					tCtx, tCancel := context.WithCancel(ctx)
+					td = &taskDispatch{ctx: tCtx, cancel: tCancel, bySeq: make(map[int][]types.SubTask)}
+					dispatches[manifest.TaskID] = td
+				}
+				td.expected = len(manifest.SubTaskIDs)
+				slog.Debug("[DISPATCHER] manifest received", "task", manifest.TaskID, "expecting", td.expected)
+				tryStart(td)
+
+			case types.MsgSubTask:
+				st, err := toSubTask(msg.Payload)
+				if err != nil {
+					slog.Error("[DISPATCHER] bad SubTask payload", "error", err)
+					continue
+				}
+				td, exists := dispatches[st.ParentTaskID]
+				if !exists {
+					tCtx, tCancel := context.WithCancel(ctx)
+					td = &taskDispatch{ctx: tCtx, cancel: tCancel, bySeq: make(map[int][]types.SubTask)}
+					dispatches[st.ParentTaskID] = td
+				}
+				td.bySeq[st.Sequence] = append(td.bySeq[st.Sequence], st)
+				tryStart(td)
+
+			case types.MsgExecutionResult:
+				result, err := toExecutionResult(msg.Payload)
+				if err != nil {
+					slog.Error("[DISPATCHER] bad ExecutionResult payload", "error", err)
+					continue
+				}
+				mu.Lock()
+				state, found := states[result.SubTaskID]
+				mu.Unlock()
+				if !found {
+					slog.Warn("[DISPATCHER] no state for subtask (already completed?)", "subtask", result.SubTaskID)
+					continue
+				}
+				select {
+				case state.resultCh <- result:
+				default:
+					slog.Warn("[DISPATCHER] resultCh full, dropping result", "subtask", result.SubTaskID)
+				}
 			}
-			td.bySeq[st.Sequence] = append(td.bySeq[st.Sequence], st)
-			tryStart(td)
 
 		case sig, ok := <-completionCh:
 			if !ok {
@@ -372,13 +1038,21 @@ func runSubtaskDispatcher(ctx context.Context, b *bus.Bus, exec *executor.Execut
 				var s string
 				if raw, err := json.Marshal(sig.output); err == nil {
 					if json.Unmarshal(raw, &s) == nil && s != "" {
-						td.prevOutputs = append(td.prevOutputs, s)
+						td.prevOutputs = append(td.prevOutputs, priorOutput(s))
 					} else {
-						td.prevOutputs = append(td.prevOutputs, string(raw))
+						td.prevOutputs = append(td.prevOutputs, priorOutput(string(raw)))
 					}
 				}
 			}
 			td.inFlight--
+			td.completed++
+			publishTaskProgress(b, types.TaskProgress{
+				TaskID:            sig.parentTaskID,
+				CompletedSubtasks: td.completed,
+				TotalSubtasks:     td.expected,
+				CurrentSequence:   td.currentSeq,
+			})
+			fillSlots(td)
 			if td.inFlight == 0 {
 				if next := minSeqAbove(td, td.currentSeq); next >= 0 {
 					dispatchSeq(td, next)
@@ -386,80 +1060,241 @@ func runSubtaskDispatcher(ctx context.Context, b *bus.Bus, exec *executor.Execut
 					delete(dispatches, sig.parentTaskID)
 				}
 			}
-
-		case msg, ok := <-execResultCh:
-			if !ok {
-				return
-			}
-			result, err := toExecutionResult(msg.Payload)
-			if err != nil {
-				slog.Error("[DISPATCHER] bad ExecutionResult payload", "error", err)
-				continue
-			}
-			mu.Lock()
-			state, found := states[result.SubTaskID]
-			mu.Unlock()
-			if !found {
-				slog.Warn("[DISPATCHER] no state for subtask (already completed?)", "subtask", result.SubTaskID)
-				continue
-			}
-			select {
-			case state.resultCh <- result:
-			default:
-				slog.Warn("[DISPATCHER] resultCh full, dropping result", "subtask", result.SubTaskID)
-			}
 		}
 	}
 }
 
-func runTask(ctx context.Context, b *bus.Bus, llmClient *llm.Client, input string, resultCh <-chan types.FinalResult, logReg *tasklog.Registry, mem types.MemoryService) error {
+// maxParallelSubtasksFromEnv reads ARTOO_MAX_PARALLEL_SUBTASKS, the most subtasks of
+// one task the dispatcher runs at once.
+//
+// Expectations:
+//   - Returns 0 (unlimited) when the variable is unset, not a number, or not positive
+//   - Returns the parsed value otherwise
+func maxParallelSubtasksFromEnv() int {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("ARTOO_MAX_PARALLEL_SUBTASKS")))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// toolBreakerThresholdFromEnv reads ARTOO_TOOL_BREAKER, the consecutive failures
+// of one tool within a task after which R3 stops calling it for that task.
+//
+// Expectations:
+//   - Returns executor.DefaultBreakerThreshold when the variable is unset or not a number
+//   - Returns 0 (breaker off) for 0 or a negative value
+//   - Returns the parsed value otherwise
+func toolBreakerThresholdFromEnv() int {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("ARTOO_TOOL_BREAKER")))
+	if err != nil {
+		return executor.DefaultBreakerThreshold
+	}
+	return max(n, 0)
+}
+
+// byPriority returns a copy of subTasks ordered for dispatch within one sequence group.
+//
+// Expectations:
+//   - Higher Priority comes first
+//   - Equal priorities keep their original (plan) order
+//   - The input slice is not modified
+func byPriority(subTasks []types.SubTask) []types.SubTask {
+	out := slices.Clone(subTasks)
+	slices.SortStableFunc(out, func(a, b types.SubTask) int { return b.Priority - a.Priority })
+	return out
+}
+
+// publishTaskProgress reports how far a task's plan has got, for the UI.
+func publishTaskProgress(b *bus.Bus, tp types.TaskProgress) {
+	b.Publish(types.Message{
+		ID:        uuid.New().String(),
+		Timestamp: time.Now().UTC(),
+		From:      types.RoleUser,
+		To:        types.RoleUser,
+		Type:      types.MsgTaskProgress,
+		Payload:   tp,
+	})
+}
+
+// priorOutput labels a completed subtask's output by its content type before it is
+// injected into the next sequence's Context, so a later subtask can use a path
+// list or JSON record directly instead of re-parsing prose.
+//
+// Expectations:
+//   - Plain text is returned unchanged
+//   - Path lists, JSON, and tables get a one-line header naming their shape
+func priorOutput(s string) string {
+	switch tools.DetectContentType(s) {
+	case tools.ContentPaths:
+		return "File paths (one per line, use as-is):\n" + s
+	case tools.ContentJSON:
+		return "JSON:\n" + s
+	case tools.ContentTable:
+		return "Table (delimited rows):\n" + s
+	}
+	return s
+}
+
+// publishFailedOutcome reports subTask to R4b as failed with reason, on behalf of an
+// agentval goroutine that could not (it panicked, or its executor did).
+//
+// Expectations:
+//   - Publishes a SubTaskOutcome from R4a to R4b with status "failed" and the given reason
+//   - Returns the published outcome
+func publishFailedOutcome(b *bus.Bus, subTask types.SubTask, reason string) types.SubTaskOutcome {
+	o := types.SubTaskOutcome{
+		SubTaskID:       subTask.SubTaskID,
+		ParentTaskID:    subTask.ParentTaskID,
+		Intent:          subTask.Intent,
+		Sequence:        subTask.Sequence,
+		SuccessCriteria: subTask.SuccessCriteria,
+		Status:          "failed",
+		FailureReason:   &reason,
+	}
+	b.Publish(types.Message{
+		ID:        uuid.New().String(),
+		Timestamp: time.Now().UTC(),
+		From:      types.RoleAgentVal,
+		To:        types.RoleMetaVal,
+		Type:      types.MsgSubTaskOutcome,
+		Payload:   o,
+	})
+	return o
+}
+
+// outputMode selects how a one-shot run prints its result.
+type outputMode int
+
+const (
+	outputRich  outputMode = iota // result panel, decision log, and cost lines
+	outputQuiet                   // --quiet: only the result's output
+	outputJSON                    // --json: the whole FinalResult as one JSON line
+)
+
+// String returns the flag that selects the mode ("" for the default).
+func (m outputMode) String() string {
+	switch m {
+	case outputQuiet:
+		return "quiet"
+	case outputJSON:
+		return "json"
+	}
+	return ""
+}
+
+// printResultJSON writes fr to w as a single line of compact JSON, for --json.
+//
+// Expectations:
+//   - Writes exactly one line: the marshalled FinalResult followed by a newline
+//   - Includes loss, directive, and (when set) grad_l and replans under their JSON tags
+func printResultJSON(w io.Writer, fr types.FinalResult) error {
+	data, err := json.Marshal(fr)
+	if err != nil {
+		return fmt.Errorf("encode result: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// runTask runs one input through the pipeline and prints the result.
+// With noClarify set, R1 never waits on stdin for a clarifying answer.
+// --confirm and cost questions are read from the same stdin scanner, with or without noClarify.
+// A cancelled task prints its result and returns an error naming the reason.
+// mode outputQuiet (--quiet) prints only the result's output to stdout and
+// outputJSON (--json) the whole FinalResult as one JSON line; both ask any
+// question on stderr, so stdout carries nothing but the answer. Under --json a
+// direct answer from R1 is printed as a FinalResult with only Output set.
+// tags (--tag) are attached to the task's TaskSpec.
+// Returns the task's ID, or "" when R1 answered directly or failed.
+func runTask(ctx context.Context, b *bus.Bus, llmClient *llm.Client, input string, resultCh <-chan types.FinalResult, logReg *tasklog.Registry, mem types.MemoryService, noClarify bool, confirmer *toolConfirmer, canceller *taskCanceller, mode outputMode, tags []string) (string, error) {
 	scanner := bufio.NewScanner(os.Stdin)
-	clarifyFn := func(question string) (string, error) {
-		fmt.Printf("? %s\n> ", question)
+	prompts := io.Writer(os.Stdout)
+	if mode != outputRich {
+		prompts = os.Stderr
+	}
+	askStdin := func(question string) (string, error) {
+		fmt.Fprintf(prompts, "? %s\n> ", question)
 		if scanner.Scan() {
 			return scanner.Text(), nil
 		}
 		return "", fmt.Errorf("no input")
 	}
+	// --no-clarify silences only R1; --confirm and cost questions still read stdin.
+	confirmer.setAsk(func(_ context.Context, question string) (string, error) {
+		return askStdin(question)
+	})
+	clarifyFn := askStdin
+	if noClarify {
+		clarifyFn = perceiver.NoClarify
+	}
 
-	p := perceiver.New(b, llmClient, clarifyFn, mem)
+	p := perceiver.NewWithTags(b, llmClient, clarifyFn, mem, tags)
+	p.SetTaskLog(logReg)
 	pr, err := p.Process(ctx, input, "")
 	if err != nil {
-		return fmt.Errorf("perceiver: %w", err)
+		return "", fmt.Errorf("perceiver: %w", err)
 	}
 
 	// Fast path — R1 answered directly, no pipeline needed.
 	if pr.DirectResponse != "" {
+		if mode == outputJSON {
+			return "", printResultJSON(os.Stdout, types.FinalResult{Output: pr.DirectResponse})
+		}
 		fmt.Println(pr.DirectResponse)
-		return nil
+		return "", nil
 	}
 
 	perceiverUsage := pr.Usage
+	canceller.begin(pr.TaskID, pr.Assumptions)
+	defer canceller.end(pr.TaskID)
 
-	// Wait for final result
+	// Wait for final result. SIGTERM delivers a cancelled result just before it
+	// cancels ctx, so a result that is already waiting wins over ctx.Done.
+	var result types.FinalResult
 	select {
+	case result = <-resultCh:
 	case <-ctx.Done():
-		return ctx.Err()
-	case result := <-resultCh:
-		printResult(result, input)
+		select {
+		case result = <-resultCh:
+		default:
+			return pr.TaskID, ctx.Err()
+		}
+	}
+	switch mode {
+	case outputJSON:
+		if err := printResultJSON(os.Stdout, result); err != nil {
+			return result.TaskID, err
+		}
+	case outputQuiet:
+		ui.RenderQuiet(os.Stdout, result)
+	default:
+		ui.RenderResult(os.Stdout, result, input)
 		stats := logReg.GetStats(result.TaskID)
 		printDecisionLog(logReg.ReadEvents(result.TaskID))
 		printCostStats(perceiverUsage, stats)
 	}
-	return nil
+	if result.Directive == "cancelled" {
+		return result.TaskID, fmt.Errorf("task cancelled: %s", cancelDescription(result.CancelReason))
+	}
+	return result.TaskID, nil
 }
 
 // sessionEntry records one REPL turn for context passing to the Perceiver.
 type sessionEntry struct {
 	Input   string
 	Summary string
+	Tags    []string // the task's TaskSpec tags; nil for direct answers
 }
 
-func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-chan types.FinalResult, auditReportCh <-chan types.AuditReport, cancel context.CancelFunc, cacheDir string, disp *ui.Display, abortTaskCh chan<- string, logReg *tasklog.Registry, mem *memory.Store) {
-	fmt.Println("\033[1m\033[36m🤖 artoo\033[0m — agentic shell  \033[2m(exit/Ctrl-D to quit | Ctrl+C aborts task | debug: ~/.artoo/debug.log)\033[0m")
+func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-chan types.FinalResult, auditReportCh <-chan types.AuditReport, cancel context.CancelFunc, cacheDir string, disp *ui.Display, canceller *taskCanceller, logReg *tasklog.Registry, mem *memory.Store, env envSources, noClarify bool, confirmer *toolConfirmer, tags []string, trajectory func(taskID string) []ggs.RoundSnapshot, manifests *latestManifest) {
+	t := ui.Active()
+	fmt.Printf("%s%s%sartoo%s %s agentic shell  %s(exit/Ctrl-D to quit | Ctrl+C aborts task | debug: ~/.artoo/debug.log)%s\n",
+		t.Bold, t.Cyan, t.Prefix("robot"), t.Reset, t.Icon("dash"), t.Dim, t.Reset)
 
+	prompt := t.Prefix("user") + t.Cyan + ">" + t.Reset + " "
 	rl, err := readline.NewEx(&readline.Config{
-		Prompt:            "🧑 \033[36m>\033[0m ",
+		Prompt:            prompt,
 		HistoryFile:       filepath.Join(cacheDir, "history"),
 		HistorySearchFold: true,
 		InterruptPrompt:   "^C",
@@ -475,11 +1310,11 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 
 	const maxHistory = 5
 	var history []sessionEntry
+	var lastTaskID string // the latest task that went through the pipeline, for /trajectory
 
 	// Per-task state — protected by taskMu.
 	var taskMu sync.Mutex
 	var taskCancel context.CancelFunc
-	var currentTaskID string
 
 	// Ctrl+C during task execution (readline NOT active): abort the task only.
 	// Ctrl+C during readline input arrives as readline.ErrInterrupt (handled below).
@@ -519,17 +1354,17 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 			case <-intrCh:
 				taskMu.Lock()
 				tc := taskCancel
-				tid := currentTaskID
 				taskMu.Unlock()
 				if tc != nil {
-					tc() // cancel per-task context (unblocks waitResult)
-					// Tell dispatcher to cancel the executor/agentval goroutines.
-					select {
-					case abortTaskCh <- tid:
-					default:
+					// Once R1 has produced a task, the canceller stops its subtasks and
+					// delivers a cancelled FinalResult, which waitResult prints. Before
+					// that, cancel the per-task context to stop R1 itself.
+					if !canceller.cancel(types.CancelUser) {
+						tc()
 					}
 					disp.Abort() // close the pipeline box immediately
-					fmt.Print("\r\033[K\n\033[33m⚠️  task aborted\033[0m  (type 'exit' or Ctrl+D to quit)\n")
+					fmt.Print(t.ClearLine())
+					fmt.Printf("\n%s%s task aborted%s  (type 'exit' or Ctrl+D to quit)\n", t.Yellow, t.Icon("warn"), t.Reset)
 				} else {
 					select {
 					case rlCh <- rlResult{err: readline.ErrInterrupt}:
@@ -554,12 +1389,26 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 		return <-rlCh
 	}
 
+	// --confirm questions arrive from executor goroutines while a task runs and
+	// the main loop is waiting for its result, so they read rlCh directly.
+	// Ctrl+C cancels the task context, which unblocks a pending question.
+	confirmer.setAsk(func(ctx context.Context, question string) (string, error) {
+		fmt.Print(t.ClearLine())
+		fmt.Printf("%s?%s %s\n", t.Yellow, t.Reset, question)
+		select {
+		case r := <-rlCh:
+			return r.line, r.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	})
+
 	for {
 		r := readLine()
 
 		if r.err == readline.ErrInterrupt {
 			// Ctrl+C while idle (no task running) — first press warns, second exits.
-			fmt.Println("\n\033[2m(Ctrl+C again or type 'exit' to quit)\033[0m")
+			fmt.Printf("\n%s(Ctrl+C again or type 'exit' to quit)%s\n", t.Dim, t.Reset)
 			r2 := readLine()
 			if r2.err == readline.ErrInterrupt || strings.TrimSpace(r2.line) == "exit" || strings.TrimSpace(r2.line) == "quit" {
 				cancel()
@@ -613,7 +1462,7 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 		// While accumulating, the prompt changes to `... ` to signal continuation.
 		// Ctrl+C cancels accumulation and returns to normal prompt.
 		if input == `"""` {
-			rl.SetPrompt("\033[2m...\033[0m ")
+			rl.SetPrompt(t.Dim + "..." + t.Reset + " ")
 			var lines []string
 			aborted := false
 			for {
@@ -631,9 +1480,9 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 				}
 				lines = append(lines, r2.line)
 			}
-			rl.SetPrompt("🧑 \033[36m>\033[0m ")
+			rl.SetPrompt(prompt)
 			if aborted || len(lines) == 0 {
-				fmt.Println("\033[2m(multi-line input cancelled)\033[0m")
+				fmt.Printf("%s(multi-line input cancelled)%s\n", t.Dim, t.Reset)
 				continue
 			}
 			input = strings.Join(lines, "\n")
@@ -660,6 +1509,50 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 			rl.Refresh()
 			continue
 		}
+		// /memory forget <intent> — delete the lessons recorded for one kind of task:
+		// the intent maps to its space tag via IntentSlug, as GGS does when it writes.
+		if input == "/memory forget" || strings.HasPrefix(input, "/memory forget ") {
+			rl.Clean()
+			intent := strings.TrimSpace(strings.TrimPrefix(input, "/memory forget"))
+			space := memory.IntentSlug(intent)
+			if space == "intent:" {
+				fmt.Printf("Usage: /memory forget <intent>  %s e.g. /memory forget search reuters news\n", t.Icon("dash"))
+				rl.Refresh()
+				continue
+			}
+			if n, err := mem.ForgetSpace(space); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			} else if n == 0 {
+				fmt.Printf("No Megram found in %s\n", space)
+			} else {
+				fmt.Printf("%s Forgot %d Megram(s) in %s\n", t.Icon("check"), n, space)
+			}
+			rl.Refresh()
+			continue
+		}
+		// /memory clear — wipe the whole MKCT store after an explicit y/N confirmation.
+		if input == "/memory clear" {
+			rl.Clean()
+			total := 0
+			for _, c := range mem.Summary().LevelCounts {
+				total += c
+			}
+			fmt.Printf("%s?%s Permanently delete all %d Megram(s)? [y/N]\n", t.Yellow, t.Reset, total)
+			// readLine() keeps the readline goroutine the sole rl.Readline() caller.
+			ans := readLine()
+			if ans.err != nil || !strings.EqualFold(strings.TrimSpace(ans.line), "y") {
+				fmt.Printf("%s(memory clear cancelled)%s\n", t.Dim, t.Reset)
+				rl.Refresh()
+				continue
+			}
+			if n, err := mem.Clear(); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			} else {
+				fmt.Printf("%s Cleared %d Megram(s) %s memory reset\n", t.Icon("check"), n, t.Icon("dash"))
+			}
+			rl.Refresh()
+			continue
+		}
 
 		// /remember — inject a Megram into MKCT memory.
 		// Usage: /remember <content>                    — C-level at global:user (default)
@@ -719,7 +1612,7 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 				K:         k,
 			}
 			mem.Write(meg)
-			fmt.Printf("✓ Persisted %s-level Megram [%s / env:local]\n  %s\n", level, space, content)
+			fmt.Printf("%s Persisted %s-level Megram [%s / env:local]\n  %s\n", t.Icon("check"), level, space, content)
 			rl.Refresh()
 			continue
 		}
@@ -731,26 +1624,128 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 			rl.Clean()
 			arg := strings.TrimSpace(strings.TrimPrefix(input, "/forget"))
 			if arg == "" {
-				fmt.Println("Usage: /forget <id-prefix>  — delete by ID prefix")
-				fmt.Println("       /forget all          — delete ALL Megrams")
+				fmt.Printf("Usage: /forget <id-prefix>  %s delete by ID prefix\n", t.Icon("dash"))
+				fmt.Printf("       /forget all          %s delete ALL Megrams\n", t.Icon("dash"))
 				rl.Refresh()
 				continue
 			}
 			if arg == "all" {
 				deleted := mem.DeleteAll()
-				fmt.Printf("✓ Deleted all %d Megram(s) — memory reset\n", deleted)
+				fmt.Printf("%s Deleted all %d Megram(s) %s memory reset\n", t.Icon("check"), deleted, t.Icon("dash"))
 			} else {
 				deleted := mem.DeleteByPrefix(arg)
 				if deleted == 0 {
 					fmt.Printf("No Megram found matching prefix %q\n", arg)
 				} else {
-					fmt.Printf("✓ Deleted %d Megram(s) matching %q\n", deleted, arg)
+					fmt.Printf("%s Deleted %d Megram(s) matching %q\n", t.Icon("check"), deleted, arg)
 				}
 			}
 			rl.Refresh()
 			continue
 		}
 
+		// /find — search past task logs by intent, status, and date.
+		// Usage: /find [words...] [status:accepted|abandoned|cancelled] [since:YYYY-MM-DD|Nd] [until:YYYY-MM-DD]
+		if input == "/find" || strings.HasPrefix(input, "/find ") {
+			rl.Clean()
+			filter, err := parseFindQuery(strings.TrimPrefix(input, "/find"), time.Now())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				rl.Refresh()
+				continue
+			}
+			found, err := tasklog.Query(filepath.Join(cacheDir, "tasks"), filter)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			} else {
+				printFindResults(found)
+			}
+			rl.Refresh()
+			continue
+		}
+
+		// /stats [--since <age>] — totals over every task log, optionally only recent runs.
+		if input == "/stats" || strings.HasPrefix(input, "/stats ") {
+			rl.Clean()
+			since, err := parseStatsSince(strings.TrimPrefix(input, "/stats"), time.Now())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				rl.Refresh()
+				continue
+			}
+			stats, err := tasklog.Aggregate(filepath.Join(cacheDir, "tasks"), since)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			} else {
+				printAggregateStats(os.Stdout, since, stats)
+			}
+			rl.Refresh()
+			continue
+		}
+
+		// /history [--tag <tag>] — list this session's turns, optionally only those with a tag.
+		if input == "/history" || strings.HasPrefix(input, "/history ") {
+			rl.Clean()
+			tag, err := parseHistoryTag(strings.TrimPrefix(input, "/history"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			} else {
+				printHistory(filterHistory(history, tag))
+			}
+			rl.Refresh()
+			continue
+		}
+
+		// /trajectory — show GGS's round-by-round loss and directives for the latest task.
+		if input == "/trajectory" {
+			rl.Clean()
+			printTrajectory(os.Stdout, lastTaskID, trajectory(lastTaskID))
+			rl.Refresh()
+			continue
+		}
+
+		// /criteria — show the latest task's criteria and the verdicts they received.
+		if input == "/criteria" {
+			rl.Clean()
+			if m, ok := manifests.forTask(lastTaskID); ok {
+				printCriteria(os.Stdout, buildCriteriaReport(m, logReg.ReadEvents(lastTaskID)))
+			} else {
+				fmt.Printf("%s(no plan recorded for the latest task)%s\n", t.Dim, t.Reset)
+			}
+			rl.Refresh()
+			continue
+		}
+
+		// /replay <taskID>|last — re-run a past task's intent as a fresh task.
+		// On success the intent replaces input and falls through to the pipeline.
+		if input == "/replay" || strings.HasPrefix(input, "/replay ") {
+			rl.Clean()
+			intent, err := resolveReplayIntent(logReg, strings.TrimPrefix(input, "/replay"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				rl.Refresh()
+				continue
+			}
+			fmt.Printf("%sreplaying: %s%s\n", t.Dim, intent, t.Reset)
+			input = intent
+		}
+
+		// /env (alias /whoami) — show the environment and configuration tasks run with.
+		if input == "/env" || input == "/whoami" {
+			rl.Clean()
+			printEnvReport(buildEnvReport(env))
+			rl.Refresh()
+			continue
+		}
+
+		// /tier-status — ping each LLM tier and report reachability and latency.
+		if input == "/tier-status" {
+			rl.Clean()
+			printTierStatus(checkTiers(ctx, env.tiers, tierPingTimeout))
+			rl.Refresh()
+			continue
+		}
+
 		// /audit — request an on-demand audit report directly from R6, bypassing the pipeline.
 		if input == "/audit" {
 			rl.Clean()
@@ -777,7 +1772,6 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 		taskCtx, tCancel := context.WithCancel(ctx)
 		taskMu.Lock()
 		taskCancel = tCancel
-		currentTaskID = "" // will be set after Process() returns the ID
 		taskMu.Unlock()
 
 		// Clean the already-printed prompt before the pipeline starts.
@@ -788,7 +1782,7 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 			// Print the question as plain output — NOT embedded in the readline prompt.
 			// A \n inside SetPrompt causes readline to miscalculate cursor position and
 			// reprint the question line on every internal redraw, flooding the terminal.
-			fmt.Printf("\033[33m?\033[0m %s\n", question)
+			fmt.Printf("%s?%s %s\n", t.Yellow, t.Reset, question)
 			// Use readLine() so the readline goroutine remains the sole rl.Readline() caller.
 			r := readLine()
 			if r.err != nil {
@@ -796,14 +1790,17 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 			}
 			return strings.TrimSpace(r.line), nil
 		}
+		if noClarify {
+			clarifyFn = perceiver.NoClarify
+		}
 
 		disp.Resume() // lift post-abort suppression before the new pipeline starts
-		p := perceiver.New(b, llmClient, clarifyFn, mem)
+		p := perceiver.NewWithTags(b, llmClient, clarifyFn, mem, tags)
+		p.SetTaskLog(logReg)
 		pr, err := p.Process(taskCtx, input, buildSessionContext(history))
 		if err != nil {
 			taskMu.Lock()
 			taskCancel = nil
-			currentTaskID = ""
 			taskMu.Unlock()
 			tCancel()
 			if taskCtx.Err() != nil {
@@ -825,7 +1822,6 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 			}
 			taskMu.Lock()
 			taskCancel = nil
-			currentTaskID = ""
 			taskMu.Unlock()
 			tCancel()
 			rl.Refresh()
@@ -834,10 +1830,9 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 
 		taskID := pr.TaskID
 		perceiverUsage := pr.Usage
-		// Register task ID so the signal handler can send it to the dispatcher on abort.
-		taskMu.Lock()
-		currentTaskID = taskID
-		taskMu.Unlock()
+		lastTaskID = taskID
+		// Register the task so Ctrl+C, SIGTERM, and the watchdogs can cancel it.
+		canceller.begin(taskID, pr.Assumptions)
 
 		// Wait for the result matching this task ID.
 		// Discard stale FinalResults from previously aborted tasks.
@@ -858,14 +1853,14 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 				// Suppress stale pipeline messages (GGS goroutines, Megram writes) until
 				// the next task starts. Abort() is a no-op on inTask=false, so no ✗ is shown.
 				disp.Abort()
-				printResult(result, input)
+				ui.RenderResult(os.Stdout, result, input)
 				stats := logReg.GetStats(result.TaskID)
 				printDecisionLog(logReg.ReadEvents(result.TaskID))
 				printCostStats(perceiverUsage, stats)
 				// Re-render the readline prompt — the display spinner overwrote
 				// it during the task, and readline doesn't know it was erased.
 				rl.Refresh()
-				history = append(history, sessionEntry{Input: input, Summary: result.Summary})
+				history = append(history, sessionEntry{Input: input, Summary: result.Summary, Tags: pr.Tags})
 				if len(history) > maxHistory {
 					history = history[len(history)-maxHistory:]
 				}
@@ -875,34 +1870,501 @@ func runREPL(ctx context.Context, b *bus.Bus, llmClient *llm.Client, resultCh <-
 				printAuditReport(rep)
 			}
 		}
-
-		taskMu.Lock()
-		taskCancel = nil
-		currentTaskID = ""
-		taskMu.Unlock()
-		tCancel()
-
-		if ctx.Err() != nil {
-			return
+
+		canceller.end(taskID)
+		taskMu.Lock()
+		taskCancel = nil
+		taskMu.Unlock()
+		tCancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		// When the task was aborted by Ctrl+C (taskCtx.Err() != nil), the readline
+		// goroutine also received the interrupt and forwarded an ErrInterrupt to rlCh.
+		// Drain it here so the next readLine() call doesn't misinterpret the task-abort
+		// Ctrl+C as an idle Ctrl+C and print a spurious "(Ctrl+C again...)" message.
+		if taskCtx.Err() != nil {
+			select {
+			case r2 := <-rlCh:
+				if r2.err != readline.ErrInterrupt {
+					// Not the expected stale interrupt — put it back for next iteration.
+					r2c := r2
+					pending = &r2c
+				}
+			case <-time.After(200 * time.Millisecond):
+				// Nothing queued — nothing to drain.
+			}
+		}
+	}
+}
+
+// toolConfirmer asks the user to approve each call to a --confirm tool.
+// Executors run in parallel, so mu keeps one question on screen at a time.
+type toolConfirmer struct {
+	mu  sync.Mutex
+	ask func(ctx context.Context, question string) (string, error) // nil until a runner installs it
+}
+
+// setAsk installs the function that puts a question to the user and reads the answer.
+func (c *toolConfirmer) setAsk(ask func(ctx context.Context, question string) (string, error)) {
+	c.mu.Lock()
+	c.ask = ask
+	c.mu.Unlock()
+}
+
+// confirm is the executor's ConfirmFunc: only an explicit "y" or "yes" approves
+// the call; any other answer, a read error, or no installed prompt declines it.
+func (c *toolConfirmer) confirm(ctx context.Context, tool, detail string) bool {
+	return c.askYes(ctx, fmt.Sprintf("Allow %s: %s ? [y/N]", tool, firstN(detail, 200)))
+}
+
+// askYes puts a yes/no question to the user; only "y" or "yes" counts as yes.
+func (c *toolConfirmer) askYes(ctx context.Context, question string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ask == nil {
+		return false
+	}
+	ans, err := c.ask(ctx, question)
+	if err != nil {
+		return false
+	}
+	ans = strings.ToLower(strings.TrimSpace(ans))
+	return ans == "y" || ans == "yes"
+}
+
+// costGate is R2's CostPreviewFunc: it shows every plan's estimate and, once the
+// estimate reaches threshold tokens, asks before the plan is dispatched.
+type costGate struct {
+	threshold int // 0 = never ask
+	confirmer *toolConfirmer
+	out       io.Writer
+}
+
+// preview prints est, or asks about it when it is at or over the threshold.
+// Declining (or having no prompt to ask with) cancels the task.
+func (g *costGate) preview(ctx context.Context, _ string, est planner.CostEstimate) bool {
+	if g.threshold <= 0 || est.Tokens < g.threshold {
+		t := ui.Active()
+		fmt.Fprint(g.out, t.ClearLine())
+		fmt.Fprintf(g.out, "%s%sPlan estimate: %s%s\n", t.Dim, t.Prefix("cost"), est, t.Reset)
+		return true
+	}
+	return g.confirmer.askYes(ctx, fmt.Sprintf("This plan may cost %s %s proceed? [y/N]", est, ui.Active().Icon("dash")))
+}
+
+// taskCanceller ends the running task early with a structured reason. Rather than
+// only cancelling contexts, it stops the dispatcher's subtasks, closes the task log
+// as "cancelled", and publishes a FinalResult with Directive "cancelled" and a
+// CancelReason, delivered through outputFn like any other result — so scripts,
+// logs, R2, R4b, and R7 can tell a cancellation from an abandon.
+//
+// It also runs two optional watchdogs: ARTOO_TASK_WALLTIME cancels a task that
+// runs too long, ARTOO_TASK_IDLE_TIMEOUT one whose bus has gone quiet.
+type taskCanceller struct {
+	b           *bus.Bus
+	logReg      *tasklog.Registry
+	outputFn    func(types.FinalResult)
+	wallTime    time.Duration // 0 = no limit
+	idleTimeout time.Duration // 0 = no limit
+
+	mu           sync.Mutex
+	taskID       string   // running task; "" when none
+	assumptions  []string // the running task's TaskSpec assumptions, for its cancelled FinalResult
+	started      time.Time
+	lastActivity time.Time
+}
+
+// newTaskCanceller reads the watchdog limits from ARTOO_TASK_WALLTIME and
+// ARTOO_TASK_IDLE_TIMEOUT (Go durations, e.g. "10m"); unset or invalid means no limit.
+func newTaskCanceller(b *bus.Bus, logReg *tasklog.Registry, outputFn func(types.FinalResult)) *taskCanceller {
+	limit := func(key string) time.Duration {
+		d, err := time.ParseDuration(os.Getenv(key))
+		if err != nil || d <= 0 {
+			return 0
+		}
+		return d
+	}
+	return &taskCanceller{
+		b:           b,
+		logReg:      logReg,
+		outputFn:    outputFn,
+		wallTime:    limit("ARTOO_TASK_WALLTIME"),
+		idleTimeout: limit("ARTOO_TASK_IDLE_TIMEOUT"),
+	}
+}
+
+// begin marks taskID as the running task and starts its watchdog clocks.
+// assumptions are R1's, carried into the FinalResult if the task is cancelled.
+func (c *taskCanceller) begin(taskID string, assumptions []string) {
+	now := time.Now()
+	c.mu.Lock()
+	c.taskID, c.assumptions, c.started, c.lastActivity = taskID, assumptions, now, now
+	c.mu.Unlock()
+}
+
+// end clears the running task once its result has been handled.
+func (c *taskCanceller) end(taskID string) {
+	c.mu.Lock()
+	if c.taskID == taskID {
+		c.taskID, c.assumptions = "", nil
+	}
+	c.mu.Unlock()
+}
+
+// cancel ends the running task with reason (a types.Cancel* constant).
+//
+// Expectations:
+//   - Returns false and publishes nothing when no task is running
+//   - Closes the task log with status "cancelled"
+//   - Publishes and delivers one FinalResult with Directive "cancelled", CancelReason set,
+//     and the task's assumptions; the dispatcher stops the task's executor/agentval goroutines on it
+//   - A second call for the same task returns false
+func (c *taskCanceller) cancel(reason string) bool {
+	c.mu.Lock()
+	taskID, assumptions := c.taskID, c.assumptions
+	c.taskID, c.assumptions = "", nil
+	c.mu.Unlock()
+	if taskID == "" {
+		return false
+	}
+	slog.Info("[MAIN] cancelling task", "task", taskID, "reason", reason)
+	c.logReg.Close(taskID, "cancelled")
+	fr := types.FinalResult{
+		TaskID:       taskID,
+		Summary:      ui.Active().Prefix("stop") + "Task cancelled: " + cancelDescription(reason),
+		Directive:    "cancelled",
+		CancelReason: reason,
+		Assumptions:  assumptions,
+	}
+	c.b.Publish(types.Message{
+		ID:        uuid.New().String(),
+		Timestamp: time.Now().UTC(),
+		From:      types.RoleUser,
+		To:        types.RoleUser,
+		Type:      types.MsgFinalResult,
+		Payload:   fr,
+	})
+	if c.outputFn != nil {
+		c.outputFn(fr)
+	}
+	return true
+}
+
+// cancelDescription is the user-facing wording for a cancel reason.
+func cancelDescription(reason string) string {
+	switch reason {
+	case types.CancelUser:
+		return "interrupted (Ctrl+C)"
+	case types.CancelIdle:
+		return "no progress within ARTOO_TASK_IDLE_TIMEOUT"
+	case types.CancelWallTime:
+		return "ran past ARTOO_TASK_WALLTIME"
+	case types.CancelSignal:
+		return "terminated by signal"
+	case types.CancelCost:
+		return "estimated cost declined"
+	}
+	return reason
+}
+
+// expired returns the watchdog reason that has fired at now, or "" when none has
+// (or no task is running). Wall time is checked before idleness.
+//
+// Expectations:
+//   - Returns "" when no task is running or both limits are 0
+//   - Returns types.CancelWallTime once now - begin reaches wallTime
+//   - Returns types.CancelIdle once now - last bus activity reaches idleTimeout
+func (c *taskCanceller) expired(now time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case c.taskID == "":
+		return ""
+	case c.wallTime > 0 && now.Sub(c.started) >= c.wallTime:
+		return types.CancelWallTime
+	case c.idleTimeout > 0 && now.Sub(c.lastActivity) >= c.idleTimeout:
+		return types.CancelIdle
+	}
+	return ""
+}
+
+// watch runs the watchdogs until ctx ends: every message on tap counts as
+// activity, and every tick cancels the running task if a limit has passed.
+// A no-op when neither limit is set.
+func (c *taskCanceller) watch(ctx context.Context, tap <-chan types.Message, tick time.Duration) {
+	if c.wallTime <= 0 && c.idleTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-tap:
+			if !ok {
+				return
+			}
+			c.mu.Lock()
+			c.lastActivity = time.Now()
+			c.mu.Unlock()
+		case now := <-ticker.C:
+			if reason := c.expired(now); reason != "" {
+				c.cancel(reason)
+			}
+		}
+	}
+}
+
+// parseHistoryTag reads the /history argument: nothing, or "--tag <tag>".
+//
+// Expectations:
+//   - Returns "" for an empty argument
+//   - Returns the tag normalised as --tag normalises it
+//   - Returns an error for anything else
+func parseHistoryTag(arg string) (string, error) {
+	fields := strings.Fields(arg)
+	switch {
+	case len(fields) == 0:
+		return "", nil
+	case len(fields) == 2 && fields[0] == "--tag":
+		if tags := perceiver.ParseTags(fields[1]); len(tags) == 1 {
+			return tags[0], nil
+		}
+	}
+	return "", fmt.Errorf("usage: /history [--tag <tag>]")
+}
+
+// filterHistory returns the session turns carrying tag, oldest first; all of
+// them when tag is "".
+//
+// Expectations:
+//   - Returns history unchanged for an empty tag
+//   - Keeps only turns whose Tags contain tag
+func filterHistory(history []sessionEntry, tag string) []sessionEntry {
+	if tag == "" {
+		return history
+	}
+	var out []sessionEntry
+	for _, e := range history {
+		if slices.Contains(e.Tags, tag) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func printHistory(history []sessionEntry) {
+	t := ui.Active()
+	if len(history) == 0 {
+		fmt.Printf("%s(no matching turns this session)%s\n", t.Dim, t.Reset)
+		return
+	}
+	fmt.Println()
+	for i, e := range history {
+		tags := ""
+		if len(e.Tags) > 0 {
+			tags = "  " + t.Dim + "#" + strings.Join(e.Tags, " #") + t.Reset
+		}
+		fmt.Printf("  %s[%d]%s %s%s\n", t.Bold, i+1, t.Reset, firstN(e.Input, 100), tags)
+		fmt.Printf("      %s%s%s\n", t.Dim, firstN(e.Summary, 120), t.Reset)
+	}
+	fmt.Println()
+}
+
+// latestManifest remembers the most recent DispatchManifest published on the bus.
+// After a replan that is the final plan, whose subtasks and task criteria decided the task.
+type latestManifest struct {
+	mu sync.Mutex
+	m  *types.DispatchManifest
+}
+
+// watch records every manifest from ch until it is closed.
+func (l *latestManifest) watch(ch <-chan types.Message) {
+	for msg := range ch {
+		raw, _ := json.Marshal(msg.Payload)
+		var m types.DispatchManifest
+		if json.Unmarshal(raw, &m) != nil {
+			continue
+		}
+		l.mu.Lock()
+		l.m = &m
+		l.mu.Unlock()
+	}
+}
+
+// forTask returns the latest manifest when it belongs to taskID.
+func (l *latestManifest) forTask(taskID string) (types.DispatchManifest, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.m == nil || taskID == "" || l.m.TaskID != taskID {
+		return types.DispatchManifest{}, false
+	}
+	return *l.m, true
+}
+
+// criteriaReport is what /criteria shows for one task.
+type criteriaReport struct {
+	TaskID       string
+	Status       string // the task_end status; "" when the log has none
+	TaskCriteria []string
+	Subtasks     []subtaskCriteria
+}
+
+// subtaskCriteria is one subtask of the final plan with its criteria.
+type subtaskCriteria struct {
+	ID       string
+	Intent   string
+	Criteria []criterionResult
+}
+
+// criterionResult is one success criterion and R4a's last verdict on it.
+type criterionResult struct {
+	Criterion string
+	Met       *bool // nil when R4a never judged it
+	Evidence  string
+}
+
+// buildCriteriaReport combines m's task criteria and subtasks with the
+// subtask_begin and criterion_verdict events of the task's log.
+//
+// Expectations:
+//   - Lists the subtasks in the manifest's order; subtasks of earlier plans are left out
+//   - Reads each subtask from the latest planning round that began it (tasklog.Rounds),
+//     so a replan reusing a subtask ID shows the new plan's criteria and verdicts
+//   - Lists each subtask's criteria from its subtask_begin event, with the verdict of its last attempt
+//   - Appends verdicts on criteria the subtask_begin event did not name, so none is hidden
+//   - Leaves Met nil for a criterion without a verdict
+//   - Takes Status from the task_end event
+func buildCriteriaReport(m types.DispatchManifest, events []tasklog.Event) criteriaReport {
+	rep := criteriaReport{TaskID: m.TaskID, TaskCriteria: m.TaskCriteria}
+	type subtaskRun struct {
+		round int
+		id    string
+	}
+	inPlan := make(map[string]bool, len(m.SubTaskIDs))
+	for _, id := range m.SubTaskIDs {
+		inPlan[id] = true
+	}
+	runs := make(map[subtaskRun]*subtaskCriteria)
+	latest := make(map[string]int) // subtask ID → latest round that began it
+	rounds := tasklog.Rounds(events)
+	for i, e := range events {
+		run := subtaskRun{rounds[i], e.SubtaskID}
+		switch e.Kind {
+		case tasklog.KindTaskEnd:
+			rep.Status = e.Status
+		case tasklog.KindSubtaskBegin:
+			if !inPlan[e.SubtaskID] || runs[run] != nil {
+				continue
+			}
+			st := &subtaskCriteria{ID: e.SubtaskID, Intent: e.Intent}
+			for _, c := range e.Criteria {
+				st.Criteria = append(st.Criteria, criterionResult{Criterion: c})
+			}
+			runs[run], latest[e.SubtaskID] = st, run.round
+		case tasklog.KindCriterionVerdict:
+			st := runs[run]
+			if st == nil {
+				continue
+			}
+			i := slices.IndexFunc(st.Criteria, func(c criterionResult) bool { return c.Criterion == e.Criterion })
+			if i < 0 {
+				st.Criteria = append(st.Criteria, criterionResult{Criterion: e.Criterion})
+				i = len(st.Criteria) - 1
+			}
+			st.Criteria[i].Met, st.Criteria[i].Evidence = e.Met, e.Evidence
+		}
+	}
+	for _, id := range m.SubTaskIDs {
+		st := runs[subtaskRun{latest[id], id}]
+		if st == nil {
+			st = &subtaskCriteria{ID: id}
 		}
+		rep.Subtasks = append(rep.Subtasks, *st)
+	}
+	return rep
+}
 
-		// When the task was aborted by Ctrl+C (taskCtx.Err() != nil), the readline
-		// goroutine also received the interrupt and forwarded an ErrInterrupt to rlCh.
-		// Drain it here so the next readLine() call doesn't misinterpret the task-abort
-		// Ctrl+C as an idle Ctrl+C and print a spurious "(Ctrl+C again...)" message.
-		if taskCtx.Err() != nil {
-			select {
-			case r2 := <-rlCh:
-				if r2.err != readline.ErrInterrupt {
-					// Not the expected stale interrupt — put it back for next iteration.
-					r2c := r2
-					pending = &r2c
-				}
-			case <-time.After(200 * time.Millisecond):
-				// Nothing queued — nothing to drain.
+// printCriteria writes rep to w: the task criteria, then each subtask's criteria
+// with its verdict and evidence.
+//
+// Expectations:
+//   - Marks task criteria "pass" only when the task was accepted; R4b judges them together, not one by one
+//   - Marks each subtask criterion "pass", "fail", or "no verdict"
+//   - Notes when the plan had no task criteria or a subtask had no criteria
+func printCriteria(w io.Writer, rep criteriaReport) {
+	t := ui.Active()
+	bold, cyan, green, red, yellow, dim, reset := t.Bold, t.Cyan, t.Green, t.Red, t.Yellow, t.Dim, t.Reset
+	fmt.Fprintf(w, "\n%s%s%sCriteria%s  %s%s%s\n\n", bold, cyan, t.Prefix("decisions"), reset, dim, rep.TaskID, reset)
+
+	fmt.Fprintf(w, "  %sTask%s %s(R4b, on the merged output)%s\n", bold, reset, dim, reset)
+	if len(rep.TaskCriteria) == 0 {
+		fmt.Fprintf(w, "    %s(none)%s\n", dim, reset)
+	}
+	for _, c := range rep.TaskCriteria {
+		if rep.Status == "accepted" {
+			fmt.Fprintf(w, "    %s%s pass%s  %s\n", green, t.Icon("check"), reset, c)
+		} else {
+			fmt.Fprintf(w, "    %s%s unconfirmed%s  %s\n", yellow, t.Icon("dash"), reset, c)
+		}
+	}
+	for _, st := range rep.Subtasks {
+		fmt.Fprintf(w, "\n  %s%s%s %s\n", bold, st.ID, reset, firstN(st.Intent, 100))
+		if len(st.Criteria) == 0 {
+			fmt.Fprintf(w, "    %s(none)%s\n", dim, reset)
+		}
+		for _, c := range st.Criteria {
+			switch {
+			case c.Met == nil:
+				fmt.Fprintf(w, "    %s%s no verdict%s  %s\n", dim, t.Icon("dash"), reset, c.Criterion)
+			case *c.Met:
+				fmt.Fprintf(w, "    %s%s pass%s  %s\n", green, t.Icon("check"), reset, c.Criterion)
+			default:
+				fmt.Fprintf(w, "    %s%s fail%s  %s\n", red, t.Icon("cross"), reset, c.Criterion)
+			}
+			if c.Evidence != "" {
+				fmt.Fprintf(w, "        %s%s%s\n", dim, firstN(c.Evidence, 160), reset)
 			}
 		}
 	}
+	fmt.Fprintln(w)
+}
+
+// printTrajectory writes taskID's GGS rounds (see ggs.GGS.Trajectory) to w as
+// an ASCII table, one row per round.
+//
+// Expectations:
+//   - Prints a hint instead of a table when there is no task yet or it has no rounds
+//   - Prints a header, then each round's number, directive, D, P, Ω, L, and signed ∇L
+//   - Uses no escape sequences inside the table, so columns line up in any terminal
+func printTrajectory(w io.Writer, taskID string, snaps []ggs.RoundSnapshot) {
+	t := ui.Active()
+	if taskID == "" {
+		fmt.Fprintf(w, "%s(no task yet this session)%s\n", t.Dim, t.Reset)
+		return
+	}
+	if len(snaps) == 0 {
+		fmt.Fprintf(w, "%s(no GGS rounds recorded for %s)%s\n", t.Dim, taskID, t.Reset)
+		return
+	}
+	fmt.Fprintf(w, "\n%s%s%sTrajectory%s  %s%s%s\n\n", t.Bold, t.Cyan, t.Prefix("decisions"), t.Reset, t.Dim, taskID, t.Reset)
+	const row = "  | %5s | %-15s | %5s | %5s | %5s | %5s | %6s |\n"
+	rule := "  +" + strings.Repeat("-", 7) + "+" + strings.Repeat("-", 17) + "+" +
+		strings.Repeat(strings.Repeat("-", 7)+"+", 4) + strings.Repeat("-", 8) + "+\n"
+	fmt.Fprint(w, rule)
+	fmt.Fprintf(w, row, "round", "directive", "D", "P", "Ω", "L", "∇L")
+	fmt.Fprint(w, rule)
+	for _, s := range snaps {
+		fmt.Fprintf(w, row, strconv.Itoa(s.Round), s.Directive,
+			fmt.Sprintf("%.2f", s.Loss.D), fmt.Sprintf("%.2f", s.Loss.P), fmt.Sprintf("%.2f", s.Loss.Omega),
+			fmt.Sprintf("%.2f", s.Loss.L), fmt.Sprintf("%+.2f", s.GradL))
+	}
+	fmt.Fprint(w, rule)
+	fmt.Fprintln(w)
 }
 
 // buildSessionContext formats the last N REPL turns into a concise string
@@ -925,47 +2387,6 @@ func firstN(s string, n int) string {
 	return s[:n] + "..."
 }
 
-func printResult(result types.FinalResult, rawInput string) {
-	const (
-		bold  = "\033[1m"
-		green = "\033[32m"
-		dim   = "\033[2m"
-		reset = "\033[0m"
-	)
-	fmt.Printf("\n%s%s🤖 Result%s\n", bold, green, reset)
-	if rawInput != "" {
-		fmt.Printf("%s  › %s%s\n", dim, ui.ClipQuestion(rawInput), reset)
-	}
-	fmt.Println(result.Summary)
-	if result.Output == nil {
-		return
-	}
-	// If output is a plain string, print it directly so \n renders as newlines
-	// rather than being JSON-encoded to visible "\n" escape sequences.
-	// After bus/JSON round-trips, string values surface as either Go string
-	// or interface{} containing string — handle both.
-	b, err := json.Marshal(result.Output)
-	if err != nil {
-		fmt.Println(result.Output)
-		return
-	}
-	var s string
-	if json.Unmarshal(b, &s) == nil {
-		// It's a string — print with real newlines, not JSON escapes.
-		if s != result.Summary {
-			fmt.Println(s)
-		}
-		return
-	}
-	// Structured output (object/array) — pretty-print as indented JSON.
-	var pretty []byte
-	if pretty, err = json.MarshalIndent(result.Output, "", "  "); err == nil {
-		fmt.Println(string(pretty))
-	} else {
-		fmt.Println(result.Output)
-	}
-}
-
 // printCostStats prints a per-role LLM + tool usage summary after each task.
 // Suppressed entirely when there are no stats and no perceiver tokens.
 func printCostStats(perceiverUsage llm.Usage, stats *tasklog.TaskStats) {
@@ -975,13 +2396,9 @@ func printCostStats(perceiverUsage llm.Usage, stats *tasklog.TaskStats) {
 		return
 	}
 
-	const (
-		bold  = "\033[1m"
-		cyan  = "\033[36m"
-		dim   = "\033[2m"
-		reset = "\033[0m"
-	)
-	fmt.Printf("\n%s%s📊 Cost%s\n", bold, cyan, reset)
+	t := ui.Active()
+	bold, cyan, dim, reset := t.Bold, t.Cyan, t.Dim, t.Reset
+	fmt.Printf("\n%s%s%sCost%s\n", bold, cyan, t.Prefix("cost"), reset)
 
 	// formatToks formats a token count with thousands separator.
 	formatToks := func(n int) string {
@@ -1025,7 +2442,7 @@ func printCostStats(perceiverUsage llm.Usage, stats *tasklog.TaskStats) {
 		}
 	}
 
-	fmt.Printf("  %s──────────────────────────────────────────────────%s\n", dim, reset)
+	fmt.Printf("  %s%s%s\n", dim, t.Rule(50), reset)
 
 	// Tool execution row (wall-clock time, no tokens).
 	if stats != nil && stats.ToolCallCount > 0 {
@@ -1036,7 +2453,7 @@ func printCostStats(perceiverUsage llm.Usage, stats *tasklog.TaskStats) {
 		}
 		fmt.Printf("  %-12s %2d %-6s %10s      %6.1fs\n",
 			"tools", stats.ToolCallCount, toolWord, "(exec)", toolSecs)
-		fmt.Printf("  %s──────────────────────────────────────────────────%s\n", dim, reset)
+		fmt.Printf("  %s%s%s\n", dim, t.Rule(50), reset)
 	}
 
 	printLLMRow("total (LLM)", totalLLMCalls, totalToks, totalLLMMs)
@@ -1044,13 +2461,13 @@ func printCostStats(perceiverUsage llm.Usage, stats *tasklog.TaskStats) {
 }
 
 // printDecisionLog prints a compact summary of memory calibration, GGS decisions,
-// and plan directives from the task JSONL log. Called after each completed task.
+// plan directives, and plan diffs from the task JSONL log. Called after each completed task.
 func printDecisionLog(events []tasklog.Event) {
-	// Filter to the three decision-relevant event kinds.
+	// Filter to the decision-relevant event kinds.
 	var relevant []tasklog.Event
 	for _, e := range events {
 		switch e.Kind {
-		case tasklog.KindMemoryQuery, tasklog.KindGGSDecision, tasklog.KindPlanDirective:
+		case tasklog.KindMemoryQuery, tasklog.KindGGSDecision, tasklog.KindPlanDirective, tasklog.KindPlanDiff:
 			relevant = append(relevant, e)
 		}
 	}
@@ -1058,17 +2475,10 @@ func printDecisionLog(events []tasklog.Event) {
 		return
 	}
 
-	const (
-		bold   = "\033[1m"
-		cyan   = "\033[36m"
-		green  = "\033[32m"
-		yellow = "\033[33m"
-		red    = "\033[31m"
-		dim    = "\033[2m"
-		reset  = "\033[0m"
-	)
+	t := ui.Active()
+	bold, cyan, green, yellow, red, dim, reset := t.Bold, t.Cyan, t.Green, t.Yellow, t.Red, t.Dim, t.Reset
 
-	fmt.Printf("\n%s%s📐 Decisions%s\n", bold, cyan, reset)
+	fmt.Printf("\n%s%s%sDecisions%s\n", bold, cyan, t.Prefix("decisions"), reset)
 
 	for _, e := range relevant {
 		switch e.Kind {
@@ -1103,11 +2513,11 @@ func printDecisionLog(events []tasklog.Event) {
 			if e.ReplanRound > 0 {
 				round = fmt.Sprintf("  rd=%d", e.ReplanRound)
 			}
-			fmt.Printf("  %sgss%s%s      D=%.2f  P=%.2f  Ω=%.2f  L=%.2f  ∇L=%s%.3f  %s→ %s%s\n",
+			fmt.Printf("  %sgss%s%s      D=%.2f  P=%.2f  %s=%.2f  L=%.2f  %s=%s%.3f  %s%s %s%s\n",
 				dim, reset, round,
-				e.D, e.P, e.Omega, e.L,
-				gradSign, e.GradL,
-				dirCol, e.Directive, reset)
+				e.D, e.P, t.Icon("omega"), e.Omega, e.L,
+				t.Icon("grad"), gradSign, e.GradL,
+				dirCol, t.Icon("arrow"), e.Directive, reset)
 
 		case tasklog.KindPlanDirective:
 			parts := []string{}
@@ -1126,59 +2536,274 @@ func printDecisionLog(events []tasklog.Event) {
 				detail = "  " + strings.Join(parts, "  ")
 			}
 			fmt.Printf("  %splan%s      %s%s\n", dim, reset, e.Directive, detail)
+
+		case tasklog.KindPlanDiff:
+			fmt.Printf("  %sdiff%s      %s+%d%s %s-%d%s %s~%d%s =%d\n", dim, reset,
+				green, len(e.Added), reset, red, len(e.Removed), reset, yellow, len(e.Changed), reset, e.Unchanged)
+			for _, a := range e.Added {
+				fmt.Printf("            %s+ %s%s\n", green, a, reset)
+			}
+			for _, r := range e.Removed {
+				fmt.Printf("            %s- %s%s\n", red, r, reset)
+			}
+			for _, c := range e.Changed {
+				fmt.Printf("            %s~ %s%s\n", yellow, c, reset)
+			}
 		}
 	}
 	fmt.Println()
 }
 
 func printHelp() {
-	const (
-		b = "\033[1m"    // bold
-		c = "\033[36m"   // cyan
-		d = "\033[2m"    // dim
-		r = "\033[0m"    // reset
-	)
+	t := ui.Active()
+	b, c, d, r := t.Bold, t.Cyan, t.Dim, t.Reset
 	fmt.Println()
-	fmt.Println(b + c + "🤖 Artoo Commands" + r)
+	fmt.Println(b + c + t.Prefix("robot") + "Artoo Commands" + r)
 	fmt.Println()
 	fmt.Println(b + "/help" + r + "                    Show this help message")
 	fmt.Println()
 	fmt.Println(b + c + "Memory" + r)
 	fmt.Println("  " + b + "/memory" + r + "                Show MKCT pyramid summary (level counts, C-level SOPs)")
 	fmt.Println("  " + b + "/memory verbose" + r + "        Show all Megrams with metadata and content")
+	fmt.Println("  " + b + "/memory clear" + r + "          Wipe ALL memory keys after confirmation")
+	fmt.Println("  " + b + "/memory forget" + r + " <task>  Forget the lessons recorded for one kind of task (by intent)")
 	fmt.Println("  " + b + "/remember" + r + " <content>    Inject a C-level memory at global:user (recalled on every task)")
 	fmt.Println("  " + b + "/remember" + r + " <level> ...  Inject at specific level (M/K/C/T), optionally with space tag")
-	fmt.Println("      " + d + "/remember My name is Artoo" + r + "                        → C, global:user")
-	fmt.Println("      " + d + "/remember T Always respond helpfully" + r + "               → T, global:user")
-	fmt.Println("      " + d + "/remember M intent:weather mdfind works for CJK" + r + "    → M, intent:weather")
+	fmt.Println("      " + d + "/remember My name is Artoo" + r + "                        " + t.Icon("arrow") + " C, global:user")
+	fmt.Println("      " + d + "/remember T Always respond helpfully" + r + "               " + t.Icon("arrow") + " T, global:user")
+	fmt.Println("      " + d + "/remember M intent:weather mdfind works for CJK" + r + "    " + t.Icon("arrow") + " M, intent:weather")
 	fmt.Println("  " + b + "/forget" + r + " <id-prefix>    Delete Megram(s) by ID prefix (from /memory verbose)")
 	fmt.Println("  " + b + "/forget all" + r + "            Delete ALL Megrams (full memory reset)")
 	fmt.Println()
 	fmt.Println(b + c + "System" + r)
 	fmt.Println("  " + b + "/audit" + r + "                 Request an on-demand audit report from R6")
+	fmt.Println("  " + b + "/env" + r + "                   Show OS, paths, LLM tiers, available tools, memory, and budget (alias /whoami)")
+	fmt.Println("  " + b + "/tier-status" + r + "           Ping each LLM tier: reachable, latency, model; search and embeddings setup")
+	fmt.Println("  " + b + "/find" + r + " <query>         Find past tasks; words match intent, plus status:, tag:, since:, until: filters")
+	fmt.Println("  " + b + "/stats" + r + " [--since <age>] Totals over past tasks: runs by status, tokens, time, replans (age: 24h, 7d)")
+	fmt.Println("  " + b + "/history" + r + " [--tag <t>]  List this session's turns, optionally only those tagged <t>")
+	fmt.Println("  " + b + "/replay" + r + " <id>|last     Re-run a past task's intent (from its task log) as a fresh task")
+	fmt.Println("  " + b + "/criteria" + r + "              Show the latest task's criteria and each one's pass/fail verdict")
+	fmt.Println("  " + b + "/trajectory" + r + "            Show the latest task's rounds: directive, D, P, Ω, L, ∇L")
+	fmt.Println("      " + d + "/find search status:accepted since:7d" + r + "              " + t.Icon("arrow") + " accepted tasks mentioning \"search\" this week")
 	fmt.Println("  " + b + "Ctrl+C" + r + "                 Abort current task (REPL stays alive)")
 	fmt.Println("  " + b + "Ctrl+D" + r + "                 Exit REPL")
 	fmt.Println()
 }
 
+// envSources are the inputs /env reports on; main wires the live ones.
+type envSources struct {
+	tiers         []*llm.Client
+	registry      *tools.Registry
+	available     func(tool string) (ok bool, reason string) // tools.Available outside tests
+	memoryPath    string                                     // "" when memory is disabled
+	dataDir       string
+	verdictPolicy agentval.VerdictPolicy
+}
+
+// tierStatus is one LLM tier in the /env report. Problem is the Validate error, if any.
+type tierStatus struct {
+	Name, Model, BaseURL, Problem string
+}
+
+// toolStatus is one registered tool in the /env report.
+type toolStatus struct {
+	Name      string
+	Available bool
+	Reason    string // why the tool cannot run; empty when Available
+}
+
+// envReport is the diagnostic snapshot printed by /env.
+type envReport struct {
+	OS, Arch      string
+	CWD           string
+	Workspace     string
+	DataDir       string
+	Tiers         []tierStatus
+	Tools         []toolStatus
+	Memory        string // memory store path; "" when disabled
+	MaxReplans    int
+	TimeBudget    time.Duration
+	VerdictPolicy agentval.VerdictPolicy
+}
+
+// buildEnvReport assembles what the shell sees: runtime, paths, LLM tiers,
+// registered tools with their availability, memory, and the GGS task budget.
+//
+// Expectations:
+//   - Reports runtime OS/arch, the working directory, and tools.WorkspaceDir()
+//   - Lists each tier's label, model, and base URL, with its Validate error as Problem
+//   - Lists every registered tool in registry order with its availability and reason
+//   - Reports memoryPath as-is ("" means memory is disabled)
+//   - Reports the GGS replan and time budget from ggs.Budget
+func buildEnvReport(src envSources) envReport {
+	cwd, err := os.Getwd()
+	if err != nil {
+		cwd = "(unknown: " + err.Error() + ")"
+	}
+	maxReplans, timeBudget := ggs.Budget()
+	rep := envReport{
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		CWD:           cwd,
+		Workspace:     tools.WorkspaceDir(),
+		DataDir:       src.dataDir,
+		Memory:        src.memoryPath,
+		MaxReplans:    maxReplans,
+		TimeBudget:    timeBudget,
+		VerdictPolicy: src.verdictPolicy,
+	}
+	for _, c := range src.tiers {
+		ts := tierStatus{Name: c.Label(), Model: c.Model(), BaseURL: c.BaseURL()}
+		if err := c.Validate(); err != nil {
+			ts.Problem = err.Error()
+		}
+		rep.Tiers = append(rep.Tiers, ts)
+	}
+	if src.registry != nil {
+		for _, tl := range src.registry.Tools() {
+			ok, reason := true, ""
+			if src.available != nil {
+				ok, reason = src.available(tl.Name())
+			}
+			rep.Tools = append(rep.Tools, toolStatus{Name: tl.Name(), Available: ok, Reason: reason})
+		}
+	}
+	return rep
+}
+
+func printEnvReport(rep envReport) {
+	t := ui.Active()
+	bold, cyan, green, red, yellow, dim, reset := t.Bold, t.Cyan, t.Green, t.Red, t.Yellow, t.Dim, t.Reset
+	fmt.Printf("\n%s%s%sEnvironment%s\n\n", bold, cyan, t.Prefix("robot"), reset)
+	fmt.Printf("  %-12s %s/%s\n", "platform", rep.OS, rep.Arch)
+	fmt.Printf("  %-12s %s\n", "cwd", rep.CWD)
+	fmt.Printf("  %-12s %s\n", "workspace", rep.Workspace)
+	fmt.Printf("  %-12s %s\n", "data dir", rep.DataDir)
+
+	fmt.Printf("\n%s%sLLM tiers%s\n", bold, cyan, reset)
+	for _, ts := range rep.Tiers {
+		if ts.Problem != "" {
+			fmt.Printf("  %-12s %s%s%s\n", ts.Name, yellow, ts.Problem, reset)
+			continue
+		}
+		fmt.Printf("  %-12s %s %s(%s)%s\n", ts.Name, ts.Model, dim, ts.BaseURL, reset)
+	}
+
+	fmt.Printf("\n%s%sTools%s\n", bold, cyan, reset)
+	for _, tl := range rep.Tools {
+		if tl.Available {
+			fmt.Printf("  %s%s%s %s\n", green, t.Icon("check"), reset, tl.Name)
+		} else {
+			fmt.Printf("  %s%s%s %s  %s%s%s\n", red, t.Icon("cross"), reset, tl.Name, dim, tl.Reason, reset)
+		}
+	}
+
+	fmt.Printf("\n%s%sRun settings%s\n", bold, cyan, reset)
+	if rep.Memory != "" {
+		fmt.Printf("  %-12s enabled %s(%s)%s\n", "memory", dim, rep.Memory, reset)
+	} else {
+		fmt.Printf("  %-12s %sdisabled%s\n", "memory", yellow, reset)
+	}
+	fmt.Printf("  %-12s %d replans, %s per task\n", "GGS budget", rep.MaxReplans, rep.TimeBudget)
+	fmt.Printf("  %-12s %s\n", "verdicts", rep.VerdictPolicy)
+	fmt.Println()
+}
+
+// tierPingTimeout bounds each tier's health-check ping.
+const tierPingTimeout = 15 * time.Second
+
+// tierCheck is one LLM tier in the /tier-status report.
+type tierCheck struct {
+	Name, BaseURL string
+	Model         string // the model that answered; the configured one when unreachable
+	Reachable     bool
+	Latency       time.Duration
+	Problem       string // why the tier is unreachable; empty when Reachable
+}
+
+// tierStatusReport is what /tier-status and --check print.
+type tierStatusReport struct {
+	Tiers      []tierCheck
+	Search     string // web search backend the search tool uses
+	Embeddings string // how memory recall matches entries
+}
+
+// allReachable reports whether every tier answered its ping.
+func (r tierStatusReport) allReachable() bool {
+	for _, tc := range r.Tiers {
+		if !tc.Reachable {
+			return false
+		}
+	}
+	return true
+}
+
+// checkTiers pings every tier at once, each bounded by timeout, and reports
+// the results in tier order alongside the search and embedding setup.
+//
+// Expectations:
+//   - Marks a tier reachable with its latency and answering model when its ping succeeds
+//   - Marks a tier unreachable with the ping error as Problem otherwise (bad URL, bad key, missing config)
+//   - Keeps the tiers in the order given
+//   - Reports the search backend from tools.SearchBackend
+func checkTiers(ctx context.Context, tiers []*llm.Client, timeout time.Duration) tierStatusReport {
+	rep := tierStatusReport{
+		Tiers:      make([]tierCheck, len(tiers)),
+		Search:     tools.SearchBackend(),
+		Embeddings: "none (memory recall matches space and entity tags)",
+	}
+	var wg sync.WaitGroup
+	for i, c := range tiers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			tc := tierCheck{Name: c.Label(), BaseURL: c.BaseURL(), Model: c.Model()}
+			res, err := c.Ping(pctx)
+			if err != nil {
+				tc.Problem = err.Error()
+			} else {
+				tc.Reachable, tc.Latency, tc.Model = true, res.Latency, res.Model
+			}
+			rep.Tiers[i] = tc
+		}()
+	}
+	wg.Wait()
+	return rep
+}
+
+func printTierStatus(rep tierStatusReport) {
+	t := ui.Active()
+	bold, cyan, green, red, dim, reset := t.Bold, t.Cyan, t.Green, t.Red, t.Dim, t.Reset
+	fmt.Printf("\n%s%s%sTier status%s\n\n", bold, cyan, t.Prefix("robot"), reset)
+	for _, tc := range rep.Tiers {
+		if tc.Reachable {
+			fmt.Printf("  %s%s%s %-8s %s  %s%s (%s)%s\n", green, t.Icon("check"), reset, tc.Name, tc.Model,
+				dim, tc.Latency.Round(time.Millisecond), tc.BaseURL, reset)
+			continue
+		}
+		fmt.Printf("  %s%s%s %-8s %sunreachable: %s%s\n", red, t.Icon("cross"), reset, tc.Name, red, firstN(tc.Problem, 200), reset)
+	}
+	fmt.Println()
+	fmt.Printf("  %-12s %s\n", "search", rep.Search)
+	fmt.Printf("  %-12s %s\n", "embeddings", rep.Embeddings)
+	fmt.Println()
+}
+
 func printMemorySummary(s types.MemorySummary) {
-	const (
-		bold  = "\033[1m"
-		cyan  = "\033[36m"
-		green = "\033[32m"
-		red   = "\033[31m"
-		dim   = "\033[2m"
-		reset = "\033[0m"
-	)
+	t := ui.Active()
+	bold, cyan, green, red, dim, reset := t.Bold, t.Cyan, t.Green, t.Red, t.Dim, t.Reset
 	total := s.LevelCounts["M"] + s.LevelCounts["K"] + s.LevelCounts["C"] + s.LevelCounts["T"]
-	fmt.Printf("\n%s%s📦 MKCT Memory Summary%s  %s%d Megrams total%s\n",
-		bold, cyan, reset, dim, total, reset)
+	fmt.Printf("\n%s%s%sMKCT Memory Summary%s  %s%d Megrams total%s\n",
+		bold, cyan, t.Prefix("memory"), reset, dim, total, reset)
 
 	type row struct{ lvl, desc string }
 	rows := []row{
 		{"M", "episodic facts       (short half-life, raw events)"},
 		{"K", "task-scoped cache    (pruned by Dreamer GC)"},
-		{"C", "timeless SOPs ★      (promoted patterns, k=0.0)"},
+		{"C", "timeless SOPs " + t.Icon("star") + "      (promoted patterns, k=0.0)"},
 		{"T", "system persona       (hardcoded in system prompt)"},
 	}
 	for _, r := range rows {
@@ -1194,7 +2819,7 @@ func printMemorySummary(s types.MemorySummary) {
 	}
 
 	if len(s.CLevel) == 0 {
-		fmt.Printf("\n  %sNo C-level entries yet — Dreamer consolidation pending.%s\n", dim, reset)
+		fmt.Printf("\n  %sNo C-level entries yet %s Dreamer consolidation pending.%s\n", dim, t.Icon("dash"), reset)
 	} else {
 		fmt.Printf("\n  %sC-level entries (promoted knowledge):%s\n", bold, reset)
 		for _, rec := range s.CLevel {
@@ -1205,7 +2830,7 @@ func printMemorySummary(s types.MemorySummary) {
 			}
 			content := rec.Content
 			if len(content) > 80 {
-				content = content[:80] + "…"
+				content = content[:80] + t.Icon("ellipsis")
 			}
 			fmt.Printf("  %s%s%s  [%s / %s]\n      %s\n",
 				col, sigStr, reset, rec.Space, rec.Entity, content)
@@ -1215,24 +2840,17 @@ func printMemorySummary(s types.MemorySummary) {
 }
 
 func printMemorySummaryVerbose(s types.MemorySummary) {
-	const (
-		bold   = "\033[1m"
-		cyan   = "\033[36m"
-		green  = "\033[32m"
-		red    = "\033[31m"
-		yellow = "\033[33m"
-		dim    = "\033[2m"
-		reset  = "\033[0m"
-	)
+	t := ui.Active()
+	bold, cyan, green, red, yellow, dim, reset := t.Bold, t.Cyan, t.Green, t.Red, t.Yellow, t.Dim, t.Reset
 	total := s.LevelCounts["M"] + s.LevelCounts["K"] + s.LevelCounts["C"] + s.LevelCounts["T"]
-	fmt.Printf("\n%s%s📦 MKCT Memory — verbose%s  %s%d Megrams total%s\n",
-		bold, cyan, reset, dim, total, reset)
+	fmt.Printf("\n%s%s%sMKCT Memory %s verbose%s  %s%d Megrams total%s\n",
+		bold, cyan, t.Prefix("memory"), t.Icon("dash"), reset, dim, total, reset)
 
 	levelOrder := []string{"M", "K", "C", "T"}
 	levelDesc := map[string]string{
 		"M": "episodic facts",
 		"K": "task-scoped cache",
-		"C": "timeless SOPs ★",
+		"C": "timeless SOPs " + t.Icon("star"),
 		"T": "system persona",
 	}
 
@@ -1249,8 +2867,8 @@ func printMemorySummaryVerbose(s types.MemorySummary) {
 		if count > 0 {
 			marker = reset
 		}
-		fmt.Printf("\n%s── %s%s  %s%s%s  %s(%d entries)%s\n",
-			bold, lvl, reset,
+		fmt.Printf("\n%s%s %s%s  %s%s%s  %s(%d entries)%s\n",
+			bold, t.Rule(2), lvl, reset,
 			marker, levelDesc[lvl], reset,
 			dim, count, reset)
 
@@ -1269,20 +2887,20 @@ func printMemorySummaryVerbose(s types.MemorySummary) {
 			case "Caution":
 				actionCol = yellow
 			}
-			fmt.Printf("  %s[%s / %s]%s  att=%.2f  dec=%+.2f  %s→ %s%s\n",
+			fmt.Printf("  %s[%s / %s]%s  att=%.2f  dec=%+.2f  %s%s %s%s\n",
 				bold, g.Space, g.Entity, reset,
 				g.Attention, g.Decision,
-				actionCol, g.Action, reset)
+				actionCol, t.Icon("arrow"), g.Action, reset)
 
 			for _, r := range g.Megrams {
 				age := relativeTime(r.CreatedAt)
-				fmt.Printf("    %-18s  σ=%+.1f  f=%.2f  k=%.2f  att=%.2f  dec=%+.2f  %s%s%s\n",
-					r.State, r.Sigma, r.F, r.K, r.Attention, r.Decision,
+				fmt.Printf("    %-18s  %s=%+.1f  f=%.2f  k=%.2f  att=%.2f  dec=%+.2f  %s%s%s\n",
+					r.State, t.Icon("sigma"), r.Sigma, r.F, r.K, r.Attention, r.Decision,
 					dim, age, reset)
 				if r.Content != "" {
 					content := r.Content
 					if len(content) > 120 {
-						content = content[:120] + "…"
+						content = content[:120] + t.Icon("ellipsis")
 					}
 					fmt.Printf("      %s%s%s\n", dim, content, reset)
 				}
@@ -1292,6 +2910,457 @@ func printMemorySummaryVerbose(s types.MemorySummary) {
 	fmt.Println()
 }
 
+// resolveReplayIntent returns the intent /replay re-runs: that of the task log
+// named by arg, or of the most recently modified log when arg is "last".
+//
+// Expectations:
+//   - Returns a usage error when arg is blank
+//   - "last" resolves to the newest task log in reg's directory
+//   - Propagates LoadIntent's error for a missing or malformed log
+func resolveReplayIntent(reg *tasklog.Registry, arg string) (string, error) {
+	taskID := strings.TrimSpace(arg)
+	if taskID == "" {
+		return "", fmt.Errorf("usage: /replay <taskID>|last")
+	}
+	if taskID == "last" {
+		id, err := reg.LatestTaskID()
+		if err != nil {
+			return "", err
+		}
+		taskID = id
+	}
+	return reg.LoadIntent(taskID)
+}
+
+// findResultLimit caps how many matches /find prints; the newest come first.
+const findResultLimit = 20
+
+// parseFindQuery turns a /find argument into a tasklog.QueryFilter.
+// Plain words form the intent substring; status:, tag:, since: and until: set the other fields.
+// since: accepts YYYY-MM-DD or Nd (N days before now); until: YYYY-MM-DD includes that whole day.
+//
+// Expectations:
+//   - Empty query returns the zero filter (match all)
+//   - status: accepts only accepted, abandoned, or cancelled
+//   - Unparseable dates return an error naming the token
+func parseFindQuery(q string, now time.Time) (tasklog.QueryFilter, error) {
+	var f tasklog.QueryFilter
+	var words []string
+	for _, tok := range strings.Fields(q) {
+		key, val, ok := strings.Cut(tok, ":")
+		switch {
+		case ok && key == "status":
+			if val != "accepted" && val != "abandoned" && val != "cancelled" {
+				return f, fmt.Errorf("status must be accepted, abandoned, or cancelled, got %q", val)
+			}
+			f.Status = val
+		case ok && key == "tag":
+			tags := perceiver.ParseTags(val)
+			if len(tags) != 1 {
+				return f, fmt.Errorf("invalid %q: want tag:<name>", tok)
+			}
+			f.Tag = tags[0]
+		case ok && key == "since":
+			if days, ok := strings.CutSuffix(val, "d"); ok {
+				n, err := strconv.Atoi(days)
+				if err != nil || n < 0 {
+					return f, fmt.Errorf("invalid %q: want YYYY-MM-DD or Nd", tok)
+				}
+				f.Since = now.AddDate(0, 0, -n)
+				continue
+			}
+			d, err := time.ParseInLocation("2006-01-02", val, time.Local)
+			if err != nil {
+				return f, fmt.Errorf("invalid %q: want YYYY-MM-DD or Nd", tok)
+			}
+			f.Since = d
+		case ok && key == "until":
+			d, err := time.ParseInLocation("2006-01-02", val, time.Local)
+			if err != nil {
+				return f, fmt.Errorf("invalid %q: want YYYY-MM-DD", tok)
+			}
+			f.Until = d.AddDate(0, 0, 1)
+		default:
+			words = append(words, tok)
+		}
+	}
+	f.Intent = strings.Join(words, " ")
+	return f, nil
+}
+
+func printFindResults(found []tasklog.TaskSummary) {
+	t := ui.Active()
+	bold, cyan, green, red, yellow, dim, reset := t.Bold, t.Cyan, t.Green, t.Red, t.Yellow, t.Dim, t.Reset
+	if len(found) == 0 {
+		fmt.Printf("%s(no matching tasks)%s\n", dim, reset)
+		return
+	}
+	fmt.Printf("\n%s%s%sTasks%s %s(%d found)%s\n\n", bold, cyan, t.Prefix("robot"), reset, dim, len(found), reset)
+	for i, s := range found {
+		if i == findResultLimit {
+			fmt.Printf("  %s%s %d more; narrow the query%s\n", dim, t.Icon("ellipsis"), len(found)-findResultLimit, reset)
+			break
+		}
+		status := yellow + "incomplete" + reset
+		switch s.Status {
+		case "accepted":
+			status = green + s.Status + reset
+		case "abandoned":
+			status = red + s.Status + reset
+		case "cancelled":
+			status = dim + s.Status + reset
+		}
+		fmt.Printf("  %s%s%s  %s  %s%s%s\n", bold, s.TaskID, reset, status, dim, relativeTime(s.Started.Format(time.RFC3339)), reset)
+		fmt.Printf("    %s\n", firstN(s.Intent, 100))
+		if len(s.Tags) > 0 {
+			fmt.Printf("    %s#%s%s\n", dim, strings.Join(s.Tags, " #"), reset)
+		}
+		if s.Status != "" {
+			sep := t.Icon("sep")
+			fmt.Printf("    %s%.1fs %s %d tokens %s %d tool calls%s\n", dim, float64(s.ElapsedMs)/1000, sep, s.TotalTokens, sep, s.ToolCallCount, reset)
+		}
+	}
+	fmt.Println()
+}
+
+// parseStatsSince parses /stats's argument: nothing, or --since with a Go
+// duration (24h, 90m) or a day count (7d), measured back from now.
+//
+// Expectations:
+//   - Returns the zero time for an empty argument (all runs)
+//   - Returns now minus the age for --since <duration> or --since <N>d
+//   - Returns a usage error for anything else, including a negative age
+func parseStatsSince(arg string, now time.Time) (time.Time, error) {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		return time.Time{}, nil
+	}
+	if len(fields) == 2 && fields[0] == "--since" {
+		if days, ok := strings.CutSuffix(fields[1], "d"); ok {
+			if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+				return now.AddDate(0, 0, -n), nil
+			}
+		} else if d, err := time.ParseDuration(fields[1]); err == nil && d >= 0 {
+			return now.Add(-d), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("usage: /stats [--since <age>], e.g. --since 24h or --since 7d")
+}
+
+// printAggregateStats renders /stats: run counts by status and the totals of
+// tokens, wall-clock time, tool calls, subtasks, corrections, and replans.
+func printAggregateStats(w io.Writer, since time.Time, s tasklog.AggregateStats) {
+	t := ui.Active()
+	scope := "all tasks"
+	if !since.IsZero() {
+		scope = "since " + since.Format("2006-01-02 15:04")
+	}
+	if s.Runs == 0 {
+		fmt.Fprintf(w, "%s(no tasks recorded, %s)%s\n", t.Dim, scope, t.Reset)
+		return
+	}
+	fmt.Fprintf(w, "\n%s%s%sStats%s  %s%s%s\n\n", t.Bold, t.Cyan, t.Prefix("robot"), t.Reset, t.Dim, scope, t.Reset)
+	fmt.Fprintf(w, "  runs         %d  %s(%s%d accepted%s, %s%d abandoned%s, %d cancelled, %s%d incomplete%s)%s\n",
+		s.Runs, t.Dim, t.Green, s.ByStatus["accepted"], t.Dim, t.Red, s.ByStatus["abandoned"], t.Dim,
+		s.ByStatus["cancelled"], t.Yellow, s.ByStatus[""], t.Dim, t.Reset)
+	fmt.Fprintf(w, "  tokens       %d  %s(%d per run)%s\n", s.TotalTokens, t.Dim, s.TotalTokens/s.Runs, t.Reset)
+	fmt.Fprintf(w, "  time         %.1fs  %s(%.1fs per run)%s\n", float64(s.ElapsedMs)/1000, t.Dim, float64(s.ElapsedMs)/1000/float64(s.Runs), t.Reset)
+	fmt.Fprintf(w, "  tool calls   %d\n", s.ToolCalls)
+	fmt.Fprintf(w, "  subtasks     %d\n", s.Subtasks)
+	fmt.Fprintf(w, "  corrections  %d\n", s.Corrections)
+	fmt.Fprintf(w, "  replans      %d\n", s.Replans)
+	fmt.Fprintln(w)
+}
+
+// loadReplay reads the llm_call events at path (a task log or a directory of them)
+// into an llm.Replay for --replay-llm.
+func loadReplay(path string, live bool) (*llm.Replay, error) {
+	events, err := tasklog.ReadLLMCalls(path)
+	if err != nil {
+		return nil, err
+	}
+	calls := make([]llm.RecordedCall, len(events))
+	for i, e := range events {
+		calls[i] = llm.RecordedCall{
+			Role:             e.Role,
+			System:           e.SystemPrompt,
+			User:             e.UserPrompt,
+			Response:         e.Response,
+			PromptTokens:     e.PromptTokens,
+			CompletionTokens: e.CompletionTokens,
+		}
+	}
+	return llm.NewReplay(calls, live), nil
+}
+
+// Entries of an --export-session archive.
+const (
+	bundleManifest = "manifest.json" // sessionManifest
+	bundleTaskLog  = "task.jsonl"    // the task's log, as written under tasks/
+	bundleMemory   = "memory.jsonl"  // one Megram per line
+	bundleConfig   = "config.json"   // sessionConfig
+	bundleBusTrace = "bus.jsonl"     // one bus Message per line, in publish order
+)
+
+// sessionManifest describes an --export-session archive.
+type sessionManifest struct {
+	TaskID      string   `json:"task_id"`
+	CreatedAt   string   `json:"created_at"`
+	Files       []string `json:"files"`
+	Megrams     int      `json:"megrams"`
+	BusMessages int      `json:"bus_messages"`
+}
+
+// sessionConfig is the effective configuration recorded in a session bundle.
+type sessionConfig struct {
+	Args     []string          `json:"args"`     // the command line after the program name
+	Env      envReport         `json:"env"`      // what /env reports
+	Settings map[string]string `json:"settings"` // see sessionSettings
+}
+
+// settingPrefixes are the environment variables recorded in a session bundle.
+var settingPrefixes = []string{"ARTOO_", "GGS_", "OPENAI_", "BRAIN_", "TOOL_", "SERPER_"}
+
+// sessionSettings returns the configuration variables in environ ("NAME=value"
+// pairs) for a session bundle.
+//
+// Expectations:
+//   - Keeps only variables named with one of settingPrefixes
+//   - Replaces the value of any variable whose name mentions a key, token, secret, or password with "[redacted]"
+func sessionSettings(environ []string) map[string]string {
+	settings := make(map[string]string)
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if !slices.ContainsFunc(settingPrefixes, func(p string) bool { return strings.HasPrefix(name, p) }) {
+			continue
+		}
+		upper := strings.ToUpper(name)
+		for _, secret := range []string{"KEY", "TOKEN", "SECRET", "PASSWORD"} {
+			if strings.Contains(upper, secret) {
+				value = "[redacted]"
+				break
+			}
+		}
+		settings[name] = value
+	}
+	return settings
+}
+
+// busRecorder keeps every message published on the bus, for the bundle's bus trace.
+type busRecorder struct {
+	mu   sync.Mutex
+	msgs []types.Message
+}
+
+// recordBus records tap until ctx ends.
+func recordBus(ctx context.Context, tap <-chan types.Message) *busRecorder {
+	r := &busRecorder{}
+	go func() {
+		for {
+			select {
+			case msg, ok := <-tap:
+				if !ok {
+					return
+				}
+				r.mu.Lock()
+				r.msgs = append(r.msgs, msg)
+				r.mu.Unlock()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// messages returns a copy of the messages recorded so far; nil on a nil recorder.
+func (r *busRecorder) messages() []types.Message {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.msgs)
+}
+
+// sessionMemoryTags returns the (space, entity) pairs whose Megrams belong in
+// taskID's bundle: its own intent space, and every tag pair its log shows it
+// querying or writing. An empty entity stands for the whole space.
+func sessionMemoryTags(taskID string, events []tasklog.Event) [][2]string {
+	tags := [][2]string{{"intent:" + taskID, ""}}
+	for _, e := range events {
+		if (e.Kind != tasklog.KindMemoryQuery && e.Kind != tasklog.KindMemoryWrite) || e.Space == "" || e.Entity == "" {
+			continue
+		}
+		if tag := [2]string{e.Space, e.Entity}; !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// exportSession writes taskID's session bundle to path: its task log, the
+// Megrams it read and wrote, cfg, and trace when non-empty, as a zip archive.
+//
+// Expectations:
+//   - Returns an error, writing nothing, when taskID has no task log
+//   - Archives manifest.json, task.jsonl, memory.jsonl, and config.json; bus.jsonl only when trace is non-empty
+//   - task.jsonl is byte-for-byte the task's log
+//   - memory.jsonl holds each Megram of sessionMemoryTags once
+func exportSession(path, taskID string, logReg *tasklog.Registry, mem *memory.Store, cfg sessionConfig, trace []types.Message) (sessionManifest, error) {
+	logData, err := os.ReadFile(filepath.Join(logReg.Dir(), taskID+".jsonl"))
+	if err != nil {
+		return sessionManifest{}, fmt.Errorf("no task log for %s: %w", taskID, err)
+	}
+	var megrams []types.Megram
+	seen := make(map[string]bool)
+	for _, tag := range sessionMemoryTags(taskID, logReg.ReadEvents(taskID)) {
+		megs, err := mem.Export(tag[0], tag[1])
+		if err != nil {
+			return sessionManifest{}, err
+		}
+		for _, m := range megs {
+			if !seen[m.ID] {
+				seen[m.ID] = true
+				megrams = append(megrams, m)
+			}
+		}
+	}
+	cfgData, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return sessionManifest{}, err
+	}
+
+	man := sessionManifest{
+		TaskID:      taskID,
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+		Files:       []string{bundleTaskLog, bundleMemory, bundleConfig},
+		Megrams:     len(megrams),
+		BusMessages: len(trace),
+	}
+	if len(trace) > 0 {
+		man.Files = append(man.Files, bundleBusTrace)
+	}
+	manData, err := json.MarshalIndent(man, "", "  ")
+	if err != nil {
+		return sessionManifest{}, err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return sessionManifest{}, err
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	entries := []struct {
+		name string
+		data []byte
+	}{
+		{bundleManifest, manData},
+		{bundleTaskLog, logData},
+		{bundleMemory, jsonLines(megrams)},
+		{bundleConfig, cfgData},
+	}
+	if len(trace) > 0 {
+		entries = append(entries, struct {
+			name string
+			data []byte
+		}{bundleBusTrace, jsonLines(trace)})
+	}
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			return sessionManifest{}, err
+		}
+		if _, err := w.Write(e.data); err != nil {
+			return sessionManifest{}, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return sessionManifest{}, err
+	}
+	return man, f.Close()
+}
+
+// jsonLines encodes each value of vs as one JSON line.
+func jsonLines[T any](vs []T) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, v := range vs {
+		_ = enc.Encode(v)
+	}
+	return buf.Bytes()
+}
+
+// importSession loads an --export-session bundle for offline replay: its
+// Megrams go into mem and its task log into tasksDir, where --replay-llm and
+// /history find it. Returns the bundle's manifest and the Megrams added.
+//
+// Expectations:
+//   - Returns an error for a file that is not a session bundle
+//   - Rejects a manifest task ID that is not a plain file name
+//   - Writes task.jsonl as tasksDir/<task_id>.jsonl, keeping an existing log of that name
+//   - Imports memory.jsonl with memory.Store.Import, so importing twice adds no Megrams
+func importSession(path string, mem *memory.Store, tasksDir string) (sessionManifest, int, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return sessionManifest{}, 0, err
+	}
+	defer zr.Close()
+	read := func(name string) ([]byte, error) {
+		f, err := zr.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: missing %s: %w", path, name, err)
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+
+	var man sessionManifest
+	data, err := read(bundleManifest)
+	if err != nil {
+		return man, 0, err
+	}
+	if err := json.Unmarshal(data, &man); err != nil {
+		return man, 0, fmt.Errorf("%s: bad manifest: %w", path, err)
+	}
+	if man.TaskID == "" || man.TaskID != filepath.Base(man.TaskID) || strings.HasPrefix(man.TaskID, ".") {
+		return man, 0, fmt.Errorf("%s: bad task id %q", path, man.TaskID)
+	}
+
+	logData, err := read(bundleTaskLog)
+	if err != nil {
+		return man, 0, err
+	}
+	if err := os.MkdirAll(tasksDir, 0o755); err != nil {
+		return man, 0, err
+	}
+	logPath := filepath.Join(tasksDir, man.TaskID+".jsonl")
+	if _, err := os.Stat(logPath); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(logPath, logData, 0o644); err != nil {
+			return man, 0, err
+		}
+	}
+
+	data, err = read(bundleMemory)
+	if err != nil {
+		return man, 0, err
+	}
+	var megrams []types.Megram
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var m types.Megram
+		if err := dec.Decode(&m); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return man, 0, fmt.Errorf("%s: bad %s: %w", path, bundleMemory, err)
+		}
+		megrams = append(megrams, m)
+	}
+	added, err := mem.Import(megrams)
+	return man, added, err
+}
+
 // relativeTime formats an RFC3339 timestamp as a human-readable age string.
 func relativeTime(ts string) string {
 	t, err := time.Parse(time.RFC3339, ts)
@@ -1312,44 +3381,38 @@ func relativeTime(ts string) string {
 }
 
 func printAuditReport(rep types.AuditReport) {
-	const (
-		bold   = "\033[1m"
-		cyan   = "\033[36m"
-		yellow = "\033[33m"
-		red    = "\033[31m"
-		dim    = "\033[2m"
-		reset  = "\033[0m"
-	)
-	fmt.Printf("\n%s%s📡 Audit Report%s  %s%s → %s%s\n",
-		bold, cyan, reset, dim, rep.Period.From, rep.Period.To, reset)
+	t := ui.Active()
+	bold, cyan, yellow, red, dim, reset := t.Bold, t.Cyan, t.Yellow, t.Red, t.Dim, t.Reset
+	fmt.Printf("\n%s%s%sAudit Report%s  %s%s %s %s%s\n",
+		bold, cyan, t.Prefix("audit"), reset, dim, rep.Period.From, t.Icon("arrow"), rep.Period.To, reset)
 	fmt.Printf("  Tasks observed:      %d\n", rep.TasksObserved)
 	fmt.Printf("  Avg corrections:     %.2f\n", rep.ConvergenceHealth.AvgCorrectionCount)
 	gt := rep.ConvergenceHealth.GapTrendDistribution
-	fmt.Printf("  Gap trends:          ↑improving=%d  →stable=%d  ↓worsening=%d\n",
-		gt.Improving, gt.Stable, gt.Worsening)
+	fmt.Printf("  Gap trends:          %simproving=%d  %sstable=%d  %sworsening=%d\n",
+		t.Icon("up"), gt.Improving, t.Icon("arrow"), gt.Stable, t.Icon("down"), gt.Worsening)
 	if len(rep.BoundaryViolations) > 0 {
 		fmt.Printf("  %sBoundary violations:%s\n", yellow, reset)
 		for _, v := range rep.BoundaryViolations {
-			fmt.Printf("    • %s\n", v)
+			fmt.Printf("    %s %s\n", t.Icon("bullet"), v)
 		}
 	}
 	if len(rep.DriftAlerts) > 0 {
 		fmt.Printf("  %sDrift alerts:%s\n", red, reset)
 		for _, d := range rep.DriftAlerts {
-			fmt.Printf("    • %s\n", d)
+			fmt.Printf("    %s %s\n", t.Icon("bullet"), d)
 		}
 	}
 	th := rep.ToolHealth
 	if th.ExecutionFailures > 0 || th.EnvironmentalRetries > 0 || th.LogicalRetries > 0 {
 		fmt.Printf("  %sTool health:%s\n", yellow, reset)
 		if th.ExecutionFailures > 0 {
-			fmt.Printf("    • Execution failures:    %d\n", th.ExecutionFailures)
+			fmt.Printf("    %s Execution failures:    %d\n", t.Icon("bullet"), th.ExecutionFailures)
 		}
 		if th.EnvironmentalRetries > 0 {
-			fmt.Printf("    • Environmental retries: %d  (tool/infra errors)\n", th.EnvironmentalRetries)
+			fmt.Printf("    %s Environmental retries: %d  (tool/infra errors)\n", t.Icon("bullet"), th.EnvironmentalRetries)
 		}
 		if th.LogicalRetries > 0 {
-			fmt.Printf("    • Logical retries:       %d  (LLM format/reasoning)\n", th.LogicalRetries)
+			fmt.Printf("    %s Logical retries:       %d  (LLM format/reasoning)\n", t.Icon("bullet"), th.LogicalRetries)
 		}
 	}
 	if len(rep.BoundaryViolations) == 0 && len(rep.DriftAlerts) == 0 &&
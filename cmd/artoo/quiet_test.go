@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/llm"
+	"github.com/haricheung/agentic-shell/internal/tasklog"
+	"github.com/haricheung/agentic-shell/internal/types"
+	"github.com/haricheung/agentic-shell/internal/ui"
+)
+
+// runTaskStdout runs input through runTask with R1 scripted and fr as the
+// pipeline's answer, and returns everything runTask wrote to stdout.
+func runTaskStdout(t *testing.T, mode outputMode, fr types.FinalResult) string {
+	t.Helper()
+	newScriptedLLM(t, map[string][]string{
+		"R1": {`{"task_id":"find_report","intent":"locate report.txt","constraints":{"scope":null,"deadline":null},"raw_input":"find report.txt"}`},
+	})
+	prev := ui.Active()
+	plain, _ := ui.ThemeByName("plain")
+	ui.SetTheme(plain)
+	t.Cleanup(func() { ui.SetTheme(prev) })
+
+	b := bus.New()
+	logReg := tasklog.NewRegistry(t.TempDir())
+	resultCh := make(chan types.FinalResult, 1)
+	resultCh <- fr
+	canceller := newTaskCanceller(b, logReg, func(types.FinalResult) {})
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	_, runErr := runTask(t.Context(), b, llm.New(), "find report.txt", resultCh, logReg, nil, true, &toolConfirmer{}, canceller, mode, nil)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+	if runErr != nil {
+		t.Fatalf("runTask: %v", runErr)
+	}
+	return string(out)
+}
+
+func TestRunTask_QuietPrintsOnlyTheOutput(t *testing.T) {
+	// quiet prints only the result's output to stdout: no header, summary, or Assumed/Created lines
+	fr := types.FinalResult{
+		TaskID:      "find_report",
+		Summary:     "Found report.txt.",
+		Output:      "/tmp/work/report.txt",
+		Directive:   "accept",
+		Artifacts:   []string{"/tmp/work/notes.md"},
+		Assumptions: []string{"searched under /tmp/work"},
+	}
+	if got := runTaskStdout(t, outputQuiet, fr); got != "/tmp/work/report.txt\n" {
+		t.Errorf("quiet stdout = %q, want only the output line", got)
+	}
+	loud := runTaskStdout(t, outputRich, fr)
+	for _, want := range []string{"Result", "Found report.txt.", "Assumed:", "Created:"} {
+		if !strings.Contains(loud, want) {
+			t.Errorf("without quiet, expected %q in:\n%s", want, loud)
+		}
+	}
+}
+
+func TestRunTask_NoClarifyStillAsksConfirmQuestions(t *testing.T) {
+	// --no-clarify silences only R1: --confirm questions are still read from stdin
+	newScriptedLLM(t, map[string][]string{
+		"R1": {`{"task_id":"find_report","intent":"locate report.txt","constraints":{"scope":null,"deadline":null},"raw_input":"find report.txt"}`},
+	})
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("y\n")
+	w.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() { os.Stdin = stdin })
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	t.Cleanup(func() { os.Stdout = stdout; devNull.Close() })
+
+	b := bus.New()
+	logReg := tasklog.NewRegistry(t.TempDir())
+	resultCh := make(chan types.FinalResult, 1)
+	resultCh <- types.FinalResult{TaskID: "find_report", Output: "done", Directive: "accept"}
+	canceller := newTaskCanceller(b, logReg, func(types.FinalResult) {})
+	confirmer := &toolConfirmer{}
+	if _, err := runTask(t.Context(), b, llm.New(), "find report.txt", resultCh, logReg, nil, true, confirmer, canceller, outputQuiet, nil); err != nil {
+		t.Fatalf("runTask: %v", err)
+	}
+	if !confirmer.confirm(t.Context(), "shell", "rm report.txt") {
+		t.Error("confirm declined; want the stdin answer \"y\" to approve the call")
+	}
+}
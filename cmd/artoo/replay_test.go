@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/haricheung/agentic-shell/internal/tasklog"
+)
+
+// writeTaskBegin writes a one-event task log for taskID, modified at mod.
+func writeTaskBegin(t *testing.T, dir, taskID, intent string, mod time.Time) {
+	t.Helper()
+	data, _ := json.Marshal(tasklog.Event{Kind: tasklog.KindTaskBegin, TaskID: taskID, Intent: intent})
+	path := filepath.Join(dir, taskID+".jsonl")
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveReplayIntent(t *testing.T) {
+	// "last" resolves to the newest task log in reg's directory; a task ID resolves to its own log
+	dir := t.TempDir()
+	now := time.Now()
+	writeTaskBegin(t, dir, "count_go_files", "count Go files in the project", now.Add(-time.Hour))
+	writeTaskBegin(t, dir, "check_weather", "what's the weather in Beijing", now)
+	reg := tasklog.NewRegistry(dir)
+
+	if got, err := resolveReplayIntent(reg, " count_go_files "); err != nil || got != "count Go files in the project" {
+		t.Errorf("by ID = %q, %v", got, err)
+	}
+	if got, err := resolveReplayIntent(reg, " last"); err != nil || got != "what's the weather in Beijing" {
+		t.Errorf("last = %q, %v; want the newest log's intent", got, err)
+	}
+}
+
+func TestResolveReplayIntent_Errors(t *testing.T) {
+	// Returns a usage error when arg is blank; propagates LoadIntent's error for a missing log
+	reg := tasklog.NewRegistry(t.TempDir())
+	for _, arg := range []string{"", "  ", " missing_task", " last"} {
+		if got, err := resolveReplayIntent(reg, arg); err == nil {
+			t.Errorf("resolveReplayIntent(%q) = %q, want an error", arg, got)
+		}
+	}
+}
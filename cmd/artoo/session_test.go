@@ -0,0 +1,187 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haricheung/agentic-shell/internal/roles/memory"
+	"github.com/haricheung/agentic-shell/internal/tasklog"
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+// exportedRun logs one task that queried (tool:shell, intent:list_files), fills a
+// store with its Megrams plus an unrelated one, and exports it to a bundle.
+func exportedRun(t *testing.T) (bundle string, logReg *tasklog.Registry) {
+	t.Helper()
+	logReg = tasklog.NewRegistry(filepath.Join(t.TempDir(), "tasks"))
+	tl := logReg.Open("find_report", "locate report.txt")
+	tl.MemoryQuery("tool:shell", "intent:list_files", 0, "Exploit", 1.2, 0.8)
+	logReg.Close("find_report", "accepted")
+
+	mem := memory.New(nil, filepath.Join(t.TempDir(), "memory.leveldb"), nil)
+	now := time.Now().UTC().Format(time.RFC3339)
+	mem.Write(types.Megram{ID: "m-terminal", CreatedAt: now, Space: "intent:find_report", Entity: "env:local", State: "accept", F: 0.9, Sigma: 1, K: 0.05})
+	mem.Write(types.Megram{ID: "m-queried", CreatedAt: now, Space: "tool:shell", Entity: "intent:list_files", State: "accept", F: 0.9, Sigma: 1, K: 0.05})
+	mem.Write(types.Megram{ID: "m-other", CreatedAt: now, Space: "intent:find_reports_old", Entity: "env:local", State: "abandon", F: 0.95, Sigma: -1, K: 0.05})
+
+	bundle = filepath.Join(t.TempDir(), "session.zip")
+	cfg := sessionConfig{Args: []string{"--export-session", bundle, "find report.txt"}, Settings: map[string]string{"ARTOO_THEME": "plain"}}
+	trace := []types.Message{{ID: "1", Type: types.MsgTaskSpec}, {ID: "2", Type: types.MsgFinalResult}}
+	man, err := exportSession(bundle, "find_report", logReg, mem, cfg, trace)
+	if err != nil {
+		t.Fatalf("exportSession: %v", err)
+	}
+	if man.Megrams != 2 || man.BusMessages != 2 {
+		t.Errorf("manifest counts megrams=%d bus=%d, want 2 and 2", man.Megrams, man.BusMessages)
+	}
+	return bundle, logReg
+}
+
+// zipEntries returns the archive's entries by name.
+func zipEntries(t *testing.T, path string) map[string][]byte {
+	t.Helper()
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	entries := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	return entries
+}
+
+func TestExportSession_ArchiveHoldsExpectedFiles(t *testing.T) {
+	// Archives manifest.json, task.jsonl, memory.jsonl, and config.json; bus.jsonl only when trace is non-empty
+	// task.jsonl is byte-for-byte the task's log
+	// memory.jsonl holds each Megram of sessionMemoryTags once
+	bundle, logReg := exportedRun(t)
+	entries := zipEntries(t, bundle)
+
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if want := []string{"bus.jsonl", "config.json", "manifest.json", "memory.jsonl", "task.jsonl"}; !slices.Equal(names, want) {
+		t.Fatalf("archive entries = %v, want %v", names, want)
+	}
+	logData, err := os.ReadFile(filepath.Join(logReg.Dir(), "find_report.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(entries["task.jsonl"], logData) {
+		t.Error("task.jsonl differs from the task's log")
+	}
+	mem := string(entries["memory.jsonl"])
+	if n := strings.Count(mem, "\n"); n != 2 {
+		t.Errorf("memory.jsonl has %d Megrams, want 2:\n%s", n, mem)
+	}
+	if !strings.Contains(mem, "m-terminal") || !strings.Contains(mem, "m-queried") || strings.Contains(mem, "m-other") {
+		t.Errorf("memory.jsonl should hold the task's and the queried Megrams only:\n%s", mem)
+	}
+	if !strings.Contains(string(entries["config.json"]), `"ARTOO_THEME": "plain"`) {
+		t.Errorf("config.json lacks the settings:\n%s", entries["config.json"])
+	}
+	if n := strings.Count(string(entries["bus.jsonl"]), "\n"); n != 2 {
+		t.Errorf("bus.jsonl has %d messages, want 2", n)
+	}
+}
+
+func TestExportSession_NoTaskLog(t *testing.T) {
+	// Returns an error, writing nothing, when taskID has no task log
+	bundle := filepath.Join(t.TempDir(), "session.zip")
+	mem := memory.New(nil, filepath.Join(t.TempDir(), "memory.leveldb"), nil)
+	if _, err := exportSession(bundle, "never_ran", tasklog.NewRegistry(t.TempDir()), mem, sessionConfig{}, nil); err == nil {
+		t.Error("expected an error for a task without a log")
+	}
+	if _, err := os.Stat(bundle); !os.IsNotExist(err) {
+		t.Errorf("bundle should not exist, stat err = %v", err)
+	}
+}
+
+func TestImportSession_ReimportsIntoFreshStore(t *testing.T) {
+	// Writes task.jsonl as tasksDir/<task_id>.jsonl, keeping an existing log of that name
+	// Imports memory.jsonl with memory.Store.Import, so importing twice adds no Megrams
+	bundle, logReg := exportedRun(t)
+	fresh := memory.New(nil, filepath.Join(t.TempDir(), "memory.leveldb"), nil)
+	tasksDir := filepath.Join(t.TempDir(), "tasks")
+
+	man, added, err := importSession(bundle, fresh, tasksDir)
+	if err != nil {
+		t.Fatalf("importSession: %v", err)
+	}
+	if man.TaskID != "find_report" || added != 2 {
+		t.Errorf("imported task %q with %d megrams, want find_report with 2", man.TaskID, added)
+	}
+	got := tasklog.NewRegistry(tasksDir).ReadEvents("find_report")
+	want := logReg.ReadEvents("find_report")
+	if len(got) == 0 || len(got) != len(want) {
+		t.Errorf("re-imported log has %d events, want %d", len(got), len(want))
+	}
+	recent, err := fresh.QueryRecent(t.Context(), "tool:shell", "intent:list_files", 5)
+	if err != nil || len(recent) != 1 || recent[0].ID != "m-queried" {
+		t.Errorf("QueryRecent after import = %+v, %v", recent, err)
+	}
+	if st, _ := fresh.IntentStats("intent:find_report"); st.Accepts != 1 {
+		t.Errorf("IntentStats after import: accepts = %d, want 1", st.Accepts)
+	}
+	if _, again, err := importSession(bundle, fresh, tasksDir); err != nil || again != 0 {
+		t.Errorf("second import added %d megrams (err %v), want 0", again, err)
+	}
+}
+
+func TestImportSession_RejectsBadBundle(t *testing.T) {
+	// Returns an error for a file that is not a session bundle
+	// Rejects a manifest task ID that is not a plain file name
+	dir := t.TempDir()
+	notZip := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notZip, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	evil := filepath.Join(dir, "evil.zip")
+	f, err := os.Create(evil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	w, _ := zw.Create("manifest.json")
+	w.Write([]byte(`{"task_id":"../../escape"}`))
+	zw.Close()
+	f.Close()
+
+	mem := memory.New(nil, filepath.Join(dir, "memory.leveldb"), nil)
+	for _, path := range []string{notZip, evil} {
+		if _, _, err := importSession(path, mem, filepath.Join(dir, "tasks")); err == nil {
+			t.Errorf("%s: expected an error", filepath.Base(path))
+		}
+	}
+}
+
+func TestSessionSettings(t *testing.T) {
+	// Keeps only variables named with one of settingPrefixes
+	// Replaces the value of any variable whose name mentions a key, token, secret, or password with "[redacted]"
+	got := sessionSettings([]string{"ARTOO_THEME=plain", "GGS_ALPHA=0.5", "TOOL_API_KEY=sk-123", "SERPER_API_KEY=abc", "HOME=/root", "PATH=/bin"})
+	want := map[string]string{"ARTOO_THEME": "plain", "GGS_ALPHA": "0.5", "TOOL_API_KEY": "[redacted]", "SERPER_API_KEY": "[redacted]"}
+	if len(got) != len(want) {
+		t.Fatalf("settings = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haricheung/agentic-shell/internal/tasklog"
+)
+
+func TestParseStatsSince(t *testing.T) {
+	// Returns the zero time for an empty argument; now minus the age for --since <duration> or <N>d;
+	// a usage error for anything else, including a negative age
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for arg, want := range map[string]time.Time{
+		"":                time.Time{},
+		" --since 24h":    now.Add(-24 * time.Hour),
+		" --since 90m":    now.Add(-90 * time.Minute),
+		" --since 7d":     now.AddDate(0, 0, -7),
+		"  --since   0d ": now,
+	} {
+		got, err := parseStatsSince(arg, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseStatsSince(%q) = %v, %v; want %v", arg, got, err, want)
+		}
+	}
+	for _, arg := range []string{"--since", "--since -1h", "--since -2d", "--since yesterday", "24h", "--since 1h extra"} {
+		if _, err := parseStatsSince(arg, now); err == nil {
+			t.Errorf("parseStatsSince(%q): expected a usage error", arg)
+		}
+	}
+}
+
+func TestPrintAggregateStats_ShowsCountsAndTotals(t *testing.T) {
+	// Renders run counts by status and the totals; a placeholder line when no run matched
+	var out bytes.Buffer
+	printAggregateStats(&out, time.Time{}, tasklog.AggregateStats{
+		Runs:        3,
+		ByStatus:    map[string]int{"accepted": 2, "abandoned": 1},
+		TotalTokens: 900,
+		ElapsedMs:   6000,
+		Replans:     4,
+	})
+	for _, want := range []string{"all tasks", "2 accepted", "1 abandoned", "900", "(300 per run)", "6.0s", "replans      4"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, out.String())
+		}
+	}
+	out.Reset()
+	printAggregateStats(&out, time.Now(), tasklog.AggregateStats{})
+	if !strings.Contains(out.String(), "no tasks recorded, since") {
+		t.Errorf("empty stats = %q", out.String())
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haricheung/agentic-shell/internal/llm"
+)
+
+// stubTier returns a tier client named prefix whose backend is url.
+func stubTier(t *testing.T, prefix, url string) *llm.Client {
+	t.Helper()
+	t.Setenv(prefix+"_BASE_URL", url)
+	t.Setenv(prefix+"_API_KEY", "k")
+	t.Setenv(prefix+"_MODEL", "alias-model")
+	return llm.NewTier(prefix)
+}
+
+func TestCheckTiers_ReportsReachableAndUnreachable(t *testing.T) {
+	// Marks a tier reachable with its latency and answering model when its ping succeeds;
+	// unreachable with the ping error as Problem otherwise
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"model":"resolved-model-2025","choices":[{"message":{"content":"p"}}]}`))
+	}))
+	defer ok.Close()
+	badKey := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, `{"error":{"message":"invalid api key"}}`, http.StatusUnauthorized)
+	}))
+	defer badKey.Close()
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+
+	rep := checkTiers(t.Context(), []*llm.Client{
+		stubTier(t, "BRAIN", ok.URL),
+		stubTier(t, "TOOL", badKey.URL),
+		stubTier(t, "SPARE", gone.URL),
+	}, 5*time.Second)
+
+	if len(rep.Tiers) != 3 || rep.Tiers[0].Name != "BRAIN" || rep.Tiers[1].Name != "TOOL" || rep.Tiers[2].Name != "SPARE" {
+		t.Fatalf("expected the tiers in the order given, got %+v", rep.Tiers)
+	}
+	brain := rep.Tiers[0]
+	if !brain.Reachable || brain.Model != "resolved-model-2025" || brain.Latency < 20*time.Millisecond {
+		t.Errorf("BRAIN: expected reachable via resolved-model-2025 in ≥20ms, got %+v", brain)
+	}
+	if tool := rep.Tiers[1]; tool.Reachable || !strings.Contains(tool.Problem, "401") {
+		t.Errorf("TOOL: expected unreachable with HTTP 401, got %+v", tool)
+	}
+	if spare := rep.Tiers[2]; spare.Reachable || spare.Problem == "" || spare.Model != "alias-model" {
+		t.Errorf("SPARE: expected unreachable with a problem and the configured model, got %+v", spare)
+	}
+	if rep.allReachable() {
+		t.Error("allReachable should be false with unreachable tiers")
+	}
+}
+
+func TestCheckTiers_ReportsSearchBackend(t *testing.T) {
+	// Reports the search backend from tools.SearchBackend
+	t.Setenv("SERPER_API_KEY", "")
+	if rep := checkTiers(t.Context(), nil, time.Second); rep.Search != "DuckDuckGo" || !rep.allReachable() {
+		t.Errorf("got %+v, want DuckDuckGo and no unreachable tiers", rep)
+	}
+	t.Setenv("SERPER_API_KEY", "key")
+	if rep := checkTiers(t.Context(), nil, time.Second); rep.Search != "Serper" {
+		t.Errorf("search = %q, want Serper", rep.Search)
+	}
+}
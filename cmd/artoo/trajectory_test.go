@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/haricheung/agentic-shell/internal/roles/ggs"
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+func TestPrintTrajectory_TableOfRounds(t *testing.T) {
+	// Prints a header, then each round's number, directive, D, P, Ω, L, and signed ∇L, in aligned columns
+	var out bytes.Buffer
+	printTrajectory(&out, "t1", []ggs.RoundSnapshot{
+		{Round: 1, Directive: "change_path", Loss: types.LossBreakdown{D: 1, P: 0.2, Omega: 0.13, L: 0.73}},
+		{Round: 2, Directive: "accept", Loss: types.LossBreakdown{D: 0, P: 0.5, Omega: 0.2, L: 0.16}, GradL: -0.57},
+	})
+	got := out.String()
+	for _, want := range []string{
+		"| round | directive       |     D |     P |     Ω |     L |     ∇L |",
+		"|     1 | change_path     |  1.00 |  0.20 |  0.13 |  0.73 |  +0.00 |",
+		"|     2 | accept          |  0.00 |  0.50 |  0.20 |  0.16 |  -0.57 |",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing row %q in:\n%s", want, got)
+		}
+	}
+	var widths []int
+	for _, l := range strings.Split(got, "\n") {
+		if l = strings.TrimSpace(l); strings.HasPrefix(l, "|") || strings.HasPrefix(l, "+") {
+			widths = append(widths, len([]rune(l)))
+		}
+	}
+	for _, w := range widths {
+		if w != widths[0] {
+			t.Errorf("table rows differ in width: %v", widths)
+			break
+		}
+	}
+}
+
+func TestPrintTrajectory_HintWithoutRounds(t *testing.T) {
+	// Prints a hint instead of a table when there is no task yet or it has no rounds
+	for taskID, want := range map[string]string{"": "no task yet", "t1": "no GGS rounds recorded for t1"} {
+		var out bytes.Buffer
+		printTrajectory(&out, taskID, nil)
+		if !strings.Contains(out.String(), want) || strings.Contains(out.String(), "|") {
+			t.Errorf("task %q: got %q, want a hint containing %q", taskID, out.String(), want)
+		}
+	}
+}
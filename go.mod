@@ -7,12 +7,12 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/joho/godotenv v1.5.1
 	github.com/mattn/go-runewidth v0.0.20
+	github.com/syndtr/goleveldb v1.0.0
 )
 
 require (
 	github.com/clipperhouse/uax29/v2 v2.2.0 // indirect
 	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
-	github.com/syndtr/goleveldb v1.0.0 // indirect
 )
 
 // Patched local copy: fixes getBackspaceSequence() to emit Width(rune) backspaces
@@ -13,11 +13,25 @@ const (
 )
 
 // Bus is the observable message bus. All inter-role communication passes through it.
-// Multiple consumers (Auditor, UI) can each register their own tap channel via NewTap.
+// Multiple consumers (Auditor, UI) can each register their own tap channel via NewTap,
+// or via NewFilteredTap for only the message types they handle.
 type Bus struct {
 	mu          sync.RWMutex
 	subscribers map[types.MessageType][]chan types.Message
-	taps        []chan types.Message
+	taps        []tap
+	queues      []*Queue // priority subscribers; see SubscribePriority
+	closed      bool     // set by Close; Publish is then a no-op
+}
+
+// tap is one tap channel and the message types it receives; nil only receives every type.
+type tap struct {
+	ch   chan types.Message
+	only map[types.MessageType]bool
+}
+
+// accepts reports whether t registered for messages of type mt.
+func (t tap) accepts(mt types.MessageType) bool {
+	return t.only == nil || t.only[mt]
 }
 
 // New creates a new Bus.
@@ -27,14 +41,19 @@ func New() *Bus {
 	}
 }
 
-// Publish fans out msg to all subscribers of msg.Type and to the tap channel.
-// Non-blocking: if a subscriber's channel is full, the message is dropped with a warning.
+// Publish fans out msg to all subscribers of msg.Type and to the tap channels
+// that accept it. Non-blocking: if a subscriber's channel is full, the message is
+// dropped with a warning. A no-op after Close.
 func (b *Bus) Publish(msg types.Message) {
+	// Sends never block, so holding the read lock throughout is cheap, and it
+	// keeps Close from closing a channel mid-send.
 	b.mu.RLock()
-	subs := b.subscribers[msg.Type]
-	b.mu.RUnlock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return
+	}
 
-	for _, ch := range subs {
+	for _, ch := range b.subscribers[msg.Type] {
 		select {
 		case ch <- msg:
 		default:
@@ -42,40 +61,125 @@ func (b *Bus) Publish(msg types.Message) {
 		}
 	}
 
-	// Fan out to all tap channels (auditor, UI, etc.). Non-blocking.
-	b.mu.RLock()
-	taps := b.taps
-	b.mu.RUnlock()
-	for _, tap := range taps {
+	// Fan out to the tap channels (auditor, UI, etc.) that accept msg.Type — a
+	// filtered tap never queues what its consumer would discard. Non-blocking.
+	for _, t := range b.taps {
+		if !t.accepts(msg.Type) {
+			continue
+		}
 		select {
-		case tap <- msg:
+		case t.ch <- msg:
 		default:
 			slog.Warn("[BUS] tap channel full, message dropped", "type", msg.Type)
 		}
 	}
+
+	// Priority subscribers queue instead of blocking; see Queue.push for overflow.
+	for _, q := range b.queues {
+		if q.accepts(msg.Type) {
+			q.push(msg)
+		}
+	}
 }
 
 // Subscribe returns a receive-only channel that delivers messages of type t.
-// Each call creates a new independent subscriber channel.
+// Each call creates a new independent subscriber channel; after Close it is
+// returned already closed.
 func (b *Bus) Subscribe(t types.MessageType) <-chan types.Message {
 	ch := make(chan types.Message, subscriberBufSize)
 	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch
+	}
 	b.subscribers[t] = append(b.subscribers[t], ch)
-	b.mu.Unlock()
 	return ch
 }
 
 // NewTap registers and returns a new read-only tap channel.
 // Each caller gets an independent channel that receives every published message.
+// It is NewFilteredTap with no types.
 func (b *Bus) NewTap() <-chan types.Message {
-	ch := make(chan types.Message, tapBufSize)
+	return b.NewFilteredTap()
+}
+
+// NewFilteredTap registers and returns a new read-only tap channel that only
+// receives messages of the given types, or every message when none are given.
+// The bus filters before enqueueing, so a slow consumer builds no backlog of
+// messages it would discard.
+//
+// Expectations:
+//   - Delivers published messages of the given types, in publish order
+//   - Never delivers a message of any other type
+//   - Delivers every message when no types are given, like NewTap
+//   - The channel is closed by Close
+func (b *Bus) NewFilteredTap(ts ...types.MessageType) <-chan types.Message {
+	t := tap{ch: make(chan types.Message, tapBufSize)}
+	if len(ts) > 0 {
+		t.only = make(map[types.MessageType]bool, len(ts))
+		for _, mt := range ts {
+			t.only[mt] = true
+		}
+	}
 	b.mu.Lock()
-	b.taps = append(b.taps, ch)
-	b.mu.Unlock()
-	return ch
+	defer b.mu.Unlock()
+	if b.closed {
+		close(t.ch)
+		return t.ch
+	}
+	b.taps = append(b.taps, t)
+	return t.ch
+}
+
+// SubscribePriority registers a priority subscriber for the given message types,
+// or for every message (like NewTap) when none are given. Unlike Subscribe and
+// NewTap, which deliver in publish order, it delivers high-priority messages
+// (see PriorityOf) ahead of every normal one already queued, so a slow consumer
+// does not see a FinalResult or PlanDirective only after a backlog of progress.
+// After Close it is returned already closed.
+func (b *Bus) SubscribePriority(ts ...types.MessageType) *Queue {
+	q := newQueue(tapBufSize, ts)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(q.done)
+		return q
+	}
+	b.queues = append(b.queues, q)
+	return q
 }
 
 // Tap is an alias for NewTap, kept for backward compatibility.
 func (b *Bus) Tap() <-chan types.Message {
 	return b.NewTap()
 }
+
+// Close shuts the bus down: it closes every Subscribe and tap channel, and every
+// priority subscriber, so their consumers' receive loops end, and makes later
+// Publish calls no-ops. Safe to call more than once.
+//
+// Expectations:
+//   - Closes every subscriber and tap channel, filtered or not
+//   - A priority subscriber's Recv returns its queued messages, then ErrClosed
+//   - Publish after Close delivers nothing and does not panic
+//   - A tap or subscriber registered after Close is returned already closed
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for _, subs := range b.subscribers {
+		for _, ch := range subs {
+			close(ch)
+		}
+	}
+	for _, t := range b.taps {
+		close(t.ch)
+	}
+	for _, q := range b.queues {
+		close(q.done)
+	}
+}
@@ -0,0 +1,107 @@
+package bus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+// drain returns the messages buffered on ch without blocking.
+func drain(ch <-chan types.Message) []types.Message {
+	var out []types.Message
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return out
+			}
+			out = append(out, msg)
+		default:
+			return out
+		}
+	}
+}
+
+func TestNewFilteredTap_OnlyDeliversMatchingTypes(t *testing.T) {
+	// Delivers published messages of the given types, in publish order;
+	// never delivers a message of any other type
+	b := New()
+	tap := b.NewFilteredTap(types.MsgFinalResult, types.MsgReplanRequest)
+	all := b.NewTap()
+
+	sent := []types.MessageType{
+		types.MsgTaskSpec, types.MsgReplanRequest, types.MsgTaskProgress,
+		types.MsgExecutionResult, types.MsgFinalResult, types.MsgAuditQuery,
+	}
+	for _, mt := range sent {
+		b.Publish(types.Message{Type: mt})
+	}
+
+	got := drain(tap)
+	if len(got) != 2 || got[0].Type != types.MsgReplanRequest || got[1].Type != types.MsgFinalResult {
+		t.Errorf("filtered tap got %v, want ReplanRequest then FinalResult", got)
+	}
+	if n := len(drain(all)); n != len(sent) {
+		t.Errorf("unfiltered tap got %d messages, want %d", n, len(sent))
+	}
+}
+
+func TestNewFilteredTap_NonMatchingTypesNeverQueue(t *testing.T) {
+	// A filtered tap never queues what its consumer would discard, so a flood of
+	// other types cannot fill it and drop a matching message
+	b := New()
+	tap := b.NewFilteredTap(types.MsgFinalResult)
+	for range tapBufSize * 2 {
+		b.Publish(types.Message{Type: types.MsgTaskProgress})
+	}
+	b.Publish(types.Message{Type: types.MsgFinalResult})
+	if got := drain(tap); len(got) != 1 || got[0].Type != types.MsgFinalResult {
+		t.Errorf("got %d messages, want just the FinalResult", len(got))
+	}
+}
+
+func TestClose_ClosesTapsAndSubscribers(t *testing.T) {
+	// Closes every subscriber and tap channel, filtered or not; Publish after Close
+	// delivers nothing and does not panic; a tap registered after Close is returned already closed
+	b := New()
+	filtered := b.NewFilteredTap(types.MsgFinalResult)
+	all := b.NewTap()
+	sub := b.Subscribe(types.MsgFinalResult)
+
+	b.Close()
+	b.Close()
+	b.Publish(types.Message{Type: types.MsgFinalResult})
+
+	for name, ch := range map[string]<-chan types.Message{
+		"filtered tap": filtered, "tap": all, "subscriber": sub,
+		"late tap": b.NewFilteredTap(types.MsgFinalResult), "late subscriber": b.Subscribe(types.MsgTaskSpec),
+	} {
+		select {
+		case msg, ok := <-ch:
+			if ok {
+				t.Errorf("%s received %v after Close", name, msg.Type)
+			}
+		default:
+			t.Errorf("%s is not closed", name)
+		}
+	}
+}
+
+func TestClose_EndsPrioritySubscribersAfterTheirQueue(t *testing.T) {
+	// A priority subscriber's Recv returns its queued messages, then ErrClosed
+	b := New()
+	q := b.SubscribePriority(types.MsgFinalResult)
+	b.Publish(types.Message{Type: types.MsgFinalResult, ID: "queued"})
+
+	b.Close()
+	if msg, err := q.Recv(t.Context()); err != nil || msg.ID != "queued" {
+		t.Errorf("Recv = %q, %v; want the message queued before Close", msg.ID, err)
+	}
+	if _, err := q.Recv(t.Context()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Recv on a drained closed queue = %v, want ErrClosed", err)
+	}
+	if _, err := b.SubscribePriority().Recv(t.Context()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Recv on a late priority subscriber = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,161 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+// Priority orders delivery on a priority subscriber (see Bus.SubscribePriority).
+type Priority int
+
+const (
+	PriorityNormal Priority = iota
+	PriorityHigh
+)
+
+// highPriority are the control messages a priority subscriber receives ahead of
+// queued traffic: the task's end (including cancellation, which is a FinalResult
+// with directive "cancelled") and GGS's next planning directive.
+var highPriority = map[types.MessageType]bool{
+	types.MsgFinalResult:   true,
+	types.MsgPlanDirective: true,
+}
+
+// PriorityOf returns the delivery priority of messages of type t.
+//
+// Expectations:
+//   - FinalResult and PlanDirective are high priority; everything else is normal
+func PriorityOf(t types.MessageType) Priority {
+	if highPriority[t] {
+		return PriorityHigh
+	}
+	return PriorityNormal
+}
+
+// ErrClosed is returned by Queue.Recv once the bus is closed and the queue drained.
+var ErrClosed = errors.New("bus: closed")
+
+// Queue is one priority subscriber's mailbox: two FIFO queues, high and normal,
+// drained high first. Publish never blocks on it; Recv blocks until a message
+// is queued. Within one priority, messages keep their publish order.
+type Queue struct {
+	mu     sync.Mutex
+	high   []types.Message
+	normal []types.Message
+	size   int                        // max messages held across both queues
+	only   map[types.MessageType]bool // subscribed types; nil accepts every type
+	ready  chan struct{}              // 1-buffered; signalled whenever a message may be waiting
+	done   chan struct{}              // closed by Bus.Close
+}
+
+func newQueue(size int, ts []types.MessageType) *Queue {
+	q := &Queue{size: size, ready: make(chan struct{}, 1), done: make(chan struct{})}
+	if len(ts) > 0 {
+		q.only = make(map[types.MessageType]bool, len(ts))
+		for _, t := range ts {
+			q.only[t] = true
+		}
+	}
+	return q
+}
+
+// accepts reports whether q subscribed to messages of type t.
+func (q *Queue) accepts(t types.MessageType) bool {
+	return q.only == nil || q.only[t]
+}
+
+// push queues msg by its priority. When q is full a normal message is dropped;
+// a high-priority one evicts the oldest normal message instead, and is dropped
+// only when q holds nothing but high-priority messages.
+//
+// Expectations:
+//   - Appends msg to the queue of its priority and signals ready
+//   - Drops a normal message when the queue is full
+//   - Evicts the oldest normal message to fit a high-priority one when full
+//   - Drops a high-priority message only when every queued message is high-priority
+func (q *Queue) push(msg types.Message) {
+	q.mu.Lock()
+	full := len(q.high)+len(q.normal) >= q.size
+	switch {
+	case !full && PriorityOf(msg.Type) == PriorityHigh:
+		q.high = append(q.high, msg)
+	case !full:
+		q.normal = append(q.normal, msg)
+	case PriorityOf(msg.Type) == PriorityHigh && len(q.normal) > 0:
+		evicted := q.normal[0]
+		q.normal = q.normal[1:]
+		q.high = append(q.high, msg)
+		q.mu.Unlock()
+		slog.Warn("[BUS] priority queue full, normal message evicted", "type", evicted.Type, "for", msg.Type)
+		q.signal()
+		return
+	default:
+		q.mu.Unlock()
+		slog.Warn("[BUS] priority queue full, message dropped", "type", msg.Type, "from", msg.From)
+		return
+	}
+	q.mu.Unlock()
+	q.signal()
+}
+
+// signal wakes a waiting Recv without blocking.
+func (q *Queue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// TryRecv returns the next message without blocking: the oldest high-priority
+// message, else the oldest normal one. ok is false when q is empty.
+func (q *Queue) TryRecv() (msg types.Message, ok bool) {
+	q.mu.Lock()
+	switch {
+	case len(q.high) > 0:
+		msg, q.high = q.high[0], q.high[1:]
+	case len(q.normal) > 0:
+		msg, q.normal = q.normal[0], q.normal[1:]
+	default:
+		q.mu.Unlock()
+		return types.Message{}, false
+	}
+	more := len(q.high)+len(q.normal) > 0
+	q.mu.Unlock()
+	if more {
+		q.signal() // another Recv may be waiting on the wake-up this one consumed
+	}
+	return msg, true
+}
+
+// Recv blocks until a message is queued and returns it, high priority first.
+// Returns ctx's error when ctx ends first, and ErrClosed once the bus is closed
+// and every message queued before Close has been received.
+func (q *Queue) Recv(ctx context.Context) (types.Message, error) {
+	for {
+		if msg, ok := q.TryRecv(); ok {
+			return msg, nil
+		}
+		select {
+		case <-q.ready:
+		case <-q.done:
+			// Close waits out in-flight publishes, so nothing arrives after done.
+			if msg, ok := q.TryRecv(); ok {
+				return msg, nil
+			}
+			return types.Message{}, ErrClosed
+		case <-ctx.Done():
+			return types.Message{}, ctx.Err()
+		}
+	}
+}
+
+// Len returns how many messages are queued.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.high) + len(q.normal)
+}
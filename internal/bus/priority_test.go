@@ -0,0 +1,153 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+func progress(n int) types.Message {
+	return types.Message{Type: types.MsgTaskProgress, Payload: types.TaskProgress{TaskID: "t1", CompletedSubtasks: n}}
+}
+
+func TestSubscribePriority_HighDeliveredAheadOfQueuedNormal(t *testing.T) {
+	// delivers high-priority messages (see PriorityOf) ahead of every normal one already queued
+	b := New()
+	q := b.SubscribePriority()
+	for i := 1; i <= 10; i++ {
+		b.Publish(progress(i))
+	}
+	b.Publish(types.Message{Type: types.MsgFinalResult, Payload: types.FinalResult{TaskID: "t1"}})
+
+	first, err := q.Recv(t.Context())
+	if err != nil || first.Type != types.MsgFinalResult {
+		t.Fatalf("first delivery = %s, %v; want FinalResult", first.Type, err)
+	}
+	// Within one priority, messages keep their publish order
+	for i := 1; i <= 10; i++ {
+		msg, ok := q.TryRecv()
+		if !ok || msg.Payload.(types.TaskProgress).CompletedSubtasks != i {
+			t.Fatalf("delivery %d = %+v (ok=%v), want progress %d", i+1, msg.Payload, ok, i)
+		}
+	}
+	if _, ok := q.TryRecv(); ok {
+		t.Error("expected the queue to be empty")
+	}
+}
+
+func TestSubscribePriority_PlainSubscribersKeepPublishOrder(t *testing.T) {
+	// Subscribe and NewTap, which deliver in publish order, are unchanged
+	b := New()
+	sub := b.Subscribe(types.MsgFinalResult)
+	tap := b.NewTap()
+	b.Publish(progress(1))
+	b.Publish(types.Message{Type: types.MsgFinalResult})
+	if got := (<-tap).Type; got != types.MsgTaskProgress {
+		t.Errorf("tap delivered %s first, want TaskProgress", got)
+	}
+	if got := (<-tap).Type; got != types.MsgFinalResult {
+		t.Errorf("tap delivered %s second, want FinalResult", got)
+	}
+	if got := (<-sub).Type; got != types.MsgFinalResult {
+		t.Errorf("subscriber got %s, want FinalResult", got)
+	}
+}
+
+func TestSubscribePriority_FiltersByType(t *testing.T) {
+	// registers a priority subscriber for the given message types
+	b := New()
+	q := b.SubscribePriority(types.MsgPlanDirective, types.MsgTaskProgress)
+	b.Publish(types.Message{Type: types.MsgSubTask})
+	b.Publish(progress(1))
+	b.Publish(types.Message{Type: types.MsgPlanDirective})
+	if q.Len() != 2 {
+		t.Fatalf("expected 2 queued messages, got %d", q.Len())
+	}
+	if msg, _ := q.TryRecv(); msg.Type != types.MsgPlanDirective {
+		t.Errorf("expected PlanDirective first, got %s", msg.Type)
+	}
+}
+
+func TestQueuePush_OverflowPrefersHighPriority(t *testing.T) {
+	// Evicts the oldest normal message to fit a high-priority one when full
+	q := newQueue(3, nil)
+	for i := 1; i <= 3; i++ {
+		q.push(progress(i))
+	}
+	// Drops a normal message when the queue is full
+	q.push(progress(4))
+	q.push(types.Message{Type: types.MsgFinalResult})
+	want := []string{"FinalResult", "2", "3"}
+	for i, w := range want {
+		msg, ok := q.TryRecv()
+		if !ok {
+			t.Fatalf("delivery %d missing, want %s", i+1, w)
+		}
+		got := string(msg.Type)
+		if p, isProgress := msg.Payload.(types.TaskProgress); isProgress {
+			got = strconv.Itoa(p.CompletedSubtasks)
+		}
+		if got != w {
+			t.Errorf("delivery %d = %s, want %s", i+1, got, w)
+		}
+	}
+
+	// Drops a high-priority message only when every queued message is high-priority
+	q = newQueue(2, nil)
+	q.push(types.Message{Type: types.MsgFinalResult, ID: "a"})
+	q.push(types.Message{Type: types.MsgPlanDirective, ID: "b"})
+	q.push(types.Message{Type: types.MsgFinalResult, ID: "c"})
+	if q.Len() != 2 {
+		t.Fatalf("expected 2 queued messages, got %d", q.Len())
+	}
+	if msg, _ := q.TryRecv(); msg.ID != "a" {
+		t.Errorf("expected the queued high-priority messages to be kept, got %s first", msg.ID)
+	}
+}
+
+func TestQueueRecv_BlocksUntilPublishOrContextEnd(t *testing.T) {
+	// Recv blocks until a message is queued; returns ctx's error when ctx ends first
+	b := New()
+	q := b.SubscribePriority()
+	got := make(chan types.MessageType, 1)
+	go func() {
+		msg, err := q.Recv(t.Context())
+		if err == nil {
+			got <- msg.Type
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	b.Publish(types.Message{Type: types.MsgPlanDirective})
+	select {
+	case typ := <-got:
+		if typ != types.MsgPlanDirective {
+			t.Errorf("got %s, want PlanDirective", typ)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Recv did not return after a publish")
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.Recv(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected DeadlineExceeded on an empty queue, got %v", err)
+	}
+}
+
+func TestPriorityOf(t *testing.T) {
+	// FinalResult and PlanDirective are high priority; everything else is normal
+	for typ, want := range map[types.MessageType]Priority{
+		types.MsgFinalResult:   PriorityHigh,
+		types.MsgPlanDirective: PriorityHigh,
+		types.MsgTaskProgress:  PriorityNormal,
+		types.MsgSubTask:       PriorityNormal,
+	} {
+		if got := PriorityOf(typ); got != want {
+			t.Errorf("PriorityOf(%s) = %d, want %d", typ, got, want)
+		}
+	}
+}
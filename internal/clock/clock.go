@@ -0,0 +1,58 @@
+// Package clock abstracts the wall clock so roles that stamp or age data
+// (R5 memory decay, GGS trajectories, R4b task timing, task logs) can be driven
+// by a FakeClock in tests instead of real elapsed time.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock reports the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// Wall is the real clock: Now returns time.Now().
+var Wall Clock = wall{}
+
+type wall struct{}
+
+func (wall) Now() time.Time { return time.Now() }
+
+// FakeClock is a Clock that only moves when told to. Safe for concurrent use.
+//
+// Expectations:
+//   - Now returns the time set by NewFake until Advance or Set is called
+//   - Advance(d) moves Now forward by exactly d
+//   - Set(t) makes Now return t
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFake returns a FakeClock stopped at t.
+func NewFake(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+// Now returns the fake clock's current time.
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the fake clock forward by d.
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// Set moves the fake clock to t.
+func (f *FakeClock) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = t
+}
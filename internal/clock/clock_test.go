@@ -0,0 +1,33 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock_AdvanceAndSet(t *testing.T) {
+	// Now returns the time set by NewFake until Advance or Set is called;
+	// Advance(d) moves Now forward by exactly d; Set(t) makes Now return t
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	if !c.Now().Equal(start) {
+		t.Fatalf("Now() = %s, want %s", c.Now(), start)
+	}
+	c.Advance(36 * time.Hour)
+	if got := c.Now().Sub(start); got != 36*time.Hour {
+		t.Errorf("after Advance(36h) Now is %s past the start", got)
+	}
+	later := start.Add(30 * 24 * time.Hour)
+	c.Set(later)
+	if !c.Now().Equal(later) {
+		t.Errorf("after Set Now() = %s, want %s", c.Now(), later)
+	}
+}
+
+func TestWall_FollowsRealTime(t *testing.T) {
+	// Wall.Now reads the real clock
+	before := time.Now()
+	if got := Wall.Now(); got.Before(before) || got.Sub(before) > time.Minute {
+		t.Errorf("Wall.Now() = %s, want close to %s", got, before)
+	}
+}
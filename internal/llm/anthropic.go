@@ -0,0 +1,116 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// API styles selectable with {TIER}_API_STYLE.
+const (
+	StyleOpenAI    = "openai"    // POST {base}/chat/completions, Bearer auth (default)
+	StyleAnthropic = "anthropic" // POST {base}/messages, x-api-key auth
+)
+
+// anthropicVersion is the Messages API version sent in the anthropic-version header.
+const anthropicVersion = "2023-06-01"
+
+// anthropicDefaultMaxTokens is sent when no completion cap is configured: the
+// Messages API, unlike chat completions, requires max_tokens on every request.
+const anthropicDefaultMaxTokens = 8192
+
+type anthropicRequest struct {
+	Model     string    `json:"model"`
+	System    string    `json:"system,omitempty"`
+	Messages  []chatMsg `json:"messages"`
+	MaxTokens int       `json:"max_tokens"`
+}
+
+type anthropicResponse struct {
+	Model   string `json:"model"`
+	Content []struct {
+		Type string `json:"type"` // "text" | "thinking" | ...
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"` // "max_tokens" when the cap cut the completion
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// toAnthropic maps a chat-completions payload onto the Messages API: system
+// messages become the top-level system prompt, the rest stay messages.
+//
+// Expectations:
+//   - Moves the system message into System and keeps the user message in Messages
+//   - Sends MaxTokens when set, anthropicDefaultMaxTokens otherwise
+func toAnthropic(p chatRequest) anthropicRequest {
+	r := anthropicRequest{Model: p.Model, MaxTokens: p.MaxTokens}
+	if r.MaxTokens <= 0 {
+		r.MaxTokens = anthropicDefaultMaxTokens
+	}
+	var system []string
+	for _, m := range p.Messages {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+			continue
+		}
+		r.Messages = append(r.Messages, m)
+	}
+	r.System = strings.Join(system, "\n\n")
+	return r
+}
+
+// fromAnthropic decodes a Messages API response body into the chat-completions
+// shape the rest of the client works with.
+//
+// Expectations:
+//   - Joins the text blocks of the content into one choice, skipping non-text blocks
+//   - Maps usage.input_tokens/output_tokens to PromptTokens/CompletionTokens and their sum to TotalTokens
+//   - Maps stop_reason "max_tokens" to finish reason "length"
+//   - Returns an error for an API error body or a response without text
+func fromAnthropic(body []byte) (chatResponse, error) {
+	var ar anthropicResponse
+	if err := json.Unmarshal(body, &ar); err != nil {
+		return chatResponse{}, fmt.Errorf("llm: unmarshal response: %w", err)
+	}
+	if ar.Error != nil {
+		return chatResponse{}, fmt.Errorf("llm: API error: %s", ar.Error.Message)
+	}
+	var text strings.Builder
+	found := false
+	for _, block := range ar.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+			found = true
+		}
+	}
+	if !found {
+		return chatResponse{}, fmt.Errorf("llm: no text content in response")
+	}
+	var choice chatChoice
+	choice.Message.Content = text.String()
+	if ar.StopReason == "max_tokens" {
+		choice.FinishReason = "length"
+	}
+	return chatResponse{
+		Model:   ar.Model,
+		Choices: []chatChoice{choice},
+		Usage: Usage{
+			PromptTokens:     ar.Usage.InputTokens,
+			CompletionTokens: ar.Usage.OutputTokens,
+			TotalTokens:      ar.Usage.InputTokens + ar.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+// setAnthropicHeaders authenticates req for the Messages API.
+func setAnthropicHeaders(req *http.Request, apiKey string) {
+	req.Header.Set("x-api-key", apiKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+}
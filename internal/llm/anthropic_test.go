@@ -0,0 +1,96 @@
+package llm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewTier_ReadsAPIStyle(t *testing.T) {
+	// Sets the API style from {prefix}_API_STYLE (else OPENAI_API_STYLE), lowercased; "" means openai;
+	// strips a trailing "/messages" from the base URL for the anthropic style
+	t.Setenv("OPENAI_API_STYLE", "")
+	t.Setenv("BRAIN_API_STYLE", " Anthropic ")
+	t.Setenv("BRAIN_BASE_URL", "https://api.anthropic.com/v1/messages")
+	if c := NewTier("BRAIN"); c.apiStyle != StyleAnthropic || c.baseURL != "https://api.anthropic.com/v1" {
+		t.Errorf("BRAIN: style %q, base URL %q", c.apiStyle, c.baseURL)
+	}
+	if c := NewTier("TOOL"); c.apiStyle != StyleOpenAI {
+		t.Errorf("TOOL: style %q, want openai by default", c.apiStyle)
+	}
+	t.Setenv("OPENAI_API_STYLE", "anthropic")
+	if c := NewTier("TOOL"); c.apiStyle != StyleAnthropic {
+		t.Errorf("TOOL: style %q, want the shared OPENAI_API_STYLE", c.apiStyle)
+	}
+}
+
+func TestValidate_RejectsUnknownAPIStyle(t *testing.T) {
+	// Returns error naming the value when the API style is not openai or anthropic
+	c := &Client{baseURL: "u", apiKey: "k", model: "m", label: "BRAIN", apiStyle: "gemini"}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), `"gemini"`) {
+		t.Errorf("got %v, want an error naming gemini", err)
+	}
+}
+
+func TestToAnthropic_SplitsSystemPrompt(t *testing.T) {
+	// Moves the system message into System and keeps the user message in Messages;
+	// sends MaxTokens when set, anthropicDefaultMaxTokens otherwise
+	r := toAnthropic(chatRequest{Model: "claude", Messages: []chatMsg{{Role: "system", Content: "be brief"}, {Role: "user", Content: "hi"}}})
+	if r.System != "be brief" || len(r.Messages) != 1 || r.Messages[0].Role != "user" || r.MaxTokens != anthropicDefaultMaxTokens {
+		t.Errorf("got %+v", r)
+	}
+	if r := toAnthropic(chatRequest{MaxTokens: 1}); r.MaxTokens != 1 {
+		t.Errorf("max_tokens = %d, want 1", r.MaxTokens)
+	}
+}
+
+func TestFromAnthropic(t *testing.T) {
+	// Joins the text blocks, skipping non-text ones; maps usage and stop_reason "max_tokens" to "length";
+	// returns an error for an API error body or a response without text
+	cr, err := fromAnthropic([]byte(`{"model":"claude-x","content":[{"type":"thinking","thinking":"hmm"},{"type":"text","text":"{\"a\":"},{"type":"text","text":"1}"}],
+		"stop_reason":"max_tokens","usage":{"input_tokens":12,"output_tokens":5}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cr.Model != "claude-x" || cr.Choices[0].Message.Content != `{"a":1}` || cr.Choices[0].FinishReason != "length" {
+		t.Errorf("got %+v", cr)
+	}
+	if cr.Usage != (Usage{PromptTokens: 12, CompletionTokens: 5, TotalTokens: 17}) {
+		t.Errorf("usage = %+v", cr.Usage)
+	}
+	if _, err := fromAnthropic([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)); err == nil || !strings.Contains(err.Error(), "Overloaded") {
+		t.Errorf("expected the API error, got %v", err)
+	}
+	if _, err := fromAnthropic([]byte(`{"content":[]}`)); err == nil {
+		t.Error("expected an error for a response without text")
+	}
+}
+
+func TestChat_AnthropicStyle(t *testing.T) {
+	// Chat posts to {base}/messages with x-api-key auth and returns the text and token usage
+	var body anthropicRequest
+	var path, key, version, auth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, key, version, auth = r.URL.Path, r.Header.Get("x-api-key"), r.Header.Get("anthropic-version"), r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"content":[{"type":"text","text":"hello"}],"stop_reason":"end_turn","usage":{"input_tokens":7,"output_tokens":2}}`))
+	}))
+	defer ts.Close()
+	c := &Client{baseURL: ts.URL + "/v1", apiKey: "sk-ant", model: "claude-x", label: "BRAIN", apiStyle: StyleAnthropic, httpClient: http.DefaultClient}
+
+	out, usage, err := c.Chat(t.Context(), "system text", "user text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "hello" || usage.PromptTokens != 7 || usage.CompletionTokens != 2 {
+		t.Errorf("got %q, %+v", out, usage)
+	}
+	if path != "/v1/messages" || key != "sk-ant" || version != anthropicVersion || auth != "" {
+		t.Errorf("request to %s with key %q, version %q, Authorization %q", path, key, version, auth)
+	}
+	if body.System != "system text" || len(body.Messages) != 1 || body.Messages[0].Content != "user text" || body.Model != "claude-x" {
+		t.Errorf("request body = %+v", body)
+	}
+}
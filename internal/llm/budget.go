@@ -0,0 +1,246 @@
+package llm
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// EstimateTokens is a provider-independent estimate of how many tokens s costs:
+// about four bytes per token for ASCII text, one token per non-ASCII rune (CJK
+// text runs close to a token per character). It errs on the high side.
+//
+// Expectations:
+//   - Returns 0 for ""
+//   - Counts ASCII at one token per four bytes, rounded up
+//   - Counts each non-ASCII rune as one token
+func EstimateTokens(s string) int {
+	ascii, other := 0, 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// MaxPromptTokens returns the prompt-token budget for a role ("executor",
+// "metaval", "planner", ...) from ARTOO_<ROLE>_MAX_PROMPT_TOKENS, falling back
+// to ARTOO_MAX_PROMPT_TOKENS. Zero means no budget.
+//
+// Expectations:
+//   - Returns the role-specific value when set to a positive integer
+//   - Falls back to ARTOO_MAX_PROMPT_TOKENS when the role variable is unset or invalid
+//   - Returns 0 when neither is a positive integer
+func MaxPromptTokens(role string) int {
+	for _, key := range []string{"ARTOO_" + strings.ToUpper(role) + "_MAX_PROMPT_TOKENS", "ARTOO_MAX_PROMPT_TOKENS"} {
+		if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// defaultMaxOutputTokens caps each role's completions when neither
+// ARTOO_<ROLE>_MAX_OUTPUT_TOKENS nor {TIER}_MAX_OUTPUT_TOKENS is set. Roles that
+// write plans or merge outputs get more room than those answering with a verdict.
+var defaultMaxOutputTokens = map[string]int{
+	"planner":   16384,
+	"metaval":   16384,
+	"executor":  8192,
+	"perceiver": 4096,
+	"agentval":  4096,
+}
+
+// maxOutputTokens resolves a role's completion cap: ARTOO_<ROLE>_MAX_OUTPUT_TOKENS,
+// else tierMax (from {TIER}_MAX_OUTPUT_TOKENS), else the role's default. Zero means
+// no cap.
+//
+// Expectations:
+//   - Returns the role-specific value when set to a positive integer, even when tierMax is set
+//   - Falls back to tierMax when the role variable is unset or invalid and tierMax > 0
+//   - Falls back to defaultMaxOutputTokens[role] otherwise (0 for roles without a default)
+func maxOutputTokens(role string, tierMax int) int {
+	if n, err := strconv.Atoi(os.Getenv("ARTOO_" + strings.ToUpper(role) + "_MAX_OUTPUT_TOKENS")); err == nil && n > 0 {
+		return n
+	}
+	if tierMax > 0 {
+		return tierMax
+	}
+	return defaultMaxOutputTokens[role]
+}
+
+// defaultCallTimeout bounds one Chat call when neither ARTOO_<ROLE>_LLM_TIMEOUT
+// nor ARTOO_LLM_TIMEOUT is set.
+const defaultCallTimeout = 120 * time.Second
+
+// callTimeout resolves how long one Chat call for role may wait for the backend:
+// ARTOO_<ROLE>_LLM_TIMEOUT, else ARTOO_LLM_TIMEOUT, else defaultCallTimeout.
+// Values are Go durations ("90s", "5m"); an empty role reads only ARTOO_LLM_TIMEOUT.
+//
+// Expectations:
+//   - Returns the role-specific value when set to a positive duration
+//   - Falls back to ARTOO_LLM_TIMEOUT when the role variable is unset or invalid
+//   - Returns defaultCallTimeout when neither is a positive duration
+func callTimeout(role string) time.Duration {
+	keys := []string{"ARTOO_LLM_TIMEOUT"}
+	if role != "" {
+		keys = append([]string{"ARTOO_" + strings.ToUpper(role) + "_LLM_TIMEOUT"}, keys...)
+	}
+	for _, key := range keys {
+		if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultCallTimeout
+}
+
+// SystemPromptFile is the environment variable naming a file whose contents
+// replace role's system prompt, e.g. ARTOO_EXECUTOR_SYSTEM_PROMPT_FILE. It is
+// how prompt variants are tried without recompiling (see --ab-role).
+func SystemPromptFile(role string) string {
+	return "ARTOO_" + strings.ToUpper(role) + "_SYSTEM_PROMPT_FILE"
+}
+
+// systemPromptOverride returns the contents of role's SystemPromptFile, or ""
+// when it is unset, unreadable, or blank (the built-in prompt is kept).
+//
+// Expectations:
+//   - Returns the file's contents, trimmed, when the variable names a readable file
+//   - Returns "" for an empty role, an unset variable, a missing file, or a blank file
+func systemPromptOverride(role string) string {
+	if role == "" {
+		return ""
+	}
+	key := SystemPromptFile(role)
+	path := os.Getenv(key)
+	if path == "" {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("[LLM] system prompt override unreadable; keeping the built-in prompt", "var", key, "error", err)
+		return ""
+	}
+	prompt := strings.TrimSpace(string(data))
+	if prompt != "" {
+		slog.Info("[LLM] system prompt overridden", "role", role, "file", path, "bytes", len(prompt))
+	}
+	return prompt
+}
+
+// TruncateTokens shortens s to about maxTokens estimated tokens, keeping the
+// first third and the last two thirds around a truncation marker, so both the
+// context and the latest content survive.
+//
+// Expectations:
+//   - Returns s unchanged when it already fits
+//   - Result is within maxTokens estimated tokens
+//   - Keeps the head and tail of s and marks the cut
+//   - Never splits a UTF-8 rune
+func TruncateTokens(s string, maxTokens int) string {
+	if EstimateTokens(s) <= maxTokens {
+		return s
+	}
+	const marker = "\n...[truncated to fit the prompt budget]...\n"
+	room := maxTokens - EstimateTokens(marker)
+	if room <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	head := prefixWithin(runes, room/3)
+	tail := suffixWithin(runes[len(head):], room-EstimateTokens(string(head)))
+	return string(head) + marker + string(tail)
+}
+
+// prefixWithin returns the longest prefix of runes within maxTokens.
+func prefixWithin(runes []rune, maxTokens int) []rune {
+	n := largestFitting(len(runes), func(i int) bool { return EstimateTokens(string(runes[:i])) > maxTokens })
+	return runes[:n]
+}
+
+// suffixWithin returns the longest suffix of runes within maxTokens.
+func suffixWithin(runes []rune, maxTokens int) []rune {
+	n := largestFitting(len(runes), func(i int) bool { return EstimateTokens(string(runes[len(runes)-i:])) > maxTokens })
+	return runes[len(runes)-n:]
+}
+
+// largestFitting returns the largest i in [0, n] for which tooBig(i) is false,
+// assuming tooBig is monotonic.
+func largestFitting(n int, tooBig func(int) bool) int {
+	lo, hi := 0, n
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if tooBig(mid) {
+			hi = mid - 1
+		} else {
+			lo = mid
+		}
+	}
+	return lo
+}
+
+// FitParts keeps the newest parts that fit, with fixed, within maxTokens.
+// Parts are ordered oldest first and the oldest are dropped first; a note
+// saying how many of what were omitted takes their place as the first element.
+// The newest part is always kept, shortened with TruncateTokens when it does
+// not fit on its own. A budget of 0 or less keeps everything.
+//
+// Expectations:
+//   - Returns parts unchanged when maxTokens <= 0 or everything fits
+//   - Drops the oldest parts first and leads with a note counting them
+//   - Always keeps the newest part, truncated if it alone exceeds the room left
+//   - fixed plus the returned parts fit within maxTokens
+func FitParts(maxTokens int, fixed, what string, parts []string) []string {
+	if maxTokens <= 0 || len(parts) == 0 {
+		return parts
+	}
+	room := maxTokens - EstimateTokens(fixed)
+	total := 0
+	for _, p := range parts {
+		total += EstimateTokens(p)
+	}
+	if total <= room {
+		return parts
+	}
+	if len(parts) == 1 {
+		return []string{TruncateTokens(parts[0], room)}
+	}
+	room -= EstimateTokens(droppedNote(len(parts), what))
+	used := 0
+	start := len(parts)
+	for start > 1 && used+EstimateTokens(parts[start-1]) <= room {
+		start--
+		used += EstimateTokens(parts[start])
+	}
+	if start == len(parts) {
+		start--
+		return []string{droppedNote(start, what), TruncateTokens(parts[start], room)}
+	}
+	return append([]string{droppedNote(start, what)}, parts[start:]...)
+}
+
+// FitUser shortens user so that system plus user fit within maxTokens, as a
+// last resort for roles whose prompt has no finer-grained context to drop.
+// A budget of 0 or less returns user unchanged.
+//
+// Expectations:
+//   - Returns user unchanged when maxTokens <= 0 or the prompt fits
+//   - Otherwise returns user truncated (head and tail kept) so the prompt fits
+func FitUser(maxTokens int, system, user string) string {
+	if maxTokens <= 0 {
+		return user
+	}
+	return TruncateTokens(user, maxTokens-EstimateTokens(system))
+}
+
+// droppedNote stands in for n context items left out to fit the prompt budget.
+func droppedNote(n int, what string) string {
+	return fmt.Sprintf("[%d earlier %s omitted to fit the prompt budget]\n", n, what)
+}
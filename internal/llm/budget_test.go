@@ -0,0 +1,141 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+)
+
+// ── EstimateTokens ────────────────────────────────────────────────────────────
+
+func TestEstimateTokens_CountsASCIIAndRunes(t *testing.T) {
+	// Counts ASCII at one token per four bytes, rounded up; each non-ASCII rune as one token
+	cases := map[string]int{"": 0, "abcd": 1, "abcde": 2, "查找文件": 4, "ab查": 2}
+	for s, want := range cases {
+		if got := EstimateTokens(s); got != want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+// ── MaxPromptTokens ───────────────────────────────────────────────────────────
+
+func TestMaxPromptTokens_RoleOverridesShared(t *testing.T) {
+	// Returns the role-specific value when set to a positive integer
+	t.Setenv("ARTOO_MAX_PROMPT_TOKENS", "8000")
+	t.Setenv("ARTOO_EXECUTOR_MAX_PROMPT_TOKENS", "4000")
+	if got := MaxPromptTokens("executor"); got != 4000 {
+		t.Errorf("expected 4000, got %d", got)
+	}
+	if got := MaxPromptTokens("planner"); got != 8000 {
+		t.Errorf("expected the shared 8000 for planner, got %d", got)
+	}
+}
+
+func TestMaxPromptTokens_InvalidFallsBack(t *testing.T) {
+	// Falls back to ARTOO_MAX_PROMPT_TOKENS when the role variable is unset or invalid
+	t.Setenv("ARTOO_MAX_PROMPT_TOKENS", "8000")
+	t.Setenv("ARTOO_METAVAL_MAX_PROMPT_TOKENS", "lots")
+	if got := MaxPromptTokens("metaval"); got != 8000 {
+		t.Errorf("expected 8000, got %d", got)
+	}
+}
+
+func TestMaxPromptTokens_ZeroWhenUnset(t *testing.T) {
+	// Returns 0 when neither is a positive integer
+	t.Setenv("ARTOO_MAX_PROMPT_TOKENS", "-1")
+	t.Setenv("ARTOO_AGENTVAL_MAX_PROMPT_TOKENS", "")
+	if got := MaxPromptTokens("agentval"); got != 0 {
+		t.Errorf("expected 0, got %d", got)
+	}
+}
+
+// ── TruncateTokens ────────────────────────────────────────────────────────────
+
+func TestTruncateTokens_KeepsHeadAndTailWithinBudget(t *testing.T) {
+	// Result is within maxTokens estimated tokens; keeps the head and tail of s and marks the cut
+	s := "HEAD " + strings.Repeat("middle ", 2000) + " TAIL"
+	got := TruncateTokens(s, 200)
+	if n := EstimateTokens(got); n > 200 {
+		t.Errorf("expected at most 200 tokens, got %d", n)
+	}
+	if !strings.HasPrefix(got, "HEAD ") || !strings.HasSuffix(got, " TAIL") || !strings.Contains(got, "truncated to fit the prompt budget") {
+		t.Errorf("expected head, tail, and marker, got %q", got)
+	}
+}
+
+func TestTruncateTokens_UnchangedWhenFits(t *testing.T) {
+	// Returns s unchanged when it already fits
+	if got := TruncateTokens("short", 10); got != "short" {
+		t.Errorf("expected unchanged, got %q", got)
+	}
+}
+
+func TestTruncateTokens_KeepsRunesWhole(t *testing.T) {
+	// Never splits a UTF-8 rune
+	got := TruncateTokens(strings.Repeat("数据", 500), 100)
+	if !strings.HasPrefix(got, "数") || strings.ContainsRune(got, '�') {
+		t.Errorf("expected whole runes, got %q", got)
+	}
+}
+
+// ── FitParts ──────────────────────────────────────────────────────────────────
+
+func TestFitParts_UnchangedWhenNoBudgetOrFits(t *testing.T) {
+	// Returns parts unchanged when maxTokens <= 0 or everything fits
+	parts := []string{"one\n", "two\n"}
+	if got := FitParts(0, strings.Repeat("x", 1000), "items", parts); len(got) != 2 {
+		t.Errorf("expected both parts with no budget, got %v", got)
+	}
+	if got := FitParts(100, "fixed", "items", parts); len(got) != 2 {
+		t.Errorf("expected both parts when they fit, got %v", got)
+	}
+}
+
+func TestFitParts_DropsOldestFirst(t *testing.T) {
+	// Drops the oldest parts first and leads with a note counting them; fixed plus the returned parts fit within maxTokens
+	var parts []string
+	for i := 0; i < 10; i++ {
+		parts = append(parts, strings.Repeat(string(rune('a'+i)), 400)+"\n") // ~101 tokens each
+	}
+	fixed := strings.Repeat("f", 400)
+	got := FitParts(450, fixed, "tool results", parts)
+	if len(got) < 2 || got[0] != "[7 earlier tool results omitted to fit the prompt budget]\n" {
+		t.Fatalf("expected a note for 7 dropped parts first, got %d parts: %q", len(got), got[0])
+	}
+	if got[len(got)-1] != parts[9] || got[1] != parts[7] {
+		t.Errorf("expected the newest three parts kept in order")
+	}
+	if n := EstimateTokens(fixed + strings.Join(got, "")); n > 450 {
+		t.Errorf("expected at most 450 tokens, got %d", n)
+	}
+}
+
+func TestFitParts_TruncatesNewestWhenAloneTooBig(t *testing.T) {
+	// Always keeps the newest part, truncated if it alone exceeds the room left
+	parts := []string{"old\n", "NEW " + strings.Repeat("z", 4000) + " END"}
+	got := FitParts(300, "", "tool results", parts)
+	if len(got) != 2 || !strings.HasPrefix(got[1], "NEW ") || !strings.HasSuffix(got[1], " END") {
+		t.Fatalf("expected the note and the truncated newest part, got %q", got)
+	}
+	if n := EstimateTokens(strings.Join(got, "")); n > 300 {
+		t.Errorf("expected at most 300 tokens, got %d", n)
+	}
+}
+
+// ── FitUser ───────────────────────────────────────────────────────────────────
+
+func TestFitUser_TruncatesToFitWithSystem(t *testing.T) {
+	// Otherwise returns user truncated (head and tail kept) so the prompt fits
+	system := strings.Repeat("s", 400)
+	user := "task: " + strings.Repeat("evidence ", 1000) + "criteria: done"
+	got := FitUser(500, system, user)
+	if n := EstimateTokens(system) + EstimateTokens(got); n > 500 {
+		t.Errorf("expected at most 500 tokens, got %d", n)
+	}
+	if !strings.HasPrefix(got, "task: ") || !strings.HasSuffix(got, "criteria: done") {
+		t.Errorf("expected head and tail kept, got %q", got)
+	}
+	if FitUser(0, system, user) != user {
+		t.Error("expected user unchanged with no budget")
+	}
+}
@@ -4,12 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -19,11 +20,22 @@ type Client struct {
 	baseURL        string
 	apiKey         string
 	model          string
-	label          string // tier name used in debug log lines (e.g. "R1", "BRAIN", "TOOL")
-	enableThinking bool   // sends "enable_thinking":true in the request body (Kimi thinking mode)
+	label          string        // tier name used in debug log lines (e.g. "R1", "BRAIN", "TOOL")
+	enableThinking bool          // sends "enable_thinking":true in the request body (Kimi thinking mode)
+	reasoning      string        // sends "reasoning_effort" (low|medium|high) when non-empty; see reasoningEfforts
+	maxOutput      int           // sends "max_tokens" when > 0; {prefix}_MAX_OUTPUT_TOKENS, per role via ForRole
+	timeout        time.Duration // bounds each Chat call; 0 = only ctx; ARTOO_LLM_TIMEOUT, per role via ForRole
+	apiStyle       string        // StyleOpenAI or StyleAnthropic; {prefix}_API_STYLE
 	httpClient     *http.Client
+	replay         *Replay // when set, Chat answers from recorded calls first; see SetReplay
+	system         string  // the role's primary system prompt override; per role via ForRole, see SystemPrompt
 }
 
+// ErrTimeout is returned (wrapped) by Chat when the backend has not answered
+// within the client's call timeout. The message says "timed out", which R4a
+// classifies as an environmental failure.
+var ErrTimeout = errors.New("llm: timed out waiting for the backend")
+
 // normalizeBaseURL strips trailing slashes and the "/chat/completions" suffix
 // from a raw OPENAI_BASE_URL value so the path is never doubled when the
 // client appends "/chat/completions" itself.
@@ -56,13 +68,21 @@ func New() *Client {
 //	BRAIN_API_KEY        → OPENAI_API_KEY
 //	BRAIN_BASE_URL       → OPENAI_BASE_URL
 //	BRAIN_MODEL          → OPENAI_MODEL
+//	BRAIN_API_STYLE      → OPENAI_API_STYLE (openai | anthropic; defaults openai)
 //	BRAIN_ENABLE_THINKING (no fallback; defaults false)
+//	BRAIN_REASONING_EFFORT (no fallback; unset sends nothing)
+//	BRAIN_MAX_OUTPUT_TOKENS (no fallback; unset sends no max_tokens unless ForRole sets one)
 //
 // Expectations:
 //   - Uses {prefix}_API_KEY / _BASE_URL / _MODEL when set and non-empty
 //   - Falls back to OPENAI_* vars for any unset tier-specific var
 //   - Sets enableThinking when {prefix}_ENABLE_THINKING == "true"
+//   - Sets the reasoning effort from {prefix}_REASONING_EFFORT, lowercased and trimmed
+//   - Sets the completion cap from {prefix}_MAX_OUTPUT_TOKENS when it is a positive integer
 //   - Empty prefix reads only OPENAI_* (identical to New())
+//   - Sets the API style from {prefix}_API_STYLE (else OPENAI_API_STYLE), lowercased; "" means openai
+//   - Strips a trailing "/messages" from the base URL for the anthropic style
+//   - Shares its HTTP transport with every other tier whose base URL has the same scheme and host
 func NewTier(prefix string) *Client {
 	get := func(suffix, fallback string) string {
 		if prefix != "" {
@@ -73,27 +93,40 @@ func NewTier(prefix string) *Client {
 		return os.Getenv(fallback)
 	}
 	enableThinking := prefix != "" && os.Getenv(prefix+"_ENABLE_THINKING") == "true"
+	var reasoning string
+	var maxOutput int
+	if prefix != "" {
+		reasoning = strings.ToLower(strings.TrimSpace(os.Getenv(prefix + "_REASONING_EFFORT")))
+		if n, err := strconv.Atoi(os.Getenv(prefix + "_MAX_OUTPUT_TOKENS")); err == nil && n > 0 {
+			maxOutput = n
+		}
+	}
 	label := prefix
 	if label == "" {
 		label = "LLM"
 	}
+	apiStyle := strings.ToLower(strings.TrimSpace(get("API_STYLE", "OPENAI_API_STYLE")))
+	if apiStyle == "" {
+		apiStyle = StyleOpenAI
+	}
+	baseURL := normalizeBaseURL(get("BASE_URL", "OPENAI_BASE_URL"))
+	if apiStyle == StyleAnthropic {
+		baseURL = strings.TrimSuffix(baseURL, "/messages")
+	}
 	return &Client{
-		baseURL:        normalizeBaseURL(get("BASE_URL", "OPENAI_BASE_URL")),
+		baseURL:        baseURL,
 		apiKey:         get("API_KEY", "OPENAI_API_KEY"),
 		model:          get("MODEL", "OPENAI_MODEL"),
 		label:          label,
 		enableThinking: enableThinking,
-		httpClient: &http.Client{
-			Timeout: 120 * time.Second,
-			Transport: &http.Transport{
-				DialContext: (&net.Dialer{
-					Timeout:   10 * time.Second,
-					KeepAlive: 30 * time.Second,
-				}).DialContext,
-				TLSHandshakeTimeout:   10 * time.Second,
-				ResponseHeaderTimeout: 30 * time.Second,
-			},
-		},
+		reasoning:      reasoning,
+		maxOutput:      maxOutput,
+		timeout:        callTimeout(""),
+		apiStyle:       apiStyle,
+		// No overall http.Client timeout: each Chat call derives its own deadline
+		// from timeout, so a longer ARTOO_LLM_TIMEOUT is not cut short here. Tiers
+		// on the same backend share one connection pool (see sharedTransport).
+		httpClient: &http.Client{Transport: sharedTransport(baseURL)},
 	}
 }
 
@@ -101,8 +134,15 @@ type chatRequest struct {
 	Model          string    `json:"model"`
 	Messages       []chatMsg `json:"messages"`
 	EnableThinking bool      `json:"enable_thinking,omitempty"`
+	// ReasoningEffort is the OpenAI-style reasoning control honoured by reasoning
+	// models; omitted when unset so other providers never see it.
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+	MaxTokens       int    `json:"max_tokens,omitempty"` // completion cap; omitted when 0
 }
 
+// reasoningEfforts are the accepted {TIER}_REASONING_EFFORT values.
+var reasoningEfforts = map[string]bool{"low": true, "medium": true, "high": true}
+
 type chatMsg struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
@@ -117,17 +157,21 @@ type Usage struct {
 }
 
 type chatResponse struct {
-	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
-	Usage Usage `json:"usage"`
-	Error *struct {
+	Model   string       `json:"model"` // the model that answered; may differ from the requested alias
+	Choices []chatChoice `json:"choices"`
+	Usage   Usage        `json:"usage"`
+	Error   *struct {
 		Message string `json:"message"`
 	} `json:"error,omitempty"`
 }
 
+type chatChoice struct {
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	FinishReason string `json:"finish_reason"` // "length" when max_tokens cut the completion
+}
+
 // Validate checks that the client has the minimum required configuration
 // (base URL, API key, model). Returns a non-nil error describing what is missing.
 //
@@ -138,6 +182,8 @@ type chatResponse struct {
 //   - Returns error listing "model" when model is empty
 //   - Returns error listing all missing fields comma-separated when multiple are empty
 //   - Error message includes the tier label
+//   - Returns error naming the value when the reasoning effort is set but not low, medium, or high
+//   - Returns error naming the value when the API style is not openai or anthropic
 func (c *Client) Validate() error {
 	var missing []string
 	if c.baseURL == "" {
@@ -152,70 +198,232 @@ func (c *Client) Validate() error {
 	if len(missing) > 0 {
 		return fmt.Errorf("%s tier: missing %s", c.label, strings.Join(missing, ", "))
 	}
+	if c.reasoning != "" && !reasoningEfforts[c.reasoning] {
+		return fmt.Errorf("%s tier: reasoning effort %q: want low, medium, or high", c.label, c.reasoning)
+	}
+	if c.apiStyle != "" && c.apiStyle != StyleOpenAI && c.apiStyle != StyleAnthropic {
+		return fmt.Errorf("%s tier: API style %q: want %s or %s", c.label, c.apiStyle, StyleOpenAI, StyleAnthropic)
+	}
 	return nil
 }
 
+// Label returns the tier name the client was built for ("LLM" for the shared tier).
+func (c *Client) Label() string { return c.label }
+
+// Model returns the configured model name.
+func (c *Client) Model() string { return c.model }
+
+// BaseURL returns the normalized API base URL.
+func (c *Client) BaseURL() string { return c.baseURL }
+
+// MaxOutputTokens returns the completion cap sent as max_tokens, or 0 for none.
+func (c *Client) MaxOutputTokens() int { return c.maxOutput }
+
+// Timeout returns how long one Chat call may wait for the backend, or 0 for no bound.
+func (c *Client) Timeout() time.Duration { return c.timeout }
+
+// ForRole returns a copy of c whose completions are capped for role ("planner",
+// "agentval", ...): ARTOO_<ROLE>_MAX_OUTPUT_TOKENS wins over the tier's
+// {TIER}_MAX_OUTPUT_TOKENS, which wins over the role's built-in default. Each
+// call is bounded by ARTOO_<ROLE>_LLM_TIMEOUT, else ARTOO_LLM_TIMEOUT, else 120s.
+// ARTOO_<ROLE>_SYSTEM_PROMPT_FILE supplies the role's primary system prompt
+// (see SystemPrompt and SystemPromptFile). The copy shares c's HTTP client and
+// replay. Returns nil for a nil c.
+//
+// Expectations:
+//   - Returns nil when c is nil
+//   - Uses the role variable over the tier value, and the tier value over the role default
+//   - Uses ARTOO_<ROLE>_LLM_TIMEOUT over ARTOO_LLM_TIMEOUT for the call timeout
+//   - SystemPrompt returns the ARTOO_<ROLE>_SYSTEM_PROMPT_FILE contents instead of the built-in prompt
+//   - SystemPrompt keeps the built-in prompt when that file is unset, unreadable, or blank
+//   - Leaves c itself unchanged
+func (c *Client) ForRole(role string) *Client {
+	if c == nil {
+		return nil
+	}
+	rc := *c
+	rc.maxOutput = maxOutputTokens(role, c.maxOutput)
+	rc.timeout = callTimeout(role)
+	rc.system = systemPromptOverride(role)
+	return &rc
+}
+
+// SystemPrompt returns the system prompt for the role's primary call: the
+// ARTOO_<ROLE>_SYSTEM_PROMPT_FILE override set by ForRole, or builtin when there
+// is none. Roles apply it to their main prompt only, so helper calls (query
+// rewrites, synthesis, chat replies) keep their own prompts. Safe on a nil c.
+func (c *Client) SystemPrompt(builtin string) string {
+	if c == nil || c.system == "" {
+		return builtin
+	}
+	return c.system
+}
+
+// SetReplay puts the client in replay mode: Chat answers from r's recorded calls
+// and only reaches the backend on a miss when r.Live() is true. nil turns replay off.
+func (c *Client) SetReplay(r *Replay) { c.replay = r }
+
 // Chat sends a system + user prompt and returns the assistant's text response and token usage.
+//
+// Expectations:
+//   - In replay mode, returns the matching recorded response and its token counts without any HTTP call
+//   - In replay mode, returns an error wrapping ErrReplayMiss on a miss unless live fallback is on
+//   - Returns an error wrapping ErrTimeout when the backend has not answered within the call timeout
+//   - Returns ctx's own error, not ErrTimeout, when the caller's ctx ends first
 func (c *Client) Chat(ctx context.Context, system, user string) (string, Usage, error) {
 	slog.Debug("[LLM] system prompt", "role", c.label, "prompt", system)
 	slog.Debug("[LLM] user prompt", "role", c.label, "prompt", user)
 
+	if c.replay != nil {
+		if rc, ok := c.replay.next(system, user); ok {
+			slog.Debug("[LLM] replayed response", "role", c.label, "recorded_role", rc.Role, "response", rc.Response)
+			return rc.Response, Usage{
+				PromptTokens:     rc.PromptTokens,
+				CompletionTokens: rc.CompletionTokens,
+				TotalTokens:      rc.PromptTokens + rc.CompletionTokens,
+			}, nil
+		}
+		if !c.replay.Live() {
+			return "", Usage{}, fmt.Errorf("%s tier: %w", c.label, ErrReplayMiss)
+		}
+		slog.Info("[LLM] replay miss, calling backend", "role", c.label)
+	}
+
 	payload := chatRequest{
 		Model: c.model,
 		Messages: []chatMsg{
 			{Role: "system", Content: system},
 			{Role: "user", Content: user},
 		},
-		EnableThinking: c.enableThinking,
+		EnableThinking:  c.enableThinking,
+		ReasoningEffort: c.reasoning,
+		MaxTokens:       c.maxOutput,
 	}
 
-	body, err := json.Marshal(payload)
+	callCtx := ctx
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	chatResp, elapsedMs, err := c.send(callCtx, payload)
 	if err != nil {
-		return "", Usage{}, fmt.Errorf("llm: marshal request: %w", err)
+		// A hung backend becomes a timeout error the role handles like any other
+		// environmental failure, instead of stalling the pipeline.
+		if ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+			slog.Warn("[LLM] call timed out", "role", c.label, "timeout", c.timeout)
+			return "", Usage{}, fmt.Errorf("%s tier: no response after %s: %w", c.label, c.timeout, ErrTimeout)
+		}
+		return "", Usage{}, err
 	}
 
+	content := chatResp.Choices[0].Message.Content
+	chatResp.Usage.ElapsedMs = elapsedMs
+	if chatResp.Choices[0].FinishReason == "length" {
+		slog.Warn("[LLM] completion hit max_tokens and was cut off", "role", c.label, "max_tokens", c.maxOutput, "completion_tokens", chatResp.Usage.CompletionTokens)
+	}
+	slog.Debug("[LLM] response", "role", c.label, "prompt_tokens", chatResp.Usage.PromptTokens, "completion_tokens", chatResp.Usage.CompletionTokens, "elapsed_ms", elapsedMs, "response", content)
+	return content, chatResp.Usage, nil
+}
+
+// send posts payload to the chat completions endpoint (or, for the anthropic
+// style, the messages endpoint) and returns the decoded response with at least
+// one choice, and the wall-clock ms the call took.
+func (c *Client) send(ctx context.Context, payload chatRequest) (chatResponse, int64, error) {
+	anthropic := c.apiStyle == StyleAnthropic
+	var body []byte
+	var err error
 	url := c.baseURL + "/chat/completions"
+	if anthropic {
+		url = c.baseURL + "/messages"
+		body, err = json.Marshal(toAnthropic(payload))
+	} else {
+		body, err = json.Marshal(payload)
+	}
+	if err != nil {
+		return chatResponse{}, 0, fmt.Errorf("llm: marshal request: %w", err)
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
-		return "", Usage{}, fmt.Errorf("llm: create request: %w", err)
+		return chatResponse{}, 0, fmt.Errorf("llm: create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	if anthropic {
+		setAnthropicHeaders(req, c.apiKey)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	start := time.Now()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", Usage{}, fmt.Errorf("llm: http request: %w", err)
+		return chatResponse{}, 0, fmt.Errorf("llm: http request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	elapsedMs := time.Since(start).Milliseconds()
 	if err != nil {
-		return "", Usage{}, fmt.Errorf("llm: read response: %w", err)
+		return chatResponse{}, elapsedMs, fmt.Errorf("llm: read response: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", Usage{}, fmt.Errorf("llm: HTTP %d: %s", resp.StatusCode, string(respBody))
+		return chatResponse{}, elapsedMs, fmt.Errorf("llm: HTTP %d: %s", resp.StatusCode, string(respBody))
+	}
+	if anthropic {
+		chatResp, err := fromAnthropic(respBody)
+		return chatResp, elapsedMs, err
 	}
 
 	var chatResp chatResponse
 	if err := json.Unmarshal(respBody, &chatResp); err != nil {
-		return "", Usage{}, fmt.Errorf("llm: unmarshal response: %w", err)
+		return chatResponse{}, elapsedMs, fmt.Errorf("llm: unmarshal response: %w", err)
 	}
 
 	if chatResp.Error != nil {
-		return "", Usage{}, fmt.Errorf("llm: API error: %s", chatResp.Error.Message)
+		return chatResponse{}, elapsedMs, fmt.Errorf("llm: API error: %s", chatResp.Error.Message)
 	}
 
 	if len(chatResp.Choices) == 0 {
-		return "", Usage{}, fmt.Errorf("llm: no choices in response")
+		return chatResponse{}, elapsedMs, fmt.Errorf("llm: no choices in response")
 	}
+	return chatResp, elapsedMs, nil
+}
 
-	content := chatResp.Choices[0].Message.Content
-	chatResp.Usage.ElapsedMs = elapsedMs
-	slog.Debug("[LLM] response", "role", c.label, "prompt_tokens", chatResp.Usage.PromptTokens, "completion_tokens", chatResp.Usage.CompletionTokens, "elapsed_ms", elapsedMs, "response", content)
-	return content, chatResp.Usage, nil
+// PingResult is what one successful Ping learned about a tier.
+type PingResult struct {
+	Model   string        // the model that answered, or the configured one when the response omits it
+	Latency time.Duration // round trip of the one-token request
+}
+
+// Ping sends a minimal one-token chat request to check that the tier's backend
+// is reachable, the key is accepted, and the model exists. It always contacts
+// the backend, even in replay mode.
+//
+// Expectations:
+//   - Returns the Validate error without any HTTP call when configuration is missing
+//   - Returns the answering model and the round-trip latency on success
+//   - Falls back to the configured model when the response names none
+//   - Returns an error for an unreachable server, a non-200 status, or an API error
+func (c *Client) Ping(ctx context.Context) (PingResult, error) {
+	if err := c.Validate(); err != nil {
+		return PingResult{}, err
+	}
+	start := time.Now()
+	resp, _, err := c.send(ctx, chatRequest{
+		Model:     c.model,
+		Messages:  []chatMsg{{Role: "user", Content: "ping"}},
+		MaxTokens: 1,
+	})
+	if err != nil {
+		return PingResult{}, fmt.Errorf("%s tier: %w", c.label, err)
+	}
+	res := PingResult{Model: resp.Model, Latency: time.Since(start)}
+	if res.Model == "" {
+		res.Model = c.model
+	}
+	return res, nil
 }
 
 // StripThinkBlocks removes all <think>...</think> blocks from s.
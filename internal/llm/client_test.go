@@ -1,9 +1,17 @@
 package llm
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNormalizeBaseURL_StripsChatCompletionsSuffix(t *testing.T) {
@@ -95,6 +103,166 @@ func TestNewTier_SetsEnableThinkingWhenTrue(t *testing.T) {
 	}
 }
 
+func TestNewTier_ReadsReasoningEffort(t *testing.T) {
+	// Sets the reasoning effort from {prefix}_REASONING_EFFORT, lowercased and trimmed
+	t.Setenv("BRAIN_REASONING_EFFORT", " High ")
+	if c := NewTier("BRAIN"); c.reasoning != "high" {
+		t.Errorf("reasoning: got %q, want high", c.reasoning)
+	}
+	if c := NewTier("TOOL"); c.reasoning != "" {
+		t.Errorf("expected no reasoning effort for TOOL, got %q", c.reasoning)
+	}
+}
+
+// chatRequestBody runs one Chat call against a stub server and returns the
+// decoded request body.
+func chatRequestBody(t *testing.T, c *Client) map[string]any {
+	t.Helper()
+	var body map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"}}],"usage":{}}`))
+	}))
+	defer ts.Close()
+	c.baseURL = ts.URL
+	if _, _, err := c.Chat(context.Background(), "sys", "user"); err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	return body
+}
+
+func TestChat_SendsReasoningEffortWhenConfigured(t *testing.T) {
+	// sends "reasoning_effort" (low|medium|high) when non-empty
+	t.Setenv("BRAIN_REASONING_EFFORT", "high")
+	body := chatRequestBody(t, NewTier("BRAIN"))
+	if body["reasoning_effort"] != "high" {
+		t.Errorf("expected reasoning_effort=high in request body, got %v", body)
+	}
+}
+
+func TestChat_OmitsReasoningEffortWhenUnset(t *testing.T) {
+	// omitted when unset so other providers never see it
+	t.Setenv("TOOL_REASONING_EFFORT", "")
+	body := chatRequestBody(t, NewTier("TOOL"))
+	if _, ok := body["reasoning_effort"]; ok {
+		t.Errorf("expected no reasoning_effort in request body, got %v", body)
+	}
+}
+
+func TestPing_ReportsAnsweringModelAndLatency(t *testing.T) {
+	// Returns the answering model and the round-trip latency on success
+	var body map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(`{"model":"gpt-x-0613","choices":[{"message":{"content":"p"}}]}`))
+	}))
+	defer ts.Close()
+	c := &Client{baseURL: ts.URL, apiKey: "k", model: "gpt-x", label: "TOOL", httpClient: http.DefaultClient}
+	res, err := c.Ping(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Model != "gpt-x-0613" || res.Latency < 10*time.Millisecond {
+		t.Errorf("got %+v, want gpt-x-0613 after ≥10ms", res)
+	}
+	if body["max_tokens"] != float64(1) {
+		t.Errorf("expected a one-token request, got %v", body)
+	}
+}
+
+func TestPing_MissingConfigFailsWithoutRequest(t *testing.T) {
+	// Returns the Validate error without any HTTP call when configuration is missing
+	c := &Client{baseURL: "http://127.0.0.1:1", model: "m", label: "BRAIN"}
+	if _, err := c.Ping(context.Background()); err == nil || !strings.Contains(err.Error(), "API key") {
+		t.Errorf("expected the missing API key error, got %v", err)
+	}
+}
+
+func TestChat_SendsTierMaxOutputTokens(t *testing.T) {
+	// Sets the completion cap from {prefix}_MAX_OUTPUT_TOKENS when it is a positive integer
+	t.Setenv("TOOL_MAX_OUTPUT_TOKENS", "1500")
+	body := chatRequestBody(t, NewTier("TOOL"))
+	if body["max_tokens"] != float64(1500) {
+		t.Errorf("expected max_tokens=1500 in request body, got %v", body)
+	}
+	t.Setenv("TOOL_MAX_OUTPUT_TOKENS", "")
+	if body := chatRequestBody(t, NewTier("TOOL")); body["max_tokens"] != nil {
+		t.Errorf("expected no max_tokens without a cap, got %v", body)
+	}
+}
+
+func TestForRole_RoleOverrideBeatsTierDefault(t *testing.T) {
+	// Uses the role variable over the tier value, and the tier value over the role default
+	t.Setenv("BRAIN_MAX_OUTPUT_TOKENS", "3000")
+	t.Setenv("ARTOO_PLANNER_MAX_OUTPUT_TOKENS", "20000")
+	t.Setenv("ARTOO_AGENTVAL_MAX_OUTPUT_TOKENS", "")
+	brain := NewTier("BRAIN")
+
+	if body := chatRequestBody(t, brain.ForRole("planner")); body["max_tokens"] != float64(20000) {
+		t.Errorf("planner: expected the role override 20000, got %v", body["max_tokens"])
+	}
+	if got := brain.ForRole("agentval").MaxOutputTokens(); got != 3000 {
+		t.Errorf("agentval: expected the tier value 3000, got %d", got)
+	}
+	if got := brain.MaxOutputTokens(); got != 3000 {
+		t.Errorf("ForRole changed the tier client: %d", got)
+	}
+	t.Setenv("TOOL_MAX_OUTPUT_TOKENS", "")
+	if got, want := NewTier("TOOL").ForRole("agentval").MaxOutputTokens(), defaultMaxOutputTokens["agentval"]; got != want {
+		t.Errorf("agentval without overrides: got %d, want the role default %d", got, want)
+	}
+	if defaultMaxOutputTokens["planner"] <= defaultMaxOutputTokens["agentval"] {
+		t.Error("the planner should get more room than agentval by default")
+	}
+	var none *Client
+	if none.ForRole("planner") != nil {
+		t.Error("ForRole on a nil client should return nil")
+	}
+}
+
+func TestForRole_SystemPromptFileReplacesSystem(t *testing.T) {
+	// SystemPrompt returns the ARTOO_<ROLE>_SYSTEM_PROMPT_FILE contents instead of the built-in prompt;
+	// Chat itself always sends the caller's system prompt
+	path := filepath.Join(t.TempDir(), "executor.txt")
+	if err := os.WriteFile(path, []byte("  variant B executor prompt\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ARTOO_EXECUTOR_SYSTEM_PROMPT_FILE", path)
+	t.Setenv("ARTOO_PLANNER_SYSTEM_PROMPT_FILE", "")
+	tier := NewTier("TOOL")
+
+	if got := tier.ForRole("executor").SystemPrompt("built-in"); got != "variant B executor prompt" {
+		t.Errorf("executor: expected the file's prompt, got %q", got)
+	}
+	msgs, _ := chatRequestBody(t, tier.ForRole("executor"))["messages"].([]any)
+	if len(msgs) == 0 || msgs[0].(map[string]any)["content"] != "sys" {
+		t.Errorf("Chat must send the caller's system prompt, got %v", msgs)
+	}
+	// SystemPrompt keeps the built-in prompt when that file is unset, unreadable, or blank
+	if got := tier.ForRole("planner").SystemPrompt("built-in"); got != "built-in" {
+		t.Errorf("planner: expected the built-in prompt, got %q", got)
+	}
+	if got := tier.SystemPrompt("built-in"); got != "built-in" {
+		t.Errorf("ForRole changed the tier client: got %q", got)
+	}
+	var nilClient *Client
+	if got := nilClient.SystemPrompt("built-in"); got != "built-in" {
+		t.Errorf("nil client: got %q", got)
+	}
+	t.Setenv("ARTOO_EXECUTOR_SYSTEM_PROMPT_FILE", filepath.Join(t.TempDir(), "missing.txt"))
+	if got := tier.ForRole("executor").SystemPrompt("built-in"); got != "built-in" {
+		t.Errorf("missing file: expected the built-in prompt, got %q", got)
+	}
+	blank := filepath.Join(t.TempDir(), "blank.txt")
+	os.WriteFile(blank, []byte(" \n"), 0o644)
+	t.Setenv("ARTOO_EXECUTOR_SYSTEM_PROMPT_FILE", blank)
+	if got := tier.ForRole("executor").SystemPrompt("built-in"); got != "built-in" {
+		t.Errorf("blank file: expected the built-in prompt, got %q", got)
+	}
+}
+
 func TestNewTier_EmptyPrefixReadsOnlySharedVars(t *testing.T) {
 	// Empty prefix reads only OPENAI_* (identical to New())
 	t.Setenv("OPENAI_API_KEY", "sk-shared-key")
@@ -207,6 +375,19 @@ func TestValidate_ErrorListsAllMissingFieldsCommaSeparated(t *testing.T) {
 	}
 }
 
+func TestValidate_RejectsUnknownReasoningEffort(t *testing.T) {
+	// Returns error naming the value when the reasoning effort is set but not low, medium, or high
+	c := &Client{baseURL: "https://x", apiKey: "k", model: "m", label: "BRAIN", reasoning: "extreme"}
+	err := c.Validate()
+	if err == nil || !strings.Contains(err.Error(), `"extreme"`) {
+		t.Errorf("expected error naming the bad effort, got %v", err)
+	}
+	c.reasoning = "medium"
+	if err := c.Validate(); err != nil {
+		t.Errorf("expected medium to be accepted, got %v", err)
+	}
+}
+
 func TestValidate_ErrorIncludesTierLabel(t *testing.T) {
 	// Error message includes the tier label
 	c := &Client{baseURL: "", apiKey: "sk-key", model: "gpt-4o", label: "BRAIN"}
@@ -218,3 +399,67 @@ func TestValidate_ErrorIncludesTierLabel(t *testing.T) {
 		t.Errorf("expected tier label 'BRAIN' in error, got %q", err.Error())
 	}
 }
+
+// hangingServer accepts requests and never answers until the client gives up.
+func hangingServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body) // a fully read body lets the server notice the client leaving
+		<-r.Context().Done()
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestChat_HungBackendTimesOutWithinBound(t *testing.T) {
+	// Returns an error wrapping ErrTimeout when the backend has not answered within the call timeout
+	t.Setenv("ARTOO_LLM_TIMEOUT", "5s")
+	t.Setenv("ARTOO_EXECUTOR_LLM_TIMEOUT", "100ms")
+	ts := hangingServer(t)
+	t.Setenv("TOOL_BASE_URL", ts.URL)
+	t.Setenv("TOOL_API_KEY", "k")
+	t.Setenv("TOOL_MODEL", "m")
+	c := NewTier("TOOL").ForRole("executor")
+
+	start := time.Now()
+	_, _, err := c.Chat(context.Background(), "sys", "user")
+	if !errors.Is(err, ErrTimeout) || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Chat returned after %v; want about the 100ms bound", elapsed)
+	}
+}
+
+func TestChat_CallerCancelIsNotATimeout(t *testing.T) {
+	// Returns ctx's own error, not ErrTimeout, when the caller's ctx ends first
+	ts := hangingServer(t)
+	c := &Client{baseURL: ts.URL, apiKey: "k", model: "m", label: "TOOL", timeout: time.Minute, httpClient: http.DefaultClient}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err := c.Chat(ctx, "sys", "user")
+	if err == nil || errors.Is(err, ErrTimeout) {
+		t.Errorf("expected the caller's deadline error, got %v", err)
+	}
+}
+
+func TestCallTimeout_RoleOverridesShared(t *testing.T) {
+	// Uses ARTOO_<ROLE>_LLM_TIMEOUT over ARTOO_LLM_TIMEOUT, falling back to the 120s default
+	t.Setenv("ARTOO_LLM_TIMEOUT", "")
+	t.Setenv("ARTOO_PLANNER_LLM_TIMEOUT", "")
+	if got := callTimeout("planner"); got != defaultCallTimeout {
+		t.Errorf("no overrides: got %v, want %v", got, defaultCallTimeout)
+	}
+	t.Setenv("ARTOO_LLM_TIMEOUT", "90s")
+	t.Setenv("ARTOO_PLANNER_LLM_TIMEOUT", "bogus")
+	if got := callTimeout("planner"); got != 90*time.Second {
+		t.Errorf("invalid role value: got %v, want the shared 90s", got)
+	}
+	t.Setenv("ARTOO_PLANNER_LLM_TIMEOUT", "5m")
+	if got := NewTier("BRAIN").ForRole("planner").Timeout(); got != 5*time.Minute {
+		t.Errorf("role override: got %v, want 5m", got)
+	}
+	if got := NewTier("BRAIN").Timeout(); got != 90*time.Second {
+		t.Errorf("tier client: got %v, want the shared 90s", got)
+	}
+}
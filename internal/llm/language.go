@@ -0,0 +1,130 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// languageInfo describes a language R1 can detect: its English name (used in
+// prompt guidance) and an example of a locale-formatted date, when one helps.
+type languageInfo struct {
+	name        string
+	dateExample string
+}
+
+// languages maps the tags DetectLanguage returns to prompt guidance details.
+var languages = map[string]languageInfo{
+	"zh": {name: "Chinese", dateExample: "2026年3月2日 星期一"},
+	"ja": {name: "Japanese", dateExample: "2026年3月2日(月)"},
+	"ko": {name: "Korean", dateExample: "2026년 3월 2일 월요일"},
+	"ru": {name: "Russian", dateExample: "2 марта 2026 г."},
+	"el": {name: "Greek"},
+	"ar": {name: "Arabic"},
+	"he": {name: "Hebrew"},
+	"th": {name: "Thai"},
+	"hi": {name: "Hindi"},
+	"en": {name: "English"},
+}
+
+// scriptWeight is how many Latin letters one rune of a script counts as when
+// picking the dominant script: a CJK character carries about a word.
+func scriptWeight(lang string) int {
+	switch lang {
+	case "zh", "ja", "ko":
+		return 3
+	}
+	return 1
+}
+
+// DetectLanguage guesses the language of user input from the Unicode scripts of
+// its letters and returns a short BCP 47 tag ("zh", "ja", "ko", "ru", ...).
+// Tokens that look like paths, file names, URLs, or flags are skipped, since
+// they are Latin in any language ("查找 report.txt" is Chinese).
+// Latin-script input is reported as "en": telling English apart from other
+// Latin-script languages needs more than script counting, and "en" adds no
+// prompt guidance, so a French request is handled exactly as before.
+//
+// Expectations:
+//   - Returns "zh" for Han-dominant input and "ja" when any kana is present
+//   - Returns "ko" for Hangul, "ru" for Cyrillic, and the matching tag for other known scripts
+//   - Ignores path-like tokens (containing / . ~ _ : @ or digits, or starting with -)
+//   - Returns "en" for Latin-dominant input
+//   - Returns "" when the input has no letters outside skipped tokens
+func DetectLanguage(input string) string {
+	counts := make(map[string]int)
+	for _, tok := range strings.Fields(input) {
+		if strings.HasPrefix(tok, "-") || strings.ContainsAny(tok, "/.~_:@\\0123456789") {
+			continue
+		}
+		for _, r := range tok {
+			if lang := runeLanguage(r); lang != "" {
+				counts[lang]++
+			}
+		}
+	}
+	if counts["kana"] > 0 {
+		counts["ja"] += counts["kana"] + counts["zh"] // kanji are Han runes
+		delete(counts, "kana")
+		delete(counts, "zh")
+	}
+	best, bestScore := "", 0
+	for _, lang := range []string{"zh", "ja", "ko", "ru", "el", "ar", "he", "th", "hi", "en"} {
+		if score := counts[lang] * scriptWeight(lang); score > bestScore {
+			best, bestScore = lang, score
+		}
+	}
+	return best
+}
+
+// runeLanguage maps a letter to the language tag its script implies ("kana"
+// is resolved to "ja" by DetectLanguage), or "" for non-letters.
+func runeLanguage(r rune) string {
+	switch {
+	case unicode.Is(unicode.Han, r):
+		return "zh"
+	case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+		return "kana"
+	case unicode.Is(unicode.Hangul, r):
+		return "ko"
+	case unicode.Is(unicode.Cyrillic, r):
+		return "ru"
+	case unicode.Is(unicode.Greek, r):
+		return "el"
+	case unicode.Is(unicode.Arabic, r):
+		return "ar"
+	case unicode.Is(unicode.Hebrew, r):
+		return "he"
+	case unicode.Is(unicode.Thai, r):
+		return "th"
+	case unicode.Is(unicode.Devanagari, r):
+		return "hi"
+	case unicode.Is(unicode.Latin, r):
+		return "en"
+	}
+	return ""
+}
+
+// LanguageGuidance returns a prompt paragraph telling a role to answer in the
+// user's language and to format dates and numbers for its locale.
+//
+// Expectations:
+//   - Returns "" for "" and "en" (English is the prompts' default; nothing to add)
+//   - Names the language and its tag, with a date example when one is known
+//   - Tells the model to keep tool inputs (commands, paths, queries) as the tool needs them
+func LanguageGuidance(lang string) string {
+	if lang == "" || lang == "en" {
+		return ""
+	}
+	name := lang
+	info, ok := languages[lang]
+	if ok {
+		name = info.name
+	}
+	g := fmt.Sprintf("Language: the user wrote in %s (%s). Write user-facing text (outputs, summaries, messages) in %s "+
+		"and format dates and numbers the way a %s reader expects", name, lang, name, name)
+	if info.dateExample != "" {
+		g += fmt.Sprintf(" (e.g. %s)", info.dateExample)
+	}
+	return g + ". Keep tool inputs (commands, paths, search queries) in whatever form the tool needs."
+}
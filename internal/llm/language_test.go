@@ -0,0 +1,77 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectLanguage_Chinese(t *testing.T) {
+	// Returns "zh" for Han-dominant input and "ja" when any kana is present
+	if got := DetectLanguage("帮我找出下载文件夹里最大的视频"); got != "zh" {
+		t.Errorf("expected zh, got %q", got)
+	}
+}
+
+func TestDetectLanguage_JapaneseWithKanji(t *testing.T) {
+	// Returns "zh" for Han-dominant input and "ja" when any kana is present
+	if got := DetectLanguage("ダウンロードにある動画を探して"); got != "ja" {
+		t.Errorf("expected ja, got %q", got)
+	}
+}
+
+func TestDetectLanguage_OtherScripts(t *testing.T) {
+	// Returns "ko" for Hangul, "ru" for Cyrillic, and the matching tag for other known scripts
+	cases := map[string]string{
+		"다운로드 폴더에서 가장 큰 파일 찾기":      "ko",
+		"найди самые большие файлы": "ru",
+		"βρες τα μεγαλύτερα αρχεία": "el",
+	}
+	for input, want := range cases {
+		if got := DetectLanguage(input); got != want {
+			t.Errorf("DetectLanguage(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestDetectLanguage_IgnoresPathTokens(t *testing.T) {
+	// Ignores path-like tokens (containing / . ~ _ : @ or digits, or starting with -)
+	if got := DetectLanguage("查找 ~/Documents/quarterly_report.txt --recursive"); got != "zh" {
+		t.Errorf("expected zh despite Latin path tokens, got %q", got)
+	}
+	if got := DetectLanguage("find 报告.pdf in Downloads"); got != "en" {
+		t.Errorf("expected en despite a CJK file name, got %q", got)
+	}
+}
+
+func TestDetectLanguage_LatinIsEnglish(t *testing.T) {
+	// Returns "en" for Latin-dominant input
+	if got := DetectLanguage("find my largest video files"); got != "en" {
+		t.Errorf("expected en, got %q", got)
+	}
+}
+
+func TestDetectLanguage_NoLetters(t *testing.T) {
+	// Returns "" when the input has no letters outside skipped tokens
+	if got := DetectLanguage("~/a.txt 42 !!"); got != "" {
+		t.Errorf("expected empty tag, got %q", got)
+	}
+}
+
+func TestLanguageGuidance_EmptyForEnglish(t *testing.T) {
+	// Returns "" for "" and "en" (English is the prompts' default; nothing to add)
+	for _, lang := range []string{"", "en"} {
+		if g := LanguageGuidance(lang); g != "" {
+			t.Errorf("LanguageGuidance(%q) = %q, want empty", lang, g)
+		}
+	}
+}
+
+func TestLanguageGuidance_NamesLanguageAndDateFormat(t *testing.T) {
+	// Names the language and its tag, with a date example when one is known
+	g := LanguageGuidance("zh")
+	for _, want := range []string{"Chinese (zh)", "星期一", "tool inputs"} {
+		if !strings.Contains(g, want) {
+			t.Errorf("expected %q in guidance, got %q", want, g)
+		}
+	}
+}
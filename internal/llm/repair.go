@@ -0,0 +1,176 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// RepairJSON turns raw model output into a single valid JSON value, applying
+// the repairs models most often need: markdown fences and <think> blocks are
+// stripped, the first top-level object or array is extracted from surrounding
+// prose (or from a run of concatenated values), bare double quotes inside
+// string values are escaped, raw control characters inside strings are
+// escaped, and trailing commas before } or ] are removed.
+// On failure it returns the best-effort repaired text along with the error,
+// so callers can report what the model actually produced.
+//
+// Expectations:
+//   - Returns valid input unchanged (after trimming)
+//   - Strips ``` fences and <think> blocks
+//   - Extracts the first complete object or array, dropping leading and trailing prose
+//   - Returns the first value when several are concatenated
+//   - Escapes unescaped double quotes inside string values ("He said "hi" today")
+//   - Escapes raw newlines and tabs inside string values
+//   - Removes trailing commas before } and ]
+//   - Leaves commas, quotes, and brackets inside strings untouched
+//   - Returns an error when no valid JSON can be recovered
+func RepairJSON(raw string) (string, error) {
+	s := StripFences(raw)
+	if json.Valid([]byte(s)) {
+		return s, nil
+	}
+	s = firstJSONValue(s)
+	if json.Valid([]byte(s)) {
+		return s, nil
+	}
+	// Extraction may have stopped at a brace inside a string a stray quote
+	// closed early, so extract again once the strings are repaired.
+	s = firstJSONValue(removeTrailingCommas(repairStrings(s)))
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return s, fmt.Errorf("llm: repair json: %w", err)
+	}
+	return s, nil
+}
+
+// firstJSONValue returns the first top-level object or array in s, found by
+// bracket matching outside strings. An unclosed value runs to the end of s;
+// s is returned unchanged when it contains no '{' or '['.
+func firstJSONValue(s string) string {
+	start := strings.IndexAny(s, "{[")
+	if start < 0 {
+		return s
+	}
+	depth := 0
+	inStr := false
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		if inStr {
+			switch c {
+			case '\\':
+				i++
+			case '"':
+				inStr = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inStr = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return s[start : i+1]
+			}
+		}
+	}
+	return s[start:]
+}
+
+// repairStrings escapes what cannot appear bare inside a JSON string: a double
+// quote that does not end the string, and raw control characters. A quote ends
+// the string only when what follows could follow a string in JSON (see
+// closesString); any other quote is taken to be part of the text.
+func repairStrings(s string) string {
+	var b strings.Builder
+	inStr := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !inStr {
+			b.WriteByte(c)
+			inStr = c == '"'
+			continue
+		}
+		switch {
+		case c == '\\' && i+1 < len(s):
+			b.WriteString(s[i : i+2])
+			i++
+		case c == '"':
+			if closesString(s, i+1) {
+				b.WriteByte(c)
+				inStr = false
+			} else {
+				b.WriteString(`\"`)
+			}
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20:
+			fmt.Fprintf(&b, `\u%04x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// closesString reports whether a quote followed by s[j:] plausibly ends a JSON
+// string: it is followed by ':', '}', ']', the end of input, or a ',' that
+// itself starts the next key or value.
+func closesString(s string, j int) bool {
+	j = skipSpace(s, j)
+	if j == len(s) {
+		return true
+	}
+	switch s[j] {
+	case ':', '}', ']':
+		return true
+	case ',':
+		j = skipSpace(s, j+1)
+		return j == len(s) || strings.IndexByte(`"{[]}-0123456789`, s[j]) >= 0
+	}
+	return false
+}
+
+// removeTrailingCommas drops commas that directly precede '}' or ']' outside strings.
+func removeTrailingCommas(s string) string {
+	var b strings.Builder
+	inStr := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inStr {
+			b.WriteByte(c)
+			switch c {
+			case '\\':
+				if i+1 < len(s) {
+					i++
+					b.WriteByte(s[i])
+				}
+			case '"':
+				inStr = false
+			}
+			continue
+		}
+		if c == ',' {
+			if j := skipSpace(s, i+1); j < len(s) && (s[j] == '}' || s[j] == ']') {
+				continue
+			}
+		}
+		inStr = c == '"'
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func skipSpace(s string, j int) int {
+	for j < len(s) && strings.IndexByte(" \t\r\n", s[j]) >= 0 {
+		j++
+	}
+	return j
+}
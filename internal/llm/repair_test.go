@@ -0,0 +1,111 @@
+package llm
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// ── RepairJSON ────────────────────────────────────────────────────────────────
+
+func TestRepairJSON_ValidInputUnchanged(t *testing.T) {
+	// Returns valid input unchanged (after trimming)
+	for _, s := range []string{`{"verdict":"accept","summary":"ok"}`, `[{"subtask_id":"a"}]`, `{"a":{"b":1},"c":2}`} {
+		got, err := RepairJSON("  " + s + "\n")
+		if err != nil || got != s {
+			t.Errorf("RepairJSON(%q) = %q, %v; want unchanged", s, got, err)
+		}
+	}
+}
+
+func TestRepairJSON_StripsFencesAndThinkBlocks(t *testing.T) {
+	// Strips ``` fences and <think> blocks
+	raw := "<think>let me check</think>\n```json\n{\"verdict\":\"accept\"}\n```"
+	got, err := RepairJSON(raw)
+	if err != nil || got != `{"verdict":"accept"}` {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestRepairJSON_ExtractsFromProse(t *testing.T) {
+	// Extracts the first complete object or array, dropping leading and trailing prose
+	cases := map[string]string{
+		`Here is the result: {"verdict":"accept","summary":"ok"}`:           `{"verdict":"accept","summary":"ok"}`,
+		`{"verdict":"accept","summary":"ok"} Let me know if you need more.`: `{"verdict":"accept","summary":"ok"}`,
+		"I need to determine what location.\n\n[{\"subtask_id\":\"a\"}]":    `[{"subtask_id":"a"}]`,
+		`Result: {"summary":"brace } and bracket ] in text"} done`:          `{"summary":"brace } and bracket ] in text"}`,
+	}
+	for raw, want := range cases {
+		got, err := RepairJSON(raw)
+		if err != nil || got != want {
+			t.Errorf("RepairJSON(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+}
+
+func TestRepairJSON_FirstOfConcatenatedValues(t *testing.T) {
+	// Returns the first value when several are concatenated
+	raw := `{"action":"tool","tool":"shell","command":"ls"}{"action":"result","status":"completed"}`
+	got, err := RepairJSON(raw)
+	if err != nil || got != `{"action":"tool","tool":"shell","command":"ls"}` {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestRepairJSON_EscapesInnerQuotes(t *testing.T) {
+	// Escapes unescaped double quotes inside string values ("He said "hi" today")
+	raw := `{"verdict":"accept","summary":"The file "report.txt" was created, "as asked"","merged_output":"see "report.txt""}`
+	got, err := RepairJSON(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v (got %q)", err, got)
+	}
+	var v struct {
+		Verdict      string `json:"verdict"`
+		Summary      string `json:"summary"`
+		MergedOutput string `json:"merged_output"`
+	}
+	if err := json.Unmarshal([]byte(got), &v); err != nil {
+		t.Fatalf("repaired output does not parse: %v", err)
+	}
+	if v.Verdict != "accept" || v.Summary != `The file "report.txt" was created, "as asked"` || v.MergedOutput != `see "report.txt"` {
+		t.Errorf("unexpected fields: %+v", v)
+	}
+}
+
+func TestRepairJSON_EscapesControlCharacters(t *testing.T) {
+	// Escapes raw newlines and tabs inside string values
+	got, err := RepairJSON("{\"output\":\"line one\nline\ttwo\"}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var v struct{ Output string }
+	if err := json.Unmarshal([]byte(got), &v); err != nil || v.Output != "line one\nline\ttwo" {
+		t.Errorf("got %q (%v), output %q", got, err, v.Output)
+	}
+}
+
+func TestRepairJSON_RemovesTrailingCommas(t *testing.T) {
+	// Removes trailing commas before } and ]
+	got, err := RepairJSON("{\"task_criteria\":[\"a\",\"b\",],\n\"subtasks\":[{\"intent\":\"x\",},],\n}")
+	want := `{"task_criteria":["a","b"],` + "\n" + `"subtasks":[{"intent":"x"}]` + "\n}"
+	if err != nil || got != want {
+		t.Errorf("got %q, %v; want %q", got, err, want)
+	}
+}
+
+func TestRepairJSON_LeavesStringContentAlone(t *testing.T) {
+	// Leaves commas, quotes, and brackets inside strings untouched
+	raw := `{"command":"echo \"a,]\" ,}", "n":1,}`
+	got, err := RepairJSON(raw)
+	if err != nil || got != `{"command":"echo \"a,]\" ,}", "n":1}` {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestRepairJSON_ErrorWhenUnrecoverable(t *testing.T) {
+	// Returns an error when no valid JSON can be recovered
+	for _, raw := range []string{"no json here", `{"verdict":`, ""} {
+		if got, err := RepairJSON(raw); err == nil {
+			t.Errorf("RepairJSON(%q) = %q, want an error", raw, got)
+		}
+	}
+}
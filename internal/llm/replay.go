@@ -0,0 +1,101 @@
+package llm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+// ErrReplayMiss is returned by Chat in replay mode when no unused recorded call
+// matches the prompt and live fallback is off.
+var ErrReplayMiss = errors.New("llm: replay: no recorded call matches the prompt")
+
+// RecordedCall is one logged LLM exchange — the fields of a tasklog llm_call event.
+type RecordedCall struct {
+	Role             string
+	System           string
+	User             string
+	Response         string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Replay serves recorded responses in place of the backend so a past run can be
+// reproduced offline. One Replay is shared by every tier: the log interleaves
+// planner and tool-tier calls, and each recorded call is served at most once.
+type Replay struct {
+	mu     sync.Mutex
+	calls  []RecordedCall
+	used   []bool
+	byKey  map[string][]int // promptKey(system, user) → call indices in log order
+	bySys  map[string][]int // promptKey(system, "") → call indices in log order
+	live   bool
+	misses int
+}
+
+// NewReplay builds a Replay over calls in log order. When live is true, a prompt
+// with no recorded match is sent to the backend instead of failing.
+func NewReplay(calls []RecordedCall, live bool) *Replay {
+	r := &Replay{
+		calls: calls,
+		used:  make([]bool, len(calls)),
+		byKey: make(map[string][]int),
+		bySys: make(map[string][]int),
+		live:  live,
+	}
+	for i, c := range calls {
+		k := promptKey(c.System, c.User)
+		r.byKey[k] = append(r.byKey[k], i)
+		s := promptKey(c.System, "")
+		r.bySys[s] = append(r.bySys[s], i)
+	}
+	return r
+}
+
+// promptKey hashes a system + user prompt pair; the NUL separator keeps
+// ("ab", "c") and ("a", "bc") apart.
+func promptKey(system, user string) string {
+	sum := sha256.Sum256([]byte(system + "\x00" + user))
+	return hex.EncodeToString(sum[:])
+}
+
+// next claims the recorded call that answers (system, user).
+// The system prompt identifies the role, so when the user prompt has drifted
+// (timestamps, memory hits) the role's calls are replayed in call order.
+//
+// Expectations:
+//   - Returns the earliest unused call whose system and user prompts both match
+//   - Otherwise returns the earliest unused call with the same system prompt
+//   - Never returns the same call twice
+//   - Returns false (and counts a miss) when neither exists
+func (r *Replay) next(system, user string) (RecordedCall, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, idx := range [][]int{r.byKey[promptKey(system, user)], r.bySys[promptKey(system, "")]} {
+		for _, i := range idx {
+			if !r.used[i] {
+				r.used[i] = true
+				return r.calls[i], true
+			}
+		}
+	}
+	r.misses++
+	return RecordedCall{}, false
+}
+
+// Live reports whether misses fall back to the backend.
+func (r *Replay) Live() bool { return r.live }
+
+// Stats returns how many recorded calls have been served, how many remain, and
+// how many prompts found no match.
+func (r *Replay) Stats() (served, remaining, misses int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.used {
+		if u {
+			served++
+		}
+	}
+	return served, len(r.calls) - served, r.misses
+}
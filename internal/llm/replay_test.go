@@ -0,0 +1,111 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// replayClient returns a client in replay mode whose backend fails the test
+// if it is reached, unless allowLive is set.
+func replayClient(t *testing.T, r *Replay, allowLive bool) (*Client, *int) {
+	t.Helper()
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hits++
+		if !allowLive {
+			t.Error("backend contacted in replay mode")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"live"}}],"usage":{}}`))
+	}))
+	t.Cleanup(ts.Close)
+	c := NewTier("TOOL")
+	c.baseURL = ts.URL
+	c.SetReplay(r)
+	return c, &hits
+}
+
+func TestReplay_TwoCallLogReturnedInOrder(t *testing.T) {
+	// Identical prompts replay the recorded responses in log order, with their token counts
+	r := NewReplay([]RecordedCall{
+		{Role: "executor", System: "sys", User: "user", Response: "first", PromptTokens: 10, CompletionTokens: 2},
+		{Role: "executor", System: "sys", User: "user", Response: "second", PromptTokens: 20, CompletionTokens: 4},
+	}, false)
+	c, _ := replayClient(t, r, false)
+	for i, want := range []string{"first", "second"} {
+		got, usage, err := c.Chat(context.Background(), "sys", "user")
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if got != want {
+			t.Errorf("call %d = %q, want %q", i, got, want)
+		}
+		if usage.TotalTokens != usage.PromptTokens+usage.CompletionTokens || usage.PromptTokens != 10*(i+1) {
+			t.Errorf("call %d usage = %+v", i, usage)
+		}
+	}
+	if served, remaining, misses := r.Stats(); served != 2 || remaining != 0 || misses != 0 {
+		t.Errorf("stats = %d/%d/%d", served, remaining, misses)
+	}
+}
+
+func TestReplay_ExactPromptMatchBeatsCallOrder(t *testing.T) {
+	// A prompt matching a later call exactly gets that call, not the next in order
+	r := NewReplay([]RecordedCall{
+		{System: "planner", User: "plan A", Response: "A"},
+		{System: "planner", User: "plan B", Response: "B"},
+	}, false)
+	c, _ := replayClient(t, r, false)
+	if got, _, _ := c.Chat(context.Background(), "planner", "plan B"); got != "B" {
+		t.Errorf("got %q, want B", got)
+	}
+	if got, _, _ := c.Chat(context.Background(), "planner", "plan A"); got != "A" {
+		t.Errorf("got %q, want A", got)
+	}
+}
+
+func TestReplay_DriftedUserPromptFallsBackToRoleOrder(t *testing.T) {
+	// Same system prompt with a changed user prompt takes the role's next unused call
+	r := NewReplay([]RecordedCall{
+		{System: "agentval", User: "at 10:00", Response: "v1"},
+		{System: "metaval", User: "at 10:00", Response: "m1"},
+		{System: "agentval", User: "at 10:01", Response: "v2"},
+	}, false)
+	c, _ := replayClient(t, r, false)
+	for _, want := range []string{"v1", "v2"} {
+		if got, _, err := c.Chat(context.Background(), "agentval", "at 11:30"); err != nil || got != want {
+			t.Errorf("got %q, %v; want %q", got, err, want)
+		}
+	}
+}
+
+func TestReplay_MissReturnsErrReplayMiss(t *testing.T) {
+	// Without live fallback an unmatched prompt is an error wrapping ErrReplayMiss
+	r := NewReplay([]RecordedCall{{System: "sys", User: "user", Response: "only"}}, false)
+	c, _ := replayClient(t, r, false)
+	c.Chat(context.Background(), "sys", "user")
+	if _, _, err := c.Chat(context.Background(), "sys", "user"); !errors.Is(err, ErrReplayMiss) {
+		t.Errorf("exhausted log: err = %v, want ErrReplayMiss", err)
+	}
+	if _, _, err := c.Chat(context.Background(), "other", "user"); !errors.Is(err, ErrReplayMiss) {
+		t.Errorf("unknown role: err = %v, want ErrReplayMiss", err)
+	}
+	if _, _, misses := r.Stats(); misses != 2 {
+		t.Errorf("misses = %d, want 2", misses)
+	}
+}
+
+func TestReplay_LiveFallbackCallsBackendOnMiss(t *testing.T) {
+	// With live fallback a miss is sent to the backend; matches still are not
+	r := NewReplay([]RecordedCall{{System: "sys", User: "user", Response: "recorded"}}, true)
+	c, hits := replayClient(t, r, true)
+	if got, _, _ := c.Chat(context.Background(), "sys", "user"); got != "recorded" || *hits != 0 {
+		t.Errorf("match: got %q with %d backend hits", got, *hits)
+	}
+	if got, _, err := c.Chat(context.Background(), "other", "user"); err != nil || got != "live" || *hits != 1 {
+		t.Errorf("miss: got %q, %v with %d backend hits", got, err, *hits)
+	}
+}
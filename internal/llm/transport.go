@@ -0,0 +1,78 @@
+package llm
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Connection pool defaults for the shared LLM transports. Go's own default of
+// two idle connections per host makes parallel subtasks reconnect (and redo the
+// TLS handshake) on almost every call.
+const (
+	defaultMaxIdleConnsPerHost = 16
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// transports holds one transport per backend (scheme + host), shared by every
+// tier client and ForRole copy that talks to it.
+var (
+	transportsMu sync.Mutex
+	transports   = make(map[string]*http.Transport)
+)
+
+// sharedTransport returns the transport for baseURL's scheme and host, creating
+// it with the pool settings from the environment on first use.
+//
+// Expectations:
+//   - Returns the same transport for base URLs on the same scheme and host, whatever their paths
+//   - Returns different transports for different hosts or schemes
+//   - Pools up to ARTOO_LLM_MAX_IDLE_CONNS_PER_HOST idle connections, kept for ARTOO_LLM_IDLE_CONN_TIMEOUT
+func sharedTransport(baseURL string) *http.Transport {
+	key := baseURL
+	if u, err := url.Parse(baseURL); err == nil && u.Host != "" {
+		key = u.Scheme + "://" + u.Host
+	}
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	if t, ok := transports[key]; ok {
+		return t
+	}
+	maxIdle, idleTimeout := poolSettings()
+	t := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdle * 4,
+		MaxIdleConnsPerHost:   maxIdle,
+		IdleConnTimeout:       idleTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	}
+	transports[key] = t
+	return t
+}
+
+// poolSettings reads the idle-connection pool size and idle timeout for the
+// shared transports: ARTOO_LLM_MAX_IDLE_CONNS_PER_HOST (a positive integer) and
+// ARTOO_LLM_IDLE_CONN_TIMEOUT (a Go duration).
+//
+// Expectations:
+//   - Returns the configured values when they are positive
+//   - Falls back to defaultMaxIdleConnsPerHost and defaultIdleConnTimeout when unset or invalid
+func poolSettings() (maxIdlePerHost int, idleTimeout time.Duration) {
+	maxIdlePerHost, idleTimeout = defaultMaxIdleConnsPerHost, defaultIdleConnTimeout
+	if n, err := strconv.Atoi(os.Getenv("ARTOO_LLM_MAX_IDLE_CONNS_PER_HOST")); err == nil && n > 0 {
+		maxIdlePerHost = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("ARTOO_LLM_IDLE_CONN_TIMEOUT")); err == nil && d > 0 {
+		idleTimeout = d
+	}
+	return maxIdlePerHost, idleTimeout
+}
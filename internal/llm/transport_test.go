@@ -0,0 +1,108 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewTier_SharesTransportPerHost(t *testing.T) {
+	// Shares its HTTP transport with every other tier whose base URL has the same scheme and host
+	t.Setenv("OPENAI_BASE_URL", "https://llm.example.test/v1")
+	t.Setenv("BRAIN_BASE_URL", "https://llm.example.test/reasoning/v1/")
+	t.Setenv("TOOL_BASE_URL", "https://other.example.test/v1")
+	shared, brain, tool := New(), NewTier("BRAIN"), NewTier("TOOL")
+
+	if shared.httpClient.Transport != brain.httpClient.Transport {
+		t.Error("tiers on the same host should share one transport")
+	}
+	if brain.ForRole("planner").httpClient.Transport != brain.httpClient.Transport {
+		t.Error("a ForRole copy should keep its tier's transport")
+	}
+	if tool.httpClient.Transport == shared.httpClient.Transport {
+		t.Error("a tier on another host should get its own transport")
+	}
+	if sharedTransport("http://llm.example.test/v1") == shared.httpClient.Transport {
+		t.Error("http and https to the same host should not share a transport")
+	}
+}
+
+func TestSharedTransport_UsesPoolSettings(t *testing.T) {
+	// Pools up to ARTOO_LLM_MAX_IDLE_CONNS_PER_HOST idle connections, kept for ARTOO_LLM_IDLE_CONN_TIMEOUT
+	t.Setenv("ARTOO_LLM_MAX_IDLE_CONNS_PER_HOST", "32")
+	t.Setenv("ARTOO_LLM_IDLE_CONN_TIMEOUT", "2m")
+	tr := sharedTransport("https://pool-settings.example.test/v1")
+	if tr.MaxIdleConnsPerHost != 32 || tr.IdleConnTimeout != 2*time.Minute {
+		t.Errorf("got %d idle conns per host for %s, want 32 for 2m", tr.MaxIdleConnsPerHost, tr.IdleConnTimeout)
+	}
+}
+
+func TestPoolSettings(t *testing.T) {
+	// Returns the configured values when they are positive; falls back to the defaults when unset or invalid
+	for _, tc := range []struct {
+		maxIdle, timeout string
+		wantIdle         int
+		wantTimeout      time.Duration
+	}{
+		{"", "", defaultMaxIdleConnsPerHost, defaultIdleConnTimeout},
+		{"4", "30s", 4, 30 * time.Second},
+		{"0", "-1s", defaultMaxIdleConnsPerHost, defaultIdleConnTimeout},
+		{"many", "soon", defaultMaxIdleConnsPerHost, defaultIdleConnTimeout},
+	} {
+		t.Setenv("ARTOO_LLM_MAX_IDLE_CONNS_PER_HOST", tc.maxIdle)
+		t.Setenv("ARTOO_LLM_IDLE_CONN_TIMEOUT", tc.timeout)
+		if idle, timeout := poolSettings(); idle != tc.wantIdle || timeout != tc.wantTimeout {
+			t.Errorf("(%q, %q) = %d, %s; want %d, %s", tc.maxIdle, tc.timeout, idle, timeout, tc.wantIdle, tc.wantTimeout)
+		}
+	}
+}
+
+// BenchmarkChat_ConcurrentTiers sends concurrent calls through three tier
+// clients on one backend and reports the connections the server accepted per
+// call: "shared" uses the pooled sharedTransport, "per-client" gives each tier
+// its own transport with Go's default pool, as tiers had before.
+func BenchmarkChat_ConcurrentTiers(b *testing.B) {
+	for _, mode := range []string{"shared", "per-client"} {
+		b.Run(mode, func(b *testing.B) {
+			var conns atomic.Int64
+			ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Write([]byte(`{"choices":[{"message":{"content":"ok"}}]}`))
+			}))
+			ts.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+				if s == http.StateNew {
+					conns.Add(1)
+				}
+			}
+			ts.Start()
+			defer ts.Close()
+
+			clients := make([]*Client, 3)
+			for i := range clients {
+				tr := sharedTransport(ts.URL)
+				if mode == "per-client" {
+					tr = &http.Transport{}
+				}
+				clients[i] = &Client{baseURL: ts.URL, apiKey: "k", model: "m", label: fmt.Sprint("T", i), httpClient: &http.Client{Transport: tr}}
+			}
+
+			var next atomic.Int64
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					c := clients[next.Add(1)%int64(len(clients))]
+					if _, _, err := c.Chat(context.Background(), "s", "u"); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+		})
+	}
+}
@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"log/slog"
 	"regexp"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/haricheung/agentic-shell/internal/bus"
 	"github.com/haricheung/agentic-shell/internal/llm"
+	"github.com/haricheung/agentic-shell/internal/roles/memory"
 	"github.com/haricheung/agentic-shell/internal/tasklog"
 	"github.com/haricheung/agentic-shell/internal/types"
 )
@@ -29,6 +32,11 @@ Evidence grounding rule (apply before scoring any criterion):
 - If "output" claims a primary action succeeded (download, write, create, execute) but the corresponding tool_call entry shows the action was interrupted, errored, or truncated without a completion signal → the claim is contradicted → "retry".
 - Post-hoc verification (ls, find, stat, wc) appearing after a failed or incomplete primary action does NOT prove the primary action succeeded. A pre-existing file found by ls is not evidence of a successful download or write.
 
+Content-type tags (a tool_calls snippet may start with one; untagged output is plain text):
+- [paths]: one path per line, exactly as the tool returned it. A listed path proves the file exists; "(+N more)" means more matched than are shown.
+- [json]: score against the named keys and values. A criterion about a key absent from a complete object is not met.
+- [table]: delimited rows are records. Count rows for "how many" criteria instead of estimating.
+
 Special rules (apply in order, first match wins):
 
 Executor failure rule (highest priority):
@@ -64,14 +72,167 @@ Failed or infrastructure error:
 
 No markdown, no prose, no code fences.`
 
-const maxRetries = 2
+// Retry budgets, drawn from by the failure class of each retried attempt.
+// Environmental failures (network blips, timeouts) are often transient and get
+// more attempts; re-running the same wrong logic rarely helps, so logical
+// failures get fewer. Each budget counts failing attempts of its class.
+const (
+	maxLogicalRetries       = 2
+	maxEnvironmentalRetries = 3
+)
+
+// maxRetriesOverride caps a subtask's MaxRetries, so a plan cannot buy a subtask
+// more attempts than the environmental budget already allows.
+const maxRetriesOverride = 3
+
+// retryBudgetFor returns the retry budget an attempt that failed with class
+// draws from, and that budget's size. override is the subtask's MaxRetries.
+//
+// Expectations:
+//   - Returns ("environmental", maxEnvironmentalRetries) for "environmental"
+//   - Returns ("logical", maxLogicalRetries) for "logical", "mixed", and "" (unclassified)
+//   - A non-nil override replaces the size of either budget, clamped to 0..maxRetriesOverride;
+//     0 means the first retry verdict fails the subtask
+func retryBudgetFor(class string, override *int) (string, int) {
+	budget, size := "logical", maxLogicalRetries
+	if class == "environmental" {
+		budget, size = "environmental", maxEnvironmentalRetries
+	}
+	if override != nil {
+		size = min(max(*override, 0), maxRetriesOverride)
+	}
+	return budget, size
+}
+
+// VerdictPolicy sets how demanding R4a is before it accepts an execution result.
+// It both tightens/loosens the acceptance bar in the system prompt and applies a
+// deterministic post-pass over the LLM verdict (see applyPolicy).
+type VerdictPolicy string
+
+const (
+	PolicyStrict  VerdictPolicy = "strict"  // weak evidence never counts as met
+	PolicyDefault VerdictPolicy = "default" // LLM verdict is used as-is
+	PolicyLenient VerdictPolicy = "lenient" // single-criterion partial matches are accepted
+)
+
+// ParseVerdictPolicy converts a user-supplied policy name to a VerdictPolicy.
+// Matching is case-insensitive; surrounding whitespace is ignored.
+//
+// Expectations:
+//   - Returns PolicyDefault for "" and "default"
+//   - Returns PolicyStrict for "strict" and PolicyLenient for "lenient"
+//   - Returns PolicyDefault and a non-nil error for any other value
+func ParseVerdictPolicy(s string) (VerdictPolicy, error) {
+	switch VerdictPolicy(strings.ToLower(strings.TrimSpace(s))) {
+	case "", PolicyDefault:
+		return PolicyDefault, nil
+	case PolicyStrict:
+		return PolicyStrict, nil
+	case PolicyLenient:
+		return PolicyLenient, nil
+	}
+	return PolicyDefault, fmt.Errorf("unknown verdict policy %q (want strict, default, or lenient)", s)
+}
+
+// policyPrompt returns the acceptance-bar paragraph appended to systemPrompt for p.
+// The default policy adds nothing so the baseline prompt is unchanged.
+func policyPrompt(p VerdictPolicy) string {
+	switch p {
+	case PolicyStrict:
+		return `
+
+Acceptance bar — STRICT:
+- Mark a criterion "met" only when its evidence quotes concrete tool output that directly proves it.
+- Inferred, hedged ("likely", "appears", "should"), or partial evidence → met=false and verdict "retry".`
+	case PolicyLenient:
+		return `
+
+Acceptance bar — LENIENT:
+- When the subtask has a single success criterion and tool output shows substantive partial progress toward it, prefer "matched" over "retry".`
+	}
+	return ""
+}
+
+// weakEvidenceRe matches hedging language that signals a criterion was judged
+// met by inference rather than by observed tool output.
+var weakEvidenceRe = regexp.MustCompile(
+	`(?i)\b(likely|probably|presumably|possibly|appears? to|seems? to|assum\w*|` +
+		`should (?:be|have)|might|may (?:be|have)|not (?:shown|verified|confirmed)|unverified)\b`,
+)
+
+// isWeakEvidence reports whether evidence is too thin to prove a criterion:
+// empty, or phrased as a guess rather than an observation.
+//
+// Expectations:
+//   - Returns true for empty or whitespace-only evidence
+//   - Returns true when evidence contains hedging words ("likely", "appears to", "should have")
+//   - Returns false for a concrete tool output snippet
+func isWeakEvidence(evidence string) bool {
+	if strings.TrimSpace(evidence) == "" {
+		return true
+	}
+	return weakEvidenceRe.MatchString(evidence)
+}
+
+// applyPolicy adjusts a parsed verdict in place according to p.
+//
+// Expectations:
+//   - PolicyDefault: verdict is left unchanged
+//   - PolicyStrict: a "matched" verdict with any met criterion on weak evidence is downgraded
+//     to "retry"; those criteria become met=false/logical and are added to UnmetCriteria
+//   - PolicyStrict: a "matched" verdict whose evidence is all concrete is left unchanged
+//   - PolicyLenient: a "retry" verdict with exactly one criterion, score ≥ 0.5, and non-empty
+//     evidence is upgraded to "matched"
+//   - PolicyLenient: "failed" verdicts are never upgraded
+func applyPolicy(p VerdictPolicy, v *verdict) {
+	switch p {
+	case PolicyStrict:
+		if v.Verdict != "matched" {
+			return
+		}
+		var weak []string
+		for i := range v.CriteriaResults {
+			cr := &v.CriteriaResults[i]
+			if cr.Met && isWeakEvidence(cr.Evidence) {
+				cr.Met = false
+				cr.FailureClass = "logical"
+				weak = append(weak, cr.Criterion)
+			}
+		}
+		if len(weak) == 0 {
+			return
+		}
+		v.Verdict = "retry"
+		if v.Score > 0.5 {
+			v.Score = 0.5
+		}
+		v.UnmetCriteria = append(v.UnmetCriteria, weak...)
+		v.WhatWasWrong = fmt.Sprintf("strict policy: %d criterion(s) accepted on weak evidence: %s", len(weak), strings.Join(weak, "; "))
+		v.WhatToDo = "run a tool whose output directly demonstrates each listed criterion"
+
+	case PolicyLenient:
+		if v.Verdict != "retry" || len(v.CriteriaResults) != 1 || v.Score < 0.5 {
+			return
+		}
+		cr := &v.CriteriaResults[0]
+		if strings.TrimSpace(cr.Evidence) == "" {
+			return
+		}
+		cr.Met = true
+		cr.FailureClass = ""
+		v.Verdict = "matched"
+		v.UnmetCriteria = nil
+		v.WhatWasWrong = ""
+		v.WhatToDo = ""
+	}
+}
 
 // envErrorRe matches deterministic error patterns that indicate an environmental failure.
 // Case-insensitive; applied to criterion evidence and tool call output snippets.
 var envErrorRe = regexp.MustCompile(
 	`(?i)(permission denied|no such file|not found|not exist|` +
 		`connection refused|timed? ?out|network error|` +
-		`command not found|executable file not found|\[LAW1\])`,
+		`command not found|executable file not found|not available in this environment|\[LAW1\])`,
 )
 
 // classifyEnvironmental reports whether the criterion evidence or any tool call output
@@ -99,13 +260,71 @@ func classifyEnvironmental(evidence string, toolCalls []string) bool {
 
 // AgentValidator is R4a. It drives the fast feedback loop for one sub-task.
 type AgentValidator struct {
-	llm *llm.Client
-	b   *bus.Bus
+	llm    *llm.Client
+	b      *bus.Bus
+	policy VerdictPolicy
+	// maxPromptTokens bounds each scoring prompt's estimated size; 0 means no bound.
+	maxPromptTokens int
+	// mem supplies prior verdicts on similar criteria as scoring hints; nil disables them.
+	mem types.MemoryService
+}
+
+// New creates an AgentValidator that scores results under the given policy.
+// An empty policy is treated as PolicyDefault.
+func New(b *bus.Bus, llmClient *llm.Client, policy VerdictPolicy) *AgentValidator {
+	if policy == "" {
+		policy = PolicyDefault
+	}
+	return &AgentValidator{llm: llmClient.ForRole("agentval"), b: b, policy: policy, maxPromptTokens: llm.MaxPromptTokens("agentval")}
 }
 
-// New creates an AgentValidator.
-func New(b *bus.Bus, llmClient *llm.Client) *AgentValidator {
-	return &AgentValidator{llm: llmClient, b: b}
+// NewWithMemory creates an AgentValidator that also recalls, from mem, how
+// similar criteria were judged in earlier tasks and shows those verdicts to the
+// model as scoring hints. A nil mem behaves like New.
+func NewWithMemory(b *bus.Bus, llmClient *llm.Client, policy VerdictPolicy, mem types.MemoryService) *AgentValidator {
+	a := New(b, llmClient, policy)
+	a.mem = mem
+	return a
+}
+
+// maxPriorVerdicts is how many recalled verdicts are shown per criterion.
+const maxPriorVerdicts = 3
+
+// priorVerdicts recalls earlier verdicts on criteria worded like each of
+// criteria (see memory.CriterionTags) and formats them as a prompt section.
+//
+// Expectations:
+//   - Returns "" when mem is nil or nothing is recalled
+//   - Lists at most maxPriorVerdicts recalled verdicts per criterion, newest first
+//   - Queries each tag once even when several criteria share it
+//   - Skips criteria whose memory query fails
+func (a *AgentValidator) priorVerdicts(ctx context.Context, criteria []string) string {
+	if a.mem == nil {
+		return ""
+	}
+	var lines []string
+	seen := make(map[string]bool)
+	for _, c := range criteria {
+		space, entity := memory.CriterionTags(c)
+		if seen[space] {
+			continue
+		}
+		seen[space] = true
+		megs, err := a.mem.QueryRecent(ctx, space, entity, maxPriorVerdicts)
+		if err != nil {
+			slog.Warn("[R4a] prior verdict query failed", "criterion", c, "error", err)
+			continue
+		}
+		for _, m := range megs {
+			lines = append(lines, "- "+m.Content)
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	slog.Info("[R4a] prior verdicts from memory", "criteria", len(criteria), "recalled", len(lines))
+	return "\n\nPrior verdicts on similar criteria from earlier tasks (hints for consistency only — judge this result by its own evidence):\n" +
+		strings.Join(lines, "\n")
 }
 
 type criterionResult struct {
@@ -184,15 +403,69 @@ func toCriteriaVerdicts(crs []criterionResult) []types.CriteriaVerdict {
 	return out
 }
 
+// carryForward splits criteria into those whose pass verdict from an earlier
+// attempt can be reused for result and those that must be scored again.
+// A passed criterion is reused only when its evidence was concrete and that
+// evidence still appears verbatim in the new result's output or tool calls —
+// i.e. the tool output it rested on has not changed.
+//
+// Expectations:
+//   - Returns no cached criteria and all criteria pending when passed is empty
+//   - Reuses a passed criterion whose evidence still appears in result.Output or result.ToolCalls
+//   - Re-scores a passed criterion whose evidence no longer appears in the result
+//   - Re-scores a passed criterion with weak evidence (empty or hedging)
+//   - Re-scores everything when result.Status is "failed"
+//   - Re-scores everything when every criterion would be cached (the LLM still sees the attempt)
+func carryForward(criteria []string, passed map[string]criterionResult, result types.ExecutionResult) (cached []criterionResult, pending []string) {
+	if len(passed) == 0 || result.Status == "failed" {
+		return nil, criteria
+	}
+	outJSON, _ := json.Marshal(result.Output)
+	haystack := string(outJSON) + "\n" + fmt.Sprint(result.Output) + "\n" + strings.Join(result.ToolCalls, "\n")
+	for _, c := range criteria {
+		cr, ok := passed[c]
+		if ok && !isWeakEvidence(cr.Evidence) && strings.Contains(haystack, cr.Evidence) {
+			cached = append(cached, cr)
+			continue
+		}
+		pending = append(pending, c)
+	}
+	if len(pending) == 0 {
+		return nil, criteria
+	}
+	return cached, pending
+}
+
+// mergeCached folds carried-forward criteria into a verdict scored on the
+// remaining criteria only. The score is rescaled over all criteria, counting
+// each cached criterion as fully met; the verdict itself is left to the LLM
+// because cached criteria can never turn a pass into a failure.
+//
+// Expectations:
+//   - Prepends cached criteria to v.CriteriaResults
+//   - Rescales v.Score to (cached + score×pending) / total
+//   - Leaves v unchanged when cached is empty
+func mergeCached(v *verdict, cached []criterionResult, total int) {
+	if len(cached) == 0 || total == 0 {
+		return
+	}
+	pending := total - len(cached)
+	v.Score = (float64(len(cached)) + v.Score*float64(pending)) / float64(total)
+	v.CriteriaResults = append(append([]criterionResult(nil), cached...), v.CriteriaResults...)
+}
+
 // outcome builds a SubTaskOutcome carrying the original criteria so R4b can check them.
 // toolCalls are the tool calls from the final execution attempt, forwarded to R7 (GGS)
 // so it can derive blocked_tools for break_symmetry/change_approach directives.
 // criteriaVerdicts carries per-criterion verdicts from the final attempt (nil for infra errors).
-func (a *AgentValidator) outcome(st types.SubTask, status string, output any, reason *string, traj []types.GapTrajectoryPoint, criteriaVerdicts []types.CriteriaVerdict, toolCalls []string) types.SubTaskOutcome {
+// artifacts are the files written across all attempts — they exist whatever the verdict.
+// Confidence is the final attempt's score (see finalScore).
+func (a *AgentValidator) outcome(st types.SubTask, status string, output any, reason *string, traj []types.GapTrajectoryPoint, criteriaVerdicts []types.CriteriaVerdict, toolCalls, artifacts []string) types.SubTaskOutcome {
 	return types.SubTaskOutcome{
 		SubTaskID:        st.SubTaskID,
 		ParentTaskID:     st.ParentTaskID,
 		Intent:           st.Intent,
+		Sequence:         st.Sequence,
 		SuccessCriteria:  st.SuccessCriteria,
 		Status:           status,
 		Output:           output,
@@ -200,9 +473,20 @@ func (a *AgentValidator) outcome(st types.SubTask, status string, output any, re
 		GapTrajectory:    traj,
 		CriteriaVerdicts: criteriaVerdicts,
 		ToolCalls:        toolCalls,
+		Artifacts:        artifacts,
+		Confidence:       finalScore(traj),
 	}
 }
 
+// finalScore returns the last attempt's score clamped to [0, 1], or 0 when
+// there were no scored attempts.
+func finalScore(traj []types.GapTrajectoryPoint) float64 {
+	if len(traj) == 0 {
+		return 0
+	}
+	return min(max(traj[len(traj)-1].Score, 0), 1)
+}
+
 // publish sends a SubTaskOutcome to the bus.
 func (a *AgentValidator) publish(o types.SubTaskOutcome) {
 	a.b.Publish(types.Message{
@@ -215,10 +499,35 @@ func (a *AgentValidator) publish(o types.SubTaskOutcome) {
 	})
 }
 
+// noNewEvidence is the failure reason when a retry's evidence is byte-identical
+// to the previous attempt's: scoring it again would only repeat the verdict.
+const noNewEvidence = "retry produced no new evidence"
+
+// needReplanReason is the failure reason for a result in which R3 asked for a
+// new plan. It reads as a logical failure to GGS's keyword fallback, steering the
+// replan toward a different decomposition rather than a retry of the same one.
+func needReplanReason(reason string) string {
+	return "executor cannot proceed with the subtask as planned (wrong approach, needs replan): " + reason
+}
+
+// evidenceHash returns r's EvidenceHash, computing it when the producer left it
+// empty (results that did not come from R3).
+func evidenceHash(r types.ExecutionResult) string {
+	if r.EvidenceHash != "" {
+		return r.EvidenceHash
+	}
+	return r.HashEvidence()
+}
+
 // Run drives the fast loop for one sub-task.
 // resultCh receives ExecutionResult messages from the Executor.
 // correctionCh is for sending CorrectionSignals back to the Executor.
 // tlog may be nil — all TaskLog methods are nil-safe.
+//
+// Expectations:
+//   - Fails a retry whose evidence hash equals the previous attempt's with noNewEvidence,
+//     without scoring it, carrying the previous attempt's criteria verdicts
+//   - Fails a result carrying NeedReplan without scoring or retrying it, with needReplanReason
 func (a *AgentValidator) Run(
 	ctx context.Context,
 	subTask types.SubTask,
@@ -235,7 +544,14 @@ func (a *AgentValidator) Run(
 
 	var trajectory []types.GapTrajectoryPoint
 	attempt := 0
-	var lastToolCalls []string // tool calls from the most recent ExecutionResult, forwarded to GGS
+	retries := make(map[string]int) // failing attempts per retry budget (see retryBudgetFor)
+	var lastToolCalls []string      // tool calls from the most recent ExecutionResult, forwarded to GGS
+	var artifacts []string          // files written by any attempt, in first-write order
+	var lastEvidence string         // evidence hash of the previous attempt
+	var lastVerdicts []types.CriteriaVerdict
+	// passed holds the latest pass verdict per criterion so a retry only
+	// re-scores what failed (see carryForward).
+	passed := make(map[string]criterionResult)
 
 	for {
 		// Wait for execution result
@@ -243,24 +559,53 @@ func (a *AgentValidator) Run(
 		select {
 		case <-ctx.Done():
 			reason := "context cancelled"
-			o := a.outcome(subTask, "failed", nil, &reason, trajectory, nil, lastToolCalls)
+			o := a.outcome(subTask, "failed", nil, &reason, trajectory, nil, lastToolCalls, artifacts)
 			tlog.SubtaskEnd(subTask.SubTaskID, "failed")
 			return o
 		case r, ok := <-resultCh:
 			if !ok {
 				reason := "result channel closed"
-				o := a.outcome(subTask, "failed", nil, &reason, trajectory, nil, lastToolCalls)
+				o := a.outcome(subTask, "failed", nil, &reason, trajectory, nil, lastToolCalls, artifacts)
 				tlog.SubtaskEnd(subTask.SubTaskID, "failed")
 				return o
 			}
 			result = r
 			lastToolCalls = result.ToolCalls
+			for _, p := range result.Artifacts {
+				if !slices.Contains(artifacts, p) {
+					artifacts = append(artifacts, p)
+				}
+			}
+		}
+
+		if result.NeedReplan != "" {
+			slog.Info("[R4a] subtask FAILED: executor asked for a replan", "subtask", subTask.SubTaskID, "reason", result.NeedReplan)
+			reason := needReplanReason(result.NeedReplan)
+			o := a.outcome(subTask, "failed", result.Output, &reason, trajectory, lastVerdicts, lastToolCalls, artifacts)
+			tlog.SubtaskEnd(subTask.SubTaskID, "failed")
+			a.publish(o)
+			return o
+		}
+
+		evidence := evidenceHash(result)
+		if attempt > 0 && evidence == lastEvidence {
+			slog.Info("[R4a] subtask FAILED: retry produced no new evidence", "subtask", subTask.SubTaskID, "attempt", attempt+1)
+			reason := noNewEvidence
+			o := a.outcome(subTask, "failed", result.Output, &reason, trajectory, lastVerdicts, lastToolCalls, artifacts)
+			tlog.SubtaskEnd(subTask.SubTaskID, "failed")
+			a.publish(o)
+			return o
 		}
+		lastEvidence = evidence
 
 		attempt++
 		slog.Debug("[R4a] scoring subtask", "subtask", subTask.SubTaskID, "attempt", attempt, "status", result.Status)
 
-		v, err := a.score(ctx, subTask, result, tlog)
+		cached, pending := carryForward(subTask.SuccessCriteria, passed, result)
+		if len(cached) > 0 {
+			slog.Info("[R4a] carrying forward passed criteria", "subtask", subTask.SubTaskID, "attempt", attempt, "cached", len(cached), "rescoring", len(pending))
+		}
+		v, err := a.score(ctx, subTask, result, cached, pending, tlog)
 		if err != nil {
 			slog.Error("[R4a] scoring error", "error", err)
 			reason := fmt.Sprintf("scoring error: %v", err)
@@ -309,28 +654,37 @@ func (a *AgentValidator) Run(
 		// Log per-criterion verdicts to the task log.
 		for _, cr := range v.CriteriaResults {
 			tlog.CriterionVerdict(subTask.SubTaskID, cr.Criterion, cr.Met, cr.Evidence, attempt)
+			if cr.Met {
+				passed[cr.Criterion] = cr
+			} else {
+				delete(passed, cr.Criterion)
+			}
 		}
 
+		lastVerdicts = toCriteriaVerdicts(v.CriteriaResults)
+		attemptClass := aggregateFailureClass(v.CriteriaResults)
 		trajectory = append(trajectory, types.GapTrajectoryPoint{
 			Attempt:       attempt,
 			Score:         v.Score,
 			UnmetCriteria: v.UnmetCriteria,
-			FailureClass:  aggregateFailureClass(v.CriteriaResults),
+			FailureClass:  attemptClass,
 		})
 
 		switch v.Verdict {
 		case "matched":
 			slog.Info("[R4a] subtask MATCHED", "subtask", subTask.SubTaskID, "attempt", attempt)
-			o := a.outcome(subTask, "matched", result.Output, nil, trajectory, toCriteriaVerdicts(v.CriteriaResults), lastToolCalls)
+			o := a.outcome(subTask, "matched", result.Output, nil, trajectory, toCriteriaVerdicts(v.CriteriaResults), lastToolCalls, artifacts)
 			tlog.SubtaskEnd(subTask.SubTaskID, "matched")
 			a.publish(o)
 			return o
 
 		case "retry":
-			if attempt >= maxRetries {
-				slog.Info("[R4a] subtask max retries reached", "subtask", subTask.SubTaskID, "max_retries", maxRetries)
-				reason := fmt.Sprintf("max retries (%d) reached; last issue: %s", maxRetries, v.WhatWasWrong)
-				o := a.outcome(subTask, "failed", result.Output, &reason, trajectory, toCriteriaVerdicts(v.CriteriaResults), lastToolCalls)
+			budget, maxBudget := retryBudgetFor(attemptClass, subTask.MaxRetries)
+			retries[budget]++
+			if retries[budget] >= maxBudget {
+				slog.Info("[R4a] subtask max retries reached", "subtask", subTask.SubTaskID, "budget", budget, "max_retries", maxBudget, "attempts", attempt)
+				reason := fmt.Sprintf("max %s retries (%d) reached; last issue: %s", budget, maxBudget, v.WhatWasWrong)
+				o := a.outcome(subTask, "failed", result.Output, &reason, trajectory, toCriteriaVerdicts(v.CriteriaResults), lastToolCalls, artifacts)
 				tlog.SubtaskEnd(subTask.SubTaskID, "failed")
 				a.publish(o)
 				return o
@@ -369,7 +723,7 @@ func (a *AgentValidator) Run(
 			case correctionCh <- correction:
 			case <-ctx.Done():
 				reason := "context cancelled during correction"
-				o := a.outcome(subTask, "failed", nil, &reason, trajectory, nil, lastToolCalls)
+				o := a.outcome(subTask, "failed", nil, &reason, trajectory, nil, lastToolCalls, artifacts)
 				tlog.SubtaskEnd(subTask.SubTaskID, "failed")
 				return o
 			}
@@ -380,7 +734,7 @@ func (a *AgentValidator) Run(
 				reason = "validation failed"
 			}
 			slog.Info("[R4a] subtask FAILED", "subtask", subTask.SubTaskID, "reason", reason)
-			o := a.outcome(subTask, "failed", result.Output, &reason, trajectory, toCriteriaVerdicts(v.CriteriaResults), lastToolCalls)
+			o := a.outcome(subTask, "failed", result.Output, &reason, trajectory, toCriteriaVerdicts(v.CriteriaResults), lastToolCalls, artifacts)
 			tlog.SubtaskEnd(subTask.SubTaskID, "failed")
 			a.publish(o)
 			return o
@@ -388,19 +742,33 @@ func (a *AgentValidator) Run(
 	}
 }
 
-func (a *AgentValidator) score(ctx context.Context, st types.SubTask, result types.ExecutionResult, tlog *tasklog.TaskLog) (*verdict, error) {
-	taskJSON, _ := json.MarshalIndent(st, "", "  ")
+// score asks the LLM for a verdict on the pending criteria and merges in the
+// cached ones carried forward from earlier attempts.
+func (a *AgentValidator) score(ctx context.Context, st types.SubTask, result types.ExecutionResult, cached []criterionResult, pending []string, tlog *tasklog.TaskLog) (*verdict, error) {
+	scored := st
+	scored.SuccessCriteria = pending
+	taskJSON, _ := json.MarshalIndent(scored, "", "  ")
+	result.EvidenceHash = "" // bookkeeping for R4a, not evidence for the model
 	resultJSON, _ := json.MarshalIndent(result, "", "  ")
 
 	today := time.Now().UTC().Format("2006-01-02")
 	userPrompt := fmt.Sprintf("Today's date: %s\n\nSubTask:\n%s\n\nExecutionResult:\n%s", today, taskJSON, resultJSON)
+	if len(cached) > 0 {
+		userPrompt += fmt.Sprintf("\n\nNote: %d other criteria passed on a previous attempt with unchanged evidence and are not part of this check. Score only the success_criteria listed above.", len(cached))
+	}
+	userPrompt += a.priorVerdicts(ctx, pending)
 
-	raw, usage, err := a.llm.Chat(ctx, systemPrompt, userPrompt)
-	tlog.LLMCall("agentval", systemPrompt, userPrompt, raw, usage.PromptTokens, usage.CompletionTokens, usage.ElapsedMs, 0)
+	system := a.llm.SystemPrompt(systemPrompt) + policyPrompt(a.policy)
+	userPrompt = llm.FitUser(a.maxPromptTokens, system, userPrompt)
+	raw, usage, err := a.llm.Chat(ctx, system, userPrompt)
+	tlog.LLMCall("agentval", system, userPrompt, raw, usage.PromptTokens, usage.CompletionTokens, usage.ElapsedMs, 0)
 	if err != nil {
 		return nil, err
 	}
-	raw = llm.StripFences(raw)
+	raw, err = llm.RepairJSON(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse verdict: %w (raw: %s)", err, raw)
+	}
 
 	var v verdict
 	if err := json.Unmarshal([]byte(raw), &v); err != nil {
@@ -420,5 +788,13 @@ func (a *AgentValidator) score(ctx context.Context, st types.SubTask, result typ
 		}
 	}
 
+	mergeCached(&v, cached, len(cached)+len(pending))
+
+	before := v.Verdict
+	applyPolicy(a.policy, &v)
+	if v.Verdict != before {
+		slog.Info("[R4a] verdict adjusted by policy", "subtask", st.SubTaskID, "policy", a.policy, "from", before, "to", v.Verdict)
+	}
+
 	return &v, nil
 }
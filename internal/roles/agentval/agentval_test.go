@@ -1,8 +1,22 @@
 package agentval
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/llm"
+	"github.com/haricheung/agentic-shell/internal/roles/memory"
 	"github.com/haricheung/agentic-shell/internal/types"
 )
 
@@ -37,6 +51,14 @@ func TestClassifyEnvironmental_ConnectionRefusedInToolCall(t *testing.T) {
 	}
 }
 
+func TestClassifyEnvironmental_ToolTimeoutInToolCall(t *testing.T) {
+	// Returns true when a tool call ended at the executor's per-tool timeout
+	toolCalls := []string{"applescript:display dialog → ERROR: tool applescript timed out after 20s"}
+	if !classifyEnvironmental("", toolCalls) {
+		t.Error("expected true when a tool call timed out")
+	}
+}
+
 func TestClassifyEnvironmental_FalseForPureLogicFailure(t *testing.T) {
 	// Returns false for a pure logic failure with no error keywords
 	if classifyEnvironmental("the algorithm returned unexpected results: got 42, want 0", nil) {
@@ -179,3 +201,676 @@ func TestToCriteriaVerdicts_PreservesOrderAndCount(t *testing.T) {
 		}
 	}
 }
+
+// ── ParseVerdictPolicy ───────────────────────────────────────────────────────
+
+func TestParseVerdictPolicy_EmptyIsDefault(t *testing.T) {
+	// Returns PolicyDefault for "" and "default"
+	for _, in := range []string{"", "default"} {
+		p, err := ParseVerdictPolicy(in)
+		if err != nil || p != PolicyDefault {
+			t.Errorf("ParseVerdictPolicy(%q) = %q, %v; want default, nil", in, p, err)
+		}
+	}
+}
+
+func TestParseVerdictPolicy_StrictAndLenient(t *testing.T) {
+	// Returns PolicyStrict for "strict" and PolicyLenient for "lenient"
+	if p, err := ParseVerdictPolicy(" Strict "); err != nil || p != PolicyStrict {
+		t.Errorf("expected strict, got %q, %v", p, err)
+	}
+	if p, err := ParseVerdictPolicy("lenient"); err != nil || p != PolicyLenient {
+		t.Errorf("expected lenient, got %q, %v", p, err)
+	}
+}
+
+func TestParseVerdictPolicy_UnknownReturnsError(t *testing.T) {
+	// Returns PolicyDefault and a non-nil error for any other value
+	p, err := ParseVerdictPolicy("paranoid")
+	if err == nil {
+		t.Error("expected error for unknown policy")
+	}
+	if p != PolicyDefault {
+		t.Errorf("expected default fallback, got %q", p)
+	}
+}
+
+// ── isWeakEvidence ───────────────────────────────────────────────────────────
+
+func TestIsWeakEvidence_EmptyIsWeak(t *testing.T) {
+	// Returns true for empty or whitespace-only evidence
+	if !isWeakEvidence("  ") {
+		t.Error("expected whitespace evidence to be weak")
+	}
+}
+
+func TestIsWeakEvidence_HedgingIsWeak(t *testing.T) {
+	// Returns true when evidence contains hedging words ("likely", "appears to", "should have")
+	for _, ev := range []string{"file was likely written", "output appears to contain the list", "the script should have run"} {
+		if !isWeakEvidence(ev) {
+			t.Errorf("expected %q to be weak", ev)
+		}
+	}
+}
+
+func TestIsWeakEvidence_ConcreteIsNotWeak(t *testing.T) {
+	// Returns false for a concrete tool output snippet
+	if isWeakEvidence("stdout: /Users/me/Music/song.mp3 (4.2MB)") {
+		t.Error("expected concrete tool output to be strong evidence")
+	}
+}
+
+// ── applyPolicy ──────────────────────────────────────────────────────────────
+
+// borderlineVerdict is a "matched" verdict where one criterion is backed only by inference.
+func borderlineVerdict() *verdict {
+	return &verdict{
+		Verdict: "matched",
+		Score:   0.9,
+		CriteriaResults: []criterionResult{
+			{Criterion: "file exists", Met: true, Evidence: "ls: report.md"},
+			{Criterion: "file has 3 sections", Met: true, Evidence: "content likely has three sections"},
+		},
+	}
+}
+
+func TestApplyPolicy_DefaultLeavesVerdictUnchanged(t *testing.T) {
+	// PolicyDefault: verdict is left unchanged
+	v := borderlineVerdict()
+	applyPolicy(PolicyDefault, v)
+	if v.Verdict != "matched" || v.Score != 0.9 {
+		t.Errorf("expected default to keep matched/0.9, got %s/%.2f", v.Verdict, v.Score)
+	}
+}
+
+func TestApplyPolicy_StrictDowngradesWeakEvidence(t *testing.T) {
+	// PolicyStrict: a "matched" verdict with any met criterion on weak evidence is downgraded
+	// to "retry"; those criteria become met=false/logical and are added to UnmetCriteria
+	v := borderlineVerdict()
+	applyPolicy(PolicyStrict, v)
+	if v.Verdict != "retry" {
+		t.Fatalf("expected retry, got %s", v.Verdict)
+	}
+	if v.Score > 0.5 {
+		t.Errorf("expected score capped at 0.5, got %.2f", v.Score)
+	}
+	if v.CriteriaResults[1].Met || v.CriteriaResults[1].FailureClass != "logical" {
+		t.Errorf("expected weak criterion to be unmet/logical, got %+v", v.CriteriaResults[1])
+	}
+	if !v.CriteriaResults[0].Met {
+		t.Error("expected concrete criterion to stay met")
+	}
+	if len(v.UnmetCriteria) != 1 || v.UnmetCriteria[0] != "file has 3 sections" {
+		t.Errorf("expected weak criterion in UnmetCriteria, got %v", v.UnmetCriteria)
+	}
+	if v.WhatWasWrong == "" || v.WhatToDo == "" {
+		t.Error("expected correction fields to be populated")
+	}
+}
+
+func TestApplyPolicy_StrictKeepsConcreteMatch(t *testing.T) {
+	// PolicyStrict: a "matched" verdict whose evidence is all concrete is left unchanged
+	v := &verdict{Verdict: "matched", Score: 1.0, CriteriaResults: []criterionResult{
+		{Criterion: "file exists", Met: true, Evidence: "ls: report.md"},
+	}}
+	applyPolicy(PolicyStrict, v)
+	if v.Verdict != "matched" {
+		t.Errorf("expected matched, got %s", v.Verdict)
+	}
+}
+
+func TestApplyPolicy_LenientAcceptsSingleCriterionPartial(t *testing.T) {
+	// PolicyLenient: a "retry" verdict with exactly one criterion, score ≥ 0.5, and non-empty
+	// evidence is upgraded to "matched"
+	v := &verdict{
+		Verdict:         "retry",
+		Score:           0.6,
+		CriteriaResults: []criterionResult{{Criterion: "list videos", Met: false, FailureClass: "logical", Evidence: "found 4 of 5 videos"}},
+		UnmetCriteria:   []string{"list videos"},
+		WhatWasWrong:    "one video missing",
+	}
+	applyPolicy(PolicyLenient, v)
+	if v.Verdict != "matched" || !v.CriteriaResults[0].Met {
+		t.Errorf("expected lenient upgrade to matched, got %+v", v)
+	}
+	if len(v.UnmetCriteria) != 0 || v.WhatWasWrong != "" {
+		t.Errorf("expected retry fields cleared, got %+v", v)
+	}
+}
+
+func TestApplyPolicy_LenientNeverUpgradesFailed(t *testing.T) {
+	// PolicyLenient: "failed" verdicts are never upgraded
+	v := &verdict{
+		Verdict:         "failed",
+		Score:           0.6,
+		CriteriaResults: []criterionResult{{Criterion: "c", Met: false, Evidence: "partial"}},
+	}
+	applyPolicy(PolicyLenient, v)
+	if v.Verdict != "failed" {
+		t.Errorf("expected failed to stay failed, got %s", v.Verdict)
+	}
+}
+
+// ── carryForward ─────────────────────────────────────────────────────────────
+
+var twoCriteria = []string{"output lists the file size in bytes", "output names the file owner"}
+
+func TestCarryForward_NothingPassedScoresAll(t *testing.T) {
+	// Returns no cached criteria and all criteria pending when passed is empty
+	cached, pending := carryForward(twoCriteria, nil, types.ExecutionResult{Output: "x"})
+	if len(cached) != 0 || len(pending) != 2 {
+		t.Errorf("expected 0 cached / 2 pending, got %d / %d", len(cached), len(pending))
+	}
+}
+
+func TestCarryForward_ReusesUnchangedEvidence(t *testing.T) {
+	// Reuses a passed criterion whose evidence still appears in result.Output or result.ToolCalls
+	passed := map[string]criterionResult{twoCriteria[0]: {Criterion: twoCriteria[0], Met: true, Evidence: "size=4096"}}
+	res := types.ExecutionResult{Status: "completed", Output: "report.txt", ToolCalls: []string{"shell: stat → size=4096 owner=?"}}
+	cached, pending := carryForward(twoCriteria, passed, res)
+	if len(cached) != 1 || cached[0].Criterion != twoCriteria[0] {
+		t.Errorf("expected first criterion cached, got %+v", cached)
+	}
+	if len(pending) != 1 || pending[0] != twoCriteria[1] {
+		t.Errorf("expected only second criterion pending, got %v", pending)
+	}
+}
+
+func TestCarryForward_RescoresChangedEvidence(t *testing.T) {
+	// Re-scores a passed criterion whose evidence no longer appears in the result
+	passed := map[string]criterionResult{twoCriteria[0]: {Criterion: twoCriteria[0], Met: true, Evidence: "size=4096"}}
+	res := types.ExecutionResult{Status: "completed", Output: "size=8192"}
+	if cached, pending := carryForward(twoCriteria, passed, res); len(cached) != 0 || len(pending) != 2 {
+		t.Errorf("expected no cache on changed evidence, got %d cached", len(cached))
+	}
+}
+
+func TestCarryForward_RescoresWeakEvidence(t *testing.T) {
+	// Re-scores a passed criterion with weak evidence (empty or hedging)
+	passed := map[string]criterionResult{twoCriteria[0]: {Criterion: twoCriteria[0], Met: true, Evidence: "appears to be ok"}}
+	res := types.ExecutionResult{Status: "completed", Output: "it appears to be ok"}
+	if cached, _ := carryForward(twoCriteria, passed, res); len(cached) != 0 {
+		t.Errorf("expected weak evidence not to be cached, got %+v", cached)
+	}
+}
+
+func TestCarryForward_FailedResultRescoresAll(t *testing.T) {
+	// Re-scores everything when result.Status is "failed"
+	passed := map[string]criterionResult{twoCriteria[0]: {Criterion: twoCriteria[0], Met: true, Evidence: "size=4096"}}
+	res := types.ExecutionResult{Status: "failed", Output: "size=4096"}
+	if cached, pending := carryForward(twoCriteria, passed, res); len(cached) != 0 || len(pending) != 2 {
+		t.Errorf("expected full re-score for failed result, got %d cached", len(cached))
+	}
+}
+
+func TestCarryForward_AllCachedRescoresAll(t *testing.T) {
+	// Re-scores everything when every criterion would be cached (the LLM still sees the attempt)
+	passed := map[string]criterionResult{
+		twoCriteria[0]: {Criterion: twoCriteria[0], Met: true, Evidence: "size=4096"},
+		twoCriteria[1]: {Criterion: twoCriteria[1], Met: true, Evidence: "owner=alice"},
+	}
+	res := types.ExecutionResult{Status: "completed", Output: "size=4096 owner=alice"}
+	if cached, pending := carryForward(twoCriteria, passed, res); len(cached) != 0 || len(pending) != 2 {
+		t.Errorf("expected full re-score, got %d cached", len(cached))
+	}
+}
+
+// ── mergeCached ──────────────────────────────────────────────────────────────
+
+func TestMergeCached_PrependsAndRescales(t *testing.T) {
+	// Prepends cached criteria to v.CriteriaResults
+	// Rescales v.Score to (cached + score×pending) / total
+	v := &verdict{Verdict: "retry", Score: 0.0, CriteriaResults: []criterionResult{{Criterion: "b", Met: false}}}
+	mergeCached(v, []criterionResult{{Criterion: "a", Met: true, Evidence: "x"}}, 2)
+	if len(v.CriteriaResults) != 2 || v.CriteriaResults[0].Criterion != "a" {
+		t.Errorf("expected cached criterion first, got %+v", v.CriteriaResults)
+	}
+	if v.Score != 0.5 {
+		t.Errorf("expected score 0.5, got %v", v.Score)
+	}
+}
+
+func TestMergeCached_NoCacheIsNoop(t *testing.T) {
+	// Leaves v unchanged when cached is empty
+	v := &verdict{Verdict: "matched", Score: 0.9}
+	mergeCached(v, nil, 2)
+	if v.Score != 0.9 || len(v.CriteriaResults) != 0 {
+		t.Errorf("expected unchanged verdict, got %+v", v)
+	}
+}
+
+// ── Run — criterion caching across attempts ──────────────────────────────────
+
+// mockLLMResponse builds a minimal OpenAI-compatible chat completion JSON
+// whose content field is the provided body string.
+func mockLLMResponse(body string) string {
+	escaped, _ := json.Marshal(body)
+	return `{"choices":[{"message":{"role":"assistant","content":` + string(escaped) + `}}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`
+}
+
+func TestRun_RetryRescoresOnlyFailedCriterion(t *testing.T) {
+	// A criterion that passed on attempt 1 with unchanged evidence is not re-scored on attempt 2
+	bodies := []string{
+		`{"verdict":"retry","score":0.5,"criteria_results":[` +
+			`{"criterion":"output lists the file size in bytes","met":true,"evidence":"size=4096"},` +
+			`{"criterion":"output names the file owner","met":false,"failure_class":"logical","evidence":"owner missing"}],` +
+			`"unmet_criteria":["output names the file owner"],"what_was_wrong":"no owner","what_to_do":"use stat -c %U"}`,
+		`{"verdict":"matched","score":1.0,"criteria_results":[` +
+			`{"criterion":"output names the file owner","met":true,"evidence":"owner=alice"}],"unmet_criteria":[]}`,
+	}
+	var mu sync.Mutex
+	var prompts []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var req struct {
+			Messages []struct{ Content string } `json:"messages"`
+		}
+		json.Unmarshal(raw, &req)
+		mu.Lock()
+		i := min(len(prompts), len(bodies)-1)
+		prompts = append(prompts, req.Messages[1].Content)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(bodies[i])))
+	}))
+	defer ts.Close()
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+
+	st := types.SubTask{SubTaskID: "s1", ParentTaskID: "t1", Intent: "describe report.txt", SuccessCriteria: twoCriteria}
+	resultCh := make(chan types.ExecutionResult, 2)
+	correctionCh := make(chan types.CorrectionSignal, 2)
+	resultCh <- types.ExecutionResult{SubTaskID: "s1", Status: "completed", Output: "size=4096",
+		ToolCalls: []string{"shell: stat -c %s report.txt → size=4096"}}
+	go func() {
+		<-correctionCh
+		resultCh <- types.ExecutionResult{SubTaskID: "s1", Status: "completed", Output: "size=4096 owner=alice",
+			ToolCalls: []string{"shell: stat -c %s report.txt → size=4096", "shell: stat -c %U report.txt → owner=alice"}}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	o := New(bus.New(), llm.New(), PolicyDefault).Run(ctx, st, resultCh, correctionCh, nil)
+
+	if o.Status != "matched" {
+		t.Fatalf("expected matched, got %s (%v)", o.Status, o.FailureReason)
+	}
+	if len(prompts) != 2 {
+		t.Fatalf("expected 2 scoring calls, got %d", len(prompts))
+	}
+	if !strings.Contains(prompts[0], twoCriteria[0]) || !strings.Contains(prompts[0], twoCriteria[1]) {
+		t.Errorf("attempt 1 should score both criteria")
+	}
+	if strings.Contains(prompts[1], twoCriteria[0]) {
+		t.Errorf("attempt 2 re-scored the already-passed criterion:\n%s", prompts[1])
+	}
+	if !strings.Contains(prompts[1], twoCriteria[1]) {
+		t.Errorf("attempt 2 should score the failed criterion:\n%s", prompts[1])
+	}
+	if len(o.CriteriaVerdicts) != 2 {
+		t.Fatalf("expected both criteria in the outcome, got %+v", o.CriteriaVerdicts)
+	}
+	for _, cv := range o.CriteriaVerdicts {
+		if cv.Verdict != "pass" {
+			t.Errorf("expected all criteria to pass, got %+v", cv)
+		}
+	}
+	if got := o.GapTrajectory[len(o.GapTrajectory)-1].Score; got != 1.0 {
+		t.Errorf("expected final score 1.0, got %v", got)
+	}
+}
+
+func TestRun_OutcomeCarriesFinalScoreAsConfidence(t *testing.T) {
+	// Confidence is the final attempt's score: a barely matched subtask carries 0.6
+	body := `{"verdict":"matched","score":0.6,"criteria_results":[` +
+		`{"criterion":"output names the file owner","met":true,"evidence":"owner=alice"}],"unmet_criteria":[]}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(body)))
+	}))
+	defer ts.Close()
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+
+	st := types.SubTask{SubTaskID: "s1", ParentTaskID: "t1", Intent: "find the owner of report.txt",
+		SuccessCriteria: []string{"output names the file owner"}}
+	resultCh := make(chan types.ExecutionResult, 1)
+	resultCh <- types.ExecutionResult{SubTaskID: "s1", Status: "completed", Output: "owner=alice"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	o := New(bus.New(), llm.New(), PolicyDefault).Run(ctx, st, resultCh, make(chan types.CorrectionSignal, 1), nil)
+
+	if o.Status != "matched" || o.Confidence != 0.6 {
+		t.Errorf("expected matched with confidence 0.6, got %s / %v", o.Status, o.Confidence)
+	}
+}
+
+func TestFinalScore_ClampsAndDefaults(t *testing.T) {
+	// Returns the last attempt's score clamped to [0, 1], or 0 without attempts
+	if got := finalScore(nil); got != 0 {
+		t.Errorf("no attempts: got %v, want 0", got)
+	}
+	traj := []types.GapTrajectoryPoint{{Attempt: 1, Score: 0.2}, {Attempt: 2, Score: 1.4}}
+	if got := finalScore(traj); got != 1 {
+		t.Errorf("got %v, want the last score clamped to 1", got)
+	}
+}
+
+// ── retry budgets ────────────────────────────────────────────────────────────
+
+func TestRetryBudgetFor_EnvironmentalUsesEnvironmentalBudget(t *testing.T) {
+	// Returns ("environmental", maxEnvironmentalRetries) for "environmental"
+	if b, n := retryBudgetFor("environmental", nil); b != "environmental" || n != maxEnvironmentalRetries {
+		t.Errorf("expected environmental budget, got %s/%d", b, n)
+	}
+}
+
+func TestRetryBudgetFor_OtherClassesUseLogicalBudget(t *testing.T) {
+	// Returns ("logical", maxLogicalRetries) for "logical", "mixed", and "" (unclassified)
+	for _, class := range []string{"logical", "mixed", ""} {
+		if b, n := retryBudgetFor(class, nil); b != "logical" || n != maxLogicalRetries {
+			t.Errorf("class %q: expected logical budget, got %s/%d", class, b, n)
+		}
+	}
+}
+
+func TestRetryBudgetFor_OverrideReplacesBudgetSize(t *testing.T) {
+	// A non-nil override replaces the size of either budget, clamped to 0..maxRetriesOverride;
+	// 0 means the first retry verdict fails the subtask
+	for _, tc := range []struct {
+		class    string
+		override int
+		want     int
+	}{{"environmental", 1, 1}, {"logical", 3, 3}, {"logical", 0, 0}, {"environmental", -1, 0}, {"logical", 50, maxRetriesOverride}} {
+		override := tc.override
+		if _, n := retryBudgetFor(tc.class, &override); n != tc.want {
+			t.Errorf("class %q, override %d: got %d, want %d", tc.class, tc.override, n, tc.want)
+		}
+	}
+}
+
+// runAlwaysRetry drives Run against a model that answers every attempt with a
+// retry verdict carrying class, and returns the outcome and the attempt count.
+func runAlwaysRetry(t *testing.T, class, evidence string) (types.SubTaskOutcome, int) {
+	t.Helper()
+	return runAlwaysRetryWith(t, class, evidence, nil)
+}
+
+// runAlwaysRetryWith is runAlwaysRetry for a subtask with MaxRetries set to maxRetries.
+func runAlwaysRetryWith(t *testing.T, class, evidence string, maxRetries *int) (types.SubTaskOutcome, int) {
+	t.Helper()
+	body := `{"verdict":"retry","score":0.3,"criteria_results":[` +
+		`{"criterion":"output names the file owner","met":false,"failure_class":"` + class + `","evidence":"` + evidence + `"}],` +
+		`"unmet_criteria":["output names the file owner"],"what_was_wrong":"no owner","what_to_do":"try again"}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(body)))
+	}))
+	t.Cleanup(ts.Close)
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+
+	st := types.SubTask{SubTaskID: "s1", ParentTaskID: "t1", Intent: "find the owner of report.txt",
+		SuccessCriteria: []string{"output names the file owner"}, MaxRetries: maxRetries}
+	resultCh := make(chan types.ExecutionResult, 1)
+	correctionCh := make(chan types.CorrectionSignal, 1)
+	attempts := 1
+	resultCh <- types.ExecutionResult{SubTaskID: "s1", Status: "completed", Output: "attempt 1"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		for {
+			select {
+			case <-correctionCh:
+				attempts++
+				resultCh <- types.ExecutionResult{SubTaskID: "s1", Status: "completed", Output: fmt.Sprintf("attempt %d", attempts)}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	o := New(bus.New(), llm.New(), PolicyDefault).Run(ctx, st, resultCh, correctionCh, nil)
+	cancel()
+	return o, len(o.GapTrajectory)
+}
+
+func TestRun_EnvironmentalFailureGetsEnvironmentalBudget(t *testing.T) {
+	// An environmentally failing subtask is retried until maxEnvironmentalRetries attempts
+	o, attempts := runAlwaysRetry(t, "environmental", "connection timed out")
+	if o.Status != "failed" {
+		t.Fatalf("expected failed, got %s", o.Status)
+	}
+	if attempts != maxEnvironmentalRetries {
+		t.Errorf("expected %d attempts, got %d", maxEnvironmentalRetries, attempts)
+	}
+	if o.FailureReason == nil || !strings.Contains(*o.FailureReason, "max environmental retries") {
+		t.Errorf("expected the environmental budget in the failure reason, got %v", o.FailureReason)
+	}
+}
+
+func TestRun_LogicalFailureGetsLogicalBudget(t *testing.T) {
+	// A logically failing subtask is retried until maxLogicalRetries attempts
+	o, attempts := runAlwaysRetry(t, "logical", "owner missing from output")
+	if o.Status != "failed" {
+		t.Fatalf("expected failed, got %s", o.Status)
+	}
+	if attempts != maxLogicalRetries {
+		t.Errorf("expected %d attempts, got %d", maxLogicalRetries, attempts)
+	}
+	if o.FailureReason == nil || !strings.Contains(*o.FailureReason, "max logical retries") {
+		t.Errorf("expected the logical budget in the failure reason, got %v", o.FailureReason)
+	}
+}
+
+func TestRun_MaxRetriesOverridesBudget(t *testing.T) {
+	// A subtask's MaxRetries replaces the class budget: 3 allows three attempts
+	// where the logical default allows maxLogicalRetries
+	three := 3
+	o, attempts := runAlwaysRetryWith(t, "logical", "owner missing from output", &three)
+	if o.Status != "failed" || attempts != 3 {
+		t.Errorf("status %s after %d attempts, want failed after 3", o.Status, attempts)
+	}
+	if o.FailureReason == nil || !strings.Contains(*o.FailureReason, "max logical retries (3)") {
+		t.Errorf("expected the overridden budget in the failure reason, got %v", o.FailureReason)
+	}
+}
+
+func TestRun_MaxRetriesZeroFailsOnFirstRetry(t *testing.T) {
+	// MaxRetries 0 fails the subtask on its first retry verdict, without a correction
+	zero := 0
+	o, attempts := runAlwaysRetryWith(t, "environmental", "connection timed out", &zero)
+	if o.Status != "failed" || attempts != 1 {
+		t.Errorf("status %s after %d attempts, want failed after 1", o.Status, attempts)
+	}
+}
+
+// ── Run — retries without new evidence ───────────────────────────────────────
+
+func TestEvidenceHash_CoversToolCallsAndOutputOnly(t *testing.T) {
+	// Equal tool calls and output give equal hashes, whatever the other fields;
+	// a change to any tool call, their order, or the output changes the hash;
+	// evidenceHash prefers the producer's EvidenceHash
+	a := types.ExecutionResult{SubTaskID: "s1", Status: "completed", Output: "owner=root",
+		ToolCalls: []string{"shell: ls -l → x", "shell: stat → y"}}
+	b := a
+	b.Status, b.Artifacts = "uncertain", []string{"/tmp/out.txt"}
+	if a.HashEvidence() != b.HashEvidence() {
+		t.Error("status and artifacts should not change the hash")
+	}
+	for name, r := range map[string]types.ExecutionResult{
+		"output":    {Output: "owner=alice", ToolCalls: a.ToolCalls},
+		"order":     {Output: a.Output, ToolCalls: []string{"shell: stat → y", "shell: ls -l → x"}},
+		"tool call": {Output: a.Output, ToolCalls: []string{"shell: ls -l → x"}},
+	} {
+		if r.HashEvidence() == a.HashEvidence() {
+			t.Errorf("a different %s should change the hash", name)
+		}
+	}
+	if got := evidenceHash(types.ExecutionResult{EvidenceHash: "abc"}); got != "abc" {
+		t.Errorf("evidenceHash = %q, want the producer's hash", got)
+	}
+}
+
+func TestRun_IdenticalRetryFailsWithoutScoring(t *testing.T) {
+	// Fails a retry whose evidence hash equals the previous attempt's with noNewEvidence,
+	// without scoring it, carrying the previous attempt's criteria verdicts
+	body := `{"verdict":"retry","score":0.3,"criteria_results":[` +
+		`{"criterion":"output names the file owner","met":false,"failure_class":"logical","evidence":"owner missing"}],` +
+		`"unmet_criteria":["output names the file owner"],"what_was_wrong":"no owner","what_to_do":"use stat -c %U"}`
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(body)))
+	}))
+	defer ts.Close()
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+
+	st := types.SubTask{SubTaskID: "s1", ParentTaskID: "t1", Intent: "find the owner of report.txt",
+		SuccessCriteria: []string{"output names the file owner"}}
+	same := types.ExecutionResult{SubTaskID: "s1", Status: "completed", Output: "size=4096",
+		ToolCalls: []string{"shell: stat -c %s report.txt → size=4096"}}
+	resultCh := make(chan types.ExecutionResult, 2)
+	correctionCh := make(chan types.CorrectionSignal, 2)
+	resultCh <- same
+	go func() {
+		<-correctionCh
+		retry := same
+		retry.EvidenceHash = retry.HashEvidence() // as R3 publishes it
+		resultCh <- retry
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	o := New(bus.New(), llm.New(), PolicyDefault).Run(ctx, st, resultCh, correctionCh, nil)
+
+	if o.Status != "failed" || o.FailureReason == nil || *o.FailureReason != noNewEvidence {
+		t.Fatalf("got %s (%v), want failed with %q", o.Status, o.FailureReason, noNewEvidence)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected 1 scoring call, got %d", n)
+	}
+	if len(o.GapTrajectory) != 1 {
+		t.Errorf("expected only the scored attempt in the trajectory, got %+v", o.GapTrajectory)
+	}
+	if len(o.CriteriaVerdicts) != 1 || o.CriteriaVerdicts[0].Verdict != "fail" {
+		t.Errorf("expected the previous attempt's verdicts, got %+v", o.CriteriaVerdicts)
+	}
+}
+
+func TestRun_NeedReplanFailsWithoutScoring(t *testing.T) {
+	// Fails a result carrying NeedReplan without scoring or retrying it, with needReplanReason
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(`{"verdict":"matched","score":1}`)))
+	}))
+	defer ts.Close()
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+
+	st := types.SubTask{SubTaskID: "s1", ParentTaskID: "t1", Intent: "find the invoice",
+		SuccessCriteria: []string{"output is the invoice path"}}
+	resultCh := make(chan types.ExecutionResult, 1)
+	correctionCh := make(chan types.CorrectionSignal, 1)
+	resultCh <- types.ExecutionResult{SubTaskID: "s1", Status: "failed", Output: "need_replan: personal or project file?",
+		NeedReplan: "personal or project file?"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	o := New(bus.New(), llm.New(), PolicyDefault).Run(ctx, st, resultCh, correctionCh, nil)
+
+	if o.Status != "failed" || o.FailureReason == nil || *o.FailureReason != needReplanReason("personal or project file?") {
+		t.Fatalf("got %s (%v), want failed with the executor's reason", o.Status, o.FailureReason)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("expected no scoring call, got %d", n)
+	}
+	if len(correctionCh) != 0 {
+		t.Error("expected no correction to be sent")
+	}
+}
+
+// ── prior verdicts from memory ───────────────────────────────────────────────
+
+// newVerdictStore returns a running memory store holding one prior verdict on
+// criterion, waiting until the async write is queryable.
+func newVerdictStore(t *testing.T, criterion, content string) *memory.Store {
+	t.Helper()
+	s := memory.New(bus.New(), t.TempDir(), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Run(ctx)
+	space, entity := memory.CriterionTags(criterion)
+	s.Write(types.Megram{ID: uuid.New().String(), Level: "M", CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Space: space, Entity: entity, Content: content, State: "accept", F: 0.9, Sigma: 1, K: 0.05})
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if recent, _ := s.QueryRecent(ctx, space, entity, 1); len(recent) > 0 {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("prior verdict was not persisted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// scoringPrompt runs one scoring call for st with a matched verdict and returns the user prompt sent.
+func scoringPrompt(t *testing.T, a *AgentValidator, st types.SubTask) string {
+	t.Helper()
+	var prompt string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct{ Content string } `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		prompt = req.Messages[1].Content
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(`{"verdict":"matched","score":1.0,"criteria_results":[],"unmet_criteria":[]}`)))
+	}))
+	defer ts.Close()
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+	a.llm = llm.New()
+	if _, err := a.score(t.Context(), st, types.ExecutionResult{SubTaskID: st.SubTaskID, Status: "completed", Output: "/tmp/a.txt"}, nil, st.SuccessCriteria, nil); err != nil {
+		t.Fatal(err)
+	}
+	return prompt
+}
+
+func TestScore_InjectsPriorVerdictForMatchingCriterion(t *testing.T) {
+	// Lists recalled verdicts on criteria worded like the pending ones
+	prior := `fail (logical): "Output contains a valid file path." — evidence: printed a directory, not a file`
+	mem := newVerdictStore(t, "Output contains a valid file path.", prior)
+	a := NewWithMemory(nil, nil, PolicyDefault, mem)
+
+	matching := scoringPrompt(t, a, types.SubTask{SubTaskID: "s1", Intent: "find the config",
+		SuccessCriteria: []string{"output contains a valid file path"}})
+	if !strings.Contains(matching, "Prior verdicts on similar criteria") || !strings.Contains(matching, prior) {
+		t.Errorf("expected the prior verdict in the prompt, got:\n%s", matching)
+	}
+	other := scoringPrompt(t, a, types.SubTask{SubTaskID: "s2", Intent: "check the date",
+		SuccessCriteria: []string{"output names today's date"}})
+	if strings.Contains(other, "Prior verdicts") {
+		t.Errorf("expected no hints for an unrelated criterion, got:\n%s", other)
+	}
+}
+
+func TestScore_NoMemoryNoHints(t *testing.T) {
+	// Returns "" when mem is nil or nothing is recalled
+	a := New(nil, nil, PolicyDefault)
+	got := scoringPrompt(t, a, types.SubTask{SubTaskID: "s1", SuccessCriteria: []string{"output contains a valid file path"}})
+	if strings.Contains(got, "Prior verdicts") {
+		t.Errorf("expected no hints without memory, got:\n%s", got)
+	}
+}
@@ -198,7 +198,9 @@ func (a *Auditor) Run(ctx context.Context) {
 // allowed sender→receiver pairs per message type (enforces "Does NOT" boundaries).
 // v0.7: ReplanRequest now goes R4b→R7 (GGS), PlanDirective goes R7→R2.
 // OutcomeSummary closes the loop on the happy path: R4b→R7 (GGS delivers FinalResult).
-// FinalResult: R7 on accept or abandon; R4b only for the maxReplans safety net.
+// FinalResult: R7 on accept or abandon; R4b only for the maxReplans safety net;
+// User→User when the runtime cancels a task (Ctrl+C, SIGTERM, watchdog).
+// TaskProgress: User→User from the runtime's subtask dispatcher.
 var allowedPaths = map[types.MessageType][]struct {
 	from types.Role
 	to   types.Role
@@ -215,7 +217,9 @@ var allowedPaths = map[types.MessageType][]struct {
 	types.MsgMemoryWrite:      {{types.RoleMetaVal, types.RoleMemory}},
 	types.MsgMemoryRead:       {{types.RolePlanner, types.RoleMemory}},
 	types.MsgMemoryResponse:   {{types.RoleMemory, types.RolePlanner}},
-	types.MsgFinalResult:      {{types.RoleMetaVal, types.RoleUser}, {types.RoleGGS, types.RoleUser}},
+	types.MsgFinalResult:      {{types.RoleMetaVal, types.RoleUser}, {types.RolePlanner, types.RoleUser}, {types.RoleGGS, types.RoleUser}, {types.RoleUser, types.RoleUser}},
+	types.MsgPlanDiff:         {{types.RolePlanner, types.RoleUser}},
+	types.MsgTaskProgress:     {{types.RoleUser, types.RoleUser}},
 }
 
 func (a *Auditor) process(msg types.Message) {
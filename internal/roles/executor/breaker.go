@@ -0,0 +1,108 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DefaultBreakerThreshold is how many consecutive environmentally failed calls
+// of one tool within a task open its circuit when ARTOO_TOOL_BREAKER is unset.
+const DefaultBreakerThreshold = 3
+
+// circuitTag prefixes the synthetic tool result returned when a tool's circuit
+// is open for the task.
+const circuitTag = "[CIRCUIT]"
+
+// ToolBreaker counts consecutive environmentally failed calls (see
+// environmentalFailure) of each tool per task, across all of the task's
+// subtasks. Once a tool reaches the threshold its circuit stays open — runTool
+// refuses it — until Reset is called for the task. One breaker is shared by
+// every subtask the dispatcher runs; all methods are safe for concurrent use
+// and on a nil *ToolBreaker.
+type ToolBreaker struct {
+	threshold int
+
+	mu       sync.Mutex
+	failures map[string]map[string]int // taskID -> tool -> consecutive failures
+}
+
+// NewToolBreaker returns a breaker that opens a tool's circuit after threshold
+// consecutive failures. A threshold of 0 or less never opens one.
+func NewToolBreaker(threshold int) *ToolBreaker {
+	return &ToolBreaker{threshold: threshold, failures: make(map[string]map[string]int)}
+}
+
+// open reports whether tool's circuit is open for taskID, with the failure count.
+func (b *ToolBreaker) open(taskID, tool string) (bool, int) {
+	if b == nil || b.threshold <= 0 || taskID == "" {
+		return false, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.failures[taskID][tool]
+	return n >= b.threshold, n
+}
+
+// record counts one call of tool within taskID: a failure adds to its
+// consecutive count, a success clears it.
+//
+// Expectations:
+//   - A failure increments the tool's count for that task only
+//   - A success resets the tool's count to zero
+//   - Does nothing for an empty taskID or a nil breaker
+func (b *ToolBreaker) record(taskID, tool string, failed bool) {
+	if b == nil || taskID == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		delete(b.failures[taskID], tool)
+		return
+	}
+	if b.failures[taskID] == nil {
+		b.failures[taskID] = make(map[string]int)
+	}
+	b.failures[taskID][tool]++
+}
+
+// Reset forgets every count recorded for taskID, closing its circuits. The
+// dispatcher calls it when the task completes or is aborted.
+func (b *ToolBreaker) Reset(taskID string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failures, taskID)
+}
+
+// SetToolBreaker makes runTool consult and update b for every call made on
+// behalf of a task; nil (the default) disables the breaker. Call before Run.
+func (e *Executor) SetToolBreaker(b *ToolBreaker) {
+	e.breaker = b
+}
+
+// circuitMessage is the tool result returned instead of running a tool whose
+// circuit is open. It names the approach as wrong so R4a and GGS treat the
+// failure as logical and steer the next plan away from the tool.
+func circuitMessage(tool string, failures int) string {
+	return fmt.Sprintf("%s tool %s disabled for this task after %d failures. Calling it again is the wrong approach; use a different tool or report what you have.",
+		circuitTag, tool, failures)
+}
+
+// breakerTaskKey carries the parent task ID to runTool, so the breaker can
+// count calls per task without threading the ID through every call site.
+type breakerTaskKey struct{}
+
+// withBreakerTask returns ctx carrying taskID for the tool breaker.
+func withBreakerTask(ctx context.Context, taskID string) context.Context {
+	return context.WithValue(ctx, breakerTaskKey{}, taskID)
+}
+
+// breakerTask returns the task ID set by withBreakerTask, or "".
+func breakerTask(ctx context.Context) string {
+	id, _ := ctx.Value(breakerTaskKey{}).(string)
+	return id
+}
@@ -0,0 +1,296 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/haricheung/agentic-shell/internal/tools"
+)
+
+// builtinTool adapts one of R3's built-in tools to tools.Tool. The built-ins share
+// the toolCall input struct and apply R3's guards (LAW1, workspace redirects),
+// which is why they live here rather than in package tools.
+type builtinTool struct {
+	name, description, schema string
+	run                       func(ctx context.Context, tc toolCall) (string, error)
+	// contentType is the shape the tool's output normally has; "" sniffs each
+	// output. A paths tool whose output is a notice ("no files matched") is text.
+	contentType string
+}
+
+func (t builtinTool) Name() string        { return t.name }
+func (t builtinTool) Description() string { return t.description }
+func (t builtinTool) Schema() string      { return t.schema }
+
+func (t builtinTool) ContentType(output string) string {
+	if t.contentType == tools.ContentPaths && strings.HasPrefix(strings.TrimSpace(output), "(") {
+		return tools.ContentText
+	}
+	return t.contentType
+}
+
+func (t builtinTool) Run(ctx context.Context, input json.RawMessage) (string, error) {
+	var tc toolCall
+	if err := json.Unmarshal(input, &tc); err != nil {
+		return "", fmt.Errorf("%s: bad input: %w", t.name, err)
+	}
+	return t.run(ctx, tc)
+}
+
+// builtinTools are R3's own tools, in prompt priority order.
+var builtinTools = []builtinTool{
+	{
+		name:        "mdfind",
+		description: "personal file search (Spotlight index, <1 s). Use for ANY file outside the project.",
+		schema:      `{"action":"tool","tool":"mdfind","query":"filename or phrase"}`,
+		run: func(ctx context.Context, tc toolCall) (string, error) {
+			return tools.RunMdfind(ctx, tc.Query)
+		},
+		contentType: tools.ContentPaths,
+	},
+	{
+		name: "glob",
+		description: "project file search (filename pattern, recursive). Use ONLY for files inside the project.\n" +
+			`Pattern matches FILENAME ONLY — no "/" allowed. root:"." = project directory.`,
+		schema: `{"action":"tool","tool":"glob","pattern":"*.json","root":"."}`,
+		run: func(_ context.Context, tc toolCall) (string, error) {
+			root := tc.Root
+			if root == "" {
+				root = "."
+			}
+			matches, err := tools.GlobFiles(root, tc.Pattern)
+			if err != nil {
+				return "", err
+			}
+			if len(matches) == 0 {
+				return "(no files matched pattern " + tc.Pattern + " under " + root + ")", nil
+			}
+			return tools.GlobJoin(matches), nil
+		},
+		contentType: tools.ContentPaths,
+	},
+	{
+		name: "grep",
+		description: "search file CONTENTS under root (regular expression, recursive). Use instead of shell grep pipelines.\n" +
+			`glob limits the files searched by FILENAME (e.g. "*.go"); omit it to search every file. Returns "file:line: text".`,
+		schema: `{"action":"tool","tool":"grep","pattern":"func main","root":".","glob":"*.go"}`,
+		run: func(ctx context.Context, tc toolCall) (string, error) {
+			root := tc.Root
+			if root == "" {
+				root = "."
+			}
+			matches, truncated, err := tools.Grep(ctx, root, tc.Pattern, tc.Glob, tools.MaxGrepMatches)
+			if err != nil {
+				return "", err
+			}
+			if len(matches) == 0 {
+				return "(no lines matched pattern " + tc.Pattern + " under " + root + ")", nil
+			}
+			return tools.GrepJoin(matches, truncated), nil
+		},
+		contentType: tools.ContentText,
+	},
+	{
+		name:        "read_file",
+		description: "read a file, or an http(s) URL (the page is fetched).",
+		schema:      `{"action":"tool","tool":"read_file","path":"..."}`,
+		run: func(ctx context.Context, tc toolCall) (string, error) {
+			return tools.ReadFile(ctx, tc.Path)
+		},
+	},
+	{
+		name: "write_file",
+		description: "write a file. Output files (scripts, reports, generated content) MUST use ~/artoo_workspace/ as the base.\n" +
+			`Project source files may use their normal relative paths (e.g. "internal/foo/bar.go").`,
+		schema: `{"action":"tool","tool":"write_file","path":"~/artoo_workspace/report.md","content":"..."}`,
+		run: func(_ context.Context, tc toolCall) (string, error) {
+			writePath := writeFilePath(tc.Path)
+			if writePath != tools.ExpandHome(tc.Path) {
+				slog.Debug("[R3] write_file redirected to workspace", "from", tc.Path, "to", writePath)
+			}
+			// A replan re-running a subtask often writes what is already there;
+			// identical bytes are a no-op, not an overwrite for LAW1 to block.
+			if tools.SameContent(writePath, tc.Content) {
+				slog.Debug("[R3] write_file skipped: identical content already present", "path", writePath)
+				return "ok", nil
+			}
+			if irreversible, reason := isIrreversibleWriteFile(writePath); irreversible {
+				return fmt.Sprintf("[LAW1] %s — write blocked. Re-issue the task with explicit permission to overwrite.", reason), nil
+			}
+			return "ok", tools.WriteFile(writePath, tc.Content)
+		},
+	},
+	{
+		name: "applescript",
+		description: "control macOS/Apple apps (Mail, Calendar, Reminders, Messages, Music, Focus).\n" +
+			"Calendar/Reminders sync to iPhone/iPad/Watch via iCloud automatically.",
+		schema: `{"action":"tool","tool":"applescript","script":"tell application \"Reminders\" to ..."}`,
+		run: func(ctx context.Context, tc toolCall) (string, error) {
+			result, err := tools.RunAppleScript(ctx, tc.Script)
+			if err != nil {
+				return fmt.Sprintf("applescript error: %v", err), nil
+			}
+			return result, nil
+		},
+	},
+	{
+		name: "message",
+		description: "send an email (Mail) or iMessage. ALWAYS use this, never applescript, to send messages.\n" +
+			"Two steps: a call without \"token\" only creates a draft and returns its token; nothing is sent.\n" +
+			"Check the draft's recipients against the task, then call again with just the token to send it;\n" +
+			"the user is asked to approve every send, and a declined send must not be retried.",
+		schema: `{"action":"tool","tool":"message","channel":"email","to":"bob@example.com","subject":"...","content":"..."}`,
+		run: func(ctx context.Context, tc toolCall) (string, error) {
+			return runMessageTool(ctx, tools.DefaultMessenger, tc), nil
+		},
+		contentType: tools.ContentText,
+	},
+	{
+		name:        "shortcuts",
+		description: "run a named Apple Shortcut (iCloud-synced, can trigger iPhone/Watch automations).",
+		schema:      `{"action":"tool","tool":"shortcuts","name":"My Shortcut","input":""}`,
+		run: func(ctx context.Context, tc toolCall) (string, error) {
+			result, err := tools.RunShortcut(ctx, tc.Name, tc.Input)
+			if err != nil {
+				return fmt.Sprintf("shortcuts error: %v", err), nil
+			}
+			return result, nil
+		},
+	},
+	{
+		name: "shell",
+		description: "bash command for everything else (counting, aggregation, system info, file ops).\n" +
+			`NEVER use "find" to locate personal files — use mdfind instead. To search inside files use grep.` + "\n" +
+			"Never include ~/Music/Music or ~/Library in shell paths.",
+		schema:      `{"action":"tool","tool":"shell","command":"..."}`,
+		run:         runShellTool,
+		contentType: tools.ContentText, // stdout/stderr framing is always prose
+	},
+	{
+		name:        "search",
+		description: "web search (DuckDuckGo by default; Serper.dev when SERPER_API_KEY is set).",
+		schema:      `{"action":"tool","tool":"search","query":"..."}`,
+		run: func(ctx context.Context, tc toolCall) (string, error) {
+			return tools.Search(ctx, tc.Query)
+		},
+		contentType: tools.ContentText,
+	},
+}
+
+// fetchURLTool reads a web page's text. It is not in builtinTools: it reaches
+// the network on the model's say-so, so main registers it only when enabled
+// (ARTOO_FETCH_URL). Output is cut with headTail like every tool result.
+var fetchURLTool = builtinTool{
+	name: "fetch_url",
+	description: "read the text of a web page (scripts, styles, and markup removed). Use instead of shell curl for web pages.\n" +
+		"Public http(s) URLs only; use search first when you do not know the URL.",
+	schema: `{"action":"tool","tool":"fetch_url","url":"https://..."}`,
+	run: func(ctx context.Context, tc toolCall) (string, error) {
+		return tools.FetchPage(ctx, tc.URL)
+	},
+	contentType: tools.ContentText,
+}
+
+// writeFilePath is where write_file puts path. "~/" is expanded before path
+// analysis so workspace-rooted paths are not misclassified, and bare filenames
+// and "./" paths are redirected to the workspace so generated files (scripts,
+// reports, data) never land in the project root or CWD.
+func writeFilePath(path string) string {
+	writePath := tools.ExpandHome(path)
+	if resolved, redirected := tools.ResolveOutputPath(writePath); redirected {
+		return resolved
+	}
+	return writePath
+}
+
+// noNetworkNote follows a failed shell result under --no-network, so R3 stops
+// retrying network access and R4a files the failure as environmental.
+const noNetworkNote = tools.NoNetworkTag + " shell runs without network access in this session (--no-network). " +
+	"If this command needed the network, the failure is environmental: do not retry it through shell."
+
+// environmentNote ends a shell result that failed because of the environment —
+// a timeout or a sandbox that could not start — rather than the command's own
+// exit status. runTool counts only such shell failures against the tool breaker.
+const environmentNote = "[ENVIRONMENT] the command did not complete because of the environment (timeout or sandbox failure), not because of what it does."
+
+// runShellTool runs a shell call under the subtask environment carried by ctx,
+// after the LAW1 guard and the personal-find redirect. A failed call made
+// without network access carries noNetworkNote; one stopped by a timeout or a
+// sandbox failure ends with environmentNote.
+func runShellTool(ctx context.Context, tc toolCall) (string, error) {
+	if irreversible, reason := isIrreversibleShell(tc.Command); irreversible {
+		return fmt.Sprintf("[LAW1] %s — command blocked: %q. Re-issue the task with explicit permission to proceed.", reason, tc.Command), nil
+	}
+	// Intercept personal-file find commands and redirect to mdfind.
+	// The model occasionally ignores the prompt priority and emits
+	// `find /Users/... -name <pattern>` which is extremely slow (~6 min).
+	if query, ok := redirectPersonalFind(tc.Command); ok && preflight("mdfind") == "" {
+		slog.Debug("[R3] redirecting personal find to mdfind", "query", query, "original", firstN(tc.Command, 80))
+		return tools.RunMdfind(ctx, query)
+	}
+	cmd := normalizeFindCmd(tc.Command)
+	if cmd != tc.Command {
+		slog.Debug("[R3] normalized find cmd", "from", tc.Command, "to", cmd)
+	}
+	env := tools.ShellEnvFrom(ctx)
+	stdout, stderr, err := tools.RunShellEnv(ctx, cmd, env)
+	if err != nil {
+		out := fmt.Sprintf("stdout: %s\nstderr: %s\nerror: %v", stdout, stderr, err)
+		if env.NoNetwork {
+			out += "\n" + noNetworkNote
+		}
+		if errors.Is(err, tools.ErrShellTimeout) || errors.Is(err, tools.ErrSandbox) {
+			out += "\n" + environmentNote
+		}
+		return out, nil
+	}
+	return fmt.Sprintf("stdout: %s\nstderr: %s", stdout, stderr), nil
+}
+
+// runMessageTool drafts tc's message, or sends the draft named by tc.Token.
+//
+// Expectations:
+//   - Without a token, stores a draft and returns it with its token; nothing is sent
+//   - With a token, sends only that stored draft; draft fields in tc are ignored
+//   - Reports validation and send failures as tool output, not as errors
+func runMessageTool(ctx context.Context, m *tools.Messenger, tc toolCall) string {
+	if tc.Token != "" {
+		d, err := m.Send(ctx, tc.Token)
+		if err != nil {
+			return fmt.Sprintf("message error: %v", err)
+		}
+		return "sent " + d.Summary()
+	}
+	d, err := m.Draft(tc.Channel, tc.To, tc.Subject, tc.Content)
+	if err != nil {
+		return fmt.Sprintf("message error: %v", err)
+	}
+	return fmt.Sprintf("DRAFT (not sent): %s\n\n%s\n\nTo send it, call message with {\"token\":%q}.", d.Summary(), d.Body, d.Token)
+}
+
+// RegisterBuiltins adds R3's built-in tools to r, in prompt priority order.
+// The Default registry gets them at init; call this to seed a custom registry.
+func RegisterBuiltins(r *tools.Registry) error {
+	for _, t := range builtinTools {
+		if err := r.Register(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterFetchURL adds the fetch_url tool to r, after any tools already there,
+// so it is offered to the model and can be dispatched.
+func RegisterFetchURL(r *tools.Registry) error {
+	return r.Register(fetchURLTool)
+}
+
+func init() {
+	if err := RegisterBuiltins(tools.Default); err != nil {
+		panic(err)
+	}
+}
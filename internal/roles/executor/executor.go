@@ -2,17 +2,27 @@ package executor
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 	"github.com/haricheung/agentic-shell/internal/bus"
 	"github.com/haricheung/agentic-shell/internal/llm"
+	"github.com/haricheung/agentic-shell/internal/roles/memory"
 	"github.com/haricheung/agentic-shell/internal/tasklog"
 	"github.com/haricheung/agentic-shell/internal/tools"
 	"github.com/haricheung/agentic-shell/internal/types"
@@ -20,33 +30,13 @@ import (
 
 const systemPromptBase = `You are R3 — Executor. Execute exactly one assigned sub-task and return a concrete, verifiable result.
 
-Tool selection — use the FIRST tool that fits; do not skip down the list:
-1. mdfind  — personal file search (Spotlight index, <1 s). Use for ANY file outside the project.
-   Input: {"action":"tool","tool":"mdfind","query":"filename or phrase"}
-2. glob    — project file search (filename pattern, recursive). Use ONLY for files inside the project.
-   Input: {"action":"tool","tool":"glob","pattern":"*.json","root":"."}
-   Pattern matches FILENAME ONLY — no "/" allowed. root:"." = project directory.
-3. read_file  — read a file. Input: {"action":"tool","tool":"read_file","path":"..."}
-4. write_file — write a file. Output files (scripts, reports, generated content) MUST use ~/artoo_workspace/ as the base. Example: {"action":"tool","tool":"write_file","path":"~/artoo_workspace/report.md","content":"..."}
-   Project source files may use their normal relative paths (e.g. "internal/foo/bar.go").
-5. applescript — control macOS/Apple apps (Mail, Calendar, Reminders, Messages, Music, Focus).
-   Input: {"action":"tool","tool":"applescript","script":"tell application \"Reminders\" to ..."}
-   Calendar/Reminders sync to iPhone/iPad/Watch via iCloud automatically.
-6. shortcuts — run a named Apple Shortcut (iCloud-synced, can trigger iPhone/Watch automations).
-   Input: {"action":"tool","tool":"shortcuts","name":"My Shortcut","input":""}
-7. shell — bash command for everything else (counting, aggregation, system info, file ops).
-   Input: {"action":"tool","tool":"shell","command":"..."}
-   NEVER use "find" to locate personal files — use mdfind (tool #1) instead.
-   Never include ~/Music/Music or ~/Library in shell paths.`
-
-// searchToolEntry is always included — DuckDuckGo requires no API key.
-// Serper.dev is used automatically when SERPER_API_KEY is set.
-const searchToolEntry = `8. search — web search (DuckDuckGo by default; Serper.dev when SERPER_API_KEY is set). Input: {"action":"tool","tool":"search","query":"..."}`
+Tool selection — use the FIRST tool that fits; do not skip down the list:`
 
 const systemPromptExec = `
 Execution rules:
 - Read intent, success_criteria, and context before acting. Context may contain prior-step outputs — use them directly.
 - One tool call per response; wait for the REAL tool result before proceeding.
+- Exception: independent read-only lookups (%s) may be batched — up to 5 calls in one response — when none needs another's result.
 - NEVER generate fake tool output or pretend a tool ran — only output a tool call JSON OR a final result JSON, never both in the same response.
 - When tool output satisfies ALL success_criteria, output the final result immediately.
 - status "completed": tool ran and output clearly answers the task.
@@ -59,16 +49,72 @@ Output format — raw JSON only, nothing before or after:
 To call a tool:
 {"action":"tool","tool":"<name>","<param>":"<value>",...}
 
+To call several independent read-only tools at once:
+{"action":"tools","calls":[{"tool":"read_file","path":"..."},{"tool":"read_file","path":"..."}]}
+
 To report the final result:
-{"action":"result","subtask_id":"...","status":"completed|uncertain|failed","output":"<result text>","uncertainty":null,"tool_calls":["<tool: input → output summary>",...]}`
+{"action":"result","subtask_id":"...","status":"completed|uncertain|failed","output":"<result text>","uncertainty":null,"tool_calls":["<tool: input → output summary>",...]}
+
+When you genuinely cannot tell which tool or approach fits the subtask as written (e.g. it is
+unclear whether a file is personal or part of the project) and guessing would likely fail,
+ask for a new plan instead — say what is ambiguous and what the plan should settle:
+{"action":"need_replan","reason":"<what is ambiguous>"}`
+
+// buildSystemPrompt renders R3's system prompt with one numbered entry per tool
+// in reg, in registration order adjusted by prefs (see orderByPreference).
+//
+// Expectations:
+//   - Lists every registered tool as "N. name — summary" followed by its input example
+//   - Indents description lines after the first as notes under the entry
+//   - Adds a memory note under each tool with an Exploit or Avoid preference
+//   - Ends with the execution rules and output format, naming the registered batch tools
+func buildSystemPrompt(reg *tools.Registry, prefs map[string]types.Potentials) string {
+	return systemPromptBase + toolListPrompt(reg, prefs) + fmt.Sprintf(systemPromptExec, batchToolList(reg))
+}
+
+// batchToolList names the tools in reg that a batch may call, in registration
+// order, so the prompt never offers an opt-in tool that is not registered.
+func batchToolList(reg *tools.Registry) string {
+	var names []string
+	for _, t := range reg.Tools() {
+		if batchTools[t.Name()] {
+			names = append(names, t.Name())
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// systemPrompt is the prompt of R3's main tool-calling turns. An
+// ARTOO_EXECUTOR_SYSTEM_PROMPT_FILE override replaces the fixed text around the
+// tool list; the live tool list is still appended to it.
+//
+// Expectations:
+//   - Returns buildSystemPrompt's prompt when no override is set
+//   - Appends the live tool list to an override
+func (e *Executor) systemPrompt(prefs map[string]types.Potentials) string {
+	if custom := e.llm.SystemPrompt(""); custom != "" {
+		return custom + "\n\nTools:" + toolListPrompt(e.tools(), prefs)
+	}
+	return buildSystemPrompt(e.tools(), prefs)
+}
 
-func buildSystemPrompt() string {
+// toolListPrompt renders reg's tools as the numbered list of R3's prompt, in
+// preference order, each with its input schema and any memory hint.
+func toolListPrompt(reg *tools.Registry, prefs map[string]types.Potentials) string {
 	var b strings.Builder
-	b.WriteString(systemPromptBase)
-	if tools.SearchAvailable() {
-		b.WriteString("\n" + searchToolEntry)
+	for i, t := range orderByPreference(reg.Tools(), prefs) {
+		lines := strings.Split(strings.TrimSpace(t.Description()), "\n")
+		fmt.Fprintf(&b, "\n%d. %s — %s\n   Input: %s", i+1, t.Name(), lines[0], t.Schema())
+		for _, l := range lines[1:] {
+			b.WriteString("\n   " + l)
+		}
+		switch prefs[t.Name()].Action {
+		case "Exploit":
+			fmt.Fprintf(&b, "\n   Memory: %s has worked well for this kind of task — prefer it.", t.Name())
+		case "Avoid":
+			fmt.Fprintf(&b, "\n   Memory: %s has failed for this kind of task — use it only if nothing else fits.", t.Name())
+		}
 	}
-	b.WriteString(systemPromptExec)
 	return b.String()
 }
 
@@ -85,17 +131,185 @@ To call a tool:
 {"action":"tool","tool":"<name>","<param>":"<value>",...}
 
 To report the final result:
-{"action":"result","subtask_id":"...","status":"completed|uncertain|failed","output":"...","uncertainty":null,"tool_calls":["..."]}`
+{"action":"result","subtask_id":"...","status":"completed|uncertain|failed","output":"...","uncertainty":null,"tool_calls":["..."]}
+
+If you cannot tell how to proceed without guessing, ask for a new plan:
+{"action":"need_replan","reason":"<what is ambiguous>"}`
 
 // Executor is R3. It executes sub-tasks using available tools.
 type Executor struct {
-	llm *llm.Client
-	b   *bus.Bus
+	llm         *llm.Client
+	b           *bus.Bus
+	evidenceLen int                 // max chars of tool output appended to each tool_calls entry
+	registry    *tools.Registry     // tools offered to the model; nil means tools.Default
+	limits      *tools.Limits       // per-tool concurrency caps; nil means tools.DefaultLimits
+	mem         types.MemoryService // R5 — read-only; tool preferences per intent; may be nil
+	// maxPromptTokens bounds each prompt's estimated size; the oldest tool results
+	// are dropped first. 0 means no bound (see llm.MaxPromptTokens).
+	maxPromptTokens int
+	// confirmTools names the tools whose every call is put to confirm first;
+	// empty unless built with NewWithConfirm.
+	confirmTools map[string]bool
+	confirm      ConfirmFunc
+	// shellAllow restricts shell to commands starting with one of these word
+	// prefixes (ARTOO_SHELL_ALLOW); empty allows every command.
+	shellAllow [][]string
+	// verifyWrites re-reads each written file and reports its path and size in
+	// the write_file result (ARTOO_VERIFY_WRITES, on by default).
+	verifyWrites bool
+	// noNetwork runs every shell call without network access (--no-network).
+	noNetwork bool
+	// breaker short-circuits a tool that keeps failing within one task; nil
+	// disables it (see SetToolBreaker).
+	breaker *ToolBreaker
+	// ToolTimeouts bounds each call of a tool, so one hung call cannot spend the
+	// task's whole budget; tools not listed, or listed with 0, are bounded only by
+	// the subtask's context. New sets DefaultToolTimeouts; change it before Run.
+	ToolTimeouts map[string]time.Duration
+}
+
+// ConfirmFunc asks the user whether one tool call may run. detail is the call's
+// main input (the shell command, the file path, the script). Returning false
+// skips the call; the model is told it was declined.
+type ConfirmFunc func(ctx context.Context, tool, detail string) bool
+
+// New creates an Executor over the tools.Default registry. mem may be nil to
+// disable memory-based tool preferences. The tool-call evidence length comes
+// from ARTOO_EVIDENCE_LEN (see evidenceLenFromEnv) and the prompt budget from
+// ARTOO_EXECUTOR_MAX_PROMPT_TOKENS or ARTOO_MAX_PROMPT_TOKENS.
+func New(b *bus.Bus, llmClient *llm.Client, mem types.MemoryService) *Executor {
+	return NewWithRegistry(b, llmClient, mem, tools.Default)
+}
+
+// NewWithRegistry creates an Executor that offers and dispatches the tools in reg.
+// Seed reg with RegisterBuiltins to keep the built-in tools.
+func NewWithRegistry(b *bus.Bus, llmClient *llm.Client, mem types.MemoryService, reg *tools.Registry) *Executor {
+	return &Executor{
+		llm:             llmClient.ForRole("executor"),
+		b:               b,
+		evidenceLen:     evidenceLenFromEnv(),
+		registry:        reg,
+		mem:             mem,
+		maxPromptTokens: llm.MaxPromptTokens("executor"),
+		shellAllow:      ParseShellAllowList(os.Getenv("ARTOO_SHELL_ALLOW")),
+		verifyWrites:    verifyWritesFromEnv(),
+		ToolTimeouts:    DefaultToolTimeouts(),
+	}
+}
+
+// DefaultToolTimeouts returns the per-call ceilings an Executor starts with.
+// ARTOO_TOOL_TIMEOUTS overrides them per tool (see ParseToolTimeouts).
+func DefaultToolTimeouts() map[string]time.Duration {
+	return map[string]time.Duration{
+		"shell":       30 * time.Second,
+		"search":      15 * time.Second,
+		"fetch_url":   30 * time.Second,
+		"read_file":   30 * time.Second,
+		"applescript": 20 * time.Second,
+		"shortcuts":   30 * time.Second,
+		"message":     30 * time.Second,
+		"mdfind":      15 * time.Second,
+		"glob":        30 * time.Second,
+		"grep":        30 * time.Second,
+	}
+}
+
+// ParseToolTimeouts parses an ARTOO_TOOL_TIMEOUTS value: comma-separated
+// tool=duration pairs such as "shell=2m,applescript=10s". A duration of 0
+// removes the tool's ceiling.
+//
+// Expectations:
+//   - Returns an empty map for an empty or blank value
+//   - Trims space around tools and durations
+//   - Returns an error for a pair without "=", an empty tool name, or a duration that is not a non-negative Go duration
+func ParseToolTimeouts(s string) (map[string]time.Duration, error) {
+	out := make(map[string]time.Duration)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		tool, val, ok := strings.Cut(pair, "=")
+		tool, val = strings.TrimSpace(tool), strings.TrimSpace(val)
+		if !ok || tool == "" {
+			return nil, fmt.Errorf("tool timeouts: %q is not tool=duration", strings.TrimSpace(pair))
+		}
+		d, err := time.ParseDuration(val)
+		if val == "0" {
+			d, err = 0, nil
+		}
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("tool timeouts: %q: %q is not a non-negative duration such as 30s", tool, val)
+		}
+		out[tool] = d
+	}
+	return out, nil
+}
+
+// SetToolLimits makes every tool call wait for a slot in l, shared with any other
+// executor given the same l; nil (the default) uses tools.DefaultLimits. Call before Run.
+func (e *Executor) SetToolLimits(l *tools.Limits) {
+	e.limits = l
+}
+
+// SetNoNetwork makes every shell call (and so any python or curl it starts) run
+// without network access; see tools.NoNetworkSupported. Call before Run.
+func (e *Executor) SetNoNetwork(on bool) {
+	e.noNetwork = on
+}
+
+// NewWithConfirm creates an Executor over reg that calls confirm before each
+// invocation of a tool named in toolNames, and before every message send; other
+// tools run without asking. See ParseConfirmTools for turning --confirm into
+// toolNames, checked against the same reg. Without a confirm (here or with New),
+// message sends are refused.
+func NewWithConfirm(b *bus.Bus, llmClient *llm.Client, mem types.MemoryService, reg *tools.Registry, toolNames []string, confirm ConfirmFunc) *Executor {
+	e := NewWithRegistry(b, llmClient, mem, reg)
+	e.confirm = confirm
+	if confirm != nil && len(toolNames) > 0 {
+		e.confirmTools = make(map[string]bool, len(toolNames))
+		for _, name := range toolNames {
+			e.confirmTools[name] = true
+		}
+	}
+	return e
+}
+
+// ParseConfirmTools splits a comma-separated list of tool names (the --confirm
+// flag) and checks each against reg.
+//
+// Expectations:
+//   - Returns nil for an empty or blank list
+//   - Trims spaces and skips empty items ("shell, write_file," → [shell write_file])
+//   - Returns an error naming any tool that is not registered in reg
+func ParseConfirmTools(list string, reg *tools.Registry) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := reg.Lookup(name); !ok {
+			return nil, fmt.Errorf("confirm: unknown tool %q", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// tools returns the registry this executor dispatches to.
+func (e *Executor) tools() *tools.Registry {
+	if e.registry == nil {
+		return tools.Default
+	}
+	return e.registry
 }
 
-// New creates an Executor.
-func New(b *bus.Bus, llmClient *llm.Client) *Executor {
-	return &Executor{llm: llmClient, b: b}
+// toolLimits returns the concurrency limits this executor's tool calls share.
+func (e *Executor) toolLimits() *tools.Limits {
+	if e.limits == nil {
+		return tools.DefaultLimits
+	}
+	return e.limits
 }
 
 // Run starts the executor goroutine listening for SubTask messages.
@@ -103,6 +317,7 @@ func New(b *bus.Bus, llmClient *llm.Client) *Executor {
 // This Run method handles a single SubTask channel for a dedicated goroutine.
 // tlog may be nil — all TaskLog methods are nil-safe.
 func (e *Executor) RunSubTask(ctx context.Context, subTask types.SubTask, correctionCh <-chan types.CorrectionSignal, tlog *tasklog.TaskLog) {
+	ctx = withBreakerTask(ctx, subTask.ParentTaskID)
 	tlog.SubtaskBegin(subTask.SubTaskID, subTask.Intent, subTask.Sequence, subTask.SuccessCriteria)
 
 	var allToolCalls []string // accumulated across all attempts for correction context
@@ -121,6 +336,7 @@ func (e *Executor) RunSubTask(ctx context.Context, subTask types.SubTask, correc
 			Status:      "failed",
 			Output:      reason,
 			Uncertainty: &reason,
+			Artifacts:   result.Artifacts,
 		}
 	}
 	if ctx.Err() != nil {
@@ -128,6 +344,7 @@ func (e *Executor) RunSubTask(ctx context.Context, subTask types.SubTask, correc
 		return
 	}
 
+	result.EvidenceHash = result.HashEvidence()
 	e.b.Publish(types.Message{
 		ID:        uuid.New().String(),
 		Timestamp: time.Now().UTC(),
@@ -162,12 +379,14 @@ func (e *Executor) RunSubTask(ctx context.Context, subTask types.SubTask, correc
 					Status:      "failed",
 					Output:      reason,
 					Uncertainty: &reason,
+					Artifacts:   result.Artifacts,
 				}
 			}
 			if ctx.Err() != nil {
 				slog.Debug("[R3] context done after correction, skipping publish", "subtask", subTask.SubTaskID)
 				return
 			}
+			result.EvidenceHash = result.HashEvidence()
 			e.b.Publish(types.Message{
 				ID:        uuid.New().String(),
 				Timestamp: time.Now().UTC(),
@@ -190,11 +409,34 @@ type toolCall struct {
 	Query   string `json:"query,omitempty"`
 	Pattern string `json:"pattern,omitempty"`
 	Root    string `json:"root,omitempty"`
+	Glob    string `json:"glob,omitempty"`    // grep: filename filter
 	Script  string `json:"script,omitempty"`  // applescript
 	Name    string `json:"name,omitempty"`    // shortcuts
 	Input   string `json:"input,omitempty"`   // shortcuts
+	Channel string `json:"channel,omitempty"` // message: "email" (default) or "imessage"
+	To      string `json:"to,omitempty"`      // message: comma-separated recipients
+	Subject string `json:"subject,omitempty"` // message: email subject
+	Token   string `json:"token,omitempty"`   // message: draft token that confirms the send
+	URL     string `json:"url,omitempty"`     // fetch_url
+
+	raw json.RawMessage // the model's full call object, passed to Tool.Run
+}
+
+// toolBatch is the model's {"action":"tools"} response: several independent
+// read-only calls to run concurrently in one turn.
+type toolBatch struct {
+	Action string            `json:"action"`
+	Calls  []json.RawMessage `json:"calls"`
 }
 
+// batchTools are the tools a batch may call: read-only lookups that cannot
+// interfere with each other. write_file, shell, applescript, shortcuts, and
+// custom tools may change state, so they are always called one at a time.
+var batchTools = map[string]bool{"mdfind": true, "glob": true, "grep": true, "read_file": true, "search": true, "fetch_url": true}
+
+// maxBatchCalls bounds the calls in one batch.
+const maxBatchCalls = 5
+
 type finalResult struct {
 	Action      string   `json:"action"`
 	SubTaskID   string   `json:"subtask_id"`
@@ -204,6 +446,30 @@ type finalResult struct {
 	ToolCalls   []string `json:"tool_calls"`
 }
 
+// needReplanResult is the failed ExecutionResult for the model's
+// {"action":"need_replan"} response: no further tool calls are made, and the
+// reason travels to R4a in NeedReplan.
+//
+// Expectations:
+//   - Returns Status "failed" with NeedReplan, Output and Uncertainty carrying the trimmed reason
+//   - Falls back to a generic reason when the model gave none
+//   - Keeps the tool calls and artifacts of the attempt so far
+func needReplanResult(subTaskID, reason string, toolCalls, artifacts []string) types.ExecutionResult {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		reason = "unsure how to carry out the subtask as specified"
+	}
+	return types.ExecutionResult{
+		SubTaskID:   subTaskID,
+		Status:      "failed",
+		Output:      "need_replan: " + reason,
+		Uncertainty: &reason,
+		ToolCalls:   toolCalls,
+		Artifacts:   artifacts,
+		NeedReplan:  reason,
+	}
+}
+
 func (e *Executor) execute(ctx context.Context, st types.SubTask, correction *types.CorrectionSignal, priorToolCalls []string, tlog *tasklog.TaskLog) (types.ExecutionResult, []string, error) {
 	wd, _ := os.Getwd()
 
@@ -226,26 +492,126 @@ func (e *Executor) execute(ctx context.Context, st types.SubTask, correction *ty
 	} else {
 		userPrompt = "Current working directory: " + wd + "\n\nExecute this SubTask:\n" + subTaskToJSON(st)
 	}
+	if g := llm.LanguageGuidance(st.Language); g != "" {
+		userPrompt += "\n\n" + g
+	}
+
+	prefs := e.toolPreferences(ctx, st)
 
 	var toolCallHistory []string
-	var toolResultsCtx strings.Builder
+	// toolResults holds one entry per tool result or notice, oldest first, so the
+	// oldest can be dropped when the prompt budget is tight. Each turn sees them
+	// through foldObservations; synthesis sees them raw.
+	var toolResults []string
+	// artifacts are the absolute paths write_file wrote, in first-write order.
+	var artifacts []string
 	consecutiveDuplicates := 0
+	// lastKey is the callKey of the most recent call that ran; history entries are
+	// truncated and carry results, so they cannot be compared directly.
+	lastKey := ""
+	// ranKeys holds the callKey of every call that ran, so a reformulated search
+	// never repeats an earlier one.
+	ranKeys := map[string]bool{}
+	// loopKill ends the subtask once the model has repeated itself twice in a row.
+	loopKill := func(tool string) types.ExecutionResult {
+		slog.Warn("[R3] hard loop kill: 2 consecutive duplicates, failing subtask", "tool", tool)
+		return types.ExecutionResult{
+			SubTaskID: st.SubTaskID,
+			Status:    "failed",
+			Output:    fmt.Sprintf("executor loop: [%s] called with identical parameters %d times consecutively; no progress possible", tool, consecutiveDuplicates+1),
+			ToolCalls: toolCallHistory,
+			Artifacts: artifacts,
+		}
+	}
+	// repick is set once per execution when preflight rejects a tool, so the next
+	// turn asks for an available tool instead of pushing for a final result.
+	repick, repicked := false, false
+	// reformulated is set once a weak search has been retried with a rewritten query.
+	reformulated := false
+	// runCall runs one tool call that passed the duplicate guard and records it:
+	// lastKey, the tool-call history, the tool result, the task log, and artifacts.
+	runCall := func(tc toolCall, iter int) (string, error) {
+		lastKey = callKey(tc)
+		ranKeys[lastKey] = true
+		toolCallHistory = append(toolCallHistory, callSignature(tc))
+
+		// Log tool invocation with the most relevant param per tool type.
+		switch tc.Tool {
+		case "shell":
+			slog.Info("[R3] tool call", "iter", iter, "tool", "shell", "cmd", firstN(tc.Command, 120))
+		case "mdfind":
+			slog.Info("[R3] tool call", "iter", iter, "tool", "mdfind", "query", tc.Query)
+		case "glob":
+			slog.Info("[R3] tool call", "iter", iter, "tool", "glob", "pattern", tc.Pattern, "root", tc.Root)
+		case "grep":
+			slog.Info("[R3] tool call", "iter", iter, "tool", "grep", "pattern", tc.Pattern, "root", tc.Root, "glob", tc.Glob)
+		case "read_file":
+			slog.Info("[R3] tool call", "iter", iter, "tool", "read_file", "path", tc.Path)
+		case "write_file":
+			slog.Info("[R3] tool call", "iter", iter, "tool", "write_file", "path", tc.Path, "bytes", len(tc.Content))
+		case "applescript":
+			slog.Info("[R3] tool call", "iter", iter, "tool", "applescript", "script", firstN(tc.Script, 100))
+		case "shortcuts":
+			slog.Info("[R3] tool call", "iter", iter, "tool", "shortcuts", "name", tc.Name)
+		case "search":
+			slog.Info("[R3] tool call", "iter", iter, "tool", "search", "query", tc.Query)
+		case "fetch_url":
+			slog.Info("[R3] tool call", "iter", iter, "tool", "fetch_url", "url", tc.URL)
+		default:
+			slog.Info("[R3] tool call", "iter", iter, "tool", tc.Tool)
+		}
+
+		tcInputJSON := tc.input()
+		toolStart := time.Now()
+		result, contentType, err := e.runTool(ctx, tc, shellEnv(st))
+		if err == nil && tc.Tool == "write_file" && result == "ok" && e.verifyWrites {
+			result, err = verifiedWrite(tc)
+		}
+		toolElapsedMs := time.Since(toolStart).Milliseconds()
+		if err != nil {
+			toolResults = append(toolResults, fmt.Sprintf("Tool %s ERROR: %v\n", tc.Tool, err))
+			slog.Warn("[R3] tool error", "iter", iter, "tool", tc.Tool, "error", err)
+			// Append error evidence to tool_calls so R4a can verify
+			toolCallHistory[len(toolCallHistory)-1] += " → ERROR: " + firstN(err.Error(), 80)
+			tlog.ToolCall(st.SubTaskID, tc.Tool, string(tcInputJSON), "", "", err.Error(), toolElapsedMs)
+		} else {
+			toolResults = append(toolResults, fmt.Sprintf("Tool %s result%s:\n%s\n", tc.Tool, contentTag(contentType), headTail(result, 4000)))
+			slog.Debug("[R3] tool result", "iter", iter, "tool", tc.Tool, "output", firstN(strings.TrimSpace(result), 500))
+			// Append leading content to tool_calls so R4a sees concrete evidence.
+			// toolEvidence keeps the head (nearly all tool outputs put the relevant
+			// content first; lastN was wrong for search results), condensed per tool.
+			toolCallHistory[len(toolCallHistory)-1] += " → " + taggedEvidence(contentType, toolEvidence(tc.Tool, result, e.evidenceLen))
+			tlog.ToolCall(st.SubTaskID, tc.Tool, string(tcInputJSON), firstN(strings.TrimSpace(result), 500), contentType, "", toolElapsedMs)
+			if tc.Tool == "write_file" && isWriteOK(result) {
+				artifacts = addArtifact(artifacts, writeFilePath(tc.Path))
+			}
+		}
+		return result, err
+	}
 
 	const maxToolCalls = 10
 	for i := 0; i < maxToolCalls; i++ {
+		sysPrompt := e.systemPrompt(prefs)
 		prompt := userPrompt
-		if toolResultsCtx.Len() > 0 {
-			prompt += "\n\nTool results so far:\n" + headTail(toolResultsCtx.String(), 8000)
-			prompt += "\nYou have the tool output above. Output the final ExecutionResult JSON now (status=completed). Only make another tool call if the output above is genuinely insufficient."
+		if len(toolResults) > 0 {
+			var next string
+			if repick {
+				next = "\nThe last tool is not available in this environment. Re-issue the call now using one of the suggested available tools."
+				repick = false
+			} else {
+				next = "\nYou have the tool output above. Output the final ExecutionResult JSON now (status=completed). Only make another tool call if the output above is genuinely insufficient."
+			}
+			const header = "\n\nTool results so far:\n"
+			kept := llm.FitParts(e.maxPromptTokens, sysPrompt+userPrompt+header+next, "tool results", foldObservations(toolResults))
+			prompt += header + headTail(strings.Join(kept, ""), 8000) + next
 		}
-
-		sysPrompt := buildSystemPrompt()
+		prompt = llm.FitUser(e.maxPromptTokens, sysPrompt, prompt)
 		raw, usage, err := e.llm.Chat(ctx, sysPrompt, prompt)
 		tlog.LLMCall("executor", sysPrompt, prompt, raw, usage.PromptTokens, usage.CompletionTokens, usage.ElapsedMs, i+1)
 		if err != nil {
-			return types.ExecutionResult{}, toolCallHistory, fmt.Errorf("llm: %w", err)
+			return types.ExecutionResult{Artifacts: artifacts}, toolCallHistory, fmt.Errorf("llm: %w", err)
 		}
-		raw = llm.StripFences(raw)
+		raw, _ = llm.RepairJSON(raw) // unparseable output is reported by the decoders below
 		slog.Debug("[R3] llm response", "iter", i, "preview", firstN(raw, 200))
 
 		// Try to parse as final result first.
@@ -263,140 +629,950 @@ func (e *Executor) execute(ctx context.Context, st types.SubTask, correction *ty
 				Output:      fr.Output,
 				Uncertainty: fr.Uncertainty,
 				ToolCalls:   toolCallHistory,
+				Artifacts:   artifacts,
 			}, toolCallHistory, nil
 		}
 
+		// The model cannot tell how to proceed: stop here and ask for a new plan.
+		var nr struct{ Action, Reason string }
+		if err := json.NewDecoder(strings.NewReader(raw)).Decode(&nr); err == nil && nr.Action == "need_replan" {
+			slog.Info("[R3] executor asked for a replan", "subtask", st.SubTaskID, "iter", i+1, "reason", nr.Reason)
+			return needReplanResult(st.SubTaskID, nr.Reason, toolCallHistory, artifacts), toolCallHistory, nil
+		}
+
+		// A batch of independent read-only calls runs concurrently as one turn.
+		var batch toolBatch
+		if err := json.NewDecoder(strings.NewReader(raw)).Decode(&batch); err == nil && batch.Action == "tools" {
+			calls, err := parseBatch(batch.Calls)
+			if err != nil {
+				slog.Warn("[R3] tool batch rejected", "iter", i+1, "error", err)
+				toolResults = append(toolResults, fmt.Sprintf(
+					"\n⚠️ BATCH REJECTED: %v. A batch may only hold up to %d read-only calls (%s); call any other tool on its own.\n",
+					err, maxBatchCalls, batchToolList(e.tools())))
+				continue
+			}
+			// The whole batch is one observation, so folding keeps or condenses it
+			// as a unit, like the single call it replaces.
+			var obs strings.Builder
+			fmt.Fprintf(&obs, "Tool batch (%d calls):\n", len(calls))
+			// The duplicate guard applies to each call: one repeating the previous
+			// call, or an earlier call in the batch, is blocked and the rest run.
+			var run []toolCall
+			seen := map[string]bool{}
+			for _, tc := range calls {
+				key := callKey(tc)
+				if key == lastKey || seen[key] {
+					slog.Warn("[R3] loop detected: identical batch call blocked", "tool", tc.Tool, "iter", i+1)
+					obs.WriteString(duplicateNotice(tc.Tool))
+					continue
+				}
+				seen[key] = true
+				run = append(run, tc)
+			}
+			if len(run) == 0 {
+				toolResults = append(toolResults, obs.String())
+				consecutiveDuplicates++
+				if consecutiveDuplicates >= 2 {
+					return loopKill(calls[0].Tool), toolCallHistory, nil
+				}
+				continue
+			}
+			consecutiveDuplicates = 0
+			slog.Info("[R3] tool batch", "iter", i+1, "calls", len(run))
+			calls = run
+			for j, res := range e.runBatch(ctx, calls, shellEnv(st)) {
+				tc := calls[j]
+				lastKey = callKey(tc)
+				ranKeys[lastKey] = true
+				toolCallHistory = append(toolCallHistory, callSignature(tc))
+				if res.err != nil {
+					fmt.Fprintf(&obs, "Tool %s (batch %d/%d) ERROR: %v\n", tc.Tool, j+1, len(calls), res.err)
+					toolCallHistory[len(toolCallHistory)-1] += " → ERROR: " + firstN(res.err.Error(), 80)
+					tlog.ToolCall(st.SubTaskID, tc.Tool, string(tc.input()), "", "", res.err.Error(), res.elapsedMs)
+					continue
+				}
+				fmt.Fprintf(&obs, "Tool %s (batch %d/%d) result%s:\n%s\n", tc.Tool, j+1, len(calls), contentTag(res.contentType), headTail(res.content, 4000))
+				toolCallHistory[len(toolCallHistory)-1] += " → " + taggedEvidence(res.contentType, toolEvidence(tc.Tool, res.content, e.evidenceLen))
+				tlog.ToolCall(st.SubTaskID, tc.Tool, string(tc.input()), firstN(strings.TrimSpace(res.content), 500), res.contentType, "", res.elapsedMs)
+			}
+			toolResults = append(toolResults, obs.String())
+			continue
+		}
+
 		// Parse as tool call — same decoder approach for consistency.
 		var tc toolCall
-		if err := json.NewDecoder(strings.NewReader(raw)).Decode(&tc); err != nil {
+		err = json.NewDecoder(strings.NewReader(raw)).Decode(&tc.raw)
+		if err == nil && json.Unmarshal(tc.raw, &tc) != nil {
+			// A custom tool may use a built-in field name with a non-string value;
+			// only action and tool are needed here, the tool decodes the rest.
+			var head struct{ Action, Tool string }
+			err = json.Unmarshal(tc.raw, &head)
+			tc.Action, tc.Tool = head.Action, head.Tool
+		}
+		if err != nil {
 			// Log the raw response to debug.log for diagnostics, but do NOT embed it in
 			// the returned error — it could contain hallucinated tool output that R4a would
 			// evaluate as evidence when scoring criteria (issue #83).
 			slog.Warn("[R3] parse error", "iter", i+1, "raw", raw)
-			return types.ExecutionResult{}, toolCallHistory, fmt.Errorf("parse LLM output: %w", err)
+			return types.ExecutionResult{Artifacts: artifacts}, toolCallHistory, fmt.Errorf("parse LLM output: %w", err)
 		}
 
-		detail := tc.Command + tc.Path + tc.Query + tc.Pattern + tc.Name + firstN(tc.Script, 40)
-		currentSig := tc.Tool + ":" + firstN(detail, 60)
-
 		// Loop detection: identical consecutive call → block execution and warn the LLM.
 		// After 2 consecutive blocked duplicates the model is irrecoverably stuck;
 		// fail the subtask immediately to avoid burning the remaining LLM budget.
-		if len(toolCallHistory) > 0 && currentSig == toolCallHistory[len(toolCallHistory)-1] {
+		if callKey(tc) == lastKey {
 			consecutiveDuplicates++
 			slog.Warn("[R3] loop detected: identical call blocked", "tool", tc.Tool, "iter", i+1, "consecutive", consecutiveDuplicates)
 			if consecutiveDuplicates >= 2 {
-				slog.Warn("[R3] hard loop kill: 2 consecutive duplicates, failing subtask", "tool", tc.Tool)
-				return types.ExecutionResult{
-					SubTaskID: st.SubTaskID,
-					Status:    "failed",
-					Output:    fmt.Sprintf("executor loop: [%s] called with identical parameters %d times consecutively; no progress possible", tc.Tool, consecutiveDuplicates+1),
-					ToolCalls: toolCallHistory,
-				}, toolCallHistory, nil
+				return loopKill(tc.Tool), toolCallHistory, nil
 			}
-			toolResultsCtx.WriteString(fmt.Sprintf(
-				"\n⚠️ DUPLICATE CALL BLOCKED: [%s] was already called with identical parameters — repeated calls return identical results and waste budget. You MUST now either:\n1. Output the final result using what you already have (even if partial), OR\n2. Use a COMPLETELY DIFFERENT query, tool, or approach.\nDo NOT repeat this call.\n",
-				tc.Tool))
+			toolResults = append(toolResults, duplicateNotice(tc.Tool))
 			continue
 		}
 		consecutiveDuplicates = 0
 
-		toolCallHistory = append(toolCallHistory, currentSig)
-
-		// Log tool invocation with the most relevant param per tool type.
-		switch tc.Tool {
-		case "shell":
-			slog.Info("[R3] tool call", "iter", i+1, "tool", "shell", "cmd", firstN(tc.Command, 120))
-		case "mdfind":
-			slog.Info("[R3] tool call", "iter", i+1, "tool", "mdfind", "query", tc.Query)
-		case "glob":
-			slog.Info("[R3] tool call", "iter", i+1, "tool", "glob", "pattern", tc.Pattern, "root", tc.Root)
-		case "read_file":
-			slog.Info("[R3] tool call", "iter", i+1, "tool", "read_file", "path", tc.Path)
-		case "write_file":
-			slog.Info("[R3] tool call", "iter", i+1, "tool", "write_file", "path", tc.Path, "bytes", len(tc.Content))
-		case "applescript":
-			slog.Info("[R3] tool call", "iter", i+1, "tool", "applescript", "script", firstN(tc.Script, 100))
-		case "shortcuts":
-			slog.Info("[R3] tool call", "iter", i+1, "tool", "shortcuts", "name", tc.Name)
-		case "search":
-			slog.Info("[R3] tool call", "iter", i+1, "tool", "search", "query", tc.Query)
-		default:
-			slog.Info("[R3] tool call", "iter", i+1, "tool", tc.Tool)
+		result, err := runCall(tc, i+1)
+		if strings.HasPrefix(result, preflightTag) && !repicked {
+			repick, repicked = true, true
 		}
 
-		tcInputJSON, _ := json.Marshal(tc)
-		toolStart := time.Now()
-		result, err := e.runTool(ctx, tc)
-		toolElapsedMs := time.Since(toolStart).Milliseconds()
-		if err != nil {
-			toolResultsCtx.WriteString(fmt.Sprintf("Tool %s ERROR: %v\n", tc.Tool, err))
-			slog.Warn("[R3] tool error", "iter", i+1, "tool", tc.Tool, "error", err)
-			// Append error evidence to tool_calls so R4a can verify
-			toolCallHistory[len(toolCallHistory)-1] += " → ERROR: " + firstN(err.Error(), 80)
-			tlog.ToolCall(st.SubTaskID, tc.Tool, string(tcInputJSON), "", err.Error(), toolElapsedMs)
-		} else {
-			toolResultsCtx.WriteString(fmt.Sprintf("Tool %s result:\n%s\n", tc.Tool, headTail(result, 4000)))
-			slog.Debug("[R3] tool result", "iter", i+1, "tool", tc.Tool, "output", firstN(strings.TrimSpace(result), 500))
-			// Append leading content to tool_calls so R4a sees concrete evidence.
-			// firstN: nearly all tool outputs (search titles, file paths, shell results)
-			// put the relevant content at the start. lastN was wrong for search results.
-			toolCallHistory[len(toolCallHistory)-1] += " → " + firstN(strings.TrimSpace(result), 200)
-			tlog.ToolCall(st.SubTaskID, tc.Tool, string(tcInputJSON), firstN(strings.TrimSpace(result), 500), "", toolElapsedMs)
+		// A search that found nothing on-topic gets one rewritten query before the
+		// model sees it, instead of a full R4a correction round. The retry is a
+		// tool call like any other: it passes the duplicate guard, is recorded by
+		// runCall, needs budget left, and spends one turn.
+		if tc.Tool == "search" && err == nil && !reformulated && i+1 < maxToolCalls && weakSearchResult(result, st.SuccessCriteria) {
+			reformulated = true
+			if q, ok := e.reformulateQuery(ctx, st, tc.Query, tlog); ok {
+				retry, rerr := withQuery(tc, q)
+				if rerr != nil {
+					slog.Warn("[R3] search reformulation skipped", "subtask", st.SubTaskID, "error", rerr)
+					continue
+				}
+				if ranKeys[callKey(retry)] {
+					slog.Info("[R3] search reformulation skipped: query already searched", "subtask", st.SubTaskID, "query", q)
+					continue
+				}
+				i++
+				slog.Info("[R3] search reformulated", "subtask", st.SubTaskID, "from", tc.Query, "to", q)
+				runCall(retry, i+1)
+			}
 		}
 	}
 
-	return types.ExecutionResult{
+	// Tool budget exhausted without a final result: synthesize one from the
+	// gathered evidence, falling back to the raw tool results.
+	slog.Warn("[R3] tool budget exhausted", "subtask", st.SubTaskID, "max_tool_calls", maxToolCalls)
+	fallback := types.ExecutionResult{
 		SubTaskID: st.SubTaskID,
 		Status:    "uncertain",
-		Output:    toolResultsCtx.String(),
-		ToolCalls: toolCallHistory,
-	}, toolCallHistory, nil
+		Output:    strings.Join(toolResults, ""),
+	}
+	if len(toolResults) > 0 {
+		if synth, ok := e.synthesize(ctx, st, toolResults, tlog); ok {
+			fallback = synth
+		}
+	}
+	fallback.ToolCalls = toolCallHistory
+	fallback.Artifacts = artifacts
+	return fallback, toolCallHistory, nil
+}
+
+// Observation folding bounds the tool results re-sent every turn. Once the raw
+// results pass observationFoldChars, all but the newest observationKeepRecent
+// are folded into one-line digests, and the digest block is capped too. A tool
+// batch's results are one entry, so the latest batch is never split.
+const (
+	observationFoldChars    = 6000 // raw tool-result chars a turn may carry before folding
+	observationKeepRecent   = 2    // newest results always kept verbatim
+	observationDigestChars  = 200  // max chars of one folded result's digest line
+	observationSummaryChars = 2000 // max chars of all digest lines together
+)
+
+// foldObservations is the per-turn view of toolResults: unchanged while small,
+// else a summary of one-line digests for the older results followed by the
+// newest results verbatim. The raw results are still what the task log and
+// synthesize see; only the turn prompt is condensed.
+//
+// Expectations:
+//   - Returns results unchanged when their total length is within observationFoldChars
+//   - Otherwise keeps the newest observationKeepRecent results verbatim, after one summary part
+//   - The summary has one digest line per older result: its header line and the start of its content
+//   - Drops the oldest digest lines (and says how many) to keep the summary within observationSummaryChars
+//   - Never modifies results
+func foldObservations(results []string) []string {
+	total := 0
+	for _, r := range results {
+		total += len(r)
+	}
+	if total <= observationFoldChars || len(results) <= observationKeepRecent {
+		return results
+	}
+	older, recent := results[:len(results)-observationKeepRecent], results[len(results)-observationKeepRecent:]
+	digests := make([]string, len(older))
+	for i, r := range older {
+		digests[i] = observationDigest(r)
+	}
+	size, start := 0, len(digests)
+	for start > 0 && size+len(digests[start-1]) <= observationSummaryChars {
+		start--
+		size += len(digests[start])
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Summary of %d earlier tool results (condensed; the newest %d follow in full):\n", len(older), len(recent))
+	if start > 0 {
+		fmt.Fprintf(&b, "- (%d earliest results omitted)\n", start)
+	}
+	for _, d := range digests[start:] {
+		b.WriteString(d)
+	}
+	return append([]string{b.String()}, recent...)
+}
+
+// observationDigest condenses one tool result entry to a single "- " line: its
+// header ("Tool shell result:", a notice's first line) and the start of its body.
+func observationDigest(result string) string {
+	header, body, _ := strings.Cut(strings.TrimSpace(result), "\n")
+	line := header
+	if body = strings.Join(strings.Fields(body), " "); body != "" {
+		line += " " + body
+	}
+	return "- " + firstN(line, observationDigestChars) + "\n"
+}
+
+// parseBatch decodes the calls of a tool batch.
+//
+// Expectations:
+//   - Returns the calls in order, each with Action "tool" and its raw call JSON kept
+//   - Returns an error for an empty batch or one with more than maxBatchCalls calls
+//   - Returns an error naming the tool when any call is not in batchTools
+//   - Returns an error when a call does not decode
+func parseBatch(raw []json.RawMessage) ([]toolCall, error) {
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("empty batch")
+	}
+	if len(raw) > maxBatchCalls {
+		return nil, fmt.Errorf("%d calls in one batch", len(raw))
+	}
+	calls := make([]toolCall, len(raw))
+	for i, r := range raw {
+		var tc toolCall
+		if err := json.Unmarshal(r, &tc); err != nil {
+			return nil, fmt.Errorf("call %d: %w", i+1, err)
+		}
+		if !batchTools[tc.Tool] {
+			return nil, fmt.Errorf("call %d: %q is not a read-only tool", i+1, tc.Tool)
+		}
+		tc.Action = "tool"
+		tc.raw = r
+		calls[i] = tc
+	}
+	return calls, nil
+}
+
+// callDetail is what distinguishes one call of a tool from another: its main
+// parameters, or the whole call for a custom tool whose parameters are not
+// toolCall fields.
+func callDetail(tc toolCall) string {
+	detail := tc.Command + tc.Path + tc.Query + tc.Pattern + tc.Glob + tc.URL + tc.Name + tc.Script
+	if detail == "" {
+		detail = string(tc.raw)
+	}
+	return detail
+}
+
+// callKey identifies a call for loop detection; unlike callSignature it is never
+// truncated, so two long paths sharing a prefix are different calls. A write's
+// content is part of the call too, hashed to keep the key small, so rewriting a
+// file with corrected content is not a duplicate.
+func callKey(tc toolCall) string {
+	key := tc.Tool + ":" + callDetail(tc)
+	if tc.Content != "" {
+		sum := sha256.Sum256([]byte(tc.Content))
+		key += "#" + hex.EncodeToString(sum[:8])
+	}
+	return key
+}
+
+// callSignature is a call's entry in R3's tool-call history.
+func callSignature(tc toolCall) string {
+	return tc.Tool + ":" + firstN(callDetail(tc), 60)
+}
+
+// duplicateNotice is the tool result a call blocked by the duplicate guard gets.
+func duplicateNotice(tool string) string {
+	return fmt.Sprintf(
+		"\n⚠️ DUPLICATE CALL BLOCKED: [%s] was already called with identical parameters — repeated calls return identical results and waste budget. You MUST now either:\n1. Output the final result using what you already have (even if partial), OR\n2. Use a COMPLETELY DIFFERENT query, tool, or approach.\nDo NOT repeat this call.\n",
+		tool)
+}
+
+// batchResult is one call's outcome within a tool batch.
+type batchResult struct {
+	content, contentType string
+	err                  error
+	elapsedMs            int64
+}
+
+// runBatch runs calls concurrently through runTool, so each still honours
+// preflight, confirm, and tools.Limits.
+//
+// Expectations:
+//   - Returns one result per call, in call order, whatever order they finish in
+func (e *Executor) runBatch(ctx context.Context, calls []toolCall, env tools.ShellEnv) []batchResult {
+	results := make([]batchResult, len(calls))
+	var wg sync.WaitGroup
+	for i, tc := range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			content, contentType, err := e.runTool(ctx, tc, env)
+			results[i] = batchResult{content: content, contentType: contentType, err: err, elapsedMs: time.Since(start).Milliseconds()}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// addArtifact appends the absolute form of path to artifacts unless it is
+// already listed, so a file rewritten several times is reported once.
+//
+// Expectations:
+//   - Appends path made absolute
+//   - Leaves artifacts unchanged when the path is already listed
+func addArtifact(artifacts []string, path string) []string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if slices.Contains(artifacts, path) {
+		return artifacts
+	}
+	return append(artifacts, path)
+}
+
+// keywordStopwords are common criterion words that say nothing about the topic.
+var keywordStopwords = map[string]bool{
+	"that": true, "this": true, "with": true, "from": true, "into": true, "than": true,
+	"least": true, "must": true, "should": true, "contains": true, "contain": true,
+	"include": true, "includes": true, "output": true, "result": true, "results": true,
+	"each": true, "every": true, "about": true, "which": true,
+	"their": true, "there": true, "have": true, "been": true, "more": true, "most": true,
+	"returned": true, "returns": true, "shows": true, "show": true, "lists": true, "list": true,
+}
+
+// criterionKeywords returns the distinct lowercase topic words (4+ ASCII letters
+// or digits, not stopwords) of the success criteria.
+//
+// Expectations:
+//   - Splits on anything that is not a letter or digit
+//   - Drops words shorter than 4 runes, stopwords, and words with non-ASCII runes
+//   - Returns each keyword once
+func criterionKeywords(criteria []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, c := range criteria {
+		words := strings.FieldsFunc(strings.ToLower(c), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, w := range words {
+			if len(w) < 4 || keywordStopwords[w] || seen[w] || strings.IndexFunc(w, func(r rune) bool { return r > unicode.MaxASCII }) >= 0 {
+				continue
+			}
+			seen[w] = true
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// weakSearchResult reports whether a search output is too weak to act on: empty,
+// a "No results found" message, or mentioning none of the criterion keywords.
+//
+// Expectations:
+//   - Returns true for empty output and for the "No results found" message
+//   - Returns true when the output mentions none of the criterion keywords
+//   - Returns false when any criterion keyword appears (case-insensitive)
+//   - Returns false for non-empty output when the criteria have no keywords
+func weakSearchResult(result string, criteria []string) bool {
+	trimmed := strings.TrimSpace(result)
+	if trimmed == "" || strings.HasPrefix(trimmed, "No results found") {
+		return true
+	}
+	keywords := criterionKeywords(criteria)
+	if len(keywords) == 0 {
+		return false
+	}
+	lower := strings.ToLower(trimmed)
+	for _, k := range keywords {
+		if strings.Contains(lower, k) {
+			return false
+		}
+	}
+	return true
+}
+
+const reformulatePrompt = `You are R3 — Executor. A web search returned nothing relevant to the SubTask.
+Write ONE better search query: broaden it, drop over-specific terms, or rephrase with the words a relevant page would use.
+Output ONLY raw JSON — no label, no prose, no markdown:
+{"query":"<new search query>"}`
+
+// reformulateQuery asks the model for a rewritten search query after a weak search.
+//
+// Expectations:
+//   - Returns the model's query with ok=true when it is non-empty and differs from the original
+//   - Returns ok=false on LLM error, unparseable output, or an unchanged query
+func (e *Executor) reformulateQuery(ctx context.Context, st types.SubTask, query string, tlog *tasklog.TaskLog) (string, bool) {
+	prompt := "SubTask:\n" + subTaskToJSON(st) + "\n\nSearch query that returned nothing relevant: " + query
+	raw, usage, err := e.llm.Chat(ctx, reformulatePrompt, prompt)
+	tlog.LLMCall("executor", reformulatePrompt, prompt, raw, usage.PromptTokens, usage.CompletionTokens, usage.ElapsedMs, 0)
+	if err != nil {
+		slog.Warn("[R3] search reformulation call failed", "subtask", st.SubTaskID, "error", err)
+		return "", false
+	}
+	var out struct {
+		Query string `json:"query"`
+	}
+	fixed, err := llm.RepairJSON(raw)
+	if err == nil {
+		err = json.Unmarshal([]byte(fixed), &out)
+	}
+	if err != nil {
+		slog.Warn("[R3] search reformulation unparseable", "subtask", st.SubTaskID, "raw", firstN(raw, 200))
+		return "", false
+	}
+	q := strings.TrimSpace(out.Query)
+	if q == "" || strings.EqualFold(q, strings.TrimSpace(query)) {
+		return "", false
+	}
+	return q, true
+}
+
+// withQuery returns a copy of tc with its query replaced, in both the decoded
+// field and the raw call JSON handed to the tool.
+func withQuery(tc toolCall, query string) (toolCall, error) {
+	fields := map[string]any{}
+	if len(tc.raw) > 0 {
+		if err := json.Unmarshal(tc.raw, &fields); err != nil {
+			return tc, err
+		}
+	}
+	fields["action"], fields["tool"], fields["query"] = "tool", tc.Tool, query
+	raw, err := json.Marshal(fields)
+	if err != nil {
+		return tc, err
+	}
+	tc.Query, tc.raw = query, raw
+	return tc, nil
+}
+
+const synthesisPrompt = `You are R3 — Executor. Your tool-call budget for this SubTask is exhausted: no more tools can run.
+Using ONLY the tool results below, write the best possible final result for the SubTask.
+- status "completed": the tool results arguably satisfy ALL success_criteria; put the answer in output.
+- status "uncertain": they do not; put what was found in output and what is missing in uncertainty.
+- Never state anything the tool results do not show.
+Output ONLY raw JSON — no label, no prose, no markdown:
+{"action":"result","subtask_id":"...","status":"completed|uncertain","output":"<result text>","uncertainty":null}`
+
+// synthesize makes one last LLM call when the tool budget runs out, turning the
+// accumulated tool results into a final ExecutionResult instead of returning them raw.
+// Often the evidence was already gathered and the model just never committed.
+//
+// Expectations:
+//   - Returns the model's result with ok=true when it parses as a final result
+//   - Maps any status other than "completed" to "uncertain" (no tool ran, so nothing newly failed)
+//   - Returns ok=false on LLM error or unparseable output so the caller keeps the raw dump
+//   - Does not set ToolCalls — evidence comes from the code-recorded history, not the model
+func (e *Executor) synthesize(ctx context.Context, st types.SubTask, toolResults []string, tlog *tasklog.TaskLog) (types.ExecutionResult, bool) {
+	head := "SubTask:\n" + subTaskToJSON(st) + "\n\nTool results:\n"
+	var guidance string
+	if g := llm.LanguageGuidance(st.Language); g != "" {
+		guidance = "\n\n" + g
+	}
+	kept := llm.FitParts(e.maxPromptTokens, synthesisPrompt+head+guidance, "tool results", toolResults)
+	prompt := head + headTail(strings.Join(kept, ""), 8000) + guidance
+	raw, usage, err := e.llm.Chat(ctx, synthesisPrompt, prompt)
+	tlog.LLMCall("executor", synthesisPrompt, prompt, raw, usage.PromptTokens, usage.CompletionTokens, usage.ElapsedMs, 0)
+	if err != nil {
+		slog.Warn("[R3] synthesis call failed, returning raw tool results", "subtask", st.SubTaskID, "error", err)
+		return types.ExecutionResult{}, false
+	}
+	var fr finalResult
+	fixed, err := llm.RepairJSON(raw)
+	if err == nil {
+		err = json.Unmarshal([]byte(fixed), &fr)
+	}
+	if err != nil || fr.Action != "result" {
+		slog.Warn("[R3] synthesis output unparseable, returning raw tool results", "subtask", st.SubTaskID, "raw", firstN(raw, 200))
+		return types.ExecutionResult{}, false
+	}
+	if fr.Status != "completed" {
+		fr.Status = "uncertain"
+	}
+	slog.Info("[R3] synthesized result after tool budget exhausted", "subtask", st.SubTaskID, "status", fr.Status)
+	return types.ExecutionResult{
+		SubTaskID:   st.SubTaskID,
+		Status:      fr.Status,
+		Output:      fr.Output,
+		Uncertainty: fr.Uncertainty,
+	}, true
 }
 
 // splitShellFragments splits a compound shell command into individual statement
-// fragments by tokenizing on common separators (&&, ||, ;, |, newlines) and
-// stripping leading shell control-flow keywords (then, do, else).
-// This is intentionally simple — it does not parse quoting or subshells —
+// fragments. It is quote-aware: separators inside '...' or "..." or after a
+// backslash do not split, so `echo "a; rm b"` stays one harmless echo. Command
+// substitutions ($(...), `...`, <(...), >(...)) run even inside double quotes,
+// so their bodies are split recursively and returned as fragments of their own,
+// after the enclosing statement. Here-doc bodies (<<MARKER ... MARKER) fed to a
+// shell or interpreter (bash <<EOF, cat <<EOF | sh) are scripts and are split
+// like the rest of the command; bodies fed only to data consumers (cat, tee,
+// grep, …) are skipped, except that the command substitutions of an
+// unquoted-marker body still run and are returned too.
+// Leading control-flow keywords are stripped. It is not a full shell parser,
 // but covers the patterns models use to embed destructive commands inside
-// loops, conditionals, and pipelines.
+// loops, conditionals, pipelines, substitutions, and multi-line scripts.
 //
 // Expectations:
 //   - Single command returns one fragment (itself, trimmed)
-//   - Splits on "&&", "||", ";", "|", and "\n"
-//   - Strips leading keywords "then ", "do ", "else ", "{ ", "( "
+//   - Splits on unquoted "&&", "||", ";", "|", "&", "(", ")", and "\n"
+//   - Does not split inside single or double quotes or on backslash-escaped separators
+//   - Returns no fragments for the body of a here-doc (<<EOF, <<'EOF', <<"EOF", <<-EOF),
+//     which ends at the line holding only the marker (after leading tabs for <<-),
+//     when no statement on the here-doc's line runs a script (runsScript)
+//   - Splits the body as statements, quoted marker or not, when a statement on the
+//     here-doc's line is a shell, eval, ssh, xargs, sudo, or interpreter
+//   - Returns the bodies of $(...) and `...` inside an unquoted-marker here-doc as fragments
+//   - Treats <<< as a here-string, not a here-doc
+//   - Treats << inside (( ... )) as a shift, not a here-doc
+//   - Does not split on "&" or "|" that are part of a redirection (2>&1, &>, >|)
+//   - Returns the bodies of $(...), `...`, <(...), >(...) as extra fragments, also inside double quotes
+//   - Does not look inside single-quoted text
+//   - Strips leading keywords "then ", "do ", "else ", "if ", "elif ", "while ", "until ", "! ", "{ "
 //   - Returns only non-empty trimmed fragments
 func splitShellFragments(cmd string) []string {
-	for _, sep := range []string{"&&", "||", ";", "|", "\n"} {
-		cmd = strings.ReplaceAll(cmd, sep, "\x00")
+	var out, nested []string
+	var cur strings.Builder
+	flush := func() {
+		if part := stripLeadingKeywords(cur.String()); part != "" {
+			out = append(out, part)
+		}
+		cur.Reset()
 	}
-	leadingKeywords := []string{"then ", "do ", "else ", "{ ", "( "}
-	var out []string
-	for _, part := range strings.Split(cmd, "\x00") {
-		part = strings.TrimSpace(part)
-		if part == "" {
+	inSingle, inDouble := false, false
+	var heredocs []heredoc // here-docs opened on the current line, in order
+	lineStart := 0         // index in out of the current line's first statement
+	arith := 0             // depth of (( ... )), where << is a shift, not a here-doc
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		if inSingle {
+			cur.WriteByte(c)
+			if c == '\'' {
+				inSingle = false
+			}
 			continue
 		}
+		switch {
+		case !inDouble && c == '<' && strings.HasPrefix(cmd[i:], "<<<"):
+			cur.WriteString("<<<")
+			i += 2
+			continue
+		case !inDouble && arith == 0 && c == '<' && strings.HasPrefix(cmd[i:], "<<"):
+			if h, end, ok := parseHeredoc(cmd, i+2); ok {
+				heredocs = append(heredocs, h)
+				cur.WriteString(cmd[i:end])
+				i = end - 1
+				continue
+			}
+			cur.WriteString("<<")
+			i++
+			continue
+		case c == '\\' && i+1 < len(cmd):
+			cur.WriteString(cmd[i : i+2])
+			i++
+			continue
+		case c == '`':
+			end := closingBacktick(cmd, i+1)
+			nested = append(nested, splitShellFragments(cmd[i+1:end])...)
+			cur.WriteString(cmd[i:min(end+1, len(cmd))])
+			i = end
+			continue
+		case c == '(' && i > 0 && (cmd[i-1] == '$' || (!inDouble && (cmd[i-1] == '<' || cmd[i-1] == '>'))):
+			end := closingParen(cmd, i+1)
+			nested = append(nested, splitShellFragments(cmd[i+1:end])...)
+			cur.WriteString(cmd[i:min(end+1, len(cmd))])
+			i = end
+			continue
+		case c == '"':
+			inDouble = !inDouble
+			cur.WriteByte(c)
+			continue
+		case inDouble:
+			cur.WriteByte(c)
+			continue
+		case c == '\'':
+			inSingle = true
+			cur.WriteByte(c)
+			continue
+		}
+		prevRedirect := i > 0 && (cmd[i-1] == '>' || cmd[i-1] == '<')
+		switch c {
+		case '\n':
+			flush()
+			// Here-doc bodies start on the next line, one after another. A body
+			// fed to a shell or interpreter is a script, so its statements count.
+			script := len(heredocs) > 0 && slices.ContainsFunc(out[lineStart:], runsScript)
+			for _, h := range heredocs {
+				var body string
+				body, i = h.skipBody(cmd, i+1)
+				switch {
+				case script:
+					nested = append(nested, splitShellFragments(body)...)
+				case !h.quoted:
+					nested = append(nested, heredocSubstitutions(body)...)
+				}
+			}
+			heredocs = nil
+			lineStart = len(out)
+		case ';', '(', ')':
+			if c == '(' && strings.HasPrefix(cmd[i:], "((") {
+				arith++
+			} else if c == ')' && arith > 0 && strings.HasPrefix(cmd[i:], "))") {
+				arith--
+			}
+			flush()
+		case '&':
+			if prevRedirect || (i+1 < len(cmd) && cmd[i+1] == '>') {
+				cur.WriteByte(c)
+				continue
+			}
+			if i+1 < len(cmd) && cmd[i+1] == '&' {
+				i++
+			}
+			flush()
+		case '|':
+			if prevRedirect {
+				cur.WriteByte(c)
+				continue
+			}
+			if i+1 < len(cmd) && (cmd[i+1] == '|' || cmd[i+1] == '&') {
+				i++
+			}
+			flush()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush()
+	return append(out, nested...)
+}
+
+// scriptRunners are commands that execute their standard input (or a here-doc
+// fed to them) as code rather than reading it as data.
+var scriptRunners = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "dash": true, "ksh": true, "fish": true,
+	"eval": true, "source": true, ".": true, "ssh": true, "xargs": true, "sudo": true, "su": true,
+	"perl": true, "ruby": true, "node": true, "php": true, "lua": true, "osascript": true,
+}
+
+// runsScript reports whether a statement's command — before or after removing
+// wrappers like sudo and env — executes its input as code, so a here-doc on
+// the same line is a script to check rather than data to skip.
+//
+// Expectations:
+//   - Returns true for shells, eval, ssh, xargs, sudo, su, and interpreters (python*, perl, ruby, node, …)
+//   - Returns true for a wrapped runner (sudo bash, env sh -s) and for bare "sudo -s"
+//   - Returns false for data consumers such as cat, tee, grep, wc
+func runsScript(fragment string) bool {
+	isRunner := func(w string) bool {
+		w = w[strings.LastIndexByte(w, '/')+1:]
+		return scriptRunners[w] || strings.HasPrefix(w, "python")
+	}
+	words := shellWords(fragment)
+	for len(words) > 0 {
+		if eq := strings.IndexByte(words[0], '='); eq > 0 && isShellName(words[0][:eq]) {
+			words = words[1:]
+			continue
+		}
+		break
+	}
+	if len(words) > 0 && isRunner(words[0]) {
+		return true
+	}
+	cw := commandWords(fragment)
+	return len(cw) > 0 && isRunner(cw[0])
+}
+
+// heredoc is a here-doc operator whose body is still to come.
+type heredoc struct {
+	marker    string // the delimiter word, quotes removed
+	stripTabs bool   // <<- : leading tabs are ignored on body and marker lines
+	quoted    bool   // any part of the marker was quoted: the body is not expanded
+}
+
+// parseHeredoc reads the here-doc marker that follows "<<" at start. It returns
+// the here-doc, the index just past the marker word, and false when no marker
+// word follows (e.g. arithmetic 1<<2 with nothing after it).
+//
+// Expectations:
+//   - Accepts an optional "-" (strip tabs) and blanks before the marker
+//   - Removes quotes and backslashes from the marker and marks it quoted
+//   - Ends the marker at an unquoted blank, newline, or ;&|<>()
+func parseHeredoc(cmd string, start int) (heredoc, int, bool) {
+	var h heredoc
+	i := start
+	if i < len(cmd) && cmd[i] == '-' {
+		h.stripTabs = true
+		i++
+	}
+	for i < len(cmd) && (cmd[i] == ' ' || cmd[i] == '\t') {
+		i++
+	}
+	var marker strings.Builder
+	for ; i < len(cmd); i++ {
+		c := cmd[i]
+		if strings.IndexByte(" \t\n;&|<>()", c) >= 0 {
+			break
+		}
+		switch c {
+		case '\'', '"':
+			h.quoted = true
+			end := strings.IndexByte(cmd[i+1:], c)
+			if end < 0 {
+				end = len(cmd) - i - 1
+			}
+			marker.WriteString(cmd[i+1 : i+1+end])
+			i += end + 1
+		case '\\':
+			h.quoted = true
+			if i+1 < len(cmd) {
+				marker.WriteByte(cmd[i+1])
+				i++
+			}
+		default:
+			marker.WriteByte(c)
+		}
+	}
+	h.marker = marker.String()
+	if h.marker == "" {
+		return h, start, false
+	}
+	return h, min(i, len(cmd)), true
+}
+
+// skipBody returns the body of h that starts at start and the index of the
+// newline ending its marker line (len(cmd) when the marker never appears, as
+// the shell then reads to the end).
+func (h heredoc) skipBody(cmd string, start int) (string, int) {
+	for pos := start; pos < len(cmd); {
+		end := strings.IndexByte(cmd[pos:], '\n')
+		if end < 0 {
+			end = len(cmd)
+		} else {
+			end += pos
+		}
+		line := cmd[pos:end]
+		if h.stripTabs {
+			line = strings.TrimLeft(line, "\t")
+		}
+		if line == h.marker {
+			return cmd[start:pos], end
+		}
+		pos = end + 1
+	}
+	return cmd[min(start, len(cmd)):], len(cmd)
+}
+
+// heredocSubstitutions returns the fragments of the command substitutions in
+// an unquoted-marker here-doc body, which the shell expands like double-quoted
+// text: quotes in the body are literal, $(...) and `...` still run.
+func heredocSubstitutions(body string) []string {
+	var out []string
+	for i := 0; i < len(body); i++ {
+		switch {
+		case body[i] == '\\':
+			i++
+		case body[i] == '`':
+			end := closingBacktick(body, i+1)
+			out = append(out, splitShellFragments(body[i+1:end])...)
+			i = end
+		case body[i] == '$' && i+1 < len(body) && body[i+1] == '(':
+			end := closingParen(body, i+2)
+			out = append(out, splitShellFragments(body[i+2:end])...)
+			i = end
+		}
+	}
+	return out
+}
+
+// stripLeadingKeywords trims part and removes any leading control-flow keywords,
+// so "then rm foo" and "if ! rm foo" both yield "rm foo".
+func stripLeadingKeywords(part string) string {
+	leadingKeywords := []string{"then ", "do ", "else ", "if ", "elif ", "while ", "until ", "! ", "{ "}
+	part = strings.TrimSpace(part)
+	for stripped := true; stripped; {
+		stripped = false
 		for _, kw := range leadingKeywords {
 			if strings.HasPrefix(part, kw) {
 				part = strings.TrimSpace(part[len(kw):])
-				break
+				stripped = true
 			}
 		}
-		if part != "" {
-			out = append(out, part)
+	}
+	return part
+}
+
+// closingBacktick returns the index of the backtick that closes a `...`
+// substitution whose body starts at start, or len(s) when it is unclosed.
+func closingBacktick(s string, start int) int {
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '`':
+			return i
 		}
 	}
-	return out
+	return len(s)
+}
+
+// closingParen returns the index of the ")" that closes a $( substitution
+// whose body starts at start, or len(s) when it is unclosed. Parentheses inside
+// quotes do not count.
+func closingParen(s string, start int) int {
+	depth := 1
+	inSingle, inDouble := false, false
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inSingle:
+			inSingle = c != '\''
+		case c == '\\':
+			i++
+		case c == '"':
+			inDouble = !inDouble
+		case inDouble:
+		case c == '\'':
+			inSingle = true
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(s)
+}
+
+// shellWords splits a fragment into words the way the shell would before
+// expansion: quotes are removed, backslash escapes are resolved, and quoted
+// text stays inside its word. Unclosed quotes run to the end of the fragment.
+func shellWords(fragment string) []string {
+	var words []string
+	var cur strings.Builder
+	inWord := false
+	var quote byte
+	for i := 0; i < len(fragment); i++ {
+		c := fragment[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' && i+1 < len(fragment) {
+				i++
+				cur.WriteByte(fragment[i])
+			} else {
+				cur.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == '\\' && i+1 < len(fragment):
+			i++
+			cur.WriteByte(fragment[i])
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words
+}
+
+// commandWords returns the words of a fragment starting at the command that
+// actually runs: leading "sudo", VAR=value assignments, and transparent
+// wrappers (command, env, exec, nohup, nice, time, builtin) with their options
+// are skipped, and the command word is reduced to its base name, so `sudo FOO=1 \/bin/rm x`
+// yields ["rm", "x"].
+func commandWords(fragment string) []string {
+	wrappers := map[string]bool{
+		"sudo": true, "command": true, "env": true, "exec": true,
+		"nohup": true, "nice": true, "time": true, "builtin": true,
+	}
+	// Wrapper options whose value is a separate word (sudo -u root, nice -n 5).
+	valued := map[string]bool{"-u": true, "-g": true, "-C": true, "-D": true, "-n": true}
+	words := shellWords(fragment)
+	for len(words) > 0 {
+		w := words[0]
+		if eq := strings.IndexByte(w, '='); eq > 0 && isShellName(w[:eq]) {
+			words = words[1:]
+			continue
+		}
+		if !wrappers[w] {
+			break
+		}
+		words = words[1:]
+		for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+			if valued[words[0]] && len(words) > 1 {
+				words = words[1:]
+			}
+			words = words[1:]
+		}
+	}
+	if len(words) > 0 {
+		if slash := strings.LastIndexByte(words[0], '/'); slash >= 0 && slash < len(words[0])-1 {
+			words = append([]string{words[0][slash+1:]}, words[1:]...)
+		}
+	}
+	return words
+}
+
+// isShellName reports whether s is a valid shell variable name.
+func isShellName(s string) bool {
+	for i, r := range s {
+		if r != '_' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && (i == 0 || !(r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return s != ""
 }
 
 // isIrreversibleFragment checks a single normalized shell fragment (no compound
-// operators, no control-flow keywords) for destructive operations.
+// operators, no control-flow keywords) for destructive operations. Scripts
+// passed to "sh -c" / "bash -c" or to eval are checked as commands themselves.
 func isIrreversibleFragment(fragment string) (bool, string) {
-	check := strings.TrimSpace(fragment)
-	if strings.HasPrefix(check, "sudo ") {
-		check = strings.TrimSpace(check[5:])
+	words := commandWords(fragment)
+	if len(words) == 0 {
+		return false, ""
+	}
+	switch words[0] {
+	case "sh", "bash", "zsh", "dash", "ksh":
+		for i := 1; i+1 < len(words); i++ {
+			if w := words[i]; strings.HasPrefix(w, "-") && !strings.HasPrefix(w, "--") && strings.Contains(w, "c") {
+				return isIrreversibleShell(words[i+1])
+			}
+		}
+	case "eval":
+		return isIrreversibleShell(strings.Join(words[1:], " "))
 	}
+	check := strings.Join(words, " ")
 	type pattern struct{ prefix, reason string }
 	patterns := []pattern{
 		{"rm ", "rm deletes files permanently"},
@@ -443,6 +1619,12 @@ func isIrreversibleFragment(fragment string) (bool, string) {
 //   - Returns true for "find " commands containing "-exec rm"
 //   - Returns true for "xargs rm" (pipe to rm)
 //   - Returns true for compound commands embedding rm (for-loop, if-then, &&)
+//   - Returns true for rm inside command substitution ($(...), backticks), even within double quotes
+//   - Returns true for rm behind wrappers or quoting (\rm, "rm", /bin/rm, env rm, sh -c 'rm ...', eval)
+//   - Returns false when rm only appears as quoted text (echo "rm -rf /", echo 'a; rm b')
+//   - Returns false when rm only appears in a here-doc body fed to a data consumer (cat <<'EOF' ... rm ... EOF)
+//   - Returns true for rm in a here-doc body run by a shell or interpreter (bash <<EOF, sh -s <<'EOF', cat <<EOF | sh)
+//   - Returns true for rm after a here-doc's marker line, or in $(...) inside an unquoted-marker body
 //   - Returns false for read-only commands (ls, cat, grep, plain find, etc.)
 func isIrreversibleShell(cmd string) (bool, string) {
 	for _, fragment := range splitShellFragments(cmd) {
@@ -453,6 +1635,131 @@ func isIrreversibleShell(cmd string) (bool, string) {
 	return false, ""
 }
 
+// shellSyntaxWords are fragment heads that are shell grammar rather than
+// commands (the splitter leaves loop and case headers and closers in place), so
+// the allow-list never blocks them.
+var shellSyntaxWords = map[string]bool{
+	"for": true, "select": true, "case": true, "esac": true, "done": true,
+	"fi": true, "}": true, "function": true,
+}
+
+// ParseShellAllowList splits a comma-separated ARTOO_SHELL_ALLOW value into
+// command prefixes of one or more words ("git", "ls", "git status").
+//
+// Expectations:
+//   - Returns nil for an empty or blank list (shell is unrestricted)
+//   - Splits on commas and trims surrounding space
+//   - Splits each entry into words, so "git  status" becomes ["git", "status"]
+//   - Skips empty entries
+func ParseShellAllowList(list string) [][]string {
+	var out [][]string
+	for _, entry := range strings.Split(list, ",") {
+		if words := strings.Fields(entry); len(words) > 0 {
+			out = append(out, words)
+		}
+	}
+	return out
+}
+
+// formatShellAllowList renders allow for the [POLICY] message.
+func formatShellAllowList(allow [][]string) string {
+	entries := make([]string, len(allow))
+	for i, words := range allow {
+		entries[i] = strings.Join(words, " ")
+	}
+	return "allowed: " + strings.Join(entries, ", ")
+}
+
+// disallowedShellCommand returns the first fragment of cmd whose command is not
+// covered by allow, or "" when every fragment is permitted. Fragments come from
+// splitShellFragments, so every statement of a compound command, pipeline, or
+// substitution is checked. A wrapper (sudo, env, timeout, …) must be allowed
+// itself and so must the command it runs. Scripts passed to sh -c or eval are
+// only permitted when sh or eval itself is on the list.
+//
+// Expectations:
+//   - Returns "" when allow is empty (no restriction)
+//   - Returns "" when every fragment starts with an allowed prefix, word by word
+//   - Returns the offending fragment when any one fragment is not allowed
+//   - A multi-word prefix ("git status") does not allow other subcommands ("git push")
+//   - Ignores shell grammar fragments (for, done, fi, …)
+//   - Rejects VAR=value assignments, bare or before a command (PATH=/tmp/evil ls)
+//   - Rejects path-qualified commands (./ls, /tmp/evil/ls), which need not be the allowed one
+//   - Rejects a wrapper that is not allowed, a wrapped command that is not allowed, and a
+//     wrapper with no command to run (sudo -s)
+func disallowedShellCommand(cmd string, allow [][]string) string {
+	if len(allow) == 0 {
+		return ""
+	}
+	for _, fragment := range splitShellFragments(cmd) {
+		words := shellWords(fragment)
+		if len(words) == 0 || shellSyntaxWords[words[0]] {
+			continue
+		}
+		if !allowedCommand(words, allow) {
+			return fragment
+		}
+	}
+	return ""
+}
+
+// shellWrappers run the command that follows their options; each maps to the
+// options that take a separate value word.
+var shellWrappers = map[string]map[string]bool{
+	"sudo":    {"-u": true, "-g": true, "-C": true, "-D": true, "-h": true, "-p": true, "-r": true, "-t": true, "-U": true},
+	"doas":    {"-u": true, "-C": true},
+	"env":     {"-u": true, "-C": true, "-S": true},
+	"nice":    {"-n": true},
+	"ionice":  {"-c": true, "-n": true, "-p": true},
+	"timeout": {"-s": true, "-k": true},
+	"stdbuf":  {"-i": true, "-o": true, "-e": true},
+	"xargs":   {"-I": true, "-n": true, "-P": true, "-L": true, "-s": true, "-d": true, "-E": true, "-a": true},
+	"command": {}, "exec": {}, "nohup": {}, "time": {}, "builtin": {},
+}
+
+// allowedCommand reports whether one statement's words are permitted by allow,
+// following wrappers down to the command they run.
+func allowedCommand(words []string, allow [][]string) bool {
+	for len(words) > 0 {
+		head := words[0]
+		if eq := strings.IndexByte(head, '='); eq > 0 && isShellName(head[:eq]) {
+			return false // PATH=, LD_PRELOAD= and the like change what runs
+		}
+		if strings.Contains(head, "/") || !shellAllowed(words, allow) {
+			return false
+		}
+		valued, wrapper := shellWrappers[head]
+		if !wrapper {
+			return true
+		}
+		words = words[1:]
+		for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+			if words[0] == "--" {
+				words = words[1:]
+				break
+			}
+			if valued[words[0]] && len(words) > 1 {
+				words = words[1:]
+			}
+			words = words[1:]
+		}
+		if head == "timeout" && len(words) > 0 {
+			words = words[1:] // the duration
+		}
+	}
+	return false // a wrapper with nothing to run starts a shell (sudo -s) or dumps state
+}
+
+// shellAllowed reports whether words start with one of the allow prefixes.
+func shellAllowed(words []string, allow [][]string) bool {
+	for _, prefix := range allow {
+		if len(prefix) <= len(words) && slices.Equal(words[:len(prefix)], prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // isIrreversibleWriteFile reports whether writing to path would overwrite an existing file.
 //
 // Expectations:
@@ -524,75 +1831,285 @@ func normalizeFindCmd(cmd string) string {
 	return cmd
 }
 
-func (e *Executor) runTool(ctx context.Context, tc toolCall) (string, error) {
-	switch tc.Tool {
-	case "mdfind":
-		return tools.RunMdfind(ctx, tc.Query)
-	case "shell":
-		if irreversible, reason := isIrreversibleShell(tc.Command); irreversible {
-			return fmt.Sprintf("[LAW1] %s — command blocked: %q. Re-issue the task with explicit permission to proceed.", reason, tc.Command), nil
+// preflightTag prefixes the synthetic tool result returned when a tool is
+// unavailable in this environment, so execute() can recognise it.
+const preflightTag = "[PREFLIGHT]"
+
+// toolAvailable is tools.Available, indirected so tests can simulate a missing tool.
+var toolAvailable = tools.Available
+
+// preflightFallbacks lists substitutes to suggest when a tool is unavailable,
+// in preference order. Only suggestions that are themselves available are offered.
+var preflightFallbacks = map[string][]string{
+	"mdfind":      {"shell", "glob"},
+	"applescript": {"shell"},
+	"shortcuts":   {"applescript", "shell"},
+	"search":      {"shell"},
+	"shell":       {"glob", "read_file"},
+}
+
+// preflight checks that tool can run here before any attempt is made.
+// Returns "" when the tool is available; otherwise a [PREFLIGHT] message naming
+// the reason and an available substitute, worded so R4a classifies it as environmental.
+//
+// Expectations:
+//   - Returns "" when the tool is available
+//   - Returns a message starting with "[PREFLIGHT]" that names the tool when it is unavailable
+//   - Suggests only fallbacks that are themselves available
+//   - Falls back to "a different tool" when no listed substitute is available
+func preflight(tool string) string {
+	ok, reason := toolAvailable(tool)
+	if ok {
+		return ""
+	}
+	var alts []string
+	for _, alt := range preflightFallbacks[tool] {
+		if altOK, _ := toolAvailable(alt); altOK {
+			alts = append(alts, alt)
 		}
-		// Intercept personal-file find commands and redirect to mdfind.
-		// The model occasionally ignores the prompt priority and emits
-		// `find /Users/... -name <pattern>` which is extremely slow (~6 min).
-		if query, ok := redirectPersonalFind(tc.Command); ok {
-			slog.Debug("[R3] redirecting personal find to mdfind", "query", query, "original", firstN(tc.Command, 80))
-			return tools.RunMdfind(ctx, query)
+	}
+	suggestion := "a different tool"
+	if len(alts) > 0 {
+		suggestion = strings.Join(alts, " or ")
+	}
+	return fmt.Sprintf("%s tool %s is not available in this environment (%s); try %s instead.", preflightTag, tool, reason, suggestion)
+}
+
+// shellEnv returns the shell environment requested by st.
+// A subtask with neither Env nor EnvClear inherits the full process environment.
+func shellEnv(st types.SubTask) tools.ShellEnv {
+	return tools.ShellEnv{Vars: st.Env, Clear: st.EnvClear}
+}
+
+// toolPreferences asks memory how each registered tool has fared for subtasks like
+// st (see memory.ToolPreferenceTags) and returns the tools with a clear signal.
+//
+// Expectations:
+//   - Returns nil when mem is nil
+//   - Includes only tools whose potentials derive an Exploit or Avoid action
+//   - Skips tools whose memory query fails
+func (e *Executor) toolPreferences(ctx context.Context, st types.SubTask) map[string]types.Potentials {
+	if e.mem == nil {
+		return nil
+	}
+	var prefs map[string]types.Potentials
+	for _, t := range e.tools().Tools() {
+		space, entity := memory.ToolPreferenceTags(t.Name(), st.Intent)
+		pots, err := e.mem.QueryMK(ctx, space, entity)
+		if err != nil {
+			slog.Warn("[R3] tool preference query failed", "tool", t.Name(), "error", err)
+			continue
 		}
-		cmd := normalizeFindCmd(tc.Command)
-		if cmd != tc.Command {
-			slog.Debug("[R3] normalized find cmd", "from", tc.Command, "to", cmd)
+		if pots.Action != "Exploit" && pots.Action != "Avoid" {
+			continue
 		}
-		stdout, stderr, err := tools.RunShell(ctx, cmd)
-		if err != nil {
-			return fmt.Sprintf("stdout: %s\nstderr: %s\nerror: %v", stdout, stderr, err), nil
+		if prefs == nil {
+			prefs = make(map[string]types.Potentials)
 		}
-		return fmt.Sprintf("stdout: %s\nstderr: %s", stdout, stderr), nil
-	case "glob":
-		root := tc.Root
-		if root == "" {
-			root = "."
+		prefs[t.Name()] = pots
+		slog.Info("[R3] tool preference from memory", "subtask", st.SubTaskID, "tool", t.Name(), "entity", entity, "action", pots.Action, "decision", pots.Decision)
+	}
+	return prefs
+}
+
+// orderByPreference moves Exploit tools to the front (strongest decision first)
+// and Avoid tools to the back, keeping registration order otherwise — the prompt
+// tells the model to use the first tool that fits.
+//
+// Expectations:
+//   - Returns ts unchanged in order when prefs is empty
+//   - Places Exploit tools first, by descending decision potential
+//   - Places Avoid tools last, in registration order
+func orderByPreference(ts []tools.Tool, prefs map[string]types.Potentials) []tools.Tool {
+	if len(prefs) == 0 {
+		return ts
+	}
+	rank := func(t tools.Tool) int {
+		switch prefs[t.Name()].Action {
+		case "Exploit":
+			return 0
+		case "Avoid":
+			return 2
 		}
-		matches, err := tools.GlobFiles(root, tc.Pattern)
-		if err != nil {
-			return "", err
+		return 1
+	}
+	out := append([]tools.Tool(nil), ts...)
+	sort.SliceStable(out, func(i, j int) bool {
+		ri, rj := rank(out[i]), rank(out[j])
+		if ri != rj {
+			return ri < rj
 		}
-		if len(matches) == 0 {
-			return "(no files matched pattern " + tc.Pattern + " under " + root + ")", nil
+		if ri == 0 {
+			return prefs[out[i].Name()].Decision > prefs[out[j].Name()].Decision
 		}
-		return tools.GlobJoin(matches), nil
-	case "applescript":
-		result, err := tools.RunAppleScript(ctx, tc.Script)
-		if err != nil {
-			return fmt.Sprintf("applescript error: %v", err), nil
+		return false
+	})
+	return out
+}
+
+// input returns the JSON handed to Tool.Run: the model's own call object, or the
+// marshalled known fields for calls built in code.
+func (tc toolCall) input() json.RawMessage {
+	if len(tc.raw) > 0 {
+		return tc.raw
+	}
+	b, _ := json.Marshal(tc)
+	return b
+}
+
+// runTool dispatches tc to the registered tool of that name, with env available
+// to shell-backed tools through tools.ShellEnvFrom.
+//
+// Expectations:
+//   - Returns the [PREFLIGHT] message without running when the tool is unavailable
+//   - Returns an "unknown tool" error when no tool of that name is registered
+//   - Runs custom tools registered in the executor's registry with the model's call JSON
+//   - Asks confirm before running a tool named in confirmTools, and returns the
+//     [DECLINED] message without running when the user says no
+//   - Never asks confirm for tools not named in confirmTools
+//   - Waits for a tools.Limits slot before running (after any confirm), and
+//     returns ctx's error without running when ctx ends while waiting
+//   - Frees the slot when the call returns, even if the tool panics
+//   - Returns the [POLICY] message without running (or asking) when a shell
+//     command has a fragment outside shellAllow
+//   - Returns the output's content type (tools.ContentTypeOf); ContentText for
+//     [PREFLIGHT], [POLICY], and [DECLINED] notices, "" with an error
+//   - Runs shell-backed tools without network access when noNetwork is set
+//   - Bounds each call by ToolTimeouts[tool] and, when that ceiling (not ctx)
+//     ends it, returns a "tool X timed out after D" error, which R4a classifies as environmental
+//   - Returns the [CIRCUIT] message without running when the breaker has opened
+//     the tool's circuit for the task in ctx (see withBreakerTask); otherwise
+//     records with the breaker whether the call failed environmentally (see environmentalFailure)
+func (e *Executor) runTool(ctx context.Context, tc toolCall, env tools.ShellEnv) (content, contentType string, err error) {
+	if msg := preflight(tc.Tool); msg != "" {
+		slog.Warn("[R3] preflight: tool unavailable", "tool", tc.Tool)
+		return msg, tools.ContentText, nil
+	}
+	t, ok := e.tools().Lookup(tc.Tool)
+	if !ok {
+		return "", "", fmt.Errorf("unknown tool: %s", tc.Tool)
+	}
+	taskID := breakerTask(ctx)
+	if open, failures := e.breaker.open(taskID, tc.Tool); open {
+		slog.Warn("[R3] tool circuit open", "task", taskID, "tool", tc.Tool, "failures", failures)
+		return circuitMessage(tc.Tool, failures), tools.ContentText, nil
+	}
+	if tc.Tool == "shell" {
+		if head := disallowedShellCommand(tc.Command, e.shellAllow); head != "" {
+			slog.Warn("[R3] shell command blocked by allow-list", "command", head)
+			return fmt.Sprintf("%s command not permitted: %q is not on the shell allow-list (%s). Use an allowed command or another tool.",
+				policyTag, head, formatShellAllowList(e.shellAllow)), tools.ContentText, nil
 		}
-		return result, nil
-	case "shortcuts":
-		result, err := tools.RunShortcut(ctx, tc.Name, tc.Input)
-		if err != nil {
-			return fmt.Sprintf("shortcuts error: %v", err), nil
+	}
+	if e.confirmTools[tc.Tool] || isMessageSend(tc) {
+		if e.confirm == nil {
+			slog.Warn("[R3] message send refused: no one to approve it", "tool", tc.Tool)
+			return fmt.Sprintf("%s sending needs the user's approval and none can be asked here. Do not retry; report the draft instead.", declinedTag), tools.ContentText, nil
+		}
+		if !e.confirm(ctx, tc.Tool, confirmDetail(tc)) {
+			slog.Info("[R3] tool call declined by user", "tool", tc.Tool)
+			return fmt.Sprintf("%s the user declined this %s call. Do not retry it; use another approach or report what you have.", declinedTag, tc.Tool), tools.ContentText, nil
 		}
-		return result, nil
+	}
+	release, err := e.toolLimits().Acquire(ctx, tc.Tool)
+	if err != nil {
+		return "", "", err
+	}
+	defer release() // even if the tool panics, so the slot is never lost
+	env.NoNetwork = env.NoNetwork || e.noNetwork
+	callCtx := ctx
+	timeout := e.ToolTimeouts[tc.Tool]
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	content, err = t.Run(tools.WithShellEnv(callCtx, env), tc.input())
+	timedOut := ctx.Err() == nil && errors.Is(callCtx.Err(), context.DeadlineExceeded)
+	if ctx.Err() == nil {
+		e.breaker.record(taskID, tc.Tool, environmentalFailure(tc.Tool, content, err, timedOut))
+	}
+	if timedOut {
+		slog.Warn("[R3] tool call timed out", "tool", tc.Tool, "timeout", timeout)
+		return "", "", fmt.Errorf("tool %s timed out after %s", tc.Tool, timeout)
+	}
+	if err != nil {
+		return content, "", err
+	}
+	return content, tools.ContentTypeOf(t, content), nil
+}
+
+// environmentalFailure reports whether a call failed because of the environment
+// rather than what the model asked for: the tool returned an error, its
+// ToolTimeouts ceiling stopped it, or it is a shell result ending with
+// environmentNote. A shell command that ran and exited non-zero is not one —
+// its output is the model's to act on — so it never opens the tool's circuit.
+//
+// Expectations:
+//   - Returns true for a tool error or a timeout
+//   - Returns true for a shell result ending with environmentNote
+//   - Returns false for a shell result that only reports a non-zero exit
+func environmentalFailure(tool, content string, err error, timedOut bool) bool {
+	return err != nil || timedOut || (tool == "shell" && strings.HasSuffix(content, environmentNote))
+}
+
+// declinedTag prefixes the synthetic tool result returned when the user declines
+// a call to a --confirm tool.
+const declinedTag = "[DECLINED]"
+
+// isMessageSend reports whether tc sends a drafted message. Sends always need
+// the user's approval, --confirm or not: the model must not approve its own
+// outbound messages by replaying the draft token.
+func isMessageSend(tc toolCall) bool {
+	return tc.Tool == "message" && strings.TrimSpace(tc.Token) != ""
+}
+
+// policyTag prefixes the synthetic tool result returned when a shell command
+// falls outside the ARTOO_SHELL_ALLOW allow-list.
+const policyTag = "[POLICY]"
+
+// confirmDetail is what the user is shown when asked to confirm tc: the input
+// that decides what the call does, or the whole call for other tools.
+func confirmDetail(tc toolCall) string {
+	switch tc.Tool {
+	case "shell":
+		return tc.Command
 	case "read_file":
-		return tools.ReadFile(tc.Path)
+		return tc.Path
 	case "write_file":
-		// Expand "~/" before path analysis so workspace-rooted paths are not misclassified.
-		writePath := tools.ExpandHome(tc.Path)
-		// Redirect bare filenames and "./" paths to the workspace so generated files
-		// (scripts, reports, data) never land in the project root or CWD.
-		if resolved, redirected := tools.ResolveOutputPath(writePath); redirected {
-			slog.Debug("[R3] write_file redirected to workspace", "from", tc.Path, "to", resolved)
-			writePath = resolved
-		}
-		if irreversible, reason := isIrreversibleWriteFile(writePath); irreversible {
-			return fmt.Sprintf("[LAW1] %s — write blocked. Re-issue the task with explicit permission to overwrite.", reason), nil
-		}
-		return "ok", tools.WriteFile(writePath, tc.Content)
-	case "search":
-		return tools.Search(ctx, tc.Query)
-	default:
-		return "", fmt.Errorf("unknown tool: %s", tc.Tool)
+		return fmt.Sprintf("%s (%d bytes)", writeFilePath(tc.Path), len(tc.Content))
+	case "applescript":
+		return tc.Script
+	case "shortcuts":
+		return tc.Name
+	case "message":
+		if tc.Token == "" {
+			return "draft " + tc.To
+		}
+		if d, ok := tools.DefaultMessenger.Lookup(tc.Token); ok {
+			return "send " + d.Summary()
+		}
+		return "send draft " + tc.Token
+	case "search", "mdfind":
+		return tc.Query
+	case "fetch_url":
+		return tc.URL
+	case "glob":
+		if tc.Root != "" {
+			return tc.Pattern + " in " + tc.Root
+		}
+		return tc.Pattern
+	case "grep":
+		detail := tc.Pattern
+		if tc.Glob != "" {
+			detail += " in " + tc.Glob
+		}
+		if tc.Root != "" {
+			detail += " under " + tc.Root
+		}
+		return detail
 	}
+	return string(tc.input())
 }
 
 func subTaskToJSON(st types.SubTask) string {
@@ -600,6 +2117,134 @@ func subTaskToJSON(st types.SubTask) string {
 	return string(b)
 }
 
+// defaultEvidenceLen is the tool-call evidence length when ARTOO_EVIDENCE_LEN is unset.
+const defaultEvidenceLen = 200
+
+// evidenceLenFromEnv returns the evidence length from ARTOO_EVIDENCE_LEN, falling
+// back to defaultEvidenceLen when unset or not a positive integer.
+//
+// Expectations:
+//   - Returns defaultEvidenceLen when ARTOO_EVIDENCE_LEN is unset
+//   - Returns the parsed value when ARTOO_EVIDENCE_LEN is a positive integer
+//   - Returns defaultEvidenceLen when the value is zero, negative, or not a number
+func evidenceLenFromEnv() int {
+	if v := os.Getenv("ARTOO_EVIDENCE_LEN"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultEvidenceLen
+}
+
+// verifyWritesFromEnv reports whether write_file results are verified, from
+// ARTOO_VERIFY_WRITES. Verification is on unless the variable parses as false.
+func verifyWritesFromEnv() bool {
+	on, err := strconv.ParseBool(os.Getenv("ARTOO_VERIFY_WRITES"))
+	return on || err != nil
+}
+
+// verifiedWriteTag prefixes a write_file result whose file was re-read after the write.
+const verifiedWriteTag = "ok, verified "
+
+// verifiedWrite re-reads the file a successful write_file call wrote and
+// returns the result R3 and R4a see: "ok, verified <path> (<n> bytes)" rather
+// than a bare "ok", so a criterion like "a report file was created" has evidence.
+//
+// Expectations:
+//   - Returns "ok, verified <abs path> (<n> bytes)" when the file holds tc.Content
+//   - Returns an error when the file is missing, empty, or differs in size or leading bytes
+func verifiedWrite(tc toolCall) (string, error) {
+	evidence, err := tools.VerifyWrite(writeFilePath(tc.Path), tc.Content)
+	if err != nil {
+		return "", err
+	}
+	return verifiedWriteTag + evidence, nil
+}
+
+// isWriteOK reports whether a write_file result means the file was written:
+// "ok", or "ok, verified …" when ARTOO_VERIFY_WRITES is on.
+func isWriteOK(result string) bool {
+	return result == "ok" || strings.HasPrefix(result, verifiedWriteTag)
+}
+
+// toolEvidence condenses a tool's output into the evidence snippet appended to its
+// tool_calls entry, so R4a can score criteria against what the tool really returned.
+// The condensing is tool-aware so the budget of n chars goes to the useful parts:
+// search keeps each result's title and URL (snippets are dropped); glob, mdfind
+// and grep keep whole lines. Everything else keeps the leading n chars.
+//
+// Expectations:
+//   - Never returns more than n chars of content (plus a truncation marker)
+//   - search: keeps title and URL lines, drops snippet lines
+//   - glob/mdfind/grep: keeps whole lines and notes how many lines were cut
+//   - Other tools: returns firstN of the trimmed output
+func toolEvidence(tool, output string, n int) string {
+	output = strings.TrimSpace(output)
+	switch tool {
+	case "search":
+		var kept []string
+		for _, block := range strings.Split(output, "\n\n") {
+			lines := strings.Split(strings.TrimSpace(block), "\n")
+			if len(lines) >= 2 {
+				kept = append(kept, lines[0]+" "+lines[len(lines)-1])
+			} else {
+				kept = append(kept, lines[0])
+			}
+		}
+		return firstN(strings.Join(kept, "\n"), n)
+	case "glob", "mdfind", "grep":
+		return wholeLines(output, n)
+	}
+	return firstN(output, n)
+}
+
+// taggedEvidence prefixes evidence with its content type, e.g. "[json] {...}", so
+// R4a can score it by format. Plain text is left untagged.
+//
+// Expectations:
+//   - Returns evidence unchanged for ContentText and ""
+//   - Returns "[<type>] " + evidence for json, paths, and table
+func taggedEvidence(contentType, evidence string) string {
+	if contentType == "" || contentType == tools.ContentText {
+		return evidence
+	}
+	return "[" + contentType + "] " + evidence
+}
+
+// contentTag is the " (<type>)" suffix for a tool result header in R3's prompt;
+// "" for plain text.
+func contentTag(contentType string) string {
+	if contentType == "" || contentType == tools.ContentText {
+		return ""
+	}
+	return " (" + contentType + ")"
+}
+
+// wholeLines returns as many complete lines of s as fit in n chars, followed by a
+// count of the lines left out. A first line longer than n is cut with firstN.
+func wholeLines(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	lines := strings.Split(s, "\n")
+	used, kept := 0, 0
+	for _, l := range lines {
+		need := len(l)
+		if kept > 0 {
+			need++ // newline separator
+		}
+		if used+need > n {
+			break
+		}
+		used += need
+		kept++
+	}
+	if kept == 0 {
+		return firstN(lines[0], n) + fmt.Sprintf(" (+%d more)", len(lines)-1)
+	}
+	return strings.Join(lines[:kept], "\n") + fmt.Sprintf("\n... (+%d more)", len(lines)-kept)
+}
+
 func firstN(s string, n int) string {
 	if len(s) <= n {
 		return s
@@ -1,10 +1,28 @@
 package executor
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/llm"
+	"github.com/haricheung/agentic-shell/internal/roles/memory"
+	"github.com/haricheung/agentic-shell/internal/tools"
+	"github.com/haricheung/agentic-shell/internal/types"
 )
 
 // ── isIrreversibleShell ───────────────────────────────────────────────────────
@@ -223,6 +241,100 @@ func TestSplitShellFragments_EmptyFragmentsDropped(t *testing.T) {
 	}
 }
 
+func TestSplitShellFragments_SkipsHeredocBodies(t *testing.T) {
+	// Returns no fragments for the body of a here-doc, which ends at the line holding only
+	// the marker (after leading tabs for <<-); statements after that line are split as usual
+	for _, cmd := range []string{
+		"cat <<'EOF' > notes.sh\nrm -rf build\nEOF\necho done",
+		"cat <<\"EOF\" > notes.sh\nrm -rf build\nEOF\necho done",
+		"cat <<EOF > notes.sh\nrm -rf build; echo $HOME\nEOF\necho done",
+		"cat <<-EOF > notes.sh\n\trm -rf build\n\tEOF\necho done",
+		"cat << \\EOF > notes.sh\nrm -rf build\nEOF\necho done",
+	} {
+		got := splitShellFragments(cmd)
+		if len(got) != 2 || !strings.HasPrefix(got[0], "cat <<") || got[1] != "echo done" {
+			t.Errorf("%q: got %q, want the cat statement and echo done", cmd, got)
+		}
+	}
+}
+
+func TestSplitShellFragments_HeredocEdgeCases(t *testing.T) {
+	// Treats <<< as a here-string and << inside (( ... )) as a shift; two here-docs on one
+	// line are skipped in order; an unterminated body runs to the end
+	cases := map[string][]string{
+		"grep x <<< \"a; b\"; rm f":               {"grep x <<< \"a; b\"", "rm f"},
+		"(( y = x << 2 ))\nrm f":                  {"y = x << 2", "rm f"},
+		"paste <<A <<B\nrm one\nA\nrm two\nB\nls": {"paste <<A <<B", "ls"},
+		"cat <<EOF\nrm -rf build":                 {"cat <<EOF"},
+	}
+	for cmd, want := range cases {
+		if got := splitShellFragments(cmd); !slices.Equal(got, want) {
+			t.Errorf("%q: got %q, want %q", cmd, got, want)
+		}
+	}
+}
+
+func TestIsIrreversibleShell_Heredocs(t *testing.T) {
+	// Returns false when rm only appears in a here-doc body or quoted text; true for a real rm
+	// after the marker line, in a compound command, or in $(...) inside an unquoted-marker body
+	safe := []string{
+		"cat <<'EOF' > cleanup.sh\n#!/bin/sh\nrm -rf ./build\nEOF",
+		"cat > README.md <<EOF\nRun \"rm -rf build\" to clean.\nEOF\nls -l README.md",
+		"python3 - <<'PY'\nimport os\nos.system('ls')\nPY",
+		`echo "rm -rf /tmp/x"`,
+		`echo 'ls; rm b'`,
+	}
+	for _, cmd := range safe {
+		if ok, reason := isIrreversibleShell(cmd); ok {
+			t.Errorf("%q: flagged (%s), want false", cmd, reason)
+		}
+	}
+	unsafe := []string{
+		"cat <<'EOF' > cleanup.sh\nrm -rf ./build\nEOF\nrm -rf ./dist",
+		"cat <<EOF\ncleaned: $(rm -rf ./build)\nEOF",
+		"cat <<EOF\ncleaned: `rm -rf ./build`\nEOF",
+		"mkdir -p out && cat > out/a <<EOF\nx\nEOF\nls out && rm out/a",
+	}
+	for _, cmd := range unsafe {
+		if ok, _ := isIrreversibleShell(cmd); !ok {
+			t.Errorf("%q: not flagged, want true", cmd)
+		}
+	}
+}
+
+func TestIsIrreversibleShell_HeredocRunByShell(t *testing.T) {
+	// Returns true for rm in a here-doc body run by a shell or interpreter (bash <<EOF, sh -s <<'EOF', cat <<EOF | sh)
+	for _, cmd := range []string{
+		"bash <<'EOF'\nrm -rf ~/Documents\nEOF",
+		"bash <<EOF\necho start\nrm -rf ~/Documents\nEOF",
+		"sh -s <<EOF\nrm -rf ~/Documents\nEOF",
+		"sudo -s <<EOF\nrm -rf /var/data\nEOF",
+		"env FOO=1 zsh <<-EOF\n\tfor f in *; do rm \"$f\"; done\n\tEOF",
+		"cat <<'EOF' | sh\nrm -rf ~/Documents\nEOF",
+		"ssh host <<'EOF'\nrm -rf /srv/app\nEOF",
+	} {
+		if ok, _ := isIrreversibleShell(cmd); !ok {
+			t.Errorf("%q: not flagged, want true", cmd)
+		}
+	}
+}
+
+func TestRunsScript(t *testing.T) {
+	// Returns true for shells, eval, ssh, xargs, sudo, su, and interpreters (python*, perl, ruby, node, …);
+	// true for a wrapped runner (sudo bash, env sh -s) and bare "sudo -s"; false for data consumers
+	for _, frag := range []string{"bash", "sh -s", "/bin/zsh", "eval", "ssh host", "xargs -0", "sudo -s",
+		"sudo bash", "env sh -s", "FOO=1 bash", "python3 -", "python3.12", "perl", "node", "ruby"} {
+		if !runsScript(frag) {
+			t.Errorf("runsScript(%q) = false, want true", frag)
+		}
+	}
+	for _, frag := range []string{"cat <<EOF", "tee out.txt", "grep foo", "wc -l", "cat > a.py"} {
+		if runsScript(frag) {
+			t.Errorf("runsScript(%q) = true, want false", frag)
+		}
+	}
+}
+
 func TestIsIrreversibleShell_ReturnsFalseForReadOnlyCommands(t *testing.T) {
 	// Returns false for read-only commands
 	readOnly := []string{
@@ -241,6 +353,145 @@ func TestIsIrreversibleShell_ReturnsFalseForReadOnlyCommands(t *testing.T) {
 	}
 }
 
+func TestSplitShellFragments_QuotedSeparatorsDoNotSplit(t *testing.T) {
+	// Does not split inside single or double quotes or on backslash-escaped separators
+	cases := map[string]string{
+		`echo "a; rm b"`:                  `echo "a; rm b"`,
+		`echo 'a && rm b'`:                `echo 'a && rm b'`,
+		`find . -name x -exec echo {} \;`: `find . -name x -exec echo {} \;`,
+	}
+	for cmd, want := range cases {
+		got := splitShellFragments(cmd)
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("splitShellFragments(%q) = %v, want [%q]", cmd, got, want)
+		}
+	}
+}
+
+func TestSplitShellFragments_RedirectionIsNotASeparator(t *testing.T) {
+	// Does not split on "&" or "|" that are part of a redirection (2>&1, &>, >|)
+	for _, cmd := range []string{"make 2>&1", "make &> log.txt", "echo x >| out.txt"} {
+		if got := splitShellFragments(cmd); len(got) != 1 {
+			t.Errorf("splitShellFragments(%q) = %v, want one fragment", cmd, got)
+		}
+	}
+}
+
+func TestSplitShellFragments_ExtractsCommandSubstitutions(t *testing.T) {
+	// Returns the bodies of $(...), `...`, <(...), >(...) as extra fragments, also inside double quotes
+	cases := map[string]string{
+		`echo $(rm -rf x)`:            "rm -rf x",
+		"echo `rm -rf x`":             "rm -rf x",
+		`echo "today: $(rm x)"`:       "rm x",
+		`diff <(rm a) b`:              "rm a",
+		`echo $(echo $(rm -rf deep))`: "rm -rf deep",
+	}
+	for cmd, want := range cases {
+		got := splitShellFragments(cmd)
+		found := false
+		for _, f := range got {
+			if f == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("splitShellFragments(%q) = %v, want a fragment %q", cmd, got, want)
+		}
+	}
+}
+
+func TestSplitShellFragments_IgnoresSubstitutionInSingleQuotes(t *testing.T) {
+	// Does not look inside single-quoted text
+	got := splitShellFragments(`echo '$(rm -rf x)'`)
+	if len(got) != 1 {
+		t.Errorf("expected a single echo fragment, got %v", got)
+	}
+}
+
+func TestIsIrreversibleShell_CatchesCommandSubstitution(t *testing.T) {
+	// Returns true for rm inside command substitution ($(...), backticks), even within double quotes
+	for _, cmd := range []string{
+		`echo $(rm -rf /tmp/x)`,
+		"echo `rm -rf /tmp/x`",
+		`echo "done: $(rm -rf /tmp/x)"`,
+		`ls $(find /tmp -name '*.log' -delete)`,
+		`if rm /tmp/x; then echo ok; fi`,
+		`(rm -rf /tmp/x)`,
+		`sleep 1 & rm /tmp/x`,
+	} {
+		if ok, _ := isIrreversibleShell(cmd); !ok {
+			t.Errorf("expected true for %q", cmd)
+		}
+	}
+}
+
+func TestIsIrreversibleShell_CatchesWrappersAndQuoting(t *testing.T) {
+	// Returns true for rm behind wrappers or quoting (\rm, "rm", /bin/rm, env rm, sh -c 'rm ...', eval)
+	for _, cmd := range []string{
+		`\rm -rf /tmp/x`,
+		`"rm" -rf /tmp/x`,
+		`/bin/rm -rf /tmp/x`,
+		`env rm /tmp/x`,
+		`FORCE=1 rm /tmp/x`,
+		`sudo -E FOO=1 rm /tmp/x`,
+		`sh -c 'rm -rf /tmp/x'`,
+		`bash -lc "ls; rm /tmp/x"`,
+		`eval "rm -rf /tmp/x"`,
+	} {
+		if ok, _ := isIrreversibleShell(cmd); !ok {
+			t.Errorf("expected true for %q", cmd)
+		}
+	}
+}
+
+func TestIsIrreversibleShell_QuotedTextIsNotACommand(t *testing.T) {
+	// Returns false when rm only appears as quoted text (echo "rm -rf /", echo 'a; rm b')
+	for _, cmd := range []string{
+		`echo "rm -rf /"`,
+		`echo 'a; rm b'`,
+		`grep -n "rm -rf" script.sh`,
+		`printf '%s\n' '$(rm x)'`,
+		`git commit -m "cleanup; rm unused files"`,
+	} {
+		if ok, reason := isIrreversibleShell(cmd); ok {
+			t.Errorf("expected false for %q, got true (reason: %s)", cmd, reason)
+		}
+	}
+}
+
+func FuzzIsIrreversibleShell(f *testing.F) {
+	for _, seed := range []string{
+		"rm -rf /tmp/x", "echo $(rm x)", "echo `rm x`", `echo "rm -rf /"`,
+		`echo 'a; rm b'`, `sh -c 'rm x'`, `find . -delete`, "ls | xargs rm",
+		`echo "$(echo '$(rm x)')"`, `\`, `"`, `$(`, "`", "a && b || c ; d | e & f",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		ok, reason := isIrreversibleShell(s)
+		if ok2, reason2 := isIrreversibleShell(s); ok2 != ok || reason2 != reason {
+			t.Fatalf("non-deterministic result for %q", s)
+		}
+		for _, frag := range splitShellFragments(s) {
+			if frag == "" || frag != strings.TrimSpace(frag) {
+				t.Fatalf("fragment %q of %q is empty or untrimmed", frag, s)
+			}
+		}
+		// Single-quoted text is inert: it is never a command.
+		if !strings.Contains(s, "'") {
+			if ok, reason := isIrreversibleShell("echo '" + s + "'"); ok {
+				t.Fatalf("single-quoted %q flagged: %s", s, reason)
+			}
+		}
+		// Wrapping a destructive command in $(...) must not hide it.
+		if ok && !strings.ContainsAny(s, "()'\"`\\") {
+			if wrapped, _ := isIrreversibleShell("echo $(" + s + ")"); !wrapped {
+				t.Fatalf("%q is irreversible but echo $(%s) is not", s, s)
+			}
+		}
+	})
+}
+
 // ── isIrreversibleWriteFile ───────────────────────────────────────────────────
 
 func TestIsIrreversibleWriteFile_ReturnsTrueWhenFileExists(t *testing.T) {
@@ -308,3 +559,1999 @@ func TestHeadTail_TruncatesMiddleOfLongString(t *testing.T) {
 		t.Error("expected truncation marker in result")
 	}
 }
+
+// ── preflight / runTool ───────────────────────────────────────────────────────
+
+// stubAvailability makes toolAvailable report every tool in missing as unavailable.
+func stubAvailability(t *testing.T, missing ...string) {
+	t.Helper()
+	orig := toolAvailable
+	t.Cleanup(func() { toolAvailable = orig })
+	toolAvailable = func(tool string) (bool, string) {
+		for _, m := range missing {
+			if m == tool {
+				return false, tool + ": executable file not found in $PATH"
+			}
+		}
+		return true, ""
+	}
+}
+
+func TestPreflight_EmptyWhenAvailable(t *testing.T) {
+	// Returns "" when the tool is available
+	stubAvailability(t)
+	if msg := preflight("shell"); msg != "" {
+		t.Errorf("expected empty preflight message, got %q", msg)
+	}
+}
+
+func TestPreflight_MessageNamesUnavailableTool(t *testing.T) {
+	// Returns a message starting with "[PREFLIGHT]" that names the tool when it is unavailable
+	stubAvailability(t, "applescript")
+	msg := preflight("applescript")
+	if !strings.HasPrefix(msg, preflightTag) {
+		t.Fatalf("expected %s prefix, got %q", preflightTag, msg)
+	}
+	if !strings.Contains(msg, "applescript is not available in this environment") {
+		t.Errorf("expected message to name the tool, got %q", msg)
+	}
+}
+
+func TestPreflight_SuggestsOnlyAvailableFallbacks(t *testing.T) {
+	// Suggests only fallbacks that are themselves available
+	stubAvailability(t, "shortcuts", "applescript")
+	msg := preflight("shortcuts")
+	if !strings.Contains(msg, "try shell instead") {
+		t.Errorf("expected only shell to be suggested, got %q", msg)
+	}
+}
+
+func TestPreflight_NoAvailableFallback(t *testing.T) {
+	// Falls back to "a different tool" when no listed substitute is available
+	stubAvailability(t, "applescript", "shell")
+	msg := preflight("applescript")
+	if !strings.Contains(msg, "try a different tool") {
+		t.Errorf("expected generic suggestion, got %q", msg)
+	}
+}
+
+func TestRunTool_UnavailableToolReturnsPreflightMessage(t *testing.T) {
+	// An unavailable tool yields the preflight message without being attempted
+	stubAvailability(t, "applescript")
+	e := &Executor{}
+	out, _, err := e.runTool(t.Context(), toolCall{Tool: "applescript", Script: `display dialog "hi"`}, tools.ShellEnv{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(out, preflightTag) {
+		t.Errorf("expected preflight message, got %q", out)
+	}
+}
+
+func TestRunTool_AvailableToolProceeds(t *testing.T) {
+	// An available tool passes preflight and runs normally
+	stubAvailability(t, "applescript")
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e := &Executor{}
+	out, _, err := e.runTool(t.Context(), toolCall{Tool: "glob", Pattern: "*.txt", Root: dir}, tools.ShellEnv{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.HasPrefix(out, preflightTag) || !strings.Contains(out, "notes.txt") {
+		t.Errorf("expected glob result, got %q", out)
+	}
+}
+
+func TestRunTool_ReadFileLocalAndURL(t *testing.T) {
+	// read_file returns content for a local path and for an http URL alike
+	stubAvailability(t)
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(path, []byte("local notes"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "remote notes")
+	}))
+	defer srv.Close()
+	e := &Executor{}
+	for target, want := range map[string]string{path: "local notes", srv.URL + "/notes.txt": "remote notes"} {
+		out, _, err := e.runTool(t.Context(), toolCall{Tool: "read_file", Path: target}, tools.ShellEnv{})
+		if err != nil || out != want {
+			t.Errorf("read_file %s = %q, %v; want %q", target, out, err, want)
+		}
+	}
+}
+
+func TestRunTool_GrepSearchesContentsUnderGlob(t *testing.T) {
+	// grep returns "file:line: text" for matching lines in files matching glob, and a notice when nothing matches
+	stubAvailability(t)
+	dir := t.TempDir()
+	for name, body := range map[string]string{"main.go": "package main\n\nfunc main() {}\n", "notes.txt": "func main in prose\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := &Executor{}
+	out, contentType, err := e.runTool(t.Context(), toolCall{Tool: "grep", Pattern: `func main`, Root: dir, Glob: "*.go"}, tools.ShellEnv{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := filepath.Join(dir, "main.go") + ":3: func main() {}"; out != want {
+		t.Errorf("grep = %q, want %q", out, want)
+	}
+	if contentType != tools.ContentText {
+		t.Errorf("content type = %q, want text", contentType)
+	}
+	out, _, err = e.runTool(t.Context(), toolCall{Tool: "grep", Pattern: `no such text`, Root: dir}, tools.ShellEnv{})
+	if err != nil || !strings.HasPrefix(out, "(no lines matched") {
+		t.Errorf("no-match grep = %q, %v", out, err)
+	}
+}
+
+func TestRunTool_NoNetworkShellFailureCarriesNote(t *testing.T) {
+	// A failed shell call under noNetwork ends with the [NO-NETWORK] note; without noNetwork it does not
+	stubAvailability(t)
+	for _, noNetwork := range []bool{true, false} {
+		e := &Executor{noNetwork: noNetwork}
+		out, _, err := e.runTool(t.Context(), toolCall{Tool: "shell", Command: "exit 3"}, tools.ShellEnv{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := strings.Contains(out, tools.NoNetworkTag); got != noNetwork {
+			t.Errorf("noNetwork=%v: note present = %v in %q", noNetwork, got, out)
+		}
+	}
+}
+
+func TestRunTool_WriteFileIdenticalContentIsNoOp(t *testing.T) {
+	// Rewriting byte-identical content succeeds without touching the file; different content is still blocked
+	stubAvailability(t)
+	path := filepath.Join(t.TempDir(), "report.md")
+	if err := os.WriteFile(path, []byte("# Report\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	e := &Executor{}
+	out, _, err := e.runTool(t.Context(), toolCall{Tool: "write_file", Path: path, Content: "# Report\n"}, tools.ShellEnv{})
+	if err != nil || out != "ok" {
+		t.Fatalf("identical write = %q, %v; want ok", out, err)
+	}
+	if info, _ := os.Stat(path); !info.ModTime().Equal(old) {
+		t.Errorf("identical write should not rewrite the file (mtime %v, want %v)", info.ModTime(), old)
+	}
+	out, _, err = e.runTool(t.Context(), toolCall{Tool: "write_file", Path: path, Content: "# Report v2\n"}, tools.ShellEnv{})
+	if err != nil || !strings.HasPrefix(out, "[LAW1]") {
+		t.Errorf("different write = %q, %v; want a LAW1 block", out, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "# Report\n" {
+		t.Errorf("blocked write changed the file to %q", data)
+	}
+}
+
+// ── shellEnv ─────────────────────────────────────────────────────────────────
+
+func TestShellEnv_DefaultInherits(t *testing.T) {
+	// A subtask with neither Env nor EnvClear inherits the full process environment
+	env := shellEnv(types.SubTask{})
+	if env.Clear || len(env.Vars) != 0 {
+		t.Errorf("expected zero ShellEnv, got %+v", env)
+	}
+}
+
+func TestRunTool_ShellUsesSubTaskEnv(t *testing.T) {
+	// A subtask with EnvClear and one var runs shell with only that var present
+	t.Setenv("ARTOO_TEST_SECRET", "leak")
+	st := types.SubTask{Env: map[string]string{"ONLY_VAR": "42"}, EnvClear: true}
+	e := &Executor{}
+	out, _, err := e.runTool(t.Context(), toolCall{Tool: "shell", Command: "env"}, shellEnv(st))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out, "ONLY_VAR=42") {
+		t.Errorf("expected ONLY_VAR in shell env, got %q", out)
+	}
+	if strings.Contains(out, "ARTOO_TEST_SECRET") || strings.Contains(out, "HOME=") {
+		t.Errorf("expected inherited vars to be cleared, got %q", out)
+	}
+}
+
+// ── confirm ──────────────────────────────────────────────────────────────────
+
+// recordConfirm returns a ConfirmFunc answering allow and the tools it was asked about.
+func recordConfirm(allow bool) (ConfirmFunc, *[]string) {
+	var mu sync.Mutex
+	var asked []string
+	return func(_ context.Context, tool, detail string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		asked = append(asked, tool+": "+detail)
+		return allow
+	}, &asked
+}
+
+func TestRunTool_ConfirmsOnlyConfiguredTools(t *testing.T) {
+	// Never asks confirm for tools not named in confirmTools
+	confirm, asked := recordConfirm(true)
+	e := NewWithConfirm(nil, nil, nil, tools.Default, []string{"shell", "write_file"}, confirm)
+	dir := t.TempDir()
+	if _, _, err := e.runTool(t.Context(), toolCall{Tool: "glob", Pattern: "*.txt", Root: dir}, tools.ShellEnv{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*asked) != 0 {
+		t.Fatalf("expected glob to run without confirmation, asked %v", *asked)
+	}
+	out, _, err := e.runTool(t.Context(), toolCall{Tool: "shell", Command: "echo confirmed"}, tools.ShellEnv{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*asked) != 1 || (*asked)[0] != "shell: echo confirmed" {
+		t.Errorf("expected one confirmation for the shell command, got %v", *asked)
+	}
+	if !strings.Contains(out, "confirmed") {
+		t.Errorf("expected the approved command to run, got %q", out)
+	}
+}
+
+func TestRunTool_DeclinedCallDoesNotRun(t *testing.T) {
+	// Asks confirm before running a tool named in confirmTools, and returns the [DECLINED] message without running when the user says no
+	confirm, asked := recordConfirm(false)
+	e := NewWithConfirm(nil, nil, nil, tools.Default, []string{"write_file"}, confirm)
+	path := filepath.Join(t.TempDir(), "out.txt")
+	out, _, err := e.runTool(t.Context(), toolCall{Tool: "write_file", Path: path, Content: "data"}, tools.ShellEnv{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*asked) != 1 || !strings.HasPrefix(out, declinedTag) {
+		t.Errorf("expected one confirmation and a declined result, got asked=%v out=%q", *asked, out)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the declined write not to create %s", path)
+	}
+}
+
+// ── shell allow-list ─────────────────────────────────────────────────────────
+
+func TestRunTool_AllowListBlocksCompoundWithOneDisallowedFragment(t *testing.T) {
+	// Returns the [POLICY] message without running when any fragment of a compound command is not allowed
+	marker := filepath.Join(t.TempDir(), "ran")
+	e := &Executor{shellAllow: ParseShellAllowList("echo, ls")}
+	cmd := "echo start && touch " + marker + " ; ls"
+	out, ct, err := e.runTool(t.Context(), toolCall{Tool: "shell", Command: cmd}, tools.ShellEnv{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(out, policyTag+" command not permitted") || !strings.Contains(out, "touch") {
+		t.Errorf("expected a [POLICY] result naming the touch fragment, got %q", out)
+	}
+	if ct != tools.ContentText {
+		t.Errorf("content type = %q, want %q", ct, tools.ContentText)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("expected the blocked command not to run")
+	}
+}
+
+func TestRunTool_AllowListRunsAllAllowedCompound(t *testing.T) {
+	// Runs the command when every fragment starts with an allowed prefix
+	e := &Executor{shellAllow: ParseShellAllowList("echo,printf")}
+	out, _, err := e.runTool(t.Context(), toolCall{Tool: "shell", Command: "echo one && printf 'two\\n' | echo three"}, tools.ShellEnv{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.HasPrefix(out, policyTag) || !strings.Contains(out, "one") {
+		t.Errorf("expected the allowed command to run, got %q", out)
+	}
+}
+
+func TestDisallowedShellCommand(t *testing.T) {
+	allow := ParseShellAllowList(" git status , ls,, grep ")
+	cases := []struct {
+		cmd  string
+		want string
+	}{
+		{"ls -la | grep go", ""},                         // every fragment allowed
+		{"git status --short", ""},                       // multi-word prefix matches
+		{"git push origin main", "git push origin main"}, // other subcommand of a prefixed tool
+		{"ls; rm -rf /tmp/x", "rm -rf /tmp/x"},           // second statement blocked
+		{"ls $(whoami)", "whoami"},                       // substitutions are checked too
+		{"for f in *; do ls $f; done", ""},               // loop grammar is not a command
+		{"FOO=1 env ls", "FOO=1 env ls"},                 // assignments are rejected
+	}
+	for _, c := range cases {
+		if got := disallowedShellCommand(c.cmd, allow); got != c.want {
+			t.Errorf("disallowedShellCommand(%q) = %q, want %q", c.cmd, got, c.want)
+		}
+	}
+	if got := disallowedShellCommand("rm -rf /", nil); got != "" {
+		t.Errorf("empty allow-list should permit everything, got %q", got)
+	}
+}
+
+func TestDisallowedShellCommand_RejectsBypasses(t *testing.T) {
+	// Rejects VAR=value assignments, path-qualified commands, a wrapper or wrapped command
+	// that is not allowed, and a wrapper with no command to run (sudo -s)
+	allow := ParseShellAllowList("ls,sudo,env,nice,timeout,command")
+	for _, cmd := range []string{
+		"LD_PRELOAD=/tmp/x.so ls", "PATH=/tmp/evil ls", "PATH=/tmp/evil; ls",
+		"./ls", "/tmp/evil/ls -la",
+		"sudo rm -rf /", "env rm x", "env LD_PRELOAD=x ls", "nice -n 5 rm x", "timeout 5 rm x",
+		"command rm x", "sudo -s", "sudo -u root", "nohup ls",
+	} {
+		if got := disallowedShellCommand(cmd, allow); got == "" {
+			t.Errorf("disallowedShellCommand(%q) = \"\", want it rejected", cmd)
+		}
+	}
+	for _, cmd := range []string{"ls -la", "sudo ls", "sudo -u root ls /root", "env -i ls", "nice -n 5 ls", "timeout 5 ls", "timeout -s KILL 5 ls", "command -- ls"} {
+		if got := disallowedShellCommand(cmd, allow); got != "" {
+			t.Errorf("disallowedShellCommand(%q) = %q, want it allowed", cmd, got)
+		}
+	}
+}
+
+func TestDisallowedShellCommand_HeredocRunByShell(t *testing.T) {
+	// A here-doc body run by an allowed shell is checked statement by statement
+	allow := ParseShellAllowList("cat,bash")
+	if got := disallowedShellCommand("bash <<'EOF'\nrm -rf ~/Documents\nEOF", allow); got != "rm -rf ~/Documents" {
+		t.Errorf("bash here-doc: got %q, want the rm statement", got)
+	}
+	if got := disallowedShellCommand("cat <<'EOF'\nrm -rf ~/Documents\nEOF", allow); got != "" {
+		t.Errorf("cat here-doc: got %q, want the body ignored as data", got)
+	}
+}
+
+func TestRunTool_ReturnsContentType(t *testing.T) {
+	// Returns the output's content type: read_file of a JSON file is json, glob is
+	// a path list, shell is text. (There is no read_data tool; read_file covers it.)
+	dir := t.TempDir()
+	data := filepath.Join(dir, "weather.json")
+	if err := os.WriteFile(data, []byte(`{"city":"Beijing","temp":21}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	e := &Executor{}
+	cases := []struct {
+		tc   toolCall
+		want string
+	}{
+		{toolCall{Tool: "read_file", Path: data}, tools.ContentJSON},
+		{toolCall{Tool: "glob", Pattern: "*.json", Root: dir}, tools.ContentPaths},
+		{toolCall{Tool: "glob", Pattern: "*.csv", Root: dir}, tools.ContentText}, // "(no files matched ...)" notice
+		{toolCall{Tool: "shell", Command: "cat " + data}, tools.ContentText},
+	}
+	for _, c := range cases {
+		_, ct, err := e.runTool(t.Context(), c.tc, tools.ShellEnv{})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.tc.Tool, err)
+		}
+		if ct != c.want {
+			t.Errorf("%s %s%s: content type = %q, want %q", c.tc.Tool, c.tc.Path, c.tc.Pattern, ct, c.want)
+		}
+	}
+}
+
+func TestTaggedEvidence_TagsStructuredOutputOnly(t *testing.T) {
+	// Plain text is untagged; json, paths, and table evidence is prefixed with its type
+	if got := taggedEvidence(tools.ContentText, "done"); got != "done" {
+		t.Errorf("text: got %q", got)
+	}
+	if got := taggedEvidence("", "done"); got != "done" {
+		t.Errorf("empty type: got %q", got)
+	}
+	if got := taggedEvidence(tools.ContentPaths, "/tmp/a.txt"); got != "[paths] /tmp/a.txt" {
+		t.Errorf("paths: got %q", got)
+	}
+}
+
+func TestNewWithConfirm_UsesGivenRegistry(t *testing.T) {
+	// Creates an Executor over reg, whose tools are the ones confirmed and dispatched
+	confirm, asked := recordConfirm(true)
+	queries := &[]string{}
+	reg := tools.NewRegistry()
+	if err := reg.Register(scriptedSearch{queries: queries, results: map[string]string{"go release": "scripted"}}); err != nil {
+		t.Fatal(err)
+	}
+	e := NewWithConfirm(nil, nil, nil, reg, []string{"search"}, confirm)
+	out, _, err := e.runTool(t.Context(), toolCall{Tool: "search", Query: "go release"}, tools.ShellEnv{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*asked) != 1 || len(*queries) != 1 || !strings.Contains(out, "scripted") {
+		t.Errorf("expected the confirmed call to reach reg's search, asked %v, queries %v, out %q", *asked, *queries, out)
+	}
+}
+
+func TestNewWithConfirm_NoToolsNeverAsks(t *testing.T) {
+	// Never asks confirm for tools not named in confirmTools
+	confirm, asked := recordConfirm(false)
+	e := NewWithConfirm(nil, nil, nil, tools.Default, nil, confirm)
+	if _, _, err := e.runTool(t.Context(), toolCall{Tool: "shell", Command: "true"}, tools.ShellEnv{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*asked) != 0 {
+		t.Errorf("expected no confirmation, got %v", *asked)
+	}
+}
+
+func TestRunTool_MessageSendNeedsUserApproval(t *testing.T) {
+	// Asks confirm before every message send, --confirm or not; refuses the send when
+	// there is no confirm or the user declines, leaving the draft unsent
+	stubAvailability(t)
+	d, err := tools.DefaultMessenger.Draft("email", "bob@example.com", "Summary", "All done.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := toolCall{Tool: "message", Token: d.Token}
+
+	out, _, err := New(nil, nil, nil).runTool(t.Context(), send, tools.ShellEnv{})
+	if err != nil || !strings.HasPrefix(out, declinedTag) || !strings.Contains(out, "approval") {
+		t.Errorf("send without a confirm = %q, %v; want a [DECLINED] refusal", out, err)
+	}
+
+	confirm, asked := recordConfirm(false)
+	e := NewWithConfirm(nil, nil, nil, tools.Default, nil, confirm)
+	if out, _, _ := e.runTool(t.Context(), toolCall{Tool: "message", To: "bob@example.com", Content: "x"}, tools.ShellEnv{}); !strings.HasPrefix(out, "DRAFT") || len(*asked) != 0 {
+		t.Errorf("draft = %q, asked %v; want a draft without asking", out, *asked)
+	}
+	out, _, _ = e.runTool(t.Context(), send, tools.ShellEnv{})
+	if !strings.HasPrefix(out, declinedTag) || len(*asked) != 1 || !strings.Contains((*asked)[0], "bob@example.com") {
+		t.Errorf("declined send = %q, asked %v; want one question naming the recipient", out, *asked)
+	}
+	if _, ok := tools.DefaultMessenger.Lookup(d.Token); !ok {
+		t.Error("refused sends must not consume the draft")
+	}
+}
+
+func TestParseConfirmTools_TrimsAndSkipsEmpty(t *testing.T) {
+	// Trims spaces and skips empty items ("shell, write_file," → [shell write_file])
+	got, err := ParseConfirmTools("shell, write_file,", tools.Default)
+	if err != nil || !slices.Equal(got, []string{"shell", "write_file"}) {
+		t.Errorf("got %v, %v", got, err)
+	}
+	if got, err := ParseConfirmTools("  ", tools.Default); err != nil || got != nil {
+		t.Errorf("expected nil for a blank list, got %v, %v", got, err)
+	}
+}
+
+func TestParseConfirmTools_UnknownToolIsError(t *testing.T) {
+	// Returns an error naming any tool that is not registered in reg
+	_, err := ParseConfirmTools("shell,rm", tools.Default)
+	if err == nil || !strings.Contains(err.Error(), `"rm"`) {
+		t.Errorf("expected an error naming rm, got %v", err)
+	}
+}
+
+// ── evidenceLenFromEnv / toolEvidence ────────────────────────────────────────
+
+func TestEvidenceLenFromEnv_DefaultWhenUnset(t *testing.T) {
+	// Returns defaultEvidenceLen when ARTOO_EVIDENCE_LEN is unset
+	t.Setenv("ARTOO_EVIDENCE_LEN", "")
+	if got := evidenceLenFromEnv(); got != defaultEvidenceLen {
+		t.Errorf("expected %d, got %d", defaultEvidenceLen, got)
+	}
+}
+
+func TestEvidenceLenFromEnv_ParsesPositiveInteger(t *testing.T) {
+	// Returns the parsed value when ARTOO_EVIDENCE_LEN is a positive integer
+	t.Setenv("ARTOO_EVIDENCE_LEN", "500")
+	if got := evidenceLenFromEnv(); got != 500 {
+		t.Errorf("expected 500, got %d", got)
+	}
+}
+
+func TestEvidenceLenFromEnv_RejectsInvalid(t *testing.T) {
+	// Returns defaultEvidenceLen when the value is zero, negative, or not a number
+	for _, v := range []string{"0", "-5", "lots"} {
+		t.Setenv("ARTOO_EVIDENCE_LEN", v)
+		if got := evidenceLenFromEnv(); got != defaultEvidenceLen {
+			t.Errorf("ARTOO_EVIDENCE_LEN=%q: expected %d, got %d", v, defaultEvidenceLen, got)
+		}
+	}
+}
+
+func TestToolEvidence_RespectsLength(t *testing.T) {
+	// Never returns more than n chars of content (plus a truncation marker)
+	out := strings.Repeat("x", 1000)
+	for _, n := range []int{50, 200, 700} {
+		got := toolEvidence("shell", out, n)
+		if len(strings.TrimSuffix(got, "...")) != n {
+			t.Errorf("n=%d: expected %d chars of content, got %d", n, n, len(got))
+		}
+	}
+}
+
+func TestToolEvidence_LongerSettingCapturesDeepContent(t *testing.T) {
+	// Other tools: returns firstN of the trimmed output
+	out := strings.Repeat("compiling package ...\n", 15) + "binary written to /tmp/build/artoo"
+	if strings.Contains(toolEvidence("shell", out, defaultEvidenceLen), "/tmp/build/artoo") {
+		t.Fatal("test setup: answer should lie beyond the default evidence length")
+	}
+	if !strings.Contains(toolEvidence("shell", out, 1000), "/tmp/build/artoo") {
+		t.Error("expected a longer evidence length to capture the answer")
+	}
+}
+
+func TestToolEvidence_SearchKeepsTitlesAndURLs(t *testing.T) {
+	// search: keeps title and URL lines, drops snippet lines
+	out := "Go 1.25 Release Notes\n" + strings.Repeat("long snippet text ", 20) + "\nhttps://go.dev/doc/go1.25\n\n" +
+		"Go Blog\nanother snippet\nhttps://go.dev/blog"
+	got := toolEvidence("search", out, defaultEvidenceLen)
+	for _, want := range []string{"Go 1.25 Release Notes", "https://go.dev/doc/go1.25", "Go Blog", "https://go.dev/blog"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in evidence, got %q", want, got)
+		}
+	}
+	if strings.Contains(got, "snippet") {
+		t.Errorf("expected snippets to be dropped, got %q", got)
+	}
+}
+
+func TestToolEvidence_PathsKeepWholeLines(t *testing.T) {
+	// glob/mdfind: keeps whole path lines and notes how many paths were cut
+	var paths []string
+	for i := 0; i < 20; i++ {
+		paths = append(paths, filepath.Join("/home/user/projects/module", strings.Repeat("d", i+1), "report.txt"))
+	}
+	got := toolEvidence("glob", strings.Join(paths, "\n"), defaultEvidenceLen)
+	lines := strings.Split(got, "\n")
+	last := lines[len(lines)-1]
+	if !strings.HasPrefix(last, "... (+") {
+		t.Fatalf("expected a truncation count line, got %q", got)
+	}
+	for _, l := range lines[:len(lines)-1] {
+		if !strings.HasSuffix(l, "/report.txt") {
+			t.Errorf("expected only whole path lines, got partial %q", l)
+		}
+	}
+	if want := "(+" + strconv.Itoa(len(paths)-(len(lines)-1)) + " more)"; !strings.HasSuffix(last, want) {
+		t.Errorf("expected %q, got %q", want, last)
+	}
+}
+
+// ── synthesize ───────────────────────────────────────────────────────────────
+
+// mockLLMResponse builds a minimal OpenAI-compatible chat completion JSON
+// whose content field is the provided body string.
+func mockLLMResponse(body string) string {
+	escaped, _ := json.Marshal(body)
+	return `{"choices":[{"message":{"role":"assistant","content":` + string(escaped) + `}}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`
+}
+
+// budgetLLM answers every R3 turn with a fresh shell call (so the tool budget runs
+// out) and the synthesis call with synthBody. It records the synthesis prompt.
+func budgetLLM(t *testing.T, synthBody string) *string {
+	t.Helper()
+	var mu sync.Mutex
+	var synthPrompt string
+	turn := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		defer mu.Unlock()
+		body := synthBody
+		if strings.Contains(req.Messages[0].Content, "budget for this SubTask is exhausted") {
+			synthPrompt = req.Messages[1].Content
+		} else {
+			turn++
+			body = fmt.Sprintf(`{"action":"tool","tool":"shell","command":"echo part-%d"}`, turn)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(body)))
+	}))
+	t.Cleanup(ts.Close)
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+	return &synthPrompt
+}
+
+func TestExecute_BudgetExhaustedSynthesizesCompletedResult(t *testing.T) {
+	// Returns the model's result with ok=true when it parses as a final result
+	synthPrompt := budgetLLM(t, `{"action":"result","subtask_id":"st1","status":"completed","output":"parts 1-10 printed","uncertainty":null}`)
+	e := New(nil, llm.New(), nil)
+	st := types.SubTask{SubTaskID: "st1", Intent: "print ten parts", SuccessCriteria: []string{"output mentions the parts"}}
+
+	res, calls, err := e.execute(t.Context(), st, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Status != "completed" || res.Output != "parts 1-10 printed" {
+		t.Errorf("expected the synthesized completed result, got status=%q output=%v", res.Status, res.Output)
+	}
+	if len(calls) != 10 || len(res.ToolCalls) != 10 {
+		t.Errorf("expected 10 code-recorded tool calls, got %d / %d", len(calls), len(res.ToolCalls))
+	}
+	if !strings.Contains(*synthPrompt, "part-10") {
+		t.Errorf("expected the gathered tool results in the synthesis prompt, got %q", *synthPrompt)
+	}
+}
+
+func TestExecute_BudgetExhaustedFallsBackToRawDump(t *testing.T) {
+	// Returns ok=false on LLM error or unparseable output so the caller keeps the raw dump
+	budgetLLM(t, "sorry, I cannot decide")
+	e := New(nil, llm.New(), nil)
+	st := types.SubTask{SubTaskID: "st1", Intent: "print ten parts"}
+
+	res, _, err := e.execute(t.Context(), st, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out, _ := res.Output.(string)
+	if res.Status != "uncertain" || !strings.Contains(out, "part-10") {
+		t.Errorf("expected the raw uncertain dump, got status=%q output=%v", res.Status, res.Output)
+	}
+}
+
+func TestSynthesize_NonCompletedStatusBecomesUncertain(t *testing.T) {
+	// Maps any status other than "completed" to "uncertain" (no tool ran, so nothing newly failed)
+	budgetLLM(t, `{"action":"result","subtask_id":"st1","status":"failed","output":"only part 1 found","uncertainty":null}`)
+	e := New(nil, llm.New(), nil)
+
+	res, ok := e.synthesize(t.Context(), types.SubTask{SubTaskID: "st1"}, []string{"Tool shell result:\npart-1\n"}, nil)
+	if !ok || res.Status != "uncertain" {
+		t.Errorf("expected ok with status uncertain, got ok=%v status=%q", ok, res.Status)
+	}
+}
+
+// ── prompt budget ────────────────────────────────────────────────────────────
+
+// bulkyTool returns about 900 estimated tokens per call, tagged with its input.
+type bulkyTool struct{}
+
+func (bulkyTool) Name() string        { return "bulky" }
+func (bulkyTool) Description() string { return "returns a large report." }
+func (bulkyTool) Schema() string      { return `{"action":"tool","tool":"bulky","name":"..."}` }
+func (bulkyTool) Run(_ context.Context, input json.RawMessage) (string, error) {
+	var in struct {
+		Name string `json:"name"`
+	}
+	json.Unmarshal(input, &in)
+	return "report-" + in.Name + " " + strings.Repeat("data ", 700), nil
+}
+
+func TestExecute_PromptStaysWithinBudget(t *testing.T) {
+	// The oldest tool results are dropped first so each prompt stays within ARTOO_EXECUTOR_MAX_PROMPT_TOKENS
+	reg := tools.NewRegistry()
+	if err := reg.Register(bulkyTool{}); err != nil {
+		t.Fatal(err)
+	}
+	// Folded observations (a digest summary plus the two newest results, ~875
+	// tokens each) still overflow this budget, so FitParts has to drop parts.
+	limit := llm.EstimateTokens(buildSystemPrompt(reg, nil)) + 2000
+	t.Setenv("ARTOO_EXECUTOR_MAX_PROMPT_TOKENS", strconv.Itoa(limit))
+
+	var mu sync.Mutex
+	var sizes []int
+	var lastUser string
+	turn := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		defer mu.Unlock()
+		sizes = append(sizes, llm.EstimateTokens(req.Messages[0].Content)+llm.EstimateTokens(req.Messages[1].Content))
+		turn++
+		body := fmt.Sprintf(`{"action":"tool","tool":"bulky","name":"r%d"}`, turn)
+		if turn == 7 {
+			lastUser = req.Messages[1].Content
+			body = `{"action":"result","subtask_id":"st1","status":"completed","output":"done","uncertainty":null}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(body)))
+	}))
+	defer ts.Close()
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+
+	e := NewWithRegistry(nil, llm.New(), nil, reg)
+	st := types.SubTask{SubTaskID: "st1", Intent: "collect six reports"}
+	if _, _, err := e.execute(t.Context(), st, nil, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, n := range sizes {
+		if n > limit {
+			t.Errorf("prompt %d is %d estimated tokens, over the %d budget", i+1, n, limit)
+		}
+	}
+	if !strings.Contains(lastUser, "report-r6") {
+		t.Errorf("expected the newest tool result in the last prompt, got:\n%s", firstN(lastUser, 500))
+	}
+	if strings.Contains(lastUser, "report-r1 ") || !strings.Contains(lastUser, "omitted to fit the prompt budget") {
+		t.Errorf("expected the oldest tool results dropped with a note, got:\n%s", firstN(lastUser, 500))
+	}
+}
+
+func TestExecute_NoBudgetKeepsAllToolResults(t *testing.T) {
+	// 0 means no bound — with no budget configured, tool results are not dropped
+	t.Setenv("ARTOO_MAX_PROMPT_TOKENS", "")
+	synthPrompt := budgetLLM(t, `{"action":"result","subtask_id":"st1","status":"completed","output":"ok","uncertainty":null}`)
+	e := New(nil, llm.New(), nil)
+	if _, _, err := e.execute(t.Context(), types.SubTask{SubTaskID: "st1", Intent: "print ten parts"}, nil, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(*synthPrompt, "part-1\n") || strings.Contains(*synthPrompt, "omitted to fit") {
+		t.Errorf("expected every tool result in the synthesis prompt, got %q", *synthPrompt)
+	}
+}
+
+// ── tool registry ────────────────────────────────────────────────────────────
+
+// weatherTool is a custom tool registered from outside the executor.
+type weatherTool struct{ calls *[]string }
+
+func (weatherTool) Name() string { return "weather" }
+func (weatherTool) Description() string {
+	return "current weather for a city.\nPrefer this over search for weather questions."
+}
+func (weatherTool) Schema() string { return `{"action":"tool","tool":"weather","city":"..."}` }
+func (w weatherTool) Run(_ context.Context, input json.RawMessage) (string, error) {
+	var in struct {
+		City string `json:"city"`
+	}
+	if err := json.Unmarshal(input, &in); err != nil {
+		return "", err
+	}
+	*w.calls = append(*w.calls, in.City)
+	return "sunny, 21°C in " + in.City, nil
+}
+
+// registryWithWeather returns a registry holding the built-ins plus weatherTool.
+func registryWithWeather(t *testing.T) (*tools.Registry, *[]string) {
+	t.Helper()
+	reg := tools.NewRegistry()
+	if err := RegisterBuiltins(reg); err != nil {
+		t.Fatal(err)
+	}
+	calls := &[]string{}
+	if err := reg.Register(weatherTool{calls: calls}); err != nil {
+		t.Fatal(err)
+	}
+	return reg, calls
+}
+
+func TestBuildSystemPrompt_ListsCustomTool(t *testing.T) {
+	// Lists every registered tool as "N. name — summary" followed by its input example
+	reg, _ := registryWithWeather(t)
+	p := buildSystemPrompt(reg, nil)
+	for _, want := range []string{
+		"1. mdfind — personal file search",
+		"11. weather — current weather for a city.\n   Input: {\"action\":\"tool\",\"tool\":\"weather\",\"city\":\"...\"}\n   Prefer this over search",
+		"Execution rules:",
+	} {
+		if !strings.Contains(p, want) {
+			t.Errorf("expected prompt to contain %q, got:\n%s", want, p)
+		}
+	}
+}
+
+func TestSystemPrompt_OverrideKeepsToolList(t *testing.T) {
+	// Appends the live tool list to an override
+	path := filepath.Join(t.TempDir(), "executor.txt")
+	if err := os.WriteFile(path, []byte("variant B executor prompt"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ARTOO_EXECUTOR_SYSTEM_PROMPT_FILE", path)
+	reg, _ := registryWithWeather(t)
+	e := NewWithRegistry(nil, llm.NewTier("TOOL").ForRole("executor"), nil, reg)
+	p := e.systemPrompt(nil)
+	if !strings.HasPrefix(p, "variant B executor prompt\n\nTools:") || !strings.Contains(p, "11. weather — current weather for a city.") {
+		t.Errorf("expected the override followed by the tool list, got:\n%s", p)
+	}
+	if strings.Contains(p, "Execution rules:") {
+		t.Errorf("the override should replace the built-in rules, got:\n%s", p)
+	}
+}
+
+func TestRunTool_InvokesCustomTool(t *testing.T) {
+	// Runs custom tools registered in the executor's registry with the model's call JSON
+	reg, calls := registryWithWeather(t)
+	e := NewWithRegistry(nil, nil, nil, reg)
+	tc := toolCall{Tool: "weather", raw: json.RawMessage(`{"action":"tool","tool":"weather","city":"Oslo"}`)}
+	out, _, err := e.runTool(t.Context(), tc, tools.ShellEnv{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "sunny, 21°C in Oslo" || len(*calls) != 1 {
+		t.Errorf("expected the weather tool to run once, got %q (calls %v)", out, *calls)
+	}
+}
+
+func TestRegisterFetchURL_OffersAndDispatchesFetchURL(t *testing.T) {
+	// fetch_url is listed and dispatched only in a registry it was registered in
+	reg := tools.NewRegistry()
+	if err := RegisterBuiltins(reg); err != nil {
+		t.Fatal(err)
+	}
+	if p := buildSystemPrompt(reg, nil); strings.Contains(p, "fetch_url") {
+		t.Errorf("fetch_url listed before it was registered:\n%s", p)
+	}
+	if err := RegisterFetchURL(reg); err != nil {
+		t.Fatal(err)
+	}
+	p := buildSystemPrompt(reg, nil)
+	if !strings.Contains(p, "11. fetch_url — read the text of a web page") {
+		t.Errorf("expected fetch_url as entry 11, got:\n%s", p)
+	}
+	if !strings.Contains(p, "(mdfind, glob, grep, read_file, search, fetch_url) may be batched") {
+		t.Errorf("expected fetch_url among the batchable tools, got:\n%s", p)
+	}
+	e := NewWithRegistry(nil, nil, nil, reg)
+	tc := toolCall{Tool: "fetch_url", URL: "file:///etc/passwd", raw: json.RawMessage(`{"action":"tool","tool":"fetch_url","url":"file:///etc/passwd"}`)}
+	if _, _, err := e.runTool(t.Context(), tc, tools.ShellEnv{}); err == nil || !strings.Contains(err.Error(), "unsupported scheme") {
+		t.Errorf("expected fetch_url to refuse a file URL, got %v", err)
+	}
+}
+
+// slowTool stands in for a built-in: each call holds for 20ms and records the
+// peak number of its calls running at once.
+type slowTool struct {
+	name          string
+	running, peak *atomic.Int32
+}
+
+func (s slowTool) Name() string        { return s.name }
+func (s slowTool) Description() string { return "slow " + s.name }
+func (s slowTool) Schema() string      { return `{"action":"tool","tool":"` + s.name + `"}` }
+func (s slowTool) Run(context.Context, json.RawMessage) (string, error) {
+	now := s.running.Add(1)
+	defer s.running.Add(-1)
+	for {
+		p := s.peak.Load()
+		if now <= p || s.peak.CompareAndSwap(p, now) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	return "done", nil
+}
+
+func TestRunTool_DefaultLimitsSerialiseApplescriptNotReadFile(t *testing.T) {
+	// Waits for a tools.Limits slot before running: concurrent applescript calls
+	// run one at a time under tools.DefaultLimits, read_file calls overlap
+	stubAvailability(t)
+	reg := tools.NewRegistry()
+	peaks := map[string]*atomic.Int32{}
+	for _, name := range []string{"applescript", "read_file"} {
+		peaks[name] = &atomic.Int32{}
+		if err := reg.Register(slowTool{name: name, running: &atomic.Int32{}, peak: peaks[name]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := NewWithRegistry(nil, nil, nil, reg)
+	var wg sync.WaitGroup
+	for _, name := range []string{"applescript", "read_file"} {
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, _, err := e.runTool(t.Context(), toolCall{Tool: name}, tools.ShellEnv{}); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	if got := peaks["applescript"].Load(); got != 1 {
+		t.Errorf("applescript peak concurrency = %d, want 1", got)
+	}
+	if got := peaks["read_file"].Load(); got < 2 {
+		t.Errorf("read_file peak concurrency = %d, want the calls to overlap", got)
+	}
+}
+
+func TestRunTool_LimitWaitEndsWithContext(t *testing.T) {
+	// Returns ctx's error without running when ctx ends while waiting for a slot
+	stubAvailability(t)
+	reg := tools.NewRegistry()
+	peak := &atomic.Int32{}
+	if err := reg.Register(slowTool{name: "applescript", running: &atomic.Int32{}, peak: peak}); err != nil {
+		t.Fatal(err)
+	}
+	e := NewWithRegistry(nil, nil, nil, reg)
+	limits := tools.NewLimits(map[string]int{"applescript": 1})
+	e.SetToolLimits(limits)
+	release, err := limits.Acquire(t.Context(), "applescript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := e.runTool(ctx, toolCall{Tool: "applescript"}, tools.ShellEnv{}); err == nil {
+		t.Error("expected an error when the context ends while waiting")
+	}
+	if peak.Load() != 0 {
+		t.Error("expected the call not to run")
+	}
+}
+
+// panicTool stands in for a built-in whose Run panics.
+type panicTool struct{}
+
+func (panicTool) Name() string        { return "applescript" }
+func (panicTool) Description() string { return "panics" }
+func (panicTool) Schema() string      { return `{"action":"tool","tool":"applescript"}` }
+func (panicTool) Run(context.Context, json.RawMessage) (string, error) {
+	panic("tool bug")
+}
+
+func TestRunTool_PanickingToolFreesItsSlot(t *testing.T) {
+	// Frees the slot when the call returns, even if the tool panics
+	stubAvailability(t)
+	reg := tools.NewRegistry()
+	if err := reg.Register(panicTool{}); err != nil {
+		t.Fatal(err)
+	}
+	e := NewWithRegistry(nil, nil, nil, reg)
+	limits := tools.NewLimits(map[string]int{"applescript": 1})
+	e.SetToolLimits(limits)
+	func() {
+		defer func() { _ = recover() }()
+		e.runTool(t.Context(), toolCall{Tool: "applescript"}, tools.ShellEnv{})
+	}()
+	ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+	release, err := limits.Acquire(ctx, "applescript")
+	if err != nil {
+		t.Fatalf("slot still held after the panic: %v", err)
+	}
+	release()
+}
+
+// hangingTool blocks until its context ends, like an unanswered AppleScript dialog.
+type hangingTool struct{ name string }
+
+func (h hangingTool) Name() string        { return h.name }
+func (h hangingTool) Description() string { return "hangs" }
+func (h hangingTool) Schema() string      { return `{"action":"tool","tool":"` + h.name + `"}` }
+func (h hangingTool) Run(ctx context.Context, _ json.RawMessage) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestRunTool_ToolTimeoutEndsHungCall(t *testing.T) {
+	// Bounds each call by ToolTimeouts[tool]; when that ceiling ends it, returns a
+	// "tool X timed out after D" error, which R4a classifies as environmental
+	stubAvailability(t)
+	reg := tools.NewRegistry()
+	if err := reg.Register(hangingTool{name: "applescript"}); err != nil {
+		t.Fatal(err)
+	}
+	e := NewWithRegistry(nil, nil, nil, reg)
+	e.ToolTimeouts = map[string]time.Duration{"applescript": 20 * time.Millisecond}
+	_, _, err := e.runTool(t.Context(), toolCall{Tool: "applescript"}, tools.ShellEnv{})
+	if err == nil || err.Error() != "tool applescript timed out after 20ms" {
+		t.Fatalf("got %v, want a timed-out error", err)
+	}
+}
+
+func TestRunTool_CallerCancelIsNotAToolTimeout(t *testing.T) {
+	// A call ended by the caller's context returns that context's error, not a tool timeout
+	stubAvailability(t)
+	reg := tools.NewRegistry()
+	if err := reg.Register(hangingTool{name: "applescript"}); err != nil {
+		t.Fatal(err)
+	}
+	e := NewWithRegistry(nil, nil, nil, reg)
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err := e.runTool(ctx, toolCall{Tool: "applescript"}, tools.ShellEnv{})
+	if !errors.Is(err, context.DeadlineExceeded) || strings.Contains(err.Error(), "timed out after") {
+		t.Errorf("got %v, want the caller's deadline error", err)
+	}
+}
+
+// flakyTool fails while fail is set and counts the calls that reached it.
+type flakyTool struct {
+	name string
+	fail *atomic.Bool
+	runs *atomic.Int32
+}
+
+func (f flakyTool) Name() string        { return f.name }
+func (f flakyTool) Description() string { return "flaky" }
+func (f flakyTool) Schema() string      { return `{"action":"tool","tool":"` + f.name + `"}` }
+func (f flakyTool) Run(context.Context, json.RawMessage) (string, error) {
+	f.runs.Add(1)
+	if f.fail.Load() {
+		return "", errors.New("connection reset by peer")
+	}
+	return "ok", nil
+}
+
+func TestRunTool_CircuitOpensAfterConsecutiveFailures(t *testing.T) {
+	// Returns the [CIRCUIT] message without running when the breaker has opened the tool's
+	// circuit for the task in ctx; a success resets the count; other tasks and Reset are unaffected
+	stubAvailability(t)
+	fail, runs := &atomic.Bool{}, &atomic.Int32{}
+	reg := tools.NewRegistry()
+	if err := reg.Register(flakyTool{name: "search", fail: fail, runs: runs}); err != nil {
+		t.Fatal(err)
+	}
+	e := NewWithRegistry(nil, nil, nil, reg)
+	e.SetToolBreaker(NewToolBreaker(2))
+	ctx := withBreakerTask(t.Context(), "t1")
+	call := func(ctx context.Context) (string, error) {
+		out, _, err := e.runTool(ctx, toolCall{Tool: "search", Query: "q"}, tools.ShellEnv{})
+		return out, err
+	}
+
+	fail.Store(true)
+	call(ctx)
+	fail.Store(false)
+	call(ctx) // success clears the first failure
+	fail.Store(true)
+	for range 2 {
+		if _, err := call(ctx); err == nil {
+			t.Fatal("expected the tool's error")
+		}
+	}
+	out, err := call(ctx)
+	if err != nil || !strings.HasPrefix(out, "[CIRCUIT] tool search disabled for this task after 2 failures") {
+		t.Fatalf("got %q, %v; want the circuit message", out, err)
+	}
+	if runs.Load() != 4 {
+		t.Errorf("tool ran %d times, want 4 (not after the circuit opened)", runs.Load())
+	}
+	if _, err := call(withBreakerTask(t.Context(), "t2")); err == nil {
+		t.Error("another task's call should still reach the tool")
+	}
+	e.breaker.Reset("t1")
+	if _, err := call(ctx); err == nil {
+		t.Error("after Reset the call should reach the tool again")
+	}
+}
+
+func TestRunTool_BreakerCountsOnlyEnvironmentalShellFailures(t *testing.T) {
+	// Records with the breaker whether the call failed environmentally: a shell command
+	// exiting non-zero never opens the circuit, a timed-out one does
+	stubAvailability(t)
+	e := New(nil, nil, nil)
+	e.SetToolBreaker(NewToolBreaker(2))
+	ctx := withBreakerTask(t.Context(), "t1")
+	for range 3 {
+		out, _, err := e.runTool(ctx, toolCall{Tool: "shell", Command: "exit 1"}, tools.ShellEnv{})
+		if err != nil || strings.HasPrefix(out, circuitTag) {
+			t.Fatalf("non-zero exit: got %q, %v; want the command's own failure", out, err)
+		}
+	}
+	e.ToolTimeouts = map[string]time.Duration{"shell": 20 * time.Millisecond}
+	for range 2 {
+		e.runTool(ctx, toolCall{Tool: "shell", Command: "sleep 5"}, tools.ShellEnv{})
+	}
+	if out, _, _ := e.runTool(ctx, toolCall{Tool: "shell", Command: "true"}, tools.ShellEnv{}); !strings.HasPrefix(out, circuitTag) {
+		t.Errorf("after two timeouts: got %q, want the circuit message", out)
+	}
+}
+
+func TestEnvironmentalFailure(t *testing.T) {
+	// Returns true for a tool error, a timeout, or a shell result ending with environmentNote;
+	// false for a shell result that only reports a non-zero exit
+	cases := []struct {
+		tool, content string
+		err           error
+		timedOut      bool
+		want          bool
+	}{
+		{"search", "", errors.New("connection reset"), false, true},
+		{"applescript", "", nil, true, true},
+		{"shell", "stdout: \nstderr: \nerror: signal: killed\n" + environmentNote, nil, false, true},
+		{"shell", "stdout: \nstderr: \nerror: exit status 1\n" + noNetworkNote, nil, false, false},
+		{"shell", "stdout: \nstderr: no such file\nerror: exit status 1", nil, false, false},
+		{"search", "results", nil, false, false},
+	}
+	for _, c := range cases {
+		if got := environmentalFailure(c.tool, c.content, c.err, c.timedOut); got != c.want {
+			t.Errorf("environmentalFailure(%q, %q, %v, %v) = %v, want %v", c.tool, c.content, c.err, c.timedOut, got, c.want)
+		}
+	}
+}
+
+func TestToolBreaker_DisabledAndNil(t *testing.T) {
+	// A threshold of 0 never opens a circuit; a nil breaker and an empty task ID do nothing
+	b := NewToolBreaker(0)
+	for range 5 {
+		b.record("t1", "search", true)
+	}
+	if open, _ := b.open("t1", "search"); open {
+		t.Error("threshold 0 opened a circuit")
+	}
+	var nb *ToolBreaker
+	nb.record("t1", "search", true)
+	nb.Reset("t1")
+	if open, _ := nb.open("t1", "search"); open {
+		t.Error("nil breaker opened a circuit")
+	}
+	b = NewToolBreaker(1)
+	b.record("", "search", true)
+	if open, _ := b.open("", "search"); open {
+		t.Error("a call without a task counted")
+	}
+}
+
+func TestParseToolTimeouts(t *testing.T) {
+	// Returns an empty map for a blank value, trims space, accepts 0, and rejects malformed pairs
+	got, err := ParseToolTimeouts(" shell = 2m , applescript=0 ,")
+	if err != nil || len(got) != 2 || got["shell"] != 2*time.Minute || got["applescript"] != 0 {
+		t.Errorf("got %v, %v", got, err)
+	}
+	if got, err := ParseToolTimeouts("  "); err != nil || len(got) != 0 {
+		t.Errorf("blank value: got %v, %v", got, err)
+	}
+	for _, bad := range []string{"shell", "=30s", "shell=30", "shell=-1s", "shell=soon"} {
+		if _, err := ParseToolTimeouts(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestRunTool_UnknownToolErrors(t *testing.T) {
+	// Returns an "unknown tool" error when no tool of that name is registered
+	e := NewWithRegistry(nil, nil, nil, tools.NewRegistry())
+	if _, _, err := e.runTool(t.Context(), toolCall{Tool: "glob"}, tools.ShellEnv{}); err == nil || !strings.Contains(err.Error(), "unknown tool") {
+		t.Errorf("expected unknown tool error, got %v", err)
+	}
+}
+
+func TestExecute_CallsCustomToolFromModel(t *testing.T) {
+	// A custom tool named by the model is dispatched and its output reaches tool_calls evidence
+	var mu sync.Mutex
+	var prompts []string
+	bodies := []string{
+		`{"action":"tool","tool":"weather","city":"Oslo"}`,
+		`{"action":"result","subtask_id":"st1","status":"completed","output":"It is sunny in Oslo.","uncertainty":null}`,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		i := min(len(prompts), len(bodies)-1)
+		prompts = append(prompts, req.Messages[0].Content)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(bodies[i])))
+	}))
+	t.Cleanup(ts.Close)
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+
+	reg, calls := registryWithWeather(t)
+	e := NewWithRegistry(nil, llm.New(), nil, reg)
+	res, _, err := e.execute(t.Context(), types.SubTask{SubTaskID: "st1", Intent: "weather in Oslo"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*calls) != 1 || (*calls)[0] != "Oslo" {
+		t.Errorf("expected one weather call for Oslo, got %v", *calls)
+	}
+	if res.Status != "completed" || len(res.ToolCalls) != 1 || !strings.Contains(res.ToolCalls[0], "sunny, 21°C in Oslo") {
+		t.Errorf("expected completed result with weather evidence, got %+v", res)
+	}
+	if !strings.Contains(prompts[0], "weather — current weather for a city.") {
+		t.Errorf("expected the system prompt to offer the weather tool")
+	}
+}
+
+// ── artifacts ────────────────────────────────────────────────────────────────
+
+func TestExecute_ReportsWrittenFilesAsArtifacts(t *testing.T) {
+	// Every successful write_file path is reported, absolute and once each, in ExecutionResult.Artifacts
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	bodies := []string{
+		fmt.Sprintf(`{"action":"tool","tool":"write_file","path":%q,"content":"first"}`, a),
+		fmt.Sprintf(`{"action":"tool","tool":"write_file","path":%q,"content":"second"}`, b),
+		`{"action":"result","subtask_id":"st1","status":"completed","output":"wrote two files","uncertainty":null}`,
+	}
+	var mu sync.Mutex
+	turn := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		i := min(turn, len(bodies)-1)
+		turn++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(bodies[i])))
+	}))
+	t.Cleanup(ts.Close)
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+
+	e := New(nil, llm.New(), nil)
+	res, _, err := e.execute(t.Context(), types.SubTask{SubTaskID: "st1", Intent: "write two files"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(res.Artifacts, []string{a, b}) {
+		t.Errorf("expected artifacts %v, got %v", []string{a, b}, res.Artifacts)
+	}
+}
+
+func TestAddArtifact_DeduplicatesAbsolutePaths(t *testing.T) {
+	// Leaves artifacts unchanged when the path is already listed
+	got := addArtifact(nil, "/tmp/x.txt")
+	got = addArtifact(got, "/tmp/../tmp/x.txt")
+	if !slices.Equal(got, []string{"/tmp/x.txt"}) {
+		t.Errorf("expected one artifact, got %v", got)
+	}
+}
+
+// ── tool preferences ─────────────────────────────────────────────────────────
+
+// newPreferenceStore returns a running memory store holding megs, waiting until
+// the async writes are queryable.
+func newPreferenceStore(t *testing.T, megs ...types.Megram) *memory.Store {
+	t.Helper()
+	s := memory.New(bus.New(), t.TempDir(), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Run(ctx)
+	for _, m := range megs {
+		s.Write(m)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for _, m := range megs {
+		for {
+			recent, _ := s.QueryRecent(ctx, m.Space, m.Entity, 10)
+			if len(recent) > 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("megram %s/%s was not persisted", m.Space, m.Entity)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	return s
+}
+
+func toolMegram(tool, intent, state string, sigma float64) types.Megram {
+	space, entity := memory.ToolPreferenceTags(tool, intent)
+	return types.Megram{
+		ID:        uuid.New().String(),
+		Level:     "M",
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Space:     space,
+		Entity:    entity,
+		State:     state,
+		F:         0.9,
+		Sigma:     sigma,
+		K:         0.05,
+	}
+}
+
+func TestToolPreferences_StoredSearchMegramElevatesSearch(t *testing.T) {
+	// Places Exploit tools first, by descending decision potential
+	mem := newPreferenceStore(t, toolMegram("search", "find the latest Go release notes", "accept", 1.0))
+	reg := tools.NewRegistry()
+	if err := RegisterBuiltins(reg); err != nil {
+		t.Fatal(err)
+	}
+	e := NewWithRegistry(nil, nil, mem, reg)
+
+	prefs := e.toolPreferences(t.Context(), types.SubTask{SubTaskID: "st1", Intent: "find the latest Go 1.25 changes"})
+	if prefs["search"].Action != "Exploit" || len(prefs) != 1 {
+		t.Fatalf("expected only an Exploit preference for search, got %+v", prefs)
+	}
+	prompt := buildSystemPrompt(reg, prefs)
+	if !strings.Contains(prompt, "\n1. search — ") {
+		t.Errorf("expected search listed first, got:\n%s", prompt)
+	}
+	if !strings.Contains(prompt, "\n2. mdfind — ") {
+		t.Errorf("expected the remaining tools to keep their order after search")
+	}
+	if !strings.Contains(prompt, "Memory: search has worked well for this kind of task") {
+		t.Errorf("expected a memory note under search")
+	}
+}
+
+func TestToolPreferences_UnrelatedIntentLeavesOrder(t *testing.T) {
+	// Includes only tools whose potentials derive an Exploit or Avoid action
+	mem := newPreferenceStore(t, toolMegram("search", "find the latest Go release notes", "accept", 1.0))
+	reg := tools.NewRegistry()
+	if err := RegisterBuiltins(reg); err != nil {
+		t.Fatal(err)
+	}
+	e := NewWithRegistry(nil, nil, mem, reg)
+
+	prefs := e.toolPreferences(t.Context(), types.SubTask{Intent: "count lines in main.go"})
+	if len(prefs) != 0 {
+		t.Errorf("expected no preferences for an unrelated intent, got %+v", prefs)
+	}
+	if !strings.Contains(buildSystemPrompt(reg, prefs), "\n1. mdfind — ") {
+		t.Errorf("expected registration order without preferences")
+	}
+}
+
+func TestToolPreferences_NilMemory(t *testing.T) {
+	// Returns nil when mem is nil
+	e := NewWithRegistry(nil, nil, nil, tools.NewRegistry())
+	if prefs := e.toolPreferences(t.Context(), types.SubTask{Intent: "anything"}); prefs != nil {
+		t.Errorf("expected nil preferences, got %+v", prefs)
+	}
+}
+
+func TestOrderByPreference_AvoidGoesLast(t *testing.T) {
+	// Places Avoid tools last, in registration order
+	reg := tools.NewRegistry()
+	if err := RegisterBuiltins(reg); err != nil {
+		t.Fatal(err)
+	}
+	prefs := map[string]types.Potentials{
+		"mdfind": {Attention: 0.9, Decision: -0.9, Action: "Avoid"},
+		"shell":  {Attention: 0.6, Decision: 0.4, Action: "Exploit"},
+		"search": {Attention: 0.9, Decision: 0.9, Action: "Exploit"},
+	}
+	var names []string
+	for _, tl := range orderByPreference(reg.Tools(), prefs) {
+		names = append(names, tl.Name())
+	}
+	want := "search shell glob grep read_file write_file applescript message shortcuts mdfind"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("expected order %q, got %q", want, got)
+	}
+	if !strings.Contains(buildSystemPrompt(reg, prefs), "Memory: mdfind has failed for this kind of task") {
+		t.Errorf("expected an avoid note under mdfind")
+	}
+}
+
+// ── search reformulation ─────────────────────────────────────────────────────
+
+// scriptedSearch is a fake "search" tool answering from a query → output table.
+type scriptedSearch struct {
+	results map[string]string
+	queries *[]string
+}
+
+func (scriptedSearch) Name() string        { return "search" }
+func (scriptedSearch) Description() string { return "web search." }
+func (scriptedSearch) Schema() string      { return `{"action":"tool","tool":"search","query":"..."}` }
+func (s scriptedSearch) Run(_ context.Context, input json.RawMessage) (string, error) {
+	var in struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(input, &in); err != nil {
+		return "", err
+	}
+	*s.queries = append(*s.queries, in.Query)
+	return s.results[in.Query], nil
+}
+
+// reformulationLLM serves executor turns from bodies in order and answers the
+// reformulation prompt with reformulated; it records how often that prompt was seen.
+func reformulationLLM(t *testing.T, reformulated string, bodies ...string) *int {
+	t.Helper()
+	var mu sync.Mutex
+	turns, asked := 0, new(int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		body := reformulated
+		if strings.HasPrefix(req.Messages[0].Content, reformulatePrompt[:40]) {
+			*asked++
+		} else {
+			body = bodies[min(turns, len(bodies)-1)]
+			turns++
+		}
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(body)))
+	}))
+	t.Cleanup(ts.Close)
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+	return asked
+}
+
+func TestWeakSearchResult(t *testing.T) {
+	// Returns true when the output mentions none of the criterion keywords
+	criteria := []string{"output states the Go 1.22 release date"}
+	cases := []struct {
+		result string
+		want   bool
+	}{
+		{"", true},
+		{`No results found for: "go release"`, true},
+		{"1. Golf clubs on sale\n   https://shop.example", true},
+		{"1. Go 1.22 Release Notes\n   https://go.dev/doc/go1.22", false},
+	}
+	for _, c := range cases {
+		if got := weakSearchResult(c.result, criteria); got != c.want {
+			t.Errorf("weakSearchResult(%q) = %v, want %v", c.result, got, c.want)
+		}
+	}
+	if weakSearchResult("anything at all", []string{"it is in the output"}) {
+		t.Errorf("expected non-empty output to pass when criteria have no keywords")
+	}
+}
+
+func TestCriterionKeywords_DropsStopwordsAndShortWords(t *testing.T) {
+	// Drops words shorter than 4 runes, stopwords, and words with non-ASCII runes
+	got := criterionKeywords([]string{"Output lists the release date", "release notes 发布日期"})
+	if want := "release date notes"; strings.Join(got, " ") != want {
+		t.Errorf("expected %q, got %q", want, strings.Join(got, " "))
+	}
+}
+
+func TestExecute_ReformulatesIrrelevantSearchOnce(t *testing.T) {
+	// A search that found nothing on-topic gets one rewritten query before the model sees it
+	asked := reformulationLLM(t, `{"query":"Go 1.22 release date"}`,
+		`{"action":"tool","tool":"search","query":"golang newest version day"}`,
+		`{"action":"result","subtask_id":"st1","status":"completed","output":"Go 1.22 was released on 2024-02-06.","uncertainty":null}`,
+	)
+	queries := &[]string{}
+	reg := tools.NewRegistry()
+	if err := reg.Register(scriptedSearch{queries: queries, results: map[string]string{
+		"golang newest version day": "1. Golf tournament schedule\n   https://sports.example/golf",
+		"Go 1.22 release date":      "1. Go 1.22 is released - The Go Blog\n   https://go.dev/blog/go1.22",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	e := NewWithRegistry(nil, llm.New(), nil, reg)
+	st := types.SubTask{SubTaskID: "st1", Intent: "find when Go 1.22 was released",
+		SuccessCriteria: []string{"output states the Go 1.22 release date"}}
+	res, _, err := e.execute(t.Context(), st, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *asked != 1 {
+		t.Errorf("expected one reformulation request, got %d", *asked)
+	}
+	if want := "golang newest version day|Go 1.22 release date"; strings.Join(*queries, "|") != want {
+		t.Errorf("expected queries %q, got %q", want, strings.Join(*queries, "|"))
+	}
+	if len(res.ToolCalls) != 2 || !strings.Contains(res.ToolCalls[1], "go.dev/blog/go1.22") {
+		t.Errorf("expected the reformulated search and its evidence in tool calls, got %v", res.ToolCalls)
+	}
+}
+
+func TestExecute_ReformulatedSearchCountsAgainstToolBudget(t *testing.T) {
+	// The retry is a tool call like any other, so it needs budget left and spends one turn
+	bodies := []string{`{"action":"tool","tool":"search","query":"golang newest version day"}`}
+	for n := range 12 {
+		bodies = append(bodies, fmt.Sprintf(`{"action":"tool","tool":"search","query":"attempt %d"}`, n+1))
+	}
+	asked := reformulationLLM(t, `{"query":"Go 1.22 release date"}`, bodies...)
+	queries := &[]string{}
+	reg := tools.NewRegistry()
+	if err := reg.Register(scriptedSearch{queries: queries}); err != nil {
+		t.Fatal(err)
+	}
+	e := NewWithRegistry(nil, llm.New(), nil, reg)
+	st := types.SubTask{SubTaskID: "st1", Intent: "find when Go 1.22 was released",
+		SuccessCriteria: []string{"output states the Go 1.22 release date"}}
+	if _, _, err := e.execute(t.Context(), st, nil, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *asked != 1 || len(*queries) != 10 || (*queries)[1] != "Go 1.22 release date" {
+		t.Errorf("expected one reformulation within 10 searches, got asked=%d queries=%q", *asked, *queries)
+	}
+}
+
+func TestExecute_ReformulationNeverRepeatsAnEarlierSearch(t *testing.T) {
+	// The retry passes the duplicate guard: a rewritten query already searched is not run again
+	asked := reformulationLLM(t, `{"query":"Go 1.22 release notes"}`,
+		`{"action":"tool","tool":"search","query":"Go 1.22 release notes"}`,
+		`{"action":"tool","tool":"search","query":"golang newest version day"}`,
+		`{"action":"result","subtask_id":"st1","status":"completed","output":"2024-02-06","uncertainty":null}`,
+	)
+	queries := &[]string{}
+	reg := tools.NewRegistry()
+	if err := reg.Register(scriptedSearch{queries: queries, results: map[string]string{
+		"Go 1.22 release notes": "1. Go 1.22 Release Notes\n   https://go.dev/doc/go1.22",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	e := NewWithRegistry(nil, llm.New(), nil, reg)
+	st := types.SubTask{SubTaskID: "st1", Intent: "find when Go 1.22 was released",
+		SuccessCriteria: []string{"output states the Go 1.22 release date"}}
+	res, _, err := e.execute(t.Context(), st, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *asked != 1 || len(*queries) != 2 || len(res.ToolCalls) != 2 {
+		t.Errorf("expected the repeated query to be skipped, got asked=%d queries=%q", *asked, *queries)
+	}
+}
+
+func TestExecute_RelevantSearchIsNotReformulated(t *testing.T) {
+	// Returns false when any criterion keyword appears (case-insensitive)
+	asked := reformulationLLM(t, `{"query":"unused"}`,
+		`{"action":"tool","tool":"search","query":"Go 1.22 release date"}`,
+		`{"action":"result","subtask_id":"st1","status":"completed","output":"2024-02-06","uncertainty":null}`,
+	)
+	queries := &[]string{}
+	reg := tools.NewRegistry()
+	if err := reg.Register(scriptedSearch{queries: queries, results: map[string]string{
+		"Go 1.22 release date": "1. Go 1.22 Release Notes\n   https://go.dev/doc/go1.22",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	e := NewWithRegistry(nil, llm.New(), nil, reg)
+	st := types.SubTask{SubTaskID: "st1", Intent: "find when Go 1.22 was released",
+		SuccessCriteria: []string{"output states the Go 1.22 release date"}}
+	if _, _, err := e.execute(t.Context(), st, nil, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *asked != 0 || len(*queries) != 1 {
+		t.Errorf("expected a single search and no reformulation, got asked=%d queries=%v", *asked, *queries)
+	}
+}
+
+// ── tool batches ─────────────────────────────────────────────────────────────
+
+// turnLLM serves executor turns from bodies in order and returns the user
+// prompt of every turn it saw.
+func turnLLM(t *testing.T, bodies ...string) *[]string {
+	t.Helper()
+	var mu sync.Mutex
+	prompts := &[]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		body := bodies[min(len(*prompts), len(bodies)-1)]
+		*prompts = append(*prompts, req.Messages[len(req.Messages)-1].Content)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(body)))
+	}))
+	t.Cleanup(ts.Close)
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+	return prompts
+}
+
+func TestExecute_BatchOfReadFilesReturnsAllResults(t *testing.T) {
+	// A batch of independent read-only calls runs concurrently as one turn
+	dir := t.TempDir()
+	var calls []string
+	for _, name := range []string{"a", "b", "c"} {
+		path := filepath.Join(dir, name+".txt")
+		if err := os.WriteFile(path, []byte("contents of "+name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		calls = append(calls, fmt.Sprintf(`{"tool":"read_file","path":%q}`, path))
+	}
+	prompts := turnLLM(t,
+		`{"action":"tools","calls":[`+strings.Join(calls, ",")+`]}`,
+		`{"action":"result","subtask_id":"st1","status":"completed","output":"read all three","uncertainty":null}`,
+	)
+	e := New(nil, llm.New(), nil)
+	res, history, err := e.execute(t.Context(), types.SubTask{SubTaskID: "st1", Intent: "read three files"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Status != "completed" || len(*prompts) != 2 {
+		t.Fatalf("expected a completed result after one batch turn, got status=%q after %d turns", res.Status, len(*prompts))
+	}
+	if len(history) != 3 {
+		t.Errorf("expected 3 recorded tool calls, got %v", history)
+	}
+	for i, name := range []string{"a", "b", "c"} {
+		if !strings.Contains((*prompts)[1], "contents of "+name) {
+			t.Errorf("expected %s.txt's contents in the next prompt", name)
+		}
+		if i < len(history) && !strings.Contains(history[i], "contents of "+name) {
+			t.Errorf("tool call %d = %q, want %s.txt's evidence in call order", i+1, history[i], name)
+		}
+	}
+}
+
+func TestExecute_BatchResultsAreOneObservation(t *testing.T) {
+	// A tool batch's results are one entry, so the latest batch is never split
+	dir := t.TempDir()
+	write := func(name string) string {
+		path := filepath.Join(dir, name+".txt")
+		body := name + " starts\n" + strings.Repeat("z", 2000) + "\nEND OF " + name
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	first, second := write("first"), write("second")
+	var calls []string
+	for _, name := range []string{"a", "b", "c"} {
+		calls = append(calls, fmt.Sprintf(`{"tool":"read_file","path":%q}`, write(name)))
+	}
+	prompts := turnLLM(t,
+		fmt.Sprintf(`{"action":"tool","tool":"read_file","path":%q}`, first),
+		fmt.Sprintf(`{"action":"tool","tool":"read_file","path":%q}`, second),
+		`{"action":"tools","calls":[`+strings.Join(calls, ",")+`]}`,
+		`{"action":"result","subtask_id":"st1","status":"completed","output":"read them all","uncertainty":null}`,
+	)
+	e := New(nil, llm.New(), nil)
+	if _, _, err := e.execute(t.Context(), types.SubTask{SubTaskID: "st1", Intent: "read five files"}, nil, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*prompts) != 4 {
+		t.Fatalf("expected 4 turns, got %d", len(*prompts))
+	}
+	last := (*prompts)[3]
+	for _, name := range []string{"second", "a", "b", "c"} {
+		if !strings.Contains(last, "END OF "+name) {
+			t.Errorf("expected %s.txt verbatim in the prompt after the batch", name)
+		}
+	}
+	if strings.Contains(last, "END OF first") {
+		t.Error("expected the oldest single result folded to a digest")
+	}
+}
+
+func TestExecute_NeedReplanStopsWithReason(t *testing.T) {
+	// The model cannot tell how to proceed: no further tool calls are made, and the
+	// result is failed with NeedReplan carrying the reason
+	dir := t.TempDir()
+	prompts := turnLLM(t,
+		fmt.Sprintf(`{"action":"tool","tool":"glob","pattern":"*.pdf","root":%q}`, dir),
+		`{"action":"need_replan","reason":"  unclear whether the invoice is a personal file or part of the project  "}`,
+		`{"action":"tool","tool":"shell","command":"echo should not run"}`,
+	)
+	e := New(nil, llm.New(), nil)
+	res, history, err := e.execute(t.Context(), types.SubTask{SubTaskID: "st1", Intent: "find the invoice"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	const reason = "unclear whether the invoice is a personal file or part of the project"
+	if res.Status != "failed" || res.NeedReplan != reason || res.Uncertainty == nil || *res.Uncertainty != reason {
+		t.Errorf("got status=%q need_replan=%q, want failed with the trimmed reason", res.Status, res.NeedReplan)
+	}
+	if len(*prompts) != 2 || len(history) != 1 || !strings.HasPrefix(history[0], "glob:") {
+		t.Errorf("expected to stop after the glob call, got %d turns and history %v", len(*prompts), history)
+	}
+}
+
+func TestNeedReplanResult_DefaultsEmptyReason(t *testing.T) {
+	// Falls back to a generic reason when the model gave none; keeps the tool calls and artifacts
+	res := needReplanResult("st1", " ", []string{"glob:*.pdf"}, []string{"/tmp/a"})
+	if res.NeedReplan == "" || res.Output != "need_replan: "+res.NeedReplan || len(res.ToolCalls) != 1 || len(res.Artifacts) != 1 {
+		t.Errorf("got %+v", res)
+	}
+}
+
+func TestExecute_BatchWithWriteFileIsRejected(t *testing.T) {
+	// Returns an error naming the tool when any call is not in batchTools; nothing in the batch runs
+	dir := t.TempDir()
+	readPath, writePath := filepath.Join(dir, "in.txt"), filepath.Join(dir, "out.txt")
+	if err := os.WriteFile(readPath, []byte("secret input"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prompts := turnLLM(t,
+		fmt.Sprintf(`{"action":"tools","calls":[{"tool":"read_file","path":%q},{"tool":"write_file","path":%q,"content":"x"}]}`, readPath, writePath),
+		`{"action":"result","subtask_id":"st1","status":"failed","output":"batch rejected","uncertainty":null}`,
+	)
+	e := New(nil, llm.New(), nil)
+	_, history, err := e.execute(t.Context(), types.SubTask{SubTaskID: "st1", Intent: "copy a file"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*prompts) != 2 || !strings.Contains((*prompts)[1], "BATCH REJECTED") || !strings.Contains((*prompts)[1], `"write_file"`) {
+		t.Fatalf("expected the rejection naming write_file in the next prompt, got %q", *prompts)
+	}
+	if strings.Contains((*prompts)[1], "secret input") || len(history) != 0 {
+		t.Errorf("no call in a rejected batch should run, got history %v", history)
+	}
+	if _, err := os.Stat(writePath); !os.IsNotExist(err) {
+		t.Errorf("write_file in a rejected batch wrote %s", writePath)
+	}
+}
+
+func TestExecute_RepeatedCallIsBlocked(t *testing.T) {
+	// Loop detection: an identical consecutive call is blocked with a notice, not run again
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("contents of a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	read := fmt.Sprintf(`{"action":"tool","tool":"read_file","path":%q}`, path)
+	prompts := turnLLM(t, read, read,
+		`{"action":"result","subtask_id":"st1","status":"completed","output":"read a","uncertainty":null}`)
+	e := New(nil, llm.New(), nil)
+	_, history, err := e.execute(t.Context(), types.SubTask{SubTaskID: "st1", Intent: "read a file"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(history) != 1 || len(*prompts) != 3 || !strings.Contains((*prompts)[2], "DUPLICATE CALL BLOCKED") {
+		t.Errorf("expected the repeat to be blocked with a notice, got history %v after %d turns", history, len(*prompts))
+	}
+}
+
+func TestExecute_BatchBlocksDuplicateCalls(t *testing.T) {
+	// The duplicate guard applies to each call: one repeating the previous call, or an
+	// earlier call in the batch, is blocked and the rest run
+	dir := t.TempDir()
+	call := map[string]string{}
+	for _, name := range []string{"a", "b"} {
+		path := filepath.Join(dir, name+".txt")
+		if err := os.WriteFile(path, []byte("contents of "+name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		call[name] = fmt.Sprintf(`{"tool":"read_file","path":%q}`, path)
+	}
+	prompts := turnLLM(t,
+		`{"action":"tool",`+strings.TrimPrefix(call["a"], "{"),
+		`{"action":"tools","calls":[`+call["a"]+`,`+call["b"]+`,`+call["b"]+`]}`,
+		`{"action":"result","subtask_id":"st1","status":"completed","output":"read both","uncertainty":null}`,
+	)
+	e := New(nil, llm.New(), nil)
+	_, history, err := e.execute(t.Context(), types.SubTask{SubTaskID: "st1", Intent: "read two files"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(history) != 2 || !strings.Contains(history[1], "contents of b") {
+		t.Errorf("expected a then b to run once each, got history %v", history)
+	}
+	if len(*prompts) != 3 || strings.Count((*prompts)[2], "DUPLICATE CALL BLOCKED") != 2 {
+		t.Errorf("expected two blocked calls in the batch turn's result, got %q", (*prompts)[len(*prompts)-1])
+	}
+}
+
+func TestExecute_RewriteWithNewContentIsNotADuplicate(t *testing.T) {
+	// A write_file to the same path with different content is a new call, not a duplicate
+	path := filepath.Join(t.TempDir(), "report.md")
+	prompts := turnLLM(t,
+		fmt.Sprintf(`{"action":"tool","tool":"write_file","path":%q,"content":"draft"}`, path),
+		fmt.Sprintf(`{"action":"tool","tool":"write_file","path":%q,"content":"final"}`, path),
+		`{"action":"result","subtask_id":"st1","status":"completed","output":"wrote the report","uncertainty":null}`,
+	)
+	e := New(nil, llm.New(), nil)
+	_, history, err := e.execute(t.Context(), types.SubTask{SubTaskID: "st1", Intent: "write a report"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(history) != 2 || len(*prompts) != 3 || strings.Contains((*prompts)[2], "DUPLICATE CALL BLOCKED") {
+		t.Errorf("expected the second write to run rather than be blocked, got history %v", history)
+	}
+}
+
+// ── write verification ───────────────────────────────────────────────────────
+
+func TestExecute_WriteFileResultCarriesVerification(t *testing.T) {
+	// Returns "ok, verified <abs path> (<n> bytes)" when the file holds tc.Content
+	path := filepath.Join(t.TempDir(), "report.md")
+	prompts := turnLLM(t,
+		fmt.Sprintf(`{"action":"tool","tool":"write_file","path":%q,"content":"# Report\n"}`, path),
+		`{"action":"result","subtask_id":"st1","status":"completed","output":"wrote the report","uncertainty":null}`,
+	)
+	e := New(nil, llm.New(), nil)
+	res, history, err := e.execute(t.Context(), types.SubTask{SubTaskID: "st1", Intent: "write a report"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := fmt.Sprintf("ok, verified %s (9 bytes)", path)
+	if len(history) != 1 || !strings.Contains(history[0], want) {
+		t.Errorf("expected tool call evidence %q, got %v", want, history)
+	}
+	if len(*prompts) != 2 || !strings.Contains((*prompts)[1], want) {
+		t.Errorf("expected %q in the next prompt, got %q", want, *prompts)
+	}
+	if !slices.Equal(res.Artifacts, []string{path}) {
+		t.Errorf("expected the verified file as an artifact, got %v", res.Artifacts)
+	}
+}
+
+func TestExecute_WriteVerificationOffKeepsBareOK(t *testing.T) {
+	// verifyWritesFromEnv: verification is on unless ARTOO_VERIFY_WRITES parses as false
+	t.Setenv("ARTOO_VERIFY_WRITES", "false")
+	path := filepath.Join(t.TempDir(), "report.md")
+	turnLLM(t,
+		fmt.Sprintf(`{"action":"tool","tool":"write_file","path":%q,"content":"x"}`, path),
+		`{"action":"result","subtask_id":"st1","status":"completed","output":"done","uncertainty":null}`,
+	)
+	e := New(nil, llm.New(), nil)
+	res, history, err := e.execute(t.Context(), types.SubTask{SubTaskID: "st1", Intent: "write a file"}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(history) != 1 || !strings.HasSuffix(history[0], "→ ok") {
+		t.Errorf("expected a bare ok, got %v", history)
+	}
+	if !slices.Equal(res.Artifacts, []string{path}) {
+		t.Errorf("expected the written file as an artifact, got %v", res.Artifacts)
+	}
+}
+
+func TestVerifiedWrite_ReportsMissingAndEmptyFiles(t *testing.T) {
+	// Returns an error when the file is missing, empty, or differs in size or leading bytes
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.txt")
+	if _, err := verifiedWrite(toolCall{Tool: "write_file", Path: missing, Content: "data"}); err == nil {
+		t.Errorf("expected an error for a file that was never written")
+	}
+	empty := filepath.Join(dir, "empty.txt")
+	if err := os.WriteFile(empty, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := verifiedWrite(toolCall{Tool: "write_file", Path: empty, Content: "data"})
+	if err == nil || !strings.Contains(err.Error(), "holds 0 bytes") {
+		t.Errorf("expected a zero-byte error, got %v", err)
+	}
+}
+
+func TestVerifyWritesFromEnv(t *testing.T) {
+	// Verification is on unless ARTOO_VERIFY_WRITES parses as false
+	for v, want := range map[string]bool{"": true, "true": true, "1": true, "false": false, "0": false, "maybe": true} {
+		t.Setenv("ARTOO_VERIFY_WRITES", v)
+		if got := verifyWritesFromEnv(); got != want {
+			t.Errorf("ARTOO_VERIFY_WRITES=%q: got %v, want %v", v, got, want)
+		}
+	}
+}
+
+// ── observation folding ──────────────────────────────────────────────────────
+
+// toolResultEntry builds a toolResults entry the way execute does for a successful call.
+func toolResultEntry(tool, body string) string {
+	return fmt.Sprintf("Tool %s result:\n%s\n", tool, body)
+}
+
+func TestFoldObservations_SmallResultsUnchanged(t *testing.T) {
+	// Returns results unchanged when their total length is within observationFoldChars
+	results := []string{toolResultEntry("shell", "a"), toolResultEntry("glob", "b.go")}
+	if got := foldObservations(results); !slices.Equal(got, results) {
+		t.Errorf("expected results unchanged, got %q", got)
+	}
+}
+
+func TestFoldObservations_FoldsOlderKeepsNewest(t *testing.T) {
+	// Otherwise keeps the newest observationKeepRecent results verbatim, after one summary part
+	var results []string
+	for i := range 6 {
+		results = append(results, toolResultEntry("read_file", fmt.Sprintf("file %d starts here\n%s\nEND %d", i, strings.Repeat("x", 1500), i)))
+	}
+	orig := slices.Clone(results)
+	got := foldObservations(results)
+	if len(got) != 1+observationKeepRecent {
+		t.Fatalf("expected a summary plus %d results, got %d parts", observationKeepRecent, len(got))
+	}
+	if !slices.Equal(got[1:], results[len(results)-observationKeepRecent:]) {
+		t.Error("expected the newest results verbatim after the summary")
+	}
+	// The summary has one digest line per older result: its header line and the start of its content
+	summary := got[0]
+	for i := range 4 {
+		if !strings.Contains(summary, fmt.Sprintf("- Tool read_file result: file %d starts here", i)) {
+			t.Errorf("summary lacks a digest of result %d:\n%s", i, summary)
+		}
+		if strings.Contains(summary, fmt.Sprintf("END %d", i)) {
+			t.Errorf("summary carries the full body of result %d", i)
+		}
+	}
+	// Never modifies results
+	if !slices.Equal(results, orig) {
+		t.Error("foldObservations modified its input")
+	}
+}
+
+func TestFoldObservations_SummaryIsCapped(t *testing.T) {
+	// Drops the oldest digest lines (and says how many) to keep the summary within observationSummaryChars
+	var results []string
+	for i := range 60 {
+		results = append(results, toolResultEntry("shell", fmt.Sprintf("run %02d %s", i, strings.Repeat("y", 400))))
+	}
+	got := foldObservations(results)
+	if n := len(got[0]); n > observationSummaryChars+200 {
+		t.Errorf("summary is %d chars, want about %d at most", n, observationSummaryChars)
+	}
+	if !strings.Contains(got[0], "earliest results omitted") || strings.Contains(got[0], "run 00") {
+		t.Errorf("expected the oldest digests dropped with a note:\n%s", got[0])
+	}
+	if !strings.Contains(got[0], "run 57") {
+		t.Errorf("expected the newest folded result's digest kept:\n%s", got[0])
+	}
+}
+
+func TestExecute_ManyToolTurnsSendBoundedContext(t *testing.T) {
+	// Each turn sees them through foldObservations: after many tool calls the per-turn
+	// context is a bounded summary plus the newest results, not the growing raw concatenation
+	dir := t.TempDir()
+	const calls = 8
+	var bodies []string
+	partLen := 0
+	for i := range calls {
+		path := filepath.Join(dir, fmt.Sprintf("part%d.txt", i))
+		content := fmt.Sprintf("part %d header\n%s\nTAIL-MARKER-%d", i, strings.Repeat(fmt.Sprintf("row %d ", i), 300), i)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, fmt.Sprintf(`{"action":"tool","tool":"read_file","path":%q}`, path))
+		partLen = max(partLen, len(content))
+	}
+	bodies = append(bodies, `{"action":"result","subtask_id":"st1","status":"completed","output":"read every part","uncertainty":null}`)
+	prompts := turnLLM(t, bodies...)
+
+	e := New(nil, llm.New(), nil)
+	res, _, err := e.execute(t.Context(), types.SubTask{SubTaskID: "st1", Intent: "read every part"}, nil, nil, nil)
+	if err != nil || res.Status != "completed" {
+		t.Fatalf("expected a completed result, got %+v, %v", res, err)
+	}
+	if len(*prompts) != calls+1 {
+		t.Fatalf("expected %d turns, got %d", calls+1, len(*prompts))
+	}
+	last := (*prompts)[calls]
+	if !strings.Contains(last, "Summary of 6 earlier tool results") {
+		t.Errorf("expected the last turn to carry a summary of the older results")
+	}
+	if strings.Contains(last, "TAIL-MARKER-0") || !strings.Contains(last, "part 0 header") {
+		t.Errorf("expected result 0 as a digest, not in full")
+	}
+	if !strings.Contains(last, "TAIL-MARKER-7") || !strings.Contains(last, "TAIL-MARKER-6") {
+		t.Errorf("expected the newest two results in full")
+	}
+	// Bounded by the summary budget plus the two newest results (the raw results
+	// would fill the whole 8000-char window).
+	bound := observationSummaryChars + 2*(partLen+100) + 200
+	if grow := len(last) - len((*prompts)[0]); grow > bound {
+		t.Errorf("tool results add %d chars to turn %d; want at most %d", grow, calls+1, bound)
+	}
+}
+
+func TestRunMessageTool_DraftThenSendWithToken(t *testing.T) {
+	// Without a token, stores a draft and returns it with its token; nothing is sent
+	// With a token, sends only that stored draft; draft fields in tc are ignored
+	// Reports validation and send failures as tool output, not as errors
+	var scripts []string
+	m := tools.NewMessengerWith(func(_ context.Context, script string) (string, error) {
+		scripts = append(scripts, script)
+		return "", nil
+	})
+
+	out := runMessageTool(t.Context(), m, toolCall{Tool: "message", To: "bob@example.com", Subject: "Summary", Content: "All done."})
+	if len(scripts) != 0 {
+		t.Fatalf("draft step sent %d messages", len(scripts))
+	}
+	if !strings.HasPrefix(out, "DRAFT (not sent): email to bob@example.com") || !strings.Contains(out, "All done.") {
+		t.Errorf("draft output = %q", out)
+	}
+	start := strings.Index(out, `{"token":"`)
+	if start < 0 {
+		t.Fatalf("draft output has no token: %q", out)
+	}
+	token := out[start+len(`{"token":"`):]
+	token = token[:strings.Index(token, `"`)]
+
+	if out := runMessageTool(t.Context(), m, toolCall{Tool: "message", Token: "0000000000000000", To: "eve@example.com", Content: "x"}); !strings.HasPrefix(out, "message error:") {
+		t.Errorf("send with a wrong token = %q, want message error", out)
+	}
+	if len(scripts) != 0 {
+		t.Fatalf("wrong token sent %d messages", len(scripts))
+	}
+
+	out = runMessageTool(t.Context(), m, toolCall{Tool: "message", Token: token, To: "eve@example.com"})
+	if out != `sent email to bob@example.com — subject "Summary"` {
+		t.Errorf("send output = %q", out)
+	}
+	if len(scripts) != 1 || strings.Contains(scripts[0], "eve@") || !strings.Contains(scripts[0], `"bob@example.com"`) {
+		t.Errorf("sent scripts = %q, want one send to the drafted recipient", scripts)
+	}
+}
@@ -0,0 +1,194 @@
+package ggs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+)
+
+// Regions a round's signals fall into, judged against the Hyperparams
+// thresholds with the same boundary rules as selectDirective.
+const (
+	OmegaWithin    = "within"    // Ω < abandon budget
+	OmegaExhausted = "exhausted" // Ω >= abandon budget
+	DFar           = "far"       // D > δ
+	DNear          = "near"      // D <= δ
+	GradFlat       = "flat"      // |∇L| < ε
+	GradImproving  = "improving" // ∇L <= -ε
+	GradWorsening  = "worsening" // ∇L >= ε
+	PLow           = "low"       // P <= ρ: environmental failure
+	PHigh          = "high"      // P > ρ: logical failure
+)
+
+// anyRegion matches every region of a field; an omitted field means the same.
+const anyRegion = "*"
+
+var (
+	omegaRegions = []string{OmegaWithin, OmegaExhausted}
+	dRegions     = []string{DFar, DNear}
+	gradRegions  = []string{GradFlat, GradImproving, GradWorsening}
+	pRegions     = []string{PLow, PHigh}
+
+	// directives are the macro-states a rule may select.
+	directives = []string{"success", "abandon", "refine", "change_path", "change_approach", "break_symmetry"}
+)
+
+// DecisionRule maps one combination of regions to a directive. An empty or
+// "*" region matches every region of that field.
+type DecisionRule struct {
+	Omega     string `json:"omega,omitempty"`
+	D         string `json:"d,omitempty"`
+	Grad      string `json:"grad,omitempty"`
+	P         string `json:"p,omitempty"`
+	Directive string `json:"directive"`
+}
+
+// DecisionTable replaces the built-in diagnostic cascade: the first rule whose
+// regions all match a round selects its directive. LoadDecisionTable only
+// returns tables that cover every combination of regions.
+type DecisionTable struct {
+	Rules []DecisionRule `json:"rules"`
+}
+
+// DefaultDecisionTable returns the built-in v0.8 cascade as a table — a
+// starting point for a GGS_DECISION_TABLE file.
+func DefaultDecisionTable() DecisionTable {
+	return DecisionTable{Rules: []DecisionRule{
+		{Omega: OmegaExhausted, Directive: "abandon"},
+		{D: DNear, Directive: "success"},
+		{Grad: GradFlat, P: PHigh, Directive: "break_symmetry"},
+		{P: PHigh, Directive: "change_approach"},
+		{Grad: GradFlat, Directive: "change_path"},
+		{Directive: "refine"},
+	}}
+}
+
+// LoadDecisionTable reads a JSON decision table from path and validates it.
+//
+// Expectations:
+//   - Returns an error when the file is unreadable, is not JSON, or has unknown fields
+//   - Returns an error naming the rule when a region or directive is not a known one
+//   - Returns an error naming the first combination of regions no rule matches
+func LoadDecisionTable(path string) (*DecisionTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var t DecisionTable
+	if err := dec.Decode(&t); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// Validate checks every rule's regions and directive, and that the rules
+// cover every combination of regions.
+//
+// Expectations:
+//   - Returns an error naming the rule (1-based) and field for an unknown region
+//   - Returns an error naming the rule for an unknown or missing directive
+//   - Returns an error naming the first combination of regions no rule matches
+func (t DecisionTable) Validate() error {
+	for i, r := range t.Rules {
+		for _, f := range []struct {
+			name, value string
+			regions     []string
+		}{
+			{"omega", r.Omega, omegaRegions},
+			{"d", r.D, dRegions},
+			{"grad", r.Grad, gradRegions},
+			{"p", r.P, pRegions},
+		} {
+			if f.value != "" && f.value != anyRegion && !slices.Contains(f.regions, f.value) {
+				return fmt.Errorf("rule %d: %s %q is not one of %v or %q", i+1, f.name, f.value, f.regions, anyRegion)
+			}
+		}
+		if !slices.Contains(directives, r.Directive) {
+			return fmt.Errorf("rule %d: directive %q is not one of %v", i+1, r.Directive, directives)
+		}
+	}
+	for _, omega := range omegaRegions {
+		for _, d := range dRegions {
+			for _, grad := range gradRegions {
+				for _, p := range pRegions {
+					if _, ok := t.lookup(omega, d, grad, p); !ok {
+						return fmt.Errorf("no rule matches omega=%s d=%s grad=%s p=%s", omega, d, grad, p)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// lookup returns the directive of the first rule matching the regions.
+func (t DecisionTable) lookup(omega, d, grad, p string) (string, bool) {
+	for _, r := range t.Rules {
+		if regionMatches(r.Omega, omega) && regionMatches(r.D, d) && regionMatches(r.Grad, grad) && regionMatches(r.P, p) {
+			return r.Directive, true
+		}
+	}
+	return "", false
+}
+
+// regionMatches reports whether a rule's region accepts the round's region.
+func regionMatches(rule, region string) bool {
+	return rule == "" || rule == anyRegion || rule == region
+}
+
+// regions places a round's signals in their regions under h's thresholds.
+//
+// Expectations:
+//   - Uses selectDirective's boundaries: Ω = θ is exhausted, D = δ is near,
+//     |∇L| = ε is a signal, P = ρ is low
+func (h Hyperparams) regions(gradL, D, P, Omega float64) (omega, d, grad, p string) {
+	omega, d, grad, p = OmegaWithin, DFar, GradFlat, PLow
+	if atLeast(Omega, h.AbandonOmega) {
+		omega = OmegaExhausted
+	}
+	if atMost(D, h.Delta) {
+		d = DNear
+	}
+	if atLeast(math.Abs(gradL), h.Epsilon) {
+		grad = GradWorsening
+		if gradL < 0 {
+			grad = GradImproving
+		}
+	}
+	if !atMost(P, h.Rho) {
+		p = PHigh
+	}
+	return omega, d, grad, p
+}
+
+// SetDecisionTable makes GGS select directives from t instead of the built-in
+// cascade; nil restores the cascade. t should come from LoadDecisionTable or
+// pass Validate. Call before Run.
+func (g *GGS) SetDecisionTable(t *DecisionTable) {
+	g.table = t
+}
+
+// selectDirective picks the round's macro-state: from the decision table when
+// one is set, otherwise from the built-in cascade (Hyperparams.selectDirective).
+//
+// Expectations:
+//   - Returns the cascade's directive when no table is set
+//   - Returns the first matching rule's directive when a table is set
+//   - Falls back to the cascade if a table somehow matches nothing
+func (g *GGS) selectDirective(gradL, D, P, Omega float64) string {
+	if g.table == nil {
+		return g.hp.selectDirective(gradL, D, P, Omega)
+	}
+	if directive, ok := g.table.lookup(g.hp.regions(gradL, D, P, Omega)); ok {
+		return directive
+	}
+	return g.hp.selectDirective(gradL, D, P, Omega)
+}
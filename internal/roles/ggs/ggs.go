@@ -3,35 +3,184 @@ package ggs
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/clock"
 	"github.com/haricheung/agentic-shell/internal/roles/memory"
 	"github.com/haricheung/agentic-shell/internal/tasklog"
 	"github.com/haricheung/agentic-shell/internal/types"
 )
 
-// Loss hyperparameters (v0.8 defaults).
+// Loss hyperparameters (v0.8 defaults; override with GGS_* env, see LoadHyperparams).
 const (
 	alpha         = 0.6     // weight on intent-result distance D
 	beta          = 0.3     // weight on process implausibility P (before adaptive scaling)
 	lambda        = 0.4     // weight on resource cost Ω
 	w1            = 0.6     // Ω sub-weight for replan count
 	w2            = 0.4     // Ω sub-weight for elapsed time
-	epsilon       = 0.1     // |∇L| below this → plateau (no signal)
-	delta         = 0.3     // D below this → success (convergence threshold)
-	rho           = 0.5     // P above this → logical failure; below → environmental
-	abandonOmega  = 0.8     // Ω above this → abandon regardless of other signals
+	epsilon       = 0.1     // |∇L| below this → plateau (no signal); at or above → signal
+	delta         = 0.3     // D at or below this → success (convergence threshold)
+	rho           = 0.5     // P above this → logical failure; at or below → environmental
+	abandonOmega  = 0.8     // Ω at or above this → abandon regardless of other signals
 	timeBudgetMs  = 300_000 // default time budget per task (5 min)
 	maxReplansGGS = 3       // matches R4b's maxReplans; used in Ω computation
+
+	defaultLaw2KillThreshold = 2 // consecutive worsening rounds before Law 2 forces abandon
 )
 
+// Hyperparams are the loss weights and decision thresholds GGS computes L and
+// selects directives with. DefaultHyperparams holds the v0.8 values.
+type Hyperparams struct {
+	Alpha        float64 // weight on intent-result distance D
+	Beta         float64 // weight on process implausibility P (before adaptive scaling)
+	Lambda       float64 // weight on resource cost Ω
+	W1           float64 // Ω sub-weight for replan count
+	W2           float64 // Ω sub-weight for elapsed time
+	Epsilon      float64 // |∇L| at or above this is a signal
+	Delta        float64 // D at or below this is success
+	Rho          float64 // P above this is a logical failure
+	AbandonOmega float64 // Ω at or above this abandons
+	Law2Kill     int     // consecutive worsening rounds before Law 2 forces abandon
+}
+
+// DefaultHyperparams returns the compiled-in v0.8 hyperparameters.
+func DefaultHyperparams() Hyperparams {
+	return Hyperparams{
+		Alpha: alpha, Beta: beta, Lambda: lambda, W1: w1, W2: w2,
+		Epsilon: epsilon, Delta: delta, Rho: rho, AbandonOmega: abandonOmega,
+		Law2Kill: defaultLaw2KillThreshold,
+	}
+}
+
+// LoadHyperparams reads GGS_ALPHA, GGS_BETA, GGS_LAMBDA, GGS_W1, GGS_W2,
+// GGS_EPSILON, GGS_DELTA, GGS_RHO, GGS_ABANDON_OMEGA, and
+// GGS_LAW2_KILL_THRESHOLD, starting from DefaultHyperparams for any that are
+// unset. A kill threshold above maxReplansGGS could never fire before the
+// replan budget runs out, so it is rejected.
+//
+// Expectations:
+//   - Returns DefaultHyperparams when no GGS_* variable is set
+//   - Overrides only the variables that are set
+//   - Returns an error naming the variable when a value is not a number
+//   - Returns an error when a value is outside [0, 1], when GGS_EPSILON or
+//     GGS_ABANDON_OMEGA is 0, or when GGS_W1 and GGS_W2 are both 0
+//   - Returns an error when GGS_LAW2_KILL_THRESHOLD is not an integer in [1, maxReplansGGS]
+func LoadHyperparams() (Hyperparams, error) {
+	h := DefaultHyperparams()
+	for _, f := range []struct {
+		env string
+		dst *float64
+	}{
+		{"GGS_ALPHA", &h.Alpha},
+		{"GGS_BETA", &h.Beta},
+		{"GGS_LAMBDA", &h.Lambda},
+		{"GGS_W1", &h.W1},
+		{"GGS_W2", &h.W2},
+		{"GGS_EPSILON", &h.Epsilon},
+		{"GGS_DELTA", &h.Delta},
+		{"GGS_RHO", &h.Rho},
+		{"GGS_ABANDON_OMEGA", &h.AbandonOmega},
+	} {
+		raw := strings.TrimSpace(os.Getenv(f.env))
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return DefaultHyperparams(), fmt.Errorf("%s: %q is not a number", f.env, raw)
+		}
+		if v < 0 || v > 1 {
+			return DefaultHyperparams(), fmt.Errorf("%s: %v is outside [0, 1]", f.env, v)
+		}
+		*f.dst = v
+	}
+	if raw := strings.TrimSpace(os.Getenv("GGS_LAW2_KILL_THRESHOLD")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return DefaultHyperparams(), fmt.Errorf("GGS_LAW2_KILL_THRESHOLD: %q is not an integer", raw)
+		}
+		if n < 1 || n > maxReplansGGS {
+			return DefaultHyperparams(), fmt.Errorf("GGS_LAW2_KILL_THRESHOLD: %d is outside [1, %d]", n, maxReplansGGS)
+		}
+		h.Law2Kill = n
+	}
+	switch {
+	case h.Epsilon == 0:
+		return DefaultHyperparams(), fmt.Errorf("GGS_EPSILON must be above 0 (every gradient would count as a signal)")
+	case h.AbandonOmega == 0:
+		return DefaultHyperparams(), fmt.Errorf("GGS_ABANDON_OMEGA must be above 0 (every task would abandon at once)")
+	case h.W1 == 0 && h.W2 == 0:
+		return DefaultHyperparams(), fmt.Errorf("GGS_W1 and GGS_W2 cannot both be 0 (Ω would never grow)")
+	}
+	return h, nil
+}
+
+// FailureKeywords are the phrases computePKeyword looks for in a failed
+// subtask's failure reason and unmet criteria when R4a gave no failure_class:
+// a Logical phrase marks the approach as wrong, an Environmental one blames the
+// environment. Phrases match as case-insensitive substrings.
+type FailureKeywords struct {
+	Logical       []string
+	Environmental []string
+}
+
+// DefaultFailureKeywords returns the compiled-in English keyword lists.
+func DefaultFailureKeywords() FailureKeywords {
+	return FailureKeywords{
+		Logical: []string{"logic", "wrong approach", "incorrect", "invalid", "cannot", "not possible",
+			"permission denied", "operation not permitted"},
+		Environmental: []string{"network", "timeout", "context deadline", "connection", "unavailable",
+			"not found", "no such file", "temporary", "rate limit"},
+	}
+}
+
+// LoadFailureKeywords returns DefaultFailureKeywords extended with the
+// comma-separated phrases in GGS_LOGICAL_KEYWORDS and GGS_ENV_KEYWORDS, e.g.
+// GGS_ENV_KEYWORDS="err-5031,quota exceeded,服务不可用" for internal error codes
+// or non-English messages.
+//
+// Expectations:
+//   - Returns DefaultFailureKeywords when neither variable is set
+//   - Appends each listed phrase, trimmed and lower-cased, after the defaults
+//   - Skips empty phrases and phrases already in the list
+func LoadFailureKeywords() FailureKeywords {
+	k := DefaultFailureKeywords()
+	k.Logical = appendKeywords(k.Logical, os.Getenv("GGS_LOGICAL_KEYWORDS"))
+	k.Environmental = appendKeywords(k.Environmental, os.Getenv("GGS_ENV_KEYWORDS"))
+	return k
+}
+
+// appendKeywords appends the comma-separated phrases of raw to list.
+func appendKeywords(list []string, raw string) []string {
+	for _, kw := range strings.Split(raw, ",") {
+		kw = strings.ToLower(strings.TrimSpace(kw))
+		if kw != "" && !slices.Contains(list, kw) {
+			list = append(list, kw)
+		}
+	}
+	return list
+}
+
+// Budget returns the per-task budget GGS charges Ω against: the replan rounds and
+// wall-clock time after which a task counts as fully spent.
+func Budget() (maxReplans int, timeBudget time.Duration) {
+	return maxReplansGGS, timeBudgetMs * time.Millisecond
+}
+
 // GGS is R7 — Goal Gradient Solver. It sits between R4b (sensor) and R2 (actuator)
 // in the medium loop. It receives ReplanRequest from R4b, computes D, P, Ω, L, ∇L,
 // selects a macro-state from the v0.8 decision table, and either emits PlanDirective
@@ -41,18 +190,47 @@ type GGS struct {
 	b              *bus.Bus
 	mem            types.MemoryService // R5 — sole writer; may be nil (memory disabled)
 	logReg         *tasklog.Registry   // per-task structured log; may be nil (logging disabled)
-	outputFn       func(taskID, summary string, output any)
+	outputFn       func(types.FinalResult)
 	mu             sync.Mutex
 	lPrev          map[string]float64  // L_{t-1} per task_id
 	replans        map[string]int      // replan round counter per task_id
 	worseningCount map[string]int      // consecutive "worsening" gradient count per task_id
 	triedTargets   map[string][]string // accumulated failed tool inputs per task_id (for environmental directives)
 	prevDirective  map[string]string   // macro-state from the previous round per task_id
+	artifacts      map[string][]string // files written so far per task_id, across replan rounds
+	roundBase      map[string]int      // rounds restored from a checkpoint; R4b's ReplanRequest.Round restarts at 1 after a restart
+	terminal       map[string]bool     // task_ids that reached accept/success/abandon; cleared by a new TaskSpec
+	terminalOrder  []string            // task_ids in terminal, oldest first; bounded by maxTerminalMarks
+	runIDs         map[string]string   // per-run ID of each task_id (its TaskSpec message ID); replaced by a new TaskSpec
+	runOrder       []string            // task_ids in runIDs, least recently started first; bounded by maxRunIDs
+	assumptions    map[string][]string // TaskSpec.Assumptions per task_id, attached to its FinalResult by deliver
+	checkpointDir  string              // per-task state checkpoints; "" disables (see NewWithCheckpoints)
+
+	// trajectory holds the recent RoundSnapshots of each live task; forget moves
+	// them to finished, which keeps the last maxFinishedTrajectories tasks.
+	trajectory    map[string][]RoundSnapshot
+	finished      map[string][]RoundSnapshot
+	finishedOrder []string // task_ids in finished, oldest first
+
+	// megramSample is the fraction of routine per-tool-call Megrams written (see
+	// megramSampleFromEnv); megramRoutine counts the routine ones seen so far.
+	megramSample  float64
+	megramRoutine int
+
+	// hp holds the loss weights and thresholds; DefaultHyperparams unless SetHyperparams is called.
+	hp Hyperparams
+	// kw classifies free-text failure reasons for P; DefaultFailureKeywords unless SetFailureKeywords is called.
+	kw FailureKeywords
+	// clock stamps RoundSnapshots, Megrams and outbound messages; clock.Wall unless SetClock is called.
+	clock clock.Clock
+	// table replaces the built-in cascade when set (see SetDecisionTable); nil uses the cascade.
+	table *DecisionTable
 }
 
-// New creates a GGS. mem may be nil to disable memory writes (e.g. in tests).
+// New creates a GGS. outputFn receives every FinalResult GGS publishes and may
+// be nil. mem may be nil to disable memory writes (e.g. in tests).
 // logReg may be nil to disable per-task decision logging (e.g. in tests).
-func New(b *bus.Bus, outputFn func(taskID, summary string, output any), mem types.MemoryService, logReg *tasklog.Registry) *GGS {
+func New(b *bus.Bus, outputFn func(types.FinalResult), mem types.MemoryService, logReg *tasklog.Registry) *GGS {
 	return &GGS{
 		b:              b,
 		mem:            mem,
@@ -63,20 +241,126 @@ func New(b *bus.Bus, outputFn func(taskID, summary string, output any), mem type
 		worseningCount: make(map[string]int),
 		triedTargets:   make(map[string][]string),
 		prevDirective:  make(map[string]string),
+		artifacts:      make(map[string][]string),
+		roundBase:      make(map[string]int),
+		terminal:       make(map[string]bool),
+		runIDs:         make(map[string]string),
+		assumptions:    make(map[string][]string),
+		trajectory:     make(map[string][]RoundSnapshot),
+		finished:       make(map[string][]RoundSnapshot),
+		megramSample:   megramSampleFromEnv(),
+		hp:             DefaultHyperparams(),
+		kw:             DefaultFailureKeywords(),
+		clock:          clock.Wall,
+	}
+}
+
+// SetHyperparams replaces the loss weights and thresholds (see LoadHyperparams).
+// Call before Run.
+func (g *GGS) SetHyperparams(h Hyperparams) {
+	g.hp = h
+}
+
+// SetFailureKeywords replaces the keyword lists P falls back on (see
+// LoadFailureKeywords). Call before Run.
+func (g *GGS) SetFailureKeywords(k FailureKeywords) {
+	g.kw = k
+}
+
+// SetClock replaces the clock GGS stamps snapshots, Megrams and messages with;
+// nil restores clock.Wall. Call before Run.
+func (g *GGS) SetClock(c clock.Clock) {
+	if c == nil {
+		c = clock.Wall
+	}
+	g.clock = c
+}
+
+// megramSampleFromEnv reads ARTOO_MEGRAM_SAMPLE: the fraction, in [0, 1], of
+// routine per-tool-call Megrams GGS writes. A round is routine when its directive
+// repeats the previous round's (refine after refine); rounds that change the
+// directive, and terminal states, are always written.
+//
+// Expectations:
+//   - Returns 1 (write every Megram) when unset or unparseable
+//   - Clamps values outside [0, 1]
+func megramSampleFromEnv() float64 {
+	v, err := strconv.ParseFloat(os.Getenv("ARTOO_MEGRAM_SAMPLE"), 64)
+	if err != nil {
+		return 1
 	}
+	return math.Max(0, math.Min(1, v))
+}
+
+// NewWithCheckpoints is New with per-task controller state (L_prev, replan count,
+// worsening count, tried targets, previous directive) checkpointed as JSON under
+// dir after every replan round, so a restarted process resumes a task's loss
+// gradient instead of starting from scratch. Checkpoints are removed when the
+// task reaches a terminal state.
+func NewWithCheckpoints(b *bus.Bus, outputFn func(types.FinalResult), mem types.MemoryService, logReg *tasklog.Registry, dir string) *GGS {
+	g := New(b, outputFn, mem, logReg)
+	g.checkpointDir = dir
+	return g
+}
+
+// NewWithStatePath is NewWithCheckpoints for callers without a task log
+// registry. path is the checkpoint directory, one JSON file per task_id; ""
+// keeps controller state in memory only. State is written after every replan
+// round in process; processAccept ends the task, so it prunes the checkpoint
+// instead of writing one.
+func NewWithStatePath(b *bus.Bus, outputFn func(types.FinalResult), mem types.MemoryService, path string) *GGS {
+	return NewWithCheckpoints(b, outputFn, mem, nil, path)
 }
 
 // Run listens for ReplanRequest and OutcomeSummary messages from R4b.
 // ReplanRequest → compute loss + gradient → emit PlanDirective (or abandon).
 // OutcomeSummary → all subtasks matched → record final loss (D=0) → emit FinalResult.
 // GGS is always in the medium loop; it is never idle even on the happy path.
+// TaskSpec → a new run of that task_id; clears its terminal mark and records its assumptions.
+// FinalResult "cancelled" → the task was stopped from outside; forget it like a terminal decision.
+// FinalResult from any other role → drop the task's recorded assumptions.
 func (g *GGS) Run(ctx context.Context) {
 	replanCh := g.b.Subscribe(types.MsgReplanRequest)
 	acceptCh := g.b.Subscribe(types.MsgOutcomeSummary)
+	specCh := g.b.Subscribe(types.MsgTaskSpec)
+	finalCh := g.b.Subscribe(types.MsgFinalResult)
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case msg, ok := <-finalCh:
+			if !ok {
+				return
+			}
+			fr, err := toFinalResult(msg.Payload)
+			if err != nil || msg.From == types.RoleGGS {
+				continue // GGS's own results already forgot the task
+			}
+			if fr.Directive == "cancelled" {
+				slog.Info("[R7] task cancelled, dropping state", "task", fr.TaskID, "reason", fr.CancelReason)
+				g.forget(fr.TaskID)
+			}
+			// Any other sender (R2 abandoning a plan or a declined cost gate) ends the
+			// task before a GGS decision; only its recorded assumptions remain.
+			g.mu.Lock()
+			delete(g.assumptions, fr.TaskID)
+			g.mu.Unlock()
+		case msg, ok := <-specCh:
+			if !ok {
+				return
+			}
+			spec, err := toTaskSpec(msg.Payload)
+			if err != nil {
+				slog.Error("[R7] bad TaskSpec", "error", err)
+				continue
+			}
+			g.mu.Lock()
+			delete(g.terminal, spec.TaskID)
+			g.terminalOrder = slices.DeleteFunc(g.terminalOrder, func(id string) bool { return id == spec.TaskID })
+			delete(g.finished, spec.TaskID)
+			g.setRunIDLocked(spec.TaskID, msg.ID)
+			g.assumptions[spec.TaskID] = spec.Assumptions
+			g.mu.Unlock()
 		case msg, ok := <-replanCh:
 			if !ok {
 				return
@@ -107,8 +391,16 @@ func (g *GGS) process(ctx context.Context, rr types.ReplanRequest) {
 	taskID := rr.TaskID
 
 	g.mu.Lock()
-	g.replans[taskID]++
-	replanCount := g.replans[taskID]
+	g.restoreLocked(taskID)
+	replanCount, ok := g.admitRoundLocked(taskID, rr.Round)
+	if !ok {
+		terminal := g.terminal[taskID]
+		g.mu.Unlock()
+		slog.Warn("[R7] dropping stale ReplanRequest", "task", taskID, "round", rr.Round, "terminal", terminal)
+		return
+	}
+	artifacts := collectArtifacts(g.artifacts[taskID], rr.Outcomes)
+	g.artifacts[taskID] = artifacts
 	lPrev, hasPrev := g.lPrev[taskID]
 	prevDir := g.prevDirective[taskID]
 	g.mu.Unlock()
@@ -120,9 +412,9 @@ func (g *GGS) process(ctx context.Context, rr types.ReplanRequest) {
 
 	// Compute loss components.
 	D := computeD(rr.Outcomes)
-	P := computeP(rr.Outcomes)
-	Omega := computeOmega(replanCount, rr.ElapsedMs)
-	L := computeLoss(D, P, Omega)
+	P := g.kw.computeP(rr.Outcomes)
+	Omega := g.hp.computeOmega(replanCount, rr.ElapsedMs)
+	L := g.hp.computeLoss(D, P, Omega)
 
 	// Store L for next round's gradient.
 	g.mu.Lock()
@@ -136,12 +428,20 @@ func (g *GGS) process(ctx context.Context, rr types.ReplanRequest) {
 	}
 
 	// computeGradient is used only for Law 2 worsening detection.
-	gradient := computeGradient(gradL, D)
+	gradient := g.hp.computeGradient(gradL, D)
+
+	// v0.8 diagnostic cascade: Ω → D → (|∇L|, P), or the GGS_DECISION_TABLE rules.
+	directive := g.selectDirective(gradL, D, P, Omega)
 
-	// v0.8 diagnostic cascade: Ω → D → (|∇L|, P).
-	directive := selectDirective(gradL, D, P, Omega)
+	// A plan effectively identical to the previous round's means the last
+	// directive's constraints did not change R2's approach: escalate.
+	escalated := false
+	if planRepeated(rr.PriorPlans) && (directive == "refine" || directive == "change_path" || directive == "change_approach") {
+		slog.Warn("[R7] plan repeated across rounds: escalating to break_symmetry", "task", taskID, "directive", directive)
+		directive, escalated = "break_symmetry", true
+	}
 
-	// Law 2 kill-switch: 2 consecutive worsening rounds → force abandon.
+	// Law 2 kill-switch: g.hp.Law2Kill consecutive worsening rounds → force abandon.
 	// Does not override "success" — if D ≤ δ the result is good enough.
 	g.mu.Lock()
 	if gradient == "worsening" {
@@ -152,8 +452,7 @@ func (g *GGS) process(ctx context.Context, rr types.ReplanRequest) {
 	consecutiveWorsening := g.worseningCount[taskID]
 	g.mu.Unlock()
 
-	const law2KillThreshold = 2
-	if consecutiveWorsening >= law2KillThreshold && directive != "abandon" && directive != "success" {
+	if consecutiveWorsening >= g.hp.Law2Kill && directive != "abandon" && directive != "success" {
 		slog.Warn("[R7] LAW2 kill-switch: overriding to abandon", "task", taskID, "consecutive_worsening", consecutiveWorsening, "directive", directive)
 		directive = "abandon"
 	}
@@ -165,94 +464,71 @@ func (g *GGS) process(ctx context.Context, rr types.ReplanRequest) {
 	}
 
 	slog.Info("[R7] GGS compute", "task", taskID, "round", replanCount, "D", D, "P", P, "Omega", Omega, "L", L, "gradL", gradL, "gradient", gradient, "directive", directive)
+	g.record(taskID, replanCount, directive, types.LossBreakdown{D: D, P: P, Omega: Omega, L: L}, gradL)
 
 	// "success" macro-state: D ≤ δ, Ω < θ — close enough, deliver result without routing to R2.
 	if directive == "success" {
-		slog.Info("[R7] task SUCCESS", "task", taskID, "D", D, "delta", delta)
-		summary := buildSuccessSummary(rr)
+		slog.Info("[R7] task SUCCESS", "task", taskID, "D", D, "delta", g.hp.Delta)
+		summary := buildSuccessSummary(rr, rr.Language)
 		output := mergeMatchedOutputs(rr.Outcomes)
 
 		g.logReg.Get(taskID).GGSDecision(D, P, Omega, L, gradL, "success", "", replanCount)
-		g.logReg.Close(taskID, "success")
 
-		// Write terminal Megram to R5 (GGS is sole writer).
+		// Write terminal Megram to R5 (GGS is sole writer) while the task log is
+		// still open: it supplies the Megram's cost and records the writes.
 		g.writeTerminalMegram(taskID, rr.Intent, buildTerminalContent(rr.Outcomes, "success", summary, rr.GapSummary), "success")
+		g.writeToolPreferenceMegrams(taskID, rr.Outcomes, "success")
+		g.writeCriterionMegrams(taskID, rr.Outcomes, "success")
+		g.logReg.Close(taskID, "success")
 
-		g.b.Publish(types.Message{
-			ID:        uuid.New().String(),
-			Timestamp: time.Now().UTC(),
-			From:      types.RoleGGS,
-			To:        types.RoleUser,
-			Type:      types.MsgFinalResult,
-			Payload: types.FinalResult{
-				TaskID:        taskID,
-				Summary:       summary,
-				Output:        output,
-				Loss:          types.LossBreakdown{D: D, P: P, Omega: Omega, L: L},
-				GradL:         gradL,
-				Replans:       replanCount,
-				Directive:     "success",
-				PrevDirective: prevDirective,
-			},
+		g.deliver(types.FinalResult{
+			TaskID:        taskID,
+			Summary:       summary,
+			Output:        output,
+			Artifacts:     artifacts,
+			Loss:          types.LossBreakdown{D: D, P: P, Omega: Omega, L: L},
+			GradL:         gradL,
+			Replans:       replanCount,
+			Directive:     "success",
+			PrevDirective: prevDirective,
 		})
-		if g.outputFn != nil {
-			g.outputFn(taskID, summary, output)
-		}
 
-		g.mu.Lock()
-		delete(g.lPrev, taskID)
-		delete(g.replans, taskID)
-		delete(g.worseningCount, taskID)
-		delete(g.triedTargets, taskID)
-		delete(g.prevDirective, taskID)
-		g.mu.Unlock()
+		g.forget(taskID)
 		return
 	}
 
 	// "abandon" macro-state: Ω ≥ θ, Law 2 kill-switch, or R4b safety-net recommendation.
 	if directive == "abandon" {
-		slog.Info("[R7] task ABANDON", "task", taskID, "Omega", Omega, "threshold", abandonOmega)
-		summary := buildAbandonSummary(rr)
+		slog.Info("[R7] task ABANDON", "task", taskID, "Omega", Omega, "threshold", g.hp.AbandonOmega)
+		summary := buildAbandonSummary(rr, rr.Language)
 
 		g.logReg.Get(taskID).GGSDecision(D, P, Omega, L, gradL, "abandon", "", replanCount)
-		g.logReg.Close(taskID, "abandoned")
 
 		// Write terminal Megram to R5 (GGS is sole writer).
 		g.writeTerminalMegram(taskID, rr.Intent, buildTerminalContent(rr.Outcomes, "abandon", "", rr.GapSummary), "abandon")
+		g.writeToolPreferenceMegrams(taskID, rr.Outcomes, "abandon")
+		g.writeCriterionMegrams(taskID, rr.Outcomes, "abandon")
+		g.logReg.Close(taskID, "abandoned")
 
-		g.b.Publish(types.Message{
-			ID:        uuid.New().String(),
-			Timestamp: time.Now().UTC(),
-			From:      types.RoleGGS,
-			To:        types.RoleUser,
-			Type:      types.MsgFinalResult,
-			Payload: types.FinalResult{
-				TaskID:        taskID,
-				Summary:       summary,
-				Loss:          types.LossBreakdown{D: D, P: P, Omega: Omega, L: L},
-				GradL:         gradL,
-				Replans:       replanCount,
-				Directive:     "abandon",
-				PrevDirective: prevDirective,
-			},
+		g.deliver(types.FinalResult{
+			TaskID:        taskID,
+			Summary:       summary,
+			Artifacts:     artifacts,
+			Loss:          types.LossBreakdown{D: D, P: P, Omega: Omega, L: L},
+			GradL:         gradL,
+			Replans:       replanCount,
+			Directive:     "abandon",
+			PrevDirective: prevDirective,
 		})
-		if g.outputFn != nil {
-			g.outputFn(taskID, summary, nil)
-		}
 
-		g.mu.Lock()
-		delete(g.lPrev, taskID)
-		delete(g.replans, taskID)
-		delete(g.worseningCount, taskID)
-		delete(g.triedTargets, taskID)
-		delete(g.prevDirective, taskID)
-		g.mu.Unlock()
+		g.forget(taskID)
 		return
 	}
 
 	// Action states: refine | change_path | change_approach | break_symmetry.
-	// Write one Megram per failed tool call to R5 (fire-and-forget).
-	g.writeMegramsFromToolCalls(taskID, rr.Outcomes, directive)
+	// Write one Megram per failed tool call to R5 (fire-and-forget); a round that
+	// repeats the previous directive is routine and sampled down.
+	g.writeMegramsFromToolCalls(taskID, rr.Outcomes, directive, directive == prevDirective)
 
 	blockedTools := deriveBlockedTools(rr.Outcomes, directive)
 
@@ -264,10 +540,14 @@ func (g *GGS) process(ctx context.Context, rr types.ReplanRequest) {
 	allBlockedTargets := g.triedTargets[taskID]
 	g.prevDirective[taskID] = directive
 	g.mu.Unlock()
+	g.checkpoint(taskID)
 
 	failedCriterion := primaryFailedCriterion(rr.Outcomes)
-	failureClass := computeFailureClass(rr.Outcomes)
-	rationale := buildRationale(directive, D, P, Omega, gradL, rr.GapSummary)
+	failureClass := g.hp.computeFailureClass(P)
+	rationale := g.hp.buildRationale(directive, D, P, Omega, gradL, rr.GapSummary)
+	if escalated {
+		rationale = fmt.Sprintf(repeatedPlanRationale, rr.GapSummary)
+	}
 
 	tl := g.logReg.Get(taskID)
 	tl.GGSDecision(D, P, Omega, L, gradL, directive, rationale, replanCount)
@@ -277,7 +557,7 @@ func (g *GGS) process(ctx context.Context, rr types.ReplanRequest) {
 
 	g.b.Publish(types.Message{
 		ID:        uuid.New().String(),
-		Timestamp: time.Now().UTC(),
+		Timestamp: g.clock.Now().UTC(),
 		From:      types.RoleGGS,
 		To:        types.RolePlanner,
 		Type:      types.MsgPlanDirective,
@@ -293,47 +573,285 @@ func (g *GGS) process(ctx context.Context, rr types.ReplanRequest) {
 			BudgetPressure:  Omega,
 			GradL:           gradL,
 			Rationale:       rationale,
+			Round:           rr.Round,
 		},
 	})
 }
 
-// processAccept handles the happy-path case: all subtasks matched, R4b accepted.
-// GGS records the final loss (D=0) and delivers FinalResult to the user.
-// This keeps GGS in the medium loop even when no replanning is needed —
-// a proper closed-loop controller computes the error signal on every cycle,
-// including when the error is zero.
+// admitRoundLocked decides whether a ReplanRequest for round (R4b's
+// ReplanRequest.Round) should be processed and, if so, records and returns the
+// task's replan count. R4b's round is the authoritative counter; GGS only offsets
+// it by rounds restored from a checkpoint. Caller holds g.mu.
 //
 // Expectations:
-//   - D is always 0.0 (all subtasks matched)
-//   - Ω is computed from prior replan count + elapsed time (rewards fast, first-try solutions)
-//   - gradient is always "stable" (D=0 ≤ δ)
-//   - Emits MsgFinalResult to RoleUser with the merged output and summary
-//   - FinalResult carries Loss (D=0), GradL, and Replans for trajectory checkpoint display
-//   - Calls outputFn so the REPL can display the result
-//   - Cleans up lPrev and replans state for the task
+//   - Returns false for a task that already reached a terminal state
+//   - Returns false when round is at or below the last round processed (out of order or duplicate)
+//   - Returns roundBase + round when round > 0
+//   - Falls back to incrementing GGS's own count when round is 0 (sender did not stamp it)
+func (g *GGS) admitRoundLocked(taskID string, round int) (int, bool) {
+	if g.terminal[taskID] {
+		return 0, false
+	}
+	next := g.replans[taskID] + 1
+	if round > 0 {
+		next = g.roundBase[taskID] + round
+	}
+	if next <= g.replans[taskID] {
+		return 0, false
+	}
+	g.replans[taskID] = next
+	return next, true
+}
+
+// taskState is one task's controller state as checkpointed to disk.
+type taskState struct {
+	TaskID         string   `json:"task_id"`
+	LPrev          *float64 `json:"l_prev,omitempty"` // nil before the first loss is recorded
+	Replans        int      `json:"replans"`
+	WorseningCount int      `json:"worsening_count"`
+	TriedTargets   []string `json:"tried_targets,omitempty"`
+	PrevDirective  string   `json:"prev_directive,omitempty"`
+	Artifacts      []string `json:"artifacts,omitempty"`
+}
+
+// checkpointPath returns the checkpoint file for taskID, with path separators
+// replaced so a task ID can never address a file outside checkpointDir.
+func (g *GGS) checkpointPath(taskID string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, taskID)
+	return filepath.Join(g.checkpointDir, name+".json")
+}
+
+// checkpoint writes taskID's current state to disk. No-op when checkpoints are off.
 //
+// Expectations:
+//   - No-ops when checkpointDir is ""
+//   - Writes L_prev, replans, worsening count, tried targets, previous directive, and artifacts
+//   - Replaces the file atomically (temp file + rename), creating checkpointDir if needed
+//   - Logs and continues on write errors; checkpointing never fails a task
+func (g *GGS) checkpoint(taskID string) {
+	if g.checkpointDir == "" {
+		return
+	}
+	g.mu.Lock()
+	st := taskState{
+		TaskID:         taskID,
+		Replans:        g.replans[taskID],
+		WorseningCount: g.worseningCount[taskID],
+		TriedTargets:   append([]string(nil), g.triedTargets[taskID]...),
+		PrevDirective:  g.prevDirective[taskID],
+		Artifacts:      append([]string(nil), g.artifacts[taskID]...),
+	}
+	if l, ok := g.lPrev[taskID]; ok {
+		st.LPrev = &l
+	}
+	g.mu.Unlock()
+
+	data, err := json.Marshal(st)
+	if err == nil {
+		err = os.MkdirAll(g.checkpointDir, 0o755)
+	}
+	if err == nil {
+		tmp := g.checkpointPath(taskID) + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, g.checkpointPath(taskID))
+		}
+	}
+	if err != nil {
+		slog.Warn("[R7] checkpoint write failed", "task", taskID, "error", err)
+	}
+}
+
+// restoreLocked loads taskID's checkpoint into memory when GGS holds no state for
+// the task yet — i.e. the first round seen after a restart. Caller holds g.mu.
+//
+// Expectations:
+//   - No-ops when checkpointDir is "" or any in-memory state exists for taskID
+//   - No-ops silently when no checkpoint file exists
+//   - Restores every checkpointed field; L_prev only when it was recorded
+//   - Logs and ignores unreadable or malformed checkpoints
+func (g *GGS) restoreLocked(taskID string) {
+	if g.checkpointDir == "" {
+		return
+	}
+	if _, ok := g.replans[taskID]; ok {
+		return
+	}
+	if _, ok := g.lPrev[taskID]; ok {
+		return
+	}
+	data, err := os.ReadFile(g.checkpointPath(taskID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	var st taskState
+	if err == nil {
+		err = json.Unmarshal(data, &st)
+	}
+	if err != nil {
+		slog.Warn("[R7] checkpoint unreadable, starting fresh", "task", taskID, "error", err)
+		return
+	}
+	if st.LPrev != nil {
+		g.lPrev[taskID] = *st.LPrev
+	}
+	g.replans[taskID] = st.Replans
+	g.roundBase[taskID] = st.Replans
+	g.worseningCount[taskID] = st.WorseningCount
+	if len(st.TriedTargets) > 0 {
+		g.triedTargets[taskID] = st.TriedTargets
+	}
+	if st.PrevDirective != "" {
+		g.prevDirective[taskID] = st.PrevDirective
+	}
+	if len(st.Artifacts) > 0 {
+		g.artifacts[taskID] = st.Artifacts
+	}
+	slog.Info("[R7] resumed task state from checkpoint", "task", taskID, "replans", st.Replans, "prev", st.PrevDirective)
+}
+
+// forget drops all per-task state once the task is terminal, including its checkpoint,
+// and marks the task terminal so late ReplanRequests and OutcomeSummaries are dropped.
+// Its trajectory is kept, among the finished ones, for Trajectory.
+func (g *GGS) forget(taskID string) {
+	g.mu.Lock()
+	g.markTerminalLocked(taskID)
+	delete(g.roundBase, taskID)
+	delete(g.lPrev, taskID)
+	delete(g.replans, taskID)
+	delete(g.worseningCount, taskID)
+	delete(g.triedTargets, taskID)
+	delete(g.prevDirective, taskID)
+	delete(g.artifacts, taskID)
+	g.retireTrajectoryLocked(taskID)
+	g.mu.Unlock()
+	if g.checkpointDir == "" {
+		return
+	}
+	if err := os.Remove(g.checkpointPath(taskID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("[R7] checkpoint cleanup failed", "task", taskID, "error", err)
+	}
+}
+
+// Trajectory bounds: rounds kept per task, and finished tasks kept for /trajectory.
+const (
+	maxTrajectoryRounds     = 32
+	maxFinishedTrajectories = 16
+)
+
+// RoundSnapshot is GGS's decision for one round of a task: the directive it
+// chose and the loss it chose it from.
+type RoundSnapshot struct {
+	Round     int                 `json:"round"`     // 1 for the first evaluation; the accept round follows the last replan
+	Directive string              `json:"directive"` // after the repeated-plan, Law 2, and R4b overrides
+	Loss      types.LossBreakdown `json:"loss"`
+	GradL     float64             `json:"grad_l"`
+	At        time.Time           `json:"at"`
+}
+
+// record appends a snapshot to taskID's trajectory, keeping the last
+// maxTrajectoryRounds rounds.
+func (g *GGS) record(taskID string, round int, directive string, loss types.LossBreakdown, gradL float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t := append(g.trajectory[taskID], RoundSnapshot{Round: round, Directive: directive, Loss: loss, GradL: gradL, At: g.clock.Now().UTC()})
+	if len(t) > maxTrajectoryRounds {
+		t = slices.Clone(t[len(t)-maxTrajectoryRounds:])
+	}
+	g.trajectory[taskID] = t
+}
+
+// maxTerminalMarks bounds how many ended tasks keep their terminal mark. A late
+// message for a task arrives within moments of its end, so only recent marks matter.
+const maxTerminalMarks = 64
+
+// markTerminalLocked marks taskID terminal, evicting the oldest mark beyond
+// maxTerminalMarks. Caller holds g.mu.
+func (g *GGS) markTerminalLocked(taskID string) {
+	if !g.terminal[taskID] {
+		g.terminalOrder = append(g.terminalOrder, taskID)
+	}
+	g.terminal[taskID] = true
+	for len(g.terminalOrder) > maxTerminalMarks {
+		delete(g.terminal, g.terminalOrder[0])
+		g.terminalOrder = g.terminalOrder[1:]
+	}
+}
+
+// retireTrajectoryLocked moves taskID's trajectory out of the live set into the
+// finished set, evicting the oldest finished task beyond maxFinishedTrajectories.
+// Caller holds g.mu.
+func (g *GGS) retireTrajectoryLocked(taskID string) {
+	t, ok := g.trajectory[taskID]
+	if !ok {
+		return
+	}
+	delete(g.trajectory, taskID)
+	if _, seen := g.finished[taskID]; !seen {
+		g.finishedOrder = append(g.finishedOrder, taskID)
+	}
+	g.finished[taskID] = t
+	for len(g.finishedOrder) > maxFinishedTrajectories {
+		delete(g.finished, g.finishedOrder[0])
+		g.finishedOrder = g.finishedOrder[1:]
+	}
+}
+
+// Trajectory returns taskID's recorded rounds, oldest first, or nil when GGS has
+// none. Safe to call from any goroutine; the slice is a copy.
+//
+// Expectations:
+//   - Returns one snapshot per round processed, in order, each with its directive, loss, and ∇L
+//   - Includes the accept round, numbered after the last replan round
+//   - Keeps at most maxTrajectoryRounds rounds per task, dropping the oldest
+//   - Still returns a task's rounds after it reaches a terminal state, for the last maxFinishedTrajectories tasks
+//   - Returns nil for an unknown task
+func (g *GGS) Trajectory(taskID string) []RoundSnapshot {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if t, ok := g.trajectory[taskID]; ok {
+		return slices.Clone(t)
+	}
+	return slices.Clone(g.finished[taskID])
+}
+
 // processAccept handles the happy-path case: all subtasks matched, R4b accepted.
-// GGS records the final loss (D=0) and delivers FinalResult to the user.
+// GGS records the final loss and delivers FinalResult to the user. D is not
+// forced to 0: a match R4a only barely passed (low Confidence) still adds
+// partial distance, so the accept path reports how solid the result is.
 // This keeps GGS in the medium loop even when no replanning is needed —
 // a proper closed-loop controller computes the error signal on every cycle,
-// including when the error is zero.
+// including when the error is small.
 //
 // Expectations:
-//   - D is always 0.0 (all subtasks matched)
+//   - D is computeD(outcomes): 0.0 when every match is fully confident (or no outcomes), partial otherwise
 //   - Ω is computed from prior replan count + elapsed time (rewards fast, first-try solutions)
 //   - FinalResult.Directive is always "accept"
 //   - FinalResult.PrevDirective is "init" on first-try accepts; prior directive after replanning
 //   - Emits MsgFinalResult to RoleUser with the merged output and summary
-//   - FinalResult carries Loss (D=0), GradL, and Replans for trajectory checkpoint display
+//   - FinalResult carries Loss (with that D), GradL, and Replans for trajectory checkpoint display
 //   - Calls outputFn so the REPL can display the result
+//   - FinalResult.Artifacts lists files written in this and earlier rounds
 //   - Cleans up all per-task state
+//   - Drops the summary without a FinalResult when the task is already terminal
 func (g *GGS) processAccept(_ context.Context, os types.OutcomeSummary) {
 	taskID := os.TaskID
 
 	g.mu.Lock()
+	if g.terminal[taskID] {
+		g.mu.Unlock()
+		slog.Warn("[R7] dropping OutcomeSummary for terminal task", "task", taskID)
+		return
+	}
+	g.restoreLocked(taskID)
 	lPrev, hasPrev := g.lPrev[taskID]
 	replanCount := g.replans[taskID] // 0 for first-try accepts; >0 if GGS directed prior replans
 	prevDir := g.prevDirective[taskID]
+	artifacts := collectArtifacts(g.artifacts[taskID], os.Outcomes)
 	g.mu.Unlock()
 
 	prevDirective := "init"
@@ -341,10 +859,15 @@ func (g *GGS) processAccept(_ context.Context, os types.OutcomeSummary) {
 		prevDirective = prevDir
 	}
 
-	// D=0: all subtasks matched. P=0.5: no failures → neutral. Ω: elapsed time + prior replans.
-	const D, P = 0.0, 0.5
-	Omega := computeOmega(replanCount, os.ElapsedMs)
-	L := computeLoss(D, P, Omega)
+	// D: all subtasks matched, so only low-confidence matches add distance.
+	// P=0.5: no failures → neutral. Ω: elapsed time + prior replans.
+	D := 0.0
+	if len(os.Outcomes) > 0 {
+		D = computeD(os.Outcomes)
+	}
+	const P = 0.5
+	Omega := g.hp.computeOmega(replanCount, os.ElapsedMs)
+	L := g.hp.computeLoss(D, P, Omega)
 
 	var gradL float64
 	if hasPrev {
@@ -354,51 +877,83 @@ func (g *GGS) processAccept(_ context.Context, os types.OutcomeSummary) {
 	slog.Info("[R7] task ACCEPT", "task", taskID, "Omega", Omega, "L", L, "gradL", gradL, "replans", replanCount, "prev", prevDirective)
 
 	g.logReg.Get(taskID).GGSDecision(D, P, Omega, L, gradL, "accept", "", replanCount)
+	g.record(taskID, replanCount+1, "accept", types.LossBreakdown{D: D, P: P, Omega: Omega, L: L}, gradL)
 
 	// Clean up per-task state (task is done).
-	g.mu.Lock()
-	delete(g.lPrev, taskID)
-	delete(g.replans, taskID)
-	delete(g.worseningCount, taskID)
-	delete(g.triedTargets, taskID)
-	delete(g.prevDirective, taskID)
-	g.mu.Unlock()
+	g.forget(taskID)
 
 	// Write terminal Megram to R5 (GGS is sole writer).
 	g.writeTerminalMegram(taskID, os.Intent, buildTerminalContent(os.Outcomes, "accept", os.Summary, ""), "accept")
+	g.writeToolPreferenceMegrams(taskID, os.Outcomes, "accept")
+	g.writeCriterionMegrams(taskID, os.Outcomes, "accept")
 
 	// GGS is the sole emitter of FinalResult — consistent path for accept, success, and abandon.
 	// Directive="accept"; Loss, GradL, Replans, PrevDirective for trajectory checkpoint display.
+	g.deliver(types.FinalResult{
+		TaskID:        taskID,
+		Summary:       os.Summary,
+		Output:        os.MergedOutput,
+		Artifacts:     artifacts,
+		Loss:          types.LossBreakdown{D: D, P: P, Omega: Omega, L: L},
+		GradL:         gradL,
+		Replans:       replanCount,
+		Directive:     "accept",
+		PrevDirective: prevDirective,
+		Conflicts:     os.Conflicts,
+	})
+}
+
+// deliver publishes fr to the user and hands it to outputFn for the REPL.
+// The task's TaskSpec assumptions are attached here, and dropped once delivered,
+// because forget runs before deliver on the accept path.
+func (g *GGS) deliver(fr types.FinalResult) {
+	g.mu.Lock()
+	fr.Assumptions = g.assumptions[fr.TaskID]
+	delete(g.assumptions, fr.TaskID)
+	g.mu.Unlock()
 	g.b.Publish(types.Message{
 		ID:        uuid.New().String(),
-		Timestamp: time.Now().UTC(),
+		Timestamp: g.clock.Now().UTC(),
 		From:      types.RoleGGS,
 		To:        types.RoleUser,
 		Type:      types.MsgFinalResult,
-		Payload: types.FinalResult{
-			TaskID:        taskID,
-			Summary:       os.Summary,
-			Output:        os.MergedOutput,
-			Loss:          types.LossBreakdown{D: D, P: P, Omega: Omega, L: L},
-			GradL:         gradL,
-			Replans:       replanCount,
-			Directive:     "accept",
-			PrevDirective: prevDirective,
-		},
+		Payload:   fr,
 	})
 	if g.outputFn != nil {
-		g.outputFn(taskID, os.Summary, os.MergedOutput)
+		g.outputFn(fr)
 	}
 }
 
+// collectArtifacts returns seen followed by the artifact paths of outcomes not
+// already in it, in order. seen is not modified.
+//
+// Expectations:
+//   - Keeps seen's paths first, in order
+//   - Appends each outcome's artifacts in outcome order, skipping duplicates
+//   - Returns nil when there are no artifacts at all
+func collectArtifacts(seen []string, outcomes []types.SubTaskOutcome) []string {
+	out := append([]string(nil), seen...)
+	for _, o := range outcomes {
+		for _, p := range o.Artifacts {
+			if !slices.Contains(out, p) {
+				out = append(out, p)
+			}
+		}
+	}
+	return out
+}
+
 // computeD computes intent-result distance D ∈ [0, 1] at criterion granularity.
 // When CriteriaVerdicts are present, D = failed_criteria / total_criteria.
 // Falls back to subtask-level (1 synthetic criterion per outcome) when CriteriaVerdicts absent.
 // Returns 1.0 when outcomes is empty (complete failure).
+// A matched outcome's passing criteria each add 1 − Confidence, so a barely
+// matched subtask (R4a score 0.6) contributes partial distance.
 //
 // Expectations:
 //   - Returns 1.0 when outcomes is empty (no data = total failure)
-//   - Returns 0.0 when all outcomes are matched (with or without CriteriaVerdicts)
+//   - Returns 0.0 when all outcomes are matched with Confidence 1 or unset (with or without CriteriaVerdicts)
+//   - A matched outcome with Confidence c in (0, 1) counts 1 − c per passing criterion
 //   - Uses CriteriaVerdicts when available: D = failed_criteria / total_criteria
 //   - For outcomes without CriteriaVerdicts: counts each as 1 synthetic criterion
 //   - Criterion-level result differs from subtask-level when subtasks have unequal criterion counts
@@ -406,13 +961,16 @@ func computeD(outcomes []types.SubTaskOutcome) float64 {
 	if len(outcomes) == 0 {
 		return 1.0
 	}
-	total, failed := 0, 0
+	total, failed := 0, 0.0
 	for _, o := range outcomes {
+		doubt := matchDoubt(o)
 		if len(o.CriteriaVerdicts) > 0 {
 			for _, cv := range o.CriteriaVerdicts {
 				total++
 				if cv.Verdict == "fail" {
 					failed++
+				} else {
+					failed += doubt
 				}
 			}
 		} else {
@@ -420,10 +978,22 @@ func computeD(outcomes []types.SubTaskOutcome) float64 {
 			total++
 			if o.Status == "failed" {
 				failed++
+			} else {
+				failed += doubt
 			}
 		}
 	}
-	return float64(failed) / float64(total)
+	return failed / float64(total)
+}
+
+// matchDoubt is the distance a passing criterion of o adds to D: 1 − Confidence
+// for a matched outcome R4a scored below 1, and 0 otherwise. Confidence 0 means
+// unset (outcomes from before it was recorded) and is not treated as doubt.
+func matchDoubt(o types.SubTaskOutcome) float64 {
+	if o.Status != "matched" || o.Confidence <= 0 || o.Confidence >= 1 {
+		return 0
+	}
+	return 1 - o.Confidence
 }
 
 // computePKeyword computes process implausibility P ∈ [0, 1] via keyword heuristics.
@@ -434,13 +1004,9 @@ func computeD(outcomes []types.SubTaskOutcome) float64 {
 //   - Returns 0.5 when no outcomes have failure reasons (neutral)
 //   - Returns value > 0.5 when failure reasons suggest logical errors
 //   - Returns value < 0.5 when failure reasons suggest environmental errors
+//   - Matches k's keywords case-insensitively
 //   - Returns value in [0, 1]
-func computePKeyword(outcomes []types.SubTaskOutcome) float64 {
-	logicalKW := []string{"logic", "wrong approach", "incorrect", "invalid", "cannot", "not possible",
-		"permission denied", "operation not permitted"}
-	envKW := []string{"network", "timeout", "context deadline", "connection", "unavailable",
-		"not found", "no such file", "temporary", "rate limit"}
-
+func (k FailureKeywords) computePKeyword(outcomes []types.SubTaskOutcome) float64 {
 	logical, environmental := 0, 0
 	for _, o := range outcomes {
 		if o.Status != "failed" {
@@ -456,15 +1022,15 @@ func computePKeyword(outcomes []types.SubTaskOutcome) float64 {
 			}
 		}
 		isLogical := false
-		for _, kw := range logicalKW {
-			if strings.Contains(reason, kw) {
+		for _, kw := range k.Logical {
+			if strings.Contains(reason, strings.ToLower(kw)) {
 				isLogical = true
 				break
 			}
 		}
 		isEnv := false
-		for _, kw := range envKW {
-			if strings.Contains(reason, kw) {
+		for _, kw := range k.Environmental {
+			if strings.Contains(reason, strings.ToLower(kw)) {
 				isEnv = true
 				break
 			}
@@ -493,7 +1059,7 @@ func computePKeyword(outcomes []types.SubTaskOutcome) float64 {
 //   - Returns P = logical / (logical+environmental) from structured data
 //   - Falls back to computePKeyword when no structured failure_class is classified
 //   - Returns 0.5 (via fallback) when CriteriaVerdicts present but all FailureClass fields empty
-func computeP(outcomes []types.SubTaskOutcome) float64 {
+func (k FailureKeywords) computeP(outcomes []types.SubTaskOutcome) float64 {
 	logical, env := 0, 0
 	for _, o := range outcomes {
 		if o.Status != "failed" {
@@ -514,7 +1080,7 @@ func computeP(outcomes []types.SubTaskOutcome) float64 {
 	if logical+env > 0 {
 		return float64(logical) / float64(logical+env)
 	}
-	return computePKeyword(outcomes)
+	return k.computePKeyword(outcomes)
 }
 
 // computeOmega computes resource cost Ω ∈ [0, 1].
@@ -526,10 +1092,10 @@ func computeP(outcomes []types.SubTaskOutcome) float64 {
 //   - Returns w2 (0.4) when replanCount=0 and elapsedMs=timeBudgetMs
 //   - Returns 1.0 when replanCount=maxReplansGGS and elapsedMs=timeBudgetMs
 //   - Never exceeds 1.0
-func computeOmega(replanCount int, elapsedMs int64) float64 {
+func (h Hyperparams) computeOmega(replanCount int, elapsedMs int64) float64 {
 	replanRatio := float64(replanCount) / float64(maxReplansGGS)
 	timeRatio := float64(elapsedMs) / float64(timeBudgetMs)
-	omega := w1*replanRatio + w2*timeRatio
+	omega := h.W1*replanRatio + h.W2*timeRatio
 	if omega > 1.0 {
 		return 1.0
 	}
@@ -544,25 +1110,26 @@ func computeOmega(replanCount int, elapsedMs int64) float64 {
 //   - Returns λ when D=0, P=0, Ω=1 (pure resource cost)
 //   - Returns α+β when D=1, P=1, Ω=0 (λ·Ω term is zero at no budget pressure)
 //   - β_eff is zero when Ω=1, so P has no effect when budget is exhausted
-func computeLoss(D, P, Omega float64) float64 {
-	betaEff := beta * (1.0 - Omega)
-	return alpha*D + betaEff*P + lambda*Omega
+func (h Hyperparams) computeLoss(D, P, Omega float64) float64 {
+	betaEff := h.Beta * (1.0 - Omega)
+	return h.Alpha*D + betaEff*P + h.Lambda*Omega
 }
 
-// computeGradient converts ∇L and D into a gradient label.
+// computeGradient converts ∇L and D into a gradient label, with the same
+// threshold semantics as selectDirective.
 // plateau: |∇L| < epsilon AND D > delta (local minimum).
-// improving: ∇L < 0 (loss decreasing).
-// worsening: ∇L > 0 (loss increasing).
+// improving: ∇L <= -epsilon (loss decreasing).
+// worsening: ∇L >= epsilon (loss increasing).
 // stable: |∇L| < epsilon AND D <= delta (converged or near-converged).
 //
 // Expectations:
 //   - Returns "plateau" when |∇L| < epsilon and D > delta
 //   - Returns "stable" when |∇L| < epsilon and D <= delta
-//   - Returns "improving" when ∇L < -epsilon
-//   - Returns "worsening" when ∇L > epsilon
-func computeGradient(gradL, D float64) string {
-	if math.Abs(gradL) < epsilon {
-		if D > delta {
+//   - Returns "improving" when ∇L <= -epsilon
+//   - Returns "worsening" when ∇L >= epsilon
+func (h Hyperparams) computeGradient(gradL, D float64) string {
+	if !atLeast(math.Abs(gradL), h.Epsilon) {
+		if !atMost(D, h.Delta) {
 			return "plateau"
 		}
 		return "stable"
@@ -573,7 +1140,8 @@ func computeGradient(gradL, D float64) string {
 	return "worsening"
 }
 
-// selectDirective selects the macro-state using the v0.8 diagnostic cascade.
+// selectDirective selects the macro-state using the v0.8 diagnostic cascade
+// under h's thresholds (the Expectations name the default-valued fields).
 //
 // Priority 1: Ω — hard constraint (can we continue?)
 // Priority 2: D — target distance (are we close enough?)
@@ -581,6 +1149,11 @@ func computeGradient(gradL, D float64) string {
 //
 // ∇L sign is a modulator within each macro-state (urgency), not a state selector.
 //
+// Thresholds: Ω and |∇L| are inclusive lower bounds (Ω = θ abandons, |∇L| = ε
+// is a signal), D is an inclusive upper bound (D = δ succeeds), and P = ρ counts
+// as environmental. Comparisons allow thresholdTol of float rounding, so a value
+// that sits on a threshold in exact arithmetic lands on the documented side.
+//
 // Expectations:
 //   - Returns "abandon" when Omega >= abandonOmega regardless of other values
 //   - Returns "success" when Omega < abandonOmega and D <= delta
@@ -588,18 +1161,19 @@ func computeGradient(gradL, D float64) string {
 //   - Returns "change_approach" when D > delta, |∇L| >= epsilon, P > rho
 //   - Returns "change_path" when D > delta, |∇L| < epsilon, P <= rho
 //   - Returns "refine" when D > delta, |∇L| >= epsilon, P <= rho
-func selectDirective(gradL, D, P, Omega float64) string {
+//   - Treats values within thresholdTol of a threshold as on it (∇L = 0.5-0.4 is a signal)
+func (h Hyperparams) selectDirective(gradL, D, P, Omega float64) string {
 	// Priority 1: Ω — budget hard constraint.
-	if Omega >= abandonOmega {
+	if atLeast(Omega, h.AbandonOmega) {
 		return "abandon"
 	}
 	// Priority 2: D — convergence threshold.
-	if D <= delta {
+	if atMost(D, h.Delta) {
 		return "success"
 	}
 	// Priority 3: (|∇L|, P) — action selection.
-	hasSignal := math.Abs(gradL) >= epsilon
-	highP := P > rho
+	hasSignal := atLeast(math.Abs(gradL), h.Epsilon)
+	highP := !atMost(P, h.Rho)
 	switch {
 	case !hasSignal && highP:
 		return "break_symmetry" // stuck + logical failure → novel approach
@@ -612,6 +1186,56 @@ func selectDirective(gradL, D, P, Omega float64) string {
 	}
 }
 
+// planRepeatOverlap is the keyword overlap (Jaccard) at which two rounds' plan
+// fingerprints count as the same plan, reworded.
+const planRepeatOverlap = 0.8
+
+// repeatedPlanRationale explains a break_symmetry escalated by planRepeated.
+// Argument: the gap summary.
+const repeatedPlanRationale = "Plan repeated: this round's plan was effectively the previous round's, reworded, " +
+	"so the last directive's constraints did not change the approach. Block all tried tools, demand novel approach. Gap: %s"
+
+// planRepeated reports whether the last two plan fingerprints in priorPlans
+// (see types.ReplanRequest.PriorPlans) are effectively identical.
+//
+// Expectations:
+//   - Returns false with fewer than two fingerprints
+//   - Returns false when either of the last two is empty
+//   - Returns true when the last two share at least planRepeatOverlap of their keywords
+func planRepeated(priorPlans []string) bool {
+	if len(priorPlans) < 2 {
+		return false
+	}
+	prev := make(map[string]bool)
+	for _, w := range strings.Fields(priorPlans[len(priorPlans)-2]) {
+		prev[w] = true
+	}
+	last := make(map[string]bool)
+	for _, w := range strings.Fields(priorPlans[len(priorPlans)-1]) {
+		last[w] = true
+	}
+	if len(prev) == 0 || len(last) == 0 {
+		return false
+	}
+	inter := 0
+	for w := range last {
+		if prev[w] {
+			inter++
+		}
+	}
+	return float64(inter)/float64(len(prev)+len(last)-inter) >= planRepeatOverlap
+}
+
+// thresholdTol is the float rounding allowed when comparing a computed signal
+// with a threshold: ∇L = 0.5 - 0.4 evaluates to 0.09999999999999998, not 0.1.
+const thresholdTol = 1e-9
+
+// atLeast reports whether x >= threshold, allowing thresholdTol of rounding.
+func atLeast(x, threshold float64) bool { return x >= threshold-thresholdTol }
+
+// atMost reports whether x <= threshold, allowing thresholdTol of rounding.
+func atMost(x, threshold float64) bool { return x <= threshold+thresholdTol }
+
 // deriveBlockedTools collects tool names from failed subtasks' ToolCalls.
 // Only populated for break_symmetry or change_approach directives.
 //
@@ -731,32 +1355,78 @@ func primaryFailedCriterion(outcomes []types.SubTaskOutcome) string {
 	return ""
 }
 
-// computeFailureClass returns "logical", "environmental", or "mixed" based on P.
+// computeFailureClass returns "logical", "environmental", or "mixed" for P,
+// split at the same ρ selectDirective uses so PlanDirective.FailureClass always
+// agrees with the directive.
 //
 // Expectations:
-//   - Returns "logical" when P > 0.5
-//   - Returns "environmental" when P < 0.5
-//   - Returns "mixed" when P == 0.5
-func computeFailureClass(outcomes []types.SubTaskOutcome) string {
-	P := computeP(outcomes)
-	if P > 0.5 {
+//   - Returns "logical" when P > ρ
+//   - Returns "environmental" when P < ρ
+//   - Returns "mixed" when P == ρ
+func (h Hyperparams) computeFailureClass(P float64) string {
+	if !atMost(P, h.Rho) {
 		return "logical"
 	}
-	if P < 0.5 {
+	if !atLeast(P, h.Rho) {
 		return "environmental"
 	}
 	return "mixed"
 }
 
-// buildAbandonSummary generates a structured summary from SubTaskOutcome data.
+// summaryStrings holds the fixed text of the success and abandon summaries for
+// one locale. Format verbs: Completed/Failed take the joined intent list;
+// MatchedCount/FailedCount take a count.
+type summaryStrings struct {
+	Abandoned, Completed, Failed, NextSteps string
+	Success, MatchedCount, FailedCount      string
+	ListSep                                 string
+}
+
+// summaryLocales maps TaskSpec.Language tags to summary text. Unknown tags use "en".
+var summaryLocales = map[string]summaryStrings{
+	"en": {
+		Abandoned:    "❌ Task abandoned after budget exhausted.",
+		Completed:    "Completed: %s.",
+		Failed:       "Failed: %s.",
+		NextSteps:    "Consider breaking the task into smaller steps or retrying with more specific instructions.",
+		Success:      "✅ Task completed within convergence threshold.",
+		MatchedCount: "%d subtask(s) completed.",
+		FailedCount:  "%d subtask(s) failed but gap is within acceptable threshold (D ≤ δ).",
+		ListSep:      "; ",
+	},
+	"zh": {
+		Abandoned:    "❌ 预算耗尽，任务已放弃。",
+		Completed:    "已完成：%s。",
+		Failed:       "未完成：%s。",
+		NextSteps:    "建议将任务拆分为更小的步骤，或给出更具体的说明后重试。",
+		Success:      "✅ 任务已在收敛阈值内完成。",
+		MatchedCount: "%d 个子任务已完成。",
+		FailedCount:  "%d 个子任务失败，但差距在可接受阈值内（D ≤ δ）。",
+		ListSep:      "；",
+	},
+}
+
+// summaryLocale returns the summary strings for lang, falling back to English.
+func summaryLocale(lang string) summaryStrings {
+	if s, ok := summaryLocales[lang]; ok {
+		return s
+	}
+	return summaryLocales["en"]
+}
+
+// buildAbandonSummary generates a structured summary from SubTaskOutcome data,
+// with fixed text in the locale for lang (see summaryLocales).
 // No LLM call; R2 graceful failure (LLM-backed) is deferred to v0.8.
 //
 // Expectations:
 //   - Lists completed subtask intents when any matched
 //   - Lists failed subtask intents when any failed
+//   - Sorts each intent list, so outcome arrival order does not change the summary
 //   - Includes gap_summary when non-empty
 //   - Always ends with generic next-step suggestions
-func buildAbandonSummary(rr types.ReplanRequest) string {
+//   - Uses the fixed strings for lang; unknown or empty lang uses English
+func buildAbandonSummary(rr types.ReplanRequest, lang string) string {
+	loc := summaryLocale(lang)
 	var matched, failed []string
 	for _, o := range rr.Outcomes {
 		if o.Status == "matched" {
@@ -765,28 +1435,33 @@ func buildAbandonSummary(rr types.ReplanRequest) string {
 			failed = append(failed, o.Intent)
 		}
 	}
+	sort.Strings(matched)
+	sort.Strings(failed)
 
-	parts := []string{"❌ Task abandoned after budget exhausted."}
+	parts := []string{loc.Abandoned}
 	if len(matched) > 0 {
-		parts = append(parts, fmt.Sprintf("Completed: %s.", strings.Join(matched, "; ")))
+		parts = append(parts, fmt.Sprintf(loc.Completed, strings.Join(matched, loc.ListSep)))
 	}
 	if len(failed) > 0 {
-		parts = append(parts, fmt.Sprintf("Failed: %s.", strings.Join(failed, "; ")))
+		parts = append(parts, fmt.Sprintf(loc.Failed, strings.Join(failed, loc.ListSep)))
 	}
 	if rr.GapSummary != "" {
 		parts = append(parts, rr.GapSummary)
 	}
-	parts = append(parts, "Consider breaking the task into smaller steps or retrying with more specific instructions.")
+	parts = append(parts, loc.NextSteps)
 	return strings.Join(parts, " ")
 }
 
 // buildSuccessSummary produces a user-facing summary for the "success" macro-state
-// (D ≤ δ — close enough, delivering result without further replanning).
+// (D ≤ δ — close enough, delivering result without further replanning), with fixed
+// text in the locale for lang.
 //
 // Expectations:
 //   - Starts with a success prefix indicating convergence threshold was met
 //   - Lists how many subtasks matched and how many failed within threshold
-func buildSuccessSummary(rr types.ReplanRequest) string {
+//   - Uses the fixed strings for lang; unknown or empty lang uses English
+func buildSuccessSummary(rr types.ReplanRequest, lang string) string {
+	loc := summaryLocale(lang)
 	matched, failed := 0, 0
 	for _, o := range rr.Outcomes {
 		if o.Status == "matched" {
@@ -795,12 +1470,12 @@ func buildSuccessSummary(rr types.ReplanRequest) string {
 			failed++
 		}
 	}
-	parts := []string{"✅ Task completed within convergence threshold."}
+	parts := []string{loc.Success}
 	if matched > 0 {
-		parts = append(parts, fmt.Sprintf("%d subtask(s) completed.", matched))
+		parts = append(parts, fmt.Sprintf(loc.MatchedCount, matched))
 	}
 	if failed > 0 {
-		parts = append(parts, fmt.Sprintf("%d subtask(s) failed but gap is within acceptable threshold (D ≤ δ).", failed))
+		parts = append(parts, fmt.Sprintf(loc.FailedCount, failed))
 	}
 	if rr.GapSummary != "" {
 		parts = append(parts, rr.GapSummary)
@@ -808,49 +1483,115 @@ func buildSuccessSummary(rr types.ReplanRequest) string {
 	return strings.Join(parts, " ")
 }
 
-// mergeMatchedOutputs collects outputs from matched subtask outcomes.
-// Returns nil when no matched outputs exist; the single output when exactly one;
-// a []any slice when multiple.
+// mergeMatchedOutputs collects outputs from matched subtask outcomes in plan
+// order: by subtask sequence, then subtask ID, so parallel subtasks merge the
+// same way whatever order they finished in. Identical outputs (redundant parallel subtasks) are kept once. When the
+// distinct outputs share a shape they are merged: strings are joined with a
+// blank line and lists are unioned. Otherwise the distinct outputs are listed.
 //
 // Expectations:
 //   - Returns nil when no matched outcomes have non-nil output
 //   - Returns the single output directly (not wrapped in a slice) when exactly one
-//   - Returns []any of all outputs when multiple matched outputs exist
+//   - Orders outputs by Sequence, then SubTaskID, regardless of arrival order
+//   - Does not reorder the caller's outcomes slice
+//   - Collapses identical outputs to one
+//   - Joins distinct string outputs with a blank line, in plan order
+//   - Unions list outputs, keeping the first occurrence of each element
+//   - Returns []any of the distinct outputs when their shapes differ
 func mergeMatchedOutputs(outcomes []types.SubTaskOutcome) any {
-	var outputs []any
+	var matched []types.SubTaskOutcome
 	for _, o := range outcomes {
 		if o.Status == "matched" && o.Output != nil {
-			outputs = append(outputs, o.Output)
+			matched = append(matched, o)
 		}
 	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		if matched[i].Sequence != matched[j].Sequence {
+			return matched[i].Sequence < matched[j].Sequence
+		}
+		return matched[i].SubTaskID < matched[j].SubTaskID
+	})
+	var outputs []any
+	for _, o := range matched {
+		outputs = appendDistinct(outputs, o.Output)
+	}
 	switch len(outputs) {
 	case 0:
 		return nil
 	case 1:
 		return outputs[0]
-	default:
-		return outputs
 	}
+	var strs []string
+	var union []any
+	for _, out := range outputs {
+		switch v := out.(type) {
+		case string:
+			strs = append(strs, v)
+		case []any:
+			for _, item := range v {
+				union = appendDistinct(union, item)
+			}
+		}
+	}
+	switch {
+	case len(strs) == len(outputs):
+		return strings.Join(strs, "\n\n")
+	case allLists(outputs):
+		return union
+	}
+	return outputs
+}
+
+// appendDistinct appends v to vals unless an equal value (same JSON encoding) is already there.
+func appendDistinct(vals []any, v any) []any {
+	key := outputKey(v)
+	for _, existing := range vals {
+		if outputKey(existing) == key {
+			return vals
+		}
+	}
+	return append(vals, v)
+}
+
+// outputKey is a comparable form of an output value. JSON encoding sorts map
+// keys, so equal objects decoded in different key orders compare equal.
+func outputKey(v any) string {
+	if b, err := json.Marshal(v); err == nil {
+		return string(b)
+	}
+	return fmt.Sprintf("%#v", v)
+}
+
+// allLists reports whether every output is a list.
+func allLists(outputs []any) bool {
+	for _, out := range outputs {
+		if _, ok := out.([]any); !ok {
+			return false
+		}
+	}
+	return true
 }
 
 // buildRationale produces a human-readable explanation of the directive.
-func buildRationale(directive string, D, P, Omega, gradL float64, gapSummary string) string {
+// Thresholds print with %g so configured values such as ε=0.05 show as set.
+func (h Hyperparams) buildRationale(directive string, D, P, Omega, gradL float64, gapSummary string) string {
+	epsilon, delta, rho, abandonOmega := h.Epsilon, h.Delta, h.Rho, h.AbandonOmega
 	switch directive {
 	case "refine":
-		if gradL < -epsilon {
-			return fmt.Sprintf("Loss decreasing (∇L=%.3f), approach is sound (P=%.2f ≤ ρ). Tighten parameters. Gap: %s", gradL, P, gapSummary)
+		if atLeast(-gradL, epsilon) {
+			return fmt.Sprintf("Loss decreasing (∇L=%.3f), approach is sound (P=%.2f ≤ ρ=%g). Tighten parameters. Gap: %s", gradL, P, rho, gapSummary)
 		}
-		return fmt.Sprintf("Has signal (|∇L|=%.3f ≥ ε=%.1f), environmental issue (P=%.2f ≤ ρ). Adjust path/parameters. Gap: %s", math.Abs(gradL), epsilon, P, gapSummary)
+		return fmt.Sprintf("Has signal (|∇L|=%.3f ≥ ε=%g), environmental issue (P=%.2f ≤ ρ=%g). Adjust path/parameters. Gap: %s", math.Abs(gradL), epsilon, P, rho, gapSummary)
 	case "change_path":
-		return fmt.Sprintf("Plateau (|∇L|=%.3f < ε=%.1f, D=%.2f > δ=%.1f), environmental origin (P=%.2f ≤ ρ). Same tool class, different target. Gap: %s",
-			math.Abs(gradL), epsilon, D, delta, P, gapSummary)
+		return fmt.Sprintf("Plateau (|∇L|=%.3f < ε=%g, D=%.2f > δ=%g), environmental origin (P=%.2f ≤ ρ=%g). Same tool class, different target. Gap: %s",
+			math.Abs(gradL), epsilon, D, delta, P, rho, gapSummary)
 	case "change_approach":
-		return fmt.Sprintf("Has signal (|∇L|=%.3f ≥ ε=%.1f), logical failure (P=%.2f > ρ). Switch tool class entirely. Gap: %s", math.Abs(gradL), epsilon, P, gapSummary)
+		return fmt.Sprintf("Has signal (|∇L|=%.3f ≥ ε=%g), logical failure (P=%.2f > ρ=%g). Switch tool class entirely. Gap: %s", math.Abs(gradL), epsilon, P, rho, gapSummary)
 	case "break_symmetry":
-		return fmt.Sprintf("Local minimum (|∇L|=%.3f < ε=%.1f, D=%.2f > δ=%.1f), logical failure (P=%.2f > ρ). Block all tried tools, demand novel approach. Gap: %s",
-			math.Abs(gradL), epsilon, D, delta, P, gapSummary)
+		return fmt.Sprintf("Local minimum (|∇L|=%.3f < ε=%g, D=%.2f > δ=%g), logical failure (P=%.2f > ρ=%g). Block all tried tools, demand novel approach. Gap: %s",
+			math.Abs(gradL), epsilon, D, delta, P, rho, gapSummary)
 	case "abandon":
-		return fmt.Sprintf("Budget exhausted (Ω=%.3f ≥ θ=%.1f). Continued replanning cost exceeds gap cost. Gap: %s", Omega, abandonOmega, gapSummary)
+		return fmt.Sprintf("Budget exhausted (Ω=%.3f ≥ θ=%g). Continued replanning cost exceeds gap cost. Gap: %s", Omega, abandonOmega, gapSummary)
 	default:
 		return gapSummary
 	}
@@ -934,6 +1675,20 @@ func buildTerminalContent(outcomes []types.SubTaskOutcome, directive, summary, g
 	}
 }
 
+// quantizer is implemented by memory stores that carry their own quantization matrix.
+type quantizer interface {
+	QuantizationMatrix() map[string]memory.Quantization
+}
+
+// quantization returns the (f, σ, k) table for Megram writes: the memory store's own
+// matrix when it has one (overrides applied), otherwise the package defaults.
+func (g *GGS) quantization() map[string]memory.Quantization {
+	if q, ok := g.mem.(quantizer); ok {
+		return q.QuantizationMatrix()
+	}
+	return memory.QuantizationMatrix()
+}
+
 // writeTerminalMegram writes one Megram to R5 on terminal states (accept/success/abandon).
 // Tags: space = "intent:<taskID>"; entity = "env:local".
 // Using taskID (ASCII snake_case from R1) instead of IntentSlug(intent) ensures CJK and
@@ -944,6 +1699,9 @@ func buildTerminalContent(outcomes []types.SubTaskOutcome, directive, summary, g
 //   - No-ops when mem is nil
 //   - Uses "intent:"+taskID as space tag (not IntentSlug(intent))
 //   - Sets f, sigma, k from quantization matrix for the given state
+//   - Sets IdempotencyKey from (taskID, run ID, state) so R5 drops a replayed write
+//     but keeps a later run of the same task_id
+//   - Sets Cost from the open task log on accept and success, so R2 can prefer cheaper approaches
 //   - Publishes MsgMegram to bus for Auditor observability
 //   - Fires Write() async (non-blocking)
 //   - Logs a memory_write event to the task log after writing
@@ -951,14 +1709,14 @@ func (g *GGS) writeTerminalMegram(taskID, intent, content, state string) {
 	if g.mem == nil {
 		return
 	}
-	q, ok := memory.QuantizationMatrix()[state]
+	q, ok := g.quantization()[state]
 	if !ok {
 		return
 	}
 	meg := types.Megram{
 		ID:        uuid.New().String(),
 		Level:     "M",
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		CreatedAt: g.clock.Now().UTC().Format(time.RFC3339),
 		Space:     "intent:" + taskID,
 		Entity:    "env:local",
 		Content:   content,
@@ -966,13 +1724,18 @@ func (g *GGS) writeTerminalMegram(taskID, intent, content, state string) {
 		F:         q.F,
 		Sigma:     q.Sigma,
 		K:         q.K,
+		// A FinalResult delivered twice must not count the outcome twice.
+		IdempotencyKey: TerminalIdempotencyKey(taskID, g.runID(taskID), state),
+	}
+	if state == "accept" || state == "success" {
+		meg.Cost = taskCost(g.logReg.Get(taskID))
 	}
 	g.mem.Write(meg)
 	g.logReg.Get(taskID).MemoryWrite(meg.State, meg.Level, meg.Space, meg.Entity)
 	if g.b != nil {
 		g.b.Publish(types.Message{
 			ID:        uuid.New().String(),
-			Timestamp: time.Now().UTC(),
+			Timestamp: g.clock.Now().UTC(),
 			From:      types.RoleGGS,
 			To:        types.RoleMemory,
 			Type:      types.MsgMegram,
@@ -981,9 +1744,214 @@ func (g *GGS) writeTerminalMegram(taskID, intent, content, state string) {
 	}
 }
 
+// taskCost summarises tl's LLM and tool usage so far; nil when there is no log
+// or nothing was spent.
+func taskCost(tl *tasklog.TaskLog) *types.TaskCost {
+	st := tl.Stats()
+	if st == nil {
+		return nil
+	}
+	c := &types.TaskCost{ToolCallCount: st.ToolCallCount, ToolElapsedMs: st.ToolElapsedMs}
+	for _, r := range st.Roles {
+		tokens := r.PromptTokens + r.CompletionTokens
+		c.ByRole = append(c.ByRole, types.RoleCost{Role: r.Role, Calls: r.Calls, TotalTokens: tokens, ElapsedMs: r.ElapsedMs})
+		c.TotalTokens += tokens
+		c.LLMElapsedMs += r.ElapsedMs
+	}
+	if c.TotalTokens == 0 && c.ToolCallCount == 0 {
+		return nil
+	}
+	return c
+}
+
+// TerminalIdempotencyKey returns the idempotency key of the terminal Megram GGS
+// writes for (taskID, state) in the run runID. Task IDs repeat across runs of
+// the same request, so the run ID keeps a rerun's outcome from being dropped.
+func TerminalIdempotencyKey(taskID, runID, state string) string {
+	return "terminal|" + taskID + "|" + runID + "|" + state
+}
+
+// runID returns taskID's current run ID: the ID of the TaskSpec message that
+// started the run, or a fresh one, kept until the next TaskSpec, when GGS never
+// saw it (e.g. a run restored from a checkpoint).
+func (g *GGS) runID(taskID string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	id, ok := g.runIDs[taskID]
+	if !ok {
+		id = uuid.New().String()
+		g.setRunIDLocked(taskID, id)
+	}
+	return id
+}
+
+// maxRunIDs bounds how many task_ids keep a run ID. A run ID is read only while
+// its task is live, and GGS runs few tasks at once, so only recent runs matter.
+const maxRunIDs = 64
+
+// setRunIDLocked records id as taskID's run ID, evicting the least recently
+// started run beyond maxRunIDs. Caller holds g.mu.
+func (g *GGS) setRunIDLocked(taskID, id string) {
+	if _, ok := g.runIDs[taskID]; ok {
+		g.runOrder = slices.DeleteFunc(g.runOrder, func(t string) bool { return t == taskID })
+	}
+	g.runIDs[taskID] = id
+	g.runOrder = append(g.runOrder, taskID)
+	for len(g.runOrder) > maxRunIDs {
+		delete(g.runIDs, g.runOrder[0])
+		g.runOrder = g.runOrder[1:]
+	}
+}
+
+// writeToolPreferenceMegrams records how each tool fared for a kind of subtask so
+// R3 can favour tools that worked and deprioritise ones that did not. On accept and
+// success it writes for matched outcomes; on abandon, for failed ones. Tags come
+// from memory.ToolPreferenceTags (space "tool:<name>", entity = subtask intent slug).
+//
+// Expectations:
+//   - No-ops when mem is nil or state has no quantization row
+//   - Writes for matched outcomes on accept/success and failed outcomes on abandon
+//   - Deduplicates by (toolName, entity) within one call
+//   - Takes the tool name from the text before the first ":" of each ToolCalls entry
+//   - Sets f, sigma, k from the quantization matrix for state
+func (g *GGS) writeToolPreferenceMegrams(taskID string, outcomes []types.SubTaskOutcome, state string) {
+	if g.mem == nil {
+		return
+	}
+	q, ok := g.quantization()[state]
+	if !ok {
+		return
+	}
+	want := "matched"
+	if state == "abandon" {
+		want = "failed"
+	}
+	seen := make(map[string]bool)
+	for _, o := range outcomes {
+		if o.Status != want {
+			continue
+		}
+		for _, tc := range o.ToolCalls {
+			name := tc
+			if idx := strings.Index(tc, ":"); idx > 0 {
+				name = tc[:idx]
+			}
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			space, entity := memory.ToolPreferenceTags(name, o.Intent)
+			if seen[space+"|"+entity] {
+				continue
+			}
+			seen[space+"|"+entity] = true
+			meg := types.Megram{
+				ID:        uuid.New().String(),
+				Level:     "M",
+				CreatedAt: g.clock.Now().UTC().Format(time.RFC3339),
+				Space:     space,
+				Entity:    entity,
+				Content:   o.Intent,
+				State:     state,
+				F:         q.F,
+				Sigma:     q.Sigma,
+				K:         q.K,
+			}
+			g.mem.Write(meg)
+			g.logReg.Get(taskID).MemoryWrite(meg.State, meg.Level, meg.Space, meg.Entity)
+			if g.b != nil {
+				g.b.Publish(types.Message{
+					ID:        uuid.New().String(),
+					Timestamp: g.clock.Now().UTC(),
+					From:      types.RoleGGS,
+					To:        types.RoleMemory,
+					Type:      types.MsgMegram,
+					Payload:   meg,
+				})
+			}
+		}
+	}
+}
+
+// maxCriterionEvidence bounds, in runes, the evidence quoted in a criterion verdict Megram.
+const maxCriterionEvidence = 160
+
+// writeCriterionMegrams records R4a's final verdict on each success criterion so
+// R4a can recall how similar criteria were judged before (see agentval's prior
+// verdict hints). Tags come from memory.CriterionTags. Content reads
+// `pass: "<criterion>" — evidence: <evidence>` (fail adds the failure class).
+//
+// Expectations:
+//   - No-ops when mem is nil or state has no quantization row
+//   - Writes one Megram per CriteriaVerdict across all outcomes, matched or failed
+//   - Deduplicates by criterion text within one call
+//   - Sets f and k from the quantization matrix for state
+//   - Sigma is +|σ| for a passing criterion and −|σ| for a failing one, whatever the task's state
+func (g *GGS) writeCriterionMegrams(taskID string, outcomes []types.SubTaskOutcome, state string) {
+	if g.mem == nil {
+		return
+	}
+	q, ok := g.quantization()[state]
+	if !ok {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, o := range outcomes {
+		for _, cv := range o.CriteriaVerdicts {
+			if cv.Criterion == "" || seen[cv.Criterion] {
+				continue
+			}
+			seen[cv.Criterion] = true
+			sigma := math.Abs(q.Sigma)
+			label := "pass"
+			if cv.Verdict == "fail" {
+				sigma = -sigma
+				label = "fail"
+				if cv.FailureClass != "" {
+					label += " (" + cv.FailureClass + ")"
+				}
+			}
+			content := fmt.Sprintf("%s: %q", label, cv.Criterion)
+			if ev := strings.TrimSpace(cv.Evidence); ev != "" {
+				if r := []rune(ev); len(r) > maxCriterionEvidence {
+					ev = string(r[:maxCriterionEvidence]) + "…"
+				}
+				content += " — evidence: " + ev
+			}
+			space, entity := memory.CriterionTags(cv.Criterion)
+			meg := types.Megram{
+				ID:        uuid.New().String(),
+				Level:     "M",
+				CreatedAt: g.clock.Now().UTC().Format(time.RFC3339),
+				Space:     space,
+				Entity:    entity,
+				Content:   content,
+				State:     state,
+				F:         q.F,
+				Sigma:     sigma,
+				K:         q.K,
+			}
+			g.mem.Write(meg)
+			g.logReg.Get(taskID).MemoryWrite(meg.State, meg.Level, meg.Space, meg.Entity)
+			if g.b != nil {
+				g.b.Publish(types.Message{
+					ID:        uuid.New().String(),
+					Timestamp: g.clock.Now().UTC(),
+					From:      types.RoleGGS,
+					To:        types.RoleMemory,
+					Type:      types.MsgMegram,
+					Payload:   meg,
+				})
+			}
+		}
+	}
+}
+
 // writeMegramsFromToolCalls writes one Megram per unique (tool, target) pair found
 // in failed subtask ToolCalls. Used for action states (refine, change_path, etc.)
 // Tags: space = "tool:<name>"; entity = "target:<value>".
+// routine marks a round whose directive repeats the previous one; its Megrams are
+// sampled down to megramSample (see keepRoutineMegram).
 //
 // Expectations:
 //   - No-ops when mem is nil
@@ -991,12 +1959,13 @@ func (g *GGS) writeTerminalMegram(taskID, intent, content, state string) {
 //   - Deduplicates by (toolName, target) to avoid multiple Megrams for the same pair
 //   - Sets f, sigma, k from quantization matrix for the given directive
 //   - Skips tool calls where ParseToolCall returns an empty target
+//   - Writes every Megram when routine is false; otherwise only those keepRoutineMegram keeps
 //   - Logs one memory_write event per written Megram to the task log
-func (g *GGS) writeMegramsFromToolCalls(taskID string, outcomes []types.SubTaskOutcome, directive string) {
+func (g *GGS) writeMegramsFromToolCalls(taskID string, outcomes []types.SubTaskOutcome, directive string, routine bool) {
 	if g.mem == nil {
 		return
 	}
-	q, ok := memory.QuantizationMatrix()[directive]
+	q, ok := g.quantization()[directive]
 	if !ok {
 		return
 	}
@@ -1015,6 +1984,10 @@ func (g *GGS) writeMegramsFromToolCalls(taskID string, outcomes []types.SubTaskO
 				continue
 			}
 			seen[key] = true
+			if routine && !g.keepRoutineMegram() {
+				slog.Debug("[R7] routine Megram sampled out", "task", taskID, "tool", toolName, "target", target)
+				continue
+			}
 
 			content := o.Intent
 			if o.FailureReason != nil && *o.FailureReason != "" {
@@ -1023,7 +1996,7 @@ func (g *GGS) writeMegramsFromToolCalls(taskID string, outcomes []types.SubTaskO
 			meg := types.Megram{
 				ID:        uuid.New().String(),
 				Level:     "M",
-				CreatedAt: time.Now().UTC().Format(time.RFC3339),
+				CreatedAt: g.clock.Now().UTC().Format(time.RFC3339),
 				Space:     "tool:" + toolName,
 				Entity:    "target:" + target,
 				Content:   content,
@@ -1037,7 +2010,7 @@ func (g *GGS) writeMegramsFromToolCalls(taskID string, outcomes []types.SubTaskO
 			if g.b != nil {
 				g.b.Publish(types.Message{
 					ID:        uuid.New().String(),
-					Timestamp: time.Now().UTC(),
+					Timestamp: g.clock.Now().UTC(),
 					From:      types.RoleGGS,
 					To:        types.RoleMemory,
 					Type:      types.MsgMegram,
@@ -1048,6 +2021,21 @@ func (g *GGS) writeMegramsFromToolCalls(taskID string, outcomes []types.SubTaskO
 	}
 }
 
+// keepRoutineMegram reports whether the next routine Megram is written. Sampling
+// is systematic rather than random — at 0.25 every fourth is kept — so the rate
+// is exact over any run of routine writes.
+//
+// Expectations:
+//   - Keeps every Megram at megramSample 1 and none at 0
+//   - Keeps round(n × megramSample) of n consecutive calls, give or take one
+func (g *GGS) keepRoutineMegram() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.megramRoutine++
+	n := float64(g.megramRoutine)
+	return math.Floor(n*g.megramSample) > math.Floor((n-1)*g.megramSample)
+}
+
 func toReplanRequest(payload any) (types.ReplanRequest, error) {
 	b, err := json.Marshal(payload)
 	if err != nil {
@@ -1065,3 +2053,21 @@ func toOutcomeSummary(payload any) (types.OutcomeSummary, error) {
 	var os types.OutcomeSummary
 	return os, json.Unmarshal(b, &os)
 }
+
+func toTaskSpec(payload any) (types.TaskSpec, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return types.TaskSpec{}, err
+	}
+	var s types.TaskSpec
+	return s, json.Unmarshal(b, &s)
+}
+
+func toFinalResult(payload any) (types.FinalResult, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return types.FinalResult{}, err
+	}
+	var fr types.FinalResult
+	return fr, json.Unmarshal(b, &fr)
+}
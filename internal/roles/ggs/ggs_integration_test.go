@@ -37,7 +37,7 @@ func toPlanDirective(t *testing.T, payload any) types.PlanDirective {
 	return pd
 }
 
-func toFinalResult(t *testing.T, payload any) types.FinalResult {
+func finalResultOf(t *testing.T, payload any) types.FinalResult {
 	t.Helper()
 	b, err := json.Marshal(payload)
 	if err != nil {
@@ -200,7 +200,7 @@ func TestGGSIntegration_Abandon_EmitsFinalResult(t *testing.T) {
 	// Expect FinalResult (not PlanDirective)
 	select {
 	case msg := <-finalCh:
-		fr := toFinalResult(t, msg.Payload)
+		fr := finalResultOf(t, msg.Payload)
 		if fr.TaskID != "task-ab" {
 			t.Errorf("expected task_id 'task-ab', got %q", fr.TaskID)
 		}
@@ -246,7 +246,7 @@ func TestGGSIntegration_SuccessPath_EmitsFinalResult(t *testing.T) {
 
 	select {
 	case msg := <-finalCh:
-		fr := toFinalResult(t, msg.Payload)
+		fr := finalResultOf(t, msg.Payload)
 		if fr.TaskID != "task-suc" {
 			t.Errorf("expected task_id task-suc, got %q", fr.TaskID)
 		}
@@ -290,7 +290,7 @@ func TestGGSIntegration_AcceptPath_EmitsFinalResultWithDZero(t *testing.T) {
 	})
 
 	msg := waitMsg(t, finalCh, 2*time.Second)
-	fr := toFinalResult(t, msg.Payload)
+	fr := finalResultOf(t, msg.Payload)
 	if fr.Loss.D != 0.0 {
 		t.Errorf("accept path: expected D=0, got D=%.3f", fr.Loss.D)
 	}
@@ -3,12 +3,21 @@ package ggs
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"math"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/clock"
+	"github.com/haricheung/agentic-shell/internal/roles/memory"
+	"github.com/haricheung/agentic-shell/internal/tasklog"
 	"github.com/haricheung/agentic-shell/internal/types"
 )
 
@@ -91,11 +100,32 @@ func TestComputeD_FallsBackToSubtaskLevelWhenNoCriteriaVerdicts(t *testing.T) {
 	}
 }
 
+func TestComputeD_LowConfidenceMatchIsPartial(t *testing.T) {
+	// A matched outcome with Confidence c in (0, 1) counts 1 − c per passing criterion
+	confident := types.SubTaskOutcome{Status: "matched", Confidence: 1}
+	barely := types.SubTaskOutcome{Status: "matched", Confidence: 0.6}
+	if got := computeD([]types.SubTaskOutcome{confident, barely}); math.Abs(got-0.2) > 1e-9 {
+		t.Errorf("subtask level: expected D=0.4/2=0.2, got %f", got)
+	}
+	barely.CriteriaVerdicts = []types.CriteriaVerdict{{Criterion: "a", Verdict: "pass"}, {Criterion: "b", Verdict: "pass"}}
+	confident.CriteriaVerdicts = []types.CriteriaVerdict{{Criterion: "c", Verdict: "pass"}, {Criterion: "d", Verdict: "pass"}}
+	if got := computeD([]types.SubTaskOutcome{confident, barely}); math.Abs(got-0.2) > 1e-9 {
+		t.Errorf("criterion level: expected D=0.8/4=0.2, got %f", got)
+	}
+	// Confidence is ignored for failed outcomes and when unset
+	if got := computeD([]types.SubTaskOutcome{{Status: "failed", Confidence: 0.6}, {Status: "matched"}}); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("expected D=0.5, got %f", got)
+	}
+}
+
 // ── computeP ────────────────────────────────────────────────────────────────
 
+// defaultKW is the compiled-in keyword set the P and failure-class tests run under.
+var defaultKW = DefaultFailureKeywords()
+
 func TestComputeP_EmptyOutcomesReturnsNeutral(t *testing.T) {
 	// Returns 0.5 when outcomes is empty (neutral default)
-	if got := computeP(nil); got != 0.5 {
+	if got := defaultKW.computeP(nil); got != 0.5 {
 		t.Errorf("expected 0.5, got %f", got)
 	}
 }
@@ -105,7 +135,7 @@ func TestComputeP_NoFailureReasonsReturnsNeutral(t *testing.T) {
 	outcomes := []types.SubTaskOutcome{
 		{Status: "failed"}, // no FailureReason, no GapTrajectory
 	}
-	if got := computeP(outcomes); got != 0.5 {
+	if got := defaultKW.computeP(outcomes); got != 0.5 {
 		t.Errorf("expected 0.5, got %f", got)
 	}
 }
@@ -116,7 +146,7 @@ func TestComputeP_LogicalKeywordPushesAboveHalf(t *testing.T) {
 	outcomes := []types.SubTaskOutcome{
 		{Status: "failed", FailureReason: &reason},
 	}
-	if got := computeP(outcomes); got <= 0.5 {
+	if got := defaultKW.computeP(outcomes); got <= 0.5 {
 		t.Errorf("expected P > 0.5 for logical failure, got %f", got)
 	}
 }
@@ -127,7 +157,7 @@ func TestComputeP_EnvironmentalKeywordPushesBelowHalf(t *testing.T) {
 	outcomes := []types.SubTaskOutcome{
 		{Status: "failed", FailureReason: &reason},
 	}
-	if got := computeP(outcomes); got >= 0.5 {
+	if got := defaultKW.computeP(outcomes); got >= 0.5 {
 		t.Errorf("expected P < 0.5 for environmental failure, got %f", got)
 	}
 }
@@ -138,7 +168,7 @@ func TestComputeP_InRangeZeroToOne(t *testing.T) {
 	outcomes := []types.SubTaskOutcome{
 		{Status: "failed", FailureReason: &reason},
 	}
-	got := computeP(outcomes)
+	got := defaultKW.computeP(outcomes)
 	if got < 0.0 || got > 1.0 {
 		t.Errorf("P out of range [0,1]: %f", got)
 	}
@@ -155,7 +185,7 @@ func TestComputeP_AllLogicalCriteriaReturnsOne(t *testing.T) {
 			},
 		},
 	}
-	got := computeP(outcomes)
+	got := defaultKW.computeP(outcomes)
 	if math.Abs(got-1.0) > 1e-9 {
 		t.Errorf("expected P=1.0 for all logical failures, got %f", got)
 	}
@@ -171,7 +201,7 @@ func TestComputeP_AllEnvironmentalCriteriaReturnsZero(t *testing.T) {
 			},
 		},
 	}
-	got := computeP(outcomes)
+	got := defaultKW.computeP(outcomes)
 	if math.Abs(got-0.0) > 1e-9 {
 		t.Errorf("expected P=0.0 for all environmental failures, got %f", got)
 	}
@@ -187,25 +217,74 @@ func TestComputeP_FallsBackToKeywordWhenNoStructuredClass(t *testing.T) {
 			},
 		},
 	}
-	got := computeP(outcomes)
+	got := defaultKW.computeP(outcomes)
 	// No keywords in empty failure reasons → neutral 0.5
 	if math.Abs(got-0.5) > 1e-9 {
 		t.Errorf("expected P=0.5 fallback when no structured class, got %f", got)
 	}
 }
 
+func TestComputePKeyword_CustomEnvironmentalKeyword(t *testing.T) {
+	// Matches k's keywords case-insensitively
+	// A deployment's own environmental marker pushes P below 0.5 where the defaults stay neutral
+	reason := "upstream returned ERR-5031 while syncing"
+	outcomes := []types.SubTaskOutcome{{Status: "failed", FailureReason: &reason}}
+	if got := defaultKW.computePKeyword(outcomes); got != 0.5 {
+		t.Fatalf("defaults should not classify %q, got P=%f", reason, got)
+	}
+	t.Setenv("GGS_ENV_KEYWORDS", " ERR-5031 , quota exceeded,")
+	if got := LoadFailureKeywords().computePKeyword(outcomes); got >= 0.5 {
+		t.Errorf("expected P < 0.5 with the custom keyword, got %f", got)
+	}
+}
+
+func TestComputePKeyword_CustomLogicalKeywordNonEnglish(t *testing.T) {
+	// A logical marker in another language pushes P above 0.5
+	reason := "参数错误：路径格式不对"
+	outcomes := []types.SubTaskOutcome{{Status: "failed", FailureReason: &reason}}
+	kw := DefaultFailureKeywords()
+	kw.Logical = append(kw.Logical, "参数错误")
+	if got := kw.computePKeyword(outcomes); got <= 0.5 {
+		t.Errorf("expected P > 0.5 for the custom logical keyword, got %f", got)
+	}
+}
+
+func TestLoadFailureKeywords(t *testing.T) {
+	// Returns DefaultFailureKeywords when neither variable is set
+	// Appends each listed phrase, trimmed and lower-cased, after the defaults
+	// Skips empty phrases and phrases already in the list
+	t.Setenv("GGS_LOGICAL_KEYWORDS", "")
+	t.Setenv("GGS_ENV_KEYWORDS", "")
+	def := DefaultFailureKeywords()
+	if got := LoadFailureKeywords(); !slices.Equal(got.Logical, def.Logical) || !slices.Equal(got.Environmental, def.Environmental) {
+		t.Errorf("unset variables: got %+v, want defaults", got)
+	}
+	t.Setenv("GGS_LOGICAL_KEYWORDS", "Schema Mismatch,,invalid")
+	t.Setenv("GGS_ENV_KEYWORDS", "E503")
+	got := LoadFailureKeywords()
+	if want := append(slices.Clone(def.Logical), "schema mismatch"); !slices.Equal(got.Logical, want) {
+		t.Errorf("Logical = %q, want %q", got.Logical, want)
+	}
+	if want := append(slices.Clone(def.Environmental), "e503"); !slices.Equal(got.Environmental, want) {
+		t.Errorf("Environmental = %q, want %q", got.Environmental, want)
+	}
+}
+
+// defaultHP is the compiled-in hyperparameter set the loss and directive tests run under.
+var defaultHP = DefaultHyperparams()
+
 // ── computeOmega ─────────────────────────────────────────────────────────────
 
 func TestComputeOmega_BothZeroReturnsZero(t *testing.T) {
 	// Returns 0.0 when replanCount=0 and elapsedMs=0
-	if got := computeOmega(0, 0); got != 0.0 {
+	if got := defaultHP.computeOmega(0, 0); got != 0.0 {
 		t.Errorf("expected 0.0, got %f", got)
 	}
 }
 
 func TestComputeOmega_MaxReplansNoTimeReturnsW1(t *testing.T) {
 	// Returns w1 (0.6) when replanCount=maxReplansGGS and elapsedMs=0
-	got := computeOmega(maxReplansGGS, 0)
+	got := defaultHP.computeOmega(maxReplansGGS, 0)
 	if math.Abs(got-w1) > 1e-9 {
 		t.Errorf("expected w1=%.1f, got %f", w1, got)
 	}
@@ -213,7 +292,7 @@ func TestComputeOmega_MaxReplansNoTimeReturnsW1(t *testing.T) {
 
 func TestComputeOmega_ZeroReplansFullBudgetReturnsW2(t *testing.T) {
 	// Returns w2 (0.4) when replanCount=0 and elapsedMs=timeBudgetMs
-	got := computeOmega(0, timeBudgetMs)
+	got := defaultHP.computeOmega(0, timeBudgetMs)
 	if math.Abs(got-w2) > 1e-9 {
 		t.Errorf("expected w2=%.1f, got %f", w2, got)
 	}
@@ -221,7 +300,7 @@ func TestComputeOmega_ZeroReplansFullBudgetReturnsW2(t *testing.T) {
 
 func TestComputeOmega_MaxBothReturnsCappedAtOne(t *testing.T) {
 	// Returns 1.0 when replanCount=maxReplansGGS and elapsedMs=timeBudgetMs
-	got := computeOmega(maxReplansGGS, timeBudgetMs)
+	got := defaultHP.computeOmega(maxReplansGGS, timeBudgetMs)
 	if math.Abs(got-1.0) > 1e-9 {
 		t.Errorf("expected 1.0, got %f", got)
 	}
@@ -229,7 +308,7 @@ func TestComputeOmega_MaxBothReturnsCappedAtOne(t *testing.T) {
 
 func TestComputeOmega_NeverExceedsOne(t *testing.T) {
 	// Never exceeds 1.0
-	got := computeOmega(maxReplansGGS*10, timeBudgetMs*10)
+	got := defaultHP.computeOmega(maxReplansGGS*10, timeBudgetMs*10)
 	if got > 1.0 {
 		t.Errorf("Omega exceeded 1.0: %f", got)
 	}
@@ -239,7 +318,7 @@ func TestComputeOmega_NeverExceedsOne(t *testing.T) {
 
 func TestComputeLoss_PureDistanceLoss(t *testing.T) {
 	// Returns α when D=1, P=0, Ω=0 (pure distance loss)
-	got := computeLoss(1.0, 0.0, 0.0)
+	got := defaultHP.computeLoss(1.0, 0.0, 0.0)
 	if math.Abs(got-alpha) > 1e-9 {
 		t.Errorf("expected α=%.1f, got %f", alpha, got)
 	}
@@ -247,7 +326,7 @@ func TestComputeLoss_PureDistanceLoss(t *testing.T) {
 
 func TestComputeLoss_PureResourceCost(t *testing.T) {
 	// Returns λ when D=0, P=0, Ω=1 (pure resource cost; β_eff=0 when Ω=1)
-	got := computeLoss(0.0, 0.0, 1.0)
+	got := defaultHP.computeLoss(0.0, 0.0, 1.0)
 	if math.Abs(got-lambda) > 1e-9 {
 		t.Errorf("expected λ=%.1f, got %f", lambda, got)
 	}
@@ -256,7 +335,7 @@ func TestComputeLoss_PureResourceCost(t *testing.T) {
 func TestComputeLoss_MaxDistancePlusBetaAtZeroOmega(t *testing.T) {
 	// Returns α+β when D=1, P=1, Ω=0 (λ·Ω = 0 at zero budget pressure)
 	expected := alpha + beta
-	got := computeLoss(1.0, 1.0, 0.0)
+	got := defaultHP.computeLoss(1.0, 1.0, 0.0)
 	if math.Abs(got-expected) > 1e-9 {
 		t.Errorf("expected α+β=%.2f, got %f", expected, got)
 	}
@@ -264,8 +343,8 @@ func TestComputeLoss_MaxDistancePlusBetaAtZeroOmega(t *testing.T) {
 
 func TestComputeLoss_BetaEffZeroWhenOmegaOne(t *testing.T) {
 	// β_eff is zero when Ω=1, so P has no effect when budget is exhausted
-	got1 := computeLoss(0.5, 0.0, 1.0)
-	got2 := computeLoss(0.5, 1.0, 1.0)
+	got1 := defaultHP.computeLoss(0.5, 0.0, 1.0)
+	got2 := defaultHP.computeLoss(0.5, 1.0, 1.0)
 	if math.Abs(got1-got2) > 1e-9 {
 		t.Errorf("P should have no effect when Ω=1: loss(P=0)=%f loss(P=1)=%f", got1, got2)
 	}
@@ -275,7 +354,7 @@ func TestComputeLoss_BetaEffZeroWhenOmegaOne(t *testing.T) {
 
 func TestComputeGradient_PlateauWhenSmallGradAndHighD(t *testing.T) {
 	// Returns "plateau" when |∇L| < epsilon and D > delta
-	got := computeGradient(0.0, delta+0.1)
+	got := defaultHP.computeGradient(0.0, delta+0.1)
 	if got != "plateau" {
 		t.Errorf("expected plateau, got %q", got)
 	}
@@ -283,7 +362,7 @@ func TestComputeGradient_PlateauWhenSmallGradAndHighD(t *testing.T) {
 
 func TestComputeGradient_StableWhenSmallGradAndLowD(t *testing.T) {
 	// Returns "stable" when |∇L| < epsilon and D <= delta
-	got := computeGradient(0.0, delta-0.1)
+	got := defaultHP.computeGradient(0.0, delta-0.1)
 	if got != "stable" {
 		t.Errorf("expected stable, got %q", got)
 	}
@@ -291,7 +370,7 @@ func TestComputeGradient_StableWhenSmallGradAndLowD(t *testing.T) {
 
 func TestComputeGradient_ImprovingWhenNegativeGrad(t *testing.T) {
 	// Returns "improving" when ∇L < -epsilon
-	got := computeGradient(-(epsilon + 0.05), 0.5)
+	got := defaultHP.computeGradient(-(epsilon + 0.05), 0.5)
 	if got != "improving" {
 		t.Errorf("expected improving, got %q", got)
 	}
@@ -299,17 +378,30 @@ func TestComputeGradient_ImprovingWhenNegativeGrad(t *testing.T) {
 
 func TestComputeGradient_WorseningWhenPositiveGrad(t *testing.T) {
 	// Returns "worsening" when ∇L > epsilon
-	got := computeGradient(epsilon+0.05, 0.5)
+	got := defaultHP.computeGradient(epsilon+0.05, 0.5)
 	if got != "worsening" {
 		t.Errorf("expected worsening, got %q", got)
 	}
 }
 
+func TestComputeGradient_BoundaryEpsilonHasDirection(t *testing.T) {
+	// Returns "improving" when ∇L <= -epsilon
+	if got := defaultHP.computeGradient(-epsilon, 0.5); got != "improving" {
+		t.Errorf("∇L == -epsilon: expected improving, got %q", got)
+	}
+	if got := defaultHP.computeGradient(epsilon, 0.5); got != "worsening" {
+		t.Errorf("∇L == epsilon: expected worsening, got %q", got)
+	}
+	if got := defaultHP.computeGradient(0.0, delta); got != "stable" {
+		t.Errorf("D == delta: expected stable, got %q", got)
+	}
+}
+
 // ── selectDirective (v0.8 cascade) ───────────────────────────────────────────
 
 func TestSelectDirective_AbandonWhenOmegaHigh(t *testing.T) {
 	// Returns "abandon" when Omega >= abandonOmega regardless of other values
-	got := selectDirective(0.0, 0.5, 0.5, abandonOmega)
+	got := defaultHP.selectDirective(0.0, 0.5, 0.5, abandonOmega)
 	if got != "abandon" {
 		t.Errorf("expected abandon, got %q", got)
 	}
@@ -317,7 +409,7 @@ func TestSelectDirective_AbandonWhenOmegaHigh(t *testing.T) {
 
 func TestSelectDirective_SuccessWhenDSmallOmegaLow(t *testing.T) {
 	// Returns "success" when D <= delta and Omega < abandonOmega (new in v0.8)
-	got := selectDirective(0.0, delta-0.05, 0.8, 0.2)
+	got := defaultHP.selectDirective(0.0, delta-0.05, 0.8, 0.2)
 	if got != "success" {
 		t.Errorf("expected success, got %q", got)
 	}
@@ -325,7 +417,7 @@ func TestSelectDirective_SuccessWhenDSmallOmegaLow(t *testing.T) {
 
 func TestSelectDirective_SuccessOverridesHighP(t *testing.T) {
 	// Returns "success" even when P is high — D <= delta takes priority over P
-	got := selectDirective(0.0, delta, 0.9, 0.1)
+	got := defaultHP.selectDirective(0.0, delta, 0.9, 0.1)
 	if got != "success" {
 		t.Errorf("expected success (D=%v <= delta=%v), got %q", delta, delta, got)
 	}
@@ -333,7 +425,7 @@ func TestSelectDirective_SuccessOverridesHighP(t *testing.T) {
 
 func TestSelectDirective_BreakSymmetryWhenPlateauHighP(t *testing.T) {
 	// Returns "break_symmetry" when |∇L| < epsilon and P > rho (stuck + logical failure)
-	got := selectDirective(0.0, 0.5, 0.8, 0.2)
+	got := defaultHP.selectDirective(0.0, 0.5, 0.8, 0.2)
 	if got != "break_symmetry" {
 		t.Errorf("expected break_symmetry, got %q", got)
 	}
@@ -341,7 +433,7 @@ func TestSelectDirective_BreakSymmetryWhenPlateauHighP(t *testing.T) {
 
 func TestSelectDirective_ChangePathWhenPlateauLowP(t *testing.T) {
 	// Returns "change_path" when |∇L| < epsilon and P <= rho (stuck + environmental failure)
-	got := selectDirective(0.0, 0.5, 0.2, 0.2)
+	got := defaultHP.selectDirective(0.0, 0.5, 0.2, 0.2)
 	if got != "change_path" {
 		t.Errorf("expected change_path, got %q", got)
 	}
@@ -350,7 +442,7 @@ func TestSelectDirective_ChangePathWhenPlateauLowP(t *testing.T) {
 func TestSelectDirective_ChangeApproachWhenHasSignalHighP(t *testing.T) {
 	// Returns "change_approach" when |∇L| >= epsilon and P > rho (has signal + logical failure)
 	// This includes the v0.7 case 3.3 fix: improving ∇L + logical failure → change_approach, not refine.
-	got := selectDirective(epsilon+0.05, 0.5, 0.8, 0.2)
+	got := defaultHP.selectDirective(epsilon+0.05, 0.5, 0.8, 0.2)
 	if got != "change_approach" {
 		t.Errorf("expected change_approach, got %q", got)
 	}
@@ -359,7 +451,7 @@ func TestSelectDirective_ChangeApproachWhenHasSignalHighP(t *testing.T) {
 func TestSelectDirective_ChangeApproachWhenImprovingHighP(t *testing.T) {
 	// v0.8 case 3.3 fix: ∇L < -ε (improving), D > δ, P > ρ → change_approach (not refine).
 	// v0.7 would have returned "refine" here (wrong — improving loss with wrong approach).
-	got := selectDirective(-(epsilon + 0.05), 0.5, 0.8, 0.2)
+	got := defaultHP.selectDirective(-(epsilon + 0.05), 0.5, 0.8, 0.2)
 	if got != "change_approach" {
 		t.Errorf("expected change_approach (v0.8 case 3.3 fix), got %q", got)
 	}
@@ -367,7 +459,7 @@ func TestSelectDirective_ChangeApproachWhenImprovingHighP(t *testing.T) {
 
 func TestSelectDirective_RefineWhenHasSignalLowP(t *testing.T) {
 	// Returns "refine" when |∇L| >= epsilon and P <= rho (has signal + environmental failure)
-	got := selectDirective(epsilon+0.05, 0.5, 0.2, 0.2)
+	got := defaultHP.selectDirective(epsilon+0.05, 0.5, 0.2, 0.2)
 	if got != "refine" {
 		t.Errorf("expected refine, got %q", got)
 	}
@@ -375,12 +467,69 @@ func TestSelectDirective_RefineWhenHasSignalLowP(t *testing.T) {
 
 func TestSelectDirective_RefineWhenImprovingLowP(t *testing.T) {
 	// Returns "refine" when ∇L < -epsilon and P <= rho (improving + environmental)
-	got := selectDirective(-(epsilon + 0.05), 0.5, 0.2, 0.2)
+	got := defaultHP.selectDirective(-(epsilon + 0.05), 0.5, 0.2, 0.2)
 	if got != "refine" {
 		t.Errorf("expected refine, got %q", got)
 	}
 }
 
+func TestSelectDirective_BoundaryPEqualsRhoIsEnvironmental(t *testing.T) {
+	// Returns "change_path" when D > delta, |∇L| < epsilon, P <= rho
+	if got := defaultHP.selectDirective(0.0, 0.5, rho, 0.2); got != "change_path" {
+		t.Errorf("P == rho: expected change_path, got %q", got)
+	}
+	if got := defaultHP.selectDirective(0.2, 0.5, rho, 0.2); got != "refine" {
+		t.Errorf("P == rho with signal: expected refine, got %q", got)
+	}
+}
+
+func TestSelectDirective_BoundaryDEqualsDeltaIsSuccess(t *testing.T) {
+	// Returns "success" when Omega < abandonOmega and D <= delta
+	if got := defaultHP.selectDirective(0.3, delta, 0.2, 0.2); got != "success" {
+		t.Errorf("D == delta: expected success, got %q", got)
+	}
+	// D as computed from 3 failed criteria of 10.
+	if got := defaultHP.selectDirective(0.3, 3.0/10.0, 0.2, 0.2); got != "success" {
+		t.Errorf("D == 3/10: expected success, got %q", got)
+	}
+}
+
+func TestSelectDirective_BoundaryGradEqualsEpsilonIsSignal(t *testing.T) {
+	// Returns "refine" when D > delta, |∇L| >= epsilon, P <= rho
+	for _, gradL := range []float64{epsilon, -epsilon} {
+		if got := defaultHP.selectDirective(gradL, 0.5, 0.2, 0.2); got != "refine" {
+			t.Errorf("∇L = %v: expected refine, got %q", gradL, got)
+		}
+		if got := defaultHP.selectDirective(gradL, 0.5, 0.8, 0.2); got != "change_approach" {
+			t.Errorf("∇L = %v, high P: expected change_approach, got %q", gradL, got)
+		}
+	}
+}
+
+func TestSelectDirective_BoundaryOmegaEqualsThetaAbandons(t *testing.T) {
+	// Returns "abandon" when Omega >= abandonOmega regardless of other values
+	if got := defaultHP.selectDirective(-0.5, 0.0, 0.0, abandonOmega); got != "abandon" {
+		t.Errorf("Ω == θ: expected abandon, got %q", got)
+	}
+}
+
+func TestSelectDirective_RoundingOnThresholdLandsOnDocumentedSide(t *testing.T) {
+	// Treats values within thresholdTol of a threshold as on it (∇L = 0.5-0.4 is a signal)
+	gradL := 0.5 - 0.4 // 0.09999999999999998
+	if got := defaultHP.selectDirective(gradL, 0.5, 0.2, 0.2); got != "refine" {
+		t.Errorf("∇L = 0.5-0.4: expected refine, got %q", got)
+	}
+	if got := defaultHP.computeGradient(gradL, 0.5); got != "worsening" {
+		t.Errorf("∇L = 0.5-0.4: expected worsening, got %q", got)
+	}
+	if got := defaultHP.selectDirective(0.0, 0.1+0.2, 0.2, 0.2); got != "success" { // 0.30000000000000004
+		t.Errorf("D = 0.1+0.2: expected success, got %q", got)
+	}
+	if got := defaultHP.selectDirective(0.0, 0.5, rho+1e-3, 0.2); got != "break_symmetry" {
+		t.Errorf("P just above rho: expected break_symmetry, got %q", got)
+	}
+}
+
 // ── deriveBlockedTools ───────────────────────────────────────────────────────
 
 func TestDeriveBlockedTools_NilForRefineDirective(t *testing.T) {
@@ -525,37 +674,63 @@ func TestPrimaryFailedCriterion_ReturnsFirstUnmetCriterion(t *testing.T) {
 // ── computeFailureClass ──────────────────────────────────────────────────────
 
 func TestComputeFailureClass_LogicalWhenPAboveHalf(t *testing.T) {
-	// Returns "logical" when P > 0.5
+	// Returns "logical" when P > ρ
 	reason := "wrong approach used"
 	outcomes := []types.SubTaskOutcome{
 		{Status: "failed", FailureReason: &reason},
 	}
-	got := computeFailureClass(outcomes)
+	got := defaultHP.computeFailureClass(defaultKW.computeP(outcomes))
 	if got != "logical" {
 		t.Errorf("expected logical, got %q", got)
 	}
 }
 
 func TestComputeFailureClass_EnvironmentalWhenPBelowHalf(t *testing.T) {
-	// Returns "environmental" when P < 0.5
+	// Returns "environmental" when P < ρ
 	reason := "network timeout"
 	outcomes := []types.SubTaskOutcome{
 		{Status: "failed", FailureReason: &reason},
 	}
-	got := computeFailureClass(outcomes)
+	got := defaultHP.computeFailureClass(defaultKW.computeP(outcomes))
 	if got != "environmental" {
 		t.Errorf("expected environmental, got %q", got)
 	}
 }
 
 func TestComputeFailureClass_MixedWhenPEqualsHalf(t *testing.T) {
-	// Returns "mixed" when P == 0.5
-	got := computeFailureClass(nil) // empty → P = 0.5
+	// Returns "mixed" when P == ρ
+	got := defaultHP.computeFailureClass(defaultKW.computeP(nil)) // empty → P = 0.5
 	if got != "mixed" {
 		t.Errorf("expected mixed, got %q", got)
 	}
 }
 
+func TestComputeFailureClass_FollowsConfiguredRho(t *testing.T) {
+	// The class splits at the configured ρ, agreeing with selectDirective
+	hp := DefaultHyperparams()
+	hp.Rho = 0.7
+	if got := hp.computeFailureClass(0.6); got != "environmental" {
+		t.Errorf("P=0.6 under ρ=0.7: class = %q, want environmental", got)
+	}
+	if d := hp.selectDirective(-0.5, 0.8, 0.6, 0.1); d != "refine" && d != "change_path" {
+		t.Errorf("P=0.6 under ρ=0.7: directive = %q, want an environmental one", d)
+	}
+}
+
+// ── buildRationale ───────────────────────────────────────────────────────────
+
+func TestBuildRationale_PrintsConfiguredThresholds(t *testing.T) {
+	// Thresholds print as configured, and ρ carries its value
+	hp := DefaultHyperparams()
+	hp.Epsilon, hp.Rho = 0.05, 0.7
+	got := hp.buildRationale("change_approach", 0.8, 0.9, 0.1, -0.2, "gap")
+	for _, want := range []string{"ε=0.05", "ρ=0.7"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("rationale %q: want %q", got, want)
+		}
+	}
+}
+
 // ── processAccept ─────────────────────────────────────────────────────────────
 
 func TestProcessAccept_EmitsFinalResultWithCorrectPayload(t *testing.T) {
@@ -564,9 +739,9 @@ func TestProcessAccept_EmitsFinalResultWithCorrectPayload(t *testing.T) {
 	tap := b.NewTap()
 	var gotSummary string
 	var gotOutput any
-	gs := New(b, func(_ string, summary string, output any) {
-		gotSummary = summary
-		gotOutput = output
+	gs := New(b, func(fr types.FinalResult) {
+		gotSummary = fr.Summary
+		gotOutput = fr.Output
 	}, nil, nil)
 	os := types.OutcomeSummary{
 		TaskID:       "t1",
@@ -631,20 +806,81 @@ func TestProcessAccept_DIsAlwaysZero(t *testing.T) {
 	}
 }
 
+func TestProcessAccept_LowConfidenceMatchRaisesD(t *testing.T) {
+	// D is computeD(outcomes): partial for a barely matched subtask; the task is still accepted
+	var got types.FinalResult
+	gs := New(bus.New(), func(fr types.FinalResult) { got = fr }, nil, nil)
+	gs.processAccept(context.Background(), types.OutcomeSummary{TaskID: "t-conf", Outcomes: []types.SubTaskOutcome{
+		{SubTaskID: "s1", Status: "matched", Confidence: 1},
+		{SubTaskID: "s2", Status: "matched", Confidence: 0.6},
+	}})
+	if got.Directive != "accept" || math.Abs(got.Loss.D-0.2) > 1e-9 {
+		t.Errorf("expected accept with D=0.2, got %s with D=%f", got.Directive, got.Loss.D)
+	}
+}
+
+func TestProcessAccept_CarriesConflicts(t *testing.T) {
+	// R4b's conflicts between subtask outputs reach the FinalResult
+	var got types.FinalResult
+	gs := New(bus.New(), func(fr types.FinalResult) { got = fr }, nil, nil)
+	gs.processAccept(context.Background(), types.OutcomeSummary{TaskID: "t-conflict", Conflicts: []string{"s1 and s2 disagree"}})
+	if len(got.Conflicts) != 1 || got.Conflicts[0] != "s1 and s2 disagree" {
+		t.Errorf("expected the conflict on the FinalResult, got %q", got.Conflicts)
+	}
+}
+
 func TestProcessAccept_OmegaUsesElapsedTimeAndPriorReplans(t *testing.T) {
 	// Ω is non-zero even on first-try accept when significant time has elapsed
-	omega := computeOmega(0, timeBudgetMs/2) // 0 replans, half budget elapsed
+	omega := defaultHP.computeOmega(0, timeBudgetMs/2) // 0 replans, half budget elapsed
 	if math.Abs(omega-w2*0.5) > 1e-9 {
 		t.Errorf("expected Ω=w2*0.5=%.3f, got %.3f", w2*0.5, omega)
 	}
 }
 
+func TestProcessAccept_ReportsArtifactsFromAllRounds(t *testing.T) {
+	// Appends each outcome's artifacts in outcome order, skipping duplicates
+	var got []string
+	gs := New(bus.New(), func(fr types.FinalResult) { got = fr.Artifacts }, nil, nil)
+	gs.mu.Lock()
+	gs.artifacts["t3"] = []string{"/tmp/a.txt"} // written in an earlier replan round
+	gs.mu.Unlock()
+
+	gs.processAccept(context.Background(), types.OutcomeSummary{TaskID: "t3", Outcomes: []types.SubTaskOutcome{
+		{SubTaskID: "s1", Status: "matched", Artifacts: []string{"/tmp/a.txt"}},
+		{SubTaskID: "s2", Status: "matched", Artifacts: []string{"/tmp/b.txt"}},
+	}})
+	if !slices.Equal(got, []string{"/tmp/a.txt", "/tmp/b.txt"}) {
+		t.Errorf("expected both files once each, got %v", got)
+	}
+}
+
+// ── collectArtifacts ─────────────────────────────────────────────────────────
+
+func TestCollectArtifacts_NilWhenNone(t *testing.T) {
+	// Returns nil when there are no artifacts at all
+	if got := collectArtifacts(nil, []types.SubTaskOutcome{{SubTaskID: "s1"}}); got != nil {
+		t.Errorf("expected nil, got %v", got)
+	}
+}
+
+func TestCollectArtifacts_KeepsSeenFirst(t *testing.T) {
+	// Keeps seen's paths first, in order
+	seen := []string{"/b", "/a"}
+	got := collectArtifacts(seen, []types.SubTaskOutcome{{Artifacts: []string{"/c", "/a"}}})
+	if !slices.Equal(got, []string{"/b", "/a", "/c"}) {
+		t.Errorf("got %v", got)
+	}
+	if len(seen) != 2 {
+		t.Errorf("seen was modified: %v", seen)
+	}
+}
+
 // ── buildSuccessSummary ──────────────────────────────────────────────────────
 
 func TestBuildSuccessSummary_StartsWithSuccessPrefix(t *testing.T) {
 	// Starts with a success prefix indicating convergence threshold was met
 	rr := types.ReplanRequest{TaskID: "t1", Outcomes: []types.SubTaskOutcome{{Status: "matched"}}}
-	got := buildSuccessSummary(rr)
+	got := buildSuccessSummary(rr, "")
 	if !strings.Contains(got, "✅") {
 		t.Errorf("expected success prefix in summary, got %q", got)
 	}
@@ -659,7 +895,7 @@ func TestBuildSuccessSummary_ListsMatchedAndFailedCounts(t *testing.T) {
 			{Status: "failed"},
 		},
 	}
-	got := buildSuccessSummary(rr)
+	got := buildSuccessSummary(rr, "")
 	if !strings.Contains(got, "1 subtask(s) completed") {
 		t.Errorf("expected matched count in summary, got %q", got)
 	}
@@ -668,6 +904,65 @@ func TestBuildSuccessSummary_ListsMatchedAndFailedCounts(t *testing.T) {
 	}
 }
 
+func TestBuildSuccessSummary_LocaleChangesFixedStrings(t *testing.T) {
+	// Uses the fixed strings for lang; unknown or empty lang uses English
+	rr := types.ReplanRequest{TaskID: "t1", Outcomes: []types.SubTaskOutcome{{Status: "matched"}}}
+	en := buildSuccessSummary(rr, "en")
+	zh := buildSuccessSummary(rr, "zh")
+	if en == zh {
+		t.Fatalf("expected zh summary to differ from en, both %q", en)
+	}
+	if !strings.Contains(zh, "1 个子任务已完成") {
+		t.Errorf("expected localized matched count, got %q", zh)
+	}
+	if got := buildSuccessSummary(rr, "xx"); got != en {
+		t.Errorf("expected unknown locale to fall back to English, got %q", got)
+	}
+}
+
+// ── buildAbandonSummary ──────────────────────────────────────────────────────
+
+func TestBuildAbandonSummary_IdenticalOutcomesByteIdentical(t *testing.T) {
+	// Sorts each intent list, so outcome arrival order does not change the summary
+	outcomes := []types.SubTaskOutcome{
+		{Intent: "fetch prices", Status: "failed"},
+		{Intent: "list files", Status: "matched"},
+		{Intent: "check disk", Status: "failed"},
+		{Intent: "count lines", Status: "matched"},
+	}
+	reversed := make([]types.SubTaskOutcome, len(outcomes))
+	for i, o := range outcomes {
+		reversed[len(outcomes)-1-i] = o
+	}
+	a := buildAbandonSummary(types.ReplanRequest{Outcomes: outcomes, GapSummary: "gap"}, "")
+	b := buildAbandonSummary(types.ReplanRequest{Outcomes: reversed, GapSummary: "gap"}, "")
+	if a != b {
+		t.Errorf("expected byte-identical summaries, got\n%q\n%q", a, b)
+	}
+	if !strings.Contains(a, "Completed: count lines; list files.") || !strings.Contains(a, "Failed: check disk; fetch prices.") {
+		t.Errorf("expected sorted intent lists, got %q", a)
+	}
+}
+
+func TestBuildAbandonSummary_LocaleChangesFixedStrings(t *testing.T) {
+	// Uses the fixed strings for lang; unknown or empty lang uses English
+	rr := types.ReplanRequest{Outcomes: []types.SubTaskOutcome{
+		{Intent: "查找文件", Status: "matched"},
+		{Intent: "下载报告", Status: "failed"},
+	}}
+	en := buildAbandonSummary(rr, "")
+	zh := buildAbandonSummary(rr, "zh")
+	if !strings.HasPrefix(en, "❌ Task abandoned") || !strings.Contains(en, "Consider breaking the task") {
+		t.Errorf("expected English fixed strings by default, got %q", en)
+	}
+	if !strings.HasPrefix(zh, "❌ 预算耗尽") || !strings.Contains(zh, "已完成：查找文件。") || !strings.Contains(zh, "未完成：下载报告。") {
+		t.Errorf("expected Chinese fixed strings, got %q", zh)
+	}
+	if strings.Contains(zh, "Task abandoned") {
+		t.Errorf("expected no English fixed strings in zh summary, got %q", zh)
+	}
+}
+
 // ── mergeMatchedOutputs ──────────────────────────────────────────────────────
 
 func TestMergeMatchedOutputs_NilWhenNoMatchedOutputs(t *testing.T) {
@@ -688,11 +983,11 @@ func TestMergeMatchedOutputs_SingleOutputNotWrapped(t *testing.T) {
 	}
 }
 
-func TestMergeMatchedOutputs_MultipleOutputsReturnSlice(t *testing.T) {
-	// Returns []any of all outputs when multiple matched outputs exist
+func TestMergeMatchedOutputs_MixedShapesReturnSlice(t *testing.T) {
+	// Returns []any of the distinct outputs when their shapes differ
 	outcomes := []types.SubTaskOutcome{
 		{Status: "matched", Output: "a"},
-		{Status: "matched", Output: "b"},
+		{Status: "matched", Output: map[string]any{"count": 3.0}},
 	}
 	got := mergeMatchedOutputs(outcomes)
 	slice, ok := got.([]any)
@@ -704,6 +999,92 @@ func TestMergeMatchedOutputs_MultipleOutputsReturnSlice(t *testing.T) {
 	}
 }
 
+func TestMergeMatchedOutputs_DuplicatesCollapseToOne(t *testing.T) {
+	// Collapses identical outputs to one
+	outcomes := []types.SubTaskOutcome{
+		{Status: "matched", Output: map[string]any{"title": "Go 1.25", "url": "https://go.dev"}},
+		{Status: "matched", Output: map[string]any{"url": "https://go.dev", "title": "Go 1.25"}},
+	}
+	got, ok := mergeMatchedOutputs(outcomes).(map[string]any)
+	if !ok || got["title"] != "Go 1.25" {
+		t.Errorf("expected the single shared output, got %#v", mergeMatchedOutputs(outcomes))
+	}
+	if got := mergeMatchedOutputs([]types.SubTaskOutcome{
+		{Status: "matched", Output: "same"}, {Status: "matched", Output: "same"},
+	}); got != "same" {
+		t.Errorf("expected duplicate strings to collapse, got %#v", got)
+	}
+}
+
+func TestMergeMatchedOutputs_DistinctStringsJoined(t *testing.T) {
+	// Joins distinct string outputs with a blank line, in outcome order
+	outcomes := []types.SubTaskOutcome{
+		{Status: "matched", Output: "a"},
+		{Status: "matched", Output: "b"},
+		{Status: "matched", Output: "a"},
+	}
+	if got := mergeMatchedOutputs(outcomes); got != "a\n\nb" {
+		t.Errorf("expected %q, got %#v", "a\n\nb", got)
+	}
+}
+
+func TestMergeMatchedOutputs_ListsUnioned(t *testing.T) {
+	// Unions list outputs, keeping the first occurrence of each element
+	outcomes := []types.SubTaskOutcome{
+		{Status: "matched", Output: []any{"x.mp4", "y.mp4"}},
+		{Status: "matched", Output: []any{"y.mp4", "z.mp4"}},
+	}
+	got, ok := mergeMatchedOutputs(outcomes).([]any)
+	if !ok || len(got) != 3 || got[0] != "x.mp4" || got[1] != "y.mp4" || got[2] != "z.mp4" {
+		t.Errorf("expected [x.mp4 y.mp4 z.mp4], got %#v", mergeMatchedOutputs(outcomes))
+	}
+}
+
+func TestMergeMatchedOutputs_OrderedBySequenceNotArrival(t *testing.T) {
+	// Orders outputs by Sequence, then SubTaskID, regardless of arrival order
+	outcomes := []types.SubTaskOutcome{
+		{SubTaskID: "c", Sequence: 2, Status: "matched", Output: "third"},
+		{SubTaskID: "b", Sequence: 1, Status: "matched", Output: "second"},
+		{SubTaskID: "a", Sequence: 1, Status: "matched", Output: "first"},
+	}
+	if got := mergeMatchedOutputs(outcomes); got != "first\n\nsecond\n\nthird" {
+		t.Errorf("expected outputs in sequence order, got %#v", got)
+	}
+	lists := []types.SubTaskOutcome{
+		{SubTaskID: "b", Sequence: 2, Status: "matched", Output: []any{"z.mp4"}},
+		{SubTaskID: "a", Sequence: 1, Status: "matched", Output: []any{"x.mp4", "y.mp4"}},
+	}
+	got, ok := mergeMatchedOutputs(lists).([]any)
+	if !ok || len(got) != 3 || got[0] != "x.mp4" || got[1] != "y.mp4" || got[2] != "z.mp4" {
+		t.Errorf("expected [x.mp4 y.mp4 z.mp4], got %#v", mergeMatchedOutputs(lists))
+	}
+}
+
+func TestMergeMatchedOutputs_StableAcrossArrivalOrders(t *testing.T) {
+	// Orders outputs by Sequence, then SubTaskID, regardless of arrival order
+	a := types.SubTaskOutcome{SubTaskID: "a", Sequence: 1, Status: "matched", Output: "a"}
+	b := types.SubTaskOutcome{SubTaskID: "b", Sequence: 1, Status: "matched", Output: map[string]any{"n": 1.0}}
+	c := types.SubTaskOutcome{SubTaskID: "c", Sequence: 2, Status: "matched", Output: []any{"c"}}
+	want := outputKey(mergeMatchedOutputs([]types.SubTaskOutcome{a, b, c}))
+	for _, order := range [][]types.SubTaskOutcome{{c, b, a}, {b, c, a}, {c, a, b}} {
+		if got := outputKey(mergeMatchedOutputs(order)); got != want {
+			t.Errorf("merge of %s%s%s = %s, want %s", order[0].SubTaskID, order[1].SubTaskID, order[2].SubTaskID, got, want)
+		}
+	}
+}
+
+func TestMergeMatchedOutputs_LeavesCallerSliceUntouched(t *testing.T) {
+	// Does not reorder the caller's outcomes slice
+	outcomes := []types.SubTaskOutcome{
+		{SubTaskID: "b", Sequence: 2, Status: "matched", Output: "b"},
+		{SubTaskID: "a", Sequence: 1, Status: "matched", Output: "a"},
+	}
+	mergeMatchedOutputs(outcomes)
+	if outcomes[0].SubTaskID != "b" || outcomes[1].SubTaskID != "a" {
+		t.Errorf("expected caller's order b,a to be kept, got %s,%s", outcomes[0].SubTaskID, outcomes[1].SubTaskID)
+	}
+}
+
 // ── prevDirective tracking ────────────────────────────────────────────────────
 
 func TestProcessAccept_PrevDirectiveIsInitOnFirstTry(t *testing.T) {
@@ -736,6 +1117,158 @@ func TestProcessAccept_PrevDirectiveIsInitOnFirstTry(t *testing.T) {
 	}
 }
 
+// ── repeated plans ───────────────────────────────────────────────────────────
+
+func TestPlanRepeated(t *testing.T) {
+	// False with fewer than two fingerprints or an empty one; true when the last two
+	// share at least planRepeatOverlap of their keywords
+	for _, tc := range []struct {
+		plans []string
+		want  bool
+	}{
+		{nil, false},
+		{[]string{"downloads find pdf"}, false},
+		{[]string{"downloads find pdf", ""}, false},
+		{[]string{"downloads find pdf", "downloads find pdf"}, true},
+		{[]string{"archive downloads find largest pdf", "downloads find largest pdf"}, true},
+		{[]string{"downloads find pdf", "calendar list meetings"}, false},
+		{[]string{"calendar list meetings", "downloads find pdf", "downloads find pdf"}, true},
+	} {
+		if got := planRepeated(tc.plans); got != tc.want {
+			t.Errorf("planRepeated(%q) = %v, want %v", tc.plans, got, tc.want)
+		}
+	}
+}
+
+// environmentalReplanRequest returns a round's ReplanRequest with one subtask that
+// failed for an environmental reason, which selects change_path or refine.
+func environmentalReplanRequest(taskID string, round int, priorPlans ...string) types.ReplanRequest {
+	reason := "connection timed out"
+	return types.ReplanRequest{
+		TaskID: taskID, GapSummary: "download failed", ElapsedMs: 1000, Round: round, PriorPlans: priorPlans,
+		Outcomes: []types.SubTaskOutcome{{
+			SubTaskID: "s1", Intent: "download the report", Status: "failed", FailureReason: &reason,
+			CriteriaVerdicts: []types.CriteriaVerdict{{Criterion: "file exists", Verdict: "fail", FailureClass: "environmental"}},
+			ToolCalls:        []string{"shell"},
+		}},
+	}
+}
+
+func TestProcess_RepeatedPlanEscalatesToBreakSymmetry(t *testing.T) {
+	// A round whose plan fingerprint matches the previous round's escalates an
+	// environmental directive to break_symmetry, and says why in the rationale
+	b := bus.New()
+	tap := b.NewTap()
+	gs := New(b, nil, nil, nil)
+	const plan = "download report the"
+
+	gs.process(t.Context(), environmentalReplanRequest("t1", 1, plan))
+	if pd := nextPlanDirective(t, tap); pd.Directive == "break_symmetry" {
+		t.Fatalf("round 1 has no earlier plan to repeat, got %q", pd.Directive)
+	}
+	gs.process(t.Context(), environmentalReplanRequest("t1", 2, plan, plan))
+	pd := nextPlanDirective(t, tap)
+	if pd.Directive != "break_symmetry" {
+		t.Errorf("round 2 directive = %q, want break_symmetry", pd.Directive)
+	}
+	if !strings.HasPrefix(pd.Rationale, "Plan repeated") {
+		t.Errorf("expected the rationale to name the repeated plan, got %q", pd.Rationale)
+	}
+}
+
+func TestProcess_ChangedPlanIsNotEscalated(t *testing.T) {
+	// A round whose plan differs from the previous round's keeps the selected directive
+	b := bus.New()
+	tap := b.NewTap()
+	gs := New(b, nil, nil, nil)
+
+	gs.process(t.Context(), environmentalReplanRequest("t1", 1, "download report the"))
+	nextPlanDirective(t, tap)
+	gs.process(t.Context(), environmentalReplanRequest("t1", 2, "download report the", "mirror fetch archived copy"))
+	if pd := nextPlanDirective(t, tap); pd.Directive == "break_symmetry" {
+		t.Errorf("a changed plan should not escalate, got %q (%s)", pd.Directive, pd.Rationale)
+	}
+}
+
+// ── Trajectory ────────────────────────────────────────────────────────────────
+
+func TestTrajectory_RecordsRoundsInOrderThroughAccept(t *testing.T) {
+	// Returns one snapshot per round processed, in order, each with its directive, loss, and ∇L;
+	// includes the accept round, and still returns the rounds after the task is terminal
+	gs := New(bus.New(), nil, nil, nil)
+	gs.process(t.Context(), environmentalReplanRequest("t1", 1))
+	gs.process(t.Context(), environmentalReplanRequest("t1", 2))
+	gs.processAccept(t.Context(), types.OutcomeSummary{TaskID: "t1", ElapsedMs: 2000})
+
+	traj := gs.Trajectory("t1")
+	if len(traj) != 3 {
+		t.Fatalf("got %d snapshots, want 3: %+v", len(traj), traj)
+	}
+	for i, s := range traj {
+		if s.Round != i+1 {
+			t.Errorf("snapshot %d has round %d, want %d", i, s.Round, i+1)
+		}
+		if s.Loss.L == 0 || s.At.IsZero() {
+			t.Errorf("snapshot %d is missing its loss or time: %+v", i, s)
+		}
+	}
+	if traj[0].Directive == "" || traj[0].GradL != 0 {
+		t.Errorf("first round = %+v, want a directive and ∇L 0", traj[0])
+	}
+	if traj[1].GradL != traj[1].Loss.L-traj[0].Loss.L {
+		t.Errorf("round 2 ∇L = %v, want L2 − L1 = %v", traj[1].GradL, traj[1].Loss.L-traj[0].Loss.L)
+	}
+	if traj[2].Directive != "accept" {
+		t.Errorf("last directive = %q, want accept", traj[2].Directive)
+	}
+	if _, live := gs.trajectory["t1"]; live {
+		t.Error("a terminal task should leave the live trajectories")
+	}
+}
+
+func TestTrajectory_BoundsRoundsAndFinishedTasks(t *testing.T) {
+	// Keeps at most maxTrajectoryRounds rounds per task, dropping the oldest, and the rounds of
+	// the last maxFinishedTrajectories terminal tasks; returns nil for an unknown task
+	gs := New(bus.New(), nil, nil, nil)
+	for r := 1; r <= maxTrajectoryRounds+5; r++ {
+		gs.record("long", r, "refine", types.LossBreakdown{L: 0.5}, 0)
+	}
+	traj := gs.Trajectory("long")
+	if len(traj) != maxTrajectoryRounds || traj[0].Round != 6 {
+		t.Errorf("got %d rounds starting at %d, want %d starting at 6", len(traj), traj[0].Round, maxTrajectoryRounds)
+	}
+
+	for i := 0; i <= maxFinishedTrajectories; i++ {
+		id := fmt.Sprintf("t%d", i)
+		gs.record(id, 1, "abandon", types.LossBreakdown{L: 0.9}, 0)
+		gs.forget(id)
+	}
+	if gs.Trajectory("t0") != nil {
+		t.Error("the oldest finished task should have been evicted")
+	}
+	if got := gs.Trajectory(fmt.Sprintf("t%d", maxFinishedTrajectories)); len(got) != 1 {
+		t.Errorf("the newest finished task has %d rounds, want 1", len(got))
+	}
+	if gs.Trajectory("unknown") != nil {
+		t.Error("expected nil for an unknown task")
+	}
+}
+
+func TestTrajectory_StampsRoundsFromClock(t *testing.T) {
+	// Each RoundSnapshot's At is read from the GGS clock (clock.Wall unless SetClock is called)
+	fc := clock.NewFake(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+	gs := New(bus.New(), nil, nil, nil)
+	gs.SetClock(fc)
+	gs.record("t1", 1, "refine", types.LossBreakdown{L: 0.5}, 0)
+	fc.Advance(90 * time.Second)
+	gs.record("t1", 2, "refine", types.LossBreakdown{L: 0.4}, -0.1)
+
+	traj := gs.Trajectory("t1")
+	if len(traj) != 2 || !traj[0].At.Equal(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)) || traj[1].At.Sub(traj[0].At) != 90*time.Second {
+		t.Errorf("got %+v, want rounds stamped 90s apart from the fake clock", traj)
+	}
+}
+
 // ── Law 2 kill-switch ─────────────────────────────────────────────────────────
 
 // worseningOutcomes returns a ReplanRequest whose outcomes produce a worsening
@@ -855,6 +1388,52 @@ func TestLaw2KillSwitch_NotFiredAfterOnlyOneWorsening(t *testing.T) {
 	}
 }
 
+// roundsUntilAbandon feeds a GGS with the given kill threshold consecutive
+// worsening rounds and returns the round that produced the abandon FinalResult,
+// or 0 if none of maxRounds did.
+func roundsUntilAbandon(t *testing.T, threshold, maxRounds int) int {
+	t.Helper()
+	b := bus.New()
+	tap := b.NewTap()
+	gs := New(b, nil, nil, nil)
+	hp := DefaultHyperparams()
+	hp.Law2Kill = threshold
+	gs.SetHyperparams(hp)
+	taskID := "law2-threshold"
+	for round := 1; round <= maxRounds; round++ {
+		gs.mu.Lock()
+		gs.lPrev[taskID] = 0.01 // keep the gradient worsening
+		gs.mu.Unlock()
+		gs.process(context.Background(), worseningReplanRequest(taskID))
+		timeout := time.After(500 * time.Millisecond)
+	wait:
+		for {
+			select {
+			case msg := <-tap:
+				switch msg.Type {
+				case types.MsgFinalResult:
+					return round
+				case types.MsgPlanDirective:
+					break wait
+				}
+			case <-timeout:
+				t.Fatalf("round %d: timed out waiting for a directive", round)
+			}
+		}
+	}
+	return 0
+}
+
+func TestLaw2KillSwitch_ThresholdFromHyperparams(t *testing.T) {
+	// directive overridden to "abandon" after Hyperparams.Law2Kill consecutive worsening gradients
+	if got := roundsUntilAbandon(t, 1, 3); got != 1 {
+		t.Errorf("threshold 1: abandoned at round %d, want 1", got)
+	}
+	if got := roundsUntilAbandon(t, 3, 3); got != 3 {
+		t.Errorf("threshold 3: abandoned at round %d, want 3", got)
+	}
+}
+
 func TestLaw2KillSwitch_ResetWhenGradientImproves(t *testing.T) {
 	// worseningCount resets to 0 when gradient is not worsening
 	// Sequence: worsening(1) → improving(0) → worsening(1) → no kill-switch on 3rd call
@@ -1008,3 +1587,782 @@ func TestBuildTerminalContent_AbandonFallbackWhenNoOutcomes(t *testing.T) {
 		t.Errorf("expected gap_summary in fallback, got: %s", got)
 	}
 }
+
+// ── checkpoints ──────────────────────────────────────────────────────────────
+
+// nextPlanDirective returns the next PlanDirective published on tap.
+func nextPlanDirective(t *testing.T, tap <-chan types.Message) types.PlanDirective {
+	t.Helper()
+	timeout := time.After(500 * time.Millisecond)
+	for {
+		select {
+		case msg := <-tap:
+			if msg.Type == types.MsgFinalResult {
+				t.Fatalf("expected a PlanDirective, got FinalResult %+v", msg.Payload)
+			}
+			if pd, ok := msg.Payload.(types.PlanDirective); ok && msg.Type == types.MsgPlanDirective {
+				return pd
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for PlanDirective")
+		}
+	}
+}
+
+func TestCheckpoint_StateRoundTrips(t *testing.T) {
+	// Restores every checkpointed field; L_prev only when it was recorded
+	dir := t.TempDir()
+	g1 := NewWithCheckpoints(bus.New(), nil, nil, nil, dir)
+	g1.mu.Lock()
+	g1.lPrev["t1"] = 0.42
+	g1.replans["t1"] = 2
+	g1.worseningCount["t1"] = 1
+	g1.triedTargets["t1"] = []string{"golang release", "ls /missing"}
+	g1.prevDirective["t1"] = "change_path"
+	g1.mu.Unlock()
+	g1.checkpoint("t1")
+
+	g2 := NewWithCheckpoints(bus.New(), nil, nil, nil, dir)
+	g2.mu.Lock()
+	g2.restoreLocked("t1")
+	defer g2.mu.Unlock()
+	if g2.lPrev["t1"] != 0.42 || g2.replans["t1"] != 2 || g2.worseningCount["t1"] != 1 || g2.prevDirective["t1"] != "change_path" {
+		t.Errorf("expected restored scalars, got lPrev=%v replans=%d worsening=%d prev=%q",
+			g2.lPrev["t1"], g2.replans["t1"], g2.worseningCount["t1"], g2.prevDirective["t1"])
+	}
+	if got := strings.Join(g2.triedTargets["t1"], "|"); got != "golang release|ls /missing" {
+		t.Errorf("expected restored tried targets, got %q", got)
+	}
+}
+
+func TestCheckpoint_DisabledWritesNothing(t *testing.T) {
+	// No-ops when checkpointDir is ""
+	g := New(bus.New(), nil, nil, nil)
+	g.replans["t1"] = 1
+	g.checkpoint("t1") // must not panic or write anywhere
+	g.mu.Lock()
+	g.restoreLocked("t2")
+	g.mu.Unlock()
+	if _, ok := g.replans["t2"]; ok {
+		t.Errorf("expected no state restored without a checkpoint dir")
+	}
+}
+
+func TestCheckpoint_ResumedGGSContinuesGradient(t *testing.T) {
+	// a restarted GGS picks up L_prev and the replan count, so ∇L is computed across the restart
+	dir := t.TempDir()
+	rr := worseningReplanRequest("resume-task")
+
+	b1 := bus.New()
+	tap1 := b1.NewTap()
+	g1 := NewWithCheckpoints(b1, nil, nil, nil, dir)
+	g1.process(context.Background(), rr)
+	first := nextPlanDirective(t, tap1)
+
+	// Simulated restart: a fresh GGS over the same checkpoint directory.
+	b2 := bus.New()
+	tap2 := b2.NewTap()
+	g2 := NewWithCheckpoints(b2, nil, nil, nil, dir)
+	g2.process(context.Background(), rr)
+	second := nextPlanDirective(t, tap2)
+
+	if second.GradL == 0 || math.Abs(second.GradL-(second.Loss.L-first.Loss.L)) > 1e-9 {
+		t.Errorf("expected ∇L = L2 − L1 = %v, got %v", second.Loss.L-first.Loss.L, second.GradL)
+	}
+	if second.PrevDirective != first.Directive {
+		t.Errorf("expected prev directive %q after resume, got %q", first.Directive, second.PrevDirective)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "resume-task.json"))
+	if err != nil {
+		t.Fatalf("expected a checkpoint file: %v", err)
+	}
+	var st taskState
+	if err := json.Unmarshal(data, &st); err != nil || st.Replans != 2 || st.LPrev == nil || *st.LPrev != second.Loss.L {
+		t.Errorf("expected checkpoint with 2 replans and L_prev = L2, got %+v (err %v)", st, err)
+	}
+}
+
+func TestNewWithStatePath_PersistsOnlyWithAPath(t *testing.T) {
+	// "" keeps controller state in memory only; a path resumes a task across a restart
+	if g := NewWithStatePath(bus.New(), nil, nil, ""); g.checkpointDir != "" {
+		t.Errorf("empty path: checkpointDir = %q, want in-memory only", g.checkpointDir)
+	}
+	dir := t.TempDir()
+	rr := worseningReplanRequest("resume-task")
+	NewWithStatePath(bus.New(), nil, nil, dir).process(context.Background(), rr)
+
+	b := bus.New()
+	tap := b.NewTap()
+	NewWithStatePath(b, nil, nil, dir).process(context.Background(), rr)
+	if pd := nextPlanDirective(t, tap); pd.GradL == 0 || pd.PrevDirective == "" {
+		t.Errorf("expected the restarted GGS to resume the gradient, got %+v", pd)
+	}
+}
+
+func TestCheckpoint_RemovedOnTerminalState(t *testing.T) {
+	// Checkpoints are removed when the task reaches a terminal state
+	dir := t.TempDir()
+	b := bus.New()
+	tap := b.NewTap()
+	g := NewWithCheckpoints(b, nil, nil, nil, dir)
+	g.process(context.Background(), worseningReplanRequest("done-task"))
+	nextPlanDirective(t, tap)
+	path := filepath.Join(dir, "done-task.json")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected a checkpoint after a replan round: %v", err)
+	}
+
+	g.processAccept(context.Background(), types.OutcomeSummary{TaskID: "done-task"})
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the checkpoint removed after accept, stat err = %v", err)
+	}
+}
+
+// ── round counter / terminal guard ───────────────────────────────────────────
+
+// expectSilence fails if tap carries a PlanDirective or FinalResult within a short window.
+func expectSilence(t *testing.T, tap <-chan types.Message) {
+	t.Helper()
+	timeout := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case msg := <-tap:
+			if msg.Type == types.MsgPlanDirective || msg.Type == types.MsgFinalResult {
+				t.Fatalf("expected the request to be dropped, got %s %+v", msg.Type, msg.Payload)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestAdmitRound_AdoptsR4bRoundAndRejectsStale(t *testing.T) {
+	// R4b's round is adopted; rounds at or below the last one seen are rejected
+	g := New(bus.New(), nil, nil, nil)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if n, ok := g.admitRoundLocked("t1", 2); !ok || n != 2 {
+		t.Fatalf("round 2: got %d, %v; want 2, true", n, ok)
+	}
+	for _, stale := range []int{1, 2} {
+		if _, ok := g.admitRoundLocked("t1", stale); ok {
+			t.Errorf("round %d after round 2 should be rejected", stale)
+		}
+	}
+	if n, ok := g.admitRoundLocked("t1", 0); !ok || n != 3 {
+		t.Errorf("unstamped round: got %d, %v; want own count 3, true", n, ok)
+	}
+}
+
+func TestAdmitRound_OffsetsByCheckpointedRounds(t *testing.T) {
+	// After a restart R4b counts from 1 again; GGS continues from the restored count
+	dir := t.TempDir()
+	g1 := NewWithCheckpoints(bus.New(), nil, nil, nil, dir)
+	g1.mu.Lock()
+	g1.replans["t1"] = 2
+	g1.mu.Unlock()
+	g1.checkpoint("t1")
+
+	g2 := NewWithCheckpoints(bus.New(), nil, nil, nil, dir)
+	g2.mu.Lock()
+	defer g2.mu.Unlock()
+	g2.restoreLocked("t1")
+	if n, ok := g2.admitRoundLocked("t1", 1); !ok || n != 3 {
+		t.Errorf("got %d, %v; want 3, true", n, ok)
+	}
+}
+
+func TestProcess_OutOfOrderRequestAfterAbandonIgnored(t *testing.T) {
+	// Round 3 abandons; a late round-2 request for the same task emits nothing
+	b := bus.New()
+	tap := b.NewTap()
+	g := New(b, nil, nil, nil)
+
+	final := worseningReplanRequest("race-task")
+	final.Round = 3
+	final.Recommendation = "abandon"
+	g.process(context.Background(), final)
+	timeout := time.After(500 * time.Millisecond)
+	for abandoned := false; !abandoned; {
+		select {
+		case msg := <-tap:
+			if msg.Type == types.MsgPlanDirective {
+				t.Fatalf("expected abandon, got PlanDirective %+v", msg.Payload)
+			}
+			if fr, ok := msg.Payload.(types.FinalResult); ok && msg.Type == types.MsgFinalResult {
+				if fr.Directive != "abandon" || fr.Replans != 3 {
+					t.Fatalf("expected abandon at round 3, got %q with %d replans", fr.Directive, fr.Replans)
+				}
+				abandoned = true
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for abandon")
+		}
+	}
+
+	late := worseningReplanRequest("race-task")
+	late.Round = 2
+	g.process(context.Background(), late)
+	g.processAccept(context.Background(), types.OutcomeSummary{TaskID: "race-task"})
+	expectSilence(t, tap)
+}
+
+func TestProcess_EchoesRoundInPlanDirective(t *testing.T) {
+	// PlanDirective.Round echoes ReplanRequest.Round; a duplicate of that round is dropped
+	b := bus.New()
+	tap := b.NewTap()
+	g := New(b, nil, nil, nil)
+	rr := worseningReplanRequest("echo-task")
+	rr.Round = 1
+	g.process(context.Background(), rr)
+	if pd := nextPlanDirective(t, tap); pd.Round != 1 {
+		t.Errorf("PlanDirective.Round = %d, want 1", pd.Round)
+	}
+	g.process(context.Background(), rr)
+	expectSilence(t, tap)
+}
+
+func TestRun_NewTaskSpecClearsTerminalMark(t *testing.T) {
+	// A new TaskSpec for a finished task_id starts a fresh run
+	b := bus.New()
+	tap := b.NewTap()
+	g := New(b, nil, nil, nil)
+	g.mu.Lock()
+	g.terminal["again"] = true
+	g.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.Run(ctx)
+	time.Sleep(20 * time.Millisecond) // let Run subscribe
+	b.Publish(types.Message{Type: types.MsgTaskSpec, Payload: types.TaskSpec{TaskID: "again"}})
+	time.Sleep(20 * time.Millisecond)
+
+	rr := worseningReplanRequest("again")
+	rr.Round = 1
+	b.Publish(types.Message{Type: types.MsgReplanRequest, Payload: rr})
+	if pd := nextPlanDirective(t, tap); pd.TaskID != "again" {
+		t.Errorf("expected a PlanDirective for the new run, got %+v", pd)
+	}
+}
+
+func TestRun_CancelledFinalResultForgetsTask(t *testing.T) {
+	// A cancelled FinalResult drops the task's state and marks it terminal
+	b := bus.New()
+	g := New(b, nil, nil, nil)
+	g.mu.Lock()
+	g.replans["stopped"] = 2
+	g.lPrev["stopped"] = 0.7
+	g.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.Run(ctx)
+	time.Sleep(20 * time.Millisecond) // let Run subscribe
+	b.Publish(types.Message{Type: types.MsgFinalResult, Payload: types.FinalResult{
+		TaskID: "stopped", Directive: "cancelled", CancelReason: types.CancelUser,
+	}})
+	time.Sleep(20 * time.Millisecond)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.terminal["stopped"] {
+		t.Error("cancelled task should be marked terminal")
+	}
+	if _, ok := g.replans["stopped"]; ok {
+		t.Error("cancelled task's replan count should be forgotten")
+	}
+	if _, ok := g.lPrev["stopped"]; ok {
+		t.Error("cancelled task's previous loss should be forgotten")
+	}
+}
+
+func TestForget_TerminalMarksAreBounded(t *testing.T) {
+	// Keeps at most maxTerminalMarks terminal marks, evicting the oldest first
+	g := New(bus.New(), nil, nil, nil)
+	for i := 0; i <= maxTerminalMarks; i++ {
+		g.forget(fmt.Sprintf("task-%d", i))
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.terminal) != maxTerminalMarks || len(g.terminalOrder) != maxTerminalMarks {
+		t.Errorf("terminal marks = %d (order %d), want %d", len(g.terminal), len(g.terminalOrder), maxTerminalMarks)
+	}
+	if g.terminal["task-0"] {
+		t.Error("oldest terminal mark should be evicted")
+	}
+	if !g.terminal[fmt.Sprintf("task-%d", maxTerminalMarks)] {
+		t.Error("newest terminal mark should be kept")
+	}
+}
+
+func TestRun_PlannerFinalResultDropsAssumptions(t *testing.T) {
+	// A FinalResult from another role ends the task; its recorded assumptions are dropped
+	b := bus.New()
+	g := New(b, nil, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.Run(ctx)
+	time.Sleep(20 * time.Millisecond) // let Run subscribe
+
+	b.Publish(types.Message{Type: types.MsgTaskSpec, Payload: types.TaskSpec{TaskID: "t1", Assumptions: []string{"assumed"}}})
+	time.Sleep(20 * time.Millisecond)
+	b.Publish(types.Message{Type: types.MsgFinalResult, From: types.RolePlanner, Payload: types.FinalResult{TaskID: "t1", Directive: "abandon"}})
+	time.Sleep(20 * time.Millisecond)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.assumptions["t1"]; ok {
+		t.Error("assumptions should be dropped once another role ends the task")
+	}
+}
+
+func TestRun_TaskSpecAssumptionsReachFinalResult(t *testing.T) {
+	// Records a TaskSpec's assumptions and attaches them to the task's FinalResult
+	b := bus.New()
+	results := make(chan types.FinalResult, 1)
+	g := New(b, func(fr types.FinalResult) { results <- fr }, nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.Run(ctx)
+	time.Sleep(20 * time.Millisecond) // let Run subscribe
+
+	assumed := []string{"assumed 'the file' means the most recently modified .md"}
+	b.Publish(types.Message{Type: types.MsgTaskSpec, Payload: types.TaskSpec{TaskID: "t1", Intent: "summarise the file", Assumptions: assumed}})
+	time.Sleep(20 * time.Millisecond)
+	b.Publish(types.Message{Type: types.MsgOutcomeSummary, Payload: types.OutcomeSummary{TaskID: "t1", Summary: "done"}})
+
+	select {
+	case fr := <-results:
+		if len(fr.Assumptions) != 1 || fr.Assumptions[0] != assumed[0] {
+			t.Errorf("FinalResult assumptions = %v, want %v", fr.Assumptions, assumed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for FinalResult")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.assumptions["t1"]; ok {
+		t.Error("assumptions should be dropped once delivered")
+	}
+}
+
+// ── Megram write sampling ───────────────────────────────────────────────────
+
+// countingMem is a MemoryService that only counts and records Writes.
+type countingMem struct {
+	mu     sync.Mutex
+	writes int
+	megs   []types.Megram
+}
+
+func (m *countingMem) Write(meg types.Megram) {
+	m.mu.Lock()
+	m.writes++
+	m.megs = append(m.megs, meg)
+	m.mu.Unlock()
+}
+func (m *countingMem) QueryC(context.Context, string, string) ([]types.SOPRecord, error) {
+	return nil, nil
+}
+func (m *countingMem) QueryMK(context.Context, string, string) (types.Potentials, error) {
+	return types.Potentials{}, nil
+}
+func (m *countingMem) QueryRecent(context.Context, string, string, int) ([]types.Megram, error) {
+	return nil, nil
+}
+func (m *countingMem) RecordNegativeFeedback(context.Context, string, string) {}
+func (m *countingMem) Clear() (int, error)                                    { return 0, nil }
+func (m *countingMem) Close()                                                 {}
+
+func TestWriteTerminalMegram_SetsIdempotencyKey(t *testing.T) {
+	// Sets IdempotencyKey from (taskID, state) so R5 drops a replayed write
+	mem := &countingMem{}
+	g := New(nil, nil, mem, nil)
+	g.writeTerminalMegram("t1", "find audio", "done", "accept")
+	g.writeTerminalMegram("t1", "find audio", "done", "accept")
+	g.writeTerminalMegram("t1", "find audio", "gave up", "abandon")
+	if len(mem.megs) != 3 {
+		t.Fatalf("writes = %d, want 3", len(mem.megs))
+	}
+	if a, b := mem.megs[0].IdempotencyKey, mem.megs[1].IdempotencyKey; a == "" || a != b {
+		t.Errorf("same task+state keys = %q, %q; want equal and non-empty", a, b)
+	}
+	if mem.megs[2].IdempotencyKey == mem.megs[0].IdempotencyKey {
+		t.Errorf("abandon reused the accept key %q", mem.megs[0].IdempotencyKey)
+	}
+}
+
+func TestWriteTerminalMegram_NewRunGetsNewKey(t *testing.T) {
+	// Sets IdempotencyKey from (taskID, run ID, state) so R5 drops a replayed write
+	// but keeps a later run of the same task_id
+	b := bus.New()
+	mem := &countingMem{}
+	g := New(b, nil, mem, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go g.Run(ctx)
+	time.Sleep(20 * time.Millisecond) // let Run subscribe
+
+	runOnce := func(msgID string) string {
+		b.Publish(types.Message{ID: msgID, Type: types.MsgTaskSpec, From: types.RolePerceiver, To: types.RolePlanner,
+			Payload: types.TaskSpec{TaskID: "count_go_files", Intent: "count Go files"}})
+		deadline := time.Now().Add(time.Second)
+		for g.runID("count_go_files") != msgID && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		g.writeTerminalMegram("count_go_files", "count Go files", "42", "accept")
+		mem.mu.Lock()
+		defer mem.mu.Unlock()
+		return mem.megs[len(mem.megs)-1].IdempotencyKey
+	}
+	first := runOnce("spec-1")
+	if again := TerminalIdempotencyKey("count_go_files", "spec-1", "accept"); first != again {
+		t.Errorf("replayed write in the same run: key %q, want %q", again, first)
+	}
+	if second := runOnce("spec-2"); second == first {
+		t.Errorf("a second run of the same task_id reused the key %q", first)
+	}
+}
+
+func TestRunID_IsBounded(t *testing.T) {
+	// Keeps at most maxRunIDs run IDs, evicting the least recently started run first
+	g := New(nil, nil, nil, nil)
+	for i := 0; i <= maxRunIDs; i++ {
+		g.runID(fmt.Sprintf("task-%d", i))
+	}
+	g.mu.Lock()
+	g.setRunIDLocked("task-1", "spec-again") // a new run of task-1 makes it the newest
+	g.setRunIDLocked("task-extra", "spec-extra")
+	defer g.mu.Unlock()
+	if len(g.runIDs) != maxRunIDs || len(g.runOrder) != maxRunIDs {
+		t.Errorf("run IDs = %d (order %d), want %d", len(g.runIDs), len(g.runOrder), maxRunIDs)
+	}
+	if _, ok := g.runIDs["task-0"]; ok {
+		t.Error("oldest run ID should be evicted")
+	}
+	if _, ok := g.runIDs["task-2"]; ok {
+		t.Error("task-2 is now the least recently started and should be evicted")
+	}
+	if g.runIDs["task-1"] != "spec-again" {
+		t.Errorf("task-1 run ID = %q, want the restarted run's", g.runIDs["task-1"])
+	}
+}
+
+func TestWriteTerminalMegram_SetsCostOnSuccess(t *testing.T) {
+	// Sets Cost from the open task log on accept and success, so R2 can prefer cheaper approaches
+	reg := tasklog.NewRegistry(t.TempDir())
+	tl := reg.Open("t1", "find audio")
+	tl.LLMCall("planner", "sys", "user", "plan", 1200, 300, 40, 0)
+	tl.ToolCall("s1", "shell", "ls", "a.mp3", "", "", 5)
+	mem := &countingMem{}
+	g := New(nil, nil, mem, reg)
+	g.writeTerminalMegram("t1", "find audio", "done", "accept")
+	g.writeTerminalMegram("t1", "find audio", "gave up", "abandon")
+	c := mem.megs[0].Cost
+	if c == nil || c.TotalTokens != 1500 || c.ToolCallCount != 1 || len(c.ByRole) != 1 || c.ByRole[0].Role != "planner" {
+		t.Errorf("accept cost = %+v, want 1500 tokens, 1 tool call, planner by role", c)
+	}
+	if mem.megs[1].Cost != nil {
+		t.Errorf("abandon cost = %+v, want nil", mem.megs[1].Cost)
+	}
+}
+
+// failedShellOutcomes returns one failed outcome with n distinct failed shell calls.
+func failedShellOutcomes(n int) []types.SubTaskOutcome {
+	o := types.SubTaskOutcome{SubTaskID: "s1", Intent: "read the config", Status: "failed"}
+	for i := range n {
+		o.ToolCalls = append(o.ToolCalls, fmt.Sprintf(`shell: {"command":"cat conf%d.yaml"} → No such file or directory`, i))
+	}
+	return []types.SubTaskOutcome{o}
+}
+
+// routineWrites runs rounds refine rounds of 4 failed calls each with the given
+// sample rate and returns how many Megrams were written.
+func routineWrites(t *testing.T, sample string, rounds int) int {
+	t.Helper()
+	t.Setenv("ARTOO_MEGRAM_SAMPLE", sample)
+	mem := &countingMem{}
+	g := New(nil, nil, mem, nil)
+	for range rounds {
+		g.writeMegramsFromToolCalls("t1", failedShellOutcomes(4), "refine", true)
+	}
+	return mem.writes
+}
+
+func TestWriteMegramsFromToolCalls_SamplingWritesFewerRoutineMegrams(t *testing.T) {
+	// Writes every Megram when unsampled; only those keepRoutineMegram keeps when routine
+	all := routineWrites(t, "", 3)
+	sampled := routineWrites(t, "0.25", 3)
+	if all != 12 {
+		t.Fatalf("unsampled routine writes = %d, want 12", all)
+	}
+	if sampled != 3 {
+		t.Errorf("sampled routine writes = %d, want 3 (a quarter of 12)", sampled)
+	}
+}
+
+func TestWriteMegramsFromToolCalls_SignificantRoundIgnoresSampling(t *testing.T) {
+	// A round that changes the directive is not routine: every Megram is written
+	t.Setenv("ARTOO_MEGRAM_SAMPLE", "0")
+	mem := &countingMem{}
+	g := New(nil, nil, mem, nil)
+	g.writeMegramsFromToolCalls("t1", failedShellOutcomes(4), "change_path", false)
+	g.writeMegramsFromToolCalls("t1", failedShellOutcomes(4), "refine", true)
+	if mem.writes != 4 {
+		t.Errorf("writes = %d, want 4 (the significant round only)", mem.writes)
+	}
+}
+
+func TestMegramSampleFromEnv(t *testing.T) {
+	// Returns 1 when unset or unparseable; clamps values outside [0, 1]
+	for in, want := range map[string]float64{"": 1, "half": 1, "0.3": 0.3, "-2": 0, "7": 1} {
+		t.Setenv("ARTOO_MEGRAM_SAMPLE", in)
+		if got := megramSampleFromEnv(); got != want {
+			t.Errorf("ARTOO_MEGRAM_SAMPLE=%q: got %v, want %v", in, got, want)
+		}
+	}
+}
+
+// ── criterion verdict Megrams ────────────────────────────────────────────────
+
+func TestWriteCriterionMegrams_RecordsEachVerdictOnce(t *testing.T) {
+	// Writes one Megram per CriteriaVerdict, deduplicated by criterion; sigma follows pass/fail
+	mem := &countingMem{}
+	g := New(nil, nil, mem, nil)
+	g.writeCriterionMegrams("t1", []types.SubTaskOutcome{
+		{Status: "matched", CriteriaVerdicts: []types.CriteriaVerdict{
+			{Criterion: "output contains a valid file path", Verdict: "pass", Evidence: "/tmp/a.txt"},
+		}},
+		{Status: "failed", CriteriaVerdicts: []types.CriteriaVerdict{
+			{Criterion: "output names the owner", Verdict: "fail", FailureClass: "logical", Evidence: "no owner"},
+			{Criterion: "output contains a valid file path", Verdict: "pass", Evidence: "/tmp/b.txt"},
+		}},
+	}, "abandon")
+	if len(mem.megs) != 2 {
+		t.Fatalf("expected 2 Megrams, got %+v", mem.megs)
+	}
+	pass, fail := mem.megs[0], mem.megs[1]
+	if space, _ := memory.CriterionTags("output contains a valid file path"); pass.Space != space {
+		t.Errorf("space = %q, want %q", pass.Space, space)
+	}
+	if pass.Sigma <= 0 || pass.Content != `pass: "output contains a valid file path" — evidence: /tmp/a.txt` {
+		t.Errorf("pass megram = %+v", pass)
+	}
+	if fail.Sigma >= 0 || !strings.HasPrefix(fail.Content, `fail (logical): "output names the owner"`) {
+		t.Errorf("fail megram = %+v", fail)
+	}
+}
+
+func TestWriteCriterionMegrams_TruncatesEvidenceByRunes(t *testing.T) {
+	// Quotes at most maxCriterionEvidence runes of evidence, never splitting a multi-byte rune
+	mem := &countingMem{}
+	g := New(nil, nil, mem, nil)
+	g.writeCriterionMegrams("t1", []types.SubTaskOutcome{
+		{Status: "matched", CriteriaVerdicts: []types.CriteriaVerdict{
+			{Criterion: "输出包含文件路径", Verdict: "pass", Evidence: strings.Repeat("路径", maxCriterionEvidence)},
+		}},
+	}, "accept")
+	if len(mem.megs) != 1 {
+		t.Fatalf("expected 1 Megram, got %+v", mem.megs)
+	}
+	_, ev, _ := strings.Cut(mem.megs[0].Content, " — evidence: ")
+	if !utf8.ValidString(ev) || utf8.RuneCountInString(ev) != maxCriterionEvidence+1 || !strings.HasSuffix(ev, "…") {
+		t.Errorf("evidence = %q (%d runes), want %d valid runes plus an ellipsis", ev, utf8.RuneCountInString(ev), maxCriterionEvidence)
+	}
+}
+
+// ── Hyperparams ──────────────────────────────────────────────────────────────
+
+func TestLoadHyperparams_DefaultsWhenUnset(t *testing.T) {
+	// Returns DefaultHyperparams when no GGS_* variable is set
+	for _, env := range []string{"GGS_ALPHA", "GGS_BETA", "GGS_LAMBDA", "GGS_W1", "GGS_W2", "GGS_EPSILON", "GGS_DELTA", "GGS_RHO", "GGS_ABANDON_OMEGA", "GGS_LAW2_KILL_THRESHOLD"} {
+		t.Setenv(env, "")
+	}
+	h, err := LoadHyperparams()
+	if err != nil || h != DefaultHyperparams() {
+		t.Errorf("LoadHyperparams() = %+v, %v; want defaults", h, err)
+	}
+}
+
+func TestLoadHyperparams_OverridesSetValues(t *testing.T) {
+	// Overrides only the variables that are set
+	t.Setenv("GGS_ABANDON_OMEGA", "0.5")
+	t.Setenv("GGS_LAMBDA", " 0.7 ")
+	t.Setenv("GGS_LAW2_KILL_THRESHOLD", " 3 ")
+	h, err := LoadHyperparams()
+	if err != nil {
+		t.Fatalf("LoadHyperparams: %v", err)
+	}
+	want := DefaultHyperparams()
+	want.AbandonOmega, want.Lambda, want.Law2Kill = 0.5, 0.7, 3
+	if h != want {
+		t.Errorf("got %+v, want %+v", h, want)
+	}
+}
+
+func TestLoadHyperparams_RejectsInsaneValues(t *testing.T) {
+	// Returns an error naming the variable when a value is not a number
+	// Returns an error when a value is outside [0, 1], when GGS_EPSILON or
+	// GGS_ABANDON_OMEGA is 0, or when GGS_W1 and GGS_W2 are both 0
+	// Returns an error when GGS_LAW2_KILL_THRESHOLD is not an integer in [1, maxReplansGGS]
+	cases := []struct{ env map[string]string }{
+		{map[string]string{"GGS_ALPHA": "heavy"}},
+		{map[string]string{"GGS_BETA": "-0.1"}},
+		{map[string]string{"GGS_DELTA": "1.5"}},
+		{map[string]string{"GGS_EPSILON": "0"}},
+		{map[string]string{"GGS_ABANDON_OMEGA": "0"}},
+		{map[string]string{"GGS_W1": "0", "GGS_W2": "0"}},
+		{map[string]string{"GGS_LAW2_KILL_THRESHOLD": "soon"}},
+		{map[string]string{"GGS_LAW2_KILL_THRESHOLD": "0"}},
+		{map[string]string{"GGS_LAW2_KILL_THRESHOLD": "9"}},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprint(c.env), func(t *testing.T) {
+			var name string
+			for k, v := range c.env {
+				t.Setenv(k, v)
+				name = k
+			}
+			_, err := LoadHyperparams()
+			if err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("err = %v, want an error naming %s", err, name)
+			}
+		})
+	}
+}
+
+func TestSetHyperparams_ChangesDirectiveSelection(t *testing.T) {
+	// SetHyperparams changes the weights and thresholds the instance decides with
+	g := New(nil, nil, nil, nil)
+	if got := g.hp.selectDirective(0, 0.5, 0.2, 0.6); got != "change_path" {
+		t.Fatalf("default directive at Ω=0.6 = %q, want change_path", got)
+	}
+	h := DefaultHyperparams()
+	h.AbandonOmega = 0.5
+	h.Lambda = 1
+	h.W1 = 1
+	g.SetHyperparams(h)
+	if got := g.hp.selectDirective(0, 0.5, 0.2, 0.6); got != "abandon" {
+		t.Errorf("directive at Ω=0.6 with GGS_ABANDON_OMEGA=0.5 = %q, want abandon", got)
+	}
+	if got := g.hp.computeOmega(maxReplansGGS, 0); got != 1 {
+		t.Errorf("Ω with W1=1 at full replans = %v, want 1", got)
+	}
+	if got := g.hp.computeLoss(0, 0, 1); got != 1 {
+		t.Errorf("L with Lambda=1 at Ω=1 = %v, want 1", got)
+	}
+}
+
+// ── Decision table (GGS_DECISION_TABLE) ──────────────────────────────────────
+
+// invertedPathRefineTable is DefaultDecisionTable with change_path and refine swapped.
+const invertedPathRefineTable = `{"rules": [
+	{"omega": "exhausted", "directive": "abandon"},
+	{"d": "near", "directive": "success"},
+	{"grad": "flat", "p": "high", "directive": "break_symmetry"},
+	{"p": "high", "directive": "change_approach"},
+	{"grad": "flat", "directive": "refine"},
+	{"omega": "*", "d": "*", "grad": "*", "p": "*", "directive": "change_path"}
+]}`
+
+func writeDecisionTable(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultDecisionTable_MatchesCascade(t *testing.T) {
+	// The built-in cascade as a table selects the same directive as selectDirective,
+	// including on the thresholds themselves
+	table := DefaultDecisionTable()
+	if err := table.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	g := New(nil, nil, nil, nil)
+	g.SetDecisionTable(&table)
+	for _, gradL := range []float64{-0.5, -epsilon, 0, epsilon / 2, epsilon, 0.5} {
+		for _, D := range []float64{0, delta, 0.5, 1} {
+			for _, P := range []float64{0, rho, 0.8} {
+				for _, Omega := range []float64{0, 0.5, abandonOmega, 1} {
+					want := defaultHP.selectDirective(gradL, D, P, Omega)
+					if got := g.selectDirective(gradL, D, P, Omega); got != want {
+						t.Errorf("(∇L=%v, D=%v, P=%v, Ω=%v): table %q, cascade %q", gradL, D, P, Omega, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestLoadDecisionTable_Validates(t *testing.T) {
+	// Returns an error naming the rule for an unknown region or directive, the first
+	// uncovered combination of regions, or an unknown field
+	for content, want := range map[string]string{
+		`{"rules":[{"p":"medium","directive":"refine"}]}`:                        `rule 1: p "medium"`,
+		`{"rules":[{"directive":"retry"}]}`:                                      `rule 1: directive "retry"`,
+		`{"rules":[{"grad":"flat","directive":"refine"}]}`:                       "no rule matches omega=within d=far grad=improving p=low",
+		`{"rules":[{"directive":"refine","when":"always"}]}`:                     `unknown field "when"`,
+		`{"rules":[{"d":"near","directive":"success"},{"directive":"abandon"}]}`: "",
+	} {
+		_, err := LoadDecisionTable(writeDecisionTable(t, content))
+		if want == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", content, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want one containing %q", content, err, want)
+		}
+	}
+}
+
+func TestSetDecisionTable_GGSFollowsCustomTable(t *testing.T) {
+	// A table inverting change_path and refine makes a stuck environmental round refine
+	// and a signalling one change path; nil restores the cascade
+	table, err := LoadDecisionTable(writeDecisionTable(t, invertedPathRefineTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := New(nil, nil, nil, nil)
+	g.SetDecisionTable(table)
+	if got := g.selectDirective(0, 0.5, 0.2, 0.2); got != "refine" {
+		t.Errorf("plateau, low P = %q, want refine", got)
+	}
+	if got := g.selectDirective(0.3, 0.5, 0.2, 0.2); got != "change_path" {
+		t.Errorf("signal, low P = %q, want change_path", got)
+	}
+	if got := g.selectDirective(0, 0.5, 0.8, 0.2); got != "break_symmetry" {
+		t.Errorf("plateau, high P = %q, want break_symmetry as before", got)
+	}
+	g.SetDecisionTable(nil)
+	if got := g.selectDirective(0, 0.5, 0.2, 0.2); got != "change_path" {
+		t.Errorf("after SetDecisionTable(nil) = %q, want the cascade's change_path", got)
+	}
+
+	// End to end: the first round of an environmental failure is a plateau with low P.
+	for _, tc := range []struct {
+		table *DecisionTable
+		want  string
+	}{{nil, "change_path"}, {table, "refine"}} {
+		b := bus.New()
+		tap := b.NewTap()
+		g := New(b, nil, nil, nil)
+		g.SetDecisionTable(tc.table)
+		reason := "connection timeout"
+		g.process(context.Background(), types.ReplanRequest{
+			TaskID: "env-task", Round: 1, GapSummary: "fetch failed", ElapsedMs: 1000,
+			Outcomes: []types.SubTaskOutcome{{
+				Status: "failed", FailureReason: &reason,
+				CriteriaVerdicts: []types.CriteriaVerdict{{Criterion: "c1", Verdict: "fail", FailureClass: "environmental"}},
+			}},
+		})
+		if pd := nextPlanDirective(t, tap); pd.Directive != tc.want {
+			t.Errorf("table %v: directive %q, want %q", tc.table != nil, pd.Directive, tc.want)
+		}
+	}
+}
@@ -4,20 +4,27 @@ package memory
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/util"
 
 	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/clock"
 	"github.com/haricheung/agentic-shell/internal/llm"
 	"github.com/haricheung/agentic-shell/internal/types"
 )
@@ -28,13 +35,22 @@ import (
 //	x|<space>|<entity>|<id> → nil                  (inverted index for tag scan)
 //	l|<level>|<id>       → nil                     (level scan for Dreamer)
 //	r|<id>               → RFC3339                 (last_recalled_at; only mutable key)
+//	s|<space>            → IntentStats JSON        (per-intent terminal outcome counts)
+//	k|<idempotency key>  → RFC3339                 (when a keyed Megram was first persisted)
 const (
 	prefixMegram = "m|"
 	prefixIdx    = "x|"
 	prefixLevel  = "l|"
 	prefixRecall = "r|"
+	prefixStats  = "s|"
+	prefixIdem   = "k|"
 )
 
+// idempotencyWindow is how long a persisted idempotency key suppresses repeats.
+// Task IDs are intent-derived and recur across sessions, so a key only has to
+// outlive the delivery races that replay one FinalResult, not the task itself.
+const idempotencyWindow = 10 * time.Minute
+
 // GGS quantization matrix: maps macro-state to (f, σ, k).
 // Decay constants: k=0.05 ≈ 14-day half-life; k=0.2 ≈ 3.5-day; k=0.5 ≈ 1.4-day.
 var quantizationMatrix = map[string]struct{ f, sigma, k float64 }{
@@ -47,6 +63,12 @@ var quantizationMatrix = map[string]struct{ f, sigma, k float64 }{
 	"refine":          {f: 0.10, sigma: +0.5, k: 0.50},
 }
 
+// Default Dreamer triggers (see DreamerSchedule).
+const (
+	defaultDreamerInterval = 5 * time.Minute
+	defaultDreamerSettle   = 50 * time.Millisecond
+)
+
 // Dreamer consolidation thresholds (upward flow).
 const (
 	lambdaAtt = 5.0 // M_attention threshold for C-level promotion
@@ -58,8 +80,74 @@ const (
 type Store struct {
 	b       *bus.Bus
 	db      *leveldb.DB
-	llm     *llm.Client       // used by Dreamer Phase 3 distillation; nil disables upward consolidation
-	writeCh chan types.Megram // async write queue; buffered to avoid blocking GGS hot path
+	llm     *llm.Client             // used by Dreamer Phase 3 distillation; nil disables upward consolidation
+	writeCh chan types.Megram       // async write queue; buffered to avoid blocking GGS hot path
+	flushCh chan chan struct{}      // flush requests served by Run's writer loop, which closes the reply once the queue is persisted
+	running atomic.Bool             // Run's writer loop owns persistence; flushes go through flushCh
+	stopped chan struct{}           // closed when Run has drained the queue for the last time
+	quant   map[string]Quantization // effective (f, σ, k) per macro-state; defaults merged with overrides
+	decay   DecayFunc               // forgetting curve shared by every potential computation
+	clock   clock.Clock             // stamps CreatedAt/recall times and ages Megrams; clock.Wall unless SetClock
+	dream   DreamerSchedule         // when the Dreamer runs; DefaultDreamerSchedule unless SetDreamerSchedule
+	dirty   atomic.Bool             // a Megram was written through the queue since the last Dreamer cycle (true until the first)
+}
+
+// Quantization is one row of the GGS quantization matrix: the stimulus strength f,
+// valence σ, and decay constant k written into every Megram for a macro-state.
+type Quantization struct{ F, Sigma, K float64 }
+
+// Validate reports whether q lies in the legal ranges f∈[0,1], σ∈[-1,1], k≥0.
+func (q Quantization) Validate() error {
+	switch {
+	case math.IsNaN(q.F) || q.F < 0 || q.F > 1:
+		return fmt.Errorf("f=%v out of range [0,1]", q.F)
+	case math.IsNaN(q.Sigma) || q.Sigma < -1 || q.Sigma > 1:
+		return fmt.Errorf("sigma=%v out of range [-1,1]", q.Sigma)
+	case math.IsNaN(q.K) || q.K < 0:
+		return fmt.Errorf("k=%v must be >= 0", q.K)
+	}
+	return nil
+}
+
+// DecayFunc is a forgetting curve: the fraction of a Megram's stimulus f that
+// survives deltaDays after its decay origin, given its decay constant k.
+// QueryMK, gcPass, consolidation and SummaryVerbose all read potentials through
+// the store's DecayFunc, so they cannot disagree about what a Megram is worth.
+type DecayFunc interface {
+	Decay(k, deltaDays float64) float64
+}
+
+// ExponentialDecay is the default forgetting curve, exp(-k·Δt).
+type ExponentialDecay struct{}
+
+// Decay returns exp(-k·deltaDays).
+func (ExponentialDecay) Decay(k, deltaDays float64) float64 { return math.Exp(-k * deltaDays) }
+
+// PowerLawDecay is the forgetting curve (1+Δt)^(-k): it drops faster than
+// exponential decay over the first days and then flattens into a long tail.
+// k keeps its meaning as "larger forgets faster", but the default matrix was
+// tuned for exponential decay, so pair this with ARTOO_QUANTIZATION overrides.
+type PowerLawDecay struct{}
+
+// Decay returns (1+deltaDays)^(-k); negative ages count as zero.
+func (PowerLawDecay) Decay(k, deltaDays float64) float64 {
+	return math.Pow(1+math.Max(deltaDays, 0), -k)
+}
+
+// ParseDecay maps a decay model name to its DecayFunc.
+//
+// Expectations:
+//   - "" and "exponential" return ExponentialDecay
+//   - "power_law" (or "power-law", any case) returns PowerLawDecay
+//   - Any other name returns an error naming it
+func ParseDecay(name string) (DecayFunc, error) {
+	switch strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "-", "_") {
+	case "", "exponential":
+		return ExponentialDecay{}, nil
+	case "power_law":
+		return PowerLawDecay{}, nil
+	}
+	return nil, fmt.Errorf("unknown decay model %q (want exponential or power_law)", name)
 }
 
 // New opens (or creates) a LevelDB database at dbPath and returns a Store.
@@ -75,12 +163,137 @@ func New(b *bus.Bus, dbPath string, llmClient *llm.Client) *Store {
 		fmt.Fprintf(os.Stderr, "\033[2mAnother artoo process may be running (LevelDB is single-writer). Kill it and retry.\033[0m\n")
 		os.Exit(1)
 	}
-	return &Store{
+	s := &Store{
 		b:       b,
 		llm:     llmClient,
 		writeCh: make(chan types.Megram, 1024),
+		flushCh: make(chan chan struct{}),
+		stopped: make(chan struct{}),
 		db:      db,
+		quant:   QuantizationMatrix(),
+		decay:   ExponentialDecay{},
+		clock:   clock.Wall,
+		dream:   DefaultDreamerSchedule(),
+	}
+	s.dirty.Store(true)
+	return s
+}
+
+// SetDecay replaces the store's forgetting curve; nil restores ExponentialDecay.
+// Call it before Run — the Dreamer reads the curve from its own goroutine.
+func (s *Store) SetDecay(d DecayFunc) {
+	if d == nil {
+		d = ExponentialDecay{}
+	}
+	s.decay = d
+}
+
+// SetClock replaces the clock the store stamps and ages Megrams with; nil
+// restores clock.Wall. Call it before Run, as with SetDecay.
+func (s *Store) SetClock(c clock.Clock) {
+	if c == nil {
+		c = clock.Wall
+	}
+	s.clock = c
+}
+
+// DreamerSchedule sets when the Dreamer consolidates: every Interval, and Settle
+// after each FinalResult (debounced, so the task's Megrams land first). With
+// Adaptive set, a cycle is skipped when no Megram was stored since the last one;
+// decay-driven GC then waits for the next write.
+type DreamerSchedule struct {
+	Interval time.Duration
+	Settle   time.Duration
+	Adaptive bool
+}
+
+// DefaultDreamerSchedule returns the built-in schedule: every 5 minutes and 50 ms
+// after each FinalResult, never skipping a cycle.
+func DefaultDreamerSchedule() DreamerSchedule {
+	return DreamerSchedule{Interval: defaultDreamerInterval, Settle: defaultDreamerSettle}
+}
+
+// ParseDreamerSchedule builds a schedule from the ARTOO_DREAMER_INTERVAL and
+// ARTOO_DREAMER_SETTLE durations and the ARTOO_DREAMER_ADAPTIVE boolean.
+//
+// Expectations:
+//   - Returns DefaultDreamerSchedule when every value is empty
+//   - An empty value keeps that field's default
+//   - Returns an error naming the variable for an unparsable or non-positive duration, or an unparsable boolean
+func ParseDreamerSchedule(interval, settle, adaptive string) (DreamerSchedule, error) {
+	d := DefaultDreamerSchedule()
+	for _, f := range []struct {
+		name, raw string
+		dst       *time.Duration
+	}{
+		{"ARTOO_DREAMER_INTERVAL", interval, &d.Interval},
+		{"ARTOO_DREAMER_SETTLE", settle, &d.Settle},
+	} {
+		if raw := strings.TrimSpace(f.raw); raw != "" {
+			v, err := time.ParseDuration(raw)
+			if err != nil || v <= 0 {
+				return DreamerSchedule{}, fmt.Errorf("%s: %q is not a positive duration", f.name, f.raw)
+			}
+			*f.dst = v
+		}
+	}
+	if raw := strings.TrimSpace(adaptive); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return DreamerSchedule{}, fmt.Errorf("ARTOO_DREAMER_ADAPTIVE: %q is not a boolean", adaptive)
+		}
+		d.Adaptive = v
+	}
+	return d, nil
+}
+
+// SetDreamerSchedule replaces when the Dreamer runs; a non-positive Interval or
+// Settle keeps its default. Call it before Run, as with SetDecay.
+func (s *Store) SetDreamerSchedule(d DreamerSchedule) {
+	if d.Interval <= 0 {
+		d.Interval = defaultDreamerInterval
+	}
+	if d.Settle <= 0 {
+		d.Settle = defaultDreamerSettle
+	}
+	s.dream = d
+}
+
+// NewWithQuantization is New with per-state overrides of the quantization matrix,
+// for experimenting with memory dynamics without recompiling. Each override replaces
+// the whole (f, σ, k) row for its state; states not overridden keep their defaults.
+//
+// Expectations:
+//   - Returns an error (without opening the database) when any override is out of range
+//   - Returns an error naming the state when the override key is not a known macro-state
+//   - Store.QuantizationMatrix reflects the overrides merged over the defaults
+func NewWithQuantization(b *bus.Bus, dbPath string, llmClient *llm.Client, overrides map[string]Quantization) (*Store, error) {
+	quant := QuantizationMatrix()
+	for state, q := range overrides {
+		if _, ok := quant[state]; !ok {
+			return nil, fmt.Errorf("quantization override: unknown state %q", state)
+		}
+		if err := q.Validate(); err != nil {
+			return nil, fmt.Errorf("quantization override %q: %w", state, err)
+		}
+		quant[state] = q
+	}
+	s := New(b, dbPath, llmClient)
+	s.quant = quant
+	if len(overrides) > 0 {
+		slog.Info("[R5] quantization matrix overridden", "states", len(overrides))
 	}
+	return s, nil
+}
+
+// QuantizationMatrix returns a copy of the store's effective state → (f, σ, k) table.
+// GGS reads it on the write path so overrides passed to NewWithQuantization take effect.
+func (s *Store) QuantizationMatrix() map[string]Quantization {
+	out := make(map[string]Quantization, len(s.quant))
+	for state, q := range s.quant {
+		out[state] = q
+	}
+	return out
 }
 
 // Write enqueues a Megram for async non-blocking persistence.
@@ -96,7 +309,7 @@ func (s *Store) Write(m types.Megram) {
 		m.ID = uuid.New().String()
 	}
 	if m.CreatedAt == "" {
-		m.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+		m.CreatedAt = s.clock.Now().UTC().Format(time.RFC3339)
 	}
 	if m.Level == "" {
 		m.Level = "M"
@@ -135,7 +348,7 @@ func (s *Store) QueryC(ctx context.Context, space, entity string) ([]types.SOPRe
 			continue
 		}
 		// Update last_recalled_at to reset time decay for this entry.
-		_ = s.db.Put([]byte(prefixRecall+id), []byte(time.Now().UTC().Format(time.RFC3339)), nil)
+		_ = s.db.Put([]byte(prefixRecall+id), []byte(s.clock.Now().UTC().Format(time.RFC3339)), nil)
 		results = append(results, types.SOPRecord{
 			ID:      m.ID,
 			Space:   m.Space,
@@ -155,6 +368,7 @@ func (s *Store) QueryC(ctx context.Context, space, entity string) ([]types.SOPRe
 //   - M_attention = Σ|fᵢ|·exp(−kᵢ·Δt_days)
 //   - M_decision = Σσᵢ·fᵢ·exp(−kᵢ·Δt_days)
 //   - Uses last_recalled_at as decay origin when it is later than created_at
+//   - Measures Δt_days on the store's clock (see SetClock)
 //   - Action is derived via the action decision plane thresholds
 //   - Returns error only on LevelDB iteration failure
 func (s *Store) QueryMK(ctx context.Context, space, entity string) (types.Potentials, error) {
@@ -162,7 +376,7 @@ func (s *Store) QueryMK(ctx context.Context, space, entity string) (types.Potent
 	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
 	defer iter.Release()
 
-	now := time.Now().UTC()
+	now := s.clock.Now().UTC()
 	var attention, decision float64
 
 	for iter.Next() {
@@ -174,25 +388,12 @@ func (s *Store) QueryMK(ctx context.Context, space, entity string) (types.Potent
 		if err != nil {
 			continue
 		}
-
-		createdAt, err := time.Parse(time.RFC3339, m.CreatedAt)
-		if err != nil {
+		a, d, ok := s.potentialTerms(id, m, now)
+		if !ok {
 			continue
 		}
-		// Use last_recalled_at as decay origin when available (recall resets clock).
-		decayOrigin := createdAt
-		if recallBytes, err := s.db.Get([]byte(prefixRecall+id), nil); err == nil {
-			if recalled, err := time.Parse(time.RFC3339, string(recallBytes)); err == nil {
-				if recalled.After(decayOrigin) {
-					decayOrigin = recalled
-				}
-			}
-		}
-
-		deltaDays := now.Sub(decayOrigin).Hours() / 24.0
-		decay := math.Exp(-m.K * deltaDays)
-		attention += math.Abs(m.F) * decay
-		decision += m.Sigma * m.F * decay
+		attention += a
+		decision += d
 	}
 
 	if err := iter.Error(); err != nil {
@@ -205,6 +406,29 @@ func (s *Store) QueryMK(ctx context.Context, space, entity string) (types.Potent
 	}, nil
 }
 
+// potentialTerms returns one Megram's decayed contributions to the attention
+// and decision potentials at now; ok is false when its CreatedAt is unparseable.
+// Uses last_recalled_at as the decay origin when it is later than created_at.
+func (s *Store) potentialTerms(id string, m types.Megram, now time.Time) (attention, decision float64, ok bool) {
+	createdAt, err := time.Parse(time.RFC3339, m.CreatedAt)
+	if err != nil {
+		return 0, 0, false
+	}
+	// Use last_recalled_at as decay origin when available (recall resets clock).
+	decayOrigin := createdAt
+	if recallBytes, err := s.db.Get([]byte(prefixRecall+id), nil); err == nil {
+		if recalled, err := time.Parse(time.RFC3339, string(recallBytes)); err == nil {
+			if recalled.After(decayOrigin) {
+				decayOrigin = recalled
+			}
+		}
+	}
+
+	deltaDays := now.Sub(decayOrigin).Hours() / 24.0
+	decay := s.decay.Decay(m.K, deltaDays)
+	return math.Abs(m.F) * decay, m.Sigma * m.F * decay, true
+}
+
 // QueryRecent returns up to n most recent M/K-level Megrams for the given (space, entity)
 // pair, sorted newest-first by CreatedAt. Skips consolidated entries.
 // Used by Planner to inject concrete past experience directly into R2's prompt without
@@ -252,6 +476,70 @@ func (s *Store) QueryRecent(_ context.Context, space, entity string, n int) ([]t
 	return results, nil
 }
 
+// QueryCalibration returns the C-level SOPs, live potentials, and up to n recent
+// M/K-level Megrams for the (space, entity) pair in one pass over the index —
+// the same results as QueryC, QueryMK, and QueryRecent called in that order.
+// R2 uses it to calibrate a plan with a single round-trip per tag pair.
+//
+// Expectations:
+//   - SOPs match QueryC: C-level only, and last_recalled_at is updated for each
+//   - Potentials match QueryMK run after QueryC (returned SOPs decay from now)
+//   - Recent matches QueryRecent: M/K only, no consolidated entries, newest-first, at most n
+//   - Returns an empty bundle with Action="Ignore" when no megrams match
+//   - Returns error only on LevelDB iteration failure
+func (s *Store) QueryCalibration(_ context.Context, space, entity string, n int) (types.CalibrationBundle, error) {
+	prefix := idxPrefix(space, entity)
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	now := s.clock.Now().UTC()
+	var b types.CalibrationBundle
+	var attention, decision float64
+	for iter.Next() {
+		id := megIDFromIdxKey(string(iter.Key()), prefix)
+		if id == "" {
+			continue
+		}
+		m, err := s.fetchMegram(id)
+		if err != nil {
+			continue
+		}
+		switch {
+		case m.Level == "C":
+			// Stamp the recall before summing, as QueryC does before QueryMK.
+			_ = s.db.Put([]byte(prefixRecall+id), []byte(now.Format(time.RFC3339)), nil)
+			b.SOPs = append(b.SOPs, types.SOPRecord{
+				ID:      m.ID,
+				Space:   m.Space,
+				Entity:  m.Entity,
+				Content: m.Content,
+				Sigma:   m.Sigma,
+			})
+		case (m.Level == "M" || m.Level == "K") && m.State != "consolidated":
+			b.Recent = append(b.Recent, m)
+		}
+		if a, d, ok := s.potentialTerms(id, m, now); ok {
+			attention += a
+			decision += d
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return types.CalibrationBundle{}, err
+	}
+	sort.Slice(b.Recent, func(i, j int) bool {
+		return b.Recent[i].CreatedAt > b.Recent[j].CreatedAt
+	})
+	if len(b.Recent) > n {
+		b.Recent = b.Recent[:n]
+	}
+	b.Potentials = types.Potentials{
+		Attention: attention,
+		Decision:  decision,
+		Action:    deriveAction(attention, decision),
+	}
+	return b, nil
+}
+
 // RecordNegativeFeedback appends a negative-σ Megram that mathematically cancels
 // a stale positive potential. This implements the "Soft Overwrite" from Module 4.
 //
@@ -268,7 +556,7 @@ func (s *Store) RecordNegativeFeedback(_ context.Context, ruleID, content string
 	s.Write(types.Megram{
 		ID:        uuid.New().String(),
 		Level:     orig.Level,
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		CreatedAt: s.clock.Now().UTC().Format(time.RFC3339),
 		Space:     orig.Space,
 		Entity:    orig.Entity,
 		Content:   content,
@@ -286,18 +574,23 @@ func (s *Store) Close() {}
 // Run processes the async write queue and runs the Dreamer in the background.
 // Drains all pending writes and closes the DB when ctx is cancelled.
 func (s *Store) Run(ctx context.Context) {
+	s.running.Store(true)
 	go s.dreamer(ctx)
 
 	for {
 		select {
 		case <-ctx.Done():
 			s.drainWriteQueue()
+			close(s.stopped)
 			if err := s.db.Close(); err != nil {
 				slog.Warn("[R5] DB close error", "error", err)
 			}
 			return
 		case m := <-s.writeCh:
 			s.persistMegram(m)
+		case done := <-s.flushCh:
+			s.drainWriteQueue()
+			close(done)
 		}
 	}
 }
@@ -306,22 +599,129 @@ func (s *Store) Run(ctx context.Context) {
 // Internal — write path
 // ---------------------------------------------------------------------------
 
+// persistMegram writes m and its index keys in one batch.
+//
+// Expectations:
+//   - Skips m when a Megram with the same IdempotencyKey was persisted within idempotencyWindow
+//   - A skipped duplicate does not count towards the intent statistic
+//   - Megrams without an IdempotencyKey are always written
+//   - A written Megram marks the store dirty for the next Dreamer cycle
 func (s *Store) persistMegram(m types.Megram) {
+	if s.seenIdempotencyKey(m.IdempotencyKey) {
+		slog.Info("[R5] duplicate Megram skipped", "key", m.IdempotencyKey, "id", m.ID)
+		return
+	}
+	if s.storeMegram(m) == nil { // errors logged
+		s.dirty.Store(true)
+	}
+}
+
+// storeMegram writes m, its index keys, its idempotency key, and the updated
+// intent statistic in one batch. Errors are logged and returned.
+func (s *Store) storeMegram(m types.Megram) error {
 	data, err := json.Marshal(m)
 	if err != nil {
 		slog.Error("[R5] marshal megram failed", "id", m.ID, "error", err)
-		return
+		return err
 	}
 	batch := new(leveldb.Batch)
 	batch.Put([]byte(prefixMegram+m.ID), data)
 	batch.Put([]byte(idxKey(m.Space, m.Entity, m.ID)), nil)
 	batch.Put([]byte(levelKey(m.Level, m.ID)), nil)
+	if m.IdempotencyKey != "" {
+		batch.Put([]byte(prefixIdem+m.IdempotencyKey), []byte(s.clock.Now().UTC().Format(time.RFC3339)))
+	}
+	if stats, ok := s.bumpIntentStats(m); ok {
+		if data, err := json.Marshal(stats); err == nil {
+			batch.Put([]byte(prefixStats+safeKeyPart(m.Space)), data)
+		}
+	}
 
 	if err := s.db.Write(batch, nil); err != nil {
 		slog.Error("[R5] persist megram failed", "id", m.ID, "error", err)
-		return
+		return err
 	}
 	slog.Info("[R5] persisted Megram", "id", m.ID, "level", m.Level, "state", m.State, "space", m.Space, "entity", m.Entity)
+	return nil
+}
+
+// seenIdempotencyKey reports whether key was persisted within idempotencyWindow.
+// An empty key, or a stored timestamp that cannot be parsed, is never a duplicate.
+func (s *Store) seenIdempotencyKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	data, err := s.db.Get([]byte(prefixIdem+key), nil)
+	if err != nil {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339, string(data))
+	return err == nil && s.clock.Now().Sub(t) < idempotencyWindow
+}
+
+// bumpIntentStats returns the intent statistic for m's space updated with m's
+// terminal state. Only persistMegram calls it, from the single writer goroutine,
+// so the read-modify-write needs no lock.
+//
+// Expectations:
+//   - Returns ok=false unless m is an M-level Megram in an "intent:" space
+//   - Returns ok=false for non-terminal states (refine, change_path, ...)
+//   - accept and success increment Accepts; abandon increments Abandons
+//   - Starts from the persisted counts, or zero when none exist
+func (s *Store) bumpIntentStats(m types.Megram) (types.IntentStats, bool) {
+	if m.Level != "M" || !strings.HasPrefix(m.Space, "intent:") {
+		return types.IntentStats{}, false
+	}
+	st, _ := s.IntentStats(m.Space)
+	switch m.State {
+	case "accept", "success":
+		st.Accepts++
+	case "abandon":
+		st.Abandons++
+	default:
+		return types.IntentStats{}, false
+	}
+	st.Space = m.Space
+	st.UpdatedAt = m.CreatedAt
+	return st, true
+}
+
+// IntentStats returns the persisted accept/abandon counts for an intent space
+// ("intent:<taskID>", the space GGS writes terminal Megrams under).
+//
+// Expectations:
+//   - Returns zero counts and no error when the space has no recorded outcomes
+//   - Reflects every terminal Megram persisted for the space
+func (s *Store) IntentStats(space string) (types.IntentStats, error) {
+	data, err := s.db.Get([]byte(prefixStats+safeKeyPart(space)), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return types.IntentStats{Space: space}, nil
+	}
+	if err != nil {
+		return types.IntentStats{Space: space}, err
+	}
+	var st types.IntentStats
+	if err := json.Unmarshal(data, &st); err != nil {
+		return types.IntentStats{Space: space}, err
+	}
+	return st, nil
+}
+
+// flush persists every queued write before returning. Once Run is active the
+// writer loop does the work, so no Megram is persisted twice or concurrently
+// and one it has already dequeued is on disk before flush returns; before Run
+// starts the queue is drained inline.
+func (s *Store) flush() {
+	if !s.running.Load() {
+		s.drainWriteQueue()
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case s.flushCh <- done:
+		<-done
+	case <-s.stopped:
+	}
 }
 
 func (s *Store) drainWriteQueue() {
@@ -340,11 +740,11 @@ func (s *Store) drainWriteQueue() {
 // ---------------------------------------------------------------------------
 
 // dreamer runs the Dreamer consolidation/GC engine.
-// Triggers: (a) 5-minute periodic timer, (b) 50 ms after each FinalResult
-// (debounced) so Megrams from GGS flush before GC/trust-bankruptcy runs,
-// (c) one final cycle on context cancellation (handles one-shot mode exit).
+// Triggers (see DreamerSchedule): (a) a periodic timer, (b) a settle delay after
+// each FinalResult (debounced) so Megrams from GGS flush before GC/trust-bankruptcy
+// runs, (c) one final cycle on context cancellation (handles one-shot mode exit).
 func (s *Store) dreamer(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Minute)
+	ticker := time.NewTicker(s.dream.Interval)
 	defer ticker.Stop()
 	finalResultCh := s.b.Subscribe(types.MsgFinalResult)
 	var settleC <-chan time.Time
@@ -357,11 +757,13 @@ func (s *Store) dreamer(ctx context.Context) {
 			s.runDreamer("timer")
 		case _, ok := <-finalResultCh:
 			if !ok {
-				return
+				// The bus closed; keep running until ctx ends so shutdown still consolidates.
+				finalResultCh = nil
+				continue
 			}
 			// Short settle so GGS Megram write (async) lands before consolidation.
 			// Debounced: re-arming on rapid successive FinalResults is intentional.
-			settleC = time.After(50 * time.Millisecond)
+			settleC = time.After(s.dream.Settle)
 		case <-settleC:
 			settleC = nil
 			s.runDreamer("post-task")
@@ -369,7 +771,18 @@ func (s *Store) dreamer(ctx context.Context) {
 	}
 }
 
-func (s *Store) runDreamer(trigger string) {
+// runDreamer runs one consolidation cycle and reports whether it ran.
+//
+// Expectations:
+//   - Always runs the first cycle after New
+//   - In adaptive mode, skips the cycle when no Megram was stored since the last one;
+//     the cycle's own writes (promoted SOPs) do not count
+//   - Runs every cycle when the schedule is not adaptive
+func (s *Store) runDreamer(trigger string) bool {
+	if wrote := s.dirty.Swap(false); s.dream.Adaptive && !wrote {
+		slog.Debug("[R5/Dreamer] consolidation cycle skipped: no writes since the last one", "trigger", trigger)
+		return false
+	}
 	start := time.Now()
 	slog.Info("[R5/Dreamer] consolidation cycle starting", "trigger", trigger)
 	gcScanned, gcDeleted := s.gcPass()
@@ -384,6 +797,7 @@ func (s *Store) runDreamer(trigger string) {
 		"gc_scanned", gcScanned, "gc_deleted", gcDeleted,
 		"trust_scanned", tbScanned, "trust_demoted", tbDemoted,
 		"up_promoted", upPromoted)
+	return true
 }
 
 // gcPass scans M and K-level Megrams and hard-deletes those with M_attention < 0.1.
@@ -394,7 +808,7 @@ func (s *Store) runDreamer(trigger string) {
 //   - Does not delete C or T level megrams
 //   - Removes all four index entries (primary, inverted, level, recall) on delete
 func (s *Store) gcPass() (scanned, deleted int) {
-	now := time.Now().UTC()
+	now := s.clock.Now().UTC()
 	for _, lvl := range []string{"M", "K"} {
 		prefix := prefixLevel + lvl + "|"
 		iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
@@ -411,7 +825,7 @@ func (s *Store) gcPass() (scanned, deleted int) {
 				continue
 			}
 			deltaDays := now.Sub(createdAt).Hours() / 24.0
-			decay := math.Exp(-m.K * deltaDays)
+			decay := s.decay.Decay(m.K, deltaDays)
 			if math.Abs(m.F)*decay < 0.1 {
 				toDelete = append(toDelete, id)
 			}
@@ -493,6 +907,7 @@ func (s *Store) trustBankruptcyPass() (scanned, demoted int) {
 //   - Groups by (space, entity); computes live dual-channel potentials for each group
 //   - Promotes at most one new C-level Megram per (space, entity) group per cycle
 //   - Marks all source Megrams in a promoted group as State="consolidated"
+//   - Stores the SOP without marking the store dirty, so it alone never triggers another cycle
 //   - Returns count of groups promoted this cycle
 func (s *Store) consolidationPass(ctx context.Context) int {
 	if s.llm == nil {
@@ -507,7 +922,7 @@ func (s *Store) consolidationPass(ctx context.Context) int {
 	}
 	groups := make(map[groupKey][]groupEntry)
 
-	now := time.Now().UTC()
+	now := s.clock.Now().UTC()
 	for _, lvl := range []string{"M", "K"} {
 		prefix := prefixLevel + lvl + "|"
 		iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
@@ -533,7 +948,7 @@ func (s *Store) consolidationPass(ctx context.Context) int {
 				}
 			}
 			deltaDays := now.Sub(decayOrigin).Hours() / 24.0
-			decay := math.Exp(-m.K * deltaDays)
+			decay := s.decay.Decay(m.K, deltaDays)
 			att := math.Abs(m.F) * decay
 			dec := m.Sigma * m.F * decay
 			k := groupKey{m.Space, m.Entity}
@@ -583,7 +998,7 @@ func (s *Store) consolidationPass(ctx context.Context) int {
 		sopMeg := types.Megram{
 			ID:        uuid.New().String(),
 			Level:     "C",
-			CreatedAt: time.Now().UTC().Format(time.RFC3339),
+			CreatedAt: s.clock.Now().UTC().Format(time.RFC3339),
 			Space:     k.space,
 			Entity:    k.entity,
 			Content:   rule,
@@ -592,7 +1007,11 @@ func (s *Store) consolidationPass(ctx context.Context) int {
 			Sigma:     sigma,
 			K:         0.0,
 		}
-		s.persistMegram(sopMeg)
+		// storeMegram, not persistMegram: the Dreamer's own output is no new
+		// experience, so it does not make the next adaptive cycle run.
+		if s.storeMegram(sopMeg) != nil {
+			continue
+		}
 		slog.Info("[R5/Dreamer] promoted C-level SOP",
 			"space", k.space, "entity", k.entity, "signal", signal,
 			"att", totalAtt, "dec", totalDec, "rule", rule)
@@ -706,6 +1125,162 @@ func (s *Store) DeleteAll() int {
 	return deleted
 }
 
+// Clear wipes the store: every m|, x|, l|, r|, and s| key is removed in a single
+// batch, including orphaned index keys that no longer point at a Megram.
+// Returns the number of Megrams removed. Used by /memory clear.
+//
+// Expectations:
+//   - Returns 0 and no error on an empty store
+//   - Returns the number of Megrams that existed before the call
+//   - Summary reports zero counts at every level afterwards
+//   - QueryMK returns Ignore for any previously populated tag pair afterwards
+func (s *Store) Clear() (int, error) {
+	// Flush queued writes first so they are wiped too rather than landing after the reset.
+	s.flush()
+
+	batch := new(leveldb.Batch)
+	megrams := 0
+	for _, prefix := range []string{prefixMegram, prefixIdx, prefixLevel, prefixRecall, prefixStats, prefixIdem} {
+		iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+		for iter.Next() {
+			// iter.Key() is only valid until the next call — copy before batching.
+			batch.Delete(append([]byte(nil), iter.Key()...))
+			if prefix == prefixMegram {
+				megrams++
+			}
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return 0, fmt.Errorf("memory: clear scan %q: %w", prefix, err)
+		}
+	}
+	if err := s.db.Write(batch, nil); err != nil {
+		return 0, fmt.Errorf("memory: clear: %w", err)
+	}
+	slog.Info("[R5] memory cleared", "megrams", megrams, "keys", batch.Len())
+	return megrams, nil
+}
+
+// Forget deletes every Megram tagged with exactly (space, entity), for
+// lessons about one topic that have gone stale. Returns the number deleted.
+//
+// Expectations:
+//   - Returns 0 and no error when no Megram carries the tag pair
+//   - Deletes all index keys (m|, x|, l|, r|) for each matched Megram
+//   - Leaves Megrams under any other space or entity untouched
+//   - Also deletes matching Megrams still in the write queue
+func (s *Store) Forget(space, entity string) (int, error) {
+	return s.forgetIdx(idxPrefix(space, entity))
+}
+
+// ForgetSpace deletes every Megram in space, whatever its entity. Used by
+// /memory forget <intent>. Returns the number deleted.
+//
+// Expectations:
+//   - Returns 0 and no error when space holds no Megrams
+//   - Deletes Megrams under every entity of space
+//   - Leaves Megrams in other spaces untouched, including ones whose name extends space
+//   - Resets the space's intent statistic
+func (s *Store) ForgetSpace(space string) (int, error) {
+	n, err := s.forgetIdx(prefixIdx + safeKeyPart(space) + "|")
+	if err == nil {
+		err = s.db.Delete([]byte(prefixStats+safeKeyPart(space)), nil)
+	}
+	return n, err
+}
+
+// Export returns every Megram tagged with (space, entity), oldest first; an
+// empty entity matches every entity of space. Used by --export-session.
+//
+// Expectations:
+//   - Includes Megrams still in the write queue
+//   - While Run is active, flushes through its writer loop rather than persisting concurrently
+//   - Returns an empty slice and no error when nothing carries the tag
+//   - Leaves Megrams in spaces whose name merely extends space out
+func (s *Store) Export(space, entity string) ([]types.Megram, error) {
+	// Flush queued writes first so a task's final Megrams are exported too.
+	s.flush()
+
+	prefix := prefixIdx + safeKeyPart(space) + "|"
+	if entity != "" {
+		prefix = idxPrefix(space, entity)
+	}
+	var ids []string
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	for iter.Next() {
+		key := string(iter.Key())
+		ids = append(ids, key[strings.LastIndex(key, "|")+1:])
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("memory: export scan %q: %w", prefix, err)
+	}
+	megs := []types.Megram{}
+	for _, id := range ids {
+		if m, err := s.fetchMegram(id); err == nil {
+			megs = append(megs, m)
+		}
+	}
+	sort.SliceStable(megs, func(i, j int) bool { return megs[i].CreatedAt < megs[j].CreatedAt })
+	return megs, nil
+}
+
+// Import stores megs as they are, keeping their IDs and timestamps, and returns
+// how many were added. Used by --import-session to rebuild an exported run's
+// memory in a fresh store; call it before Run.
+//
+// Expectations:
+//   - Skips Megrams whose ID is already stored, so importing twice adds nothing
+//   - Skips Megrams without an ID
+//   - Keeps Megrams that share an IdempotencyKey; they were distinct runs when exported
+//   - Imported Megrams are returned by QueryRecent and counted in IntentStats
+func (s *Store) Import(megs []types.Megram) (int, error) {
+	added := 0
+	for _, m := range megs {
+		if m.ID == "" {
+			continue
+		}
+		if ok, err := s.db.Has([]byte(prefixMegram+m.ID), nil); err != nil {
+			return added, fmt.Errorf("memory: import %s: %w", m.ID, err)
+		} else if ok {
+			continue
+		}
+		if err := s.storeMegram(m); err != nil {
+			return added, fmt.Errorf("memory: import %s: %w", m.ID, err)
+		}
+		added++
+	}
+	return added, nil
+}
+
+// forgetIdx deletes every Megram with an inverted-index key under prefix.
+func (s *Store) forgetIdx(prefix string) (int, error) {
+	// Flush queued writes first so a pending Megram on the topic is forgotten too.
+	s.flush()
+
+	var ids []string
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	for iter.Next() {
+		key := string(iter.Key())
+		ids = append(ids, key[strings.LastIndex(key, "|")+1:])
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return 0, fmt.Errorf("memory: forget scan %q: %w", prefix, err)
+	}
+	deleted := 0
+	for _, id := range ids {
+		m, err := s.fetchMegram(id)
+		if err != nil {
+			continue
+		}
+		s.deleteMegram(id, m.Level)
+		deleted++
+	}
+	slog.Info("[R5] memory forgotten", "prefix", prefix, "megrams", deleted)
+	return deleted, nil
+}
+
 // fetchMegram retrieves a Megram by ID from LevelDB.
 func (s *Store) fetchMegram(id string) (types.Megram, error) {
 	data, err := s.db.Get([]byte(prefixMegram+id), nil)
@@ -783,6 +1358,54 @@ func IntentSlug(intent string) string {
 	return "intent:" + strings.Join(parts, "_")
 }
 
+// ToolPreferenceTags returns the (space, entity) tag pair under which GGS records
+// how a tool fared for a kind of subtask, and under which R3 looks it up:
+// space "tool:<name>", entity IntentSlug(intent).
+func ToolPreferenceTags(tool, intent string) (space, entity string) {
+	return "tool:" + tool, IntentSlug(intent)
+}
+
+// criterionSlugWords is how many words of a criterion make up its memory tag;
+// enough to tell "output contains a valid file path" from "output contains a date".
+const criterionSlugWords = 6
+
+// CriterionTags returns the (space, entity) tag pair under which GGS records
+// verdicts on a success criterion, and under which R4a looks them up: space
+// "criterion:<first words, lowercased, letters and digits>", entity "env:local".
+// Criteria worded alike share a tag. Letters of every script count, so a CJK
+// criterion — written without spaces, so one word — keeps its own tag; one with
+// no letters or digits at all is tagged by a hash of its text instead.
+//
+// Expectations:
+//   - Space starts with "criterion:" and uses at most criterionSlugWords words
+//   - Lowercases and strips everything but Unicode letters and digits from each word
+//   - Criteria differing only in case and punctuation share a tag
+//   - CJK-only criteria get distinct, non-empty tags
+//   - Falls back to "criterion:#<hash>" of the trimmed text when no letters or digits remain
+//   - Entity is always "env:local"
+func CriterionTags(criterion string) (space, entity string) {
+	var parts []string
+	for _, w := range strings.Fields(strings.ToLower(criterion)) {
+		var b strings.Builder
+		for _, r := range w {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				b.WriteRune(r)
+			}
+		}
+		if b.Len() > 0 {
+			parts = append(parts, b.String())
+		}
+		if len(parts) == criterionSlugWords {
+			break
+		}
+	}
+	if len(parts) == 0 {
+		sum := sha256.Sum256([]byte(strings.TrimSpace(criterion)))
+		return "criterion:#" + hex.EncodeToString(sum[:6]), "env:local"
+	}
+	return "criterion:" + strings.Join(parts, "_"), "env:local"
+}
+
 // ParseToolCall extracts the tool name and primary target value from a tool-call
 // string in the format produced by R3 Executor:
 //
@@ -895,7 +1518,7 @@ func (s *Store) Summary() types.MemorySummary {
 //   - Action is derived from the aggregate potentials using the decision plane
 func (s *Store) SummaryVerbose() types.MemorySummary {
 	base := s.Summary()
-	now := time.Now().UTC()
+	now := s.clock.Now().UTC()
 
 	groupMap := make(map[string]*types.MegRamGroup) // key: "level|space|entity"
 
@@ -918,7 +1541,7 @@ func (s *Store) SummaryVerbose() types.MemorySummary {
 			}
 		}
 		deltaDays := now.Sub(decayOrigin).Hours() / 24.0
-		decay := math.Exp(-m.K * deltaDays)
+		decay := s.decay.Decay(m.K, deltaDays)
 		att := math.Abs(m.F) * decay
 		dec := m.Sigma * m.F * decay
 
@@ -972,11 +1595,12 @@ func (s *Store) SummaryVerbose() types.MemorySummary {
 	return base
 }
 
-// QuantizationMatrix exports the GGS state → (f, σ, k) table for use by GGS write path.
-func QuantizationMatrix() map[string]struct{ F, Sigma, K float64 } {
-	out := make(map[string]struct{ F, Sigma, K float64 }, len(quantizationMatrix))
+// QuantizationMatrix exports the default GGS state → (f, σ, k) table.
+// Stores built with NewWithQuantization may differ; see Store.QuantizationMatrix.
+func QuantizationMatrix() map[string]Quantization {
+	out := make(map[string]Quantization, len(quantizationMatrix))
 	for state, q := range quantizationMatrix {
-		out[state] = struct{ F, Sigma, K float64 }{F: q.f, Sigma: q.sigma, K: q.k}
+		out[state] = Quantization{F: q.f, Sigma: q.sigma, K: q.k}
 	}
 	return out
 }
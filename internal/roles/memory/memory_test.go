@@ -3,12 +3,18 @@ package memory
 import (
 	"context"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/clock"
+	"github.com/haricheung/agentic-shell/internal/llm"
 	"github.com/haricheung/agentic-shell/internal/types"
 )
 
@@ -518,6 +524,111 @@ func TestQuantizationMatrix_ChangePathNeutralSigma(t *testing.T) {
 	}
 }
 
+// ---------------------------------------------------------------------------
+// NewWithQuantization tests
+// ---------------------------------------------------------------------------
+
+func newQuantStore(t *testing.T, overrides map[string]Quantization) (*Store, error) {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := NewWithQuantization(nil, dir, nil, overrides)
+	if s != nil {
+		t.Cleanup(func() { s.db.Close() })
+	}
+	return s, err
+}
+
+// refineMegramAged builds a refine Megram stamped with s's quantization row, created days ago.
+func refineMegramAged(s *Store, days int) types.Megram {
+	q := s.QuantizationMatrix()["refine"]
+	return types.Megram{
+		ID:        uuid.New().String(),
+		Level:     "M",
+		CreatedAt: time.Now().UTC().Add(-time.Duration(days) * 24 * time.Hour).Format(time.RFC3339),
+		Space:     "tool:shell",
+		Entity:    "target:make",
+		State:     "refine",
+		F:         q.F,
+		Sigma:     q.Sigma,
+		K:         q.K,
+	}
+}
+
+func TestNewWithQuantization_MergesOverDefaults(t *testing.T) {
+	// Store.QuantizationMatrix reflects the overrides merged over the defaults
+	s, err := newQuantStore(t, map[string]Quantization{"refine": {F: 0.4, Sigma: 0.5, K: 0.01}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	qm := s.QuantizationMatrix()
+	if qm["refine"] != (Quantization{F: 0.4, Sigma: 0.5, K: 0.01}) {
+		t.Errorf("refine: expected override, got %+v", qm["refine"])
+	}
+	if qm["abandon"] != QuantizationMatrix()["abandon"] {
+		t.Errorf("abandon: expected default, got %+v", qm["abandon"])
+	}
+	if len(qm) != len(QuantizationMatrix()) {
+		t.Errorf("expected %d states, got %d", len(QuantizationMatrix()), len(qm))
+	}
+}
+
+func TestNewWithQuantization_RejectsOutOfRange(t *testing.T) {
+	// Returns an error (without opening the database) when any override is out of range
+	for name, q := range map[string]Quantization{
+		"f>1":      {F: 1.5, Sigma: 0, K: 0.1},
+		"f<0":      {F: -0.1, Sigma: 0, K: 0.1},
+		"sigma>1":  {F: 0.5, Sigma: 1.1, K: 0.1},
+		"sigma<-1": {F: 0.5, Sigma: -2, K: 0.1},
+		"k<0":      {F: 0.5, Sigma: 0, K: -0.01},
+	} {
+		s, err := newQuantStore(t, map[string]Quantization{"refine": q})
+		if err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+		if s != nil {
+			t.Errorf("%s: expected nil store on error", name)
+		}
+	}
+}
+
+func TestNewWithQuantization_RejectsUnknownState(t *testing.T) {
+	// Returns an error naming the state when the override key is not a known macro-state
+	_, err := newQuantStore(t, map[string]Quantization{"retry": {F: 0.5, Sigma: 0, K: 0.1}})
+	if err == nil || !strings.Contains(err.Error(), `"retry"`) {
+		t.Errorf("expected error naming unknown state, got %v", err)
+	}
+}
+
+func TestGCPass_CustomRefineKShiftsGC(t *testing.T) {
+	// Deletes M/K megrams whose decayed attention potential falls below Λ_gc=0.1
+	// Same 5-day-old refine Megram (f=0.5) under two refine.k overrides:
+	// k=0.5 → att ≈ 0.5*exp(-2.5) ≈ 0.04 (deleted); k=0.01 → att ≈ 0.48 (kept).
+	const f = 0.5
+	fast, err := newQuantStore(t, map[string]Quantization{"refine": {F: f, Sigma: 0.5, K: 0.5}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	slow, err := newQuantStore(t, map[string]Quantization{"refine": {F: f, Sigma: 0.5, K: 0.01}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fastMeg := refineMegramAged(fast, 5)
+	slowMeg := refineMegramAged(slow, 5)
+	fast.persistMegram(fastMeg)
+	slow.persistMegram(slowMeg)
+
+	if _, deleted := fast.gcPass(); deleted != 1 {
+		t.Errorf("k=0.5: expected 5-day-old refine Megram to be GC'd, deleted=%d", deleted)
+	}
+	if _, deleted := slow.gcPass(); deleted != 0 {
+		t.Errorf("k=0.01: expected 5-day-old refine Megram to survive GC, deleted=%d", deleted)
+	}
+	if _, err := slow.db.Get([]byte(prefixMegram+slowMeg.ID), nil); err != nil {
+		t.Errorf("k=0.01: megram should still exist after GC: %v", err)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // QueryMK time-decay and multi-entry tests
 // ---------------------------------------------------------------------------
@@ -1141,6 +1252,100 @@ func TestQueryRecent_SkipsCLevelAndConsolidated(t *testing.T) {
 	}
 }
 
+// ---------------------------------------------------------------------------
+// QueryCalibration tests
+// ---------------------------------------------------------------------------
+
+func TestQueryCalibration_BundlesSOPsPotentialsAndRecent(t *testing.T) {
+	// SOPs match QueryC, Potentials match QueryMK run after QueryC, Recent matches QueryRecent
+	s := newTestStore(t)
+	defer s.db.Close()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	at := func(h int) string { return now.Add(-time.Duration(h) * time.Hour).Format(time.RFC3339) }
+
+	s.persistMegram(types.Megram{
+		ID: uuid.New().String(), Level: "C", CreatedAt: at(48),
+		Space: "intent:calib", Entity: "env:local", Content: "use find -name",
+		State: "Best Practice", F: 1.0, Sigma: 1.0, K: 0.0,
+	})
+	for i, content := range []string{"newest run", "middle run", "older run", "oldest run"} {
+		s.persistMegram(types.Megram{
+			ID: uuid.New().String(), Level: "M", CreatedAt: at(i + 1),
+			Space: "intent:calib", Entity: "env:local", Content: content,
+			State: "accept", F: 0.9, Sigma: 1.0, K: 0.0,
+		})
+	}
+	s.persistMegram(types.Megram{
+		ID: uuid.New().String(), Level: "M", CreatedAt: at(0),
+		Space: "intent:calib", Entity: "env:local", Content: "consolidated run",
+		State: "consolidated", F: 0.9, Sigma: 1.0, K: 0.0,
+	})
+	s.persistMegram(types.Megram{
+		ID: uuid.New().String(), Level: "C", CreatedAt: at(0),
+		Space: "intent:other", Entity: "env:local", Content: "other intent SOP",
+		State: "Best Practice", F: 1.0, Sigma: 1.0, K: 0.0,
+	})
+
+	b, err := s.QueryCalibration(ctx, "intent:calib", "env:local", 3)
+	if err != nil {
+		t.Fatalf("QueryCalibration failed: %v", err)
+	}
+	if len(b.SOPs) != 1 || b.SOPs[0].Content != "use find -name" || b.SOPs[0].Sigma != 1.0 {
+		t.Errorf("SOPs = %+v, want the one C-level SOP for the intent", b.SOPs)
+	}
+	if b.Potentials.Action != "Exploit" {
+		t.Errorf("Potentials = %+v, want Exploit", b.Potentials)
+	}
+	pots, err := s.QueryMK(ctx, "intent:calib", "env:local")
+	if err != nil {
+		t.Fatalf("QueryMK failed: %v", err)
+	}
+	if math.Abs(pots.Attention-b.Potentials.Attention) > 1e-9 || math.Abs(pots.Decision-b.Potentials.Decision) > 1e-9 {
+		t.Errorf("Potentials = %+v, QueryMK = %+v; want equal", b.Potentials, pots)
+	}
+	var got []string
+	for _, m := range b.Recent {
+		got = append(got, m.Content)
+	}
+	if want := []string{"newest run", "middle run", "older run"}; !slices.Equal(got, want) {
+		t.Errorf("Recent = %v, want %v", got, want)
+	}
+}
+
+func TestQueryCalibration_UpdatesLastRecalledAtForSOPs(t *testing.T) {
+	// SOPs match QueryC: last_recalled_at is updated for each
+	s := newTestStore(t)
+	defer s.db.Close()
+
+	id := uuid.New().String()
+	s.persistMegram(types.Megram{
+		ID: id, Level: "C", CreatedAt: "2026-01-01T00:00:00Z",
+		Space: "intent:calib_recall", Entity: "env:local", Content: "sop",
+		State: "Best Practice", F: 1.0, Sigma: 1.0, K: 0.05,
+	})
+	if _, err := s.QueryCalibration(context.Background(), "intent:calib_recall", "env:local", 3); err != nil {
+		t.Fatalf("QueryCalibration failed: %v", err)
+	}
+	if _, err := s.db.Get([]byte(prefixRecall+id), nil); err != nil {
+		t.Errorf("last_recalled_at not set for returned SOP: %v", err)
+	}
+}
+
+func TestQueryCalibration_EmptyForNoMatch(t *testing.T) {
+	// Returns an empty bundle with Action="Ignore" when no megrams match
+	s := newTestStore(t)
+	defer s.db.Close()
+
+	b, err := s.QueryCalibration(context.Background(), "intent:none", "env:local", 3)
+	if err != nil {
+		t.Fatalf("QueryCalibration failed: %v", err)
+	}
+	if len(b.SOPs) != 0 || len(b.Recent) != 0 || b.Potentials.Action != "Ignore" {
+		t.Errorf("bundle = %+v, want empty with Ignore", b)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // DeleteByPrefix tests
 // ---------------------------------------------------------------------------
@@ -1184,3 +1389,772 @@ func TestDeleteByPrefix_DeletesMatched(t *testing.T) {
 		t.Error("expected def456 to still exist")
 	}
 }
+
+// ---------------------------------------------------------------------------
+// Clear tests
+// ---------------------------------------------------------------------------
+
+func TestClear_EmptyStore(t *testing.T) {
+	// Returns 0 and no error on an empty store
+	s := newTestStore(t)
+	defer s.db.Close()
+	n, err := s.Clear()
+	if err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("expected 0 cleared, got %d", n)
+	}
+}
+
+func TestClear_ReturnsMegramCount(t *testing.T) {
+	// Returns the number of Megrams that existed before the call
+	s := newTestStore(t)
+	defer s.db.Close()
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, lvl := range []string{"M", "K", "C"} {
+		s.persistMegram(types.Megram{
+			ID: uuid.New().String(), Level: lvl, CreatedAt: now,
+			Space: "intent:test", Entity: "env:local", Content: "entry " + lvl,
+			State: "accept", F: 0.9, Sigma: 1.0, K: 0.05,
+		})
+	}
+	n, err := s.Clear()
+	if err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 cleared, got %d", n)
+	}
+}
+
+func TestClear_SummaryReportsZeroCounts(t *testing.T) {
+	// Summary reports zero counts at every level afterwards
+	s := newTestStore(t)
+	defer s.db.Close()
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, lvl := range []string{"M", "K", "C", "T"} {
+		s.persistMegram(types.Megram{
+			ID: uuid.New().String(), Level: lvl, CreatedAt: now,
+			Space: "global:user", Entity: "env:local", Content: "entry " + lvl,
+			State: "accept", F: 1.0, Sigma: 1.0, K: 0.0,
+		})
+	}
+	if _, err := s.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	sum := s.Summary()
+	for lvl, c := range sum.LevelCounts {
+		if c != 0 {
+			t.Errorf("expected 0 %s-level megrams after Clear, got %d", lvl, c)
+		}
+	}
+	if len(sum.CLevel) != 0 {
+		t.Errorf("expected no C-level entries after Clear, got %d", len(sum.CLevel))
+	}
+}
+
+func TestClear_QueryMKReturnsIgnore(t *testing.T) {
+	// QueryMK returns Ignore for any previously populated tag pair afterwards
+	s := newTestStore(t)
+	defer s.db.Close()
+	now := time.Now().UTC().Format(time.RFC3339)
+	for i := 0; i < 3; i++ {
+		s.persistMegram(types.Megram{
+			ID: uuid.New().String(), Level: "M", CreatedAt: now,
+			Space: "intent:test", Entity: "env:local", Content: "worked",
+			State: "accept", F: 0.9, Sigma: 1.0, K: 0.05,
+		})
+	}
+	before, _ := s.QueryMK(context.Background(), "intent:test", "env:local")
+	if before.Action == "Ignore" {
+		t.Fatalf("precondition: expected populated pair to be non-Ignore, got %q", before.Action)
+	}
+	if _, err := s.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	pots, err := s.QueryMK(context.Background(), "intent:test", "env:local")
+	if err != nil {
+		t.Fatalf("QueryMK failed: %v", err)
+	}
+	if pots.Action != "Ignore" {
+		t.Errorf("expected Ignore after Clear, got %q", pots.Action)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Forget tests
+// ---------------------------------------------------------------------------
+
+// persistTagged stores one M-level Megram per entity under space and returns their IDs.
+func persistTagged(s *Store, space string, entities ...string) []string {
+	var ids []string
+	for _, entity := range entities {
+		id := uuid.New().String()
+		s.persistMegram(types.Megram{
+			ID: id, Level: "M", CreatedAt: time.Now().UTC().Format(time.RFC3339),
+			Space: space, Entity: entity, Content: "lesson about " + space,
+			State: "accept", F: 0.9, Sigma: 1.0, K: 0.05,
+		})
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestForget_DeletesOnlyTheTagPair(t *testing.T) {
+	// Deletes all Megrams tagged with exactly (space, entity)
+	// Leaves Megrams under any other space or entity untouched
+	s := newTestStore(t)
+	defer s.db.Close()
+	gone := persistTagged(s, "intent:search_reuters", "env:local", "env:local")
+	kept := persistTagged(s, "intent:search_reuters", "env:remote")
+	kept = append(kept, persistTagged(s, "intent:weather", "env:local")...)
+
+	n, err := s.Forget("intent:search_reuters", "env:local")
+	if err != nil || n != 2 {
+		t.Fatalf("Forget = %d, %v; want 2, nil", n, err)
+	}
+	for _, id := range gone {
+		if _, err := s.fetchMegram(id); err == nil {
+			t.Errorf("expected %s to be deleted", id)
+		}
+	}
+	for _, id := range kept {
+		if _, err := s.fetchMegram(id); err != nil {
+			t.Errorf("expected %s to survive: %v", id, err)
+		}
+	}
+	if n, _ := s.Forget("intent:search_reuters", "env:local"); n != 0 {
+		t.Errorf("second Forget deleted %d, want 0", n)
+	}
+}
+
+func TestForgetSpace_DeletesOnlyTheTargetedSpace(t *testing.T) {
+	// Deletes Megrams under every entity of space
+	// Leaves Megrams in other spaces untouched, including ones whose name extends space
+	s := newTestStore(t)
+	defer s.db.Close()
+	space := IntentSlug("search reuters for news")
+	gone := persistTagged(s, space, "env:local", "tool:search")
+	kept := persistTagged(s, "intent:weather", "env:local")
+	kept = append(kept, persistTagged(s, space+"_today", "env:local")...)
+
+	n, err := s.ForgetSpace(space)
+	if err != nil || n != len(gone) {
+		t.Fatalf("ForgetSpace = %d, %v; want %d, nil", n, err, len(gone))
+	}
+	for _, id := range gone {
+		if _, err := s.fetchMegram(id); err == nil {
+			t.Errorf("expected %s to be deleted", id)
+		}
+	}
+	for _, id := range kept {
+		if _, err := s.fetchMegram(id); err != nil {
+			t.Errorf("expected %s to survive: %v", id, err)
+		}
+	}
+	if recent, _ := s.QueryRecent(context.Background(), space, "env:local", 10); len(recent) != 0 {
+		t.Errorf("QueryRecent still returns %d megrams for the forgotten space", len(recent))
+	}
+}
+
+// ── DecayFunc ────────────────────────────────────────────────────────────────
+
+// constDecay is a DecayFunc that ignores k and Δt, so a test can tell whether
+// a potential was computed through the store's injected curve.
+type constDecay float64
+
+func (c constDecay) Decay(float64, float64) float64 { return float64(c) }
+
+// agedMegram builds an M-level accept Megram with the given f and k, created days ago.
+func agedMegram(space string, f, k float64, days int) types.Megram {
+	return types.Megram{
+		ID: uuid.New().String(), Level: "M",
+		CreatedAt: time.Now().UTC().Add(-time.Duration(days) * 24 * time.Hour).Format(time.RFC3339),
+		Space:     space, Entity: "env:local",
+		State: "accept", F: f, Sigma: 1.0, K: k,
+	}
+}
+
+func TestSetDecay_QueryMKUsesInjectedCurve(t *testing.T) {
+	// QueryMK scales each Megram's f by the injected curve instead of exp(-k·Δt)
+	s := newTestStore(t)
+	defer s.db.Close()
+	s.SetDecay(constDecay(0.25))
+	s.persistMegram(agedMegram("intent:decay_inject", 0.8, 0.05, 0))
+
+	pots, err := s.QueryMK(context.Background(), "intent:decay_inject", "env:local")
+	if err != nil {
+		t.Fatalf("QueryMK failed: %v", err)
+	}
+	if math.Abs(pots.Attention-0.2) > 1e-9 || math.Abs(pots.Decision-0.2) > 1e-9 {
+		t.Errorf("expected att=dec=0.8*0.25=0.2, got att=%.4f dec=%.4f", pots.Attention, pots.Decision)
+	}
+}
+
+func TestSetDecay_GCPassUsesInjectedCurve(t *testing.T) {
+	// gcPass measures attention with the injected curve: a fresh Megram survives
+	// exponential decay but not a curve that keeps 5% of f
+	s := newTestStore(t)
+	defer s.db.Close()
+	m := agedMegram("intent:decay_gc", 0.9, 0.05, 0)
+	s.persistMegram(m)
+	if _, deleted := s.gcPass(); deleted != 0 {
+		t.Fatalf("default curve: expected fresh Megram to survive GC, deleted=%d", deleted)
+	}
+	s.SetDecay(constDecay(0.05))
+	if _, deleted := s.gcPass(); deleted != 1 {
+		t.Errorf("injected curve: expected att=0.045 < 0.1 to be GC'd, deleted=%d", deleted)
+	}
+}
+
+func TestSetDecay_SummaryVerboseUsesInjectedCurve(t *testing.T) {
+	// SummaryVerbose reports potentials decayed by the injected curve
+	s := newTestStore(t)
+	defer s.db.Close()
+	s.SetDecay(constDecay(0.5))
+	s.persistMegram(agedMegram("intent:decay_summary", 0.6, 0.05, 3))
+
+	got := s.SummaryVerbose()
+	if len(got.Groups) != 1 || len(got.Groups[0].Megrams) != 1 {
+		t.Fatalf("expected 1 group with 1 megram, got %+v", got.Groups)
+	}
+	if g := got.Groups[0]; math.Abs(g.Attention-0.3) > 1e-9 || math.Abs(g.Megrams[0].Attention-0.3) > 1e-9 {
+		t.Errorf("expected attention 0.6*0.5=0.3, got group %.4f / entry %.4f", g.Attention, g.Megrams[0].Attention)
+	}
+}
+
+func TestSetDecay_NilRestoresExponential(t *testing.T) {
+	// SetDecay(nil) restores ExponentialDecay
+	s := newTestStore(t)
+	defer s.db.Close()
+	s.SetDecay(constDecay(0))
+	s.SetDecay(nil)
+	if _, ok := s.decay.(ExponentialDecay); !ok {
+		t.Errorf("expected ExponentialDecay after SetDecay(nil), got %T", s.decay)
+	}
+}
+
+func TestPowerLawDecay_Values(t *testing.T) {
+	// Decay returns (1+deltaDays)^(-k); negative ages count as zero
+	d := PowerLawDecay{}
+	for _, c := range []struct{ k, days, want float64 }{
+		{0.5, 3, 0.5},
+		{1, 1, 0.5},
+		{2, 9, 0.01},
+		{0.5, 0, 1},
+		{0.5, -2, 1},
+		{0, 30, 1},
+	} {
+		if got := d.Decay(c.k, c.days); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("Decay(k=%v, Δt=%v) = %v, want %v", c.k, c.days, got, c.want)
+		}
+	}
+}
+
+func TestPowerLawDecay_QueryMKAttention(t *testing.T) {
+	// PowerLawDecay is the forgetting curve (1+Δt)^(-k)
+	// k=0.5, 3 days old → (1+3)^-0.5 = 0.5; att = 0.9*0.5 = 0.45 (exponential would give ≈0.77).
+	s := newTestStore(t)
+	defer s.db.Close()
+	s.SetDecay(PowerLawDecay{})
+	s.persistMegram(agedMegram("intent:decay_power", 0.9, 0.5, 3))
+
+	pots, err := s.QueryMK(context.Background(), "intent:decay_power", "env:local")
+	if err != nil {
+		t.Fatalf("QueryMK failed: %v", err)
+	}
+	if math.Abs(pots.Attention-0.45) > 0.01 {
+		t.Errorf("expected att≈0.45 under power-law decay, got %.4f", pots.Attention)
+	}
+}
+
+func TestParseDecay(t *testing.T) {
+	// "" and "exponential" return ExponentialDecay; "power_law" (or "power-law", any case) returns PowerLawDecay
+	for name, want := range map[string]DecayFunc{
+		"":            ExponentialDecay{},
+		"exponential": ExponentialDecay{},
+		"power_law":   PowerLawDecay{},
+		"Power-Law":   PowerLawDecay{},
+	} {
+		got, err := ParseDecay(name)
+		if err != nil || got != want {
+			t.Errorf("ParseDecay(%q) = %T, %v; want %T", name, got, err, want)
+		}
+	}
+	// Any other name returns an error naming it
+	if _, err := ParseDecay("hyperbolic"); err == nil || !strings.Contains(err.Error(), `"hyperbolic"`) {
+		t.Errorf("expected error naming the model, got %v", err)
+	}
+}
+
+// ── CriterionTags ────────────────────────────────────────────────────────────
+
+func TestCriterionTags_SimilarWordingSharesTag(t *testing.T) {
+	// Criteria differing only in case and punctuation share a tag
+	s1, e1 := CriterionTags("Output contains a valid file path.")
+	s2, e2 := CriterionTags("output CONTAINS a valid file path!")
+	if s1 != "criterion:output_contains_a_valid_file_path" || s1 != s2 {
+		t.Errorf("expected one shared tag, got %q and %q", s1, s2)
+	}
+	if e1 != "env:local" || e2 != "env:local" {
+		t.Errorf("entity = %q / %q, want env:local", e1, e2)
+	}
+}
+
+func TestCriterionTags_CJKCriteriaGetDistinctTags(t *testing.T) {
+	// CJK-only criteria get distinct, non-empty tags; no letters or digits falls back to a hash
+	a, _ := CriterionTags("输出包含有效的文件路径")
+	b, _ := CriterionTags("输出列出所有者")
+	if a != "criterion:输出包含有效的文件路径" || a == b {
+		t.Errorf("CJK tags = %q and %q, want distinct slugs of each criterion", a, b)
+	}
+	h1, _ := CriterionTags("✅ ✅")
+	h2, _ := CriterionTags("❌ ❌")
+	if !strings.HasPrefix(h1, "criterion:#") || h1 == h2 {
+		t.Errorf("symbol-only tags = %q and %q, want distinct hash tags", h1, h2)
+	}
+}
+
+func TestCriterionTags_UsesAtMostSixWords(t *testing.T) {
+	// Space starts with "criterion:" and uses at most criterionSlugWords words
+	space, _ := CriterionTags("the output lists every mp4 file under Downloads with its size")
+	if space != "criterion:the_output_lists_every_mp4_file" {
+		t.Errorf("got %q", space)
+	}
+}
+
+func terminalMegram(space, state string) types.Megram {
+	return types.Megram{
+		ID:        uuid.New().String(),
+		Level:     "M",
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		Space:     space,
+		Entity:    "env:local",
+		State:     state,
+	}
+}
+
+func TestIntentStats_UpdatesOnTerminalStates(t *testing.T) {
+	// accept and success increment Accepts; abandon increments Abandons
+	// Returns ok=false for non-terminal states (refine, change_path, ...)
+	s := newTestStore(t)
+	defer s.db.Close()
+
+	for _, state := range []string{"accept", "success", "abandon", "refine", "change_path", "accept"} {
+		s.persistMegram(terminalMegram("intent:find_audio", state))
+	}
+	st, err := s.IntentStats("intent:find_audio")
+	if err != nil {
+		t.Fatalf("IntentStats: %v", err)
+	}
+	if st.Accepts != 3 || st.Abandons != 1 {
+		t.Errorf("got accepts=%d abandons=%d, want 3 and 1", st.Accepts, st.Abandons)
+	}
+	if got := st.SuccessRate(); math.Abs(got-0.75) > 1e-9 {
+		t.Errorf("SuccessRate = %.3f, want 0.75", got)
+	}
+}
+
+func TestIntentStats_IgnoresNonIntentSpacesAndUnknownIntents(t *testing.T) {
+	// Returns ok=false unless m is an M-level Megram in an "intent:" space
+	// Returns zero counts and no error when the space has no recorded outcomes
+	s := newTestStore(t)
+	defer s.db.Close()
+
+	s.persistMegram(terminalMegram("tool:shell", "accept"))
+	c := terminalMegram("intent:find_audio", "accept")
+	c.Level = "C"
+	s.persistMegram(c)
+
+	for _, space := range []string{"tool:shell", "intent:find_audio", "intent:never_seen"} {
+		st, err := s.IntentStats(space)
+		if err != nil {
+			t.Fatalf("IntentStats(%q): %v", space, err)
+		}
+		if st.Runs() != 0 {
+			t.Errorf("IntentStats(%q).Runs() = %d, want 0", space, st.Runs())
+		}
+	}
+}
+
+func TestIntentStats_PersistsAcrossReopen(t *testing.T) {
+	// Reflects every terminal Megram persisted for the space
+	dir := t.TempDir()
+	s := New(nil, dir, nil)
+	s.persistMegram(terminalMegram("intent:find_audio", "abandon"))
+	s.persistMegram(terminalMegram("intent:find_audio", "abandon"))
+	s.db.Close()
+
+	s = New(nil, dir, nil)
+	defer s.db.Close()
+	s.persistMegram(terminalMegram("intent:find_audio", "accept"))
+	st, _ := s.IntentStats("intent:find_audio")
+	if st.Accepts != 1 || st.Abandons != 2 {
+		t.Errorf("got accepts=%d abandons=%d after reopen, want 1 and 2", st.Accepts, st.Abandons)
+	}
+}
+
+func TestForgetSpace_ResetsIntentStats(t *testing.T) {
+	// Resets the space's intent statistic
+	s := newTestStore(t)
+	defer s.db.Close()
+
+	s.persistMegram(terminalMegram("intent:find_audio", "abandon"))
+	if _, err := s.ForgetSpace("intent:find_audio"); err != nil {
+		t.Fatalf("ForgetSpace: %v", err)
+	}
+	if st, _ := s.IntentStats("intent:find_audio"); st.Runs() != 0 {
+		t.Errorf("Runs() = %d after ForgetSpace, want 0", st.Runs())
+	}
+}
+
+func TestPersistMegram_DeduplicatesIdempotencyKey(t *testing.T) {
+	// Skips m when a Megram with the same IdempotencyKey was persisted within idempotencyWindow
+	// A skipped duplicate does not count towards the intent statistic
+	s := newTestStore(t)
+	defer s.db.Close()
+
+	for range 2 {
+		m := terminalMegram("intent:find_audio", "accept")
+		m.IdempotencyKey = "terminal|find_audio|accept"
+		s.persistMegram(m)
+	}
+	recent, err := s.QueryRecent(context.Background(), "intent:find_audio", "env:local", 10)
+	if err != nil {
+		t.Fatalf("QueryRecent: %v", err)
+	}
+	if len(recent) != 1 {
+		t.Errorf("persisted %d Megrams, want 1", len(recent))
+	}
+	if st, _ := s.IntentStats("intent:find_audio"); st.Accepts != 1 {
+		t.Errorf("Accepts = %d, want 1", st.Accepts)
+	}
+}
+
+func TestPersistMegram_DistinctOrMissingKeysAreWritten(t *testing.T) {
+	// Megrams without an IdempotencyKey are always written
+	// Only an identical key is a duplicate — another state for the same task is kept
+	s := newTestStore(t)
+	defer s.db.Close()
+
+	accept := terminalMegram("intent:find_audio", "accept")
+	accept.IdempotencyKey = "terminal|find_audio|accept"
+	abandon := terminalMegram("intent:find_audio", "abandon")
+	abandon.IdempotencyKey = "terminal|find_audio|abandon"
+	for _, m := range []types.Megram{accept, abandon, terminalMegram("intent:find_audio", "refine"), terminalMegram("intent:find_audio", "refine")} {
+		s.persistMegram(m)
+	}
+	recent, err := s.QueryRecent(context.Background(), "intent:find_audio", "env:local", 10)
+	if err != nil {
+		t.Fatalf("QueryRecent: %v", err)
+	}
+	if len(recent) != 4 {
+		t.Errorf("persisted %d Megrams, want 4", len(recent))
+	}
+}
+
+func TestPersistMegram_KeyExpiresAfterWindow(t *testing.T) {
+	// A key older than idempotencyWindow no longer suppresses a write, so a later run
+	// of a task with the same ID is recorded
+	s := newTestStore(t)
+	defer s.db.Close()
+
+	key := "terminal|find_audio|accept"
+	old := time.Now().Add(-2 * idempotencyWindow).UTC().Format(time.RFC3339)
+	if err := s.db.Put([]byte(prefixIdem+key), []byte(old), nil); err != nil {
+		t.Fatal(err)
+	}
+	m := terminalMegram("intent:find_audio", "accept")
+	m.IdempotencyKey = key
+	s.persistMegram(m)
+	if st, _ := s.IntentStats("intent:find_audio"); st.Accepts != 1 {
+		t.Errorf("Accepts = %d, want 1 — expired key should not block the write", st.Accepts)
+	}
+}
+
+func TestExport_ReturnsTaggedMegramsIncludingQueued(t *testing.T) {
+	// Includes Megrams still in the write queue
+	// Leaves Megrams in spaces whose name merely extends space out
+	// Returns an empty slice and no error when nothing carries the tag
+	s := newTestStore(t)
+	defer s.db.Close()
+
+	s.persistMegram(terminalMegram("intent:find_audio", "accept"))
+	s.Write(terminalMegram("intent:find_audio", "abandon")) // still queued
+	s.persistMegram(terminalMegram("intent:find_audio_files", "accept"))
+
+	megs, err := s.Export("intent:find_audio", "")
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if len(megs) != 2 {
+		t.Errorf("exported %d Megrams, want 2", len(megs))
+	}
+	for _, m := range megs {
+		if m.Space != "intent:find_audio" {
+			t.Errorf("exported a Megram from %q", m.Space)
+		}
+	}
+	if megs, err := s.Export("intent:never_seen", "env:local"); err != nil || megs == nil || len(megs) != 0 {
+		t.Errorf("Export of an empty tag = %v, %v; want empty slice", megs, err)
+	}
+}
+
+func TestExport_FlushesThroughRunningWriter(t *testing.T) {
+	// While Run is active, flushes through its writer loop rather than persisting concurrently
+	dir := t.TempDir()
+	s := New(bus.New(), dir, nil)
+	s.SetDreamerSchedule(DreamerSchedule{Interval: time.Hour, Settle: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() { s.Run(ctx); close(stopped) }()
+	defer func() { cancel(); <-stopped }()
+	for !s.running.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	for round := 1; round <= 20; round++ {
+		s.Write(terminalMegram("intent:find_audio", "accept"))
+		megs, err := s.Export("intent:find_audio", "")
+		if err != nil {
+			t.Fatalf("Export: %v", err)
+		}
+		if len(megs) != round {
+			t.Fatalf("round %d: exported %d Megrams, want every one written so far", round, len(megs))
+		}
+	}
+	if st, _ := s.IntentStats("intent:find_audio"); st.Accepts != 20 {
+		t.Errorf("Accepts = %d, want 20 — each Megram persisted once", st.Accepts)
+	}
+}
+
+func TestImport_KeepsIDsAndSkipsExisting(t *testing.T) {
+	// Skips Megrams whose ID is already stored, so importing twice adds nothing
+	// Keeps Megrams that share an IdempotencyKey; they were distinct runs when exported
+	// Imported Megrams are returned by QueryRecent and counted in IntentStats
+	s := newTestStore(t)
+	defer s.db.Close()
+
+	a := terminalMegram("intent:find_audio", "accept")
+	b := terminalMegram("intent:find_audio", "accept")
+	a.IdempotencyKey, b.IdempotencyKey = "terminal|find_audio|accept", "terminal|find_audio|accept"
+	megs := []types.Megram{a, b, {Space: "intent:find_audio"}}
+	if n, err := s.Import(megs); err != nil || n != 2 {
+		t.Fatalf("Import = %d, %v; want 2", n, err)
+	}
+	if n, err := s.Import(megs); err != nil || n != 0 {
+		t.Errorf("second Import = %d, %v; want 0", n, err)
+	}
+	recent, _ := s.QueryRecent(context.Background(), "intent:find_audio", "env:local", 10)
+	if len(recent) != 2 {
+		t.Errorf("QueryRecent found %d Megrams, want 2", len(recent))
+	}
+	if st, _ := s.IntentStats("intent:find_audio"); st.Accepts != 2 {
+		t.Errorf("Accepts = %d, want 2", st.Accepts)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// SetClock tests
+// ---------------------------------------------------------------------------
+
+// newFakeClockStore returns a test store whose clock is stopped at a fixed date.
+func newFakeClockStore(t *testing.T) (*Store, *clock.FakeClock) {
+	t.Helper()
+	s := newTestStore(t)
+	fc := clock.NewFake(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+	s.SetClock(fc)
+	return s, fc
+}
+
+func TestSetClock_QueryMKDecaysToExactAttention(t *testing.T) {
+	// M_attention = Σ|fᵢ|·exp(−kᵢ·Δt_days), with Δt measured on the store's clock
+	s, fc := newFakeClockStore(t)
+	defer s.db.Close()
+	s.persistMegram(types.Megram{
+		ID: uuid.New().String(), Level: "M",
+		CreatedAt: fc.Now().Format(time.RFC3339),
+		Space:     "intent:fake_clock", Entity: "env:local",
+		State: "accept", F: 0.9, Sigma: 1.0, K: 0.2,
+	})
+
+	for _, days := range []int{0, 1, 10} {
+		fc.Set(time.Date(2025, 3, 1+days, 12, 0, 0, 0, time.UTC))
+		pots, err := s.QueryMK(context.Background(), "intent:fake_clock", "env:local")
+		if err != nil {
+			t.Fatalf("QueryMK failed: %v", err)
+		}
+		if want := 0.9 * math.Exp(-0.2*float64(days)); math.Abs(pots.Attention-want) > 1e-12 {
+			t.Errorf("after %d days: att = %.15f, want %.15f", days, pots.Attention, want)
+		}
+	}
+}
+
+func TestSetClock_RecallResetsDecayAtClockTime(t *testing.T) {
+	// Uses last_recalled_at as decay origin when it is later than created_at;
+	// QueryC stamps last_recalled_at from the store's clock
+	s, fc := newFakeClockStore(t)
+	defer s.db.Close()
+	s.persistMegram(types.Megram{
+		ID: uuid.New().String(), Level: "C",
+		CreatedAt: fc.Now().Format(time.RFC3339),
+		Space:     "intent:fake_recall", Entity: "env:local",
+		Content: "use rg before find", State: "accept", F: 0.8, Sigma: 1.0, K: 0.1,
+	})
+
+	fc.Advance(5 * 24 * time.Hour)
+	if sops, err := s.QueryC(context.Background(), "intent:fake_recall", "env:local"); err != nil || len(sops) != 1 {
+		t.Fatalf("QueryC = %v, %v; want the SOP", sops, err)
+	}
+	fc.Advance(2 * 24 * time.Hour)
+	pots, err := s.QueryMK(context.Background(), "intent:fake_recall", "env:local")
+	if err != nil {
+		t.Fatalf("QueryMK failed: %v", err)
+	}
+	if want := 0.8 * math.Exp(-0.1*2); math.Abs(pots.Attention-want) > 1e-12 {
+		t.Errorf("att = %.15f, want %.15f (2 days since the recall, not 7 since creation)", pots.Attention, want)
+	}
+}
+
+func TestSetClock_GCPassDeletesOnceDecayedBelowThreshold(t *testing.T) {
+	// Deletes M/K megrams whose decayed attention potential falls below Λ_gc=0.1
+	// f=0.9, k=0.2: 0.9·exp(−2.0) ≈ 0.122 at 10 days, 0.9·exp(−2.4) ≈ 0.082 at 12 days.
+	s, fc := newFakeClockStore(t)
+	defer s.db.Close()
+	m := types.Megram{
+		ID: uuid.New().String(), Level: "M",
+		CreatedAt: fc.Now().Format(time.RFC3339),
+		Space:     "intent:fake_gc", Entity: "env:local",
+		State: "accept", F: 0.9, Sigma: 1.0, K: 0.2,
+	}
+	s.persistMegram(m)
+
+	fc.Advance(10 * 24 * time.Hour)
+	if _, deleted := s.gcPass(); deleted != 0 {
+		t.Fatalf("at 10 days: deleted=%d, want the Megram kept", deleted)
+	}
+	fc.Advance(2 * 24 * time.Hour)
+	if _, deleted := s.gcPass(); deleted != 1 {
+		t.Errorf("at 12 days: deleted=%d, want the Megram collected", deleted)
+	}
+}
+
+func TestSetClock_WriteStampsCreatedAtFromClock(t *testing.T) {
+	// Assigns ID and CreatedAt if missing, reading CreatedAt from the store's clock
+	s, fc := newFakeClockStore(t)
+	defer s.db.Close()
+	s.Write(types.Megram{Space: "intent:fake_write", Entity: "env:local", State: "accept"})
+	m := <-s.writeCh
+	if want := fc.Now().Format(time.RFC3339); m.CreatedAt != want {
+		t.Errorf("CreatedAt = %q, want %q", m.CreatedAt, want)
+	}
+}
+
+func TestSetClock_NilRestoresWall(t *testing.T) {
+	// SetClock(nil) restores clock.Wall
+	s, _ := newFakeClockStore(t)
+	defer s.db.Close()
+	s.SetClock(nil)
+	if s.clock != clock.Wall {
+		t.Errorf("expected clock.Wall after SetClock(nil), got %T", s.clock)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Dreamer schedule tests
+// ---------------------------------------------------------------------------
+
+func TestRunDreamer_AdaptiveSkipsCycleWithoutWrites(t *testing.T) {
+	// Always runs the first cycle after New; in adaptive mode, skips the cycle
+	// when no Megram was stored since the last one
+	s := newTestStore(t)
+	defer s.db.Close()
+	s.SetDreamerSchedule(DreamerSchedule{Adaptive: true})
+
+	if !s.runDreamer("test") {
+		t.Fatal("the first cycle should run")
+	}
+	if s.runDreamer("test") {
+		t.Error("a cycle with no writes since the last one should be skipped")
+	}
+	s.persistMegram(agedMegram("intent:dreamer_adaptive", 0.9, 0.05, 0))
+	if !s.runDreamer("test") {
+		t.Error("a cycle after a write should run")
+	}
+	if s.runDreamer("test") {
+		t.Error("the cycle after that should be skipped again")
+	}
+}
+
+func TestRunDreamer_PromotedSOPDoesNotTriggerNextCycle(t *testing.T) {
+	// In adaptive mode, the cycle's own writes (promoted SOPs) do not count
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"Use find -name for file lookups."}}],"usage":{"total_tokens":15}}`))
+	}))
+	defer ts.Close()
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+	s := New(nil, t.TempDir(), llm.New())
+	defer s.db.Close()
+	s.SetDreamerSchedule(DreamerSchedule{Adaptive: true})
+	for range 6 {
+		s.persistMegram(agedMegram("intent:dreamer_promote", 0.95, 0.05, 0))
+	}
+
+	if !s.runDreamer("test") {
+		t.Fatal("the first cycle should run")
+	}
+	if sops, _ := s.QueryC(context.Background(), "intent:dreamer_promote", "env:local"); len(sops) != 1 {
+		t.Fatalf("expected the cycle to promote one SOP, got %d", len(sops))
+	}
+	if s.runDreamer("test") {
+		t.Error("a cycle whose only write was its own SOP should not make the next one run")
+	}
+}
+
+func TestRunDreamer_NotAdaptiveAlwaysRuns(t *testing.T) {
+	// Runs every cycle when the schedule is not adaptive
+	s := newTestStore(t)
+	defer s.db.Close()
+	for i := range 3 {
+		if !s.runDreamer("test") {
+			t.Errorf("cycle %d was skipped", i+1)
+		}
+	}
+}
+
+func TestParseDreamerSchedule(t *testing.T) {
+	// Returns DefaultDreamerSchedule when every value is empty; an empty value keeps
+	// that field's default; returns an error naming the variable for an unparsable or
+	// non-positive duration, or an unparsable boolean
+	if d, err := ParseDreamerSchedule("", "", ""); err != nil || d != DefaultDreamerSchedule() {
+		t.Errorf("empty: got %+v, %v; want the default", d, err)
+	}
+	d, err := ParseDreamerSchedule("1m", "", "true")
+	if err != nil || d.Interval != time.Minute || d.Settle != defaultDreamerSettle || !d.Adaptive {
+		t.Errorf("got %+v, %v; want 1m, the default settle, adaptive", d, err)
+	}
+	for _, c := range []struct{ interval, settle, adaptive, name string }{
+		{"soon", "", "", "ARTOO_DREAMER_INTERVAL"},
+		{"", "0s", "", "ARTOO_DREAMER_SETTLE"},
+		{"", "-1s", "", "ARTOO_DREAMER_SETTLE"},
+		{"", "", "sometimes", "ARTOO_DREAMER_ADAPTIVE"},
+	} {
+		if _, err := ParseDreamerSchedule(c.interval, c.settle, c.adaptive); err == nil || !strings.Contains(err.Error(), c.name) {
+			t.Errorf("(%q, %q, %q): got %v, want an error naming %s", c.interval, c.settle, c.adaptive, err, c.name)
+		}
+	}
+}
+
+func TestSetDreamerSchedule_NonPositiveKeepsDefaults(t *testing.T) {
+	// A non-positive Interval or Settle keeps its default
+	s := newTestStore(t)
+	defer s.db.Close()
+	s.SetDreamerSchedule(DreamerSchedule{Interval: -time.Second, Adaptive: true})
+	if want := (DreamerSchedule{Interval: defaultDreamerInterval, Settle: defaultDreamerSettle, Adaptive: true}); s.dream != want {
+		t.Errorf("got %+v, want %+v", s.dream, want)
+	}
+}
@@ -5,12 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/clock"
 	"github.com/haricheung/agentic-shell/internal/llm"
 	"github.com/haricheung/agentic-shell/internal/tasklog"
 	"github.com/haricheung/agentic-shell/internal/types"
@@ -33,6 +37,11 @@ merged_output rules:
 - Include concrete data (file paths, values, counts) — not process descriptions.
 - Omit intermediate steps (file discovery, etc.) unless they are the answer.
 
+conflicts rules:
+- Subtasks can contradict each other (e.g. two parallel searches each name a different file as THE answer).
+- Do NOT silently pick one. Keep both in merged_output and list each contradiction in "conflicts",
+  naming the subtask IDs and what they disagree on. Omit "conflicts" when outputs agree.
+
 JSON encoding rules (MANDATORY):
 - Output ONLY raw JSON — no markdown, no prose, no code fences.
 - Never write bare ASCII double-quote characters (") inside string values.
@@ -42,7 +51,7 @@ JSON encoding rules (MANDATORY):
 Output — choose ONE:
 
 All criteria met:
-{"verdict":"accept","summary":"<one sentence for the user>","merged_output":"<combined result>"}
+{"verdict":"accept","summary":"<one sentence for the user>","merged_output":"<combined result>","conflicts":["<optional: subtask IDs and what they disagree on>"]}
 
 Criteria unmet, replanning possible:
 {"verdict":"replan","gap_summary":"<which criterion failed and why>","failed_subtasks":["<subtask_id>"],"recommendation":"replan"}`
@@ -64,33 +73,70 @@ type MetaValidator struct {
 	trackers   map[string]*manifestTracker // taskID -> tracker
 	taskStart  map[string]time.Time        // taskID -> time first manifest was received
 	replanCounts map[string]int            // replan round counter for maxReplans safety net
+	planHistory  map[string][]string       // taskID -> planFingerprint of each replanned round, for GGS
 	// outputFn is called when a final result is ready for the user
-	outputFn func(taskID, summary string, output any)
+	outputFn func(types.FinalResult)
+	// maxPromptTokens bounds the merge prompt's estimated size (see fitOutcomes).
+	// 0 means no bound.
+	maxPromptTokens int
+	// clock times tasks and stamps outbound messages; clock.Wall unless SetClock is called.
+	clock clock.Clock
 }
 
 // New creates a MetaValidator.
-func New(b *bus.Bus, llmClient *llm.Client, outputFn func(taskID, summary string, output any), logReg *tasklog.Registry) *MetaValidator {
+func New(b *bus.Bus, llmClient *llm.Client, outputFn func(types.FinalResult), logReg *tasklog.Registry) *MetaValidator {
 	return &MetaValidator{
-		llm:          llmClient,
+		llm:          llmClient.ForRole("metaval"),
 		b:            b,
 		logReg:       logReg,
 		trackers:     make(map[string]*manifestTracker),
 		taskStart:    make(map[string]time.Time),
 		replanCounts: make(map[string]int),
+		planHistory:  make(map[string][]string),
 		outputFn:     outputFn,
+		// ARTOO_METAVAL_MAX_PROMPT_TOKENS, falling back to ARTOO_MAX_PROMPT_TOKENS.
+		maxPromptTokens: llm.MaxPromptTokens("metaval"),
+		clock:           clock.Wall,
 	}
 }
 
-// Run listens for DispatchManifest and SubTaskOutcome messages.
+// SetClock replaces the clock R4b times tasks and stamps messages with; nil
+// restores clock.Wall. Call before Run.
+func (m *MetaValidator) SetClock(c clock.Clock) {
+	if c == nil {
+		c = clock.Wall
+	}
+	m.clock = c
+}
+
+// Run listens for DispatchManifest and SubTaskOutcome messages, and for a
+// cancelled FinalResult so a task stopped from outside is no longer tracked.
 func (m *MetaValidator) Run(ctx context.Context) {
 	manifestCh := m.b.Subscribe(types.MsgDispatchManifest)
 	outcomeCh := m.b.Subscribe(types.MsgSubTaskOutcome)
+	finalCh := m.b.Subscribe(types.MsgFinalResult)
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
 
+		case msg, ok := <-finalCh:
+			if !ok {
+				return
+			}
+			fr, err := toFinalResult(msg.Payload)
+			if err != nil || fr.Directive != "cancelled" {
+				continue
+			}
+			m.mu.Lock()
+			delete(m.trackers, fr.TaskID)
+			delete(m.taskStart, fr.TaskID)
+			delete(m.replanCounts, fr.TaskID)
+			delete(m.planHistory, fr.TaskID)
+			m.mu.Unlock()
+			slog.Debug("[R4b] task cancelled, stopped tracking", "task", fr.TaskID, "reason", fr.CancelReason)
+
 		case msg, ok := <-manifestCh:
 			if !ok {
 				return
@@ -114,7 +160,7 @@ func (m *MetaValidator) Run(ctx context.Context) {
 			}
 			// Record start time on first manifest for GGS Ω elapsed time computation.
 			if _, seen := m.taskStart[manifest.TaskID]; !seen {
-				m.taskStart[manifest.TaskID] = time.Now().UTC()
+				m.taskStart[manifest.TaskID] = m.clock.Now().UTC()
 			}
 			m.mu.Unlock()
 			slog.Debug("[R4b] tracking task", "task", manifest.TaskID, "expecting", len(manifest.SubTaskIDs))
@@ -195,20 +241,31 @@ func (m *MetaValidator) evaluate(ctx context.Context, tracker *manifestTracker)
 	}
 
 	// All subtasks matched — call LLM to merge outputs and verify task_criteria.
-	outcomesJSON, _ := json.MarshalIndent(tracker.outcomes, "", "  ")
 	criteriaJSON, _ := json.MarshalIndent(tracker.manifest.TaskCriteria, "", "  ")
-	userPrompt := fmt.Sprintf(
-		"Task intent: %s\n\nTask criteria (written by R2 — ALL must be satisfied by the combined output):\n%s\n\nSubTaskOutcomes:\n%s\n\nMerge the subtask outputs and verify all task criteria are met.",
-		tracker.spec.Intent, criteriaJSON, outcomesJSON)
+	const userFormat = "Task intent: %s\n\nTask criteria (written by R2 — ALL must be satisfied by the combined output):\n%s\n\nSubTaskOutcomes:\n%s\n\nMerge the subtask outputs and verify all task criteria are met."
+	var guidance string
+	if g := llm.LanguageGuidance(tracker.spec.Language); g != "" {
+		guidance = "\n\n" + g
+	}
+	sys := m.llm.SystemPrompt(systemPrompt)
+	fixed := sys + fmt.Sprintf(userFormat, tracker.spec.Intent, criteriaJSON, "") + guidance
+	outcomesJSON := fitOutcomes(m.maxPromptTokens, fixed, tracker.outcomes)
+	userPrompt := fmt.Sprintf(userFormat, tracker.spec.Intent, criteriaJSON, outcomesJSON) + guidance
 
-	raw, usage, err := m.llm.Chat(ctx, systemPrompt, userPrompt)
+	raw, usage, err := m.llm.Chat(ctx, sys, userPrompt)
 	tl := m.logReg.Get(taskID)
-	tl.LLMCall("metaval", systemPrompt, userPrompt, raw, usage.PromptTokens, usage.CompletionTokens, usage.ElapsedMs, 0)
+	tl.LLMCall("metaval", sys, userPrompt, raw, usage.PromptTokens, usage.CompletionTokens, usage.ElapsedMs, 0)
 	if err != nil {
 		slog.Error("[R4b] LLM call failed", "error", err)
 		return
 	}
-	raw = extractJSON(llm.StripFences(raw))
+	raw, err = llm.RepairJSON(raw)
+	if err != nil {
+		slog.Error("[R4b] parse verdict failed", "error", err, "raw", raw)
+		m.triggerReplan(ctx, tracker, nil, totalCorrections,
+			"metaval verdict parse error: "+err.Error())
+		return
+	}
 
 	var v struct {
 		Verdict        string   `json:"verdict"`
@@ -217,6 +274,7 @@ func (m *MetaValidator) evaluate(ctx context.Context, tracker *manifestTracker)
 		GapSummary     string   `json:"gap_summary"`
 		FailedSubtasks []string `json:"failed_subtasks"`
 		Recommendation string   `json:"recommendation"`
+		Conflicts      []string `json:"conflicts"`
 	}
 	if err := json.Unmarshal([]byte(raw), &v); err != nil {
 		slog.Error("[R4b] parse verdict failed", "error", err, "raw", raw)
@@ -241,13 +299,20 @@ func (m *MetaValidator) evaluate(ctx context.Context, tracker *manifestTracker)
 		start, hasStart := m.taskStart[taskID]
 		outcomes := append([]types.SubTaskOutcome(nil), tracker.outcomes...)
 		m.mu.Unlock()
+		// Contradictory matched outputs are surfaced, not silently merged: the
+		// LLM's own list plus whatever the deterministic check catches.
+		conflicts := mergeConflicts(v.Conflicts, detectConflicts(outcomes))
+		if len(conflicts) > 0 {
+			slog.Warn("[R4b] conflicting subtask results", "task", taskID, "conflicts", conflicts)
+			v.Summary = flagConflicts(v.Summary, conflicts)
+		}
 		var elapsedMs int64
 		if hasStart {
-			elapsedMs = time.Since(start).Milliseconds()
+			elapsedMs = m.clock.Now().Sub(start).Milliseconds()
 		}
 		m.b.Publish(types.Message{
 			ID:        uuid.New().String(),
-			Timestamp: time.Now().UTC(),
+			Timestamp: m.clock.Now().UTC(),
 			From:      types.RoleMetaVal,
 			To:        types.RoleGGS,
 			Type:      types.MsgOutcomeSummary,
@@ -258,6 +323,7 @@ func (m *MetaValidator) evaluate(ctx context.Context, tracker *manifestTracker)
 				MergedOutput: v.MergedOutput,
 				ElapsedMs:    elapsedMs,
 				Outcomes:     outcomes,
+				Conflicts:    conflicts,
 			},
 		})
 
@@ -266,6 +332,7 @@ func (m *MetaValidator) evaluate(ctx context.Context, tracker *manifestTracker)
 		delete(m.trackers, taskID)
 		delete(m.taskStart, taskID)
 		delete(m.replanCounts, taskID)
+		delete(m.planHistory, taskID)
 		m.mu.Unlock()
 
 	case "replan":
@@ -273,6 +340,135 @@ func (m *MetaValidator) evaluate(ctx context.Context, tracker *manifestTracker)
 	}
 }
 
+// maxConflictAnswer bounds the outputs detectConflicts compares: a longer output
+// is a report, not a single answer two subtasks could disagree on.
+const maxConflictAnswer = 200
+
+// detectConflicts is the deterministic half of conflict detection: matched
+// subtasks of the same sequence that set out to answer the same question
+// (intents sharing at least half their keywords) but came back with different
+// single-line answers. Subtasks of different sequences are steps of a pipeline
+// — find the file, then read it — so their outputs are expected to differ.
+//
+// Expectations:
+//   - Returns nil when fewer than two matched outcomes have a short single-line output
+//   - Reports a pair with similar intents whose answers differ beyond case and trailing punctuation
+//   - Ignores pairs whose intents share under half their keywords
+//   - Ignores pairs from different sequences
+//   - Ignores failed outcomes and multi-line or long outputs
+func detectConflicts(outcomes []types.SubTaskOutcome) []string {
+	type answer struct {
+		o    types.SubTaskOutcome
+		text string
+		kw   map[string]bool
+	}
+	var answers []answer
+	for _, o := range outcomes {
+		if o.Status != "matched" {
+			continue
+		}
+		text := strings.TrimSpace(fmt.Sprint(o.Output))
+		if s, ok := o.Output.(string); ok {
+			text = strings.TrimSpace(s)
+		}
+		if o.Output == nil || text == "" || len(text) > maxConflictAnswer || strings.Contains(text, "\n") {
+			continue
+		}
+		answers = append(answers, answer{o: o, text: text, kw: intentKeywords(o.Intent)})
+	}
+	var conflicts []string
+	for i := range answers {
+		for j := i + 1; j < len(answers); j++ {
+			a, b := answers[i], answers[j]
+			if a.o.Sequence != b.o.Sequence || keywordOverlap(a.kw, b.kw) < 0.5 || sameAnswer(a.text, b.text) {
+				continue
+			}
+			conflicts = append(conflicts, fmt.Sprintf("%s and %s disagree on %q: %q vs %q",
+				a.o.SubTaskID, b.o.SubTaskID, a.o.Intent, a.text, b.text))
+		}
+	}
+	return conflicts
+}
+
+// planFingerprint identifies the plan behind outcomes by its subtask intents:
+// the sorted, de-duplicated intentKeywords of all of them, joined by spaces.
+// Reordered subtasks give the same fingerprint; a reworded plan a similar one.
+func planFingerprint(outcomes []types.SubTaskOutcome) string {
+	kw := make(map[string]bool)
+	for _, o := range outcomes {
+		maps.Copy(kw, intentKeywords(o.Intent))
+	}
+	return strings.Join(slices.Sorted(maps.Keys(kw)), " ")
+}
+
+// intentStopwords are common intent words that say nothing about the question asked.
+var intentStopwords = map[string]bool{
+	"the": true, "and": true, "for": true, "from": true, "with": true, "into": true,
+	"that": true, "this": true, "all": true, "any": true, "its": true, "then": true,
+	"each": true, "every": true, "out": true, "using": true, "via": true,
+}
+
+// intentKeywords returns the lowercase words of at least three letters or digits
+// in intent, minus intentStopwords.
+func intentKeywords(intent string) map[string]bool {
+	kw := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(intent), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(w)) >= 3 && !intentStopwords[w] {
+			kw[w] = true
+		}
+	}
+	return kw
+}
+
+// keywordOverlap is the Jaccard similarity of two keyword sets; 0 when both are empty.
+func keywordOverlap(a, b map[string]bool) float64 {
+	inter := 0
+	for w := range a {
+		if b[w] {
+			inter++
+		}
+	}
+	union := len(a) + len(b) - inter
+	if union == 0 {
+		return 0
+	}
+	return float64(inter) / float64(union)
+}
+
+// sameAnswer compares two answers ignoring case and trailing punctuation.
+func sameAnswer(a, b string) bool {
+	trim := func(s string) string { return strings.TrimRight(strings.ToLower(s), ".!;, ") }
+	return trim(a) == trim(b)
+}
+
+// mergeConflicts joins conflict lists, dropping exact duplicates and blanks.
+func mergeConflicts(lists ...[]string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, l := range lists {
+		for _, c := range l {
+			c = strings.TrimSpace(c)
+			if c != "" && !seen[c] {
+				seen[c] = true
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+// flagConflicts appends the conflicts to the user-facing summary, so a merged
+// result built on contradictory subtask outputs never reads as settled.
+func flagConflicts(summary string, conflicts []string) string {
+	flag := "⚠ Conflicting subtask results — check before relying on this: " + strings.Join(conflicts, "; ")
+	if strings.TrimSpace(summary) == "" {
+		return flag
+	}
+	return strings.TrimSpace(summary) + "\n" + flag
+}
+
 // aggregateFailureClassFromOutcomes derives the dominant failure_class from
 // SubTaskOutcome.CriteriaVerdicts across all failed outcomes.
 //
@@ -323,15 +519,29 @@ func aggregateFailureClassFromOutcomes(outcomes []types.SubTaskOutcome) string {
 //   - Increments replanCounts before checking the limit
 //   - Sends ReplanRequest to R7 (GGS), not R2 (Planner)
 //   - Includes full outcomes and elapsed_ms in ReplanRequest for GGS gradient computation
+//   - Stamps ReplanRequest.Round with replanCount, the authoritative round counter
+//   - Appends the round's planFingerprint to ReplanRequest.PriorPlans, after those of earlier rounds
+//   - Sends nothing when tracker is no longer the task's live tracker (task already terminal)
+//   - Measures ElapsedMs on the validator's clock since the task's first manifest
 func (m *MetaValidator) triggerReplan(ctx context.Context, tracker *manifestTracker, failedIDs []string, totalCorrections int, gapSummary string) {
 	const maxReplans = 3
 	taskID := tracker.manifest.TaskID
 
 	m.mu.Lock()
+	// A concurrent evaluate may have abandoned the task (or a newer manifest
+	// replaced this tracker) while this one was waiting on the LLM; replanning
+	// now would restart the round count for a task that is already over.
+	if m.trackers[taskID] != tracker {
+		m.mu.Unlock()
+		slog.Warn("[R4b] dropping replan for a task that is no longer tracked", "task", taskID)
+		return
+	}
 	m.replanCounts[taskID]++
 	replanCount := m.replanCounts[taskID]
 	start, hasStart := m.taskStart[taskID]
 	outcomes := append([]types.SubTaskOutcome(nil), tracker.outcomes...) // snapshot before reset
+	m.planHistory[taskID] = append(m.planHistory[taskID], planFingerprint(outcomes))
+	priorPlans := slices.Clone(m.planHistory[taskID])
 	m.mu.Unlock()
 
 	tl := m.logReg.Get(taskID)
@@ -350,7 +560,7 @@ func (m *MetaValidator) triggerReplan(ctx context.Context, tracker *manifestTrac
 	// Compute elapsed time for GGS Ω calculation.
 	var elapsedMs int64
 	if hasStart {
-		elapsedMs = time.Since(start).Milliseconds()
+		elapsedMs = m.clock.Now().Sub(start).Milliseconds()
 	}
 
 	rr := types.ReplanRequest{
@@ -362,11 +572,14 @@ func (m *MetaValidator) triggerReplan(ctx context.Context, tracker *manifestTrac
 		ElapsedMs:       elapsedMs,
 		Outcomes:        outcomes,
 		Recommendation:  recommendation,
+		Language:        tracker.spec.Language,
+		Round:           replanCount,
+		PriorPlans:      priorPlans,
 	}
 	slog.Info("[R4b] sending ReplanRequest to GGS", "task", taskID, "round", replanCount, "gap", gapSummary, "elapsed_ms", elapsedMs)
 	m.b.Publish(types.Message{
 		ID:        uuid.New().String(),
-		Timestamp: time.Now().UTC(),
+		Timestamp: m.clock.Now().UTC(),
 		From:      types.RoleMetaVal,
 		To:        types.RoleGGS,
 		Type:      types.MsgReplanRequest,
@@ -379,6 +592,7 @@ func (m *MetaValidator) triggerReplan(ctx context.Context, tracker *manifestTrac
 		delete(m.trackers, taskID)
 		delete(m.replanCounts, taskID)
 		delete(m.taskStart, taskID)
+		delete(m.planHistory, taskID)
 	} else {
 		tracker.outcomes = nil
 	}
@@ -386,50 +600,53 @@ func (m *MetaValidator) triggerReplan(ctx context.Context, tracker *manifestTrac
 }
 
 
-// extractJSON finds the first complete JSON object in s by brace-matching.
-// Returns the extracted substring or s unchanged if no complete object is found.
-// Handles nested objects and strings with escaped characters correctly.
+// fitOutcomes renders outcomes as indented JSON for the merge prompt, keeping
+// fixed plus the result within maxTokens estimated tokens. Every outcome stays
+// in the prompt — R4b must see each subtask to merge them — so it first drops
+// the intermediate steps (gap trajectory, criteria verdicts, tool calls), then
+// shortens each output evenly, keeping its head and tail.
 //
 // Expectations:
-//   - Returns s unchanged when s contains no '{'
-//   - Returns the first complete {...} object, stripping leading/trailing prose
-//   - Returns s unchanged when braces are unbalanced (no complete object)
-//   - Handles nested braces correctly
-func extractJSON(s string) string {
-	start := strings.IndexByte(s, '{')
-	if start < 0 {
-		return s
-	}
-	depth := 0
-	inStr := false
-	escaped := false
-	for i := start; i < len(s); i++ {
-		b := s[i]
-		if escaped {
-			escaped = false
-			continue
+//   - Returns the full JSON when maxTokens <= 0 or it fits
+//   - Drops gap_trajectory, criteria_verdicts, and tool_calls before touching outputs
+//   - Shortens outputs until fixed plus the JSON fits, keeping every subtask_id and status
+func fitOutcomes(maxTokens int, fixed string, outcomes []types.SubTaskOutcome) []byte {
+	fits := func(b []byte) bool {
+		return maxTokens <= 0 || llm.EstimateTokens(fixed)+llm.EstimateTokens(string(b)) <= maxTokens
+	}
+	full, _ := json.MarshalIndent(outcomes, "", "  ")
+	if fits(full) {
+		return full
+	}
+	slim := make([]types.SubTaskOutcome, len(outcomes))
+	texts := make([]string, len(outcomes))
+	for i, o := range outcomes {
+		o.GapTrajectory, o.CriteriaVerdicts, o.ToolCalls = nil, nil, nil
+		slim[i] = o
+		if s, ok := o.Output.(string); ok {
+			texts[i] = s
+		} else {
+			b, _ := json.Marshal(o.Output)
+			texts[i] = string(b)
 		}
-		switch b {
-		case '\\':
-			if inStr {
-				escaped = true
-			}
-		case '"':
-			inStr = !inStr
-		case '{':
-			if !inStr {
-				depth++
-			}
-		case '}':
-			if !inStr {
-				depth--
-				if depth == 0 {
-					return s[start : i+1]
-				}
-			}
+	}
+	out, _ := json.MarshalIndent(slim, "", "  ")
+	if fits(out) {
+		return out
+	}
+	slog.Info("[R4b] shortening subtask outputs to fit the prompt budget", "max_prompt_tokens", maxTokens, "subtasks", len(outcomes))
+	per := (maxTokens - llm.EstimateTokens(fixed)) / max(len(outcomes), 1)
+	for per > 0 {
+		for i := range slim {
+			slim[i].Output = llm.TruncateTokens(texts[i], per)
+		}
+		out, _ = json.MarshalIndent(slim, "", "  ")
+		if fits(out) {
+			break
 		}
+		per = per * 3 / 4
 	}
-	return s
+	return out
 }
 
 func toDispatchManifest(payload any) (types.DispatchManifest, error) {
@@ -449,3 +666,12 @@ func toSubTaskOutcome(payload any) (types.SubTaskOutcome, error) {
 	var o types.SubTaskOutcome
 	return o, json.Unmarshal(b, &o)
 }
+
+func toFinalResult(payload any) (types.FinalResult, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return types.FinalResult{}, err
+	}
+	var fr types.FinalResult
+	return fr, json.Unmarshal(b, &fr)
+}
@@ -3,13 +3,16 @@ package metaval
 import (
 	"context"
 	"encoding/json"
+	"maps"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/clock"
 	"github.com/haricheung/agentic-shell/internal/llm"
 	"github.com/haricheung/agentic-shell/internal/tasklog"
 	"github.com/haricheung/agentic-shell/internal/types"
@@ -116,50 +119,6 @@ func TestAggregateFailureClassFromOutcomes_NoClassifiedCriteriaReturnsEmpty(t *t
 	}
 }
 
-// ── extractJSON ───────────────────────────────────────────────────────────────
-
-func TestExtractJSON_PassesThroughPureJSON(t *testing.T) {
-	// Returns s unchanged when s contains only a JSON object
-	s := `{"verdict":"accept","summary":"ok"}`
-	if got := extractJSON(s); got != s {
-		t.Errorf("expected unchanged, got %q", got)
-	}
-}
-
-func TestExtractJSON_ExtractsFromLeadingProse(t *testing.T) {
-	// Returns the first complete {...} object, stripping leading prose
-	s := `Here is the result: {"verdict":"accept","summary":"ok"}`
-	want := `{"verdict":"accept","summary":"ok"}`
-	if got := extractJSON(s); got != want {
-		t.Errorf("expected %q, got %q", want, got)
-	}
-}
-
-func TestExtractJSON_ExtractsFromTrailingProse(t *testing.T) {
-	// Returns the first complete {...} object, stripping trailing prose
-	s := `{"verdict":"accept","summary":"ok"} Let me know if you need more.`
-	want := `{"verdict":"accept","summary":"ok"}`
-	if got := extractJSON(s); got != want {
-		t.Errorf("expected %q, got %q", want, got)
-	}
-}
-
-func TestExtractJSON_HandlesNestedBraces(t *testing.T) {
-	// Handles nested braces correctly — stops at the matching outer '}'
-	s := `{"a":{"b":1},"c":2}`
-	if got := extractJSON(s); got != s {
-		t.Errorf("expected unchanged for nested braces, got %q", got)
-	}
-}
-
-func TestExtractJSON_ReturnsUnchangedWhenNoBrace(t *testing.T) {
-	// Returns s unchanged when s contains no '{'
-	s := `no json here`
-	if got := extractJSON(s); got != s {
-		t.Errorf("expected unchanged when no brace, got %q", got)
-	}
-}
-
 // ── evaluate — replan on parse error ─────────────────────────────────────────
 
 // mockLLMResponse builds a minimal OpenAI-compatible chat completion JSON
@@ -172,7 +131,7 @@ func mockLLMResponse(body string) string {
 func TestEvaluate_TriggerReplanOnParseError(t *testing.T) {
 	// When the LLM returns invalid JSON, evaluate() must call triggerReplan
 	// and publish a MsgReplanRequest instead of returning silently.
-	badJSON := `{"verdict":"accept","summary":"ok","merged_output":"text with "unescaped" quotes"}`
+	badJSON := `{"verdict":"accept","summary":"ok","merged_output":`
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(mockLLMResponse(badJSON)))
@@ -204,6 +163,9 @@ func TestEvaluate_TriggerReplanOnParseError(t *testing.T) {
 		outcomes:      []types.SubTaskOutcome{{SubTaskID: "s1", Status: "matched"}},
 		expectedCount: 1,
 	}
+	mv.mu.Lock()
+	mv.trackers["test-task"] = tracker
+	mv.mu.Unlock()
 
 	mv.evaluate(context.Background(), tracker)
 
@@ -219,3 +181,350 @@ func TestEvaluate_TriggerReplanOnParseError(t *testing.T) {
 		t.Error("expected MsgReplanRequest but got none — evaluate() likely returned silently")
 	}
 }
+
+func TestEvaluate_RepairsUnescapedQuotes(t *testing.T) {
+	// Bare inner quotes in the verdict are repaired, so the task is accepted
+	// instead of replanned on a parse error.
+	badJSON := `{"verdict":"accept","summary":"ok","merged_output":"text with "unescaped" quotes"}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(badJSON)))
+	}))
+	defer ts.Close()
+
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+
+	b := bus.New()
+	summaryCh := b.Subscribe(types.MsgOutcomeSummary)
+	logReg := tasklog.NewRegistry("")
+	logReg.Open("test-task", "test intent")
+
+	mv := New(b, llm.New(), nil, logReg)
+	mv.mu.Lock()
+	mv.replanCounts["test-task"] = 0
+	mv.taskStart["test-task"] = time.Now()
+	mv.mu.Unlock()
+
+	tracker := &manifestTracker{
+		manifest: types.DispatchManifest{
+			TaskID:       "test-task",
+			SubTaskIDs:   []string{"s1"},
+			TaskCriteria: []string{"output is correct"},
+		},
+		spec:          types.TaskSpec{Intent: "test intent"},
+		outcomes:      []types.SubTaskOutcome{{SubTaskID: "s1", Status: "matched"}},
+		expectedCount: 1,
+	}
+
+	mv.evaluate(context.Background(), tracker)
+
+	select {
+	case msg := <-summaryCh:
+		var os types.OutcomeSummary
+		b2, _ := json.Marshal(msg.Payload)
+		json.Unmarshal(b2, &os)
+		if got, _ := os.MergedOutput.(string); got != `text with "unescaped" quotes` {
+			t.Errorf("expected repaired merged_output, got %#v", os.MergedOutput)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("expected MsgOutcomeSummary for the repaired verdict but got none")
+	}
+}
+
+// ── conflicting outcomes ─────────────────────────────────────────────────────
+
+// conflictingOutcomes is two parallel file searches that each found a different "the" file.
+func conflictingOutcomes() []types.SubTaskOutcome {
+	return []types.SubTaskOutcome{
+		{SubTaskID: "s1", Status: "matched", Intent: "find the tax PDF in Downloads", Output: "/Users/me/Downloads/tax_2025.pdf"},
+		{SubTaskID: "s2", Status: "matched", Intent: "find the tax PDF in Documents", Output: "/Users/me/Documents/tax-return.pdf"},
+	}
+}
+
+func TestDetectConflicts_SimilarIntentsDifferentAnswers(t *testing.T) {
+	// Reports a pair with similar intents whose answers differ beyond case and trailing punctuation
+	got := detectConflicts(conflictingOutcomes())
+	if len(got) != 1 || !strings.Contains(got[0], "s1 and s2 disagree") || !strings.Contains(got[0], "tax-return.pdf") {
+		t.Errorf("expected one s1/s2 conflict, got %q", got)
+	}
+}
+
+func TestDetectConflicts_IgnoresAgreementUnrelatedAndLongOutputs(t *testing.T) {
+	// Ignores agreeing answers, dissimilar intents, failed outcomes, and multi-line outputs
+	cases := map[string][]types.SubTaskOutcome{
+		"agree": {
+			{SubTaskID: "s1", Status: "matched", Intent: "count Go files in the project", Output: "42"},
+			{SubTaskID: "s2", Status: "matched", Intent: "count Go files in the project tree", Output: "42."},
+		},
+		"unrelated": {
+			{SubTaskID: "s1", Status: "matched", Intent: "count Go files in the project", Output: "42"},
+			{SubTaskID: "s2", Status: "matched", Intent: "fetch the weather for Beijing", Output: "sunny"},
+		},
+		"failed": {
+			conflictingOutcomes()[0],
+			{SubTaskID: "s2", Status: "failed", Intent: "find the tax PDF in Documents", Output: "/tmp/x.pdf"},
+		},
+		"report": {
+			conflictingOutcomes()[0],
+			{SubTaskID: "s2", Status: "matched", Intent: "find the tax PDF in Documents", Output: "found:\n/Users/me/Documents/tax-return.pdf"},
+		},
+	}
+	for name, outcomes := range cases {
+		if got := detectConflicts(outcomes); len(got) != 0 {
+			t.Errorf("%s: expected no conflicts, got %q", name, got)
+		}
+	}
+}
+
+func TestDetectConflicts_IgnoresSequentialSteps(t *testing.T) {
+	// Ignores pairs from different sequences
+	outcomes := []types.SubTaskOutcome{
+		{SubTaskID: "s1", Status: "matched", Sequence: 1, Intent: "find the config file", Output: "/etc/app/config.yaml"},
+		{SubTaskID: "s2", Status: "matched", Sequence: 2, Intent: "read the config file", Output: "port: 8080"},
+	}
+	if got := detectConflicts(outcomes); len(got) != 0 {
+		t.Errorf("expected no conflict between a step and the step after it, got %q", got)
+	}
+}
+
+func TestIntentKeywords_DropsStopwords(t *testing.T) {
+	// intentKeywords drops intentStopwords so function words do not inflate overlap
+	got := intentKeywords("find the tax PDF and all receipts from Downloads")
+	want := []string{"downloads", "find", "pdf", "receipts", "tax"}
+	if keys := slices.Sorted(maps.Keys(got)); !slices.Equal(keys, want) {
+		t.Errorf("intentKeywords = %v, want %v", keys, want)
+	}
+}
+
+func TestEvaluate_FlagsConflictingOutcomesInMergedResult(t *testing.T) {
+	// Two matched subtasks with contradictory answers: the accepted summary flags
+	// the conflict, and both the heuristic's and the LLM's conflicts are carried.
+	verdict := `{"verdict":"accept","summary":"Found your tax PDF.","merged_output":"/Users/me/Downloads/tax_2025.pdf","conflicts":["s1 and s2 name different tax PDFs"]}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(verdict)))
+	}))
+	defer ts.Close()
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+
+	b := bus.New()
+	summaryCh := b.Subscribe(types.MsgOutcomeSummary)
+	logReg := tasklog.NewRegistry("")
+	logReg.Open("tax-task", "find my tax PDF")
+	mv := New(b, llm.New(), nil, logReg)
+	tracker := &manifestTracker{
+		manifest:      types.DispatchManifest{TaskID: "tax-task", SubTaskIDs: []string{"s1", "s2"}, TaskCriteria: []string{"the tax PDF path is reported"}},
+		spec:          types.TaskSpec{Intent: "find my tax PDF"},
+		outcomes:      conflictingOutcomes(),
+		expectedCount: 2,
+	}
+
+	mv.evaluate(context.Background(), tracker)
+
+	select {
+	case msg := <-summaryCh:
+		os := msg.Payload.(types.OutcomeSummary)
+		if !strings.HasPrefix(os.Summary, "Found your tax PDF.") || !strings.Contains(os.Summary, "Conflicting subtask results") {
+			t.Errorf("expected the summary to flag the conflict, got %q", os.Summary)
+		}
+		if len(os.Conflicts) != 2 || os.Conflicts[0] != "s1 and s2 name different tax PDFs" || !strings.Contains(os.Conflicts[1], "s1 and s2 disagree") {
+			t.Errorf("expected the LLM's and the heuristic's conflicts, got %q", os.Conflicts)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expected MsgOutcomeSummary but got none")
+	}
+}
+
+// ── triggerReplan round counter ──────────────────────────────────────────────
+
+// trackedFailure registers a live tracker for taskID holding one failed outcome.
+func trackedFailure(mv *MetaValidator, taskID string) *manifestTracker {
+	tracker := &manifestTracker{
+		manifest:      types.DispatchManifest{TaskID: taskID, SubTaskIDs: []string{"s1"}},
+		outcomes:      []types.SubTaskOutcome{{SubTaskID: "s1", ParentTaskID: taskID, Status: "failed"}},
+		expectedCount: 1,
+	}
+	mv.mu.Lock()
+	mv.trackers[taskID] = tracker
+	mv.mu.Unlock()
+	return tracker
+}
+
+func TestTriggerReplan_StampsRoundAndAbandonsAtLimit(t *testing.T) {
+	// Round counts 1, 2, 3; the third round carries the abandon recommendation
+	b := bus.New()
+	replanCh := b.Subscribe(types.MsgReplanRequest)
+	mv := New(b, nil, nil, nil)
+	tracker := trackedFailure(mv, "t1")
+
+	for want := 1; want <= 3; want++ {
+		mv.triggerReplan(context.Background(), tracker, []string{"s1"}, 0, "gap")
+		msg := <-replanCh
+		rr := msg.Payload.(types.ReplanRequest)
+		if rr.Round != want {
+			t.Errorf("round = %d, want %d", rr.Round, want)
+		}
+		if wantRec := map[bool]string{true: "abandon", false: "replan"}[want == 3]; rr.Recommendation != wantRec {
+			t.Errorf("round %d recommendation = %q, want %q", want, rr.Recommendation, wantRec)
+		}
+	}
+}
+
+func TestTriggerReplan_AppendsPlanFingerprints(t *testing.T) {
+	// Appends the round's planFingerprint to ReplanRequest.PriorPlans, after those of earlier rounds
+	b := bus.New()
+	replanCh := b.Subscribe(types.MsgReplanRequest)
+	mv := New(b, nil, nil, nil)
+	tracker := trackedFailure(mv, "t1")
+
+	var got []string
+	for _, intent := range []string{"Find the PDF in Downloads", "downloads: find the pdf"} {
+		tracker.outcomes = []types.SubTaskOutcome{{SubTaskID: "s1", ParentTaskID: "t1", Intent: intent, Status: "failed"}}
+		mv.triggerReplan(context.Background(), tracker, []string{"s1"}, 0, "gap")
+		got = (<-replanCh).Payload.(types.ReplanRequest).PriorPlans
+	}
+	if want := []string{"downloads find pdf", "downloads find pdf"}; !slices.Equal(got, want) {
+		t.Errorf("PriorPlans = %q, want %q", got, want)
+	}
+}
+
+func TestTriggerReplan_ElapsedMsFromClock(t *testing.T) {
+	// Measures ElapsedMs on the validator's clock since the task's first manifest
+	b := bus.New()
+	replanCh := b.Subscribe(types.MsgReplanRequest)
+	fc := clock.NewFake(time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+	mv := New(b, nil, nil, nil)
+	mv.SetClock(fc)
+	tracker := trackedFailure(mv, "t1")
+	mv.mu.Lock()
+	mv.taskStart["t1"] = fc.Now()
+	mv.mu.Unlock()
+
+	fc.Advance(42 * time.Second)
+	mv.triggerReplan(context.Background(), tracker, []string{"s1"}, 0, "gap")
+	if rr := (<-replanCh).Payload.(types.ReplanRequest); rr.ElapsedMs != 42000 {
+		t.Errorf("ElapsedMs = %d, want 42000", rr.ElapsedMs)
+	}
+}
+
+func TestTriggerReplan_DropsAfterAbandon(t *testing.T) {
+	// A late evaluation of the abandoned task's tracker must not restart the round count
+	b := bus.New()
+	replanCh := b.Subscribe(types.MsgReplanRequest)
+	mv := New(b, nil, nil, nil)
+	tracker := trackedFailure(mv, "t1")
+	mv.mu.Lock()
+	mv.replanCounts["t1"] = 2
+	mv.mu.Unlock()
+
+	mv.triggerReplan(context.Background(), tracker, []string{"s1"}, 0, "gap")
+	if rr := (<-replanCh).Payload.(types.ReplanRequest); rr.Recommendation != "abandon" {
+		t.Fatalf("expected abandon at round 3, got %q", rr.Recommendation)
+	}
+
+	mv.triggerReplan(context.Background(), tracker, []string{"s1"}, 0, "late gap")
+	select {
+	case msg := <-replanCh:
+		t.Fatalf("expected no ReplanRequest after abandon, got %+v", msg.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRun_CancelledFinalResultStopsTracking(t *testing.T) {
+	// A cancelled FinalResult drops the task's tracker, start time, and replan count
+	b := bus.New()
+	mv := New(b, nil, nil, nil)
+	trackedFailure(mv, "t1")
+	mv.mu.Lock()
+	mv.replanCounts["t1"] = 1
+	mv.taskStart["t1"] = time.Now()
+	mv.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mv.Run(ctx)
+	time.Sleep(20 * time.Millisecond) // let Run subscribe
+	b.Publish(types.Message{Type: types.MsgFinalResult, Payload: types.FinalResult{
+		TaskID: "t1", Directive: "cancelled", CancelReason: types.CancelIdle,
+	}})
+	time.Sleep(20 * time.Millisecond)
+
+	mv.mu.Lock()
+	defer mv.mu.Unlock()
+	if _, ok := mv.trackers["t1"]; ok {
+		t.Error("tracker should be dropped")
+	}
+	if _, ok := mv.replanCounts["t1"]; ok {
+		t.Error("replan count should be dropped")
+	}
+	if _, ok := mv.taskStart["t1"]; ok {
+		t.Error("start time should be dropped")
+	}
+}
+
+// ── fitOutcomes ───────────────────────────────────────────────────────────────
+
+func bulkyOutcomes() []types.SubTaskOutcome {
+	var out []types.SubTaskOutcome
+	for _, id := range []string{"s1", "s2", "s3"} {
+		out = append(out, types.SubTaskOutcome{
+			SubTaskID:     id,
+			Status:        "matched",
+			Output:        "result-" + id + " " + strings.Repeat("row of data ", 600) + " end-" + id,
+			GapTrajectory: []types.GapTrajectoryPoint{{Attempt: 1}, {Attempt: 2}},
+			ToolCalls:     []string{"shell: " + strings.Repeat("x", 500)},
+		})
+	}
+	return out
+}
+
+func TestFitOutcomes_FullJSONWithoutBudget(t *testing.T) {
+	// Returns the full JSON when maxTokens <= 0 or it fits
+	outcomes := bulkyOutcomes()
+	full, _ := json.MarshalIndent(outcomes, "", "  ")
+	if got := fitOutcomes(0, "system", outcomes); string(got) != string(full) {
+		t.Error("expected the full outcomes JSON with no budget")
+	}
+}
+
+func TestFitOutcomes_BoundsPromptAndKeepsEverySubtask(t *testing.T) {
+	// Shortens outputs until fixed plus the JSON fits, keeping every subtask_id and status
+	outcomes := bulkyOutcomes()
+	fixed := strings.Repeat("system prompt ", 100)
+	const limit = 2000
+	got := fitOutcomes(limit, fixed, outcomes)
+	if n := llm.EstimateTokens(fixed) + llm.EstimateTokens(string(got)); n > limit {
+		t.Errorf("expected at most %d tokens, got %d", limit, n)
+	}
+	var back []types.SubTaskOutcome
+	if err := json.Unmarshal(got, &back); err != nil || len(back) != 3 {
+		t.Fatalf("expected 3 outcomes in valid JSON, got %d (%v)", len(back), err)
+	}
+	for i, o := range back {
+		out, _ := o.Output.(string)
+		id := outcomes[i].SubTaskID
+		if o.SubTaskID != id || o.Status != "matched" || !strings.HasPrefix(out, "result-"+id) || !strings.HasSuffix(out, "end-"+id) {
+			t.Errorf("outcome %d lost its identity or the head/tail of its output: %+v", i, o.SubTaskID)
+		}
+	}
+}
+
+func TestFitOutcomes_DropsIntermediateStepsFirst(t *testing.T) {
+	// Drops gap_trajectory, criteria_verdicts, and tool_calls before touching outputs
+	outcomes := bulkyOutcomes()
+	outputsOnly := make([]types.SubTaskOutcome, len(outcomes))
+	for i, o := range outcomes {
+		o.GapTrajectory, o.ToolCalls = nil, nil
+		outputsOnly[i] = o
+	}
+	slim, _ := json.MarshalIndent(outputsOnly, "", "  ")
+	limit := llm.EstimateTokens(string(slim)) + 10
+	got := fitOutcomes(limit, "", outcomes)
+	if string(got) != string(slim) {
+		t.Error("expected intermediate steps dropped and outputs left whole")
+	}
+}
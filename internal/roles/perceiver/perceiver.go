@@ -3,6 +3,7 @@ package perceiver
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
@@ -11,6 +12,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/haricheung/agentic-shell/internal/bus"
 	"github.com/haricheung/agentic-shell/internal/llm"
+	"github.com/haricheung/agentic-shell/internal/tasklog"
 	"github.com/haricheung/agentic-shell/internal/types"
 )
 
@@ -29,6 +31,7 @@ No markdown, no prose, no code fences.
 Field rules:
 - task_id: short, descriptive, snake_case (e.g. "find_video_file", "disk_space_check"). Not a UUID.
 - intent: one sentence, action-oriented, no filler.
+- assumptions: optional array of short strings. When you resolve an ambiguity yourself instead of asking (which file, which folder, which time range), add one entry per interpretation you chose, e.g. "assumed 'the file' means the most recently modified .md in the current directory". Omit it when the input was unambiguous.
 
 Temporal reference rules:
 - Do NOT resolve relative time words (今年/this year, 最近/recently, 上周/last week, 昨天/yesterday, etc.) into specific dates or years.
@@ -49,13 +52,58 @@ type Perceiver struct {
 	mem types.MemoryService // may be nil; used by fast path to consult global:user memories
 	// clarify is a function called when R1 needs user input; returns user's answer
 	clarify func(question string) (string, error)
+	// maxPromptTokens bounds each prompt's estimated size; the session history is
+	// shortened first. 0 means no bound.
+	maxPromptTokens int
+	// tags are the user's --tag tags, attached to every TaskSpec ahead of AutoTags.
+	tags []string
+	// logReg receives R1's LLM calls once the task ID is known; may be nil.
+	logReg *tasklog.Registry
+	// calls holds this Process call's TaskSpec LLM calls until publish logs them.
+	calls []perceiveCall
+}
+
+// perceiveCall is one R1 LLM exchange awaiting the task log.
+type perceiveCall struct {
+	user, response string
+	usage          llm.Usage
 }
 
 // New creates a Perceiver.
 func New(b *bus.Bus, llmClient *llm.Client, clarifyFn func(string) (string, error), mem types.MemoryService) *Perceiver {
-	return &Perceiver{llm: llmClient, b: b, clarify: clarifyFn, mem: mem}
+	return &Perceiver{llm: llmClient.ForRole("perceiver"), b: b, clarify: clarifyFn, mem: mem, maxPromptTokens: llm.MaxPromptTokens("perceiver")}
+}
+
+// NewWithTags is New with user tags (see ParseTags) attached to every TaskSpec
+// it publishes, ahead of the tags derived from the intent.
+func NewWithTags(b *bus.Bus, llmClient *llm.Client, clarifyFn func(string) (string, error), mem types.MemoryService, tags []string) *Perceiver {
+	p := New(b, llmClient, clarifyFn, mem)
+	p.tags = tags
+	return p
+}
+
+// SetTaskLog makes R1 write its TaskSpec LLM calls to the task's log in reg, so
+// --replay-llm can answer them too. The log is opened when the TaskSpec is
+// published, before R2 sees it; R2's own Open then reuses it.
+func (p *Perceiver) SetTaskLog(reg *tasklog.Registry) {
+	p.logReg = reg
+}
+
+// ErrNoClarify is returned by a clarify callback to mean "nobody is there to
+// answer — proceed with the best interpretation and do not ask again".
+// Use NoClarify as the callback for fully autonomous (unattended) operation.
+var ErrNoClarify = errors.New("clarification disabled")
+
+// NoClarify is a clarify callback that never blocks: it always returns ErrNoClarify.
+func NoClarify(string) (string, error) {
+	return "", ErrNoClarify
 }
 
+// noClarifyInstruction replaces the usual "proceed" instruction when the clarify
+// callback declined to ask, so R1 records the interpretation it chose.
+const noClarifyInstruction = "\n\n[Instruction: clarification is disabled — nobody can answer questions. Do not request clarification. " +
+	"Choose the most plausible interpretation and list every interpretation you chose in an \"assumptions\" array of short strings in the TaskSpec.]"
+
 // maxClarificationRounds caps how many times R1 may ask the user a clarifying question
 // before giving up and proceeding with its best interpretation.
 const maxClarificationRounds = 2
@@ -150,6 +198,8 @@ func isConversational(input string) bool {
 // ProcessResult holds the output of Perceiver.Process().
 type ProcessResult struct {
 	TaskID         string    // non-empty when a TaskSpec was published to the pipeline
+	Tags           []string  // the published TaskSpec's tags
+	Assumptions    []string  // the published TaskSpec's assumptions
 	DirectResponse string    // non-empty when R1 answered directly (no pipeline needed)
 	Usage          llm.Usage // accumulated LLM usage across all rounds
 }
@@ -163,8 +213,11 @@ type ProcessResult struct {
 //   - Returns DirectResponse for simple conversational queries that need no tools
 //   - Returns TaskID for actionable tasks that need the pipeline
 //   - Asks at most maxClarificationRounds clarifying questions before committing
+//   - Never blocks when the clarify callback returns ErrNoClarify: proceeds at once and records the assumption in TaskSpec.Assumptions
 //   - Accumulates LLM usage across all rounds
+//   - Logs every TaskSpec LLM call, clarification rounds included, to the published task's log when SetTaskLog was called
 func (p *Perceiver) Process(ctx context.Context, rawInput, sessionContext string) (ProcessResult, error) {
+	p.calls = nil
 	// Code-level fast path: detect simple conversational inputs before the LLM call
 	// and answer with a lightweight chat prompt (no TaskSpec parsing, no pipeline).
 	if isConversational(rawInput) {
@@ -181,7 +234,8 @@ func (p *Perceiver) Process(ctx context.Context, rawInput, sessionContext string
 			}
 		}
 		if sessionContext != "" {
-			parts = append(parts, "Recent session history:\n"+sessionContext)
+			history := p.fitSession(chatPrompt+strings.Join(parts, "\n\n")+rawInput, sessionContext)
+			parts = append(parts, "Recent session history:\n"+history)
 		}
 		parts = append(parts, rawInput)
 
@@ -194,6 +248,7 @@ func (p *Perceiver) Process(ctx context.Context, rawInput, sessionContext string
 
 	input := rawInput
 	var totalUsage llm.Usage
+	skippedQuestion := "" // set when the clarify callback returned ErrNoClarify
 	for round := 0; round < maxClarificationRounds; round++ {
 		result, needsClarification, question, usage, err := p.perceive(ctx, input, sessionContext)
 		totalUsage.PromptTokens += usage.PromptTokens
@@ -205,12 +260,17 @@ func (p *Perceiver) Process(ctx context.Context, rawInput, sessionContext string
 		}
 
 		if !needsClarification {
-			taskID, err := p.publish(result.Spec)
-			return ProcessResult{TaskID: taskID, Usage: totalUsage}, err
+			spec := p.publish(result.Spec, rawInput)
+			return ProcessResult{TaskID: spec.TaskID, Tags: spec.Tags, Assumptions: spec.Assumptions, Usage: totalUsage}, nil
 		}
 
 		// Ask user for clarification
 		answer, err := p.clarify(question)
+		if errors.Is(err, ErrNoClarify) {
+			slog.Info("[R1] clarification disabled, proceeding with best interpretation", "question", question)
+			skippedQuestion = question
+			break
+		}
 		if err != nil {
 			return ProcessResult{Usage: totalUsage}, fmt.Errorf("perceiver: clarification: %w", err)
 		}
@@ -225,7 +285,10 @@ func (p *Perceiver) Process(ctx context.Context, rawInput, sessionContext string
 
 	// Max rounds reached or user gave empty answer — one final call with instruction to commit.
 	finalInput := input + "\n\n[Instruction: proceed with the best interpretation; do not request further clarification.]"
-	result, _, _, usage, err := p.perceive(ctx, finalInput, "")
+	if skippedQuestion != "" {
+		finalInput = input + noClarifyInstruction
+	}
+	result, stillAsking, _, usage, err := p.perceive(ctx, finalInput, "")
 	totalUsage.PromptTokens += usage.PromptTokens
 	totalUsage.CompletionTokens += usage.CompletionTokens
 	totalUsage.TotalTokens += usage.TotalTokens
@@ -233,11 +296,55 @@ func (p *Perceiver) Process(ctx context.Context, rawInput, sessionContext string
 	if err != nil {
 		return ProcessResult{Usage: totalUsage}, fmt.Errorf("perceiver: %w", err)
 	}
-	taskID, err := p.publish(result.Spec)
-	return ProcessResult{TaskID: taskID, Usage: totalUsage}, err
+	if skippedQuestion != "" {
+		if stillAsking {
+			// The model asked again despite the instruction; fall back to the raw input
+			// as the intent rather than publishing an empty TaskSpec.
+			result.Spec = types.TaskSpec{TaskID: uuid.New().String(), Intent: rawInput, RawInput: finalInput}
+		}
+		result.Spec = recordAssumption(result.Spec, skippedQuestion)
+	}
+	spec := p.publish(result.Spec, rawInput)
+	return ProcessResult{TaskID: spec.TaskID, Tags: spec.Tags, Assumptions: spec.Assumptions, Usage: totalUsage}, nil
+}
+
+// recordAssumption makes sure a TaskSpec produced without asking the user says so.
+// When R1 listed no assumptions itself, a default one naming the skipped question
+// and the chosen intent is added so R2 and the task log can see the guess.
+//
+// Expectations:
+//   - Keeps the model's own Assumptions unchanged when non-empty
+//   - Adds one assumption quoting the skipped question and the chosen intent when empty
+func recordAssumption(spec types.TaskSpec, question string) types.TaskSpec {
+	if len(spec.Assumptions) > 0 {
+		return spec
+	}
+	spec.Assumptions = []string{fmt.Sprintf("did not ask %q; proceeded with: %s", question, spec.Intent)}
+	return spec
 }
 
-func (p *Perceiver) publish(spec types.TaskSpec) (string, error) {
+// publish sends spec to R2, first recording the language detected from rawInput
+// when the model did not set one. Detection runs on the user's own words, not on
+// the clarification transcript or instructions appended to them. spec is tagged
+// with the user's tags, then any the model gave, then AutoTags of its intent.
+// Returns the spec as published.
+func (p *Perceiver) publish(spec types.TaskSpec, rawInput string) types.TaskSpec {
+	if spec.Language == "" {
+		spec.Language = llm.DetectLanguage(rawInput)
+	}
+	tags := mergeTags(nil, p.tags...)
+	for _, t := range spec.Tags {
+		tags = mergeTags(tags, normTag(t))
+	}
+	spec.Tags = mergeTags(tags, AutoTags(spec.Intent)...)
+	if p.logReg != nil {
+		tl := p.logReg.Open(spec.TaskID, spec.Intent, spec.Tags...)
+		sys := p.llm.SystemPrompt(systemPrompt)
+		for _, c := range p.calls {
+			tl.LLMCall("perceiver", sys, c.user, c.response, c.usage.PromptTokens, c.usage.CompletionTokens, c.usage.ElapsedMs, 0)
+		}
+	}
+	p.calls = nil
 	p.b.Publish(types.Message{
 		ID:        uuid.New().String(),
 		Timestamp: time.Now().UTC(),
@@ -246,8 +353,22 @@ func (p *Perceiver) publish(spec types.TaskSpec) (string, error) {
 		Type:      types.MsgTaskSpec,
 		Payload:   spec,
 	})
-	slog.Info("[R1] published TaskSpec", "task_id", spec.TaskID)
-	return spec.TaskID, nil
+	slog.Info("[R1] published TaskSpec", "task_id", spec.TaskID, "assumptions", len(spec.Assumptions), "language", spec.Language, "tags", spec.Tags)
+	return spec
+}
+
+// fitSession keeps the most recent session history lines that fit, alongside
+// the rest of the prompt, within the perceiver's prompt budget.
+//
+// Expectations:
+//   - Returns sessionContext unchanged when there is no budget or it fits
+//   - Otherwise drops the oldest lines first, noting how many were dropped
+func (p *Perceiver) fitSession(rest, sessionContext string) string {
+	if p.maxPromptTokens <= 0 {
+		return sessionContext
+	}
+	lines := strings.SplitAfter(sessionContext, "\n")
+	return strings.Join(llm.FitParts(p.maxPromptTokens, rest+"Recent session history:\n", "session history lines", lines), "")
 }
 
 // perceiveResult holds the parsed LLM output — exactly one of Spec or DirectResponse is set.
@@ -257,16 +378,23 @@ type perceiveResult struct {
 }
 
 func (p *Perceiver) perceive(ctx context.Context, input, sessionContext string) (perceiveResult, bool, string, llm.Usage, error) {
+	sys := p.llm.SystemPrompt(systemPrompt)
 	userPrompt := input
 	if sessionContext != "" {
-		userPrompt = "Recent session history:\n" + sessionContext + "\n\nNew input: " + input
+		history := p.fitSession(sys+"\n\nNew input: "+input, sessionContext)
+		userPrompt = "Recent session history:\n" + history + "\n\nNew input: " + input
 	}
-	raw, usage, err := p.llm.Chat(ctx, systemPrompt, userPrompt)
+	userPrompt = llm.FitUser(p.maxPromptTokens, sys, userPrompt)
+	raw, usage, err := p.llm.Chat(ctx, sys, userPrompt)
 	if err != nil {
 		return perceiveResult{}, false, "", usage, err
 	}
+	p.calls = append(p.calls, perceiveCall{user: userPrompt, response: raw, usage: usage})
 
-	raw = llm.StripFences(raw)
+	raw, err = llm.RepairJSON(raw)
+	if err != nil {
+		return perceiveResult{}, false, "", usage, fmt.Errorf("parse TaskSpec: %w (raw: %s)", err, raw)
+	}
 
 	// Check for clarification request.
 	var clarCheck struct {
@@ -1,6 +1,24 @@
 package perceiver
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/llm"
+	"github.com/haricheung/agentic-shell/internal/tasklog"
+	"github.com/haricheung/agentic-shell/internal/types"
+)
 
 // ── isConversational ─────────────────────────────────────────────────────────
 
@@ -63,3 +81,291 @@ func TestIsConversational_ComplexTask(t *testing.T) {
 		}
 	}
 }
+
+// ── no-clarify mode ──────────────────────────────────────────────────────────
+
+// mockLLMResponse builds a minimal OpenAI-compatible chat completion JSON
+// whose content field is the provided body string.
+func mockLLMResponse(body string) string {
+	escaped, _ := json.Marshal(body)
+	return `{"choices":[{"message":{"role":"assistant","content":` + string(escaped) + `}}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`
+}
+
+// sequenceLLM serves bodies in order (repeating the last) and records each request body.
+func sequenceLLM(t *testing.T, bodies ...string) *[]string {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		i := min(len(requests), len(bodies)-1)
+		requests = append(requests, string(raw))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(mockLLMResponse(bodies[i])))
+	}))
+	t.Cleanup(ts.Close)
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+	return &requests
+}
+
+// processNoClarify runs Process with the NoClarify callback and returns the
+// TaskSpec published to R2.
+func processNoClarify(t *testing.T, input string) types.TaskSpec {
+	t.Helper()
+	b := bus.New()
+	specCh := b.Subscribe(types.MsgTaskSpec)
+	p := New(b, llm.New(), NoClarify, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pr, err := p.Process(ctx, input, "")
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if pr.TaskID == "" {
+		t.Fatal("expected a TaskID — ambiguous input should still proceed to planning")
+	}
+	select {
+	case msg := <-specCh:
+		raw, _ := json.Marshal(msg.Payload)
+		var spec types.TaskSpec
+		json.Unmarshal(raw, &spec)
+		if !slices.Equal(pr.Assumptions, spec.Assumptions) {
+			t.Errorf("ProcessResult.Assumptions = %v, want the TaskSpec's %v", pr.Assumptions, spec.Assumptions)
+		}
+		return spec
+	case <-time.After(time.Second):
+		t.Fatal("expected a TaskSpec on the bus")
+		return types.TaskSpec{}
+	}
+}
+
+const askBody = `{"needs_clarification": true, "question": "Which project do you mean?"}`
+
+func TestProcess_NoClarifyProceedsWithModelAssumptions(t *testing.T) {
+	// Never blocks when the clarify callback returns ErrNoClarify: proceeds at once and records the assumption in TaskSpec.Assumptions
+	reqs := sequenceLLM(t, askBody,
+		`{"task_id":"clean_build","intent":"delete build artifacts in the current project","constraints":{"scope":null,"deadline":null},"raw_input":"","assumptions":["project means the current working directory"]}`)
+
+	spec := processNoClarify(t, "clean up the project build stuff")
+
+	if len(*reqs) != 2 {
+		t.Fatalf("expected 2 LLM calls (ask, then commit), got %d", len(*reqs))
+	}
+	if !strings.Contains((*reqs)[1], "clarification is disabled") {
+		t.Errorf("expected the final prompt to carry the no-clarify instruction, got %s", (*reqs)[1])
+	}
+	if len(spec.Assumptions) != 1 || spec.Assumptions[0] != "project means the current working directory" {
+		t.Errorf("expected the model's assumption to be kept, got %v", spec.Assumptions)
+	}
+}
+
+func TestProcess_NoClarifyRecordsDefaultAssumption(t *testing.T) {
+	// A default assumption naming the skipped question is recorded when the model lists none
+	sequenceLLM(t, askBody,
+		`{"task_id":"clean_build","intent":"delete build artifacts in the current project","constraints":{"scope":null,"deadline":null},"raw_input":""}`)
+
+	spec := processNoClarify(t, "clean up the project build stuff")
+
+	if spec.TaskID != "clean_build" {
+		t.Errorf("expected task_id clean_build, got %q", spec.TaskID)
+	}
+	if len(spec.Assumptions) != 1 || !strings.Contains(spec.Assumptions[0], "Which project do you mean?") {
+		t.Errorf("expected a default assumption quoting the question, got %v", spec.Assumptions)
+	}
+}
+
+func TestProcess_NoClarifyFallsBackWhenModelKeepsAsking(t *testing.T) {
+	// Uses the raw input as the intent when the model asks again despite the instruction
+	sequenceLLM(t, askBody)
+
+	spec := processNoClarify(t, "clean up the project build stuff")
+
+	if spec.Intent != "clean up the project build stuff" || spec.TaskID == "" {
+		t.Errorf("expected raw-input fallback spec, got %+v", spec)
+	}
+	if len(spec.Assumptions) != 1 {
+		t.Errorf("expected one recorded assumption, got %v", spec.Assumptions)
+	}
+}
+
+// ── task log and replay ──────────────────────────────────────────────────────
+
+func TestProcess_LoggedCallsReplayFromR1(t *testing.T) {
+	// Logs every TaskSpec LLM call, clarification rounds included, to the published task's log when SetTaskLog was called
+	reqs := sequenceLLM(t, askBody,
+		`{"task_id":"clean_build","intent":"delete build artifacts in the current project","constraints":{"scope":null,"deadline":null},"raw_input":""}`)
+	dir := t.TempDir()
+	reg := tasklog.NewRegistry(dir)
+	p := New(bus.New(), llm.New(), NoClarify, nil)
+	p.SetTaskLog(reg)
+	if _, err := p.Process(t.Context(), "clean up the project build stuff", ""); err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	reg.Close("clean_build", "accepted")
+
+	events, err := tasklog.ReadLLMCalls(filepath.Join(dir, "clean_build.jsonl"))
+	if err != nil || len(events) != len(*reqs) {
+		t.Fatalf("expected %d logged R1 calls, got %d (%v)", len(*reqs), len(events), err)
+	}
+	calls := make([]llm.RecordedCall, len(events))
+	for i, e := range events {
+		if e.Role != "perceiver" {
+			t.Errorf("call %d logged as role %q, want perceiver", i, e.Role)
+		}
+		calls[i] = llm.RecordedCall{Role: e.Role, System: e.SystemPrompt, User: e.UserPrompt, Response: e.Response}
+	}
+
+	// Replay the run from R1 with no backend: every R1 call must be served from the log.
+	t.Setenv("OPENAI_BASE_URL", "http://127.0.0.1:1")
+	replayed := llm.New()
+	replay := llm.NewReplay(calls, false)
+	replayed.SetReplay(replay)
+	b := bus.New()
+	specCh := b.Subscribe(types.MsgTaskSpec)
+	pr, err := New(b, replayed, NoClarify, nil).Process(t.Context(), "clean up the project build stuff", "")
+	if err != nil || pr.TaskID != "clean_build" {
+		t.Fatalf("replayed Process = %+v, %v; want task clean_build", pr, err)
+	}
+	if served, remaining, misses := replay.Stats(); served != len(calls) || remaining != 0 || misses != 0 {
+		t.Errorf("replay stats: served %d, remaining %d, misses %d; want all %d served", served, remaining, misses, len(calls))
+	}
+	select {
+	case <-specCh:
+	case <-time.After(time.Second):
+		t.Fatal("expected the replayed TaskSpec on the bus")
+	}
+}
+
+// ── language detection ───────────────────────────────────────────────────────
+
+func TestProcess_RecordsDetectedLanguage(t *testing.T) {
+	// The language detected from a Chinese input reaches the published TaskSpec
+	sequenceLLM(t, `{"task_id":"find_videos","intent":"find the largest video files in Downloads","constraints":{"scope":null,"deadline":null},"raw_input":""}`)
+
+	spec := processNoClarify(t, "查找 ~/Downloads 里最大的视频文件")
+
+	if spec.Language != "zh" {
+		t.Errorf("expected language zh, got %q", spec.Language)
+	}
+}
+
+func TestProcess_KeepsModelLanguage(t *testing.T) {
+	// A language set by the model is not overwritten by detection
+	sequenceLLM(t, `{"task_id":"find_videos","intent":"find videos","constraints":{"scope":null,"deadline":null},"raw_input":"","language":"zh-TW"}`)
+
+	spec := processNoClarify(t, "查找 ~/Downloads 裡最大的影片")
+
+	if spec.Language != "zh-TW" {
+		t.Errorf("expected the model's zh-TW to be kept, got %q", spec.Language)
+	}
+}
+
+// ── tags ─────────────────────────────────────────────────────────────────────
+
+func TestProcess_TagsTaskSpecWithUserAndAutoTags(t *testing.T) {
+	// spec is tagged with the user's tags, then any the model gave, then AutoTags of its intent
+	sequenceLLM(t, `{"task_id":"golang_news","intent":"search the latest Go release news","constraints":{"scope":null,"deadline":null},"raw_input":"","tags":["Weekly Digest"]}`)
+	b := bus.New()
+	specCh := b.Subscribe(types.MsgTaskSpec)
+	p := NewWithTags(b, llm.New(), NoClarify, nil, ParseTags("work, research"))
+
+	pr, err := p.Process(t.Context(), "search the web for the latest Go release news and summarise it", "")
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	want := []string{"work", "research", "weekly-digest"}
+	if !slices.Equal(pr.Tags, want) {
+		t.Errorf("ProcessResult.Tags = %v, want %v", pr.Tags, want)
+	}
+	select {
+	case msg := <-specCh:
+		raw, _ := json.Marshal(msg.Payload)
+		var spec types.TaskSpec
+		json.Unmarshal(raw, &spec)
+		if !slices.Equal(spec.Tags, want) {
+			t.Errorf("TaskSpec.Tags = %v, want %v", spec.Tags, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a TaskSpec on the bus")
+	}
+}
+
+func TestAutoTags(t *testing.T) {
+	// Returns each tag whose keyword list contains a word of intent, sorted; whole words only
+	cases := map[string][]string{
+		"Search the web for Golang news":            {"research"},
+		"find the largest video files in Downloads": {"files", "media"},
+		"run the integration testing suite":         nil,
+		"":                                          nil,
+	}
+	for intent, want := range cases {
+		if got := AutoTags(intent); !slices.Equal(got, want) {
+			t.Errorf("AutoTags(%q) = %v, want %v", intent, got, want)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	// Lowercases, joins inner spaces with "-", drops empty entries and duplicates
+	got := ParseTags(" Research, deep dive,,research ,WORK")
+	if want := []string{"research", "deep-dive", "work"}; !slices.Equal(got, want) {
+		t.Errorf("ParseTags = %v, want %v", got, want)
+	}
+	if got := ParseTags(""); got != nil {
+		t.Errorf("ParseTags(\"\") = %v, want nil", got)
+	}
+}
+
+// ── recordAssumption ─────────────────────────────────────────────────────────
+
+func TestRecordAssumption_KeepsExisting(t *testing.T) {
+	// Keeps the model's own Assumptions unchanged when non-empty
+	spec := recordAssumption(types.TaskSpec{Assumptions: []string{"a"}}, "q?")
+	if len(spec.Assumptions) != 1 || spec.Assumptions[0] != "a" {
+		t.Errorf("expected [a], got %v", spec.Assumptions)
+	}
+}
+
+func TestRecordAssumption_AddsDefault(t *testing.T) {
+	// Adds one assumption quoting the skipped question and the chosen intent when empty
+	spec := recordAssumption(types.TaskSpec{Intent: "list files"}, "Which folder?")
+	if len(spec.Assumptions) != 1 || !strings.Contains(spec.Assumptions[0], `"Which folder?"`) || !strings.Contains(spec.Assumptions[0], "list files") {
+		t.Errorf("unexpected default assumption: %v", spec.Assumptions)
+	}
+}
+
+// ── prompt budget ────────────────────────────────────────────────────────────
+
+func TestFitSession_DropsOldestHistoryLines(t *testing.T) {
+	// Otherwise drops the oldest lines first, noting how many were dropped
+	t.Setenv("ARTOO_PERCEIVER_MAX_PROMPT_TOKENS", "1200")
+	p := New(bus.New(), llm.New(), NoClarify, nil)
+	var lines []string
+	for i := 0; i < 40; i++ {
+		lines = append(lines, fmt.Sprintf("turn %02d: %s", i, strings.Repeat("talk ", 30)))
+	}
+	history := strings.Join(lines, "\n")
+	rest := systemPrompt + "\n\nNew input: list my files"
+	got := p.fitSession(rest, history)
+	if n := llm.EstimateTokens(rest + "Recent session history:\n" + got); n > 1200 {
+		t.Errorf("expected at most 1200 tokens, got %d", n)
+	}
+	if !strings.Contains(got, "turn 39:") || strings.Contains(got, "turn 00:") || !strings.Contains(got, "omitted to fit the prompt budget") {
+		t.Errorf("expected the newest turns kept and the oldest dropped with a note, got:\n%s", got)
+	}
+}
+
+func TestFitSession_UnchangedWithoutBudget(t *testing.T) {
+	// Returns sessionContext unchanged when there is no budget or it fits
+	t.Setenv("ARTOO_MAX_PROMPT_TOKENS", "")
+	p := New(bus.New(), llm.New(), NoClarify, nil)
+	history := strings.Repeat("turn: talk\n", 500)
+	if got := p.fitSession(systemPrompt, history); got != history {
+		t.Error("expected the session history unchanged")
+	}
+}
@@ -0,0 +1,69 @@
+package perceiver
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// autoTagKeywords maps each auto-derived tag to the intent words that earn it.
+var autoTagKeywords = map[string][]string{
+	"research": {"research", "search", "news", "compare", "summarize", "summarise", "article", "articles", "latest", "web"},
+	"files":    {"file", "files", "folder", "folders", "directory", "disk", "pdf", "download", "downloads"},
+	"code":     {"code", "repo", "repository", "build", "test", "tests", "function", "bug", "compile", "commit"},
+	"media":    {"video", "videos", "audio", "music", "song", "songs", "photo", "photos", "image", "images", "mp3", "mp4"},
+	"schedule": {"calendar", "reminder", "reminders", "meeting", "meetings", "schedule", "appointment"},
+	"mail":     {"email", "emails", "mail", "inbox"},
+}
+
+// AutoTags derives category tags from the words of an intent, e.g. "search the
+// latest Go news" → ["research"].
+//
+// Expectations:
+//   - Returns each tag whose keyword list contains a word of intent (case-insensitive)
+//   - Matches whole words only ("testing" does not earn "code" via "test")
+//   - Returns the tags sorted; nil when no keyword matches
+func AutoTags(intent string) []string {
+	words := strings.FieldsFunc(strings.ToLower(intent), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var tags []string
+	for tag, keywords := range autoTagKeywords {
+		for _, w := range words {
+			if slices.Contains(keywords, w) {
+				tags = append(tags, tag)
+				break
+			}
+		}
+	}
+	slices.Sort(tags)
+	return tags
+}
+
+// ParseTags splits a comma-separated --tag value into normalised tags.
+//
+// Expectations:
+//   - Lowercases each tag and joins inner spaces with "-"
+//   - Drops empty entries and duplicates, keeping first-seen order
+func ParseTags(list string) []string {
+	var tags []string
+	for _, t := range strings.Split(list, ",") {
+		tags = mergeTags(tags, normTag(t))
+	}
+	return tags
+}
+
+// normTag lowercases t and joins its words with "-"; "" for a blank tag.
+func normTag(t string) string {
+	return strings.Join(strings.Fields(strings.ToLower(t)), "-")
+}
+
+// mergeTags appends each non-empty tag of add not already in tags.
+func mergeTags(tags []string, add ...string) []string {
+	for _, t := range add {
+		if t != "" && !slices.Contains(tags, t) {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,130 @@
+package planner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/haricheung/agentic-shell/internal/tasklog"
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+// Per-subtask figures used when there is no task-log history yet.
+const (
+	defaultTokensPerSubtask   = 4000
+	defaultMsPerSubtask       = 30000
+	defaultCriteriaPerSubtask = 2
+)
+
+// CostEstimate is R2's forecast of what a plan will cost before it is dispatched.
+type CostEstimate struct {
+	Subtasks   int           // subtasks in the plan
+	Criteria   int           // subtask success criteria plus task-level criteria
+	Tokens     int           // estimated prompt + completion tokens
+	Duration   time.Duration // estimated wall-clock time
+	Historical bool          // false when the defaults stood in for task-log history
+}
+
+// String renders the estimate for the user, e.g. "~8k tokens, ~1m30s for 3 subtasks".
+func (e CostEstimate) String() string {
+	s := fmt.Sprintf("~%s tokens, ~%s for %d subtask", approxTokens(e.Tokens), e.Duration.Round(time.Second), e.Subtasks)
+	if e.Subtasks != 1 {
+		s += "s"
+	}
+	if !e.Historical {
+		s += " (no history yet; default rates)"
+	}
+	return s
+}
+
+// approxTokens rounds n for display: 850 → "850", 8200 → "8.2k", 15400 → "15k".
+func approxTokens(n int) string {
+	switch {
+	case n < 1000:
+		return fmt.Sprintf("%d", n)
+	case n < 10000:
+		return fmt.Sprintf("%.1fk", float64(n)/1000)
+	}
+	return fmt.Sprintf("%.0fk", float64(n)/1000)
+}
+
+// EstimateCost forecasts a plan's token and time cost from its shape and the
+// cross-task history. Each subtask costs one execution unit plus one unit per
+// success criterion R4a must score; a task criterion costs one unit for R4b.
+// The unit is the historical cost per subtask divided by (1 + historical
+// criteria per subtask), so plans shaped like past ones cost what those did.
+//
+// Expectations:
+//   - Returns zero Tokens and Duration for an empty plan
+//   - Tokens and Duration grow linearly with the number of subtasks (same criteria each)
+//   - Tokens and Duration grow with the number of criteria, subtask or task level
+//   - Scales with hist.TokensPerSubtask and hist.MsPerSubtask when hist has subtasks
+//   - Uses the default per-subtask rates (Historical false) when hist is empty
+func EstimateCost(subTasks []types.SubTask, taskCriteria int, hist tasklog.CostHistory) CostEstimate {
+	est := CostEstimate{Subtasks: len(subTasks), Criteria: taskCriteria}
+	for _, st := range subTasks {
+		est.Criteria += len(st.SuccessCriteria)
+	}
+	if len(subTasks) == 0 {
+		return est
+	}
+	perTokens, perMs, perCriteria := float64(defaultTokensPerSubtask), float64(defaultMsPerSubtask), float64(defaultCriteriaPerSubtask)
+	if hist.Subtasks > 0 {
+		perTokens, perMs, perCriteria = hist.TokensPerSubtask, hist.MsPerSubtask, hist.CriteriaPerSubtask
+		est.Historical = true
+	}
+	units := float64(len(subTasks) + est.Criteria)
+	est.Tokens = int(units * perTokens / (1 + perCriteria))
+	est.Duration = time.Duration(units*perMs/(1+perCriteria)) * time.Millisecond
+	return est
+}
+
+// CostPreviewFunc is shown each plan's estimate after planning and before
+// dispatch. Returning false cancels the task instead of dispatching the plan.
+type CostPreviewFunc func(ctx context.Context, taskID string, est CostEstimate) bool
+
+// previewCost estimates subTasks against the task-log history and hands the
+// estimate to the preview hook.
+//
+// Expectations:
+//   - Returns true without estimating when no preview hook is installed
+//   - Returns the hook's answer otherwise
+func (p *Planner) previewCost(ctx context.Context, taskID string, subTasks []types.SubTask, taskCriteria int) bool {
+	if p.costPreview == nil {
+		return true
+	}
+	hist, err := tasklog.HistoricalCost(p.logReg.Dir())
+	if err != nil {
+		slog.Warn("[R2] could not read task-log history for cost estimate", "error", err)
+	}
+	est := EstimateCost(subTasks, taskCriteria, hist)
+	slog.Info("[R2] plan cost estimate", "task", taskID, "subtasks", est.Subtasks, "criteria", est.Criteria,
+		"tokens", est.Tokens, "duration", est.Duration, "historical", est.Historical)
+	return p.costPreview(ctx, taskID, est)
+}
+
+// publishCostDeclined ends a task whose plan the user declined at the cost
+// preview: the log closes as "cancelled" and the FinalResult carries CancelCost.
+func (p *Planner) publishCostDeclined(spec types.TaskSpec) {
+	p.logReg.Close(spec.TaskID, "cancelled")
+	fr := types.FinalResult{
+		TaskID:       spec.TaskID,
+		Summary:      "Plan not run: the estimated cost was declined.",
+		Directive:    "cancelled",
+		CancelReason: types.CancelCost,
+		Assumptions:  spec.Assumptions,
+	}
+	p.b.Publish(types.Message{
+		ID:        uuid.New().String(),
+		Timestamp: time.Now().UTC(),
+		From:      types.RolePlanner,
+		To:        types.RoleUser,
+		Type:      types.MsgFinalResult,
+		Payload:   fr,
+	})
+	if p.outputFn != nil {
+		p.outputFn(fr)
+	}
+}
@@ -0,0 +1,113 @@
+package planner
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/tasklog"
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+// subtasksWithCriteria returns n subtasks carrying c success criteria each.
+func subtasksWithCriteria(n, c int) []types.SubTask {
+	out := make([]types.SubTask, n)
+	for i := range out {
+		out[i] = types.SubTask{Intent: "step", SuccessCriteria: make([]string, c), Sequence: i + 1}
+	}
+	return out
+}
+
+func TestEstimateCost_ScalesWithSubtaskCount(t *testing.T) {
+	// Tokens and Duration grow linearly with the number of subtasks (same criteria each)
+	hist := tasklog.CostHistory{Runs: 4, Subtasks: 10, TokensPerSubtask: 3000, MsPerSubtask: 12000, CriteriaPerSubtask: 2}
+	one := EstimateCost(subtasksWithCriteria(1, 2), 0, hist)
+	three := EstimateCost(subtasksWithCriteria(3, 2), 0, hist)
+	if one.Tokens != 3000 || one.Duration != 12*time.Second {
+		t.Errorf("one subtask shaped like history = %d tokens / %v, want 3000 / 12s", one.Tokens, one.Duration)
+	}
+	if three.Tokens != 3*one.Tokens || three.Duration != 3*one.Duration {
+		t.Errorf("three subtasks = %d / %v, want 3× %d / %v", three.Tokens, three.Duration, one.Tokens, one.Duration)
+	}
+	if three.Subtasks != 3 || three.Criteria != 6 {
+		t.Errorf("subtasks/criteria = %d/%d, want 3/6", three.Subtasks, three.Criteria)
+	}
+}
+
+func TestEstimateCost_ReflectsHistoricalAverages(t *testing.T) {
+	// Scales with hist.TokensPerSubtask and hist.MsPerSubtask when hist has subtasks
+	plan := subtasksWithCriteria(2, 1)
+	cheap := EstimateCost(plan, 1, tasklog.CostHistory{Runs: 1, Subtasks: 5, TokensPerSubtask: 1000, MsPerSubtask: 5000, CriteriaPerSubtask: 1})
+	costly := EstimateCost(plan, 1, tasklog.CostHistory{Runs: 1, Subtasks: 5, TokensPerSubtask: 4000, MsPerSubtask: 20000, CriteriaPerSubtask: 1})
+	if !cheap.Historical || !costly.Historical {
+		t.Fatal("expected estimates from history")
+	}
+	if costly.Tokens != 4*cheap.Tokens || costly.Duration != 4*cheap.Duration {
+		t.Errorf("4× historical rates gave %d / %v vs %d / %v", costly.Tokens, costly.Duration, cheap.Tokens, cheap.Duration)
+	}
+	// 2 subtasks + 2 subtask criteria + 1 task criterion = 5 units of 1000/(1+1) tokens
+	if cheap.Tokens != 2500 {
+		t.Errorf("cheap tokens = %d, want 2500", cheap.Tokens)
+	}
+}
+
+func TestEstimateCost_GrowsWithCriteria(t *testing.T) {
+	// Tokens grow with the number of criteria, subtask or task level
+	hist := tasklog.CostHistory{Runs: 1, Subtasks: 4, TokensPerSubtask: 3000, MsPerSubtask: 9000, CriteriaPerSubtask: 2}
+	base := EstimateCost(subtasksWithCriteria(2, 1), 0, hist)
+	more := EstimateCost(subtasksWithCriteria(2, 3), 0, hist)
+	withTask := EstimateCost(subtasksWithCriteria(2, 1), 2, hist)
+	if more.Tokens <= base.Tokens || withTask.Tokens <= base.Tokens {
+		t.Errorf("criteria should add cost: base %d, more subtask criteria %d, task criteria %d", base.Tokens, more.Tokens, withTask.Tokens)
+	}
+}
+
+func TestEstimateCost_DefaultsWithoutHistory(t *testing.T) {
+	// Uses the default per-subtask rates (Historical false) when hist is empty
+	est := EstimateCost(subtasksWithCriteria(1, defaultCriteriaPerSubtask), 0, tasklog.CostHistory{})
+	if est.Historical || est.Tokens != defaultTokensPerSubtask || est.Duration != defaultMsPerSubtask*time.Millisecond {
+		t.Errorf("got %+v, want the default rates", est)
+	}
+	if empty := EstimateCost(nil, 0, tasklog.CostHistory{}); empty.Tokens != 0 || empty.Duration != 0 {
+		t.Errorf("empty plan = %+v, want zero cost", empty)
+	}
+}
+
+func TestEmitSubTasks_DeclinedCostPreviewCancelsTask(t *testing.T) {
+	// A declined estimate ends the task with CancelReason "cost" and dispatches nothing
+	b := bus.New()
+	subTaskCh := b.Subscribe(types.MsgSubTask)
+	logReg := tasklog.NewRegistry(filepath.Join(t.TempDir(), "tasks"))
+	tl := logReg.Open("t1", "find videos")
+	results := make(chan types.FinalResult, 1)
+	var seen CostEstimate
+	p := NewWithCostPreview(b, nil, logReg, nil, func(fr types.FinalResult) { results <- fr },
+		func(_ context.Context, _ string, est CostEstimate) bool { seen = est; return false })
+	spec := types.TaskSpec{TaskID: "t1", Intent: "find videos"}
+	raw := `{"task_criteria":["paths listed"],"subtasks":[{"intent":"search for mp4 files","success_criteria":["mp4 paths"],"sequence":1}]}`
+
+	if err := p.emitSubTasks(t.Context(), spec, raw, "", tl, 0); err != nil {
+		t.Fatal(err)
+	}
+	if seen.Subtasks != 1 || seen.Criteria != 2 || seen.Tokens == 0 {
+		t.Errorf("preview got %+v, want 1 subtask, 2 criteria, nonzero tokens", seen)
+	}
+	select {
+	case fr := <-results:
+		if fr.Directive != "cancelled" || fr.CancelReason != types.CancelCost {
+			t.Errorf("expected a cancelled result with reason cost, got %+v", fr)
+		}
+	default:
+		t.Fatal("expected a FinalResult after the declined preview")
+	}
+	select {
+	case msg := <-subTaskCh:
+		t.Errorf("declined plan must not dispatch, got %+v", msg.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if logReg.Get("t1") != nil {
+		t.Error("expected the task log to be closed")
+	}
+}
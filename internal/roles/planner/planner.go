@@ -3,10 +3,16 @@ package planner
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math"
+	"os"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -31,6 +37,8 @@ Sequence rules (critical):
   Example: sequence=1 "locate file", sequence=2 "extract audio from located file".
   The dispatcher injects the outputs of sequence N into every sequence N+1 subtask's context automatically.
 - Start sequence numbering at 1.
+- Optional "priority" (integer, default 0): within one sequence, higher-priority subtasks start first when parallel slots are limited. Raise it for cheap discovery steps the others may benefit from.
+- Optional "max_retries" (integer, 0 to 3): how many failed attempts the validator allows before giving up on this subtask. Omit it to keep the defaults (2, or 3 for network/timeout failures). Set 3 for subtasks expected to need more attempts (flaky web searches, rate-limited APIs); set 0 for steps that must not be repeated. Larger values count as 3.
 
 Context field rules:
 - Always populate context with everything the executor needs beyond the intent: known file paths, format requirements, constraints, relevant memory.
@@ -56,6 +64,7 @@ Task criteria rules (critical):
 Memory constraint rules (when a MEMORY CONSTRAINTS block is present):
 - Every "MUST NOT" line records an approach that failed before for a similar task. You MUST NOT use that approach regardless of how promising it seems.
 - Every "SHOULD PREFER" line records an approach that worked before. Prefer it over untested alternatives.
+- Every "CAUTION" line is advisory: memory is mixed on it. You may still use that approach, but plan an explicit verification step for it.
 
 When ready to finalise, output ONLY this JSON object (no markdown, no prose):
 {
@@ -112,19 +121,55 @@ type Planner struct {
 	b        *bus.Bus
 	logReg   *tasklog.Registry
 	mem      types.MemoryService // R5; may be nil (memory disabled)
-	outputFn func(taskID, summary string, output any)
+	outputFn func(types.FinalResult)
+
+	mu        sync.Mutex
+	lastPlan  map[string][]types.SubTask // taskID → subtasks of the most recent round, for PlanDiff
+	lastRound map[string]int             // taskID → PlanDirective.Round most recently planned; reset by a new TaskSpec
+	terminal  map[string]bool            // taskIDs whose FinalResult has been published; reset by a new TaskSpec
+
+	maxPromptTokens int             // bounds each planning prompt's estimated size; 0 means no bound
+	recencyHalfLife time.Duration   // >0 weights recent successes exponentially; see memoryRecencyHalfLife
+	costPreview     CostPreviewFunc // nil dispatches every plan without a cost preview
+	// maxSubtasks is the soft plan-size limit: a larger plan is sent back once to
+	// be consolidated. maxSubtasksHard rejects a plan outright. 0 disables either.
+	maxSubtasks, maxSubtasksHard int
 }
 
 // New creates a Planner. mem may be nil to disable MKCT memory queries (e.g. in tests).
-func New(b *bus.Bus, llmClient *llm.Client, logReg *tasklog.Registry, mem types.MemoryService, outputFn func(taskID, summary string, output any)) *Planner {
-	return &Planner{llm: llmClient, b: b, logReg: logReg, mem: mem, outputFn: outputFn}
+func New(b *bus.Bus, llmClient *llm.Client, logReg *tasklog.Registry, mem types.MemoryService, outputFn func(types.FinalResult)) *Planner {
+	return &Planner{
+		llm:             llmClient.ForRole("planner"),
+		b:               b,
+		logReg:          logReg,
+		mem:             mem,
+		outputFn:        outputFn,
+		lastPlan:        make(map[string][]types.SubTask),
+		lastRound:       make(map[string]int),
+		terminal:        make(map[string]bool),
+		maxPromptTokens: llm.MaxPromptTokens("planner"),
+		recencyHalfLife: memoryRecencyHalfLife(),
+		maxSubtasks:     subtaskLimitFromEnv("ARTOO_MAX_SUBTASKS", defaultMaxSubtasks),
+		maxSubtasksHard: subtaskLimitFromEnv("ARTOO_MAX_SUBTASKS_HARD", defaultMaxSubtasksHard),
+	}
+}
+
+// NewWithCostPreview creates a Planner that estimates each plan's cost from the
+// task-log history and passes it to preview before dispatch; a false answer
+// cancels the task with CancelReason "cost" instead of dispatching.
+func NewWithCostPreview(b *bus.Bus, llmClient *llm.Client, logReg *tasklog.Registry, mem types.MemoryService, outputFn func(types.FinalResult), preview CostPreviewFunc) *Planner {
+	p := New(b, llmClient, logReg, mem, outputFn)
+	p.costPreview = preview
+	return p
 }
 
-// Run listens for TaskSpec and PlanDirective messages.
+// Run listens for TaskSpec and PlanDirective messages, and for FinalResult so a
+// directive that arrives after its task has ended is dropped.
 // Memory is queried synchronously via direct calls to R5 (no bus round-trip).
 func (p *Planner) Run(ctx context.Context) {
 	taskSpecCh := p.b.Subscribe(types.MsgTaskSpec)
 	directiveCh := p.b.Subscribe(types.MsgPlanDirective)
+	finalCh := p.b.Subscribe(types.MsgFinalResult)
 
 	var currentSpec *types.TaskSpec
 
@@ -144,10 +189,16 @@ func (p *Planner) Run(ctx context.Context) {
 			}
 			slog.Info("[R2] received TaskSpec", "task", spec.TaskID)
 			currentSpec = &spec
+			// acceptDirective only admits directives for the current task, so the
+			// marks of earlier tasks are dead weight once a new one starts.
+			p.mu.Lock()
+			clear(p.terminal)
+			clear(p.lastRound)
+			p.mu.Unlock()
 			go func(s types.TaskSpec) {
 				if err := p.plan(ctx, s); err != nil {
 					slog.Error("[R2] planning failed", "error", err)
-					p.publishAbandon(s.TaskID, fmt.Sprintf("R2 planning failed: %v", err))
+					p.publishAbandon(s, fmt.Sprintf("R2 planning failed: %v", err))
 				}
 			}(spec)
 
@@ -166,40 +217,89 @@ func (p *Planner) Run(ctx context.Context) {
 				slog.Warn("[R2] PlanDirective received but no current TaskSpec")
 				continue
 			}
+			if !p.acceptDirective(currentSpec.TaskID, pd) {
+				continue
+			}
 			spec := *currentSpec
 			go func(s types.TaskSpec, directive types.PlanDirective) {
 				if err := p.replanWithDirective(ctx, s, directive); err != nil {
 					slog.Error("[R2] replanning failed", "error", err)
-					p.publishAbandon(s.TaskID, fmt.Sprintf("R2 replanning failed: %v", err))
+					p.publishAbandon(s, fmt.Sprintf("R2 replanning failed: %v", err))
 				}
 			}(spec, pd)
+
+		case msg, ok := <-finalCh:
+			if !ok {
+				return
+			}
+			fr, err := toFinalResult(msg.Payload)
+			if err != nil {
+				slog.Error("[R2] bad FinalResult payload", "error", err)
+				continue
+			}
+			p.mu.Lock()
+			p.terminal[fr.TaskID] = true
+			delete(p.lastRound, fr.TaskID)
+			delete(p.lastPlan, fr.TaskID)
+			p.mu.Unlock()
 		}
 	}
 }
 
-func (p *Planner) publishAbandon(taskID, reason string) {
-	summary := "❌ " + reason
+// acceptDirective reports whether pd should be planned against the current task,
+// recording its round when it is. Directives can arrive out of order, so this is
+// the last guard against replanning a task GGS or R4b has already ended.
+//
+// Expectations:
+//   - Returns false when pd targets a task other than currentTaskID
+//   - Returns false when a FinalResult for the task has already been seen
+//   - Returns false when pd.Round is at or below the last round planned (stale or duplicate)
+//   - Accepts unstamped directives (Round 0) for a live task
+func (p *Planner) acceptDirective(currentTaskID string, pd types.PlanDirective) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case pd.TaskID != currentTaskID:
+		slog.Warn("[R2] dropping PlanDirective for a task that is not current", "task", pd.TaskID, "current", currentTaskID)
+		return false
+	case p.terminal[pd.TaskID]:
+		slog.Warn("[R2] dropping PlanDirective for a terminal task", "task", pd.TaskID, "round", pd.Round)
+		return false
+	case pd.Round > 0 && pd.Round <= p.lastRound[pd.TaskID]:
+		slog.Warn("[R2] dropping stale PlanDirective", "task", pd.TaskID, "round", pd.Round, "last", p.lastRound[pd.TaskID])
+		return false
+	}
+	if pd.Round > 0 {
+		p.lastRound[pd.TaskID] = pd.Round
+	}
+	return true
+}
+
+// publishAbandon ends spec's task when R2 cannot plan it, carrying R1's assumptions.
+func (p *Planner) publishAbandon(spec types.TaskSpec, reason string) {
+	fr := types.FinalResult{
+		TaskID:      spec.TaskID,
+		Summary:     "❌ " + reason,
+		Directive:   "abandon",
+		Assumptions: spec.Assumptions,
+	}
 	p.b.Publish(types.Message{
 		ID:        uuid.New().String(),
 		Timestamp: time.Now().UTC(),
 		From:      types.RolePlanner,
 		To:        types.RoleUser,
 		Type:      types.MsgFinalResult,
-		Payload: types.FinalResult{
-			TaskID:    taskID,
-			Summary:   summary,
-			Directive: "abandon",
-		},
+		Payload:   fr,
 	})
 	// Deliver to resultCh so the REPL/one-shot handler unblocks.
 	if p.outputFn != nil {
-		p.outputFn(taskID, summary, nil)
+		p.outputFn(fr)
 	}
 }
 
 func (p *Planner) plan(ctx context.Context, spec types.TaskSpec) error {
 	// Open (or retrieve existing) task log — idempotent across replan rounds.
-	tl := p.logReg.Open(spec.TaskID, spec.Intent)
+	tl := p.logReg.Open(spec.TaskID, spec.Intent, spec.Tags...)
 
 	specJSON, _ := json.MarshalIndent(spec, "", "  ")
 	constraints := p.queryMKCTConstraints(ctx, spec.TaskID, tl)
@@ -213,7 +313,7 @@ func (p *Planner) plan(ctx context.Context, spec types.TaskSpec) error {
 	} else {
 		userPrompt = fmt.Sprintf("Today's date: %s\n\nTaskSpec:\n%s", today, specJSON)
 	}
-	return p.dispatch(ctx, spec, userPrompt, systemPrompt, tl)
+	return p.dispatch(ctx, spec, userPrompt, p.llm.SystemPrompt(systemPrompt), "", tl)
 }
 
 // replanWithDirective is called when R2 receives a PlanDirective from GGS (v0.7+).
@@ -260,7 +360,7 @@ func (p *Planner) replanWithDirective(ctx context.Context, spec types.TaskSpec,
 
 	today := time.Now().UTC().Format("2006-01-02")
 	userPrompt := "Today's date: " + today + "\n\n" + fmt.Sprintf(planDirectivePrompt, pdJSON, specJSON, constraints)
-	return p.dispatch(ctx, spec, userPrompt, systemPrompt, tl)
+	return p.dispatch(ctx, spec, userPrompt, p.llm.SystemPrompt(systemPrompt), pd.Directive, tl)
 }
 
 // queryMKCTConstraints queries R5 for the given taskID and returns a formatted
@@ -272,11 +372,16 @@ func (p *Planner) replanWithDirective(ctx context.Context, spec types.TaskSpec,
 // Expectations:
 //   - Returns "" when p.mem is nil
 //   - Returns "" when QueryC and QueryMK both return empty/Ignore results
+//   - Queries the task space and "global:user" through calibrate: one round-trip each
+//     when memory implements QueryCalibration
 //   - Derives space tag as "intent:"+taskID; entity as "env:local"
 //   - Includes "SHOULD PREFER" block when Action is Exploit
 //   - Includes "MUST NOT" block when Action is Avoid
 //   - Includes "CAUTION" block when Action is Caution
-//   - Appends C-level SOPs as "SHOULD PREFER" (σ>0) or "MUST NOT" (σ<0) lines
+//   - Appends C-level SOPs as "SHOULD PREFER" (σ>0) or "MUST NOT" (σ<0) lines,
+//     strongest first as ordered by weightedSOPs over both spaces' potentials
+//   - Tags recent successes by recency weight (boostRecentMegrams) when recencyHalfLife > 0
+//   - Prepends the intent's historical success rate (intentStatBlock) when memory tracks one
 //   - Logs a memory_query event to tl after computing constraints
 func (p *Planner) queryMKCTConstraints(ctx context.Context, taskID string, tl *tasklog.TaskLog) string {
 	if p.mem == nil {
@@ -286,28 +391,28 @@ func (p *Planner) queryMKCTConstraints(ctx context.Context, taskID string, tl *t
 	entity := "env:local"
 
 	// Task-specific query (existing behavior).
-	sops, err1 := p.mem.QueryC(ctx, space, entity)
-	pots, err2 := p.mem.QueryMK(ctx, space, entity)
-	recent, err3 := p.mem.QueryRecent(ctx, space, entity, 3)
-	if err1 != nil {
-		slog.Warn("[R2] QueryC failed", "error", err1)
-	}
-	if err2 != nil {
-		slog.Warn("[R2] QueryMK failed", "error", err2)
-	}
-	if err3 != nil {
-		slog.Warn("[R2] QueryRecent failed", "error", err3)
-	}
+	task := p.calibrate(ctx, space, entity)
+	pots := task.Potentials
 
 	// Global space query — always check "global:user" for cross-cutting memories
 	// (persona, preferences, environment facts). These are recalled on EVERY task
 	// regardless of task_id. Injected via /remember or future MKCT v2 auto-promotion.
-	globalSOPs, _ := p.mem.QueryC(ctx, "global:user", entity)
-	globalRecent, _ := p.mem.QueryRecent(ctx, "global:user", entity, 3)
-	sops = append(sops, globalSOPs...)
-	recent = append(recent, globalRecent...)
+	// Its potentials weight its SOPs against the task's; only the task's drive Action.
+	global := p.calibrate(ctx, "global:user", entity)
+	sops := weightedSOPs(task, global)
+	recent := append(task.Recent, global.Recent...)
+	if p.recencyHalfLife > 0 {
+		recent = boostRecentMegrams(recent, time.Now(), p.recencyHalfLife)
+	}
 
 	constraints := calibrateMKCT(sops, pots, recent)
+	if block := p.intentStatBlock(space); block != "" {
+		if constraints == "" {
+			constraints = block
+		} else {
+			constraints = block + "\n" + constraints
+		}
+	}
 	tl.MemoryQuery(space, entity, len(sops), pots.Action, pots.Attention, pots.Decision)
 	slog.Info("[R2] memory query",
 		"space", space,
@@ -346,6 +451,117 @@ func (p *Planner) queryMKCTConstraints(ctx context.Context, taskID string, tl *t
 	return constraints
 }
 
+// recentPerSpace is how many recent M/K-level Megrams R2 recalls per tag pair.
+const recentPerSpace = 3
+
+// calibrator is implemented by memory stores that answer QueryC, QueryMK, and
+// QueryRecent for one tag pair in a single round-trip.
+type calibrator interface {
+	QueryCalibration(ctx context.Context, space, entity string, n int) (types.CalibrationBundle, error)
+}
+
+// calibrate gathers the SOPs, potentials, and recent Megrams for (space, entity)
+// — with one QueryCalibration call when p.mem supports it, else with QueryC,
+// QueryMK, and QueryRecent. Failures are logged and leave their part empty.
+//
+// Expectations:
+//   - Uses QueryCalibration exclusively when p.mem implements it
+//   - Falls back to QueryC, QueryMK, and QueryRecent otherwise
+//   - Returns at most recentPerSpace recent Megrams
+func (p *Planner) calibrate(ctx context.Context, space, entity string) types.CalibrationBundle {
+	if c, ok := p.mem.(calibrator); ok {
+		b, err := c.QueryCalibration(ctx, space, entity, recentPerSpace)
+		if err != nil {
+			slog.Warn("[R2] QueryCalibration failed", "space", space, "error", err)
+		}
+		return b
+	}
+	var b types.CalibrationBundle
+	var err error
+	if b.SOPs, err = p.mem.QueryC(ctx, space, entity); err != nil {
+		slog.Warn("[R2] QueryC failed", "space", space, "error", err)
+	}
+	if b.Potentials, err = p.mem.QueryMK(ctx, space, entity); err != nil {
+		slog.Warn("[R2] QueryMK failed", "space", space, "error", err)
+	}
+	if b.Recent, err = p.mem.QueryRecent(ctx, space, entity, recentPerSpace); err != nil {
+		slog.Warn("[R2] QueryRecent failed", "space", space, "error", err)
+	}
+	return b
+}
+
+// weightedSOPs merges the SOPs of bundles, strongest first. An SOP's weight is
+// |σ| times the attention potential of the space it came from, so the rules of a
+// space with a strong, fresh memory signal lead their heading in calibrateMKCT.
+//
+// Expectations:
+//   - Returns every SOP of every bundle
+//   - Orders SOPs by |σ|·Attention of their bundle, descending
+//   - Keeps bundle order, then each bundle's own order, among equal weights
+func weightedSOPs(bundles ...types.CalibrationBundle) []types.SOPRecord {
+	type weighted struct {
+		sop    types.SOPRecord
+		weight float64
+	}
+	var all []weighted
+	for _, b := range bundles {
+		for _, sop := range b.SOPs {
+			all = append(all, weighted{sop, math.Abs(sop.Sigma) * b.Potentials.Attention})
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].weight > all[j].weight })
+	sops := make([]types.SOPRecord, len(all))
+	for i, w := range all {
+		sops[i] = w.sop
+	}
+	return sops
+}
+
+// Intent success-rate thresholds: the statistic is shown once an intent has
+// minIntentStatRuns terminal outcomes, and below lowIntentSuccessRate R2 is told
+// to plan conservatively.
+const (
+	minIntentStatRuns    = 3
+	lowIntentSuccessRate = 0.5
+)
+
+// intentStatser is implemented by memory stores that keep per-intent outcome counts.
+type intentStatser interface {
+	IntentStats(space string) (types.IntentStats, error)
+}
+
+// intentStatBlock returns the historical success-rate line for space, or "" when
+// the memory store keeps no statistic or the intent has too few recorded runs.
+//
+// Expectations:
+//   - Returns "" when p.mem does not implement IntentStats or the lookup fails
+//   - Returns "" when fewer than minIntentStatRuns outcomes are recorded
+//   - States accepts, runs, and the rate as a percentage otherwise
+//   - Below lowIntentSuccessRate, adds a LOW SUCCESS instruction to decompose
+//     conservatively and add verification subtasks
+func (p *Planner) intentStatBlock(space string) string {
+	ms, ok := p.mem.(intentStatser)
+	if !ok {
+		return ""
+	}
+	st, err := ms.IntentStats(space)
+	if err != nil {
+		slog.Warn("[R2] IntentStats failed", "space", space, "error", err)
+		return ""
+	}
+	if st.Runs() < minIntentStatRuns {
+		return ""
+	}
+	rate := st.SuccessRate()
+	block := fmt.Sprintf("HISTORICAL SUCCESS RATE for this intent: %d of %d past runs accepted (%.0f%%).",
+		st.Accepts, st.Runs(), rate*100)
+	if rate < lowIntentSuccessRate {
+		block += "\nLOW SUCCESS: this intent usually fails. Decompose conservatively into small, " +
+			"individually checkable steps and add an explicit verification subtask for the final result."
+	}
+	return block
+}
+
 // calibrateMKCT builds a planning constraint string from MKCT query results.
 // Injects three layers in priority order:
 //  1. C-level SOPs (Dreamer-distilled rules) — highest authority
@@ -356,6 +572,10 @@ func (p *Planner) queryMKCTConstraints(ctx context.Context, taskID string, tl *t
 // (which includes tools used, commands run, and outcomes) is injected directly so R2
 // has concrete evidence from the first warm run, without waiting for Dreamer promotion.
 //
+// The potential's action also grades how strongly layer 2 is worded (see
+// recentHeadings): under Avoid, failures are hard MUST NOTs; under Caution, recent
+// experience is only advisory; under Exploit, successes are strong SHOULD PREFERs.
+//
 // Expectations:
 //   - Returns "" when sops is empty, recent is empty, and pots.Action is "Ignore"
 //   - Includes "SHOULD PREFER" block when pots.Action is "Exploit"
@@ -365,6 +585,9 @@ func (p *Planner) queryMKCTConstraints(ctx context.Context, taskID string, tl *t
 //   - Non-positive-σ SOPs appear under "MUST NOT (proven constraints)"
 //   - Recent success Megrams (state=accept/success) injected under "SHOULD PREFER (recent experience)"
 //   - Recent failure Megrams (state=abandon) injected under "MUST NOT (recent experience)"
+//   - Recent successes are ordered and annotated by preferCheaper; competing costs add
+//     "where they compete, prefer the cheapest, listed first" to their heading
+//   - Recent Megram headings are graded by pots.Action as described by recentHeadings
 func calibrateMKCT(sops []types.SOPRecord, pots types.Potentials, recent []types.Megram) string {
 	var sb strings.Builder
 
@@ -397,24 +620,29 @@ func calibrateMKCT(sops []types.SOPRecord, pots types.Potentials, recent []types
 	// Layer 2 — Recent M/K Megram content (raw past experience injected directly).
 	// Success states (accept/success): inject under SHOULD PREFER.
 	// Failure states (abandon): inject under MUST NOT.
-	var recentSuccess, recentFailure []string
+	var successes []costedApproach
+	var recentFailure []string
 	for _, m := range recent {
 		if m.Content == "" {
 			continue
 		}
-		line := "  - " + m.Content
 		switch m.State {
 		case "accept", "success":
-			recentSuccess = append(recentSuccess, line)
+			successes = append(successes, costedApproach{summary: m.Content, cost: m.Cost})
 		case "abandon":
-			recentFailure = append(recentFailure, line)
+			recentFailure = append(recentFailure, "  - "+m.Content)
 		}
 	}
+	recentSuccess, competing := preferCheaper(successes)
+	failHeading, successHeading := recentHeadings(pots)
+	if competing {
+		successHeading = strings.TrimSuffix(successHeading, "):") + "; where they compete, prefer the cheapest, listed first):"
+	}
 	if len(recentFailure) > 0 {
 		if sb.Len() > 0 {
 			sb.WriteString("\n")
 		}
-		sb.WriteString("MUST NOT (recent experience — these approaches failed):\n")
+		sb.WriteString(failHeading + "\n")
 		for _, c := range recentFailure {
 			sb.WriteString(c + "\n")
 		}
@@ -423,7 +651,7 @@ func calibrateMKCT(sops []types.SOPRecord, pots types.Potentials, recent []types
 		if sb.Len() > 0 {
 			sb.WriteString("\n")
 		}
-		sb.WriteString("SHOULD PREFER (recent experience — these approaches succeeded):\n")
+		sb.WriteString(successHeading + "\n")
 		for _, c := range recentSuccess {
 			sb.WriteString(c + "\n")
 		}
@@ -434,13 +662,13 @@ func calibrateMKCT(sops []types.SOPRecord, pots types.Potentials, recent []types
 	if sb.Len() == 0 {
 		switch pots.Action {
 		case "Exploit":
-			sb.WriteString("SHOULD PREFER (memory signal: this task class succeeded previously):\n")
+			fmt.Fprintf(&sb, "SHOULD PREFER (strong — memory signal Exploit, decision %+.2f: this task class succeeded previously):\n", pots.Decision)
 			sb.WriteString("  - Follow the same general approach that succeeded previously.\n")
 		case "Avoid":
-			sb.WriteString("MUST NOT (memory signal: this task class consistently failed):\n")
+			fmt.Fprintf(&sb, "MUST NOT (hard constraint — memory signal Avoid, decision %+.2f: this task class consistently failed):\n", pots.Decision)
 			sb.WriteString("  - Do not repeat the approach that failed previously.\n")
 		case "Caution":
-			sb.WriteString("CAUTION (memory signal: mixed results for this task class):\n")
+			sb.WriteString("CAUTION (advisory — memory signal Caution: mixed results for this task class):\n")
 			sb.WriteString("  - Validate each step carefully before committing.\n")
 		}
 	}
@@ -448,6 +676,31 @@ func calibrateMKCT(sops []types.SOPRecord, pots types.Potentials, recent []types
 	return sb.String()
 }
 
+// recentHeadings returns the headings for recent failure and success Megrams,
+// worded by how strongly the dual-channel potential backs them.
+//
+// Expectations:
+//   - Avoid: failures under a hard "MUST NOT" heading citing the decision potential
+//   - Caution: failures and successes both under advisory "CAUTION" headings
+//   - Exploit: successes under a strong "SHOULD PREFER" heading citing the decision potential;
+//     failures under an advisory "CAUTION" heading
+//   - Ignore (or anything else): plain "MUST NOT (recent experience" / "SHOULD PREFER (recent experience" headings
+func recentHeadings(pots types.Potentials) (failure, success string) {
+	failure = "MUST NOT (recent experience — these approaches failed):"
+	success = "SHOULD PREFER (recent experience — these approaches succeeded):"
+	switch pots.Action {
+	case "Avoid":
+		failure = fmt.Sprintf("MUST NOT (hard constraint — memory signal Avoid, decision %+.2f; recent experience — these approaches failed):", pots.Decision)
+	case "Caution":
+		failure = "CAUTION (advisory — memory signal Caution, mixed results; recent experience — these approaches failed before):"
+		success = "CAUTION (advisory — memory signal Caution, mixed results; recent experience — these approaches succeeded before, but not reliably):"
+	case "Exploit":
+		failure = "CAUTION (advisory — this task class mostly succeeds; recent experience — these approaches failed before):"
+		success = fmt.Sprintf("SHOULD PREFER (strong — memory signal Exploit, decision %+.2f; recent experience — these approaches succeeded):", pots.Decision)
+	}
+	return failure, success
+}
+
 // calibrate implements Steps 1–3 of the Memory Calibration Protocol.
 // Step 1 — Retrieve: caller provides entries already fetched from R5 (no LLM call).
 // Step 2 — Calibrate: sort by recency (newest first), cap at maxMemoryEntries,
@@ -464,12 +717,109 @@ func calibrateMKCT(sops []types.SOPRecord, pots types.Potentials, recent []types
 //   - Drops entries with zero keyword overlap against intent (>= 3-char words)
 //   - Returns "" when all entries are filtered by keyword or have unknown type
 //   - Procedural entries appear under "MUST NOT" heading
-//   - Episodic entries appear under "SHOULD PREFER" heading
+//   - Episodic entries appear under "SHOULD PREFER" heading, ordered and annotated by preferCheaper
 func calibrate(entries []types.MemoryEntry, intent string) string {
-	if len(entries) == 0 {
+	relevant := relevantEntries(entries, intent)
+	if len(relevant) == 0 {
 		return ""
 	}
 
+	// Step 3 — derive constraint lines
+	var mustNots []string
+	var episodic []costedApproach
+	for _, e := range relevant {
+		switch e.Type {
+		case "procedural":
+			mustNots = append(mustNots, "  - "+entrySummary(e))
+		case "episodic":
+			episodic = append(episodic, costedApproach{summary: entrySummary(e), cost: e.Cost})
+		}
+	}
+	shouldPrefers, competing := preferCheaper(episodic)
+
+	var sb strings.Builder
+	if len(mustNots) > 0 {
+		sb.WriteString("MUST NOT (prior failures — do not repeat these approaches):\n")
+		for _, c := range mustNots {
+			sb.WriteString(c + "\n")
+		}
+	}
+	if len(shouldPrefers) > 0 {
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		if competing {
+			sb.WriteString("SHOULD PREFER (prior successes — these approaches worked; where they compete, prefer the cheapest, listed first):\n")
+		} else {
+			sb.WriteString("SHOULD PREFER (prior successes — these approaches worked):\n")
+		}
+		for _, c := range shouldPrefers {
+			sb.WriteString(c + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// costedApproach is a prior success as shown under SHOULD PREFER, with the
+// cost recorded for it (nil when unknown).
+type costedApproach struct {
+	summary string
+	cost    *types.TaskCost
+}
+
+// preferCheaper renders prior successes as SHOULD PREFER lines, each annotated
+// with its recorded cost. When two or more carry a cost they compete: the
+// cheapest moves to the front and is marked, so R2 leans towards the efficient
+// known-good approach. Approaches keep their order otherwise.
+//
+// Expectations:
+//   - Appends "(this approach previously succeeded using ~N tokens, M tool calls)" when cost is set
+//   - Leaves order and wording alone (competing false) when fewer than two approaches have a cost
+//   - Otherwise sorts costed approaches by ascending total tokens, ahead of uncosted ones,
+//     and prefixes the first with "[cheapest known approach]"
+func preferCheaper(approaches []costedApproach) (lines []string, competing bool) {
+	costed := 0
+	for _, a := range approaches {
+		if a.cost != nil && a.cost.TotalTokens > 0 {
+			costed++
+		}
+	}
+	ordered := approaches
+	if costed >= 2 {
+		competing = true
+		ordered = slices.Clone(approaches)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return approachTokens(ordered[i]) < approachTokens(ordered[j])
+		})
+	}
+	for i, a := range ordered {
+		line := "  - " + a.summary
+		if competing && i == 0 {
+			line = "  - [cheapest known approach] " + a.summary
+		}
+		if a.cost != nil && a.cost.TotalTokens > 0 {
+			line += fmt.Sprintf(" (this approach previously succeeded using ~%s tokens, %d tool calls)", approxTokens(a.cost.TotalTokens), a.cost.ToolCallCount)
+		}
+		lines = append(lines, line)
+	}
+	return lines, competing
+}
+
+// approachTokens is a's recorded token cost for ordering; approaches without one sort last.
+func approachTokens(a costedApproach) int {
+	if a.cost == nil || a.cost.TotalTokens <= 0 {
+		return math.MaxInt
+	}
+	return a.cost.TotalTokens
+}
+
+// relevantEntries is Step 2 of the Memory Calibration Protocol: entries sorted
+// newest first, capped at maxMemoryEntries, and keyword-filtered against intent.
+func relevantEntries(entries []types.MemoryEntry, intent string) []types.MemoryEntry {
+	if len(entries) == 0 {
+		return nil
+	}
+
 	// Step 2 — sort newest first (ISO8601 timestamps sort lexicographically)
 	sorted := make([]types.MemoryEntry, len(entries))
 	copy(sorted, entries)
@@ -493,39 +843,83 @@ func calibrate(entries []types.MemoryEntry, intent string) string {
 			}
 		}
 	}
-	if len(relevant) == 0 {
-		return ""
+	return relevant
+}
+
+// memoryRecencyHalfLife reads ARTOO_MEMORY_RECENCY_HALFLIFE (a Go duration such
+// as "72h"). When set, the planner's recency-boost mode weights each past success
+// by 0.5^(age/halfLife), so in a fast-changing environment what worked most
+// recently outranks older — even more numerous — successes. Zero disables it.
+//
+// Expectations:
+//   - Returns the parsed duration when it is positive
+//   - Returns 0 when unset, unparseable, or non-positive
+func memoryRecencyHalfLife() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("ARTOO_MEMORY_RECENCY_HALFLIFE"))
+	if err != nil || d <= 0 {
+		return 0
 	}
+	return d
+}
 
-	// Step 3 — derive constraint lines
-	var mustNots, shouldPrefers []string
-	for _, e := range relevant {
-		line := "  - " + entrySummary(e)
-		switch e.Type {
-		case "procedural":
-			mustNots = append(mustNots, line)
-		case "episodic":
-			shouldPrefers = append(shouldPrefers, line)
-		}
+// recencyWeight returns 0.5^(age/halfLife) for an RFC3339 timestamp.
+// Unparseable or future timestamps weigh 1 so they are never silently buried.
+//
+// Expectations:
+//   - Returns 1 for a timestamp equal to now
+//   - Returns 0.5 for a timestamp one half-life old
+//   - Returns 1 for unparseable or future timestamps
+func recencyWeight(ts string, now time.Time, halfLife time.Duration) float64 {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil || !t.Before(now) {
+		return 1
 	}
+	return math.Pow(0.5, float64(now.Sub(t))/float64(halfLife))
+}
 
-	var sb strings.Builder
-	if len(mustNots) > 0 {
-		sb.WriteString("MUST NOT (prior failures — do not repeat these approaches):\n")
-		for _, c := range mustNots {
-			sb.WriteString(c + "\n")
+// recencyStrong is the share of the newest success's weight at or above which a
+// success is presented as current; older ones are presented as yielding to it.
+const recencyStrong = 0.5
+
+// boostRecentMegrams is the recency-boost mode for the MKCT recent-experience
+// layer: successes are reordered newest first and tagged with their recency weight
+// relative to the newest success, so calibrateMKCT presents the latest one first
+// and marks older ones as yielding to it. Failures pass through unchanged.
+//
+// Expectations:
+//   - Success Megrams (accept/success) are sorted newest first by CreatedAt
+//   - The newest success is tagged "[most recent success, recency weight 1.00]"
+//   - Successes under recencyStrong of the newest are tagged "older success … yields to newer"
+//   - Non-success Megrams keep their content and relative order
+func boostRecentMegrams(recent []types.Megram, now time.Time, halfLife time.Duration) []types.Megram {
+	var successes, others []types.Megram
+	for _, m := range recent {
+		if m.State == "accept" || m.State == "success" {
+			successes = append(successes, m)
+		} else {
+			others = append(others, m)
 		}
 	}
-	if len(shouldPrefers) > 0 {
-		if sb.Len() > 0 {
-			sb.WriteString("\n")
+	sort.SliceStable(successes, func(i, j int) bool {
+		return recencyWeight(successes[i].CreatedAt, now, halfLife) > recencyWeight(successes[j].CreatedAt, now, halfLife)
+	})
+	top := 0.0
+	for i := range successes {
+		w := recencyWeight(successes[i].CreatedAt, now, halfLife)
+		if i == 0 {
+			top = w
 		}
-		sb.WriteString("SHOULD PREFER (prior successes — these approaches worked):\n")
-		for _, c := range shouldPrefers {
-			sb.WriteString(c + "\n")
+		rel := w / top
+		tag := fmt.Sprintf("[recency weight %.2f] ", rel)
+		switch {
+		case i == 0:
+			tag = fmt.Sprintf("[most recent success, recency weight %.2f] ", rel)
+		case rel < recencyStrong:
+			tag = fmt.Sprintf("[older success, recency weight %.2f — yields to newer successes] ", rel)
 		}
+		successes[i].Content = tag + successes[i].Content
 	}
-	return sb.String()
+	return append(successes, others...)
 }
 
 // entrySummary produces a short readable description of a memory entry for constraint text.
@@ -562,47 +956,172 @@ func memTokenize(s string) []string {
 	return words
 }
 
+// errEmptyPlan is returned by emitSubTasks when the model produced no subtasks:
+// an empty subtask list, or prose (typically a refusal) with no JSON plan at all.
+var errEmptyPlan = errors.New("planner returned 0 sub-tasks")
+
+// emptyPlanRetryInstruction is appended to the user prompt when the first
+// answer held no subtasks.
+const emptyPlanRetryInstruction = "[Instruction: your previous answer contained no subtasks. " +
+	"You MUST decompose this TaskSpec into at least one concrete, actionable SubTask — " +
+	"if the goal is vague, plan the most plausible first step (e.g. inspect or list what exists). " +
+	"Reply with the JSON plan only.]"
+
+// Default plan-size limits; see subtaskLimitFromEnv.
+const (
+	defaultMaxSubtasks     = 8
+	defaultMaxSubtasksHard = 15
+)
+
+// subtaskLimitFromEnv reads a plan-size limit from the named variable.
+//
+// Expectations:
+//   - Returns def when the variable is unset, not a number, or negative
+//   - Returns 0 (no limit) for "0"
+//   - Returns the parsed value otherwise
+func subtaskLimitFromEnv(name string, def int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv(name)))
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
+
+// planSizeError is returned by emitSubTasks for a plan with more than limit
+// subtasks; nothing is dispatched.
+type planSizeError struct {
+	n, limit int
+}
+
+func (e *planSizeError) Error() string {
+	return fmt.Sprintf("plan has %d subtasks, over the limit of %d", e.n, e.limit)
+}
+
+// consolidateInstruction is appended to the user prompt when the plan exceeds
+// the soft subtask limit. Arguments: the plan's size and the limit (twice).
+const consolidateInstruction = "[Instruction: your plan has %d subtasks; the limit is %d. " +
+	"Consolidate it: merge steps that use the same tool, operate on the same files, or simply feed one another into single subtasks. " +
+	"Reply with the JSON plan only, with at most %d subtasks.]"
+
+// emptyPlanMessage is the user-facing summary when R2 cannot produce a plan.
+const emptyPlanMessage = "I couldn't break this into steps — could you be more specific?"
+
 // dispatch drives the LLM planning loop.
+// directive is the GGS directive behind a replan, or "" for the initial plan.
 //
 // Expectations:
 //   - Calls p.llm.Chat and parses the response as a SubTask plan
-//   - Retries are handled externally (replanning); this function runs once per plan attempt
-func (p *Planner) dispatch(ctx context.Context, spec types.TaskSpec, userPrompt, sysPrompt string, tl *tasklog.TaskLog) error {
+//   - Re-prompts once with emptyPlanRetryInstruction when the plan has no subtasks
+//   - Abandons the task with emptyPlanMessage (not an error) when the re-prompt is empty too
+//   - Re-prompts once with consolidateInstruction when the plan exceeds maxSubtasks
+//   - Returns an error, dispatching nothing, when the final plan exceeds maxSubtasksHard
+//   - Other retries are handled externally (replanning)
+func (p *Planner) dispatch(ctx context.Context, spec types.TaskSpec, userPrompt, sysPrompt, directive string, tl *tasklog.TaskLog) error {
+	if g := llm.LanguageGuidance(spec.Language); g != "" {
+		userPrompt += "\n\n" + g + " Subtask intents and criteria may stay in English."
+	}
+	userPrompt = llm.FitUser(p.maxPromptTokens, sysPrompt, userPrompt)
 	raw, usage, err := p.llm.Chat(ctx, sysPrompt, userPrompt)
 	tl.LLMCall("planner", sysPrompt, userPrompt, raw, usage.PromptTokens, usage.CompletionTokens, usage.ElapsedMs, 0)
 	if err != nil {
 		return fmt.Errorf("llm: %w", err)
 	}
-	return p.emitSubTasks(spec, llm.StripFences(raw))
+	// The soft limit applies to the first answer only, and only below the hard cap.
+	consolidate := p.maxSubtasks > 0 && (p.maxSubtasksHard == 0 || p.maxSubtasks < p.maxSubtasksHard)
+	limit := p.maxSubtasksHard
+	if consolidate {
+		limit = p.maxSubtasks
+	}
+	err = p.emitSubTasks(ctx, spec, raw, directive, tl, limit)
+	var tooLarge *planSizeError
+	if consolidate && errors.As(err, &tooLarge) {
+		slog.Warn("[R2] plan over the subtask limit, asking to consolidate", "task", spec.TaskID, "subtasks", tooLarge.n, "limit", tooLarge.limit)
+		consolidatePrompt := llm.FitUser(p.maxPromptTokens, sysPrompt,
+			userPrompt+"\n\n"+fmt.Sprintf(consolidateInstruction, tooLarge.n, tooLarge.limit, tooLarge.limit))
+		raw, usage, err = p.llm.Chat(ctx, sysPrompt, consolidatePrompt)
+		tl.LLMCall("planner", sysPrompt, consolidatePrompt, raw, usage.PromptTokens, usage.CompletionTokens, usage.ElapsedMs, 0)
+		if err != nil {
+			return fmt.Errorf("llm: %w", err)
+		}
+		err = p.emitSubTasks(ctx, spec, raw, directive, tl, p.maxSubtasksHard)
+	}
+	if !errors.Is(err, errEmptyPlan) {
+		return rejectOversized(err)
+	}
+
+	slog.Warn("[R2] plan has no subtasks, re-prompting once", "task", spec.TaskID)
+	retryPrompt := llm.FitUser(p.maxPromptTokens, sysPrompt, userPrompt+"\n\n"+emptyPlanRetryInstruction)
+	raw, usage, err = p.llm.Chat(ctx, sysPrompt, retryPrompt)
+	tl.LLMCall("planner", sysPrompt, retryPrompt, raw, usage.PromptTokens, usage.CompletionTokens, usage.ElapsedMs, 0)
+	if err != nil {
+		return fmt.Errorf("llm: %w", err)
+	}
+	err = p.emitSubTasks(ctx, spec, raw, directive, tl, p.maxSubtasksHard)
+	if !errors.Is(err, errEmptyPlan) {
+		return rejectOversized(err)
+	}
+	slog.Warn("[R2] plan still has no subtasks, asking the user to be more specific", "task", spec.TaskID)
+	p.logReg.Close(spec.TaskID, "abandoned")
+	p.publishAbandon(spec, emptyPlanMessage)
+	return nil
+}
+
+// rejectOversized words a planSizeError from the last planning answer as the
+// hard-cap rejection it is; other errors pass through unchanged.
+func rejectOversized(err error) error {
+	var tooLarge *planSizeError
+	if errors.As(err, &tooLarge) {
+		slog.Warn("[R2] plan rejected at the hard subtask cap", "subtasks", tooLarge.n, "cap", tooLarge.limit)
+		return fmt.Errorf("plan rejected: %d subtasks exceed the hard cap of %d (ARTOO_MAX_SUBTASKS_HARD)", tooLarge.n, tooLarge.limit)
+	}
+	return err
 }
 
-// emitSubTasks parses a raw SubTask plan (wrapper or bare array) and fans it out on the bus.
+// emitSubTasks parses a raw SubTask plan (wrapper or bare array) and fans it out on the bus,
+// in execution order (see executionOrder).
 // It first attempts the wrapper format {"task_criteria":[...],"subtasks":[...]};
 // if that fails it falls back to a bare JSON array for backward compatibility.
-func (p *Planner) emitSubTasks(spec types.TaskSpec, raw string) error {
+// On a replan (directive != "") it first publishes a PlanDiff against the previous round.
+// With a cost preview installed, a declined estimate ends the task instead of dispatching.
+// Returns errEmptyPlan when the response holds no subtasks, and a *planSizeError
+// when it holds more than maxSubtasks (0: no limit); see dispatch.
+func (p *Planner) emitSubTasks(ctx context.Context, spec types.TaskSpec, raw, directive string, tl *tasklog.TaskLog, maxSubtasks int) error {
 	var subTasks []types.SubTask
 	var taskCriteria []string
 
-	trimmed := extractJSON(strings.TrimSpace(raw))
+	trimmed, _ := llm.RepairJSON(raw) // a parse failure is reported below with the raw output
+	if !strings.ContainsAny(trimmed, "{[") {
+		return errEmptyPlan // prose, e.g. a refusal: no plan to parse
+	}
 	if strings.HasPrefix(trimmed, "{") {
 		var wrapper struct {
 			TaskCriteria []string        `json:"task_criteria"`
 			Subtasks     []types.SubTask `json:"subtasks"`
 		}
-		if err := json.Unmarshal([]byte(trimmed), &wrapper); err == nil && len(wrapper.Subtasks) > 0 {
+		if err := json.Unmarshal([]byte(trimmed), &wrapper); err == nil {
+			if len(wrapper.Subtasks) == 0 {
+				return errEmptyPlan
+			}
 			subTasks = wrapper.Subtasks
 			taskCriteria = wrapper.TaskCriteria
 		}
 	}
 	if subTasks == nil {
-		if err := json.Unmarshal([]byte(raw), &subTasks); err != nil {
+		if err := json.Unmarshal([]byte(trimmed), &subTasks); err != nil {
 			return fmt.Errorf("parse SubTasks: %w (raw: %s)", err, raw)
 		}
 	}
 
 	if len(subTasks) == 0 {
-		return fmt.Errorf("planner returned 0 sub-tasks")
+		return errEmptyPlan
 	}
+	if maxSubtasks > 0 && len(subTasks) > maxSubtasks {
+		return &planSizeError{n: len(subTasks), limit: maxSubtasks}
+	}
+
+	// Order by sequence so the manifest, the fan-out, and the recorded plan all
+	// list subtasks in execution order, whatever order the model wrote them in.
+	subTasks = executionOrder(subTasks)
 
 	// Assign IDs and parent
 	subtaskIDs := make([]string, 0, len(subTasks))
@@ -611,9 +1130,25 @@ func (p *Planner) emitSubTasks(spec types.TaskSpec, raw string) error {
 			subTasks[i].SubTaskID = uuid.New().String()
 		}
 		subTasks[i].ParentTaskID = spec.TaskID
+		subTasks[i].Language = spec.Language
 		subtaskIDs = append(subtaskIDs, subTasks[i].SubTaskID)
 	}
 
+	if !p.previewCost(ctx, spec.TaskID, subTasks, len(taskCriteria)) {
+		slog.Info("[R2] plan declined at cost preview", "task", spec.TaskID)
+		p.publishCostDeclined(spec)
+		return nil
+	}
+
+	// Record this round's plan; on a replan, show what changed against the last one.
+	p.mu.Lock()
+	prev, hadPrev := p.lastPlan[spec.TaskID]
+	p.lastPlan[spec.TaskID] = subTasks
+	p.mu.Unlock()
+	if directive != "" && hadPrev {
+		p.publishPlanDiff(diffPlans(spec.TaskID, directive, prev, subTasks), tl)
+	}
+
 	// Publish manifest first so R4b knows expected count
 	manifest := types.DispatchManifest{
 		TaskID:       spec.TaskID,
@@ -621,6 +1156,7 @@ func (p *Planner) emitSubTasks(spec types.TaskSpec, raw string) error {
 		TaskSpec:     &spec,
 		DispatchedAt: time.Now().UTC().Format(time.RFC3339),
 		TaskCriteria: taskCriteria,
+		Steps:        manifestSteps(subTasks),
 	}
 	p.b.Publish(types.Message{
 		ID:        uuid.New().String(),
@@ -651,21 +1187,137 @@ func (p *Planner) emitSubTasks(spec types.TaskSpec, raw string) error {
 	return nil
 }
 
-// extractJSON finds the first top-level JSON object or array in s, skipping
-// any prose preamble the LLM may have emitted before the actual JSON.
-// Returns the original string unchanged if no JSON structure is found.
+// planMatchThreshold is the minimum intent similarity for two subtasks in
+// successive rounds to count as the same step (changed or unchanged) rather
+// than one removed and one added.
+const planMatchThreshold = 0.5
+
+// intentSimilarity returns the Jaccard overlap of the memTokenize keyword sets of a and b.
+//
+// Expectations:
+//   - Returns 1 for identical intents (case-insensitive)
+//   - Returns 0 when the intents share no keyword of length >= 3
+//   - Returns 0 when either intent has no keywords
+func intentSimilarity(a, b string) float64 {
+	wa, wb := memTokenize(a), memTokenize(b)
+	if len(wa) == 0 || len(wb) == 0 {
+		return 0
+	}
+	set := make(map[string]bool, len(wa))
+	for _, w := range wa {
+		set[w] = true
+	}
+	union := len(set)
+	inter := 0
+	seen := make(map[string]bool, len(wb))
+	for _, w := range wb {
+		if seen[w] {
+			continue
+		}
+		seen[w] = true
+		if set[w] {
+			inter++
+		} else {
+			union++
+		}
+	}
+	return float64(inter) / float64(union)
+}
+
+// executionOrder returns subTasks sorted by sequence number, keeping plan order
+// within a sequence — the order the dispatcher runs them in.
 //
 // Expectations:
-//   - Returns s unchanged when s starts with '{' or '['
-//   - Skips prose preamble and returns the JSON substring when '{' or '[' appears later
-//   - Returns s unchanged when no '{' or '[' is found
-func extractJSON(s string) string {
-	for i, c := range s {
-		if c == '{' || c == '[' {
-			return s[i:]
+//   - Lower sequence numbers come first
+//   - Subtasks with equal sequence numbers keep their original (plan) order
+//   - The input slice is not modified
+func executionOrder(subTasks []types.SubTask) []types.SubTask {
+	out := slices.Clone(subTasks)
+	slices.SortStableFunc(out, func(a, b types.SubTask) int { return a.Sequence - b.Sequence })
+	return out
+}
+
+// manifestSteps groups subTasks by sequence number for the dispatch manifest.
+//
+// Expectations:
+//   - Returns one step per distinct sequence number, in ascending order
+//   - Keeps each step's subtask IDs in plan order
+//   - Returns nil for an empty plan
+func manifestSteps(subTasks []types.SubTask) []types.ManifestStep {
+	var steps []types.ManifestStep
+	for _, st := range subTasks {
+		i := slices.IndexFunc(steps, func(s types.ManifestStep) bool { return s.Sequence == st.Sequence })
+		if i < 0 {
+			steps = append(steps, types.ManifestStep{Sequence: st.Sequence})
+			i = len(steps) - 1
 		}
+		steps[i].SubTaskIDs = append(steps[i].SubTaskIDs, st.SubTaskID)
 	}
-	return s
+	sort.SliceStable(steps, func(a, b int) bool { return steps[a].Sequence < steps[b].Sequence })
+	return steps
+}
+
+// diffPlans compares two successive subtask sets. Each new subtask is paired
+// with the most similar unpaired previous subtask whose intent similarity is at
+// least planMatchThreshold. Pairs with identical intent and criteria count as
+// unchanged, other pairs as changed; unpaired subtasks are added or removed.
+//
+// Expectations:
+//   - Reports a new subtask with no similar predecessor as Added
+//   - Reports a previous subtask with no similar successor as Removed
+//   - Reports a similar pair whose intent or criteria differ as Changed
+//   - Counts identical subtasks as Unchanged, not Changed
+//   - Pairs each previous subtask at most once
+func diffPlans(taskID, directive string, prev, next []types.SubTask) types.PlanDiff {
+	diff := types.PlanDiff{TaskID: taskID, Directive: directive}
+	used := make([]bool, len(prev))
+	for _, n := range next {
+		best, bestSim := -1, 0.0
+		for i, o := range prev {
+			if used[i] {
+				continue
+			}
+			if sim := intentSimilarity(o.Intent, n.Intent); sim >= planMatchThreshold && (best < 0 || sim > bestSim) {
+				best, bestSim = i, sim
+			}
+		}
+		if best < 0 {
+			diff.Added = append(diff.Added, n.Intent)
+			continue
+		}
+		used[best] = true
+		o := prev[best]
+		if o.Intent == n.Intent && slices.Equal(o.SuccessCriteria, n.SuccessCriteria) {
+			diff.Unchanged++
+		} else {
+			diff.Changed = append(diff.Changed, types.PlanChange{From: o.Intent, To: n.Intent})
+		}
+	}
+	for i, o := range prev {
+		if !used[i] {
+			diff.Removed = append(diff.Removed, o.Intent)
+		}
+	}
+	return diff
+}
+
+// publishPlanDiff logs diff to the task log and publishes it for the UI.
+func (p *Planner) publishPlanDiff(diff types.PlanDiff, tl *tasklog.TaskLog) {
+	changed := make([]string, 0, len(diff.Changed))
+	for _, c := range diff.Changed {
+		changed = append(changed, c.From+" → "+c.To)
+	}
+	tl.PlanDiff(diff.Directive, diff.Added, diff.Removed, changed, diff.Unchanged)
+	slog.Info("[R2] plan diff", "task", diff.TaskID, "directive", diff.Directive,
+		"added", len(diff.Added), "removed", len(diff.Removed), "changed", len(diff.Changed), "unchanged", diff.Unchanged)
+	p.b.Publish(types.Message{
+		ID:        uuid.New().String(),
+		Timestamp: time.Now().UTC(),
+		From:      types.RolePlanner,
+		To:        types.RoleUser,
+		Type:      types.MsgPlanDiff,
+		Payload:   diff,
+	})
 }
 
 func toTaskSpec(payload any) (types.TaskSpec, error) {
@@ -685,3 +1337,12 @@ func toPlanDirective(payload any) (types.PlanDirective, error) {
 	var pd types.PlanDirective
 	return pd, json.Unmarshal(b, &pd)
 }
+
+func toFinalResult(payload any) (types.FinalResult, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return types.FinalResult{}, err
+	}
+	var fr types.FinalResult
+	return fr, json.Unmarshal(b, &fr)
+}
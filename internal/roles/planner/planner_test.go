@@ -1,9 +1,23 @@
 package planner
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/haricheung/agentic-shell/internal/bus"
+	"github.com/haricheung/agentic-shell/internal/llm"
+	"github.com/haricheung/agentic-shell/internal/tasklog"
 	"github.com/haricheung/agentic-shell/internal/types"
 )
 
@@ -112,6 +126,54 @@ func TestCalibrateMKCT_Layer3SuppressedWhenLayer2Present(t *testing.T) {
 	}
 }
 
+func TestCalibrateMKCT_AvoidMakesRecentFailureHardConstraint(t *testing.T) {
+	// Avoid: failures under a hard "MUST NOT" heading citing the decision potential
+	recent := []types.Megram{
+		{State: "abandon", Content: "Failed. Tools tried: shell:curl http://api.example/v1. Gap: 503"},
+	}
+	got := calibrateMKCT(nil, types.Potentials{Attention: 0.9, Decision: -0.8, Action: "Avoid"}, recent)
+	if !strings.Contains(got, "MUST NOT (hard constraint — memory signal Avoid, decision -0.80") {
+		t.Errorf("expected a hard MUST NOT heading, got %q", got)
+	}
+	if !strings.Contains(got, "api.example") {
+		t.Errorf("expected the failed approach listed, got %q", got)
+	}
+}
+
+func TestCalibrateMKCT_CautionMakesRecentFailureAdvisory(t *testing.T) {
+	// Caution: failures and successes both under advisory "CAUTION" headings
+	recent := []types.Megram{
+		{State: "abandon", Content: "Failed. Tools tried: search:stock price today"},
+		{State: "accept", Content: "Succeeded. Tools: shell:curl https://quotes.example"},
+	}
+	got := calibrateMKCT(nil, types.Potentials{Attention: 0.7, Decision: 0.05, Action: "Caution"}, recent)
+	if strings.Contains(got, "MUST NOT") || strings.Contains(got, "SHOULD PREFER") {
+		t.Errorf("expected only advisory lines under Caution, got %q", got)
+	}
+	if strings.Count(got, "CAUTION (advisory") != 2 {
+		t.Errorf("expected advisory headings for both failure and success, got %q", got)
+	}
+	if !strings.Contains(got, "stock price today") || !strings.Contains(got, "quotes.example") {
+		t.Errorf("expected both recent approaches listed, got %q", got)
+	}
+}
+
+func TestCalibrateMKCT_ExploitMakesRecentSuccessStrongPreference(t *testing.T) {
+	// Exploit: successes under a strong "SHOULD PREFER" heading citing the decision potential;
+	// failures under an advisory "CAUTION" heading
+	recent := []types.Megram{
+		{State: "accept", Content: "Succeeded. Tools: mdfind:report.pdf"},
+		{State: "abandon", Content: "Failed. Tools tried: shell:find / -name report.pdf"},
+	}
+	got := calibrateMKCT(nil, types.Potentials{Attention: 0.9, Decision: 0.7, Action: "Exploit"}, recent)
+	if !strings.Contains(got, "SHOULD PREFER (strong — memory signal Exploit, decision +0.70") {
+		t.Errorf("expected a strong SHOULD PREFER heading, got %q", got)
+	}
+	if strings.Contains(got, "MUST NOT") || !strings.Contains(got, "CAUTION (advisory") {
+		t.Errorf("expected the failure to be advisory under Exploit, got %q", got)
+	}
+}
+
 // --- calibrate ---
 
 func TestCalibrate_EmptyEntries(t *testing.T) {
@@ -206,6 +268,118 @@ func TestCalibrate_EpisodicUnderShouldPrefer(t *testing.T) {
 	}
 }
 
+func TestCalibrate_CheaperPriorApproachEmphasized(t *testing.T) {
+	// Given two costed successes, the cheaper one leads, is marked, and carries its prior cost
+	entries := []types.MemoryEntry{
+		{Type: "episodic", Timestamp: "2026-02-01T00:00:00Z", Content: "searched every file with find",
+			Cost: &types.TaskCost{TotalTokens: 18000, ToolCallCount: 9}},
+		{Type: "episodic", Timestamp: "2026-01-01T00:00:00Z", Content: "located the file with mdfind",
+			Cost: &types.TaskCost{TotalTokens: 2000, ToolCallCount: 1}},
+	}
+	got := calibrate(entries, "locate the file")
+	cheap := strings.Index(got, "[cheapest known approach] \"located the file with mdfind\" (this approach previously succeeded using ~2.0k tokens, 1 tool calls)")
+	dear := strings.Index(got, "\"searched every file with find\" (this approach previously succeeded using ~18k tokens, 9 tool calls)")
+	if cheap < 0 || dear < 0 || cheap > dear {
+		t.Errorf("expected the cheaper approach first and marked, got:\n%s", got)
+	}
+	if !strings.Contains(got, "prefer the cheapest") {
+		t.Errorf("expected the heading to say to prefer the cheapest, got:\n%s", got)
+	}
+}
+
+func TestPreferCheaper_SingleCostedEntryKeepsOrder(t *testing.T) {
+	// Leaves order and wording alone when fewer than two entries have a cost
+	lines, competing := preferCheaper([]costedApproach{
+		{summary: "newer, cost unknown"},
+		{summary: "older", cost: &types.TaskCost{TotalTokens: 500, ToolCallCount: 2}},
+	})
+	if competing || len(lines) != 2 || !strings.Contains(lines[0], "newer") {
+		t.Fatalf("expected the original order without competition, got %q (competing %v)", lines, competing)
+	}
+	if strings.Contains(lines[1], "cheapest") || !strings.HasSuffix(lines[1], "(this approach previously succeeded using ~500 tokens, 2 tool calls)") {
+		t.Errorf("expected only a cost note on the costed entry, got %q", lines[1])
+	}
+}
+
+func TestCalibrateMKCT_PrefersCheaperRecentSuccess(t *testing.T) {
+	// Recent successes are ordered and annotated by preferCheaper; competing costs add
+	// "where they compete, prefer the cheapest, listed first" to their heading
+	recent := []types.Megram{
+		{State: "accept", Content: "walked the whole tree with find", Cost: &types.TaskCost{TotalTokens: 18000, ToolCallCount: 9}},
+		{State: "success", Content: "located the file with mdfind", Cost: &types.TaskCost{TotalTokens: 2000, ToolCallCount: 1}},
+		{State: "abandon", Content: "grepped the home directory"},
+	}
+	got := calibrateMKCT(nil, types.Potentials{Action: "Ignore"}, recent)
+	cheap := strings.Index(got, "  - [cheapest known approach] located the file with mdfind (this approach previously succeeded using ~2.0k tokens, 1 tool calls)")
+	dear := strings.Index(got, "  - walked the whole tree with find (this approach previously succeeded using ~18k tokens, 9 tool calls)")
+	if cheap < 0 || dear < 0 || cheap > dear {
+		t.Errorf("expected the cheaper success first and marked, got:\n%s", got)
+	}
+	if !strings.Contains(got, "these approaches succeeded; where they compete, prefer the cheapest, listed first):") {
+		t.Errorf("expected the success heading to say to prefer the cheapest, got:\n%s", got)
+	}
+	if !strings.Contains(got, "  - grepped the home directory") {
+		t.Errorf("expected the failure to stay under MUST NOT, got:\n%s", got)
+	}
+}
+
+// --- recency boost ---
+
+func TestRecencyWeight_HalvesEachHalfLife(t *testing.T) {
+	// Returns 1 for now, 0.5 one half-life back, and 1 for unparseable or future timestamps
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	cases := map[string]float64{
+		now.Format(time.RFC3339):                1,
+		now.Add(-day).Format(time.RFC3339):      0.5,
+		now.Add(-2 * day).Format(time.RFC3339):  0.25,
+		now.Add(time.Hour).Format(time.RFC3339): 1,
+		"not a timestamp":                       1,
+	}
+	for ts, want := range cases {
+		if got := recencyWeight(ts, now, day); math.Abs(got-want) > 1e-9 {
+			t.Errorf("recencyWeight(%q) = %v, want %v", ts, got, want)
+		}
+	}
+}
+
+func TestMemoryRecencyHalfLife_ParsesDuration(t *testing.T) {
+	// Returns the parsed duration when positive; 0 when unset, unparseable, or non-positive
+	for v, want := range map[string]time.Duration{"72h": 72 * time.Hour, "": 0, "soon": 0, "-1h": 0} {
+		t.Setenv("ARTOO_MEMORY_RECENCY_HALFLIFE", v)
+		if got := memoryRecencyHalfLife(); got != want {
+			t.Errorf("%q: got %v, want %v", v, got, want)
+		}
+	}
+}
+
+func TestBoostRecentMegrams_NewestSuccessFirstAndOlderYield(t *testing.T) {
+	// Successes are reordered newest first and tagged; failures pass through unchanged
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	recent := []types.Megram{
+		{State: "accept", CreatedAt: now.Add(-20 * 24 * time.Hour).Format(time.RFC3339), Content: "used locate"},
+		{State: "abandon", CreatedAt: now.Add(-time.Hour).Format(time.RFC3339), Content: "used find /"},
+		{State: "success", CreatedAt: now.Add(-time.Hour).Format(time.RFC3339), Content: "used mdfind"},
+	}
+	got := boostRecentMegrams(recent, now, 72*time.Hour)
+	if len(got) != 3 {
+		t.Fatalf("got %d megrams", len(got))
+	}
+	if !strings.HasPrefix(got[0].Content, "[most recent success, recency weight 1.00] used mdfind") {
+		t.Errorf("first = %q", got[0].Content)
+	}
+	if !strings.HasPrefix(got[1].Content, "[older success, recency weight") || !strings.HasSuffix(got[1].Content, "yields to newer successes] used locate") {
+		t.Errorf("second = %q", got[1].Content)
+	}
+	if got[2].Content != "used find /" {
+		t.Errorf("failure content changed: %q", got[2].Content)
+	}
+	out := calibrateMKCT(nil, types.Potentials{Action: "Ignore"}, got)
+	if strings.Index(out, "used mdfind") > strings.Index(out, "used locate") {
+		t.Errorf("calibrateMKCT should list the recent success first:\n%s", out)
+	}
+}
+
 // --- entrySummary ---
 
 func TestEntrySummary_TruncatesLongContent(t *testing.T) {
@@ -271,33 +445,622 @@ func TestMemTokenize_EmptyInput(t *testing.T) {
 	}
 }
 
-// --- extractJSON ---
+// --- intentSimilarity ---
+
+func TestIntentSimilarity_IdenticalIsOne(t *testing.T) {
+	// Returns 1 for identical intents (case-insensitive)
+	if got := intentSimilarity("Find Video Files", "find video files"); got != 1 {
+		t.Errorf("expected 1, got %v", got)
+	}
+}
+
+func TestIntentSimilarity_DisjointIsZero(t *testing.T) {
+	// Returns 0 when the intents share no keyword of length >= 3
+	if got := intentSimilarity("find video files", "check disk usage"); got != 0 {
+		t.Errorf("expected 0, got %v", got)
+	}
+}
+
+func TestIntentSimilarity_NoKeywordsIsZero(t *testing.T) {
+	// Returns 0 when either intent has no keywords
+	if got := intentSimilarity("a b", "find video files"); got != 0 {
+		t.Errorf("expected 0, got %v", got)
+	}
+}
+
+// --- diffPlans ---
+
+func TestManifestSteps_GroupsBySequence(t *testing.T) {
+	// Returns one step per distinct sequence number, in ascending order
+	// Keeps each step's subtask IDs in plan order
+	subTasks := []types.SubTask{
+		{SubTaskID: "c", Sequence: 2},
+		{SubTaskID: "a", Sequence: 1},
+		{SubTaskID: "d", Sequence: 2},
+		{SubTaskID: "b", Sequence: 1},
+	}
+	got := manifestSteps(subTasks)
+	want := []types.ManifestStep{
+		{Sequence: 1, SubTaskIDs: []string{"a", "b"}},
+		{Sequence: 2, SubTaskIDs: []string{"c", "d"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("manifestSteps = %+v, want %+v", got, want)
+	}
+	if manifestSteps(nil) != nil {
+		t.Error("expected nil steps for an empty plan")
+	}
+}
+
+func TestExecutionOrder_SortsBySequenceStably(t *testing.T) {
+	// Lower sequence numbers come first; subtasks with equal sequence numbers keep
+	// their original (plan) order; the input slice is not modified
+	subTasks := []types.SubTask{
+		{SubTaskID: "c", Sequence: 2},
+		{SubTaskID: "a", Sequence: 1},
+		{SubTaskID: "d", Sequence: 2},
+		{SubTaskID: "b", Sequence: 1},
+	}
+	var got []string
+	for _, st := range executionOrder(subTasks) {
+		got = append(got, st.SubTaskID)
+	}
+	if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("executionOrder = %v, want %v", got, want)
+	}
+	if subTasks[0].SubTaskID != "c" {
+		t.Error("the input slice was reordered")
+	}
+}
 
-func TestExtractJSON_AlreadyJSON(t *testing.T) {
-	// Returns s unchanged when s starts with '{' or '['
-	obj := `{"task_criteria":[],"subtasks":[]}`
-	if got := extractJSON(obj); got != obj {
-		t.Errorf("expected unchanged, got %q", got)
+func TestDiffPlans_AddedRemovedChangedUnchanged(t *testing.T) {
+	// Reports a new subtask with no similar predecessor as Added
+	// Reports a previous subtask with no similar successor as Removed
+	// Reports a similar pair whose intent or criteria differ as Changed
+	// Counts identical subtasks as Unchanged, not Changed
+	prev := []types.SubTask{
+		{Intent: "locate the largest video files in Downloads", SuccessCriteria: []string{"output lists paths"}},
+		{Intent: "search spotlight for mp4 files", SuccessCriteria: []string{"output lists mp4 paths"}},
+		{Intent: "report total size", SuccessCriteria: []string{"output has a size"}},
+	}
+	next := []types.SubTask{
+		{Intent: "locate the largest video files in Downloads", SuccessCriteria: []string{"output lists paths"}},
+		{Intent: "list video files with shell find", SuccessCriteria: []string{"output lists paths"}},
+		{Intent: "report total size in GB", SuccessCriteria: []string{"output has a size in GB"}},
+	}
+	d := diffPlans("t1", "change_approach", prev, next)
+	if d.TaskID != "t1" || d.Directive != "change_approach" {
+		t.Errorf("unexpected header: %+v", d)
+	}
+	if d.Unchanged != 1 {
+		t.Errorf("expected 1 unchanged, got %d", d.Unchanged)
+	}
+	if len(d.Added) != 1 || d.Added[0] != "list video files with shell find" {
+		t.Errorf("expected shell-find subtask added, got %v", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0] != "search spotlight for mp4 files" {
+		t.Errorf("expected spotlight subtask removed, got %v", d.Removed)
 	}
-	arr := `[{"subtask_id":"a"}]`
-	if got := extractJSON(arr); got != arr {
-		t.Errorf("expected unchanged, got %q", got)
+	if len(d.Changed) != 1 || d.Changed[0].From != "report total size" || d.Changed[0].To != "report total size in GB" {
+		t.Errorf("expected size subtask changed, got %+v", d.Changed)
 	}
 }
 
-func TestExtractJSON_SkipsProsePreamble(t *testing.T) {
-	// Skips prose preamble and returns the JSON substring
-	raw := "I need to determine what location.\n\n{\"task_criteria\":[],\"subtasks\":[]}"
-	got := extractJSON(raw)
-	if !strings.HasPrefix(got, "{") {
-		t.Errorf("expected JSON object, got %q", got)
+func TestDiffPlans_CriteriaOnlyChangeIsChanged(t *testing.T) {
+	// Reports a similar pair whose intent or criteria differ as Changed
+	prev := []types.SubTask{{Intent: "count files", SuccessCriteria: []string{"a number"}}}
+	next := []types.SubTask{{Intent: "count files", SuccessCriteria: []string{"a positive integer"}}}
+	d := diffPlans("t1", "refine", prev, next)
+	if len(d.Changed) != 1 || d.Unchanged != 0 {
+		t.Errorf("expected criteria change to count as changed, got %+v", d)
+	}
+}
+
+func TestDiffPlans_PairsEachPreviousOnce(t *testing.T) {
+	// Pairs each previous subtask at most once
+	prev := []types.SubTask{{Intent: "download the report"}}
+	next := []types.SubTask{{Intent: "download the report"}, {Intent: "download the report again"}}
+	d := diffPlans("t1", "refine", prev, next)
+	if d.Unchanged != 1 || len(d.Added) != 1 || len(d.Removed) != 0 {
+		t.Errorf("expected 1 unchanged + 1 added, got %+v", d)
+	}
+}
+
+// --- emitSubTasks / PlanDiff ---
+
+func TestEmitSubTasks_ManifestListsIDsInExecutionOrder(t *testing.T) {
+	// A plan written out of sequence order is dispatched in execution order:
+	// the manifest's SubTaskIDs and the SubTask fan-out both follow sequence, then plan order
+	b := bus.New()
+	manifestCh := b.Subscribe(types.MsgDispatchManifest)
+	subTaskCh := b.Subscribe(types.MsgSubTask)
+	p := New(b, nil, nil, nil, nil)
+	spec := types.TaskSpec{TaskID: "t1", Intent: "summarise the sales report"}
+	raw := `{"task_criteria":["summary written"],"subtasks":[` +
+		`{"subtask_id":"write","intent":"write the summary","sequence":3},` +
+		`{"subtask_id":"read-q1","intent":"read the Q1 sheet","sequence":1},` +
+		`{"subtask_id":"merge","intent":"merge the quarters","sequence":2},` +
+		`{"subtask_id":"read-q2","intent":"read the Q2 sheet","sequence":1}]}`
+
+	if err := p.emitSubTasks(t.Context(), spec, raw, "", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"read-q1", "read-q2", "merge", "write"}
+	m := (<-manifestCh).Payload.(types.DispatchManifest)
+	if !reflect.DeepEqual(m.SubTaskIDs, want) {
+		t.Errorf("manifest SubTaskIDs = %v, want %v", m.SubTaskIDs, want)
+	}
+	var fanOut []string
+	for range want {
+		fanOut = append(fanOut, (<-subTaskCh).Payload.(types.SubTask).SubTaskID)
+	}
+	if !reflect.DeepEqual(fanOut, want) {
+		t.Errorf("SubTask fan-out order = %v, want %v", fanOut, want)
+	}
+}
+
+func TestEmitSubTasks_PublishesPlanDiffOnReplan(t *testing.T) {
+	// Two successive plans: the replan publishes a PlanDiff naming the added and removed subtasks
+	b := bus.New()
+	diffCh := b.Subscribe(types.MsgPlanDiff)
+	logReg := tasklog.NewRegistry(filepath.Join(t.TempDir(), "tasks"))
+	tl := logReg.Open("t1", "find videos")
+	p := New(b, nil, logReg, nil, nil)
+	spec := types.TaskSpec{TaskID: "t1", Intent: "find videos"}
+
+	first := `{"task_criteria":["paths listed"],"subtasks":[{"intent":"search spotlight for mp4 files","success_criteria":["mp4 paths"],"sequence":1}]}`
+	second := `{"task_criteria":["paths listed"],"subtasks":[{"intent":"list video files with shell find","success_criteria":["paths"],"sequence":1}]}`
+
+	if err := p.emitSubTasks(t.Context(), spec, first, "", tl, 0); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-diffCh:
+		t.Fatalf("initial plan must not publish a PlanDiff, got %+v", msg.Payload)
+	default:
+	}
+
+	if err := p.emitSubTasks(t.Context(), spec, second, "change_approach", tl, 0); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-diffCh:
+		var d types.PlanDiff
+		raw, _ := json.Marshal(msg.Payload)
+		json.Unmarshal(raw, &d)
+		if d.Directive != "change_approach" {
+			t.Errorf("expected directive change_approach, got %q", d.Directive)
+		}
+		if len(d.Added) != 1 || d.Added[0] != "list video files with shell find" {
+			t.Errorf("expected shell-find subtask added, got %v", d.Added)
+		}
+		if len(d.Removed) != 1 || d.Removed[0] != "search spotlight for mp4 files" {
+			t.Errorf("expected spotlight subtask removed, got %v", d.Removed)
+		}
+		if msg.From != types.RolePlanner || msg.To != types.RoleUser {
+			t.Errorf("expected R2 → User, got %s → %s", msg.From, msg.To)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a PlanDiff after the replan")
+	}
+
+	found := false
+	for _, e := range logReg.ReadEvents("t1") {
+		if e.Kind == tasklog.KindPlanDiff && len(e.Added) == 1 && len(e.Removed) == 1 {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected a plan_diff event in the task log")
+	}
+}
+
+// --- acceptDirective / terminal guard ---
+
+func TestAcceptDirective_RejectsStaleOtherAndTerminal(t *testing.T) {
+	// Drops directives for another task, for rounds already planned, and for ended tasks
+	p := New(bus.New(), nil, nil, nil, nil)
+	if !p.acceptDirective("t1", types.PlanDirective{TaskID: "t1", Round: 1}) {
+		t.Fatal("round 1 for the current task should be accepted")
+	}
+	if p.acceptDirective("t1", types.PlanDirective{TaskID: "t1", Round: 1}) {
+		t.Error("a duplicate round 1 should be dropped")
+	}
+	if p.acceptDirective("t1", types.PlanDirective{TaskID: "t0", Round: 2}) {
+		t.Error("a directive for a task that is not current should be dropped")
+	}
+	if !p.acceptDirective("t1", types.PlanDirective{TaskID: "t1"}) {
+		t.Error("an unstamped directive for a live task should be accepted")
+	}
+	p.terminal["t1"] = true
+	if p.acceptDirective("t1", types.PlanDirective{TaskID: "t1", Round: 2}) {
+		t.Error("a directive after the task's FinalResult should be dropped")
+	}
+}
+
+func TestRun_DirectiveAfterAbandonDispatchesNoPlan(t *testing.T) {
+	// An out-of-order PlanDirective arriving after the abandon FinalResult is ignored:
+	// no LLM call and no new DispatchManifest
+	plan := `{"task_criteria":["done"],"subtasks":[{"intent":"list files","success_criteria":["paths"],"sequence":1}]}`
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		body, _ := json.Marshal(plan)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":` + string(body) + `}}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`))
+	}))
+	defer ts.Close()
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+
+	b := bus.New()
+	manifestCh := b.Subscribe(types.MsgDispatchManifest)
+	p := New(b, llm.New(), tasklog.NewRegistry(filepath.Join(t.TempDir(), "tasks")), nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+	time.Sleep(20 * time.Millisecond) // let Run register its subscriptions
+
+	b.Publish(types.Message{Type: types.MsgTaskSpec, Payload: types.TaskSpec{TaskID: "t1", Intent: "list files"}})
+	select {
+	case <-manifestCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the initial DispatchManifest")
+	}
+
+	b.Publish(types.Message{Type: types.MsgFinalResult, Payload: types.FinalResult{TaskID: "t1", Directive: "abandon"}})
+	time.Sleep(20 * time.Millisecond)
+	b.Publish(types.Message{Type: types.MsgPlanDirective, Payload: types.PlanDirective{TaskID: "t1", Directive: "change_path", Round: 2}})
+
+	select {
+	case msg := <-manifestCh:
+		t.Fatalf("expected no new plan after abandon, got %+v", msg.Payload)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected only the initial planning LLM call, got %d", n)
+	}
+
+	// The next task's TaskSpec evicts the ended task's marks
+	b.Publish(types.Message{Type: types.MsgTaskSpec, Payload: types.TaskSpec{TaskID: "t2", Intent: "list files"}})
+	select {
+	case <-manifestCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected t2's DispatchManifest")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.terminal) != 0 || len(p.lastRound) != 0 {
+		t.Errorf("expected t1's marks evicted, got terminal=%v lastRound=%v", p.terminal, p.lastRound)
+	}
+}
+
+// --- empty plan ---
+
+// scriptedPlanner returns a Planner whose LLM answers with responses in order
+// (repeating the last), and the user prompts it received.
+func scriptedPlanner(t *testing.T, b *bus.Bus, outputFn func(types.FinalResult), responses ...string) (*Planner, *tasklog.Registry, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var prompts []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct{ Content string } `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		prompts = append(prompts, req.Messages[len(req.Messages)-1].Content)
+		content := responses[min(len(prompts), len(responses))-1]
+		mu.Unlock()
+		body, _ := json.Marshal(content)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":` + string(body) + `}}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`))
+	}))
+	t.Cleanup(ts.Close)
+	t.Setenv("OPENAI_BASE_URL", ts.URL)
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	t.Setenv("OPENAI_MODEL", "test-model")
+	logReg := tasklog.NewRegistry(filepath.Join(t.TempDir(), "tasks"))
+	return New(b, llm.New(), logReg, nil, outputFn), logReg, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), prompts...)
+	}
+}
+
+func TestDispatch_EmptyPlanRepromptsOnce(t *testing.T) {
+	// Re-prompts once with emptyPlanRetryInstruction when the plan has no subtasks
+	b := bus.New()
+	manifestCh := b.Subscribe(types.MsgDispatchManifest)
+	p, _, prompts := scriptedPlanner(t, b, nil,
+		`{"task_criteria":[],"subtasks":[]}`,
+		`{"task_criteria":["done"],"subtasks":[{"intent":"list files in the project","success_criteria":["paths"],"sequence":1}]}`)
+
+	if err := p.plan(t.Context(), types.TaskSpec{TaskID: "t1", Intent: "tidy things up"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := prompts()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 planning calls, got %d", len(got))
+	}
+	if strings.Contains(got[0], emptyPlanRetryInstruction) || !strings.Contains(got[1], emptyPlanRetryInstruction) {
+		t.Error("expected only the second prompt to carry the retry instruction")
+	}
+	select {
+	case <-manifestCh:
+	case <-time.After(time.Second):
+		t.Fatal("expected the re-prompted plan to be dispatched")
+	}
+}
+
+func TestDispatch_SecondEmptyPlanYieldsFriendlyFinalResult(t *testing.T) {
+	// Abandons the task with emptyPlanMessage (not an error) when the re-prompt is empty too
+	b := bus.New()
+	manifestCh := b.Subscribe(types.MsgDispatchManifest)
+	results := make(chan types.FinalResult, 1)
+	p, logReg, prompts := scriptedPlanner(t, b, func(fr types.FinalResult) { results <- fr },
+		"I'm sorry, that request is too vague for me to plan.",
+		`[]`)
+
+	if err := p.plan(t.Context(), types.TaskSpec{TaskID: "t1", Intent: "do the thing"}); err != nil {
+		t.Fatalf("expected no internal error, got %v", err)
+	}
+	if n := len(prompts()); n != 2 {
+		t.Errorf("expected exactly one re-prompt (2 calls), got %d", n)
+	}
+	select {
+	case fr := <-results:
+		if fr.Directive != "abandon" || !strings.Contains(fr.Summary, emptyPlanMessage) {
+			t.Errorf("expected a friendly abandon, got %+v", fr)
+		}
+		if strings.Contains(fr.Summary, "sub-tasks") {
+			t.Errorf("summary leaks the internal error: %q", fr.Summary)
+		}
+	default:
+		t.Fatal("expected a FinalResult")
+	}
+	select {
+	case msg := <-manifestCh:
+		t.Errorf("expected nothing dispatched, got %+v", msg.Payload)
+	default:
+	}
+	if logReg.Get("t1") != nil {
+		t.Error("expected the task log to be closed")
+	}
+}
+
+// --- plan size limits ---
+
+// planOf returns a plan wrapper holding n sequential subtasks.
+func planOf(n int) string {
+	var sts []string
+	for i := 1; i <= n; i++ {
+		sts = append(sts, fmt.Sprintf(`{"intent":"step %d","success_criteria":["output of step %d"],"sequence":%d}`, i, i, i))
+	}
+	return `{"task_criteria":["done"],"subtasks":[` + strings.Join(sts, ",") + `]}`
+}
+
+func TestDispatch_OversizedPlanRepromptsToConsolidate(t *testing.T) {
+	// Re-prompts once with consolidateInstruction when the plan exceeds maxSubtasks,
+	// and dispatches the consolidated plan
+	b := bus.New()
+	manifestCh := b.Subscribe(types.MsgDispatchManifest)
+	p, _, prompts := scriptedPlanner(t, b, nil, planOf(5), planOf(2))
+	p.maxSubtasks, p.maxSubtasksHard = 3, 10
+
+	if err := p.plan(t.Context(), types.TaskSpec{TaskID: "t1", Intent: "tidy the downloads folder"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := prompts()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 planning calls, got %d", len(got))
+	}
+	if want := fmt.Sprintf(consolidateInstruction, 5, 3, 3); strings.Contains(got[0], want) || !strings.Contains(got[1], want) {
+		t.Errorf("expected only the second prompt to carry %q", want)
+	}
+	select {
+	case msg := <-manifestCh:
+		if m := msg.Payload.(types.DispatchManifest); len(m.SubTaskIDs) != 2 {
+			t.Errorf("expected the consolidated 2-subtask plan, got %d subtasks", len(m.SubTaskIDs))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the consolidated plan to be dispatched")
+	}
+	select {
+	case msg := <-manifestCh:
+		t.Errorf("expected one manifest, got another: %+v", msg.Payload)
+	default:
+	}
+}
+
+func TestDispatch_PlanOverHardCapIsRejected(t *testing.T) {
+	// Returns an error, dispatching nothing, when the final plan exceeds maxSubtasksHard
+	b := bus.New()
+	manifestCh := b.Subscribe(types.MsgDispatchManifest)
+	p, _, prompts := scriptedPlanner(t, b, nil, planOf(9))
+	p.maxSubtasks, p.maxSubtasksHard = 3, 6
+
+	err := p.plan(t.Context(), types.TaskSpec{TaskID: "t1", Intent: "tidy the downloads folder"})
+	if err == nil || !strings.Contains(err.Error(), "9 subtasks exceed the hard cap of 6") {
+		t.Errorf("expected a hard-cap rejection, got %v", err)
+	}
+	if n := len(prompts()); n != 2 {
+		t.Errorf("expected one consolidation re-prompt (2 calls), got %d", n)
+	}
+	select {
+	case msg := <-manifestCh:
+		t.Errorf("expected nothing dispatched, got %+v", msg.Payload)
+	default:
+	}
+}
+
+func TestDispatch_ConsolidatedPlanUnderHardCapIsAccepted(t *testing.T) {
+	// The soft limit applies to the first answer only: a consolidated plan still
+	// over it but within maxSubtasksHard is dispatched
+	b := bus.New()
+	manifestCh := b.Subscribe(types.MsgDispatchManifest)
+	p, _, prompts := scriptedPlanner(t, b, nil, planOf(7), planOf(5))
+	p.maxSubtasks, p.maxSubtasksHard = 3, 6
+
+	if err := p.plan(t.Context(), types.TaskSpec{TaskID: "t1", Intent: "tidy the downloads folder"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(prompts()); n != 2 {
+		t.Errorf("expected 2 planning calls, got %d", n)
+	}
+	select {
+	case <-manifestCh:
+	case <-time.After(time.Second):
+		t.Fatal("expected the consolidated plan to be dispatched")
+	}
+}
+
+func TestSubtaskLimitFromEnv(t *testing.T) {
+	// Returns def when unset, not a number, or negative; 0 (no limit) for "0"; the parsed value otherwise
+	for _, tc := range []struct {
+		val  string
+		want int
+	}{{"", 8}, {"many", 8}, {"-1", 8}, {"0", 0}, {" 4 ", 4}} {
+		t.Setenv("ARTOO_MAX_SUBTASKS", tc.val)
+		if got := subtaskLimitFromEnv("ARTOO_MAX_SUBTASKS", 8); got != tc.want {
+			t.Errorf("ARTOO_MAX_SUBTASKS=%q: got %d, want %d", tc.val, got, tc.want)
+		}
+	}
+}
+
+// --- intent success rate ---
+
+// statMem is a MemoryService with no Megrams that reports fixed per-intent stats.
+type statMem struct {
+	stats map[string]types.IntentStats
+}
+
+func (m statMem) Write(types.Megram) {}
+func (m statMem) QueryC(context.Context, string, string) ([]types.SOPRecord, error) {
+	return nil, nil
+}
+func (m statMem) QueryMK(context.Context, string, string) (types.Potentials, error) {
+	return types.Potentials{Action: "Ignore"}, nil
+}
+func (m statMem) QueryRecent(context.Context, string, string, int) ([]types.Megram, error) {
+	return nil, nil
+}
+func (m statMem) RecordNegativeFeedback(context.Context, string, string) {}
+func (m statMem) Clear() (int, error)                                    { return 0, nil }
+func (m statMem) Close()                                                 {}
+func (m statMem) IntentStats(space string) (types.IntentStats, error) {
+	return m.stats[space], nil
+}
+
+func TestQueryMKCTConstraints_IncludesIntentSuccessRate(t *testing.T) {
+	// Prepends the intent's historical success rate (intentStatBlock) when memory tracks one
+	mem := statMem{stats: map[string]types.IntentStats{
+		"intent:find_audio": {Space: "intent:find_audio", Accepts: 1, Abandons: 4},
+		"intent:check_time": {Space: "intent:check_time", Accepts: 9, Abandons: 1},
+	}}
+	logReg := tasklog.NewRegistry(t.TempDir())
+	p := New(bus.New(), nil, logReg, mem, nil)
+
+	got := p.queryMKCTConstraints(t.Context(), "find_audio", logReg.Get("t1"))
+	if !strings.Contains(got, "1 of 5 past runs accepted (20%)") || !strings.Contains(got, "LOW SUCCESS") {
+		t.Errorf("low-success intent constraints = %q, want rate and LOW SUCCESS instruction", got)
+	}
+
+	got = p.queryMKCTConstraints(t.Context(), "check_time", logReg.Get("t2"))
+	if !strings.Contains(got, "9 of 10 past runs accepted (90%)") || strings.Contains(got, "LOW SUCCESS") {
+		t.Errorf("high-success intent constraints = %q, want rate without LOW SUCCESS", got)
+	}
+
+	if got := p.queryMKCTConstraints(t.Context(), "other_intent", logReg.Get("t3")); got != "" {
+		t.Errorf("unmatched intent constraints = %q, want empty", got)
+	}
+}
+
+func TestIntentStatBlock_NeedsMinimumRuns(t *testing.T) {
+	// Returns "" when fewer than minIntentStatRuns outcomes are recorded
+	// Returns "" when p.mem does not implement IntentStats or the lookup fails
+	mem := statMem{stats: map[string]types.IntentStats{
+		"intent:new": {Accepts: 0, Abandons: minIntentStatRuns - 1},
+	}}
+	p := &Planner{mem: mem}
+	if got := p.intentStatBlock("intent:new"); got != "" {
+		t.Errorf("block with too few runs = %q, want empty", got)
+	}
+	// Wrapping in the interface hides IntentStats.
+	p = &Planner{mem: struct{ types.MemoryService }{mem}}
+	if got := p.intentStatBlock("intent:new"); got != "" {
+		t.Errorf("block without IntentStats = %q, want empty", got)
+	}
+}
+
+// --- calibration bundle ---
+
+// calibMem answers QueryCalibration from fixed bundles and counts each call;
+// its QueryC, QueryMK, and QueryRecent (from statMem) return nothing.
+type calibMem struct {
+	statMem
+	bundles map[string]types.CalibrationBundle
+	calls   []string
+}
+
+func (m *calibMem) QueryCalibration(_ context.Context, space, _ string, n int) (types.CalibrationBundle, error) {
+	m.calls = append(m.calls, fmt.Sprintf("%s/%d", space, n))
+	return m.bundles[space], nil
+}
+
+func TestQueryMKCTConstraints_UsesCalibrationBundle(t *testing.T) {
+	// Queries the task space and "global:user" through calibrate: one round-trip each
+	// when memory implements QueryCalibration
+	mem := &calibMem{bundles: map[string]types.CalibrationBundle{
+		"intent:t1": {
+			SOPs:       []types.SOPRecord{{Content: "use find -name", Sigma: 1.0}},
+			Potentials: types.Potentials{Attention: 2, Decision: 2, Action: "Exploit"},
+			Recent:     []types.Megram{{Content: "listed files with find", State: "accept", Sigma: 1.0}},
+		},
+		"global:user": {SOPs: []types.SOPRecord{{Content: "user prefers metric units", Sigma: 1.0}}},
+	}}
+	logReg := tasklog.NewRegistry(t.TempDir())
+	p := New(bus.New(), nil, logReg, mem, nil)
+
+	got := p.queryMKCTConstraints(t.Context(), "t1", logReg.Get("t1"))
+	for _, want := range []string{"use find -name", "user prefers metric units", "listed files with find"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("constraints = %q, want %q", got, want)
+		}
+	}
+	want := []string{fmt.Sprintf("intent:t1/%d", recentPerSpace), fmt.Sprintf("global:user/%d", recentPerSpace)}
+	if !reflect.DeepEqual(mem.calls, want) {
+		t.Errorf("QueryCalibration calls = %v, want %v", mem.calls, want)
+	}
+}
+
+func TestWeightedSOPs_StrongestSpaceFirst(t *testing.T) {
+	// Orders SOPs by |σ|·Attention of their bundle, descending
+	// Keeps bundle order, then each bundle's own order, among equal weights
+	task := types.CalibrationBundle{
+		SOPs:       []types.SOPRecord{{Content: "task rule"}, {Content: "task constraint", Sigma: -1}, {Content: "task practice", Sigma: 1}},
+		Potentials: types.Potentials{Attention: 0.5},
+	}
+	global := types.CalibrationBundle{
+		SOPs:       []types.SOPRecord{{Content: "global practice", Sigma: 1}, {Content: "global constraint", Sigma: -1}},
+		Potentials: types.Potentials{Attention: 2},
+	}
+	var got []string
+	for _, sop := range weightedSOPs(task, global) {
+		got = append(got, sop.Content)
+	}
+	want := []string{"global practice", "global constraint", "task constraint", "task practice", "task rule"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("weightedSOPs order = %q, want %q", got, want)
 	}
 }
 
-func TestExtractJSON_NoJSON(t *testing.T) {
-	// Returns s unchanged when no '{' or '[' is found
-	raw := "just some prose with no JSON"
-	if got := extractJSON(raw); got != raw {
-		t.Errorf("expected unchanged, got %q", got)
+func TestCalibrate_FallsBackToSeparateQueries(t *testing.T) {
+	// Falls back to QueryC, QueryMK, and QueryRecent otherwise
+	p := &Planner{mem: statMem{}}
+	b := p.calibrate(t.Context(), "intent:x", "env:local")
+	if b.Potentials.Action != "Ignore" || len(b.SOPs) != 0 || len(b.Recent) != 0 {
+		t.Errorf("fallback bundle = %+v, want statMem's empty results", b)
 	}
 }
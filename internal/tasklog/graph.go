@@ -0,0 +1,218 @@
+package tasklog
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// graphRound is one planning round reconstructed from a task log: its subtasks
+// grouped by sequence number, in the order the groups first appeared.
+type graphRound struct {
+	seqs   []int
+	groups map[int][]*graphSubtask
+	exit   string // directive that sent the task back to R2; "" for the last round
+}
+
+// Rounds numbers each of events with the planning round it belongs to, from 1.
+// A round ends at a plan_directive or replan event once it has begun a subtask,
+// and the next one starts at the following subtask_begin. A replan may reuse the
+// previous plan's subtask IDs, so it takes the round and the ID to name a subtask.
+//
+// Expectations:
+//   - Returns one round number per event, starting at 1
+//   - Starts a new round at the first subtask_begin after a plan_directive or replan
+//   - A plan_directive or replan before any subtask_begin does not start a round
+//   - Events between a replan and the next subtask_begin stay in the ending round
+func Rounds(events []Event) []int {
+	rounds := make([]int, len(events))
+	round, begun, ended := 1, false, false
+	for i, e := range events {
+		switch e.Kind {
+		case KindPlanDirective, KindReplan:
+			ended = begun
+		case KindSubtaskBegin:
+			if ended {
+				round, begun, ended = round+1, false, false
+			}
+			begun = true
+		}
+		rounds[i] = round
+	}
+	return rounds
+}
+
+// subtaskRun names one subtask in one planning round.
+type subtaskRun struct {
+	round int
+	id    string
+}
+
+// graphSubtask is one subtask node in the rendered flowchart.
+type graphSubtask struct {
+	node        string // Mermaid node ID
+	id, intent  string
+	status      string // subtask_end status; "" while still running
+	corrections int
+}
+
+// RenderMermaid renders the most recent run of taskID's log under dir as a
+// Mermaid flowchart: the task as the start node, one subgraph per sequence
+// group of each planning round, a self-edge on each subtask R4a corrected, an
+// edge back to the R2 node labelled with the directive for each replan, and the
+// terminal directive as the end node.
+//
+// Expectations:
+//   - Returns an error when taskID has no log under dir, or names a path
+//   - Renders only the events after the last task_begin (IDs are reused across sessions)
+//   - Emits one node per subtask and round, labelled with its intent and final status;
+//     a replan reusing a subtask ID gets a new node, a re-dispatch in the same round does not
+//   - Groups a round's subtasks into one subgraph per sequence number, in order
+//   - Draws "N corrections" self-edges for subtasks that received correction events
+//   - Draws an edge back to the planner, labelled with the directive, for each replan round
+//   - Ends at the last terminal GGS directive, else the task_end status, else "running"
+func RenderMermaid(dir, taskID string) (string, error) {
+	if taskID == "" || filepath.Base(taskID) != taskID {
+		return "", fmt.Errorf("tasklog: invalid task ID %q", taskID)
+	}
+	events := readEventsFile(filepath.Join(dir, taskID+".jsonl"))
+	if len(events) == 0 {
+		return "", fmt.Errorf("tasklog: no log for task %q in %s", taskID, dir)
+	}
+	for i := len(events) - 1; i > 0; i-- {
+		if events[i].Kind == KindTaskBegin {
+			events = events[i:]
+			break
+		}
+	}
+
+	intent, end := taskID, "running"
+	var rounds []*graphRound
+	nodes := make(map[subtaskRun]*graphSubtask)
+	pending := "" // directive of a replan whose next plan has not started yet
+	roundOf := Rounds(events)
+	for i, e := range events {
+		run := subtaskRun{roundOf[i], e.SubtaskID}
+		switch e.Kind {
+		case KindTaskBegin:
+			if e.Intent != "" {
+				intent = e.Intent
+			}
+		case KindSubtaskBegin:
+			if run.round > len(rounds) {
+				if len(rounds) > 0 {
+					rounds[len(rounds)-1].exit = pending
+				}
+				pending = ""
+				rounds = append(rounds, &graphRound{groups: make(map[int][]*graphSubtask)})
+			}
+			if nodes[run] != nil {
+				continue // re-dispatched within its round: same node
+			}
+			r := rounds[len(rounds)-1]
+			st := &graphSubtask{node: fmt.Sprintf("s%d", len(nodes)+1), id: e.SubtaskID, intent: e.Intent}
+			nodes[run] = st
+			if _, ok := r.groups[e.Sequence]; !ok {
+				r.seqs = append(r.seqs, e.Sequence)
+			}
+			r.groups[e.Sequence] = append(r.groups[e.Sequence], st)
+		case KindSubtaskEnd:
+			if st := nodes[run]; st != nil {
+				st.status = e.Status
+			}
+		case KindCorrection:
+			if st := nodes[run]; st != nil {
+				st.corrections++
+			}
+		case KindPlanDirective:
+			pending = e.Directive
+		case KindReplan:
+			if pending == "" {
+				pending = "replan"
+			}
+		case KindGGSDecision:
+			switch e.Directive {
+			case "accept", "success", "abandon":
+				end = e.Directive
+			}
+		case KindTaskEnd:
+			if end == "running" && e.Status != "" {
+				end = e.Status
+			}
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("flowchart TD\n")
+	fmt.Fprintf(&sb, "    task([\"%s\"])\n", mermaidText(intent))
+	sb.WriteString("    planner[[\"R2 Planner\"]]\n")
+	sb.WriteString("    task --> planner\n")
+	last := "planner" // the node the end node follows
+	for ri, r := range rounds {
+		prev := "planner"
+		for _, seq := range r.seqs {
+			group := fmt.Sprintf("r%dq%d", ri+1, seq)
+			fmt.Fprintf(&sb, "    subgraph %s [\"round %d · sequence %d\"]\n", group, ri+1, seq)
+			for _, st := range r.groups[seq] {
+				fmt.Fprintf(&sb, "        %s[\"%s\"]\n", st.node, mermaidText(subtaskLabel(st)))
+			}
+			sb.WriteString("    end\n")
+			if prev == "planner" && ri > 0 {
+				fmt.Fprintf(&sb, "    planner -- \"round %d\" --> %s\n", ri+1, group)
+			} else {
+				fmt.Fprintf(&sb, "    %s --> %s\n", prev, group)
+			}
+			for _, st := range r.groups[seq] {
+				if st.corrections > 0 {
+					fmt.Fprintf(&sb, "    %s -- \"%d correction%s\" --> %s\n", st.node, st.corrections, plural(st.corrections), st.node)
+				}
+			}
+			prev = group
+		}
+		if r.exit != "" && prev != "planner" {
+			fmt.Fprintf(&sb, "    %s -- \"%s\" --> planner\n", prev, mermaidText(r.exit))
+		}
+		last = prev
+	}
+	fmt.Fprintf(&sb, "    done([\"%s\"])\n", mermaidText(end))
+	fmt.Fprintf(&sb, "    %s --> done\n", last)
+
+	sb.WriteString("    classDef matched fill:#d4f7d4,stroke:#2e7d32\n")
+	sb.WriteString("    classDef failed fill:#fbd5d5,stroke:#c62828\n")
+	for _, r := range rounds {
+		for _, seq := range r.seqs {
+			for _, st := range r.groups[seq] {
+				if st.status == "matched" || st.status == "failed" {
+					fmt.Fprintf(&sb, "    class %s %s\n", st.node, st.status)
+				}
+			}
+		}
+	}
+	return sb.String(), nil
+}
+
+// subtaskLabel is a subtask node's text: its intent, then its final status.
+func subtaskLabel(st *graphSubtask) string {
+	label := st.intent
+	if label == "" {
+		label = st.id
+	}
+	if st.status != "" {
+		label += " · " + st.status
+	}
+	return label
+}
+
+// mermaidText makes s safe inside a quoted Mermaid label: quotes become #quot;
+// and newlines spaces.
+func mermaidText(s string) string {
+	s = strings.ReplaceAll(s, `"`, "#quot;")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -0,0 +1,139 @@
+package tasklog
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeReplanRun logs a task whose first plan fails, is replanned with
+// change_path, and is accepted on the second round.
+func writeReplanRun(t *testing.T, dir string) {
+	t.Helper()
+	r := NewRegistry(dir)
+	tl := r.Open("find_report", "Find the quarterly report")
+	tl.SubtaskBegin("a", "list Downloads", 1, nil)
+	tl.SubtaskBegin("b", "search mail", 1, nil)
+	tl.SubtaskEnd("a", "matched")
+	tl.Correction("b", "wrong folder", "search Archive", 1)
+	tl.SubtaskEnd("b", "failed")
+	tl.GGSDecision(0.8, 0.5, 0.3, 0.6, 0.1, "change_path", "mail search keeps failing", 0)
+	tl.PlanDirective("change_path", []string{"mail"}, nil, "logical", "mail search keeps failing")
+	tl.Replan("report not found", 1)
+	tl.SubtaskBegin("c", `grep "Q3" in Documents`, 1, nil)
+	tl.SubtaskEnd("c", "matched")
+	tl.SubtaskBegin("d", "summarise the report", 2, nil)
+	tl.SubtaskEnd("d", "matched")
+	tl.GGSDecision(0, 0, 0, 0, 0, "accept", "all criteria met", 1)
+	r.Close("find_report", "accepted")
+}
+
+func TestRenderMermaid_SubtasksReplanAndEnd(t *testing.T) {
+	// One node per subtask, correction self-edges, an edge back to the planner per replan, terminal directive as the end node
+	dir := t.TempDir()
+	writeReplanRun(t, dir)
+	got, err := RenderMermaid(dir, "find_report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"flowchart TD",
+		`task(["Find the quarterly report"])`,
+		`s1["list Downloads · matched"]`,
+		`s2["search mail · failed"]`,
+		`s3["grep #quot;Q3#quot; in Documents · matched"]`,
+		`s4["summarise the report · matched"]`,
+		`s2 -- "1 correction" --> s2`,
+		`r1q1 -- "change_path" --> planner`,
+		`planner -- "round 2" --> r2q1`,
+		"r2q1 --> r2q2",
+		`done(["accept"])`,
+		"r2q2 --> done",
+		"class s2 failed",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestRenderMermaid_LatestRunOnly(t *testing.T) {
+	// Renders only the events after the last task_begin; a run with no GGS decision ends at its status
+	dir := t.TempDir()
+	writeReplanRun(t, dir)
+	r := NewRegistry(dir)
+	tl := r.Open("find_report", "Find the quarterly report again")
+	tl.SubtaskBegin("e", "open Finder", 1, nil)
+	got, err := RenderMermaid(dir, "find_report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(got, "list Downloads") || !strings.Contains(got, `s1["open Finder"]`) || !strings.Contains(got, `done(["running"])`) {
+		t.Errorf("expected only the latest run, got:\n%s", got)
+	}
+}
+
+func TestRenderMermaid_ReplanReusingSubtaskIDs(t *testing.T) {
+	// A replan reusing a subtask ID gets a new node, a re-dispatch in the same round does not
+	dir := t.TempDir()
+	r := NewRegistry(dir)
+	tl := r.Open("find_report", "Find the quarterly report")
+	tl.SubtaskBegin("st1", "search mail", 1, nil)
+	tl.Correction("st1", "wrong folder", "search Archive", 1)
+	tl.SubtaskEnd("st1", "failed")
+	tl.PlanDirective("change_path", []string{"mail"}, nil, "logical", "mail search keeps failing")
+	tl.SubtaskBegin("st1", "search Documents", 1, nil)
+	tl.SubtaskBegin("st1", "search Documents", 1, nil)
+	tl.SubtaskEnd("st1", "matched")
+	r.Close("find_report", "accepted")
+
+	got, err := RenderMermaid(dir, "find_report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`s1["search mail · failed"]`,
+		`s1 -- "1 correction" --> s1`,
+		`s2["search Documents · matched"]`,
+		`planner -- "round 2" --> r2q1`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "s3") || strings.Contains(got, "s2 -- ") {
+		t.Errorf("round 2 should have one uncorrected node, got:\n%s", got)
+	}
+}
+
+func TestRounds_NumbersPlanningRounds(t *testing.T) {
+	// Starts a new round at the first subtask_begin after a plan_directive or replan
+	// A plan_directive or replan before any subtask_begin does not start a round
+	// Events between a replan and the next subtask_begin stay in the ending round
+	events := []Event{
+		{Kind: KindTaskBegin},
+		{Kind: KindReplan},
+		{Kind: KindSubtaskBegin},
+		{Kind: KindSubtaskEnd},
+		{Kind: KindPlanDirective},
+		{Kind: KindReplan},
+		{Kind: KindSubtaskEnd},
+		{Kind: KindSubtaskBegin},
+		{Kind: KindSubtaskBegin},
+	}
+	got := Rounds(events)
+	want := []int{1, 1, 1, 1, 1, 1, 1, 2, 2}
+	if !slices.Equal(got, want) {
+		t.Errorf("Rounds = %v, want %v", got, want)
+	}
+}
+
+func TestRenderMermaid_Errors(t *testing.T) {
+	// Returns an error when taskID has no log under dir, or names a path
+	dir := t.TempDir()
+	for _, id := range []string{"", "missing", "../find_report", "a/b"} {
+		if _, err := RenderMermaid(dir, id); err == nil {
+			t.Errorf("RenderMermaid(%q) should fail", id)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package tasklog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QueryFilter selects task runs in Query. Zero-valued fields match everything.
+type QueryFilter struct {
+	Intent string    // case-insensitive substring of the task_begin intent
+	Tag    string    // one of the task_begin tags, case-insensitive
+	Status string    // "accepted" | "abandoned" | "cancelled"; runs without a task_end have status ""
+	Since  time.Time // inclusive lower bound on the task_begin timestamp
+	Until  time.Time // exclusive upper bound on the task_begin timestamp
+}
+
+// TaskSummary describes one task run reconstructed from its task_begin/task_end events.
+type TaskSummary struct {
+	TaskID        string    `json:"task_id"`
+	Intent        string    `json:"intent"`
+	Tags          []string  `json:"tags,omitempty"`
+	Status        string    `json:"status"` // "" when the run never reached task_end
+	Started       time.Time `json:"started"`
+	ElapsedMs     int64     `json:"elapsed_ms"`
+	TotalTokens   int       `json:"total_tokens"`
+	ToolCallCount int       `json:"tool_call_count"`
+	SubtaskCount  int       `json:"subtask_count"`  // subtask_begin events, across replan rounds
+	CriteriaCount int       `json:"criteria_count"` // success criteria over those subtasks
+	Corrections   int       `json:"corrections"`    // R4a correction events
+	Replans       int       `json:"replans"`        // R4b replan events
+}
+
+// Query scans every task log under dir and returns the runs matching f, newest first.
+// Task IDs are reused across sessions and the log file is opened in append mode, so one
+// file can hold several runs; each task_begin that follows a task_end starts a new run.
+//
+// Expectations:
+//   - Returns nil, nil when dir does not exist
+//   - Intent matching is case-insensitive substring; empty Intent matches all runs
+//   - Tag matches runs carrying that tag, case-insensitive; empty Tag matches all runs
+//   - Status matches exactly; empty Status matches all runs
+//   - Since is inclusive, Until is exclusive; zero bounds are open
+//   - Results are sorted by Started descending, ties broken by TaskID
+//   - Files that are not *.jsonl and malformed lines are skipped
+func Query(dir string, f QueryFilter) ([]TaskSummary, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []TaskSummary
+	for _, ent := range entries {
+		if ent.IsDir() || !strings.HasSuffix(ent.Name(), ".jsonl") {
+			continue
+		}
+		for _, s := range summarize(readEventsFile(filepath.Join(dir, ent.Name()))) {
+			if f.matches(s) {
+				out = append(out, s)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Started.Equal(out[j].Started) {
+			return out[i].Started.After(out[j].Started)
+		}
+		return out[i].TaskID < out[j].TaskID
+	})
+	return out, nil
+}
+
+// matches reports whether s passes every non-zero field of f.
+func (f QueryFilter) matches(s TaskSummary) bool {
+	if f.Intent != "" && !strings.Contains(strings.ToLower(s.Intent), strings.ToLower(f.Intent)) {
+		return false
+	}
+	if f.Tag != "" && !slices.ContainsFunc(s.Tags, func(t string) bool { return strings.EqualFold(t, f.Tag) }) {
+		return false
+	}
+	if f.Status != "" && s.Status != f.Status {
+		return false
+	}
+	if !f.Since.IsZero() && s.Started.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !s.Started.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// summarize folds one file's events into per-run summaries.
+// A task_begin while a run is still open (replan rounds reuse the open log) is ignored.
+func summarize(events []Event) []TaskSummary {
+	var out []TaskSummary
+	open := false
+	for _, e := range events {
+		switch e.Kind {
+		case KindTaskBegin:
+			if open {
+				continue
+			}
+			started, _ := time.Parse(time.RFC3339Nano, e.Timestamp)
+			out = append(out, TaskSummary{TaskID: e.TaskID, Intent: e.Intent, Tags: e.Tags, Started: started})
+			open = true
+		case KindSubtaskBegin:
+			if !open {
+				continue
+			}
+			s := &out[len(out)-1]
+			s.SubtaskCount++
+			s.CriteriaCount += len(e.Criteria)
+		case KindCorrection:
+			if open {
+				out[len(out)-1].Corrections++
+			}
+		case KindReplan:
+			if open {
+				out[len(out)-1].Replans++
+			}
+		case KindTaskEnd:
+			if !open {
+				continue
+			}
+			s := &out[len(out)-1]
+			s.Status = e.Status
+			s.ElapsedMs = e.ElapsedMs
+			s.TotalTokens = e.TotalTokens
+			s.ToolCallCount = e.ToolCallCount
+			open = false
+		}
+	}
+	return out
+}
+
+// CostHistory holds per-subtask cost averages over past task runs — the
+// cross-task aggregate R2 uses to estimate a new plan's cost before dispatching it.
+type CostHistory struct {
+	Runs               int     `json:"runs"`                 // finished runs with at least one subtask
+	Subtasks           int     `json:"subtasks"`             // subtasks over those runs
+	TokensPerSubtask   float64 `json:"tokens_per_subtask"`   // total tokens / subtasks
+	MsPerSubtask       float64 `json:"ms_per_subtask"`       // wall-clock ms / subtasks
+	CriteriaPerSubtask float64 `json:"criteria_per_subtask"` // success criteria / subtasks
+}
+
+// HistoricalCost aggregates the task logs under dir into per-subtask averages.
+// Averages are ratios of totals, so a long run weighs more than a short one.
+//
+// Expectations:
+//   - Returns the zero CostHistory, nil when dir does not exist or holds no usable runs
+//   - Counts only runs that reached task_end and began at least one subtask
+//   - Includes accepted, abandoned, and cancelled runs alike (all of them cost tokens)
+//   - TokensPerSubtask, MsPerSubtask, and CriteriaPerSubtask divide run totals by total subtasks
+func HistoricalCost(dir string) (CostHistory, error) {
+	runs, err := Query(dir, QueryFilter{})
+	if err != nil {
+		return CostHistory{}, err
+	}
+	var h CostHistory
+	var tokens, criteria int
+	var ms int64
+	for _, r := range runs {
+		if r.Status == "" || r.SubtaskCount == 0 {
+			continue
+		}
+		h.Runs++
+		h.Subtasks += r.SubtaskCount
+		tokens += r.TotalTokens
+		ms += r.ElapsedMs
+		criteria += r.CriteriaCount
+	}
+	if h.Subtasks == 0 {
+		return CostHistory{}, nil
+	}
+	n := float64(h.Subtasks)
+	h.TokensPerSubtask = float64(tokens) / n
+	h.MsPerSubtask = float64(ms) / n
+	h.CriteriaPerSubtask = float64(criteria) / n
+	return h, nil
+}
+
+// readEventsFile parses every valid JSONL line of path; nil when the file is unreadable.
+func readEventsFile(path string) []Event {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var events []Event
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal([]byte(line), &e); err == nil {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// ReadLLMCalls returns the llm_call events recorded at path, in log order, for
+// --replay-llm. path is one task log file or a directory of them; a directory's
+// calls are merged and ordered by timestamp.
+//
+// Expectations:
+//   - Returns only llm_call events, in the order they were written
+//   - Reads every *.jsonl file when path is a directory, sorted by timestamp across files
+//   - Returns an error when path does not exist or holds no llm_call events
+func ReadLLMCalls(path string) ([]Event, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		files = nil
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, ent := range entries {
+			if !ent.IsDir() && strings.HasSuffix(ent.Name(), ".jsonl") {
+				files = append(files, filepath.Join(path, ent.Name()))
+			}
+		}
+	}
+	var calls []Event
+	for _, f := range files {
+		for _, e := range readEventsFile(f) {
+			if e.Kind == KindLLMCall {
+				calls = append(calls, e)
+			}
+		}
+	}
+	if len(calls) == 0 {
+		return nil, fmt.Errorf("tasklog: no llm_call events in %s", path)
+	}
+	if len(files) > 1 {
+		// RFC3339Nano trims trailing zeros, so compare parsed times, not strings.
+		at := func(e Event) time.Time { t, _ := time.Parse(time.RFC3339Nano, e.Timestamp); return t }
+		sort.SliceStable(calls, func(i, j int) bool { return at(calls[i]).Before(at(calls[j])) })
+	}
+	return calls, nil
+}
@@ -0,0 +1,326 @@
+package tasklog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeRun appends one task_begin (and task_end when status != "") to dir/<taskID>.jsonl.
+func writeRun(t *testing.T, dir, taskID, intent, status string, started time.Time) {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(dir, taskID+".jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	events := []Event{{Kind: KindTaskBegin, Timestamp: started.UTC().Format(time.RFC3339Nano), TaskID: taskID, Intent: intent}}
+	if status != "" {
+		events = append(events,
+			Event{Kind: KindToolCall, Timestamp: started.Add(time.Second).UTC().Format(time.RFC3339Nano), Tool: "shell"},
+			Event{Kind: KindTaskEnd, Timestamp: started.Add(2 * time.Second).UTC().Format(time.RFC3339Nano), TaskID: taskID, Status: status, ElapsedMs: 2000, TotalTokens: 150, ToolCallCount: 1})
+	}
+	for _, e := range events {
+		data, _ := json.Marshal(e)
+		if _, err := f.Write(append(data, '\n')); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// seedQueryDir writes four runs spread over three days and returns the dir and day 0.
+func seedQueryDir(t *testing.T) (string, time.Time) {
+	t.Helper()
+	dir := t.TempDir()
+	day0 := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	writeRun(t, dir, "search_golang_news", "Search the web for Golang release news", "accepted", day0)
+	writeRun(t, dir, "count_go_files", "Count Go files in the project", "abandoned", day0.Add(24*time.Hour))
+	writeRun(t, dir, "find_tax_pdf", "Find my tax PDF in Downloads", "accepted", day0.Add(48*time.Hour))
+	writeRun(t, dir, "search_weather", "Search weather for Beijing", "", day0.Add(48*time.Hour+time.Hour))
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir, day0
+}
+
+func taskIDs(ss []TaskSummary) []string {
+	var ids []string
+	for _, s := range ss {
+		ids = append(ids, s.TaskID)
+	}
+	return ids
+}
+
+func assertIDs(t *testing.T, got []TaskSummary, want ...string) {
+	t.Helper()
+	ids := taskIDs(got)
+	if len(ids) != len(want) {
+		t.Fatalf("ids = %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("ids = %v, want %v", ids, want)
+		}
+	}
+}
+
+func TestQuery_EmptyFilterReturnsAllNewestFirst(t *testing.T) {
+	// Zero filter matches every run; results are sorted by Started descending
+	dir, _ := seedQueryDir(t)
+	got, err := Query(dir, QueryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, got, "search_weather", "find_tax_pdf", "count_go_files", "search_golang_news")
+}
+
+func TestQuery_FiltersByIntentSubstringCaseInsensitive(t *testing.T) {
+	// Intent matches a case-insensitive substring of the task_begin intent
+	dir, _ := seedQueryDir(t)
+	got, err := Query(dir, QueryFilter{Intent: "SEARCH"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, got, "search_weather", "search_golang_news")
+}
+
+func TestQuery_FiltersByStatus(t *testing.T) {
+	// Status matches the task_end status exactly
+	dir, _ := seedQueryDir(t)
+	got, err := Query(dir, QueryFilter{Status: "abandoned"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, got, "count_go_files")
+	got, _ = Query(dir, QueryFilter{Status: "accepted"})
+	assertIDs(t, got, "find_tax_pdf", "search_golang_news")
+}
+
+func TestQuery_FiltersByDateRange(t *testing.T) {
+	// Since is inclusive and Until is exclusive on the task_begin timestamp
+	dir, day0 := seedQueryDir(t)
+	got, err := Query(dir, QueryFilter{Since: day0.Add(24 * time.Hour), Until: day0.Add(48 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, got, "count_go_files")
+	got, _ = Query(dir, QueryFilter{Since: day0.Add(48 * time.Hour)})
+	assertIDs(t, got, "search_weather", "find_tax_pdf")
+}
+
+func TestQuery_CombinedFilters(t *testing.T) {
+	// All non-zero fields must match
+	dir, day0 := seedQueryDir(t)
+	got, err := Query(dir, QueryFilter{Intent: "search", Status: "accepted", Until: day0.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, got, "search_golang_news")
+}
+
+func TestQuery_SummaryCarriesTaskEndFields(t *testing.T) {
+	// Summaries carry intent from task_begin and status/cost from task_end
+	dir, day0 := seedQueryDir(t)
+	got, _ := Query(dir, QueryFilter{Intent: "tax"})
+	assertIDs(t, got, "find_tax_pdf")
+	s := got[0]
+	if s.Intent != "Find my tax PDF in Downloads" || s.Status != "accepted" {
+		t.Errorf("intent/status = %q/%q", s.Intent, s.Status)
+	}
+	if !s.Started.Equal(day0.Add(48*time.Hour)) || s.ElapsedMs != 2000 || s.TotalTokens != 150 || s.ToolCallCount != 1 {
+		t.Errorf("summary = %+v", s)
+	}
+}
+
+func TestQuery_SplitsReusedTaskIDIntoRuns(t *testing.T) {
+	// A task_begin after a task_end in the same file starts a new run
+	dir := t.TempDir()
+	day0 := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	writeRun(t, dir, "list_files", "List files", "abandoned", day0)
+	writeRun(t, dir, "list_files", "List files again", "accepted", day0.Add(time.Hour))
+	got, err := Query(dir, QueryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Status != "accepted" || got[1].Status != "abandoned" {
+		t.Fatalf("runs = %+v", got)
+	}
+}
+
+func TestQuery_ReadsRegistryLogs(t *testing.T) {
+	// Logs written through Registry round-trip through Query
+	dir := filepath.Join(t.TempDir(), "tasks")
+	r := NewRegistry(dir)
+	r.Open("task1", "summarise the report")
+	r.Close("task1", "accepted")
+	got, err := Query(dir, QueryFilter{Intent: "report", Status: "accepted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, got, "task1")
+	if got[0].Started.IsZero() {
+		t.Error("Started should be parsed from the task_begin timestamp")
+	}
+}
+
+func TestQuery_FiltersByTag(t *testing.T) {
+	// Tag matches runs carrying that tag, case-insensitive; the tags come from task_begin
+	dir := filepath.Join(t.TempDir(), "tasks")
+	r := NewRegistry(dir)
+	r.Open("news", "search Go news", "research", "work")
+	r.Close("news", "accepted")
+	r.Open("videos", "find large videos", "files")
+	r.Close("videos", "accepted")
+
+	if begin := readEventsFile(filepath.Join(dir, "news.jsonl"))[0]; begin.Kind != KindTaskBegin || len(begin.Tags) != 2 {
+		t.Fatalf("expected the tags on task_begin, got %+v", begin)
+	}
+	got, err := Query(dir, QueryFilter{Tag: "Research"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIDs(t, got, "news")
+	if len(got[0].Tags) != 2 || got[0].Tags[0] != "research" {
+		t.Errorf("summary tags = %v", got[0].Tags)
+	}
+}
+
+func TestQuery_CountsCorrectionsAndReplans(t *testing.T) {
+	// Summaries count a run's correction and replan events, not those of other runs in the file
+	dir := filepath.Join(t.TempDir(), "tasks")
+	r := NewRegistry(dir)
+	tl := r.Open("fix_build", "fix the build")
+	tl.Correction("s1", "tests still fail", "run go vet", 1)
+	tl.Correction("s1", "vet error", "fix the import", 2)
+	tl.Replan("tests still failing", 1)
+	r.Close("fix_build", "accepted")
+	r.Open("fix_build", "fix the build again")
+	r.Close("fix_build", "accepted")
+
+	got, err := Query(dir, QueryFilter{})
+	if err != nil || len(got) != 2 {
+		t.Fatalf("Query = %+v, %v", got, err)
+	}
+	if first := got[1]; first.Corrections != 2 || first.Replans != 1 {
+		t.Errorf("first run: corrections=%d replans=%d, want 2 and 1", first.Corrections, first.Replans)
+	}
+	if second := got[0]; second.Corrections != 0 || second.Replans != 0 {
+		t.Errorf("second run: corrections=%d replans=%d, want 0 and 0", second.Corrections, second.Replans)
+	}
+}
+
+func TestQuery_MissingDirReturnsNil(t *testing.T) {
+	// A nonexistent directory is not an error
+	got, err := Query(filepath.Join(t.TempDir(), "absent"), QueryFilter{})
+	if err != nil || got != nil {
+		t.Errorf("got %v, %v; want nil, nil", got, err)
+	}
+}
+
+func TestReadLLMCalls_ReturnsLLMCallsInOrder(t *testing.T) {
+	// Only llm_call events are returned, in the order they were written
+	dir := t.TempDir()
+	r := NewRegistry(dir)
+	tl := r.Open("task1", "count files")
+	tl.LLMCall("planner", "plan-sys", "count files", "plan", 10, 5, 100, 0)
+	tl.ToolCall("s1", "shell", "ls", "a.go", "text", "", 5)
+	tl.LLMCall("executor", "exec-sys", "subtask", "done", 20, 8, 200, 1)
+	r.Close("task1", "accepted")
+	got, err := ReadLLMCalls(filepath.Join(dir, "task1.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Response != "plan" || got[1].Response != "done" {
+		t.Fatalf("calls = %+v", got)
+	}
+	if got[1].Role != "executor" || got[1].SystemPrompt != "exec-sys" || got[1].UserPrompt != "subtask" || got[1].PromptTokens != 20 {
+		t.Errorf("second call = %+v", got[1])
+	}
+}
+
+func TestReadLLMCalls_DirectoryMergesByTimestamp(t *testing.T) {
+	// A directory's calls are merged across files and sorted by timestamp
+	dir := t.TempDir()
+	day0 := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	write := func(name string, at time.Time, resp string) {
+		data, _ := json.Marshal(Event{Kind: KindLLMCall, Timestamp: at.Format(time.RFC3339Nano), Response: resp})
+		f, _ := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		f.Write(append(data, '\n'))
+		f.Close()
+	}
+	write("a.jsonl", day0.Add(120*time.Millisecond), "third")
+	write("b.jsonl", day0, "first")
+	write("b.jsonl", day0.Add(100*time.Millisecond), "second")
+	got, err := ReadLLMCalls(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].Response != "first" || got[1].Response != "second" || got[2].Response != "third" {
+		t.Errorf("calls = %+v", got)
+	}
+}
+
+func TestReadLLMCalls_ErrorsWithoutCalls(t *testing.T) {
+	// A missing path or a log with no llm_call events is an error
+	if _, err := ReadLLMCalls(filepath.Join(t.TempDir(), "absent.jsonl")); err == nil {
+		t.Error("missing path should fail")
+	}
+	dir, _ := seedQueryDir(t)
+	if _, err := ReadLLMCalls(dir); err == nil {
+		t.Error("log without llm_call events should fail")
+	}
+}
+
+// writeCostRun writes one accepted run whose subtasks carry the given criteria counts.
+func writeCostRun(t *testing.T, dir, taskID string, criteria []int, tokens int, ms int64) {
+	t.Helper()
+	ts := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC).Format(time.RFC3339Nano)
+	events := []Event{{Kind: KindTaskBegin, Timestamp: ts, TaskID: taskID, Intent: "cost run"}}
+	for i, n := range criteria {
+		events = append(events, Event{Kind: KindSubtaskBegin, Timestamp: ts, SubtaskID: fmt.Sprintf("%s-%d", taskID, i), Sequence: i + 1, Criteria: make([]string, n)})
+	}
+	events = append(events, Event{Kind: KindTaskEnd, Timestamp: ts, TaskID: taskID, Status: "accepted", ElapsedMs: ms, TotalTokens: tokens})
+	var lines []byte
+	for _, e := range events {
+		data, _ := json.Marshal(e)
+		lines = append(append(lines, data...), '\n')
+	}
+	if err := os.WriteFile(filepath.Join(dir, taskID+".jsonl"), lines, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistoricalCost_AveragesPerSubtask(t *testing.T) {
+	// TokensPerSubtask, MsPerSubtask, and CriteriaPerSubtask divide run totals by total subtasks
+	dir := t.TempDir()
+	writeCostRun(t, dir, "two_steps", []int{2, 2}, 3000, 20000)
+	writeCostRun(t, dir, "one_step", []int{4}, 3000, 10000)
+	writeRun(t, dir, "unfinished", "never ended", "", time.Now()) // no task_end: skipped
+	h, err := HistoricalCost(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Runs != 2 || h.Subtasks != 3 {
+		t.Fatalf("runs/subtasks = %d/%d, want 2/3", h.Runs, h.Subtasks)
+	}
+	if h.TokensPerSubtask != 2000 {
+		t.Errorf("tokens per subtask = %v, want 2000", h.TokensPerSubtask)
+	}
+	if h.MsPerSubtask != 10000 {
+		t.Errorf("ms per subtask = %v, want 10000", h.MsPerSubtask)
+	}
+	if h.CriteriaPerSubtask != 8.0/3 {
+		t.Errorf("criteria per subtask = %v, want %v", h.CriteriaPerSubtask, 8.0/3)
+	}
+}
+
+func TestHistoricalCost_MissingDirIsZero(t *testing.T) {
+	// Returns the zero CostHistory, nil when dir does not exist
+	h, err := HistoricalCost(filepath.Join(t.TempDir(), "nope"))
+	if err != nil || h != (CostHistory{}) {
+		t.Errorf("got %+v, %v; want zero, nil", h, err)
+	}
+}
@@ -0,0 +1,82 @@
+package tasklog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadIntent returns the intent recorded in the task_begin event of taskID's
+// archived log, for /replay.
+//
+// Expectations:
+//   - Returns the intent of the first task_begin event in <dir>/<taskID>.jsonl
+//   - Skips malformed lines
+//   - Returns an error naming the task when the log does not exist
+//   - Returns an error when the log has no task_begin event with an intent
+//   - Returns an error for an empty taskID or one containing a path separator
+func (r *Registry) LoadIntent(taskID string) (string, error) {
+	if taskID == "" || strings.ContainsAny(taskID, `/\`) || taskID == "." || taskID == ".." {
+		return "", fmt.Errorf("tasklog: invalid task ID %q", taskID)
+	}
+	path := filepath.Join(r.dir, taskID+".jsonl")
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("tasklog: no log for task %q", taskID)
+		}
+		return "", err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024) // llm_call lines carry whole prompts
+	for sc.Scan() {
+		var e Event
+		if json.Unmarshal(sc.Bytes(), &e) != nil || e.Kind != KindTaskBegin {
+			continue
+		}
+		if e.Intent != "" {
+			return e.Intent, nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", fmt.Errorf("tasklog: read %s: %w", path, err)
+	}
+	return "", fmt.Errorf("tasklog: log for task %q records no intent", taskID)
+}
+
+// LatestTaskID returns the ID of the most recently modified task log under the
+// registry's directory, for /replay last.
+//
+// Expectations:
+//   - Returns the *.jsonl file name, without extension, with the latest modification time
+//   - Ignores directories and files that are not *.jsonl
+//   - Returns an error when the directory is missing or holds no task logs
+func (r *Registry) LatestTaskID() (string, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	var latest string
+	var latestInfo os.FileInfo
+	for _, ent := range entries {
+		if ent.IsDir() || !strings.HasSuffix(ent.Name(), ".jsonl") {
+			continue
+		}
+		info, err := ent.Info()
+		if err != nil {
+			continue // removed since ReadDir
+		}
+		if latestInfo == nil || info.ModTime().After(latestInfo.ModTime()) {
+			latest, latestInfo = strings.TrimSuffix(ent.Name(), ".jsonl"), info
+		}
+	}
+	if latestInfo == nil {
+		return "", fmt.Errorf("tasklog: no task logs in %s", r.dir)
+	}
+	return latest, nil
+}
@@ -0,0 +1,83 @@
+package tasklog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadIntent_ReadsTaskBegin(t *testing.T) {
+	// Returns the intent of the first task_begin event in <dir>/<taskID>.jsonl; skips malformed lines
+	dir := t.TempDir()
+	path := filepath.Join(dir, "count_go_files.jsonl")
+	if err := os.WriteFile(path, []byte("not json\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeRun(t, dir, "count_go_files", "Count Go files in the project", "accepted", time.Now())
+	writeRun(t, dir, "count_go_files", "a later round", "", time.Now())
+
+	got, err := NewRegistry(dir).LoadIntent("count_go_files")
+	if err != nil || got != "Count Go files in the project" {
+		t.Errorf("LoadIntent = %q, %v; want the first task_begin intent", got, err)
+	}
+}
+
+func TestLoadIntent_FailsGracefully(t *testing.T) {
+	// Returns an error naming the task when the log does not exist
+	// Returns an error when the log has no task_begin event with an intent
+	// Returns an error for an empty taskID or one containing a path separator
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "garbled.jsonl"), []byte("{\"kind\":\"tool_call\"}\n{oops\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reg := NewRegistry(dir)
+	for _, id := range []string{"missing", "garbled", "", "../escape", ".."} {
+		if got, err := reg.LoadIntent(id); err == nil {
+			t.Errorf("LoadIntent(%q) = %q, want an error", id, got)
+		}
+	}
+	if _, err := reg.LoadIntent("missing"); err == nil || !strings.Contains(err.Error(), `"missing"`) {
+		t.Errorf("missing log error = %v, want it to name the task", err)
+	}
+}
+
+func TestLatestTaskID_PicksNewestLog(t *testing.T) {
+	// Returns the *.jsonl file name, without extension, with the latest modification time;
+	// ignores directories and files that are not *.jsonl
+	dir := t.TempDir()
+	now := time.Now()
+	for i, id := range []string{"newest", "middle", "oldest"} {
+		writeRun(t, dir, id, "intent "+id, "accepted", now)
+		mod := now.Add(-time.Duration(i) * time.Hour)
+		if err := os.Chtimes(filepath.Join(dir, id+".jsonl"), mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notes := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notes, []byte("ignored"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	future := now.Add(time.Hour)
+	if err := os.Chtimes(notes, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub.jsonl"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := NewRegistry(dir).LatestTaskID(); err != nil || got != "newest" {
+		t.Errorf("LatestTaskID = %q, %v; want newest", got, err)
+	}
+}
+
+func TestLatestTaskID_ErrorsWithoutLogs(t *testing.T) {
+	// Returns an error when the directory is missing or holds no task logs
+	if _, err := NewRegistry(filepath.Join(t.TempDir(), "absent")).LatestTaskID(); err == nil {
+		t.Error("missing dir: want an error")
+	}
+	if _, err := NewRegistry(t.TempDir()).LatestTaskID(); err == nil {
+		t.Error("empty dir: want an error")
+	}
+}
@@ -0,0 +1,120 @@
+package tasklog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AggregateStats totals the task runs in a directory of task logs, for /stats.
+type AggregateStats struct {
+	Runs        int            `json:"runs"`
+	ByStatus    map[string]int `json:"by_status"` // "accepted" | "abandoned" | "cancelled" | "" (never reached task_end)
+	TotalTokens int            `json:"total_tokens"`
+	ElapsedMs   int64          `json:"elapsed_ms"`
+	ToolCalls   int            `json:"tool_calls"`
+	Subtasks    int            `json:"subtasks"`
+	Corrections int            `json:"corrections"`
+	Replans     int            `json:"replans"`
+}
+
+// summaryCache keeps each task log's run summaries keyed by path, so repeated
+// aggregates over a long-lived log directory only re-read files that changed.
+type summaryCache struct {
+	mu    sync.Mutex
+	files map[string]cachedSummaries
+	reads int // files parsed from disk; lets tests observe cache hits
+}
+
+// cachedSummaries is one file's summaries and the stat they were read at.
+type cachedSummaries struct {
+	size    int64
+	modTime time.Time
+	runs    []TaskSummary
+}
+
+// aggregateCache backs Aggregate for the life of the process.
+var aggregateCache = newSummaryCache()
+
+func newSummaryCache() *summaryCache {
+	return &summaryCache{files: make(map[string]cachedSummaries)}
+}
+
+// Aggregate totals the runs in the task logs under dir whose task_begin is at
+// or after since; a zero since counts every run. Files are re-read only when
+// their size or modification time changed since the previous call.
+//
+// Expectations:
+//   - Returns the zero AggregateStats (with an empty ByStatus), nil when dir does not exist
+//   - Counts only runs whose task_begin is at or after since; zero since counts all runs
+//   - Sums tokens, elapsed time, and tool calls from task_end, and subtasks, corrections, and replans per run
+//   - Re-reads a file only when its size or modification time changed; drops files that were removed
+//   - Files that are not *.jsonl and malformed lines are skipped
+func Aggregate(dir string, since time.Time) (AggregateStats, error) {
+	return aggregateCache.aggregate(dir, since)
+}
+
+func (c *summaryCache) aggregate(dir string, since time.Time) (AggregateStats, error) {
+	stats := AggregateStats{ByStatus: make(map[string]int)}
+	runs, err := c.summaries(dir)
+	if err != nil {
+		return stats, err
+	}
+	for _, r := range runs {
+		if !since.IsZero() && r.Started.Before(since) {
+			continue
+		}
+		stats.Runs++
+		stats.ByStatus[r.Status]++
+		stats.TotalTokens += r.TotalTokens
+		stats.ElapsedMs += r.ElapsedMs
+		stats.ToolCalls += r.ToolCallCount
+		stats.Subtasks += r.SubtaskCount
+		stats.Corrections += r.Corrections
+		stats.Replans += r.Replans
+	}
+	return stats, nil
+}
+
+// summaries returns the run summaries of every *.jsonl file under dir, reading
+// only the files whose stat differs from the cached one.
+func (c *summaryCache) summaries(dir string) ([]TaskSummary, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dir = filepath.Clean(dir)
+	seen := make(map[string]bool, len(entries))
+	var out []TaskSummary
+	for _, ent := range entries {
+		if ent.IsDir() || !strings.HasSuffix(ent.Name(), ".jsonl") {
+			continue
+		}
+		info, err := ent.Info()
+		if err != nil {
+			continue // removed since ReadDir
+		}
+		path := filepath.Join(dir, ent.Name())
+		seen[path] = true
+		cached, ok := c.files[path]
+		if !ok || cached.size != info.Size() || !cached.modTime.Equal(info.ModTime()) {
+			cached = cachedSummaries{size: info.Size(), modTime: info.ModTime(), runs: summarize(readEventsFile(path))}
+			c.files[path] = cached
+			c.reads++
+		}
+		out = append(out, cached.runs...)
+	}
+	for path := range c.files {
+		if filepath.Dir(path) == dir && !seen[path] {
+			delete(c.files, path)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,76 @@
+package tasklog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAggregate_SinceExcludesOlderRuns(t *testing.T) {
+	// Counts only runs whose task_begin is at or after since; zero since counts all runs;
+	// sums tokens, elapsed time, and tool calls from task_end
+	dir, day0 := seedQueryDir(t)
+	c := newSummaryCache()
+	all, err := c.aggregate(dir, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all.Runs != 4 || all.ByStatus["accepted"] != 2 || all.ByStatus["abandoned"] != 1 || all.ByStatus[""] != 1 {
+		t.Errorf("all runs = %+v", all)
+	}
+	recent, err := c.aggregate(dir, day0.Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recent.Runs != 2 || recent.ByStatus["accepted"] != 1 || recent.ByStatus["abandoned"] != 0 {
+		t.Errorf("since day 2 = %+v, want find_tax_pdf and search_weather only", recent)
+	}
+	if recent.TotalTokens != 150 || recent.ElapsedMs != 2000 || recent.ToolCalls != 1 {
+		t.Errorf("since day 2 totals = %+v, want one finished run's", recent)
+	}
+}
+
+func TestAggregate_RereadsOnlyChangedFiles(t *testing.T) {
+	// Re-reads a file only when its size or modification time changed; drops files that were removed
+	dir, day0 := seedQueryDir(t)
+	c := newSummaryCache()
+	if _, err := c.aggregate(dir, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if c.reads != 4 {
+		t.Fatalf("first aggregate read %d files, want 4", c.reads)
+	}
+	if _, err := c.aggregate(dir, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if c.reads != 4 {
+		t.Errorf("unchanged dir re-read %d files", c.reads-4)
+	}
+
+	writeRun(t, dir, "count_go_files", "Count Go files again", "accepted", day0.Add(72*time.Hour))
+	if err := os.Remove(filepath.Join(dir, "search_weather.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.aggregate(dir, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.reads != 5 {
+		t.Errorf("after one append, read %d files in total, want 5", c.reads)
+	}
+	if got.Runs != 4 || got.ByStatus[""] != 0 || got.ByStatus["accepted"] != 3 {
+		t.Errorf("after append and removal = %+v", got)
+	}
+	if len(c.files) != 3 {
+		t.Errorf("cache holds %d files, want the removed one dropped", len(c.files))
+	}
+}
+
+func TestAggregate_MissingDir(t *testing.T) {
+	// Returns the zero AggregateStats (with an empty ByStatus), nil when dir does not exist
+	got, err := Aggregate(filepath.Join(t.TempDir(), "nope"), time.Time{})
+	if err != nil || got.Runs != 0 || got.ByStatus == nil {
+		t.Errorf("got %+v, %v", got, err)
+	}
+}
@@ -20,9 +20,10 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
+
+	"github.com/haricheung/agentic-shell/internal/clock"
 )
 
 // EventKind labels a single structured event in the task log.
@@ -42,6 +43,7 @@ const (
 	KindPlanDirective    EventKind = "plan_directive"  // replanning directive to R2
 	KindMemoryQuery      EventKind = "memory_query"    // Planner MKCT query result
 	KindMemoryWrite      EventKind = "memory_write"    // Megram written by GGS
+	KindPlanDiff         EventKind = "plan_diff"       // R2: subtask set changes after a replan
 )
 
 // Event is one JSONL line in the task log.
@@ -53,7 +55,8 @@ type Event struct {
 	// task_begin / task_end
 	TaskID        string     `json:"task_id,omitempty"`
 	Intent        string     `json:"intent,omitempty"`
-	Status        string     `json:"status,omitempty"` // "accepted" | "abandoned"
+	Tags          []string   `json:"tags,omitempty"`   // task_begin only
+	Status        string     `json:"status,omitempty"` // "accepted" | "abandoned" | "cancelled"
 	ElapsedMs     int64      `json:"elapsed_ms,omitempty"`
 	TotalTokens   int        `json:"total_tokens,omitempty"`
 	RoleStats     []RoleStat `json:"role_stats,omitempty"`     // task_end only
@@ -76,10 +79,11 @@ type Event struct {
 	IterIndex        int    `json:"iter_index,omitempty"` // 1-indexed executor turn; omitted for single-call roles
 
 	// tool_call
-	Tool       string `json:"tool,omitempty"`
-	ToolInput  string `json:"tool_input,omitempty"`
-	ToolOutput string `json:"tool_output,omitempty"`
-	ToolError  string `json:"tool_error,omitempty"`
+	Tool        string `json:"tool,omitempty"`
+	ToolInput   string `json:"tool_input,omitempty"`
+	ToolOutput  string `json:"tool_output,omitempty"`
+	ContentType string `json:"content_type,omitempty"` // "json" | "paths" | "table" | "text"
+	ToolError   string `json:"tool_error,omitempty"`
 
 	// criterion_verdict
 	Criterion string `json:"criterion,omitempty"`
@@ -107,6 +111,12 @@ type Event struct {
 	BlockedTargets []string `json:"blocked_targets,omitempty"`
 	FailureClass   string   `json:"failure_class,omitempty"`
 
+	// plan_diff
+	Added     []string `json:"added,omitempty"`
+	Removed   []string `json:"removed,omitempty"`
+	Changed   []string `json:"changed,omitempty"` // "old intent → new intent"
+	Unchanged int      `json:"unchanged,omitempty"`
+
 	// memory_query / memory_write
 	Space     string  `json:"space,omitempty"`
 	Entity    string  `json:"entity,omitempty"`
@@ -160,6 +170,7 @@ var canonicalRoleOrder = []string{"planner", "executor", "agentval", "metaval"}
 type TaskLog struct {
 	taskID           string
 	started          time.Time
+	clock            clock.Clock // the registry's clock at Open
 	mu               sync.Mutex
 	f                *os.File
 	promptTokens     int
@@ -186,6 +197,7 @@ type Registry struct {
 	mu    sync.Mutex
 	logs  map[string]*TaskLog
 	cache map[string]*TaskStats // taskID -> full stats snapshot saved on Close
+	clock clock.Clock           // stamps events and times tasks; clock.Wall unless SetClock
 }
 
 // NewRegistry creates a Registry that writes one JSONL file per task under dir.
@@ -194,12 +206,28 @@ func NewRegistry(dir string) *Registry {
 		dir:   dir,
 		logs:  make(map[string]*TaskLog),
 		cache: make(map[string]*TaskStats),
+		clock: clock.Wall,
 	}
 }
 
-// Open creates a new TaskLog for taskID, writes a task_begin event, and registers it.
+// SetClock replaces the clock that stamps events and measures elapsed_ms for
+// logs opened afterwards; nil restores clock.Wall.
+func (r *Registry) SetClock(c clock.Clock) {
+	if c == nil {
+		c = clock.Wall
+	}
+	r.mu.Lock()
+	r.clock = c
+	r.mu.Unlock()
+}
+
+// Dir returns the directory the registry writes task logs to.
+func (r *Registry) Dir() string { return r.dir }
+
+// Open creates a new TaskLog for taskID, writes a task_begin event carrying the
+// task's tags, and registers it.
 // If a log for taskID is already open (e.g. a replan round), it returns the existing log.
-func (r *Registry) Open(taskID, intent string) *TaskLog {
+func (r *Registry) Open(taskID, intent string, tags ...string) *TaskLog {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -218,12 +246,13 @@ func (r *Registry) Open(taskID, intent string) *TaskLog {
 		return nil
 	}
 
-	tl := &TaskLog{taskID: taskID, started: time.Now(), f: f, roleStats: make(map[string]*roleStat)}
+	tl := &TaskLog{taskID: taskID, started: r.clock.Now(), clock: r.clock, f: f, roleStats: make(map[string]*roleStat)}
 	r.logs[taskID] = tl
 	tl.write(Event{
 		Kind:   KindTaskBegin,
 		TaskID: taskID,
 		Intent: intent,
+		Tags:   tags,
 	})
 	return tl
 }
@@ -257,7 +286,7 @@ func (r *Registry) Close(taskID, status string) {
 	r.mu.Unlock()
 
 	tl.mu.Lock()
-	elapsed := time.Since(tl.started).Milliseconds()
+	elapsed := tl.clock.Now().Sub(tl.started).Milliseconds()
 	total := tl.promptTokens + tl.completionTokens
 	tl.mu.Unlock()
 
@@ -348,13 +377,15 @@ func (tl *TaskLog) LLMCall(role, systemPrompt, userPrompt, response string, prom
 }
 
 // ToolCall writes a tool_call event. toolError is empty on success.
+// contentType is the output's shape hint (tools.Content*); empty on error.
 // elapsedMs is the wall-clock milliseconds the tool execution took; pass 0 if unknown.
 //
 // Expectations:
 //   - ToolCallCount increments by 1 per invocation
 //   - ToolElapsedMs accumulates the sum of all elapsedMs values
+//   - content_type is serialised when non-empty
 //   - No-op on nil receiver
-func (tl *TaskLog) ToolCall(subtaskID, tool, toolInput, toolOutput, toolError string, elapsedMs int64) {
+func (tl *TaskLog) ToolCall(subtaskID, tool, toolInput, toolOutput, contentType, toolError string, elapsedMs int64) {
 	if tl == nil {
 		return
 	}
@@ -363,12 +394,13 @@ func (tl *TaskLog) ToolCall(subtaskID, tool, toolInput, toolOutput, toolError st
 	tl.toolElapsedMs += elapsedMs
 	tl.mu.Unlock()
 	tl.write(Event{
-		Kind:       KindToolCall,
-		SubtaskID:  subtaskID,
-		Tool:       tool,
-		ToolInput:  toolInput,
-		ToolOutput: toolOutput,
-		ToolError:  toolError,
+		Kind:        KindToolCall,
+		SubtaskID:   subtaskID,
+		Tool:        tool,
+		ToolInput:   toolInput,
+		ToolOutput:  toolOutput,
+		ContentType: contentType,
+		ToolError:   toolError,
 	})
 }
 
@@ -475,22 +507,7 @@ func (tl *TaskLog) Stats() *TaskStats {
 //   - Returns nil when the task file does not exist
 //   - Returns one Event per valid JSONL line; silently skips malformed lines
 func (r *Registry) ReadEvents(taskID string) []Event {
-	path := filepath.Join(r.dir, taskID+".jsonl")
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil
-	}
-	var events []Event
-	for _, line := range strings.Split(string(data), "\n") {
-		if line == "" {
-			continue
-		}
-		var e Event
-		if err := json.Unmarshal([]byte(line), &e); err == nil {
-			events = append(events, e)
-		}
-	}
-	return events
+	return readEventsFile(filepath.Join(r.dir, taskID+".jsonl"))
 }
 
 // GetStats returns and removes the cached TaskStats for taskID.
@@ -611,9 +628,30 @@ func (tl *TaskLog) MemoryWrite(state, level, space, entity string) {
 	})
 }
 
+// PlanDiff writes a plan_diff event recording how a replan changed the subtask set.
+// changed entries are pre-rendered by the caller as "old intent → new intent".
+//
+// Expectations:
+//   - No-op on nil receiver
+//   - directive is serialised; added, removed, changed are serialised when non-empty
+//   - unchanged is serialised when > 0
+func (tl *TaskLog) PlanDiff(directive string, added, removed, changed []string, unchanged int) {
+	if tl == nil {
+		return
+	}
+	tl.write(Event{
+		Kind:      KindPlanDiff,
+		Directive: directive,
+		Added:     added,
+		Removed:   removed,
+		Changed:   changed,
+		Unchanged: unchanged,
+	})
+}
+
 // write appends one JSON line to the task log file. Adds timestamp, mutex-protected.
 func (tl *TaskLog) write(e Event) {
-	e.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	e.Timestamp = tl.clock.Now().UTC().Format(time.RFC3339Nano)
 	data, err := json.Marshal(e)
 	if err != nil {
 		slog.Error("[TASKLOG] marshal event", "error", err)
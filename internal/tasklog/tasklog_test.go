@@ -5,6 +5,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/haricheung/agentic-shell/internal/clock"
 )
 
 // readEvents parses all JSONL lines from a file into a slice of Events.
@@ -143,6 +146,27 @@ func TestRegistry_Close_WritesTaskEnd(t *testing.T) {
 	}
 }
 
+func TestRegistry_SetClock_StampsEventsAndElapsed(t *testing.T) {
+	// Events are stamped, and elapsed_ms measured, on the registry's clock
+	dir := t.TempDir()
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	fc := clock.NewFake(start)
+	r := NewRegistry(dir)
+	r.SetClock(fc)
+	r.Open("task1", "intent")
+	fc.Advance(2500 * time.Millisecond)
+	r.Close("task1", "accepted")
+
+	events := readEvents(t, filepath.Join(dir, "task1.jsonl"))
+	first, last := events[0], events[len(events)-1]
+	if first.Timestamp != start.Format(time.RFC3339Nano) {
+		t.Errorf("task_begin timestamp = %q, want %q", first.Timestamp, start.Format(time.RFC3339Nano))
+	}
+	if last.ElapsedMs != 2500 {
+		t.Errorf("elapsed_ms = %d, want 2500", last.ElapsedMs)
+	}
+}
+
 func TestRegistry_Close_NoopsForUnknown(t *testing.T) {
 	// Close no-ops gracefully when taskID is not registered
 	dir := t.TempDir()
@@ -160,7 +184,7 @@ func TestTaskLog_NilReceiverNoops(t *testing.T) {
 	tl.SubtaskBegin("s1", "intent", 1, []string{"criterion"})
 	tl.SubtaskEnd("s1", "matched")
 	tl.LLMCall("executor", "sys", "user", "resp", 100, 50, 500, 1)
-	tl.ToolCall("s1", "shell", "ls", "file.go", "", 120)
+	tl.ToolCall("s1", "shell", "ls", "file.go", "text", "", 120)
 	tl.CriterionVerdict("s1", "output contains path", true, "evidence", 1)
 	tl.Correction("s1", "wrong", "try this", 1)
 	tl.Replan("gap summary", 1)
@@ -357,9 +381,9 @@ func TestToolStats_AccumulatesCallCount(t *testing.T) {
 	dir := t.TempDir()
 	r := NewRegistry(filepath.Join(dir, "tasks"))
 	tl := r.Open("task1", "intent")
-	tl.ToolCall("s1", "shell", "ls", "file.go", "", 100)
-	tl.ToolCall("s1", "mdfind", "q", "result", "", 200)
-	tl.ToolCall("s1", "glob", "*", "match", "", 50)
+	tl.ToolCall("s1", "shell", "ls", "file.go", "text", "", 100)
+	tl.ToolCall("s1", "mdfind", "q", "result", "paths", "", 200)
+	tl.ToolCall("s1", "glob", "*", "match", "paths", "", 50)
 	stats := tl.Stats()
 	r.Close("task1", "accepted")
 	if stats.ToolCallCount != 3 {
@@ -372,8 +396,8 @@ func TestToolStats_AccumulatesElapsedMs(t *testing.T) {
 	dir := t.TempDir()
 	r := NewRegistry(filepath.Join(dir, "tasks"))
 	tl := r.Open("task1", "intent")
-	tl.ToolCall("s1", "shell", "ls", "file.go", "", 100)
-	tl.ToolCall("s1", "mdfind", "q", "result", "", 250)
+	tl.ToolCall("s1", "shell", "ls", "file.go", "text", "", 100)
+	tl.ToolCall("s1", "mdfind", "q", "result", "paths", "", 250)
 	stats := tl.Stats()
 	r.Close("task1", "accepted")
 	if stats.ToolElapsedMs != 350 {
@@ -386,8 +410,8 @@ func TestTaskEnd_IncludesToolStats(t *testing.T) {
 	dir := t.TempDir()
 	r := NewRegistry(filepath.Join(dir, "tasks"))
 	tl := r.Open("task1", "intent")
-	tl.ToolCall("s1", "shell", "ls", "file.go", "", 400)
-	tl.ToolCall("s1", "glob", "*.go", "x.go", "", 600)
+	tl.ToolCall("s1", "shell", "ls", "file.go", "text", "", 400)
+	tl.ToolCall("s1", "glob", "*.go", "x.go", "paths", "", 600)
 	r.Close("task1", "accepted")
 
 	events := readEvents(t, filepath.Join(dir, "tasks", "task1.jsonl"))
@@ -670,3 +694,54 @@ func TestMemoryWrite_ActionStateToolSpace(t *testing.T) {
 	}
 	t.Fatal("no memory_write event found")
 }
+
+// ── PlanDiff ─────────────────────────────────────────────────────────────────
+
+func TestPlanDiff_WritesEvent(t *testing.T) {
+	// directive is serialised; added, removed, changed are serialised when non-empty
+	dir := t.TempDir()
+	r := NewRegistry(filepath.Join(dir, "tasks"))
+	tl := r.Open("task1", "intent")
+	tl.PlanDiff("change_approach", []string{"use find"}, []string{"use mdfind"}, []string{"count → count mp4"}, 2)
+	r.Close("task1", "accepted")
+
+	for _, e := range readEvents(t, filepath.Join(dir, "tasks", "task1.jsonl")) {
+		if e.Kind != KindPlanDiff {
+			continue
+		}
+		if e.Directive != "change_approach" || e.Unchanged != 2 {
+			t.Errorf("unexpected event header: %+v", e)
+		}
+		if len(e.Added) != 1 || len(e.Removed) != 1 || len(e.Changed) != 1 || e.Changed[0] != "count → count mp4" {
+			t.Errorf("unexpected diff lists: %+v", e)
+		}
+		return
+	}
+	t.Fatal("no plan_diff event found")
+}
+
+func TestPlanDiff_NilReceiverNoop(t *testing.T) {
+	// No-op on nil receiver
+	var tl *TaskLog
+	tl.PlanDiff("refine", nil, nil, nil, 0)
+}
+
+func TestToolCall_SerialisesContentType(t *testing.T) {
+	// content_type is serialised when non-empty and omitted for failed calls
+	dir := t.TempDir()
+	r := NewRegistry(dir)
+	tl := r.Open("task1", "intent")
+	tl.ToolCall("s1", "read_file", `{"path":"a.json"}`, `{"a":1}`, "json", "", 10)
+	tl.ToolCall("s1", "read_file", `{"path":"b.json"}`, "", "", "no such file", 10)
+	r.Close("task1", "accepted")
+
+	var got []string
+	for _, e := range readEvents(t, filepath.Join(dir, "task1.jsonl")) {
+		if e.Kind == KindToolCall {
+			got = append(got, e.ContentType)
+		}
+	}
+	if len(got) != 2 || got[0] != "json" || got[1] != "" {
+		t.Errorf("content types = %q, want [json \"\"]", got)
+	}
+}
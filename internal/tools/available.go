@@ -0,0 +1,45 @@
+package tools
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// lookPath is exec.LookPath, indirected so tests can simulate missing binaries.
+var lookPath = exec.LookPath
+
+// toolBinaries lists the external executable each binary-backed tool shells out to.
+// Tools not listed here (glob, grep, read_file, write_file, fetch_url) are pure Go and always available.
+var toolBinaries = map[string]string{
+	"mdfind":      "mdfind",
+	"shell":       "bash",
+	"applescript": "osascript",
+	"message":     "osascript",
+	"shortcuts":   "shortcuts",
+}
+
+// Available reports whether the named executor tool can run in this environment.
+// When it cannot, reason explains why in a form suitable for showing the model.
+//
+// Expectations:
+//   - Returns true for pure-Go tools (glob, grep, read_file, write_file, fetch_url)
+//   - Returns false with a reason naming the binary when a binary-backed tool's executable is not on PATH
+//   - Returns true for binary-backed tools whose executable is on PATH
+//   - Returns SearchAvailable() for "search"
+//   - Returns true for unknown tool names (the caller reports unknown tools itself)
+func Available(tool string) (ok bool, reason string) {
+	if tool == "search" {
+		if !SearchAvailable() {
+			return false, "no search provider is configured"
+		}
+		return true, ""
+	}
+	bin, needsBinary := toolBinaries[tool]
+	if !needsBinary {
+		return true, ""
+	}
+	if _, err := lookPath(bin); err != nil {
+		return false, fmt.Sprintf("%s: executable file not found in $PATH", bin)
+	}
+	return true, ""
+}
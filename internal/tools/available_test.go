@@ -0,0 +1,70 @@
+package tools
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// stubLookPath makes lookPath report only the given binaries as installed.
+func stubLookPath(t *testing.T, installed ...string) {
+	t.Helper()
+	orig := lookPath
+	t.Cleanup(func() { lookPath = orig })
+	lookPath = func(name string) (string, error) {
+		for _, b := range installed {
+			if b == name {
+				return "/usr/bin/" + name, nil
+			}
+		}
+		return "", errors.New("not found")
+	}
+}
+
+// ── Available ────────────────────────────────────────────────────────────────
+
+func TestAvailable_PureGoToolsAlwaysAvailable(t *testing.T) {
+	// Returns true for pure-Go tools (glob, read_file, write_file)
+	stubLookPath(t)
+	for _, tool := range []string{"glob", "read_file", "write_file"} {
+		if ok, reason := Available(tool); !ok {
+			t.Errorf("Available(%q) = false (%s), want true", tool, reason)
+		}
+	}
+}
+
+func TestAvailable_MissingBinaryIsUnavailable(t *testing.T) {
+	// Returns false with a reason naming the binary when a binary-backed tool's executable is not on PATH
+	stubLookPath(t, "bash")
+	ok, reason := Available("applescript")
+	if ok {
+		t.Fatal("expected applescript to be unavailable without osascript")
+	}
+	if !strings.Contains(reason, "osascript") {
+		t.Errorf("expected reason to name osascript, got %q", reason)
+	}
+}
+
+func TestAvailable_PresentBinaryIsAvailable(t *testing.T) {
+	// Returns true for binary-backed tools whose executable is on PATH
+	stubLookPath(t, "bash")
+	if ok, reason := Available("shell"); !ok {
+		t.Errorf("expected shell to be available, got reason %q", reason)
+	}
+}
+
+func TestAvailable_SearchFollowsSearchAvailable(t *testing.T) {
+	// Returns SearchAvailable() for "search"
+	stubLookPath(t)
+	if ok, _ := Available("search"); ok != SearchAvailable() {
+		t.Errorf("Available(search) = %v, want %v", ok, SearchAvailable())
+	}
+}
+
+func TestAvailable_UnknownToolIsAvailable(t *testing.T) {
+	// Returns true for unknown tool names (the caller reports unknown tools itself)
+	stubLookPath(t)
+	if ok, _ := Available("teleport"); !ok {
+		t.Error("expected unknown tool to pass the availability check")
+	}
+}
@@ -0,0 +1,105 @@
+package tools
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Content types hint at the shape of a tool's output so consumers (R4a scoring,
+// the dispatcher's context injection, the task log) can handle it by format.
+const (
+	ContentText  = "text"  // prose or anything unrecognised
+	ContentJSON  = "json"  // one JSON object or array
+	ContentPaths = "paths" // one filesystem path per line
+	ContentTable = "table" // delimited rows (CSV or TSV)
+)
+
+// ContentTyper is implemented by tools that know the shape of their output.
+// Tools that do not implement it have their output sniffed with DetectContentType.
+type ContentTyper interface {
+	ContentType(output string) string
+}
+
+// ContentTypeOf returns t's content type for output: t's own hint when it
+// implements ContentTyper, otherwise DetectContentType(output).
+func ContentTypeOf(t Tool, output string) string {
+	if ct, ok := t.(ContentTyper); ok {
+		if c := ct.ContentType(output); c != "" {
+			return c
+		}
+	}
+	return DetectContentType(output)
+}
+
+// DetectContentType sniffs output's shape.
+//
+// Expectations:
+//   - Returns ContentJSON when the trimmed output is a JSON object or array
+//   - Returns ContentPaths when every non-empty line is an absolute, home, or ./-relative path
+//   - Returns ContentTable when 2+ lines all split into the same number (≥2) of comma or tab fields
+//   - Returns ContentText otherwise, including for empty output
+func DetectContentType(output string) string {
+	s := strings.TrimSpace(output)
+	if s == "" {
+		return ContentText
+	}
+	if (s[0] == '{' || s[0] == '[') && json.Valid([]byte(s)) {
+		return ContentJSON
+	}
+	lines := nonEmptyLines(s)
+	if isPathList(lines) {
+		return ContentPaths
+	}
+	if isTable(lines) {
+		return ContentTable
+	}
+	return ContentText
+}
+
+// nonEmptyLines splits s into lines, dropping blank ones and trailing spaces.
+func nonEmptyLines(s string) []string {
+	var out []string
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.TrimRight(l, " \r"); l != "" {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// isPathList reports whether every line looks like a single path.
+func isPathList(lines []string) bool {
+	for _, l := range lines {
+		if !(strings.HasPrefix(l, "/") || strings.HasPrefix(l, "~/") || strings.HasPrefix(l, "./")) {
+			return false
+		}
+		if strings.Contains(l, "\t") || strings.Contains(l, ": ") {
+			return false
+		}
+	}
+	return len(lines) > 0
+}
+
+// isTable reports whether lines form delimited rows with a consistent field count.
+func isTable(lines []string) bool {
+	if len(lines) < 2 {
+		return false
+	}
+	for _, sep := range []string{"\t", ","} {
+		n := strings.Count(lines[0], sep) + 1
+		if n < 2 {
+			continue
+		}
+		consistent := true
+		for _, l := range lines[1:] {
+			if strings.Count(l, sep)+1 != n {
+				consistent = false
+				break
+			}
+		}
+		if consistent {
+			return true
+		}
+	}
+	return false
+}
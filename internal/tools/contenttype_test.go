@@ -0,0 +1,47 @@
+package tools
+
+import "testing"
+
+func TestDetectContentType(t *testing.T) {
+	cases := []struct {
+		name, output, want string
+	}{
+		{"empty", "  \n", ContentText},
+		{"object", ` {"city":"Beijing","temp":21} `, ContentJSON},
+		{"array", `[1,2,3]`, ContentJSON},
+		{"broken json", `{"city":`, ContentText},
+		{"abs paths", "/Users/a/x.pdf\n/Users/a/y.pdf\n", ContentPaths},
+		{"home and dot paths", "~/Downloads/a.mp4\n./b.mp4", ContentPaths},
+		{"path with prose", "/tmp/a.txt\nfound 1 file", ContentText},
+		{"csv", "name,size\na.go,120\nb.go,88", ContentTable},
+		{"tsv", "name\tsize\na.go\t120", ContentTable},
+		{"ragged csv", "a,b\nc", ContentText},
+		{"prose", "The build succeeded.", ContentText},
+	}
+	for _, c := range cases {
+		if got := DetectContentType(c.output); got != c.want {
+			t.Errorf("%s: DetectContentType = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// typedTool is a fakeTool with a fixed content-type hint.
+type typedTool struct {
+	fakeTool
+	ct string
+}
+
+func (t typedTool) ContentType(string) string { return t.ct }
+
+func TestContentTypeOf_PrefersToolHint(t *testing.T) {
+	// A ContentTyper's hint wins; other tools and empty hints fall back to sniffing
+	if got := ContentTypeOf(typedTool{fakeTool{"t"}, ContentTable}, `{"a":1}`); got != ContentTable {
+		t.Errorf("hinted tool: got %q, want table", got)
+	}
+	if got := ContentTypeOf(typedTool{fakeTool{"t"}, ""}, `{"a":1}`); got != ContentJSON {
+		t.Errorf("empty hint: got %q, want json", got)
+	}
+	if got := ContentTypeOf(fakeTool{"t"}, `{"a":1}`); got != ContentJSON {
+		t.Errorf("plain tool: got %q, want json", got)
+	}
+}
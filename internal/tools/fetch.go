@@ -0,0 +1,81 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxFetchBytes caps how much of a remote body FetchURL reads, so a large
+// download cannot flood the executor's context window.
+const maxFetchBytes = 1 << 20
+
+var fetchClient = &http.Client{
+	Timeout:   30 * time.Second,
+	Transport: searchClient.Transport,
+}
+
+// schemeRe matches a URL scheme prefix such as "https://" or "s3://".
+var schemeRe = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*)://`)
+
+// URLScheme returns the lower-cased scheme of path when it is written as a URL
+// ("https://…", "ftp://…"), or "" for a plain filesystem path.
+//
+// Expectations:
+//   - Returns "http" / "https" for web URLs, in any case
+//   - Returns "" for absolute, relative and "~/" paths
+//   - Returns "" for a Windows-style "C:\" path (no "://")
+func URLScheme(path string) string {
+	m := schemeRe.FindStringSubmatch(strings.TrimSpace(path))
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(m[1])
+}
+
+// FetchURL GETs an http(s) URL and returns its body as a string.
+//
+// Expectations:
+//   - Returns the body of a 2xx response
+//   - Returns at most maxFetchBytes of the body, with a truncation note when cut
+//   - Returns an error naming the status for a non-2xx response
+//   - Returns an error for schemes other than http and https
+//   - Returns an error when the request fails or ctx ends
+//   - Answers a repeated URL from DefaultWebCache within its TTL
+func FetchURL(ctx context.Context, rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if s := URLScheme(rawURL); s != "http" && s != "https" {
+		return "", fmt.Errorf("fetch %s: unsupported scheme %q (only http and https)", rawURL, s)
+	}
+	return DefaultWebCache.do("fetch", fetchCacheKey(rawURL), nil, func() (string, error) {
+		return fetchLive(ctx, rawURL)
+	})
+}
+
+// fetchLive GETs rawURL, bypassing the cache.
+func fetchLive(ctx context.Context, rawURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("fetch %s: %w", rawURL, err)
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetch %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("fetch %s: HTTP %d", rawURL, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("fetch %s: %w", rawURL, err)
+	}
+	if len(body) > maxFetchBytes {
+		return string(body[:maxFetchBytes]) + fmt.Sprintf("\n[truncated at %d bytes]", maxFetchBytes), nil
+	}
+	return string(body), nil
+}
@@ -1,9 +1,28 @@
 package tools
 
-import "os"
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
 
-// ReadFile reads the file at path and returns its contents as a string.
-func ReadFile(path string) (string, error) {
+// ReadFile returns the contents of path as a string. An http(s) URL is fetched
+// with FetchURL, so one tool covers local and remote reads.
+//
+// Expectations:
+//   - Returns the local file's contents for a filesystem path
+//   - Returns the response body for an http or https URL
+//   - Returns an error naming the scheme for any other URL (ftp://, s3://, …)
+func ReadFile(ctx context.Context, path string) (string, error) {
+	switch s := URLScheme(path); s {
+	case "":
+	case "http", "https":
+		return FetchURL(ctx, path)
+	default:
+		return "", fmt.Errorf("read_file: unsupported scheme %q in %s (only local paths and http/https URLs)", s, path)
+	}
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return "", err
@@ -12,6 +31,74 @@ func ReadFile(path string) (string, error) {
 }
 
 // WriteFile writes content to the file at path, creating it if necessary.
+//
+// Expectations:
+//   - Returns an error for a URL path; remote writes are not supported
 func WriteFile(path, content string) error {
+	if s := URLScheme(path); s != "" {
+		return fmt.Errorf("write_file: cannot write to a %s URL (%s); write to a local path instead", s, path)
+	}
 	return os.WriteFile(path, []byte(content), 0o644)
 }
+
+// verifyPrefixLen is how many leading bytes VerifyWrite re-reads and compares.
+const verifyPrefixLen = 512
+
+// VerifyWrite checks that the file at path now holds content, so a write_file
+// result can carry evidence rather than a bare "ok". It stats the file and
+// re-reads its first verifyPrefixLen bytes instead of the whole file.
+//
+// Expectations:
+//   - Returns "<path> (<n> bytes)" when the size and leading bytes match content
+//   - Marks an intentionally empty write as "(0 bytes, empty)"
+//   - Returns an error when path is missing or not a regular file
+//   - Returns an error naming both sizes when the file is empty or a different size
+//   - Returns an error when the leading bytes differ from content
+func VerifyWrite(path, content string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("write_file: verify: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("write_file: verify: %s is not a regular file", path)
+	}
+	if info.Size() != int64(len(content)) {
+		return "", fmt.Errorf("write_file: verify: %s holds %d bytes after the write, want %d", path, info.Size(), len(content))
+	}
+	if len(content) == 0 {
+		return path + " (0 bytes, empty)", nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("write_file: verify: %w", err)
+	}
+	defer f.Close()
+	want := []byte(content[:min(len(content), verifyPrefixLen)])
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(f, got); err != nil {
+		return "", fmt.Errorf("write_file: verify: re-read %s: %w", path, err)
+	}
+	if !bytes.Equal(got, want) {
+		return "", fmt.Errorf("write_file: verify: %s does not start with the written content", path)
+	}
+	return fmt.Sprintf("%s (%d bytes)", path, info.Size()), nil
+}
+
+// SameContent reports whether the regular file at path already holds exactly
+// content, so writing it again would change nothing.
+//
+// Expectations:
+//   - Returns true only for an existing regular file with byte-identical content
+//   - Returns false when path is missing, a directory, or a URL
+//   - Compares sizes first and reads the file only when they match
+func SameContent(path, content string) bool {
+	if URLScheme(path) != "" {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != int64(len(content)) {
+		return false
+	}
+	data, err := os.ReadFile(path)
+	return err == nil && bytes.Equal(data, []byte(content))
+}
@@ -0,0 +1,185 @@
+package tools
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ── ReadFile ─────────────────────────────────────────────────────────────────
+
+func TestReadFile_LocalPath(t *testing.T) {
+	// Returns the local file's contents for a filesystem path
+	path := filepath.Join(t.TempDir(), "doc.txt")
+	if err := os.WriteFile(path, []byte("local contents"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadFile(t.Context(), path)
+	if err != nil || got != "local contents" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestReadFile_HTTPURLIsFetched(t *testing.T) {
+	// Returns the response body for an http or https URL
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/doc.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("remote contents"))
+	}))
+	defer srv.Close()
+	got, err := ReadFile(t.Context(), srv.URL+"/doc.txt")
+	if err != nil || got != "remote contents" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if _, err := ReadFile(t.Context(), srv.URL+"/missing.txt"); err == nil || !strings.Contains(err.Error(), "HTTP 404") {
+		t.Errorf("expected an HTTP 404 error, got %v", err)
+	}
+}
+
+func TestReadFile_UnsupportedSchemeErrors(t *testing.T) {
+	// Returns an error naming the scheme for any other URL (ftp://, s3://, …)
+	for _, path := range []string{"ftp://example.com/doc.txt", "s3://bucket/key"} {
+		_, err := ReadFile(t.Context(), path)
+		if err == nil || !strings.Contains(err.Error(), "unsupported scheme") {
+			t.Errorf("ReadFile(%q): expected an unsupported-scheme error, got %v", path, err)
+		}
+	}
+}
+
+// ── VerifyWrite ──────────────────────────────────────────────────────────────
+
+func TestVerifyWrite_MatchingFileReturnsPathAndSize(t *testing.T) {
+	// Returns "<path> (<n> bytes)" when the size and leading bytes match content
+	path := filepath.Join(t.TempDir(), "report.md")
+	content := strings.Repeat("line of report\n", 100) // longer than verifyPrefixLen
+	if err := WriteFile(path, content); err != nil {
+		t.Fatal(err)
+	}
+	got, err := VerifyWrite(path, content)
+	if want := path + " (1500 bytes)"; err != nil || got != want {
+		t.Errorf("got %q, %v; want %q", got, err, want)
+	}
+}
+
+func TestVerifyWrite_IntentionallyEmptyFile(t *testing.T) {
+	// Marks an intentionally empty write as "(0 bytes, empty)"
+	path := filepath.Join(t.TempDir(), "empty.txt")
+	if err := WriteFile(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := VerifyWrite(path, ""); err != nil || got != path+" (0 bytes, empty)" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestVerifyWrite_MissingOrDirectory(t *testing.T) {
+	// Returns an error when path is missing or not a regular file
+	dir := t.TempDir()
+	if _, err := VerifyWrite(filepath.Join(dir, "nope.txt"), "x"); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+	if _, err := VerifyWrite(dir, "x"); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Errorf("expected a not-a-regular-file error, got %v", err)
+	}
+}
+
+func TestVerifyWrite_ZeroByteOrWrongSize(t *testing.T) {
+	// Returns an error naming both sizes when the file is empty or a different size
+	path := filepath.Join(t.TempDir(), "out.txt")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := VerifyWrite(path, "hello")
+	if err == nil || !strings.Contains(err.Error(), "holds 0 bytes") || !strings.Contains(err.Error(), "want 5") {
+		t.Errorf("expected a size error naming 0 and 5, got %v", err)
+	}
+}
+
+func TestVerifyWrite_DifferentLeadingBytes(t *testing.T) {
+	// Returns an error when the leading bytes differ from content
+	path := filepath.Join(t.TempDir(), "out.txt")
+	if err := os.WriteFile(path, []byte("world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyWrite(path, "hello"); err == nil || !strings.Contains(err.Error(), "does not start with") {
+		t.Errorf("expected a content mismatch error, got %v", err)
+	}
+}
+
+// ── FetchURL ─────────────────────────────────────────────────────────────────
+
+func TestFetchURL_TruncatesLargeBody(t *testing.T) {
+	// Returns at most maxFetchBytes of the body, with a truncation note when cut
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(strings.Repeat("a", maxFetchBytes+10)))
+	}))
+	defer srv.Close()
+	got, err := FetchURL(t.Context(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != strings.Repeat("a", maxFetchBytes)+"\n[truncated at 1048576 bytes]" {
+		t.Errorf("expected the body cut at %d bytes with a note, got %d bytes", maxFetchBytes, len(got))
+	}
+}
+
+// ── URLScheme ────────────────────────────────────────────────────────────────
+
+func TestURLScheme(t *testing.T) {
+	cases := map[string]string{
+		"https://example.com/a.txt": "https",
+		"HTTP://example.com":        "http",
+		"s3://bucket/key":           "s3",
+		"/tmp/a.txt":                "",
+		"~/notes.md":                "",
+		"docs/readme.md":            "",
+		`C:\Users\a.txt`:            "",
+	}
+	for in, want := range cases {
+		if got := URLScheme(in); got != want {
+			t.Errorf("URLScheme(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// ── WriteFile ────────────────────────────────────────────────────────────────
+
+func TestWriteFile_RejectsURL(t *testing.T) {
+	// Returns an error for a URL path; remote writes are not supported
+	if err := WriteFile("https://example.com/out.txt", "x"); err == nil {
+		t.Error("expected an error writing to a URL")
+	}
+}
+
+// ── SameContent ──────────────────────────────────────────────────────────────
+
+func TestSameContent(t *testing.T) {
+	// Returns true only for an existing regular file with byte-identical content
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.txt")
+	if err := os.WriteFile(path, []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path, content string
+		want          bool
+	}{
+		{path, "hello\n", true},
+		{path, "hello", false},
+		{path, "jello\n", false},
+		{filepath.Join(dir, "missing.txt"), "", false},
+		{dir, "", false},
+		{"https://example.com/out.txt", "hello\n", false},
+	}
+	for _, c := range cases {
+		if got := SameContent(c.path, c.content); got != c.want {
+			t.Errorf("SameContent(%q, %q) = %v, want %v", c.path, c.content, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// MaxGrepMatches caps the lines Grep returns; a pattern matching more than this
+// is too broad to be useful to the model and would flood its context.
+const MaxGrepMatches = 200
+
+// Grep limits on what is read: larger files and lines are skipped or cut.
+const (
+	grepMaxFileSize = 4 << 20 // files above 4 MiB are skipped (logs, datasets, binaries)
+	grepMaxLineLen  = 240     // matched lines are cut to this many bytes
+)
+
+// grepSkipDirs are directories Grep never descends into.
+var grepSkipDirs = map[string]bool{".git": true, "node_modules": true}
+
+// Grep walks root recursively and returns "file:line: text" for every line
+// matching the regular expression pattern, in files whose base name matches
+// glob (filepath.Match syntax; "" matches every file). root supports the ~
+// prefix and defaults to ".". truncated reports whether matches stopped at max.
+//
+// Expectations:
+//   - Returns an error for an empty or invalid pattern
+//   - Searches only files whose base name matches glob; a path prefix in glob is ignored, as in GlobFiles
+//   - Skips binary files, files over 4 MiB, and .git and node_modules directories
+//   - Returns at most max matches (MaxGrepMatches when max <= 0) and reports truncation
+//   - Returns ctx's error when ctx ends during the walk
+func Grep(ctx context.Context, root, pattern, glob string, max int) (matches []string, truncated bool, err error) {
+	if pattern == "" {
+		return nil, false, fmt.Errorf("grep: empty pattern")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, false, fmt.Errorf("grep: bad pattern: %w", err)
+	}
+	if root == "" {
+		root = "."
+	}
+	root = ExpandHome(root)
+	if idx := strings.LastIndex(glob, "/"); idx >= 0 {
+		glob = glob[idx+1:]
+	}
+	if max <= 0 {
+		max = MaxGrepMatches
+	}
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return nil // skip inaccessible entries
+		}
+		if d.IsDir() {
+			if path != root && grepSkipDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if glob != "" {
+			if ok, _ := filepath.Match(glob, d.Name()); !ok {
+				return nil
+			}
+		}
+		if info, err := d.Info(); err != nil || !info.Mode().IsRegular() || info.Size() > grepMaxFileSize {
+			return nil
+		}
+		if grepFile(path, re, max, &matches) {
+			truncated = true
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return matches, truncated, err
+}
+
+// grepFile appends path's matching lines to matches and reports whether max
+// was reached before the file was exhausted. Binary files are skipped.
+func grepFile(path string, re *regexp.Regexp, max int, matches *[]string) (full bool) {
+	data, err := os.ReadFile(path)
+	if err != nil || bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 {
+		return false
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), grepMaxFileSize)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Bytes()
+		if !re.Match(line) {
+			continue
+		}
+		if len(*matches) >= max {
+			return true
+		}
+		text := strings.TrimSpace(string(line))
+		if len(text) > grepMaxLineLen {
+			text = text[:grepMaxLineLen] + "…"
+		}
+		*matches = append(*matches, fmt.Sprintf("%s:%d: %s", path, n, text))
+	}
+	return false
+}
+
+// GrepJoin returns the matched lines as a newline-separated string, ready to be
+// returned as a tool result, noting when the list was cut at the match cap.
+func GrepJoin(matches []string, truncated bool) string {
+	s := strings.Join(matches, "\n")
+	if truncated {
+		s += fmt.Sprintf("\n(stopped after %d matches — narrow the pattern, root, or glob)", len(matches))
+	}
+	return s
+}
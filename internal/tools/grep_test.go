@@ -0,0 +1,107 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTree creates files (relative path → content) under a temp dir and returns it.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestGrep_MatchesOnlyGlobFiles(t *testing.T) {
+	// Searches only files whose base name matches glob; a path prefix in glob is ignored, as in GlobFiles
+	dir := writeTree(t, map[string]string{
+		"a.go":       "package a\n// TODO: fix\n",
+		"sub/b.go":   "package b\nvar x = 1 // TODO later\n",
+		"readme.txt": "TODO: write docs\n",
+	})
+	for _, glob := range []string{"*.go", "**/*.go"} {
+		got, truncated, err := Grep(t.Context(), dir, `TODO`, glob, 0)
+		if err != nil || truncated {
+			t.Fatalf("glob %q: err=%v truncated=%v", glob, err, truncated)
+		}
+		want := []string{
+			filepath.Join(dir, "a.go") + ":2: // TODO: fix",
+			filepath.Join(dir, "sub", "b.go") + ":2: var x = 1 // TODO later",
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("glob %q: got %q, want %q", glob, got, want)
+		}
+	}
+	if got, _, _ := Grep(t.Context(), dir, `TODO`, "", 0); len(got) != 3 {
+		t.Errorf("empty glob matched %d lines, want 3", len(got))
+	}
+}
+
+func TestGrep_SkipsBinaryAndVCSDirs(t *testing.T) {
+	// Skips binary files, files over 4 MiB, and .git and node_modules directories
+	dir := writeTree(t, map[string]string{
+		"keep.txt":            "needle\n",
+		"blob.bin":            "needle\x00\x01",
+		".git/config":         "needle\n",
+		"node_modules/x/y.js": "needle\n",
+		"big.log":             strings.Repeat("x", grepMaxFileSize) + "\nneedle\n",
+	})
+	got, _, err := Grep(t.Context(), dir, `needle`, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !strings.HasPrefix(got[0], filepath.Join(dir, "keep.txt")+":1:") {
+		t.Errorf("got %q, want only keep.txt", got)
+	}
+}
+
+func TestGrep_CapsMatches(t *testing.T) {
+	// Returns at most max matches (MaxGrepMatches when max <= 0) and reports truncation
+	var body strings.Builder
+	for i := range MaxGrepMatches + 5 {
+		fmt.Fprintf(&body, "hit %d\n", i)
+	}
+	dir := writeTree(t, map[string]string{"many.txt": body.String()})
+	got, truncated, err := Grep(t.Context(), dir, `^hit`, "", 0)
+	if err != nil || !truncated || len(got) != MaxGrepMatches {
+		t.Errorf("got %d matches, truncated=%v, err=%v; want %d, true", len(got), truncated, err, MaxGrepMatches)
+	}
+	got, truncated, _ = Grep(t.Context(), dir, `^hit [0-2]$`, "", 3)
+	if len(got) != 3 || truncated {
+		t.Errorf("exactly max matches: got %d, truncated=%v; want 3, false", len(got), truncated)
+	}
+	if s := GrepJoin([]string{"a", "b"}, true); !strings.HasSuffix(s, "(stopped after 2 matches — narrow the pattern, root, or glob)") {
+		t.Errorf("GrepJoin = %q", s)
+	}
+}
+
+func TestGrep_RejectsBadPattern(t *testing.T) {
+	// Returns an error for an empty or invalid pattern
+	for _, p := range []string{"", "("} {
+		if _, _, err := Grep(t.Context(), t.TempDir(), p, "", 0); err == nil {
+			t.Errorf("pattern %q: expected an error", p)
+		}
+	}
+}
+
+func TestGrep_StopsWhenContextEnds(t *testing.T) {
+	// Returns ctx's error when ctx ends during the walk
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, _, err := Grep(ctx, writeTree(t, map[string]string{"a.txt": "x\n"}), `x`, "", 0); err == nil {
+		t.Error("expected the context error")
+	}
+}
@@ -0,0 +1,126 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Limits caps how many calls of each tool run at once. Parallel subtasks share
+// one Limits, so a tool that cannot take concurrent callers (AppleScript UI
+// scripting drives one app at a time) is serialised across all of them.
+type Limits struct {
+	mu   sync.Mutex
+	max  map[string]int           // tool → concurrent calls allowed; absent = unlimited
+	sems map[string]chan struct{} // tool → semaphore sized max[tool]
+}
+
+// NewLimits returns Limits allowing max[tool] concurrent calls of each listed
+// tool; tools not listed, or listed with n <= 0, are unlimited.
+func NewLimits(max map[string]int) *Limits {
+	l := &Limits{max: make(map[string]int), sems: make(map[string]chan struct{})}
+	for tool, n := range max {
+		l.Set(tool, n)
+	}
+	return l
+}
+
+// defaultConcurrency is where NewDefaultLimits starts: applescript runs one call
+// at a time, everything else is unlimited.
+var defaultConcurrency = map[string]int{"applescript": 1}
+
+// DefaultLimits is shared by every executor not given its own Limits.
+var DefaultLimits = NewDefaultLimits(nil)
+
+// NewDefaultLimits returns the default limits with overrides, as parsed by
+// ParseLimits from ARTOO_TOOL_CONCURRENCY, applied on top.
+//
+// Expectations:
+//   - Limits applescript to 1 and leaves every other tool unlimited without overrides
+//   - An override replaces a default; n = 0 makes the tool unlimited
+func NewDefaultLimits(overrides map[string]int) *Limits {
+	l := NewLimits(defaultConcurrency)
+	for tool, n := range overrides {
+		l.Set(tool, n)
+	}
+	return l
+}
+
+// Set changes the limit for tool; n <= 0 removes it. Calls already running keep
+// the slot they hold and release it to the semaphore they took it from.
+func (l *Limits) Set(tool string, n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n <= 0 {
+		delete(l.max, tool)
+		delete(l.sems, tool)
+		return
+	}
+	l.max[tool] = n
+	l.sems[tool] = make(chan struct{}, n)
+}
+
+// Limit returns tool's concurrency limit, or 0 when it is unlimited.
+func (l *Limits) Limit(tool string) int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.max[tool]
+}
+
+// Acquire waits for a slot to run tool and returns the function that frees it.
+//
+// Expectations:
+//   - Returns immediately for unlimited tools, and on a nil *Limits
+//   - Blocks while the tool already has its limit of calls running
+//   - Returns ctx.Err() (and holds no slot) when ctx ends while waiting
+//   - release is safe to call more than once; only the first call frees the slot
+func (l *Limits) Acquire(ctx context.Context, tool string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	sem := l.sems[tool]
+	l.mu.Unlock()
+	if sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() { once.Do(func() { <-sem }) }, nil
+}
+
+// ParseLimits parses an ARTOO_TOOL_CONCURRENCY value: comma-separated
+// tool=n pairs such as "applescript=1,shell=4". n = 0 makes the tool unlimited.
+//
+// Expectations:
+//   - Returns an empty map for an empty or blank value
+//   - Trims space around tools and numbers
+//   - Returns an error for a pair without "=", an empty tool name, or an n that is not a non-negative integer
+func ParseLimits(s string) (map[string]int, error) {
+	out := make(map[string]int)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		tool, num, ok := strings.Cut(pair, "=")
+		tool = strings.TrimSpace(tool)
+		if !ok || tool == "" {
+			return nil, fmt.Errorf("tools: concurrency: %q is not tool=n", strings.TrimSpace(pair))
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(num))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("tools: concurrency: %q: limit must be a non-negative integer", tool)
+		}
+		out[tool] = n
+	}
+	return out, nil
+}
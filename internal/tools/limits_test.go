@@ -0,0 +1,115 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// peakConcurrency runs n calls of tool through l at once, each holding its slot
+// for hold, and returns the most that ran at the same time.
+func peakConcurrency(t *testing.T, l *Limits, tool string, n int, hold time.Duration) int {
+	t.Helper()
+	var running, peak atomic.Int32
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := l.Acquire(t.Context(), tool)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer release()
+			now := running.Add(1)
+			for {
+				p := peak.Load()
+				if now <= p || peak.CompareAndSwap(p, now) {
+					break
+				}
+			}
+			time.Sleep(hold)
+			running.Add(-1)
+		}()
+	}
+	wg.Wait()
+	return int(peak.Load())
+}
+
+func TestLimits_SerialisesApplescriptButNotReadFile(t *testing.T) {
+	// Blocks while the tool already has its limit of calls running; unlimited tools never wait
+	l := NewLimits(map[string]int{"applescript": 1})
+	if got := peakConcurrency(t, l, "applescript", 4, 20*time.Millisecond); got != 1 {
+		t.Errorf("applescript peak concurrency = %d, want 1", got)
+	}
+	if got := peakConcurrency(t, l, "read_file", 4, 20*time.Millisecond); got != 4 {
+		t.Errorf("read_file peak concurrency = %d, want 4", got)
+	}
+}
+
+func TestLimits_AcquireRespectsContext(t *testing.T) {
+	// Returns ctx.Err() (and holds no slot) when ctx ends while waiting
+	l := NewLimits(map[string]int{"shell": 1})
+	release, err := l.Acquire(t.Context(), "shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, "shell"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait to end with the context, got %v", err)
+	}
+	release()
+	release() // second call is a no-op
+	again, err := l.Acquire(t.Context(), "shell")
+	if err != nil {
+		t.Fatalf("expected the freed slot to be available, got %v", err)
+	}
+	again()
+}
+
+func TestNewDefaultLimits_AppliesOverrides(t *testing.T) {
+	// Limits applescript to 1 and leaves every other tool unlimited without overrides
+	// An override replaces a default; n = 0 makes the tool unlimited
+	if l := NewDefaultLimits(nil); l.Limit("applescript") != 1 || l.Limit("shell") != 0 {
+		t.Errorf("defaults: applescript=%d shell=%d, want 1 and 0", l.Limit("applescript"), l.Limit("shell"))
+	}
+	l := NewDefaultLimits(map[string]int{"applescript": 0, "shell": 4})
+	if l.Limit("applescript") != 0 || l.Limit("shell") != 4 {
+		t.Errorf("overridden: applescript=%d shell=%d, want 0 and 4", l.Limit("applescript"), l.Limit("shell"))
+	}
+}
+
+func TestLimits_SetZeroRemovesLimit(t *testing.T) {
+	// n <= 0 removes the limit; a nil *Limits is unlimited
+	l := NewLimits(map[string]int{"applescript": 1})
+	l.Set("applescript", 0)
+	if l.Limit("applescript") != 0 {
+		t.Errorf("expected applescript to be unlimited")
+	}
+	var none *Limits
+	release, err := none.Acquire(t.Context(), "applescript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
+
+func TestParseLimits(t *testing.T) {
+	got, err := ParseLimits(" applescript = 1, shell=4,,read_file=0 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got["applescript"] != 1 || got["shell"] != 4 || got["read_file"] != 0 {
+		t.Errorf("got %v", got)
+	}
+	for _, bad := range []string{"applescript", "=2", "shell=x", "shell=-1"} {
+		if _, err := ParseLimits(bad); err == nil {
+			t.Errorf("ParseLimits(%q) should fail", bad)
+		}
+	}
+}
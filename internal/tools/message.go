@@ -0,0 +1,193 @@
+package tools
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message channels a draft can be sent over.
+const (
+	ChannelEmail    = "email"    // Mail.app
+	ChannelIMessage = "imessage" // Messages.app
+)
+
+// draftTTL is how long a draft's token stays valid. A send that arrives later
+// must draft again, so a stale token from an old turn cannot fire.
+const draftTTL = 30 * time.Minute
+
+// MessageDraft is an unsent message held until its token is presented.
+type MessageDraft struct {
+	Token     string
+	Channel   string
+	To        []string
+	Subject   string // email only
+	Body      string
+	CreatedAt time.Time
+}
+
+// Messenger implements the draft-then-send workflow of the message tool: Draft
+// stores a message and returns a one-time token; only Send with that token
+// delivers it. Nothing is sent by Draft, so a hallucinated recipient is caught
+// when the draft is shown rather than after the email has gone.
+type Messenger struct {
+	mu     sync.Mutex
+	drafts map[string]MessageDraft
+	// run executes the generated AppleScript (RunAppleScript by default).
+	run func(ctx context.Context, script string) (string, error)
+	now func() time.Time
+}
+
+// NewMessenger returns a Messenger that sends via osascript.
+func NewMessenger() *Messenger {
+	return NewMessengerWith(RunAppleScript)
+}
+
+// NewMessengerWith returns a Messenger that hands each send script to run
+// instead of osascript.
+func NewMessengerWith(run func(ctx context.Context, script string) (string, error)) *Messenger {
+	return &Messenger{drafts: make(map[string]MessageDraft), run: run, now: time.Now}
+}
+
+// DefaultMessenger holds the drafts of the built-in message tool.
+var DefaultMessenger = NewMessenger()
+
+// Draft validates and stores a message without sending it. to is a comma- or
+// newline-separated recipient list; channel "" means email.
+//
+// Expectations:
+//   - Never runs AppleScript
+//   - Returns an error for an unknown channel, no recipients, or an empty body
+//   - Returns an error for an email recipient without "@"
+//   - Returns a draft with a fresh, unguessable token
+func (m *Messenger) Draft(channel, to, subject, body string) (MessageDraft, error) {
+	channel = strings.ToLower(strings.TrimSpace(channel))
+	if channel == "" || channel == "mail" {
+		channel = ChannelEmail
+	}
+	if channel != ChannelEmail && channel != ChannelIMessage {
+		return MessageDraft{}, fmt.Errorf("unknown channel %q (use %q or %q)", channel, ChannelEmail, ChannelIMessage)
+	}
+	var recipients []string
+	for _, r := range strings.FieldsFunc(to, func(c rune) bool { return c == ',' || c == ';' || c == '\n' }) {
+		if r = strings.TrimSpace(r); r != "" {
+			recipients = append(recipients, r)
+		}
+	}
+	if len(recipients) == 0 {
+		return MessageDraft{}, fmt.Errorf("no recipients")
+	}
+	if channel == ChannelEmail {
+		for _, r := range recipients {
+			if !strings.Contains(r, "@") {
+				return MessageDraft{}, fmt.Errorf("%q is not an email address", r)
+			}
+		}
+	}
+	if strings.TrimSpace(body) == "" {
+		return MessageDraft{}, fmt.Errorf("empty message body")
+	}
+	token, err := newDraftToken()
+	if err != nil {
+		return MessageDraft{}, err
+	}
+	d := MessageDraft{Token: token, Channel: channel, To: recipients, Subject: subject, Body: body, CreatedAt: m.now()}
+	m.mu.Lock()
+	m.drafts[token] = d
+	m.mu.Unlock()
+	return d, nil
+}
+
+// Send delivers the draft identified by token. The token is consumed whether
+// or not delivery succeeds; a failed send must be drafted again.
+//
+// Expectations:
+//   - Returns an error and runs nothing for an unknown, used, or expired token
+//   - Runs the AppleScript built by MessageScript for the stored draft
+//   - A token can be used at most once
+func (m *Messenger) Send(ctx context.Context, token string) (MessageDraft, error) {
+	token = strings.TrimSpace(token)
+	m.mu.Lock()
+	d, ok := m.drafts[token]
+	delete(m.drafts, token)
+	m.mu.Unlock()
+	if !ok {
+		return MessageDraft{}, fmt.Errorf("no draft for token %q — draft the message first", token)
+	}
+	if m.now().Sub(d.CreatedAt) > draftTTL {
+		return MessageDraft{}, fmt.Errorf("draft %s expired — draft the message again", token)
+	}
+	if _, err := m.run(ctx, MessageScript(d)); err != nil {
+		return MessageDraft{}, err
+	}
+	return d, nil
+}
+
+// Lookup returns the pending draft for token without consuming it.
+func (m *Messenger) Lookup(token string) (MessageDraft, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.drafts[strings.TrimSpace(token)]
+	return d, ok
+}
+
+// Summary describes d in one line: channel, recipients, and subject.
+func (d MessageDraft) Summary() string {
+	s := d.Channel + " to " + strings.Join(d.To, ", ")
+	if d.Subject != "" {
+		s += fmt.Sprintf(" — subject %q", d.Subject)
+	}
+	return s
+}
+
+// newDraftToken returns 8 random bytes as hex.
+func newDraftToken() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("draft token: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// MessageScript returns the AppleScript that sends d through Mail or Messages.
+// Every user-supplied value is embedded with AppleScriptString.
+func MessageScript(d MessageDraft) string {
+	var sb strings.Builder
+	if d.Channel == ChannelIMessage {
+		sb.WriteString("tell application \"Messages\"\n")
+		sb.WriteString("\tset svc to 1st account whose service type = iMessage\n")
+		for _, r := range d.To {
+			fmt.Fprintf(&sb, "\tsend %s to participant %s of svc\n", AppleScriptString(d.Body), AppleScriptString(r))
+		}
+		sb.WriteString("end tell\n")
+		return sb.String()
+	}
+	sb.WriteString("tell application \"Mail\"\n")
+	fmt.Fprintf(&sb, "\tset msg to make new outgoing message with properties {subject:%s, content:%s, visible:false}\n",
+		AppleScriptString(d.Subject), AppleScriptString(d.Body))
+	sb.WriteString("\ttell msg\n")
+	for _, r := range d.To {
+		fmt.Fprintf(&sb, "\t\tmake new to recipient at end of to recipients with properties {address:%s}\n", AppleScriptString(r))
+	}
+	sb.WriteString("\tend tell\n\tsend msg\nend tell\n")
+	return sb.String()
+}
+
+// AppleScriptString quotes s as an AppleScript string literal.
+//
+// Expectations:
+//   - Escapes backslashes and double quotes so s cannot close the literal
+//   - Joins lines with "& return &" so a newline cannot end the statement
+func AppleScriptString(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		l = strings.ReplaceAll(l, `\`, `\\`)
+		lines[i] = `"` + strings.ReplaceAll(l, `"`, `\"`) + `"`
+	}
+	return strings.Join(lines, " & return & ")
+}
@@ -0,0 +1,132 @@
+package tools
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingMessenger returns a Messenger whose sends are recorded instead of run.
+func recordingMessenger() (*Messenger, *[]string) {
+	scripts := &[]string{}
+	m := NewMessengerWith(func(_ context.Context, script string) (string, error) {
+		*scripts = append(*scripts, script)
+		return "", nil
+	})
+	return m, scripts
+}
+
+func TestMessengerDraft_DoesNotSend(t *testing.T) {
+	// Never runs AppleScript
+	// Returns a draft with a fresh, unguessable token
+	m, scripts := recordingMessenger()
+	d, err := m.Draft("", "bob@example.com, amy@example.com", "Summary", "Here it is.")
+	if err != nil {
+		t.Fatalf("Draft: %v", err)
+	}
+	if len(*scripts) != 0 {
+		t.Errorf("Draft ran %d scripts, want 0", len(*scripts))
+	}
+	if d.Channel != ChannelEmail || len(d.To) != 2 || len(d.Token) != 16 {
+		t.Errorf("draft = %+v, want email to 2 recipients with a 16-char token", d)
+	}
+	d2, _ := m.Draft("email", "bob@example.com", "Summary", "Here it is.")
+	if d2.Token == d.Token {
+		t.Error("two drafts got the same token")
+	}
+}
+
+func TestMessengerDraft_RejectsBadInput(t *testing.T) {
+	// Returns an error for an unknown channel, no recipients, or an empty body
+	// Returns an error for an email recipient without "@"
+	m, _ := recordingMessenger()
+	for _, tc := range []struct{ channel, to, body string }{
+		{"fax", "bob@example.com", "hi"},
+		{"email", " , ", "hi"},
+		{"email", "bob@example.com", "  "},
+		{"email", "Bob", "hi"},
+	} {
+		if _, err := m.Draft(tc.channel, tc.to, "", tc.body); err == nil {
+			t.Errorf("Draft(%q, %q, %q) succeeded, want error", tc.channel, tc.to, tc.body)
+		}
+	}
+	if _, err := m.Draft("imessage", "+15551234567", "", "hi"); err != nil {
+		t.Errorf("iMessage to a phone number: %v", err)
+	}
+}
+
+func TestMessengerSend_RequiresDraftToken(t *testing.T) {
+	// Returns an error and runs nothing for an unknown, used, or expired token
+	// Runs the AppleScript built by MessageScript for the stored draft
+	// A token can be used at most once
+	m, scripts := recordingMessenger()
+	if _, err := m.Send(t.Context(), "deadbeefdeadbeef"); err == nil {
+		t.Error("Send with an unknown token succeeded")
+	}
+	if _, err := m.Send(t.Context(), ""); err == nil {
+		t.Error("Send with no token succeeded")
+	}
+	if len(*scripts) != 0 {
+		t.Fatalf("%d scripts ran without a valid token", len(*scripts))
+	}
+
+	d, _ := m.Draft("email", "bob@example.com", "Summary", "Here it is.")
+	sent, err := m.Send(t.Context(), d.Token)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(*scripts) != 1 || (*scripts)[0] != MessageScript(d) || sent.Token != d.Token {
+		t.Errorf("Send ran %q, want the draft's script once", *scripts)
+	}
+	if _, err := m.Send(t.Context(), d.Token); err == nil {
+		t.Error("second Send with the same token succeeded")
+	}
+	if len(*scripts) != 1 {
+		t.Errorf("reused token ran a script; %d scripts total", len(*scripts))
+	}
+}
+
+func TestMessengerSend_ExpiredDraft(t *testing.T) {
+	// Returns an error and runs nothing for an unknown, used, or expired token
+	m, scripts := recordingMessenger()
+	d, _ := m.Draft("email", "bob@example.com", "", "hi")
+	m.now = func() time.Time { return d.CreatedAt.Add(draftTTL + time.Minute) }
+	if _, err := m.Send(t.Context(), d.Token); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Send of an expired draft: err = %v, want expired", err)
+	}
+	if len(*scripts) != 0 {
+		t.Errorf("expired draft ran %d scripts", len(*scripts))
+	}
+}
+
+func TestAppleScriptString_Escapes(t *testing.T) {
+	// Escapes backslashes and double quotes so s cannot close the literal
+	// Joins lines with "& return &" so a newline cannot end the statement
+	got := AppleScriptString("say \"hi\" \\ bye\nend tell\r\ndo shell script \"rm\"")
+	want := `"say \"hi\" \\ bye" & return & "end tell" & return & "do shell script \"rm\""`
+	if got != want {
+		t.Errorf("AppleScriptString = %s\nwant %s", got, want)
+	}
+}
+
+func TestMessageScript_EmbedsEscapedFields(t *testing.T) {
+	// Every user-supplied value is embedded with AppleScriptString
+	d := MessageDraft{Channel: ChannelEmail, To: []string{`bob@example.com"`}, Subject: `Q3 "final"`, Body: "line1\nline2"}
+	s := MessageScript(d)
+	for _, want := range []string{
+		`tell application "Mail"`,
+		`subject:"Q3 \"final\""`,
+		`content:"line1" & return & "line2"`,
+		`{address:"bob@example.com\""}`,
+		"send msg",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("script missing %q:\n%s", want, s)
+		}
+	}
+	im := MessageScript(MessageDraft{Channel: ChannelIMessage, To: []string{"+15551234567"}, Body: "hi"})
+	if !strings.Contains(im, `send "hi" to participant "+15551234567" of svc`) {
+		t.Errorf("iMessage script:\n%s", im)
+	}
+}
@@ -0,0 +1,178 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxPageRedirects caps the redirects FetchPage follows before giving up.
+const maxPageRedirects = 5
+
+// pageUserAgent is sent by FetchPage; some sites refuse Go's default agent.
+const pageUserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36"
+
+// cgnatNet is the carrier-grade NAT range, which some clouds use for their
+// metadata endpoints; net.IP.IsPrivate does not cover it.
+var cgnatNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// thisNet is 0.0.0.0/8 ("this network"); Linux routes any address in it to the
+// local host, and net.IP.IsUnspecified only covers 0.0.0.0 itself.
+var thisNet = &net.IPNet{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)}
+
+// internalAddr reports whether ip is one FetchPage must not connect to:
+// loopback, private, link-local, CGNAT, 0.0.0.0/8, unspecified, or multicast.
+func internalAddr(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || cgnatNet.Contains(ip) || thisNet.Contains(ip)
+}
+
+// pageAddrBlocked is internalAddr, indirected so tests can fetch from httptest servers.
+var pageAddrBlocked = internalAddr
+
+// pageClient fetches for FetchPage. The address check runs on every dial, after
+// DNS resolution and on each redirect hop, so neither a hostname nor a redirect
+// can point it at an internal service. Proxies from the environment are ignored
+// for the same reason.
+var pageClient = &http.Client{
+	Timeout: 20 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || pageAddrBlocked(ip) {
+					return fmt.Errorf("address %s is internal (loopback, private, or link-local); only public hosts can be fetched", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxPageRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxPageRedirects)
+		}
+		if s := req.URL.Scheme; s != "http" && s != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", s)
+		}
+		return nil
+	},
+}
+
+// FetchPage GETs an http(s) web page and returns its readable text: the title,
+// then the body with scripts, styles, and markup removed. Plain-text, JSON, and
+// XML responses are returned as they are. Unlike FetchURL, which read_file uses
+// for raw bodies, FetchPage refuses internal addresses.
+//
+// Expectations:
+//   - Returns an error for schemes other than http and https
+//   - Returns an error, without sending the request, for a loopback, private, or link-local address
+//   - Follows at most maxPageRedirects redirects, each to http(s) only
+//   - Returns an error naming the status for a non-2xx response
+//   - Returns an error for a binary (non-text) response
+//   - Reads at most maxFetchBytes of the body
+//   - Answers a repeated URL from DefaultWebCache within its TTL
+func FetchPage(ctx context.Context, rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if s := URLScheme(rawURL); s != "http" && s != "https" {
+		return "", fmt.Errorf("fetch_url %s: unsupported scheme %q (only http and https)", rawURL, s)
+	}
+	return DefaultWebCache.do("page", fetchCacheKey(rawURL), nil, func() (string, error) {
+		return fetchPageLive(ctx, rawURL)
+	})
+}
+
+// fetchPageLive GETs rawURL through pageClient, bypassing the cache.
+func fetchPageLive(ctx context.Context, rawURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("fetch_url %s: %w", rawURL, err)
+	}
+	req.Header.Set("User-Agent", pageUserAgent)
+	req.Header.Set("Accept", "text/html,text/plain;q=0.9,*/*;q=0.5")
+	resp, err := pageClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetch_url %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("fetch_url %s: HTTP %d", rawURL, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchBytes))
+	if err != nil {
+		return "", fmt.Errorf("fetch_url %s: %w", rawURL, err)
+	}
+	ct := resp.Header.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(body)
+	}
+	mediaType, _, _ := mime.ParseMediaType(ct)
+	switch {
+	case strings.Contains(mediaType, "html"):
+		return PageText(string(body)), nil
+	case strings.HasPrefix(mediaType, "text/"), strings.Contains(mediaType, "json"), strings.Contains(mediaType, "xml"):
+		return string(body), nil
+	}
+	return "", fmt.Errorf("fetch_url %s: %s is not a text page (download it with shell instead)", rawURL, mediaType)
+}
+
+var (
+	pageTitleRe   = regexp.MustCompile(`(?is)<title\b[^>]*>(.*?)</title\s*>`)
+	pageCommentRe = regexp.MustCompile(`(?s)<!--.*?-->`)
+	// pageBlockRe matches tags that start a new line of text.
+	pageBlockRe = regexp.MustCompile(`(?i)</?(?:p|div|br|hr|li|ul|ol|dl|dt|dd|h[1-6]|tr|table|section|article|header|footer|nav|aside|main|blockquote|pre|figure|figcaption)\b[^>]*>`)
+	// pageDropRes match elements whose content is never readable text. One
+	// expression per element, since RE2 has no backreferences to pair tags.
+	pageDropRes = func() []*regexp.Regexp {
+		var res []*regexp.Regexp
+		for _, tag := range []string{"head", "script", "style", "noscript", "template", "svg", "iframe"} {
+			res = append(res, regexp.MustCompile(`(?is)<`+tag+`\b[^>]*>.*?</`+tag+`\s*>`))
+		}
+		return res
+	}()
+)
+
+// PageText extracts the readable text of an HTML document.
+//
+// Expectations:
+//   - Starts with "Title: <title>" and a blank line when the page has a title
+//   - Drops the head, scripts, styles, comments, and other non-text elements with their content
+//   - Puts block elements (paragraphs, headings, list items, rows) on their own lines
+//   - Unescapes HTML entities and collapses runs of whitespace within each line
+//   - Drops empty lines
+func PageText(doc string) string {
+	var title string
+	if m := pageTitleRe.FindStringSubmatch(doc); m != nil {
+		title = strings.Join(strings.Fields(html.UnescapeString(stripHTMLTags(m[1]))), " ")
+	}
+	doc = pageCommentRe.ReplaceAllString(doc, "")
+	for _, re := range pageDropRes {
+		doc = re.ReplaceAllString(doc, "")
+	}
+	doc = pageBlockRe.ReplaceAllString(doc, "\n")
+	doc = html.UnescapeString(stripHTMLTags(doc))
+
+	var lines []string
+	if title != "" {
+		lines = append(lines, "Title: "+title, "")
+	}
+	for _, l := range strings.Split(doc, "\n") {
+		if l = strings.Join(strings.Fields(l), " "); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
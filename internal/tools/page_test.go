@@ -0,0 +1,126 @@
+package tools
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// allowInternalPages lets FetchPage reach loopback httptest servers for the test.
+func allowInternalPages(t *testing.T) {
+	t.Helper()
+	prev := pageAddrBlocked
+	pageAddrBlocked = func(net.IP) bool { return false }
+	t.Cleanup(func() { pageAddrBlocked = prev })
+}
+
+func TestPageText_ExtractsReadableText(t *testing.T) {
+	// Starts with the title, drops head/script/style/comments, puts blocks on their own lines,
+	// unescapes entities, collapses whitespace, and drops empty lines
+	doc := `<!doctype html><html><head><title>Release  notes</title><style>p{color:red}</style></head>
+<body><script>var x = "<p>not text</p>";</script><!-- hidden -->
+<h1>Version 2.0</h1><p>Faster   builds &amp; <b>smaller</b> binaries.</p>
+<ul><li>one</li><li>two</li></ul><noscript>enable js</noscript></body></html>`
+	want := "Title: Release notes\n\nVersion 2.0\nFaster builds & smaller binaries.\none\ntwo"
+	if got := PageText(doc); got != want {
+		t.Errorf("PageText =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestFetchPage_ReturnsTextOfHTMLAndPlainBodies(t *testing.T) {
+	// HTML is reduced to its text; plain text is returned as it is; a non-2xx status and a binary body are errors
+	allowInternalPages(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, "<html><body><script>track()</script><p>Hello <i>there</i></p></body></html>")
+		case "/notes.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, "<p> stays </p>")
+		case "/logo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte{0x89, 'P', 'N', 'G'})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	if got, err := FetchPage(t.Context(), srv.URL+"/page"); err != nil || got != "Hello there" {
+		t.Errorf("html page = %q, %v; want %q", got, err, "Hello there")
+	}
+	if got, err := FetchPage(t.Context(), srv.URL+"/notes.txt"); err != nil || got != "<p> stays </p>" {
+		t.Errorf("text page = %q, %v", got, err)
+	}
+	if _, err := FetchPage(t.Context(), srv.URL+"/logo.png"); err == nil || !strings.Contains(err.Error(), "not a text page") {
+		t.Errorf("expected a not-a-text-page error, got %v", err)
+	}
+	if _, err := FetchPage(t.Context(), srv.URL+"/missing"); err == nil || !strings.Contains(err.Error(), "HTTP 404") {
+		t.Errorf("expected an HTTP 404 error, got %v", err)
+	}
+}
+
+func TestFetchPage_BlocksInternalAddressesAndSchemes(t *testing.T) {
+	// Returns an error, without sending the request, for a loopback address, and for non-http(s) schemes
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits++
+		fmt.Fprint(w, "secret")
+	}))
+	defer srv.Close()
+	if _, err := FetchPage(t.Context(), srv.URL); err == nil || !strings.Contains(err.Error(), "internal") {
+		t.Errorf("expected the loopback address to be refused, got %v", err)
+	}
+	if hits != 0 {
+		t.Errorf("server was reached %d times", hits)
+	}
+	for _, u := range []string{"file:///etc/passwd", "ftp://example.com/x", "/etc/passwd"} {
+		if _, err := FetchPage(t.Context(), u); err == nil || !strings.Contains(err.Error(), "unsupported scheme") {
+			t.Errorf("%s: expected an unsupported-scheme error, got %v", u, err)
+		}
+	}
+}
+
+func TestFetchPage_BoundsRedirects(t *testing.T) {
+	// Follows at most maxPageRedirects redirects, each to http(s) only
+	allowInternalPages(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/to-file":
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+		case "/hop":
+			http.Redirect(w, r, "/done", http.StatusMovedPermanently)
+		default:
+			fmt.Fprint(w, "arrived")
+		}
+	}))
+	defer srv.Close()
+	if got, err := FetchPage(t.Context(), srv.URL+"/hop"); err != nil || got != "arrived" {
+		t.Errorf("single redirect = %q, %v", got, err)
+	}
+	if _, err := FetchPage(t.Context(), srv.URL+"/loop"); err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("expected a redirect-limit error, got %v", err)
+	}
+	if _, err := FetchPage(t.Context(), srv.URL+"/to-file"); err == nil || !strings.Contains(err.Error(), "unsupported scheme") {
+		t.Errorf("expected the file:// redirect to be refused, got %v", err)
+	}
+}
+
+func TestInternalAddr(t *testing.T) {
+	// Loopback, private, link-local (cloud metadata), CGNAT, 0.0.0.0/8, and unspecified addresses are internal; public ones are not
+	for addr, want := range map[string]bool{
+		"127.0.0.1": true, "::1": true, "10.1.2.3": true, "192.168.0.10": true, "172.16.5.4": true,
+		"169.254.169.254": true, "100.100.100.200": true, "0.0.0.0": true, "0.1.2.3": true, "fd00::1": true,
+		"93.184.216.34": false, "2606:4700::1111": false,
+	} {
+		if got := internalAddr(net.ParseIP(addr)); got != want {
+			t.Errorf("internalAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
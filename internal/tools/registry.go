@@ -0,0 +1,83 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Tool is one capability R3 Executor can invoke. R3's system prompt lists every
+// registered tool and its calls are dispatched by name, so adding a tool means
+// registering it — no executor changes. A tool that knows the shape of its
+// output can also implement ContentTyper.
+type Tool interface {
+	// Name is the value of "tool" in the model's call JSON. Must be unique.
+	Name() string
+	// Description tells the model when to use the tool. The first line is the
+	// summary; further lines are shown as indented notes under the input example.
+	Description() string
+	// Schema is an example call, e.g. {"action":"tool","tool":"glob","pattern":"*.go"}.
+	Schema() string
+	// Run executes one call. input is the model's full call JSON object.
+	// Return tool-level failures the model can react to as output, not as err.
+	Run(ctx context.Context, input json.RawMessage) (string, error)
+}
+
+// Registry holds tools in registration order, which is the order R3's prompt
+// lists them in ("use the FIRST tool that fits").
+type Registry struct {
+	mu    sync.RWMutex
+	byKey map[string]Tool
+	order []Tool
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{byKey: make(map[string]Tool)}
+}
+
+// Default is the registry R3 uses unless given another. The built-in tools
+// register themselves into it; register custom tools before constructing the executor.
+var Default = NewRegistry()
+
+// Register adds t to the Default registry. See Registry.Register.
+func Register(t Tool) error {
+	return Default.Register(t)
+}
+
+// Register adds t after the tools already registered.
+//
+// Expectations:
+//   - Returns an error when t's name is empty
+//   - Returns an error when a tool with the same name is already registered
+//   - Registered tools are returned by Lookup and listed by Tools in registration order
+func (r *Registry) Register(t Tool) error {
+	name := t.Name()
+	if name == "" {
+		return fmt.Errorf("tools: register: empty tool name")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.byKey[name]; dup {
+		return fmt.Errorf("tools: register: tool %q already registered", name)
+	}
+	r.byKey[name] = t
+	r.order = append(r.order, t)
+	return nil
+}
+
+// Lookup returns the tool registered under name.
+func (r *Registry) Lookup(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.byKey[name]
+	return t, ok
+}
+
+// Tools returns the registered tools in registration order.
+func (r *Registry) Tools() []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Tool(nil), r.order...)
+}
@@ -0,0 +1,66 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// fakeTool is a minimal Tool for registry tests.
+type fakeTool struct{ name string }
+
+func (f fakeTool) Name() string        { return f.name }
+func (f fakeTool) Description() string { return "fake tool" }
+func (f fakeTool) Schema() string      { return `{"action":"tool","tool":"` + f.name + `"}` }
+func (f fakeTool) Run(context.Context, json.RawMessage) (string, error) {
+	return "ran " + f.name, nil
+}
+
+func TestRegistry_RegisterAndLookup(t *testing.T) {
+	// Registered tools are returned by Lookup and listed by Tools in registration order
+	r := NewRegistry()
+	for _, n := range []string{"beta", "alpha"} {
+		if err := r.Register(fakeTool{n}); err != nil {
+			t.Fatalf("Register(%s): %v", n, err)
+		}
+	}
+	if _, ok := r.Lookup("alpha"); !ok {
+		t.Error("expected alpha to be found")
+	}
+	if _, ok := r.Lookup("gamma"); ok {
+		t.Error("expected gamma to be missing")
+	}
+	got := r.Tools()
+	if len(got) != 2 || got[0].Name() != "beta" || got[1].Name() != "alpha" {
+		t.Errorf("expected [beta alpha] in registration order, got %v", got)
+	}
+}
+
+func TestRegistry_RejectsDuplicateName(t *testing.T) {
+	// Returns an error when a tool with the same name is already registered
+	r := NewRegistry()
+	if err := r.Register(fakeTool{"x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(fakeTool{"x"}); err == nil {
+		t.Error("expected duplicate registration to fail")
+	}
+}
+
+func TestRegistry_RejectsEmptyName(t *testing.T) {
+	// Returns an error when t's name is empty
+	if err := NewRegistry().Register(fakeTool{""}); err == nil {
+		t.Error("expected empty name to be rejected")
+	}
+}
+
+func TestShellEnvFrom_RoundTrip(t *testing.T) {
+	// ShellEnvFrom returns the env stored by WithShellEnv, and the zero ShellEnv otherwise
+	env := ShellEnv{Vars: map[string]string{"A": "1"}, Clear: true}
+	if got := ShellEnvFrom(WithShellEnv(context.Background(), env)); !got.Clear || got.Vars["A"] != "1" {
+		t.Errorf("expected stored env, got %+v", got)
+	}
+	if got := ShellEnvFrom(context.Background()); got.Clear || got.Vars != nil {
+		t.Errorf("expected zero ShellEnv, got %+v", got)
+	}
+}
@@ -0,0 +1,67 @@
+package tools
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"sync"
+)
+
+// noNetworkProfile is the sandbox-exec profile used on macOS: everything is
+// allowed except network access.
+const noNetworkProfile = "(version 1)(allow default)(deny network*)"
+
+// NoNetworkTag prefixes the note appended to a failed no-network shell result.
+const NoNetworkTag = "[NO-NETWORK]"
+
+// netnsProbe creates a throwaway user + network namespace the way noNetworkArgv
+// does, once per process: having unshare on PATH is not enough where the kernel
+// or a container forbids unprivileged user namespaces. A variable so tests can
+// simulate either outcome.
+var netnsProbe = sync.OnceValue(func() error {
+	return exec.Command("unshare", "--map-root-user", "--net", "true").Run()
+})
+
+// NoNetworkSupported reports whether shell commands can be run without network
+// access here. When they cannot, reason says why in a form suitable for users.
+// On Linux, commands run as uid 0 inside their namespace — root mapped to the
+// invoking user, with no extra rights outside it.
+//
+// Expectations:
+//   - Linux: requires unshare on PATH (a new network namespace has only a down loopback)
+//   - Linux: requires that unshare --map-root-user --net actually succeeds, probed once per process
+//   - macOS: requires sandbox-exec on PATH (best effort; Apple marks it deprecated)
+//   - Returns false with a "not supported" reason on any other OS
+func NoNetworkSupported() (ok bool, reason string) {
+	switch runtime.GOOS {
+	case "linux":
+		if _, err := lookPath("unshare"); err != nil {
+			return false, "no-network mode needs unshare (util-linux), which is not on PATH"
+		}
+		if err := netnsProbe(); err != nil {
+			return false, fmt.Sprintf("no-network mode cannot create a network namespace here (unshare --map-root-user --net: %v); unprivileged user namespaces may be disabled", err)
+		}
+	case "darwin":
+		if _, err := lookPath("sandbox-exec"); err != nil {
+			return false, "no-network mode needs sandbox-exec, which is not on PATH"
+		}
+	default:
+		return false, fmt.Sprintf("no-network mode is not supported on %s", runtime.GOOS)
+	}
+	return true, ""
+}
+
+// noNetworkArgv returns the argv that runs cmd in bash without network access:
+// `unshare -rn` on Linux (an unprivileged user + network namespace) and
+// sandbox-exec with noNetworkProfile on macOS. Returns an error, and nothing to
+// run, where NoNetworkSupported is false — a no-network command never falls
+// back to running with the network.
+func noNetworkArgv(cmd string) ([]string, error) {
+	if ok, reason := NoNetworkSupported(); !ok {
+		return nil, fmt.Errorf("%s", reason)
+	}
+	if runtime.GOOS == "darwin" {
+		return []string{"sandbox-exec", "-p", noNetworkProfile, "bash", "-c", cmd}, nil
+	}
+	return []string{"unshare", "--map-root-user", "--net", "bash", "-c", cmd}, nil
+}
@@ -0,0 +1,99 @@
+package tools
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// requireNetNamespace skips t unless this Linux host lets an unprivileged
+// process create a network namespace (containers often forbid it).
+func requireNetNamespace(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skip("network namespaces are Linux-only")
+	}
+	if err := exec.Command("unshare", "--map-root-user", "--net", "true").Run(); err != nil {
+		t.Skipf("cannot create a network namespace here: %v", err)
+	}
+}
+
+func TestRunShellEnv_NoNetworkBlocksConnections(t *testing.T) {
+	// Runs cmd without network access when env.NoNetwork is set
+	requireNetNamespace(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+	cmd := "exec 3<>/dev/tcp/127.0.0.1/" + strconv.Itoa(port) + " && echo connected"
+
+	stdout, stderr, err := RunShellEnv(t.Context(), cmd, ShellEnv{})
+	if err != nil || strings.TrimSpace(stdout) != "connected" {
+		t.Fatalf("with network: stdout=%q stderr=%q err=%v; want connected", stdout, stderr, err)
+	}
+	stdout, _, err = RunShellEnv(t.Context(), cmd, ShellEnv{NoNetwork: true})
+	if err == nil || strings.Contains(stdout, "connected") {
+		t.Errorf("without network: stdout=%q err=%v; want the connection to fail", stdout, err)
+	}
+}
+
+func TestRunShellEnv_NoNetworkKeepsOtherBehaviour(t *testing.T) {
+	// Commands that need no network still run, see env.Vars, and can write files
+	requireNetNamespace(t)
+	path := filepath.Join(t.TempDir(), "out.txt")
+	stdout, _, err := RunShellEnv(t.Context(), `echo "$GREETING" > `+path+` && cat `+path, ShellEnv{Vars: map[string]string{"GREETING": "hi"}, NoNetwork: true})
+	if err != nil || strings.TrimSpace(stdout) != "hi" {
+		t.Errorf("stdout=%q err=%v; want hi", stdout, err)
+	}
+}
+
+func TestRunShellEnv_NoNetworkUnsupportedRunsNothing(t *testing.T) {
+	// Returns an error wrapping ErrSandbox without running cmd where no-network cannot be enforced
+	stubLookPath(t, "bash")
+	if ok, reason := NoNetworkSupported(); ok || reason == "" {
+		t.Fatalf("NoNetworkSupported() = %v, %q; want false with a reason", ok, reason)
+	}
+	marker := filepath.Join(t.TempDir(), "ran")
+	if _, _, err := RunShellEnv(t.Context(), "touch "+marker, ShellEnv{NoNetwork: true}); !errors.Is(err, ErrSandbox) {
+		t.Errorf("got %v, want an error wrapping ErrSandbox", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("the command ran with the network although no-network was requested")
+	}
+}
+
+func TestNoNetworkSupported_ProbesNamespace(t *testing.T) {
+	// Linux: requires that unshare --map-root-user --net actually succeeds, probed once per process
+	if runtime.GOOS != "linux" {
+		t.Skip("the namespace probe is Linux-only")
+	}
+	stubLookPath(t, "bash", "unshare")
+	orig := netnsProbe
+	t.Cleanup(func() { netnsProbe = orig })
+
+	netnsProbe = func() error { return errors.New("unshare failed: Operation not permitted") }
+	if ok, reason := NoNetworkSupported(); ok || !strings.Contains(reason, "Operation not permitted") {
+		t.Errorf("probe failing: got %v, %q; want false naming the failure", ok, reason)
+	}
+	netnsProbe = func() error { return nil }
+	if ok, reason := NoNetworkSupported(); !ok {
+		t.Errorf("probe succeeding: got false, %q", reason)
+	}
+}
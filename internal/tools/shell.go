@@ -3,24 +3,202 @@ package tools
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"os"
 	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const defaultShellTimeout = 30 * time.Second
 
-// RunShell executes cmd in a bash shell with a default 30s timeout.
-// Returns stdout, stderr, and any execution error.
+// ErrShellTimeout is wrapped by RunShellEnv's error when its deadline stopped cmd.
+var ErrShellTimeout = errors.New("shell command timed out")
+
+// ErrSandbox is wrapped by RunShellEnv's error when the no-network sandbox could
+// not run cmd at all.
+var ErrSandbox = errors.New("no-network sandbox failed")
+
+// defaultShellOutputCap is the per-stream capture limit for RunShell.
+// Override with ARTOO_SHELL_MAX_OUTPUT (bytes).
+const defaultShellOutputCap = 1 << 20 // 1 MB
+
+// shellWaitDelay bounds how long RunShell waits for stdout/stderr to close after
+// the process is killed — background children that inherited the pipes would
+// otherwise keep Wait blocked indefinitely.
+const shellWaitDelay = 2 * time.Second
+
+// shellOutputCap returns the capture limit from ARTOO_SHELL_MAX_OUTPUT, falling
+// back to defaultShellOutputCap when unset or not a positive integer.
+//
+// Expectations:
+//   - Returns defaultShellOutputCap when ARTOO_SHELL_MAX_OUTPUT is unset
+//   - Returns the parsed value when ARTOO_SHELL_MAX_OUTPUT is a positive integer
+//   - Returns defaultShellOutputCap when the value is zero, negative, or not a number
+func shellOutputCap() int {
+	if v := os.Getenv("ARTOO_SHELL_MAX_OUTPUT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultShellOutputCap
+}
+
+// cappedBuffer is an io.Writer that keeps at most limit bytes and silently
+// discards the rest. It always reports a full write so the copying goroutine
+// in os/exec never errors out. onOverflow is called once, on the first write
+// that crosses the limit.
+type cappedBuffer struct {
+	buf        bytes.Buffer
+	limit      int
+	overflowed bool
+	onOverflow func()
+}
+
+func (w *cappedBuffer) Write(p []byte) (int, error) {
+	if room := w.limit - w.buf.Len(); room > 0 {
+		if len(p) <= room {
+			return w.buf.Write(p)
+		}
+		w.buf.Write(p[:room])
+	}
+	if !w.overflowed {
+		w.overflowed = true
+		if w.onOverflow != nil {
+			w.onOverflow()
+		}
+	}
+	return len(p), nil
+}
+
+// String returns the captured bytes, followed by a truncation note when the
+// limit was exceeded.
+func (w *cappedBuffer) String() string {
+	if !w.overflowed {
+		return w.buf.String()
+	}
+	return w.buf.String() + fmt.Sprintf("\n[output truncated at %d bytes]", w.limit)
+}
+
+// ShellEnv describes the environment a shell command runs with.
+// The zero value inherits the full process environment unchanged.
+type ShellEnv struct {
+	Vars      map[string]string // set (or override) these variables
+	Clear     bool              // start from an empty environment instead of os.Environ()
+	NoNetwork bool              // run without network access (see NoNetworkSupported)
+}
+
+// shellEnvKey is the context key under which WithShellEnv stores a ShellEnv.
+type shellEnvKey struct{}
+
+// WithShellEnv returns a copy of ctx carrying env, so a Tool's Run can pick up the
+// per-subtask shell environment without it being part of the Tool interface.
+func WithShellEnv(ctx context.Context, env ShellEnv) context.Context {
+	return context.WithValue(ctx, shellEnvKey{}, env)
+}
+
+// ShellEnvFrom returns the ShellEnv stored by WithShellEnv, or the zero ShellEnv
+// (full inheritance) when ctx carries none.
+func ShellEnvFrom(ctx context.Context) ShellEnv {
+	env, _ := ctx.Value(shellEnvKey{}).(ShellEnv)
+	return env
+}
+
+// environ returns the exec.Cmd Env slice for e, or nil to inherit the process
+// environment as-is.
+//
+// Expectations:
+//   - Returns nil for the zero ShellEnv (full inheritance)
+//   - Returns os.Environ() followed by Vars (sorted by key) when Clear is false
+//   - Returns only Vars (sorted by key) when Clear is true; an empty non-nil slice when Vars is empty
+func (e ShellEnv) environ() []string {
+	if !e.Clear && len(e.Vars) == 0 {
+		return nil
+	}
+	var env []string
+	if e.Clear {
+		env = make([]string, 0, len(e.Vars))
+	} else {
+		env = os.Environ()
+	}
+	keys := make([]string, 0, len(e.Vars))
+	for k := range e.Vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	// Later entries win in os/exec, so appended Vars override inherited ones.
+	for _, k := range keys {
+		env = append(env, k+"="+e.Vars[k])
+	}
+	return env
+}
+
+// RunShell executes cmd in a bash shell with a default 30s timeout, inheriting
+// the process environment. See RunShellEnv.
 func RunShell(ctx context.Context, cmd string) (stdout, stderr string, err error) {
-	ctx, cancel := context.WithTimeout(ctx, defaultShellTimeout)
-	defer cancel()
+	return RunShellEnv(ctx, cmd, ShellEnv{})
+}
+
+// RunShellEnv executes cmd in a bash shell with a default 30s timeout and the
+// environment described by env. The default applies only when ctx has no
+// deadline, so a caller's own ceiling (the executor's ARTOO_TOOL_TIMEOUTS) wins.
+// Returns stdout, stderr, and any execution error.
+//
+// Each stream is captured up to shellOutputCap() bytes. When either stream
+// exceeds the cap the process is killed, the captured prefix is returned with
+// a "[output truncated at N bytes]" note, and the kill itself is not reported
+// as an error — the note already tells the caller what happened.
+//
+// Expectations:
+//   - Returns stdout and stderr unchanged when both are below the cap
+//   - Stops capturing at the cap and appends "[output truncated at N bytes]"
+//   - Kills a command that keeps producing output past the cap (e.g. `yes`) and returns nil error
+//   - Runs with only env.Vars (plus bash's own PWD/SHLVL/_) when env.Clear is set
+//   - Runs cmd without network access when env.NoNetwork is set, and returns an
+//     error wrapping ErrSandbox without running it where that cannot be enforced
+//   - Wraps ErrSandbox when the sandbox itself fails to start (its own message leads stderr)
+//   - Bounds cmd by defaultShellTimeout only when ctx has no deadline
+//   - Wraps ErrShellTimeout when the deadline, not the caller's cancel, stopped cmd
+func RunShellEnv(ctx context.Context, cmd string, env ShellEnv) (stdout, stderr string, err error) {
+	argv := []string{"bash", "-c", cmd}
+	if env.NoNetwork {
+		if argv, err = noNetworkArgv(cmd); err != nil {
+			return "", "", fmt.Errorf("%w: %v", ErrSandbox, err)
+		}
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultShellTimeout)
+		defer cancel()
+	}
+	runCtx, kill := context.WithCancel(ctx)
+	defer kill()
 
-	c := exec.CommandContext(ctx, "bash", "-c", cmd)
+	c := exec.CommandContext(runCtx, argv[0], argv[1:]...)
+	c.WaitDelay = shellWaitDelay
+	c.Env = env.environ()
 
-	var outBuf, errBuf bytes.Buffer
-	c.Stdout = &outBuf
-	c.Stderr = &errBuf
+	limit := shellOutputCap()
+	outBuf := &cappedBuffer{limit: limit, onOverflow: kill}
+	errBuf := &cappedBuffer{limit: limit, onOverflow: kill}
+	c.Stdout = outBuf
+	c.Stderr = errBuf
 
 	err = c.Run()
+	if (outBuf.overflowed || errBuf.overflowed) && ctx.Err() == nil {
+		// Killed by the output cap, not by the caller or the timeout.
+		err = nil
+	}
+	switch {
+	case err == nil:
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		err = fmt.Errorf("%w: %v", ErrShellTimeout, err)
+	case env.NoNetwork && strings.HasPrefix(errBuf.String(), argv[0]+":"):
+		// unshare or sandbox-exec reported its own failure; bash never ran.
+		err = fmt.Errorf("%w: %v", ErrSandbox, err)
+	}
 	return outBuf.String(), errBuf.String(), err
 }
@@ -0,0 +1,170 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ── shellOutputCap ───────────────────────────────────────────────────────────
+
+func TestShellOutputCap_DefaultWhenUnset(t *testing.T) {
+	// Returns defaultShellOutputCap when ARTOO_SHELL_MAX_OUTPUT is unset
+	t.Setenv("ARTOO_SHELL_MAX_OUTPUT", "")
+	if got := shellOutputCap(); got != defaultShellOutputCap {
+		t.Errorf("shellOutputCap() = %d, want %d", got, defaultShellOutputCap)
+	}
+}
+
+func TestShellOutputCap_ParsesPositiveValue(t *testing.T) {
+	// Returns the parsed value when ARTOO_SHELL_MAX_OUTPUT is a positive integer
+	t.Setenv("ARTOO_SHELL_MAX_OUTPUT", "4096")
+	if got := shellOutputCap(); got != 4096 {
+		t.Errorf("shellOutputCap() = %d, want 4096", got)
+	}
+}
+
+func TestShellOutputCap_DefaultOnInvalidValue(t *testing.T) {
+	// Returns defaultShellOutputCap when the value is zero, negative, or not a number
+	for _, v := range []string{"0", "-5", "lots"} {
+		t.Setenv("ARTOO_SHELL_MAX_OUTPUT", v)
+		if got := shellOutputCap(); got != defaultShellOutputCap {
+			t.Errorf("ARTOO_SHELL_MAX_OUTPUT=%q: got %d, want default", v, got)
+		}
+	}
+}
+
+// ── RunShell ─────────────────────────────────────────────────────────────────
+
+func TestRunShell_SmallOutputUnchanged(t *testing.T) {
+	// Returns stdout and stderr unchanged when both are below the cap
+	stdout, stderr, err := RunShell(context.Background(), "echo hello; echo oops >&2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stdout != "hello\n" || stderr != "oops\n" {
+		t.Errorf("got stdout=%q stderr=%q", stdout, stderr)
+	}
+}
+
+func TestRunShell_TruncatesAtCap(t *testing.T) {
+	// Stops capturing at the cap and appends "[output truncated at N bytes]"
+	t.Setenv("ARTOO_SHELL_MAX_OUTPUT", "1000")
+	stdout, _, err := RunShell(context.Background(), "head -c 50000 /dev/zero | tr '\\0' 'a'")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	note := "[output truncated at 1000 bytes]"
+	if !strings.HasSuffix(stdout, note) {
+		t.Errorf("expected truncation note suffix, got tail %q", lastRunes(stdout, 60))
+	}
+	if captured := strings.TrimSuffix(stdout, "\n"+note); len(captured) != 1000 {
+		t.Errorf("expected exactly 1000 captured bytes, got %d", len(captured))
+	}
+}
+
+func TestRunShell_KillsRunawayOutput(t *testing.T) {
+	// Kills a command that keeps producing output past the cap (e.g. `yes`) and returns nil error
+	t.Setenv("ARTOO_SHELL_MAX_OUTPUT", "4096")
+	start := time.Now()
+	stdout, _, err := RunShell(context.Background(), "yes")
+	if err != nil {
+		t.Fatalf("expected nil error after cap kill, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("expected runaway command to be killed promptly, took %v", elapsed)
+	}
+	if len(stdout) > 4096+64 {
+		t.Errorf("expected memory-bounded capture, got %d bytes", len(stdout))
+	}
+	if !strings.Contains(stdout, "[output truncated at 4096 bytes]") {
+		t.Error("expected truncation note")
+	}
+}
+
+func lastRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[len(r)-n:])
+}
+
+// ── ShellEnv / RunShellEnv ───────────────────────────────────────────────────
+
+func TestShellEnvEnviron_ZeroValueInherits(t *testing.T) {
+	// Returns nil for the zero ShellEnv (full inheritance)
+	if env := (ShellEnv{}).environ(); env != nil {
+		t.Errorf("expected nil, got %v", env)
+	}
+}
+
+func TestShellEnvEnviron_AugmentsProcessEnv(t *testing.T) {
+	// Returns os.Environ() followed by Vars (sorted by key) when Clear is false
+	t.Setenv("ARTOO_TEST_INHERITED", "yes")
+	env := ShellEnv{Vars: map[string]string{"B": "2", "A": "1"}}.environ()
+	n := len(env)
+	if n < 2 || env[n-2] != "A=1" || env[n-1] != "B=2" {
+		t.Fatalf("expected A=1, B=2 appended last, got tail %v", env[max(0, n-2):])
+	}
+	found := false
+	for _, kv := range env {
+		if kv == "ARTOO_TEST_INHERITED=yes" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected inherited variable to be kept")
+	}
+}
+
+func TestShellEnvEnviron_ClearKeepsOnlyVars(t *testing.T) {
+	// Returns only Vars (sorted by key) when Clear is true; an empty non-nil slice when Vars is empty
+	env := ShellEnv{Vars: map[string]string{"FOO": "bar"}, Clear: true}.environ()
+	if len(env) != 1 || env[0] != "FOO=bar" {
+		t.Errorf("expected [FOO=bar], got %v", env)
+	}
+	if env := (ShellEnv{Clear: true}).environ(); env == nil || len(env) != 0 {
+		t.Errorf("expected empty non-nil slice, got %#v", env)
+	}
+}
+
+func TestRunShellEnv_ClearRunsWithOnlyGivenVar(t *testing.T) {
+	// Runs with only env.Vars (plus bash's own PWD/SHLVL/_) when env.Clear is set
+	t.Setenv("ARTOO_TEST_SECRET", "leak")
+	stdout, _, err := RunShellEnv(t.Context(), "env", ShellEnv{Vars: map[string]string{"FOO": "bar"}, Clear: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		name, _, _ := strings.Cut(line, "=")
+		switch name {
+		case "PWD", "SHLVL", "_": // set by bash itself
+		default:
+			names = append(names, name)
+		}
+	}
+	if len(names) != 1 || names[0] != "FOO" || !strings.Contains(stdout, "FOO=bar") {
+		t.Errorf("expected only FOO in environment, got %v\n%s", names, stdout)
+	}
+}
+
+func TestRunShellEnv_TimeoutWrapsErrShellTimeout(t *testing.T) {
+	// Wraps ErrShellTimeout when the deadline, not the caller's cancel, stopped cmd
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := RunShellEnv(ctx, "sleep 5", ShellEnv{}); !errors.Is(err, ErrShellTimeout) {
+		t.Errorf("deadline: got %v, want ErrShellTimeout", err)
+	}
+	ctx, cancel = context.WithCancel(t.Context())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, _, err := RunShellEnv(ctx, "sleep 5", ShellEnv{}); err == nil || errors.Is(err, ErrShellTimeout) {
+		t.Errorf("cancel: got %v, want a non-timeout error", err)
+	}
+	if _, _, err := RunShellEnv(t.Context(), "exit 3", ShellEnv{}); err == nil || errors.Is(err, ErrShellTimeout) || errors.Is(err, ErrSandbox) {
+		t.Errorf("exit 3: got %v, want a plain exit error", err)
+	}
+}
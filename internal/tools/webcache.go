@@ -0,0 +1,162 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultWebCacheTTL is how long a search or fetch result is reused when
+// ARTOO_WEB_CACHE_TTL is unset.
+const defaultWebCacheTTL = time.Hour
+
+// WebCache is an on-disk cache of search and fetch results keyed on the
+// normalised query or URL. Research tasks repeat the same web calls across
+// subtasks and replan rounds; answering those from disk avoids the rate limits
+// (HTTP 429/451) that repeated live calls run into.
+type WebCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// webCacheEntry is one cached result as stored on disk.
+type webCacheEntry struct {
+	Kind   string    `json:"kind"`
+	Key    string    `json:"key"`
+	Stored time.Time `json:"stored"`
+	Result string    `json:"result"`
+}
+
+// NewWebCache returns a cache storing entries under dir that are reused for ttl.
+func NewWebCache(dir string, ttl time.Duration) *WebCache {
+	return &WebCache{dir: dir, ttl: ttl}
+}
+
+// DefaultWebCache serves Search and FetchURL. nil (the default) disables
+// caching; main installs one from ARTOO_WEB_CACHE_TTL / ARTOO_WEB_CACHE_DIR.
+var DefaultWebCache *WebCache
+
+// DefaultWebCacheDir returns where the web cache lives: $ARTOO_WEB_CACHE_DIR,
+// else ~/.cache/agsh/web_cache.
+func DefaultWebCacheDir() string {
+	if env := os.Getenv("ARTOO_WEB_CACHE_DIR"); env != "" {
+		return ExpandHome(env)
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".cache", "agsh", "web_cache")
+}
+
+// ParseWebCacheTTL parses an ARTOO_WEB_CACHE_TTL value: a Go duration such as
+// "30m" or "24h". "0" or "off" turns the cache off.
+//
+// Expectations:
+//   - Returns defaultWebCacheTTL for an empty or blank value
+//   - Returns 0 for "0" and "off" (case-insensitive)
+//   - Returns an error for a value that is not a duration, or a negative one
+func ParseWebCacheTTL(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "":
+		return defaultWebCacheTTL, nil
+	case "0", "off":
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("tools: web cache TTL %q: want a duration such as 30m, or off", s)
+	}
+	return d, nil
+}
+
+// do returns the cached result for kind/key when one younger than the TTL
+// exists; otherwise it calls live and stores a successful result that keep
+// accepts (a nil keep accepts every result).
+//
+// Expectations:
+//   - Calls live on every call when c is nil or its TTL is 0
+//   - Returns the stored result without calling live within the TTL
+//   - Calls live again once the stored entry is older than the TTL
+//   - Never stores a result whose live call returned an error
+//   - Never stores a result keep rejects
+//   - A cache directory that cannot be read or written only costs the cache, never the call
+func (c *WebCache) do(kind, key string, keep func(result string) bool, live func() (string, error)) (string, error) {
+	if c == nil || c.ttl <= 0 {
+		return live()
+	}
+	path := c.path(kind, key)
+	if data, err := os.ReadFile(path); err == nil {
+		var e webCacheEntry
+		if json.Unmarshal(data, &e) == nil && e.Kind == kind && e.Key == key && time.Since(e.Stored) < c.ttl {
+			slog.Debug("[tools] web cache hit", "kind", kind, "key", key, "age", time.Since(e.Stored).Round(time.Second))
+			return e.Result, nil
+		}
+	}
+	result, err := live()
+	if err != nil || (keep != nil && !keep(result)) {
+		return result, err
+	}
+	if err := c.store(path, webCacheEntry{Kind: kind, Key: key, Stored: time.Now().UTC(), Result: result}); err != nil {
+		slog.Warn("[tools] web cache write failed", "kind", kind, "error", err)
+	}
+	return result, nil
+}
+
+// path names the entry file for kind/key: a hash, so any query or URL is a safe file name.
+func (c *WebCache) path(kind, key string) string {
+	sum := sha256.Sum256([]byte(kind + "\x00" + key))
+	return filepath.Join(c.dir, kind+"-"+hex.EncodeToString(sum[:16])+".json")
+}
+
+// store writes e to path via a temp file and rename, so a concurrent reader
+// never sees a half-written entry.
+func (c *WebCache) store(path string, e webCacheEntry) error {
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.dir, ".entry-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// searchCacheKey normalises a query so trivially different spellings share an
+// entry: lower-cased, with runs of whitespace collapsed. The backend is part of
+// the key, so switching from DuckDuckGo to Serper never serves the other's results.
+func searchCacheKey(backend, query string) string {
+	return strings.ToLower(backend) + ":" + strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// fetchCacheKey normalises a URL: scheme and host lower-cased, fragment dropped.
+// A URL that does not parse is used trimmed as-is.
+func fetchCacheKey(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment, u.RawFragment = "", ""
+	return u.String()
+}
@@ -0,0 +1,174 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useWebCache installs a WebCache in a temp dir as DefaultWebCache for the test.
+func useWebCache(t *testing.T, ttl time.Duration) {
+	t.Helper()
+	prev := DefaultWebCache
+	DefaultWebCache = NewWebCache(t.TempDir(), ttl)
+	t.Cleanup(func() { DefaultWebCache = prev })
+}
+
+// stubSearch replaces the live backend with one that counts its calls and
+// answers with the query, or with err when it is non-nil.
+func stubSearch(t *testing.T, err error) *atomic.Int32 {
+	t.Helper()
+	var calls atomic.Int32
+	prev := liveSearch
+	liveSearch = func(_ context.Context, query string) (string, error) {
+		calls.Add(1)
+		if err != nil {
+			return "", err
+		}
+		return "results for " + query, nil
+	}
+	t.Cleanup(func() { liveSearch = prev })
+	return &calls
+}
+
+func TestSearch_SecondIdenticalQueryHitsCache(t *testing.T) {
+	// Returns the stored result without calling live within the TTL; the key ignores case and spacing
+	useWebCache(t, time.Hour)
+	calls := stubSearch(t, nil)
+	first, err := Search(t.Context(), "golang release notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Search(t.Context(), "  Golang   release NOTES ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 1 || second != first {
+		t.Errorf("backend called %d times, second = %q; want 1 call and %q", calls.Load(), second, first)
+	}
+	if _, err := Search(t.Context(), "golang release dates"); err != nil || calls.Load() != 2 {
+		t.Errorf("a different query should reach the backend (calls %d, err %v)", calls.Load(), err)
+	}
+}
+
+func TestSearch_NoCacheOrZeroTTLAlwaysCallsBackend(t *testing.T) {
+	// Calls live on every call when c is nil or its TTL is 0
+	calls := stubSearch(t, nil)
+	prev := DefaultWebCache
+	t.Cleanup(func() { DefaultWebCache = prev })
+	for _, c := range []*WebCache{nil, NewWebCache(t.TempDir(), 0)} {
+		DefaultWebCache = c
+		calls.Store(0)
+		for range 2 {
+			if _, err := Search(t.Context(), "weather beijing"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if calls.Load() != 2 {
+			t.Errorf("cache %v: backend called %d times, want 2", c, calls.Load())
+		}
+	}
+}
+
+func TestSearch_ExpiredEntryAndErrorsAreNotServed(t *testing.T) {
+	// Calls live again once the entry is older than the TTL; never stores a failed call
+	useWebCache(t, time.Nanosecond)
+	calls := stubSearch(t, nil)
+	for range 2 {
+		if _, err := Search(t.Context(), "weather beijing"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expired entry: backend called %d times, want 2", calls.Load())
+	}
+
+	useWebCache(t, time.Hour)
+	failing := stubSearch(t, errors.New("search: HTTP 429"))
+	for range 2 {
+		if _, err := Search(t.Context(), "weather beijing"); err == nil {
+			t.Fatal("expected the backend error")
+		}
+	}
+	if failing.Load() != 2 {
+		t.Errorf("failed call was cached: backend called %d times, want 2", failing.Load())
+	}
+}
+
+func TestSearch_CacheIsPerBackend(t *testing.T) {
+	// Answers a repeated query from DefaultWebCache within its TTL, per backend
+	useWebCache(t, time.Hour)
+	calls := stubSearch(t, nil)
+	t.Setenv(serperAPIKeyEnv, "")
+	if _, err := Search(t.Context(), "weather beijing"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(serperAPIKeyEnv, "test-key")
+	for range 2 {
+		if _, err := Search(t.Context(), "weather beijing"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Errorf("backend called %d times, want once per backend", calls.Load())
+	}
+}
+
+func TestSearch_NoResultsAreNotCached(t *testing.T) {
+	// Never caches a "no results" answer, which is often a transient block
+	useWebCache(t, time.Hour)
+	var calls atomic.Int32
+	prev := liveSearch
+	liveSearch = func(_ context.Context, query string) (string, error) {
+		calls.Add(1)
+		return formatSearchResult(query, nil), nil
+	}
+	t.Cleanup(func() { liveSearch = prev })
+	for range 2 {
+		if _, err := Search(t.Context(), "weather beijing"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Errorf("empty result was cached: backend called %d times, want 2", calls.Load())
+	}
+}
+
+func TestFetchURL_SecondFetchHitsCache(t *testing.T) {
+	// Answers a repeated URL from DefaultWebCache within its TTL; the fragment is not part of the key
+	useWebCache(t, time.Hour)
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, "page %d", hits.Add(1))
+	}))
+	defer srv.Close()
+	first, err := FetchURL(t.Context(), srv.URL+"/doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := FetchURL(t.Context(), srv.URL+"/doc#intro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hits.Load() != 1 || first != "page 1" || second != first {
+		t.Errorf("server hit %d times, got %q then %q", hits.Load(), first, second)
+	}
+}
+
+func TestParseWebCacheTTL(t *testing.T) {
+	for in, want := range map[string]time.Duration{"": defaultWebCacheTTL, " ": defaultWebCacheTTL, "0": 0, "OFF": 0, "30m": 30 * time.Minute} {
+		if got, err := ParseWebCacheTTL(in); err != nil || got != want {
+			t.Errorf("ParseWebCacheTTL(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"soon", "-1h", "10"} {
+		if _, err := ParseWebCacheTTL(bad); err == nil {
+			t.Errorf("ParseWebCacheTTL(%q) should fail", bad)
+		}
+	}
+}
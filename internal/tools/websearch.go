@@ -44,6 +44,17 @@ func SearchAvailable() bool {
 	return true
 }
 
+// SearchBackend names the backend Search will use, for diagnostics.
+//
+// Expectations:
+//   - Returns "Serper" when SERPER_API_KEY is non-empty, "DuckDuckGo" otherwise
+func SearchBackend() string {
+	if os.Getenv(serperAPIKeyEnv) != "" {
+		return "Serper"
+	}
+	return "DuckDuckGo"
+}
+
 // Search queries the web. Uses Serper.dev API when SERPER_API_KEY is set;
 // falls back to DuckDuckGo HTML scraping otherwise.
 //
@@ -53,7 +64,24 @@ func SearchAvailable() bool {
 //   - Returns formatted results on success
 //   - Returns a "no results" message when no results are found
 //   - Returns error when the HTTP request fails
+//   - Answers a repeated query from DefaultWebCache within its TTL, per backend
+//   - Never caches a "no results" answer, which is often a transient block
 func Search(ctx context.Context, query string) (string, error) {
+	return DefaultWebCache.do("search", searchCacheKey(SearchBackend(), query), hasSearchResults, func() (string, error) {
+		return liveSearch(ctx, query)
+	})
+}
+
+// noSearchResults starts the answer formatSearchResult gives when a query found nothing.
+const noSearchResults = "No results found for: "
+
+// hasSearchResults reports whether a search answer lists any results.
+func hasSearchResults(result string) bool {
+	return !strings.HasPrefix(result, noSearchResults)
+}
+
+// liveSearch sends query to the configured backend; a var so tests can stub it.
+var liveSearch = func(ctx context.Context, query string) (string, error) {
 	if os.Getenv(serperAPIKeyEnv) != "" {
 		return searchSerper(ctx, query)
 	}
@@ -239,7 +267,7 @@ type searchPage struct {
 //   - Caps output at searchMaxResults results
 func formatSearchResult(query string, pages []searchPage) string {
 	if len(pages) == 0 {
-		return fmt.Sprintf("No results found for: %q", query)
+		return fmt.Sprintf(noSearchResults+"%q", query)
 	}
 
 	var sb strings.Builder
@@ -2,6 +2,9 @@ package types
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"time"
 )
 
@@ -41,8 +44,20 @@ const (
 	MsgAuditReport      MessageType = "AuditReport"    // R6 → User: generated report
 	MsgPlanDirective    MessageType = "PlanDirective"  // R7 → R2: gradient-directed planning instruction
 	MsgOutcomeSummary   MessageType = "OutcomeSummary" // R4b → R7: all subtasks matched; GGS delivers final result
+	MsgPlanDiff         MessageType = "PlanDiff"       // R2 → User: how a replan changed the subtask set
+	MsgTaskProgress     MessageType = "TaskProgress"   // runtime → User: subtasks completed so far
 )
 
+// TaskMessageTypes are the message types of the task pipeline: every type but
+// R6's AuditQuery and AuditReport, which come on a timer or on demand whether a
+// task is running or not. Consumers that follow a task tap only these.
+var TaskMessageTypes = []MessageType{
+	MsgTaskSpec, MsgSubTask, MsgDispatchManifest, MsgExecutionResult, MsgCorrectionSignal,
+	MsgSubTaskOutcome, MsgReplanRequest, MsgMemoryWrite, MsgMemoryRead, MsgMemoryResponse,
+	MsgMegram, MsgMemoryRecall, MsgFinalResult, MsgPlanDirective, MsgOutcomeSummary,
+	MsgPlanDiff, MsgTaskProgress,
+}
+
 // Message is the envelope for all inter-role communication on the bus
 type Message struct {
 	ID        string      `json:"id"`
@@ -60,6 +75,15 @@ type TaskSpec struct {
 	SuccessCriteria []string    `json:"success_criteria"`
 	Constraints     Constraints `json:"constraints"`
 	RawInput        string      `json:"raw_input"`
+	// Assumptions lists interpretations R1 chose instead of asking the user
+	// (set when clarification is disabled and the input was ambiguous).
+	Assumptions []string `json:"assumptions,omitempty"`
+	// Language is the BCP 47 tag of the language the user wrote in ("zh", "ja", ...),
+	// detected by R1 so downstream roles answer in it. Empty when unknown.
+	Language string `json:"language,omitempty"`
+	// Tags categorise the task for /history and /find: the user's --tag tags plus
+	// tags R1 derives from the intent ("research", "files", ...).
+	Tags []string `json:"tags,omitempty"`
 }
 
 type Constraints struct {
@@ -76,6 +100,20 @@ type SubTask struct {
 	Context         string   `json:"context"`
 	Deadline        *string  `json:"deadline"`
 	Sequence        int      `json:"sequence"`
+	// Priority orders subtasks within one sequence group when the dispatcher can
+	// only start some of them at once: higher values start first. Default 0 (equal).
+	Priority int `json:"priority,omitempty"`
+	// MaxRetries, when set, replaces R4a's per-class retry budgets for this subtask
+	// (e.g. more attempts for a flaky network search). R4a clamps it to 0–3; 0 fails
+	// on the first retry verdict; nil keeps the defaults.
+	MaxRetries *int `json:"max_retries,omitempty"`
+	// Env sets (or overrides) environment variables for this subtask's shell commands.
+	// EnvClear starts from an empty environment instead of inheriting the process's.
+	// Both default to full inheritance.
+	Env      map[string]string `json:"env,omitempty"`
+	EnvClear bool              `json:"env_clear,omitempty"`
+	// Language is copied from the TaskSpec by R2 so R3 writes output in the user's language.
+	Language string `json:"language,omitempty"`
 }
 
 // DispatchManifest is sent by R2 to R4b so it knows expected sub-task count
@@ -85,6 +123,15 @@ type DispatchManifest struct {
 	TaskSpec     *TaskSpec `json:"task_spec,omitempty"`
 	DispatchedAt string    `json:"dispatched_at"`
 	TaskCriteria []string  `json:"task_criteria"` // task-level success criteria written by R2; R4b validates the merged output against these
+	// Steps groups SubTaskIDs by sequence number, ascending; the subtasks in one
+	// step run in parallel. Used by the UI to show the plan's shape.
+	Steps []ManifestStep `json:"steps,omitempty"`
+}
+
+// ManifestStep is one sequence group of a dispatched plan.
+type ManifestStep struct {
+	Sequence   int      `json:"sequence"`
+	SubTaskIDs []string `json:"subtask_ids"`
 }
 
 // ExecutionResult is produced by R3 Executor and consumed by R4a Agent-Validator
@@ -94,6 +141,28 @@ type ExecutionResult struct {
 	Output      any      `json:"output"`
 	Uncertainty *string  `json:"uncertainty"`
 	ToolCalls   []string `json:"tool_calls"`
+	Artifacts   []string `json:"artifacts,omitempty"` // absolute paths written by write_file in this attempt
+	// NeedReplan is R3's reason when it could not tell how to carry out the subtask
+	// as specified and asked for a new plan instead of guessing (Status "failed").
+	NeedReplan string `json:"need_replan,omitempty"`
+	// EvidenceHash is HashEvidence() of this attempt, set by R3 before publishing;
+	// R4a compares it with the previous attempt's to spot a retry that changed nothing.
+	EvidenceHash string `json:"evidence_hash,omitempty"`
+}
+
+// HashEvidence returns the hex SHA-256 of r's tool calls and output — the
+// evidence R4a scores. Status, uncertainty and artifacts are not part of it.
+//
+// Expectations:
+//   - Equal tool calls and output give equal hashes, whatever the other fields
+//   - A change to any tool call, their order, or the output changes the hash
+func (r ExecutionResult) HashEvidence() string {
+	data, _ := json.Marshal(struct {
+		ToolCalls []string `json:"tool_calls"`
+		Output    any      `json:"output"`
+	}{r.ToolCalls, r.Output})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
 
 // CorrectionSignal is produced by R4a Agent-Validator and consumed by R3 Executor
@@ -128,13 +197,18 @@ type SubTaskOutcome struct {
 	SubTaskID        string               `json:"subtask_id"`
 	ParentTaskID     string               `json:"parent_task_id"`
 	Intent           string               `json:"intent"`
-	SuccessCriteria  []string             `json:"success_criteria"` // copied from SubTask so R4b can check them
-	Status           string               `json:"status"`           // "matched" | "failed"
+	Sequence         int                  `json:"sequence,omitempty"` // copied from SubTask so GGS can merge outputs in plan order
+	SuccessCriteria  []string             `json:"success_criteria"`   // copied from SubTask so R4b can check them
+	Status           string               `json:"status"`             // "matched" | "failed"
 	Output           any                  `json:"output"`
 	FailureReason    *string              `json:"failure_reason"`
 	GapTrajectory    []GapTrajectoryPoint `json:"gap_trajectory"`
 	CriteriaVerdicts []CriteriaVerdict    `json:"criteria_verdicts,omitempty"` // per-criterion verdicts from final attempt
 	ToolCalls        []string             `json:"tool_calls,omitempty"`        // tool names used in final execution attempt; for GGS blocked_tools
+	Artifacts        []string             `json:"artifacts,omitempty"`         // absolute paths of files written across all attempts
+	// Confidence is R4a's score for the final attempt, in [0, 1]. GGS counts a
+	// low-confidence match as partial distance in D. 0 means unset.
+	Confidence float64 `json:"confidence,omitempty"`
 }
 
 // ReplanRequest is produced by R4b and consumed by R7 (GGS). GGS owns gradient computation.
@@ -147,6 +221,17 @@ type ReplanRequest struct {
 	ElapsedMs       int64            `json:"elapsed_ms"`     // wall-clock ms since task started; for Ω computation
 	Outcomes        []SubTaskOutcome `json:"outcomes"`       // full outcome data for GGS gradient computation
 	Recommendation  string           `json:"recommendation"` // "replan" | "abandon"
+	Language        string           `json:"language,omitempty"` // TaskSpec.Language; GGS localizes summaries
+	// Round is R4b's 1-indexed replan round — the single per-task round counter.
+	// R4b's abandon safety net and GGS's Ω both read it; GGS echoes it in
+	// PlanDirective.Round and drops requests for rounds it has already seen.
+	// 0 means unset (GGS falls back to its own count).
+	Round int `json:"round,omitempty"`
+	// PriorPlans holds a fingerprint of each round's plan, oldest first; the last
+	// is the plan that produced Outcomes. A fingerprint is the sorted, space-joined
+	// keywords of the plan's subtask intents, so rewording changes it only a little.
+	// GGS escalates when consecutive fingerprints are effectively identical.
+	PriorPlans []string `json:"prior_plans,omitempty"`
 }
 
 // LossBreakdown carries the GGS loss components for a replan round.
@@ -171,6 +256,7 @@ type PlanDirective struct {
 	BudgetPressure  float64       `json:"budget_pressure"`  // Ω value for display
 	GradL           float64       `json:"grad_l"`           // ∇L = L_t − L_{t-1}; 0 on first round
 	Rationale       string        `json:"rationale"`        // human-readable explanation; logged by Auditor
+	Round           int           `json:"round,omitempty"`  // ReplanRequest.Round echoed; R2 drops rounds it has already planned
 }
 
 // RoleCost records LLM usage for one role in a completed task.
@@ -182,7 +268,7 @@ type RoleCost struct {
 }
 
 // TaskCost summarises all resource consumption for a task.
-// Written into episodic MemoryEntry so R2 can calibrate planning heuristics
+// Written into episodic MemoryEntry and terminal success Megrams so R2 can calibrate planning heuristics
 // (e.g. prefer simpler plans when prior attempts consumed many tokens/tool calls).
 type TaskCost struct {
 	ByRole        []RoleCost `json:"by_role"`
@@ -279,6 +365,9 @@ type OutcomeSummary struct {
 	MergedOutput any              `json:"merged_output"` // combined user-facing result
 	ElapsedMs    int64            `json:"elapsed_ms"`    // wall-clock ms since task started; for Ω logging
 	Outcomes     []SubTaskOutcome `json:"outcomes"`      // full outcomes; GGS records final D/L
+	// Conflicts lists contradictions R4b found between matched subtask outputs;
+	// they are also flagged in Summary.
+	Conflicts []string `json:"conflicts,omitempty"`
 }
 
 // FinalResult carries the merged result to the user.
@@ -288,12 +377,29 @@ type FinalResult struct {
 	TaskID        string        `json:"task_id"`
 	Summary       string        `json:"summary"`
 	Output        any           `json:"output"`
+	Artifacts     []string      `json:"artifacts,omitempty"` // absolute paths of files written during the task
 	Loss          LossBreakdown `json:"loss"`
 	GradL         float64       `json:"grad_l,omitempty"`
 	Replans       int           `json:"replans,omitempty"`
-	Directive     string        `json:"directive"`      // "accept" | "success" | "abandon"
-	PrevDirective string        `json:"prev_directive"` // macro-state from previous round; "init" on first round
-}
+	Directive     string        `json:"directive"`               // "accept" | "success" | "abandon" | "cancelled"
+	PrevDirective string        `json:"prev_directive"`          // macro-state from previous round; "init" on first round
+	CancelReason  string        `json:"cancel_reason,omitempty"` // Cancel* constant; set only when Directive is "cancelled"
+	// Assumptions carries TaskSpec.Assumptions — the interpretations R1 chose
+	// instead of asking — so the user can catch a misread request in the result.
+	Assumptions []string `json:"assumptions,omitempty"`
+	// Conflicts carries OutcomeSummary.Conflicts: subtask outputs that contradict each other.
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// Reasons carried by a cancelled FinalResult (Directive "cancelled"), so scripts
+// and logs can tell why a task stopped early rather than being abandoned by GGS.
+const (
+	CancelUser     = "user"     // Ctrl+C while the task ran
+	CancelIdle     = "idle"     // no bus activity within ARTOO_TASK_IDLE_TIMEOUT
+	CancelWallTime = "walltime" // the task ran past ARTOO_TASK_WALLTIME
+	CancelSignal   = "signal"   // SIGTERM
+	CancelCost     = "cost"     // the user declined the plan's estimated cost before dispatch
+)
 
 // ---------------------------------------------------------------------------
 // MKCT Memory Engine types (R5 v0.8)
@@ -314,6 +420,12 @@ type Megram struct {
 	F              float64 `json:"f"`                          // initial stimulus magnitude [0, 1]
 	Sigma          float64 `json:"sigma"`                      // valence direction [-1.0, +1.0]
 	K              float64 `json:"k"`                          // time decay rate: 0.0 | 0.05 | 0.2 | 0.5
+	// IdempotencyKey, when set, lets R5 drop a replayed write of the same event
+	// (e.g. a terminal Megram for a FinalResult delivered twice).
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Cost is what the task spent, set on accept/success terminal Megrams so R2
+	// can prefer the cheapest of competing prior successes.
+	Cost *TaskCost `json:"cost,omitempty"`
 }
 
 // SOPRecord is a C-level memory entry (best practice or constraint) returned by QueryC.
@@ -333,6 +445,35 @@ type Potentials struct {
 	Action    string  `json:"action"`    // "Ignore" | "Exploit" | "Avoid" | "Caution"
 }
 
+// CalibrationBundle is everything R2 calibrates a plan against for one
+// (space, entity) pair — the results of QueryC, QueryMK, and QueryRecent
+// gathered in a single pass over memory.
+type CalibrationBundle struct {
+	SOPs       []SOPRecord `json:"sops"`       // C-level best practices and constraints
+	Potentials Potentials  `json:"potentials"` // live dual-channel potentials
+	Recent     []Megram    `json:"recent"`     // newest M/K-level entries, newest-first
+}
+
+// IntentStats counts how tasks of one intent space ended across all runs. R5
+// keeps it up to date from terminal Megrams; R2 consults it before planning.
+type IntentStats struct {
+	Space     string `json:"space"`
+	Accepts   int    `json:"accepts"`  // accept and success terminal states
+	Abandons  int    `json:"abandons"` // abandon terminal states
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+// Runs returns the number of terminal outcomes recorded.
+func (s IntentStats) Runs() int { return s.Accepts + s.Abandons }
+
+// SuccessRate returns Accepts / Runs, or 0 when nothing has been recorded.
+func (s IntentStats) SuccessRate() float64 {
+	if s.Runs() == 0 {
+		return 0
+	}
+	return float64(s.Accepts) / float64(s.Runs())
+}
+
 // MemoryService is the R5 interface used by GGS (writes) and Planner (reads).
 // GGS is the sole writer; Planner queries structured data ([]SOPRecord, Potentials).
 // All writes are fire-and-forget; queries are synchronous blocking calls.
@@ -350,6 +491,9 @@ type MemoryService interface {
 	QueryRecent(ctx context.Context, space, entity string, n int) ([]Megram, error)
 	// RecordNegativeFeedback appends a negative-σ Megram to cancel stale positive potentials.
 	RecordNegativeFeedback(ctx context.Context, ruleID, content string)
+	// Clear deletes every Megram and all index/level/recall keys, returning the
+	// number of Megrams removed.
+	Clear() (int, error)
 	// Close drains the pending write queue. Called by Run() on context cancellation.
 	Close()
 }
@@ -391,6 +535,33 @@ type MemorySummary struct {
 	Groups      []MegRamGroup  `json:"groups,omitempty"` // populated by SummaryVerbose() only
 }
 
+// PlanDiff is the payload for MsgPlanDiff, published by R2 after each replan.
+// It compares the new subtask set with the previous round's, pairing subtasks
+// by intent similarity, so the UI and task log show what the directive changed.
+type PlanDiff struct {
+	TaskID    string       `json:"task_id"`
+	Directive string       `json:"directive"`         // GGS directive that prompted the replan
+	Added     []string     `json:"added,omitempty"`   // intents with no counterpart in the previous plan
+	Removed   []string     `json:"removed,omitempty"` // previous intents with no counterpart in the new plan
+	Changed   []PlanChange `json:"changed,omitempty"` // paired subtasks whose intent or criteria differ
+	Unchanged int          `json:"unchanged"`         // paired subtasks carried over as-is
+}
+
+// TaskProgress is the payload for MsgTaskProgress, published by the subtask
+// dispatcher each time a subtask of the current plan completes.
+type TaskProgress struct {
+	TaskID            string `json:"task_id"`
+	CompletedSubtasks int    `json:"completed_subtasks"`
+	TotalSubtasks     int    `json:"total_subtasks"`   // from the DispatchManifest
+	CurrentSequence   int    `json:"current_sequence"` // sequence group the completed subtask belonged to
+}
+
+// PlanChange is one paired subtask whose definition changed between rounds.
+type PlanChange struct {
+	From string `json:"from"` // previous intent
+	To   string `json:"to"`   // new intent
+}
+
 // MemoryRecall is the payload for MsgMemoryRecall published by R2 after querying MKCT.
 // Provides pipeline display visibility into what memory returned.
 type MemoryRecall struct {
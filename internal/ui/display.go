@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -12,62 +14,57 @@ import (
 	"github.com/haricheung/agentic-shell/internal/types"
 )
 
-// ANSI codes
-const (
-	ansiReset   = "\033[0m"
-	ansiBold    = "\033[1m"
-	ansiDim     = "\033[2m"
-	ansiCyan    = "\033[36m"
-	ansiYellow  = "\033[33m"
-	ansiGreen   = "\033[32m"
-	ansiRed     = "\033[31m"
-	ansiMagenta = "\033[35m"
-	ansiBlue    = "\033[34m"
-)
+// msgColor returns the flow-line colour for a message type under theme t.
+// Unlisted types render dim.
+func msgColor(t *Theme, mt types.MessageType) string {
+	switch mt {
+	case types.MsgTaskSpec, types.MsgMemoryRecall:
+		return t.Cyan
+	case types.MsgSubTask:
+		return t.Blue
+	case types.MsgDispatchManifest, types.MsgTaskProgress:
+		return t.Dim + t.Blue
+	case types.MsgExecutionResult, types.MsgPlanDirective, types.MsgPlanDiff:
+		return t.Yellow
+	case types.MsgCorrectionSignal, types.MsgReplanRequest:
+		return t.Red
+	case types.MsgSubTaskOutcome:
+		return t.Magenta
+	case types.MsgOutcomeSummary, types.MsgFinalResult:
+		return t.Green
+	}
+	return t.Dim
+}
 
-var roleEmoji = map[types.Role]string{
-	types.RolePerceiver: "🧠",
-	types.RolePlanner:   "📐",
-	types.RoleExecutor:  "⚙️ ",
-	types.RoleAgentVal:  "🔍",
-	types.RoleMetaVal:   "🔮",
-	types.RoleMemory:    "💾",
-	types.RoleAuditor:   "📡",
-	types.RoleGGS:       "📈",
-	types.RoleUser:      "🧑",
+// statusLabel is a spinner label: the icon of the role doing the work plus text.
+type statusLabel struct {
+	role types.Role
+	text string
 }
 
-var msgColor = map[types.MessageType]string{
-	types.MsgTaskSpec:         ansiCyan,
-	types.MsgSubTask:          ansiBlue,
-	types.MsgDispatchManifest: ansiDim + ansiBlue,
-	types.MsgExecutionResult:  ansiYellow,
-	types.MsgCorrectionSignal: ansiRed,
-	types.MsgSubTaskOutcome:   ansiMagenta,
-	types.MsgReplanRequest:    ansiRed,
-	types.MsgPlanDirective:    ansiYellow,
-	types.MsgOutcomeSummary:   ansiGreen,
-	types.MsgMemoryRecall:     ansiCyan,
-	types.MsgMemoryWrite:      ansiDim,
-	types.MsgMemoryRead:       ansiDim,
-	types.MsgMemoryResponse:   ansiDim,
-	types.MsgFinalResult:      ansiGreen,
+var msgStatus = map[types.MessageType]statusLabel{
+	types.MsgTaskSpec:         {types.RolePerceiver, "perceiving..."},
+	types.MsgSubTask:          {types.RolePlanner, "scheduling subtasks..."},
+	types.MsgDispatchManifest: {types.RolePlanner, "dispatching..."},
+	types.MsgExecutionResult:  {types.RoleAgentVal, "evaluating result..."},
+	types.MsgCorrectionSignal: {types.RoleExecutor, "retrying..."},
+	types.MsgSubTaskOutcome:   {types.RoleMetaVal, "evaluating outcomes..."},
+	types.MsgReplanRequest:    {types.RoleGGS, "computing gradient..."},
+	types.MsgPlanDirective:    {types.RolePlanner, "replanning with directive..."},
+	types.MsgPlanDiff:         {types.RolePlanner, "dispatching revised plan..."},
+	types.MsgOutcomeSummary:   {types.RoleGGS, "recording final loss..."},
+	types.MsgMemoryRecall:     {types.RolePlanner, "planning with memory..."},
+	types.MsgMemoryWrite:      {types.RoleMemory, "saving memory..."},
+	types.MsgMemoryRead:       {types.RoleMemory, "recalling..."},
+	types.MsgMemoryResponse:   {types.RolePlanner, "planning..."},
 }
 
-var msgStatus = map[types.MessageType]string{
-	types.MsgTaskSpec:         "🧠 perceiving...",
-	types.MsgSubTask:          "📐 scheduling subtasks...",
-	types.MsgDispatchManifest: "📐 dispatching...",
-	types.MsgExecutionResult:  "🔍 evaluating result...",
-	types.MsgCorrectionSignal: "⚙️  retrying...",
-	types.MsgSubTaskOutcome:   "🔮 evaluating outcomes...",
-	types.MsgReplanRequest:    "📈 computing gradient...",
-	types.MsgPlanDirective:    "📐 replanning with directive...",
-	types.MsgOutcomeSummary:   "📈 recording final loss...",
-	types.MsgMemoryRecall:     "📐 planning with memory...",
-	types.MsgMemoryWrite:      "💾 saving memory...",
-	types.MsgMemoryRead:       "💾 recalling...",
-	types.MsgMemoryResponse:   "📐 planning...",
+// roleStatus prefixes text with the role's icon under theme t, when it has one.
+func roleStatus(t *Theme, r types.Role, text string) string {
+	if ic := t.RoleIcon(r); ic != "" {
+		return ic + " " + text
+	}
+	return text
 }
 
 // dynamicStatus returns a spinner label for msg, enriched with payload detail
@@ -78,18 +75,20 @@ var msgStatus = map[types.MessageType]string{
 //   - MsgPlanDirective with empty Rationale: falls through to static msgStatus label
 //   - MsgReplanRequest: returns "📊 N/M subtasks failed — computing gradient..." when outcomes present
 func dynamicStatus(msg types.Message) string {
+	t := Active()
+	dash := t.Icon("dash")
 	switch msg.Type {
 	case types.MsgPlanDirective:
 		var pd types.PlanDirective
 		if remarshal(msg.Payload, &pd) == nil && pd.Rationale != "" {
-			return "📐 replanning — " + clipCols(pd.Rationale, 42)
+			return roleStatus(t, types.RolePlanner, "replanning "+dash+" "+clipCols(pd.Rationale, 42))
 		}
 	case types.MsgReplanRequest:
 		var r types.ReplanRequest
 		if remarshal(msg.Payload, &r) == nil && len(r.Outcomes) > 0 {
 			failed := len(r.FailedSubTasks)
 			total := len(r.Outcomes)
-			return fmt.Sprintf("📊 %d/%d subtasks failed — computing gradient...", failed, total)
+			return fmt.Sprintf("%s%d/%d subtasks failed %s computing gradient...", t.Prefix("cost"), failed, total, dash)
 		}
 	case types.MsgCorrectionSignal:
 		var c types.CorrectionSignal
@@ -99,21 +98,26 @@ func dynamicStatus(msg types.Message) string {
 			// clipCols (not clip) is required: CJK chars are 2 cols each, so a
 			// rune-count clip would allow ~76 visual cols and trigger line-wrap,
 			// breaking the \r\033[K overwrite.
-			return fmt.Sprintf("⚙️  retry %d — %s", c.AttemptNumber, clipCols(c.WhatToDo, 38))
+			return roleStatus(t, types.RoleExecutor, fmt.Sprintf("retry %d %s %s", c.AttemptNumber, dash, clipCols(c.WhatToDo, 38)))
+		}
+	case types.MsgTaskProgress:
+		var tp types.TaskProgress
+		if remarshal(msg.Payload, &tp) == nil && tp.TotalSubtasks > 0 {
+			return roleStatus(t, types.RoleExecutor, fmt.Sprintf("%d/%d subtasks done %s working...", tp.CompletedSubtasks, tp.TotalSubtasks, dash))
 		}
 	case types.MsgSubTaskOutcome:
 		var o types.SubTaskOutcome
 		if remarshal(msg.Payload, &o) == nil {
 			switch o.Status {
 			case "matched":
-				return "🔮 subtask matched — merging..."
+				return roleStatus(t, types.RoleMetaVal, "subtask matched "+dash+" merging...")
 			case "failed":
-				return "🔮 subtask failed — assessing..."
+				return roleStatus(t, types.RoleMetaVal, "subtask failed "+dash+" assessing...")
 			}
 		}
 	}
-	if s := msgStatus[msg.Type]; s != "" {
-		return s
+	if l, ok := msgStatus[msg.Type]; ok {
+		return roleStatus(t, l.role, l.text)
 	}
 	return ""
 }
@@ -124,6 +128,7 @@ var spinRunes = []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
 // It reads from a bus tap channel and animates a live pipeline view.
 type Display struct {
 	tap        <-chan types.Message
+	out        io.Writer // terminal output; os.Stdout outside tests
 	abortCh    chan struct{}
 	resumeCh   chan struct{}
 	mu         sync.Mutex
@@ -135,9 +140,10 @@ type Display struct {
 	taskDone   chan struct{}  // closed by endTask; nil between tasks
 }
 
-// New creates a Display reading from tap.
+// New creates a Display reading from tap, which should carry only
+// types.TaskMessageTypes (see bus.NewFilteredTap): any message opens a pipeline box.
 func New(tap <-chan types.Message) *Display {
-	return &Display{tap: tap, abortCh: make(chan struct{}, 1), resumeCh: make(chan struct{}, 1)}
+	return &Display{tap: tap, out: os.Stdout, abortCh: make(chan struct{}, 1), resumeCh: make(chan struct{}, 1)}
 }
 
 // Abort signals the display to immediately close the current pipeline box
@@ -170,12 +176,12 @@ func (d *Display) Run(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			fmt.Print("\r\033[K")
+			d.clearLine()
 			return
 
 		case <-d.abortCh:
 			if d.inTask {
-				fmt.Print("\r\033[K")
+				d.clearLine()
 				d.endTask(false)
 			}
 			d.mu.Lock()
@@ -191,9 +197,8 @@ func (d *Display) Run(ctx context.Context) {
 			if !ok {
 				return
 			}
-			// Meta-system messages (audit query/report) are not task pipeline events;
-			// skip them so they don't open a pipeline box or stall the spinner.
-			if msg.Type == types.MsgAuditQuery || msg.Type == types.MsgAuditReport {
+			// The last subtask's progress can trail the FinalResult; it never opens a box.
+			if msg.Type == types.MsgTaskProgress && !d.inTask {
 				continue
 			}
 			if !d.inTask {
@@ -207,22 +212,23 @@ func (d *Display) Run(ctx context.Context) {
 				d.startTask()
 			}
 			// Clear spinner line before printing a new flow line.
-			fmt.Print("\r\033[K")
+			d.clearLine()
 			d.printFlow(msg)
 			d.setStatus(dynamicStatus(msg))
 			if msg.Type == types.MsgFinalResult {
 				// Detect abandon path via Directive field (v0.8).
-				// Directive=="abandon" → task failed; "accept" or "success" → task succeeded.
+				// Directive=="abandon" or "cancelled" → task did not succeed; "accept" or "success" → succeeded.
 				success := true
 				var fr types.FinalResult
-				if remarshal(msg.Payload, &fr) == nil && fr.Directive == "abandon" {
+				if remarshal(msg.Payload, &fr) == nil && (fr.Directive == "abandon" || fr.Directive == "cancelled") {
 					success = false
 				}
 				d.endTask(success)
 			}
 
 		case <-ticker.C:
-			if !d.inTask {
+			t := Active()
+			if !d.inTask || !t.Animate {
 				continue
 			}
 			frame := spinRunes[d.spinIdx%len(spinRunes)]
@@ -232,7 +238,7 @@ func (d *Display) Run(ctx context.Context) {
 			d.mu.Unlock()
 			// \r\033[K: return to line start then erase to EOL — prevents leftover
 			// chars from longer previous statuses and keeps overwrite in-place.
-			fmt.Printf("\r\033[K%s%s%s %s", ansiCyan, string(frame), ansiReset, status)
+			fmt.Fprintf(d.out, "\r\033[K%s%s%s %s", t.Cyan, string(frame), t.Reset, status)
 		}
 	}
 }
@@ -260,17 +266,26 @@ func (d *Display) startTask() {
 	d.started = time.Now()
 	d.inTask = true
 	d.setStatus("initializing...")
-	fmt.Printf("\n%s┌─── 🤖 artoo pipeline %s%s\n", ansiDim, strings.Repeat("─", 40), ansiReset)
+	t := Active()
+	fmt.Fprintf(d.out, "\n%s%s %sartoo pipeline %s%s\n", t.Dim, t.Icon("boxTop"), t.Prefix("robot"), t.Rule(40), t.Reset)
+}
+
+// clearLine erases the spinner line in place. No-op for non-animated themes,
+// which never draw a spinner and must not emit escape sequences.
+func (d *Display) clearLine() {
+	fmt.Fprint(d.out, Active().ClearLine())
 }
 
 func (d *Display) endTask(success bool) {
 	d.inTask = false
 	elapsed := time.Since(d.started).Round(time.Millisecond)
-	icon := "✅"
+	t := Active()
+	icon := t.Icon("ok")
 	if !success {
-		icon = "❌"
+		icon = t.Icon("fail")
 	}
-	fmt.Printf("\r\033[K%s└─── %s  %v %s%s\n", ansiDim, icon, elapsed, strings.Repeat("─", 35), ansiReset)
+	d.clearLine()
+	fmt.Fprintf(d.out, "%s%s %s  %v %s%s\n", t.Dim, t.Icon("boxBottom"), icon, elapsed, t.Rule(35), t.Reset)
 	// Signal any waiter (REPL goroutine) that the pipeline box is closed.
 	d.mu.Lock()
 	ch := d.taskDone
@@ -288,39 +303,38 @@ func (d *Display) setStatus(s string) {
 }
 
 func (d *Display) printFlow(msg types.Message) {
-	from := roleLabel(msg.From)
-	to := roleLabel(msg.To)
+	t := Active()
+	from := roleLabel(t, msg.From)
+	to := roleLabel(t, msg.To)
 
 	label := string(msg.Type)
 	if det := msgDetail(msg); det != "" {
 		label += ": " + det
 	}
 
-	color := msgColor[msg.Type]
-	if color == "" {
-		color = ansiDim
-	}
+	color := msgColor(t, msg.Type)
 
 	// Infrastructure messages (memory, auditor) are rendered dim.
 	isDim := msg.Type == types.MsgMemoryRead ||
 		msg.Type == types.MsgMemoryWrite ||
 		msg.Type == types.MsgMemoryResponse
 
+	lead, arrow := t.Icon("flowLead"), t.Icon("flow")
 	var line string
 	if isDim {
-		line = fmt.Sprintf("%s  %s ──[%s]──► %s%s", ansiDim, from, label, to, ansiReset)
+		line = fmt.Sprintf("%s  %s %s[%s]%s %s%s", t.Dim, from, lead, label, arrow, to, t.Reset)
 	} else {
-		line = fmt.Sprintf("  %s ──[%s%s%s]──► %s", from, color, label, ansiReset, to)
+		line = fmt.Sprintf("  %s %s[%s%s%s]%s %s", from, lead, color, label, t.Reset, arrow, to)
 	}
-	fmt.Println(line)
+	fmt.Fprintln(d.out, line)
 }
 
-func roleLabel(r types.Role) string {
-	emoji, ok := roleEmoji[r]
-	if !ok {
-		emoji = "•"
+func roleLabel(t *Theme, r types.Role) string {
+	icon := t.RoleIcon(r)
+	if icon == "" {
+		icon = t.Icon("bullet")
 	}
-	return emoji + " " + string(r)
+	return icon + " " + string(r)
 }
 
 // msgDetail returns a short inline detail string for a pipeline flow line.
@@ -375,13 +389,13 @@ func msgDetail(msg types.Message) string {
 	case types.MsgCorrectionSignal:
 		var c types.CorrectionSignal
 		if remarshal(msg.Payload, &c) == nil {
-			return fmt.Sprintf("attempt %d — %s", c.AttemptNumber, clip(c.WhatWasWrong, 40))
+			return fmt.Sprintf("attempt %d %s %s", c.AttemptNumber, Active().Icon("dash"), clip(c.WhatWasWrong, 40))
 		}
 	case types.MsgMemoryRecall:
 		var mr types.MemoryRecall
 		if remarshal(msg.Payload, &mr) == nil {
-			detail := fmt.Sprintf("[%s / %s]  sops=%d recent=%d  att=%.2f dec=%+.2f → %s",
-				mr.Space, mr.Entity, mr.SOPs, mr.Recent, mr.Attention, mr.Decision, mr.Action)
+			detail := fmt.Sprintf("[%s / %s]  sops=%d recent=%d  att=%.2f dec=%+.2f %s %s",
+				mr.Space, mr.Entity, mr.SOPs, mr.Recent, mr.Attention, mr.Decision, Active().Icon("arrow"), mr.Action)
 			if mr.Constraints != "" {
 				// Show first constraint line as preview
 				lines := strings.SplitN(mr.Constraints, "\n", 3)
@@ -395,10 +409,14 @@ func msgDetail(msg types.Message) string {
 		var m types.DispatchManifest
 		if remarshal(msg.Payload, &m) == nil {
 			n := len(m.SubTaskIDs)
+			detail := fmt.Sprintf("%d subtasks", n)
 			if n == 1 {
-				return "1 subtask"
+				detail = "1 subtask"
+			}
+			if steps := PlanSteps(m); steps != "" {
+				detail += ": " + steps
 			}
-			return fmt.Sprintf("%d subtasks", n)
+			return detail
 		}
 	case types.MsgReplanRequest:
 		var r types.ReplanRequest
@@ -415,22 +433,40 @@ func msgDetail(msg types.Message) string {
 		var pd types.PlanDirective
 		if remarshal(msg.Payload, &pd) == nil {
 			// Arrow encodes ∇L sign (urgency modulator in v0.8).
-			// After the colored arrow, ansiYellow restores the MsgPlanDirective message color.
+			// After the colored arrow, t.Yellow restores the MsgPlanDirective message color.
+			t := Active()
 			const gradEps = 0.1
-			arrowFmt := "→" // no signal
+			arrowFmt := t.Icon("arrow") // no signal
 			if pd.GradL < -gradEps {
-				arrowFmt = ansiGreen + "↑" + ansiReset + ansiYellow // improving
+				arrowFmt = t.Green + t.Icon("up") + t.Reset + t.Yellow // improving
 			} else if pd.GradL > gradEps {
-				arrowFmt = ansiRed + "↓" + ansiReset + ansiYellow // worsening
+				arrowFmt = t.Red + t.Icon("down") + t.Reset + t.Yellow // worsening
 			}
 			// State-transfer label: prev→directive (e.g. "init→change_path")
 			transition := pd.Directive
 			if pd.PrevDirective != "" {
-				transition = pd.PrevDirective + "→" + pd.Directive
+				transition = pd.PrevDirective + t.Icon("arrow") + pd.Directive
 			}
-			return fmt.Sprintf("%s %s  D=%.2f P=%.2f ∇L=%+.2f Ω=%.0f%%",
+			return fmt.Sprintf("%s %s  D=%.2f P=%.2f %s=%+.2f %s=%.0f%%",
 				arrowFmt, transition,
-				pd.Loss.D, pd.Loss.P, pd.GradL, pd.BudgetPressure*100)
+				pd.Loss.D, pd.Loss.P, t.Icon("grad"), pd.GradL, t.Icon("omega"), pd.BudgetPressure*100)
+		}
+	case types.MsgPlanDiff:
+		var pd types.PlanDiff
+		if remarshal(msg.Payload, &pd) == nil {
+			detail := fmt.Sprintf("%s: +%d -%d ~%d =%d", pd.Directive, len(pd.Added), len(pd.Removed), len(pd.Changed), pd.Unchanged)
+			switch {
+			case len(pd.Changed) > 0:
+				detail += " | " + clip(pd.Changed[0].To, 40)
+			case len(pd.Added) > 0:
+				detail += " | +" + clip(pd.Added[0], 40)
+			}
+			return detail
+		}
+	case types.MsgTaskProgress:
+		var tp types.TaskProgress
+		if remarshal(msg.Payload, &tp) == nil && tp.TotalSubtasks > 0 {
+			return fmt.Sprintf("%d/%d subtasks done (step %d)", tp.CompletedSubtasks, tp.TotalSubtasks, tp.CurrentSequence)
 		}
 	case types.MsgOutcomeSummary:
 		var os types.OutcomeSummary
@@ -443,7 +479,8 @@ func msgDetail(msg types.Message) string {
 			// Only show GGS metrics when they're populated (non-zero).
 			// Planner abandon sends FinalResult with zero Loss/GradL.
 			if fr.Loss.D > 0 || fr.Loss.Omega > 0 || fr.GradL != 0 {
-				detail := fmt.Sprintf("D=%.2f ∇L=%+.2f Ω=%.0f%%", fr.Loss.D, fr.GradL, fr.Loss.Omega*100)
+				t := Active()
+				detail := fmt.Sprintf("D=%.2f %s=%+.2f %s=%.0f%%", fr.Loss.D, t.Icon("grad"), fr.GradL, t.Icon("omega"), fr.Loss.Omega*100)
 				if fr.Replans > 0 {
 					detail += fmt.Sprintf(" | %d replan(s)", fr.Replans)
 				}
@@ -454,6 +491,24 @@ func msgDetail(msg types.Message) string {
 	return ""
 }
 
+// PlanSteps renders a manifest's sequence groups, e.g. "Step 1 (2 parallel), Step 2".
+//
+// Expectations:
+//   - Returns "" when the manifest carries no steps
+//   - Numbers steps from 1 in manifest order, whatever their sequence numbers
+//   - Marks a step with more than one subtask as "(N parallel)"
+func PlanSteps(m types.DispatchManifest) string {
+	parts := make([]string, 0, len(m.Steps))
+	for i, s := range m.Steps {
+		part := fmt.Sprintf("Step %d", i+1)
+		if len(s.SubTaskIDs) > 1 {
+			part += fmt.Sprintf(" (%d parallel)", len(s.SubTaskIDs))
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
 // ClipQuestion shortens a raw user input for display in the Result header.
 // It uses only the first line of multi-line inputs, then truncates at the
 // first sentence-ending punctuation (after at least 15 runes to skip
@@ -463,7 +518,7 @@ func msgDetail(msg types.Message) string {
 //   - Returns s unchanged when s is 80 runes or fewer and has no newline
 //   - Multi-line input: returns only the text before the first newline (then applies limit)
 //   - Truncates at first sentence-end punctuation (. ? ! 。 ？ ！) found after rune 15
-//   - Falls back to clip at 80 runes with "…" when no sentence end found within 80 runes
+//   - Falls back to clip at 80 runes with the theme's ellipsis when no sentence end found within 80 runes
 func ClipQuestion(s string) string {
 	if i := strings.IndexByte(s, '\n'); i >= 0 {
 		s = s[:i]
@@ -477,17 +532,17 @@ func ClipQuestion(s string) string {
 			return string(runes[:i+1])
 		}
 	}
-	return string(runes[:80]) + "…"
+	return string(runes[:80]) + Active().Icon("ellipsis")
 }
 
-// clip truncates s to at most n runes, appending "…" if trimmed.
+// clip truncates s to at most n runes, appending the theme's ellipsis if trimmed.
 // Use clipCols when the string will appear on a terminal line that must not wrap.
 func clip(s string, n int) string {
 	runes := []rune(s)
 	if len(runes) <= n {
 		return s
 	}
-	return string(runes[:n]) + "…"
+	return string(runes[:n]) + Active().Icon("ellipsis")
 }
 
 // runeWidth returns the number of terminal columns consumed by rune r.
@@ -532,6 +587,3 @@ func remarshal(src, dst any) error {
 	}
 	return json.Unmarshal(b, dst)
 }
-
-// Unused — satisfies Go's "declared and not used" check for ansiBold.
-var _ = ansiBold
@@ -246,6 +246,45 @@ func TestDynamicStatus_PlanDirective_EmptyRationaleUsesStaticLabel(t *testing.T)
 	}
 }
 
+// --- msgDetail: MsgDispatchManifest ---
+
+func TestMsgDetail_DispatchManifest_ShowsSteps(t *testing.T) {
+	// Marks a step with more than one subtask as "(N parallel)"
+	// Numbers steps from 1 in manifest order, whatever their sequence numbers
+	m := types.DispatchManifest{
+		SubTaskIDs: []string{"a", "b", "c"},
+		Steps: []types.ManifestStep{
+			{Sequence: 1, SubTaskIDs: []string{"a", "b"}},
+			{Sequence: 3, SubTaskIDs: []string{"c"}},
+		},
+	}
+	got := msgDetail(makeMsg(types.MsgDispatchManifest, m))
+	if want := "3 subtasks: Step 1 (2 parallel), Step 2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMsgDetail_DispatchManifest_NoStepsShowsCount(t *testing.T) {
+	// Returns "" when the manifest carries no steps, leaving only the count
+	m := types.DispatchManifest{SubTaskIDs: []string{"a"}}
+	if got := msgDetail(makeMsg(types.MsgDispatchManifest, m)); got != "1 subtask" {
+		t.Errorf("got %q, want %q", got, "1 subtask")
+	}
+}
+
+// --- msgDetail / dynamicStatus: MsgTaskProgress ---
+
+func TestMsgDetail_TaskProgress_ShowsFraction(t *testing.T) {
+	// MsgTaskProgress: returns "<done>/<total> subtasks done (step N)"
+	tp := types.TaskProgress{TaskID: "t1", CompletedSubtasks: 3, TotalSubtasks: 5, CurrentSequence: 2}
+	if got, want := msgDetail(makeMsg(types.MsgTaskProgress, tp)), "3/5 subtasks done (step 2)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := dynamicStatus(makeMsg(types.MsgTaskProgress, tp)); !strings.Contains(got, "3/5 subtasks done") {
+		t.Errorf("expected the fraction in dynamicStatus, got %q", got)
+	}
+}
+
 // --- msgDetail: unknown type ---
 
 func TestMsgDetail_UnknownType(t *testing.T) {
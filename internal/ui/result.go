@@ -0,0 +1,86 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+// RenderResult writes the final task result block to w using the active theme:
+// a "Result" heading, the clipped user question, the summary, the output, an
+// "Assumed:" line for each interpretation R1 chose without asking, and a
+// "Created:" line for each file the task wrote.
+//
+// Expectations:
+//   - Writes the summary on its own line
+//   - String output is written with real newlines and skipped when identical to the summary
+//   - Structured output (object/array) is pretty-printed as indented JSON
+//   - Lists each of result.Assumptions as "Assumed: <text>" after the output
+//   - Lists each path in result.Artifacts as "Created: <path>" after the output
+//   - Under PlainTheme the rendered block contains no ANSI escape sequences
+func RenderResult(w io.Writer, result types.FinalResult, rawInput string) {
+	t := Active()
+	fmt.Fprintf(w, "\n%s%s%sResult%s\n", t.Bold, t.Green, t.Prefix("robot"), t.Reset)
+	if rawInput != "" {
+		fmt.Fprintf(w, "%s  %s %s%s\n", t.Dim, t.Icon("quote"), ClipQuestion(rawInput), t.Reset)
+	}
+	fmt.Fprintln(w, result.Summary)
+	renderOutput(w, result)
+	for _, a := range result.Assumptions {
+		fmt.Fprintf(w, "%sAssumed:%s %s\n", t.Yellow, t.Reset, a)
+	}
+	for _, path := range result.Artifacts {
+		fmt.Fprintf(w, "%sCreated:%s %s\n", t.Dim, t.Reset, path)
+	}
+}
+
+// RenderQuiet writes only the answer, for --quiet: result.Output as plain text
+// with no header, summary, colors, or Assumed/Created lines. The summary stands
+// in when there is no output, so a script never reads an empty answer.
+//
+// Expectations:
+//   - String output is written as-is with a trailing newline
+//   - Structured output is written as indented JSON
+//   - Writes the summary when Output is nil or an empty string
+//   - Writes nothing when both are empty
+func RenderQuiet(w io.Writer, result types.FinalResult) {
+	if s, isString := result.Output.(string); result.Output == nil || isString && s == "" {
+		if result.Summary != "" {
+			fmt.Fprintln(w, result.Summary)
+		}
+		return
+	}
+	renderOutput(w, types.FinalResult{Output: result.Output})
+}
+
+// renderOutput writes result.Output, if any, below the summary.
+func renderOutput(w io.Writer, result types.FinalResult) {
+	if result.Output == nil {
+		return
+	}
+	// If output is a plain string, print it directly so \n renders as newlines
+	// rather than being JSON-encoded to visible "\n" escape sequences.
+	// After bus/JSON round-trips, string values surface as either Go string
+	// or interface{} containing string — handle both.
+	b, err := json.Marshal(result.Output)
+	if err != nil {
+		fmt.Fprintln(w, result.Output)
+		return
+	}
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		// It's a string — print with real newlines, not JSON escapes.
+		if s != result.Summary {
+			fmt.Fprintln(w, s)
+		}
+		return
+	}
+	// Structured output (object/array) — pretty-print as indented JSON.
+	if pretty, err := json.MarshalIndent(result.Output, "", "  "); err == nil {
+		fmt.Fprintln(w, string(pretty))
+	} else {
+		fmt.Fprintln(w, result.Output)
+	}
+}
@@ -0,0 +1,246 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+// Theme maps semantic styles and glyphs to their terminal rendering.
+// Every colour code and emoji printed by the Display and the REPL printers
+// goes through the active Theme, so switching themes changes all output at once.
+//
+// Empty colour fields mean "no styling"; Animate controls whether the spinner
+// and its \r\033[K line rewrites are emitted at all.
+type Theme struct {
+	Name    string
+	Animate bool // spinner + in-place line rewrites; requires a real terminal
+
+	Reset, Bold, Dim                        string
+	Red, Green, Yellow, Blue, Magenta, Cyan string
+
+	icons     map[string]string
+	roleIcons map[types.Role]string
+}
+
+// ScifiTheme is the default theme: ANSI colours, emoji, and box-drawing glyphs.
+var ScifiTheme = &Theme{
+	Name:    "scifi",
+	Animate: true,
+	Reset:   "\033[0m",
+	Bold:    "\033[1m",
+	Dim:     "\033[2m",
+	Red:     "\033[31m",
+	Green:   "\033[32m",
+	Yellow:  "\033[33m",
+	Blue:    "\033[34m",
+	Magenta: "\033[35m",
+	Cyan:    "\033[36m",
+	icons: map[string]string{
+		"robot":     "🤖",
+		"user":      "🧑",
+		"cost":      "📊",
+		"decisions": "📐",
+		"memory":    "📦",
+		"audit":     "📡",
+		"ok":        "✅",
+		"fail":      "❌",
+		"warn":      "⚠️ ",
+		"check":     "✓",
+		"cross":     "✗",
+		"star":      "★",
+		"rule":      "─",
+		"arrow":     "→",
+		"flow":      "──►",
+		"flowLead":  "──",
+		"up":        "↑",
+		"down":      "↓",
+		"boxTop":    "┌───",
+		"boxBottom": "└───",
+		"bullet":    "•",
+		"dash":      "—",
+		"quote":     "›",
+		"ellipsis":  "…",
+		"stop":      "⏹",
+		"grad":      "∇L",
+		"omega":     "Ω",
+		"sigma":     "σ",
+		"sep":       "·",
+	},
+	roleIcons: map[types.Role]string{
+		types.RolePerceiver: "🧠",
+		types.RolePlanner:   "📐",
+		types.RoleExecutor:  "⚙️ ",
+		types.RoleAgentVal:  "🔍",
+		types.RoleMetaVal:   "🔮",
+		types.RoleMemory:    "💾",
+		types.RoleAuditor:   "📡",
+		types.RoleGGS:       "📈",
+		types.RoleUser:      "🧑",
+	},
+}
+
+// PlainTheme emits no ANSI escape sequences and only ASCII glyphs.
+// Used for pipes, log capture, and terminals that render emoji as garbage.
+var PlainTheme = &Theme{
+	Name: "plain",
+	icons: map[string]string{
+		"robot":     "",
+		"user":      "",
+		"cost":      "",
+		"decisions": "",
+		"memory":    "",
+		"audit":     "",
+		"ok":        "[ok]",
+		"fail":      "[FAIL]",
+		"warn":      "!",
+		"check":     "+",
+		"cross":     "x",
+		"star":      "*",
+		"rule":      "-",
+		"arrow":     "->",
+		"flow":      "-->",
+		"flowLead":  "--",
+		"up":        "^",
+		"down":      "v",
+		"boxTop":    "+---",
+		"boxBottom": "+---",
+		"bullet":    "*",
+		"dash":      "-",
+		"quote":     ">",
+		"ellipsis":  "...",
+		"stop":      "",
+		"grad":      "dL",
+		"omega":     "Omega",
+		"sigma":     "sigma",
+		"sep":       "|",
+	},
+	roleIcons: map[types.Role]string{},
+}
+
+var (
+	themeMu sync.RWMutex
+	active  = ScifiTheme
+)
+
+// ThemeByName returns the built-in theme with the given name.
+//
+// Expectations:
+//   - Returns ScifiTheme for "scifi" and PlainTheme for "plain" (case-insensitive)
+//   - Returns an error naming the valid themes for any other value
+func ThemeByName(name string) (*Theme, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "scifi":
+		return ScifiTheme, nil
+	case "plain":
+		return PlainTheme, nil
+	}
+	return nil, fmt.Errorf("unknown theme %q (want scifi or plain)", name)
+}
+
+// DefaultTheme picks a theme when none was requested, from the environment
+// and whether stdout is a terminal (see ThemeFor).
+func DefaultTheme() *Theme {
+	return ThemeFor(os.Getenv, IsTerminal(os.Stdout))
+}
+
+// ThemeFor picks the theme for a terminal described by getenv and tty, so
+// limited terminals get readable output instead of escape codes and mojibake.
+//
+// Expectations:
+//   - Returns PlainTheme when tty is false (pipes, files, CI logs)
+//   - Returns PlainTheme when TERM is unset or "dumb"
+//   - Returns ScifiTheme without colour (NoColor) when NO_COLOR is set to any
+//     non-empty value, per no-color.org
+//   - Returns ScifiTheme otherwise
+func ThemeFor(getenv func(string) string, tty bool) *Theme {
+	if !tty {
+		return PlainTheme
+	}
+	if term := strings.TrimSpace(getenv("TERM")); term == "" || term == "dumb" {
+		return PlainTheme
+	}
+	if getenv("NO_COLOR") != "" {
+		return ScifiTheme.NoColor()
+	}
+	return ScifiTheme
+}
+
+// NoColor returns a copy of t that keeps its glyphs but emits no escape
+// sequences: colour fields are empty and Animate is off, since the spinner's
+// line rewrites are escape sequences too.
+//
+// Expectations:
+//   - Returns a theme named "<name>-nocolor" with every colour field empty
+//   - Keeps t's icons and role icons
+//   - Leaves t unchanged
+func (t *Theme) NoColor() *Theme {
+	return &Theme{Name: t.Name + "-nocolor", icons: t.icons, roleIcons: t.roleIcons}
+}
+
+// IsTerminal reports whether f is attached to a character device (a TTY).
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// SetTheme makes t the active theme for all subsequent rendering.
+func SetTheme(t *Theme) {
+	themeMu.Lock()
+	active = t
+	themeMu.Unlock()
+}
+
+// Active returns the theme currently used for rendering.
+func Active() *Theme {
+	themeMu.RLock()
+	defer themeMu.RUnlock()
+	return active
+}
+
+// Icon returns the glyph registered under name, or "" when the theme has none.
+func (t *Theme) Icon(name string) string {
+	return t.icons[name]
+}
+
+// Prefix returns the named icon followed by a space, or "" when the icon is empty —
+// so plain-theme headings don't start with a stray space.
+//
+// Expectations:
+//   - Returns "<icon> " when the icon is non-empty
+//   - Returns "" when the theme maps the icon to ""
+func (t *Theme) Prefix(name string) string {
+	if ic := t.icons[name]; ic != "" {
+		return ic + " "
+	}
+	return ""
+}
+
+// RoleIcon returns the glyph for role r, or "" when the theme has none.
+func (t *Theme) RoleIcon(r types.Role) string {
+	return t.roleIcons[r]
+}
+
+// ClearLine returns the carriage return + erase-line sequence that wipes a
+// spinner line, or "" when the theme does not animate.
+//
+// Expectations:
+//   - Returns "\r\033[K" when Animate is set
+//   - Returns "" otherwise, so callers can print it unconditionally
+func (t *Theme) ClearLine() string {
+	if t.Animate {
+		return "\r\033[K"
+	}
+	return ""
+}
+
+// Rule returns the horizontal-rule glyph repeated n times.
+func (t *Theme) Rule(n int) string {
+	return strings.Repeat(t.icons["rule"], n)
+}
@@ -0,0 +1,241 @@
+package ui
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/haricheung/agentic-shell/internal/types"
+)
+
+// useTheme activates th for the duration of the test.
+func useTheme(t *testing.T, th *Theme) {
+	t.Helper()
+	prev := Active()
+	SetTheme(th)
+	t.Cleanup(func() { SetTheme(prev) })
+}
+
+// renderSample drives a Display and RenderResult through one short task
+// (TaskSpec → PlanDirective → FinalResult) and returns everything written.
+func renderSample() string {
+	var buf bytes.Buffer
+	d := New(nil)
+	d.out = &buf
+	d.startTask()
+	d.printFlow(types.Message{From: types.RolePerceiver, To: types.RolePlanner, Type: types.MsgTaskSpec,
+		Payload: types.TaskSpec{Intent: "list large videos"}})
+	d.printFlow(types.Message{From: types.RoleGGS, To: types.RolePlanner, Type: types.MsgPlanDirective,
+		Payload: types.PlanDirective{PrevDirective: "init", Directive: "refine", GradL: -0.4,
+			Loss: types.LossBreakdown{D: 0.5, P: 0.3}, BudgetPressure: 0.2}})
+	fr := types.FinalResult{TaskID: "t1", Summary: "Found 3 videos", Output: map[string]any{"count": 3},
+		Loss: types.LossBreakdown{D: 0.1, Omega: 0.3}, Replans: 1}
+	d.printFlow(types.Message{From: types.RoleGGS, To: types.RoleUser, Type: types.MsgFinalResult, Payload: fr})
+	d.endTask(true)
+	RenderResult(&buf, fr, "find my largest videos in Downloads")
+	return buf.String()
+}
+
+// ── ThemeByName ──────────────────────────────────────────────────────────────
+
+func TestThemeByName_ReturnsBuiltins(t *testing.T) {
+	// Returns ScifiTheme for "scifi" and PlainTheme for "plain" (case-insensitive)
+	if th, err := ThemeByName("SciFi"); err != nil || th != ScifiTheme {
+		t.Errorf("ThemeByName(SciFi) = %v, %v", th, err)
+	}
+	if th, err := ThemeByName("plain"); err != nil || th != PlainTheme {
+		t.Errorf("ThemeByName(plain) = %v, %v", th, err)
+	}
+}
+
+func TestThemeByName_UnknownReturnsError(t *testing.T) {
+	// Returns an error naming the valid themes for any other value
+	_, err := ThemeByName("neon")
+	if err == nil || !strings.Contains(err.Error(), "plain") {
+		t.Errorf("expected error listing valid themes, got %v", err)
+	}
+}
+
+// ── Prefix ───────────────────────────────────────────────────────────────────
+
+func TestPrefix_NonEmptyIconGetsTrailingSpace(t *testing.T) {
+	// Returns "<icon> " when the icon is non-empty
+	if got := ScifiTheme.Prefix("robot"); got != "🤖 " {
+		t.Errorf("expected %q, got %q", "🤖 ", got)
+	}
+}
+
+func TestPrefix_EmptyIconReturnsEmpty(t *testing.T) {
+	// Returns "" when the theme maps the icon to ""
+	if got := PlainTheme.Prefix("robot"); got != "" {
+		t.Errorf("expected empty prefix, got %q", got)
+	}
+}
+
+// ── RenderResult / Display under themes ──────────────────────────────────────
+
+func TestRenderResult_PlainThemeHasNoEscapeSequences(t *testing.T) {
+	// Under PlainTheme the rendered block contains no ANSI escape sequences or emoji/box glyphs
+	useTheme(t, PlainTheme)
+	out := renderSample()
+	if strings.Contains(out, "\033") {
+		t.Errorf("plain theme output contains escape sequences:\n%q", out)
+	}
+	for _, glyph := range []string{"🤖", "✅", "──►", "┌", "└", "↑", "→", "›"} {
+		if strings.Contains(out, glyph) {
+			t.Errorf("plain theme output contains scifi glyph %q:\n%s", glyph, out)
+		}
+	}
+	if !strings.Contains(out, "Found 3 videos") || !strings.Contains(out, `"count": 3`) {
+		t.Errorf("expected summary and structured output in render, got:\n%s", out)
+	}
+}
+
+func TestRenderResult_ScifiThemeUsesColour(t *testing.T) {
+	// The scifi theme keeps the original ANSI + emoji rendering
+	useTheme(t, ScifiTheme)
+	out := renderSample()
+	if !strings.Contains(out, "\033[") {
+		t.Error("expected ANSI escapes under scifi theme")
+	}
+	if !strings.Contains(out, "🤖") {
+		t.Error("expected emoji under scifi theme")
+	}
+}
+
+func TestRenderResult_StringOutputEqualToSummarySkipped(t *testing.T) {
+	// String output is written with real newlines and skipped when identical to the summary
+	useTheme(t, PlainTheme)
+	var buf bytes.Buffer
+	RenderResult(&buf, types.FinalResult{Summary: "done", Output: "done"}, "")
+	if strings.Count(buf.String(), "done") != 1 {
+		t.Errorf("expected summary printed once, got:\n%s", buf.String())
+	}
+	buf.Reset()
+	RenderResult(&buf, types.FinalResult{Summary: "done", Output: "line1\nline2"}, "")
+	if !strings.Contains(buf.String(), "line1\nline2") {
+		t.Errorf("expected real newlines in string output, got %q", buf.String())
+	}
+}
+
+func TestRenderResult_ListsArtifacts(t *testing.T) {
+	// Lists each path in result.Artifacts as "Created: <path>" after the output
+	useTheme(t, PlainTheme)
+	var buf bytes.Buffer
+	RenderResult(&buf, types.FinalResult{Summary: "done", Output: "done",
+		Artifacts: []string{"/tmp/a.txt", "/tmp/b.txt"}}, "")
+	out := buf.String()
+	if !strings.Contains(out, "Created: /tmp/a.txt\n") || !strings.Contains(out, "Created: /tmp/b.txt\n") {
+		t.Errorf("expected a Created line per artifact, got:\n%s", out)
+	}
+}
+
+func TestRenderResult_ListsAssumptions(t *testing.T) {
+	// Lists each of result.Assumptions as "Assumed: <text>" after the output
+	useTheme(t, PlainTheme)
+	var buf bytes.Buffer
+	RenderResult(&buf, types.FinalResult{Summary: "done", Output: "notes.md deleted",
+		Assumptions: []string{"'the file' means the most recently modified .md"}}, "")
+	out := buf.String()
+	if !strings.Contains(out, "notes.md deleted\nAssumed: 'the file' means the most recently modified .md\n") {
+		t.Errorf("expected an Assumed line after the output, got:\n%s", out)
+	}
+}
+
+// ── RenderQuiet ──────────────────────────────────────────────────────────────
+
+func TestRenderQuiet_OutputOnly(t *testing.T) {
+	// String output as-is, structured output as indented JSON, the summary only when there is no output
+	cases := []struct {
+		fr   types.FinalResult
+		want string
+	}{
+		{types.FinalResult{Summary: "Done.", Output: "line 1\nline 2", Artifacts: []string{"/tmp/a"}}, "line 1\nline 2\n"},
+		{types.FinalResult{Summary: "Done.", Output: map[string]any{"count": 3}}, "{\n  \"count\": 3\n}\n"},
+		{types.FinalResult{Summary: "Nothing to report.", Output: ""}, "Nothing to report.\n"},
+		{types.FinalResult{}, ""},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		RenderQuiet(&buf, c.fr)
+		if buf.String() != c.want {
+			t.Errorf("RenderQuiet(%+v) = %q, want %q", c.fr, buf.String(), c.want)
+		}
+	}
+}
+
+// ── ThemeFor / NoColor ───────────────────────────────────────────────────────
+
+// envOf returns a getenv over vars.
+func envOf(vars map[string]string) func(string) string {
+	return func(k string) string { return vars[k] }
+}
+
+func TestThemeFor_DetectsLimitedTerminals(t *testing.T) {
+	// Returns PlainTheme when tty is false, or when TERM is unset or "dumb"
+	// Returns ScifiTheme without colour when NO_COLOR is set; ScifiTheme otherwise
+	cases := []struct {
+		env  map[string]string
+		tty  bool
+		want string
+	}{
+		{map[string]string{"TERM": "xterm-256color"}, false, "plain"},
+		{map[string]string{}, true, "plain"},
+		{map[string]string{"TERM": "dumb"}, true, "plain"},
+		{map[string]string{"TERM": "xterm-256color", "NO_COLOR": "1"}, true, "scifi-nocolor"},
+		{map[string]string{"TERM": "xterm-256color", "NO_COLOR": ""}, true, "scifi"},
+		{map[string]string{"TERM": "xterm-256color"}, true, "scifi"},
+	}
+	for _, c := range cases {
+		if got := ThemeFor(envOf(c.env), c.tty); got.Name != c.want {
+			t.Errorf("ThemeFor(%v, tty=%v) = %s, want %s", c.env, c.tty, got.Name, c.want)
+		}
+	}
+}
+
+func TestNoColor_KeepsGlyphsDropsColour(t *testing.T) {
+	// Returns a theme named "<name>-nocolor" with every colour field empty
+	// Keeps t's icons and role icons; leaves t unchanged
+	nc := ScifiTheme.NoColor()
+	if nc.Animate || nc.Reset != "" || nc.Bold != "" || nc.Red != "" || nc.Cyan != "" {
+		t.Errorf("NoColor kept styling: %+v", nc)
+	}
+	if nc.Icon("ok") != "✅" || nc.RoleIcon(types.RolePlanner) != "📐" {
+		t.Error("NoColor dropped the theme's glyphs")
+	}
+	if ScifiTheme.Red == "" || !ScifiTheme.Animate {
+		t.Error("NoColor modified ScifiTheme")
+	}
+}
+
+func TestRenderResult_NoColorHasNoEscapeSequences(t *testing.T) {
+	// With NO_COLOR set the rendered output contains no escape sequences
+	useTheme(t, ThemeFor(envOf(map[string]string{"TERM": "xterm-256color", "NO_COLOR": "1"}), true))
+	out := renderSample()
+	if strings.Contains(out, "\033") {
+		t.Errorf("NO_COLOR output contains escape sequences:\n%q", out)
+	}
+	if !strings.Contains(out, "Found 3 videos") {
+		t.Errorf("expected summary in render, got:\n%s", out)
+	}
+}
+
+func TestRenderResult_PlainThemeIsASCII(t *testing.T) {
+	// Under PlainTheme every rendered character is ASCII
+	useTheme(t, PlainTheme)
+	for i, r := range renderSample() {
+		if r > 127 {
+			t.Fatalf("non-ASCII %q at byte %d of plain output:\n%s", r, i, renderSample())
+		}
+	}
+}
+
+func TestClearLine_OnlyWhenAnimating(t *testing.T) {
+	// Returns "\r\033[K" when Animate is set; "" otherwise
+	if got := ScifiTheme.ClearLine(); got != "\r\033[K" {
+		t.Errorf("ScifiTheme.ClearLine() = %q", got)
+	}
+	if got := PlainTheme.ClearLine(); got != "" {
+		t.Errorf("PlainTheme.ClearLine() = %q, want empty", got)
+	}
+}